@@ -1,27 +1,292 @@
 package commands
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 )
 
 // addCmd represents the add command
 var addCmd = &cobra.Command{
-	Use:   "add",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Args: cobra.MaximumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:   "add <path>",
+	Short: "Add a file or directory to DFS",
+	Long: `Add splits a file into chunks, stores them together with a manifest and
+prints the content hash of the file, preceded by its sha256. The file is
+streamed to the running daemon, which pins it. Use the content hash with
+"dfs get".
+
+With -r, a directory is added with everything in it. Every file is
+printed with its hash, and the hash of the directory comes last; only the
+directory is pinned. Files in it can be fetched by path:
+
+  dfs add -r ./photos
+  dfs get /<hash>/2024/beach.jpg
+
+Symlinks and other special files are skipped.
+
+The chunking strategy defaults to the config and can be chosen per file:
+
+  dfs add --chunker fastcdc --chunk-size 262144 ./notes.txt
+
+The paged profile suits large files written a page at a time, such as
+disk images, SQLite databases and mailboxes: large content defined chunks
+cut only at 4 KiB page boundaries. Adding a new version of such a file
+with --base set to the hash of the last one takes over the chunks that
+didn't change without hashing them again, as long as the daemon indexed
+it; "dfs sync" does so for every changed file:
+
+  dfs add --profile paged ./disk.img
+  dfs add --profile paged --base <hash> ./disk.img
+
+So can compression. With zstd, chunks that compress well are stored
+compressed; ones that look compressed or encrypted already are stored as
+they are. It only changes how this node keeps the chunks, so the file's
+hash is the same either way:
+
+  dfs add --compression zstd ./logs.txt
+
+--encrypt encrypts the file's chunks before they are stored, so peers
+holding copies never see its content. Only nodes with the master key, kept
+in the keystore (see "dfs keys") or else the file encryption.master_key,
+can read it back. Names and sizes in directories stay readable.
+--group encrypts it with the key of a group instead, for every member of
+the group to read (see "dfs group").
+
+On a terminal, a progress bar shows how much the daemon has received and
+stored. --quiet prints only the hash, and --json prints progress and the
+result as JSON lines for scripts.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		filePath := args[0]
 
-		logger.Info("Adding file: " + filePath)
+		report, err := newTransferReport(cmd, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+		if report.verbose() {
+			logger.Info("Adding file", zap.String("path", filePath))
+		}
+
+		params := cfg.Chunking.Params()
+		if profile, _ := cmd.Flags().GetString("profile"); profile != "" {
+			compression := params.Compression
+			if params, err = chunking.Profile(profile); err != nil {
+				return err
+			}
+			params.Compression = compression
+		}
+		if cmd.Flags().Changed("chunker") {
+			params.Strategy, _ = cmd.Flags().GetString("chunker")
+		}
+		if cmd.Flags().Changed("chunk-size") {
+			params.Size, _ = cmd.Flags().GetInt("chunk-size")
+			params.MinSize, params.MaxSize = 0, 0
+		}
+		if cmd.Flags().Changed("compression") {
+			params.Compression, _ = cmd.Flags().GetString("compression")
+		}
+		if err := params.Validate(); err != nil {
+			return err
+		}
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		recursive, _ := cmd.Flags().GetBool("recursive")
+		encrypt, _ := cmd.Flags().GetBool("encrypt")
+		group, _ := cmd.Flags().GetString("group")
+		if info.IsDir() && !recursive {
+			return fmt.Errorf("%s is a directory, use -r to add it", filePath)
+		}
+		base, _ := cmd.Flags().GetString("base")
+		if info.IsDir() && base != "" {
+			return fmt.Errorf("--base only applies to a single file")
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if info.IsDir() {
+			a := &dirAdder{cmd: cmd, client: client, params: &params, encrypt: encrypt, group: group, report: report}
+			c, err := a.add(filePath, "", false)
+			if err != nil {
+				return err
+			}
+			if report.enc != nil {
+				report.emit(transferEvent{Event: "added", Name: filepath.Base(filePath), CID: c, Dir: true})
+				return nil
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), c)
+			return nil
+		}
+
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		sum := sha256.New()
+		req := &api.AddRequest{Name: filepath.Base(filePath), Chunking: &params, Encrypt: encrypt, Group: group, Base: base}
+		progress := report.track(req.Name, info.Size(), false)
+		res, err := client.Add(cmd.Context(), req, io.TeeReader(f, sum), progress)
+		report.clear()
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		switch {
+		case report.enc != nil:
+			report.emit(transferEvent{
+				Event:  "added",
+				Name:   req.Name,
+				CID:    res.CID,
+				Size:   res.Size,
+				Chunks: res.Chunks,
+				SHA256: fmt.Sprintf("%x", sum.Sum(nil)),
+			})
+			return nil
+		case report.quiet:
+			fmt.Fprintln(out, res.CID)
+			return nil
+		}
+		if showStats, _ := cmd.Flags().GetBool("stats"); showStats && res.Stats != nil {
+			printAddStats(out, res)
+		}
+		fmt.Fprintf(out, "sha256 %x\n", sum.Sum(nil))
+		fmt.Fprintln(out, res.CID)
+		return nil
 	},
 }
 
+// dirAdder adds a directory tree bottom up: the files in a directory, then
+// the directory listing them. Only the top directory is pinned.
+type dirAdder struct {
+	cmd     *cobra.Command
+	client  *api.Client
+	params  *chunking.Params
+	encrypt bool
+	group   string
+	report  *transferReport
+}
+
+// add adds the directory at path, shown as rel, and returns its hash.
+func (a *dirAdder) add(path, rel string, nested bool) (string, error) {
+	ctx := a.cmd.Context()
+	out := a.cmd.OutOrStdout()
+
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	req := &api.MakeDirectoryRequest{NoPin: nested}
+	for _, e := range dirEntries {
+		entryPath := filepath.Join(path, e.Name())
+		entryRel := filepath.Join(rel, e.Name())
+
+		var c string
+		switch {
+		case e.IsDir():
+			if c, err = a.add(entryPath, entryRel, true); err != nil {
+				return "", err
+			}
+		case e.Type().IsRegular():
+			if c, err = a.addFile(entryPath, entryRel); err != nil {
+				return "", fmt.Errorf("%s: %w", entryRel, err)
+			}
+			switch {
+			case a.report.enc != nil:
+				a.report.emit(transferEvent{Event: "added", Name: entryRel, CID: c})
+			case !a.report.quiet:
+				fmt.Fprintf(out, "%s  %s\n", c, entryRel)
+			}
+		default:
+			fmt.Fprintf(a.cmd.ErrOrStderr(), "Skipping %s: not a regular file\n", entryRel)
+			continue
+		}
+		req.Entries = append(req.Entries, api.DirectoryEntry{Name: e.Name(), CID: c})
+	}
+
+	res, err := a.client.MakeDirectory(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return res.CID, nil
+}
+
+func (a *dirAdder) addFile(path, rel string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	req := &api.AddRequest{Name: filepath.Base(path), Chunking: a.params, NoPin: true, Encrypt: a.encrypt, Group: a.group}
+	progress := a.report.track(rel, size, false)
+	res, err := a.client.Add(a.cmd.Context(), req, f, progress)
+	a.report.clear()
+	if err != nil {
+		return "", err
+	}
+	return res.CID, nil
+}
+
+// printAddStats summarizes chunking and dedup so chunker settings can be
+// tuned against real data.
+func printAddStats(out io.Writer, res *api.AddResponse) {
+	stats := res.Stats
+
+	var avg int64
+	if res.Chunks > 0 {
+		avg = res.Size / int64(res.Chunks)
+	}
+	fmt.Fprintf(out, "Size:    %s\n", formatBytes(res.Size))
+	fmt.Fprintf(out, "Chunks:  %d (%s)\n", res.Chunks, stats.Strategy)
+	fmt.Fprintf(out, "  min %s, avg %s, max %s\n", formatBytes(stats.MinChunk), formatBytes(avg), formatBytes(stats.MaxChunk))
+	for _, class := range stats.Sizes {
+		fmt.Fprintf(out, "  <= %-10s %d\n", formatBytes(class.UpTo), class.Count)
+	}
+
+	var ratio float64
+	if res.Size > 0 {
+		ratio = float64(stats.DedupBytes) / float64(res.Size)
+	}
+	fmt.Fprintf(out, "Dedup:   %d of %d chunks already stored, %s (%.1f%%)\n",
+		stats.DedupChunks, res.Chunks, formatBytes(stats.DedupBytes), ratio*100)
+	if stats.ReusedChunks > 0 {
+		fmt.Fprintf(out, "  %d unchanged since the base, %s not hashed again\n", stats.ReusedChunks, formatBytes(stats.ReusedBytes))
+	}
+}
+
 func init() {
+	addCmd.Flags().String("chunker", "", "chunking strategy: "+chunking.StrategyFixed+" or "+chunking.StrategyFastCDC)
+	addCmd.Flags().Int("chunk-size", 0, "chunk size in bytes (average size for fastcdc)")
+	addCmd.Flags().String("profile", "", "chunking profile: "+chunking.ProfilePaged)
+	addCmd.Flags().String("base", "", "hash of an earlier version of the file, to hash only what changed")
+	addCmd.Flags().Bool("encrypt", false, "encrypt the content with a key only this user's nodes hold")
+	addCmd.Flags().String("group", "", "encrypt the content with the key of this group, for its members to read")
+	addCmd.Flags().String("compression", "", "store chunks compressed: "+storage.CompressionZstd+" or "+storage.CompressionNone)
+	addCmd.Flags().Bool("stats", false, "print chunk size and dedup statistics")
+	addCmd.Flags().BoolP("recursive", "r", false, "add a directory and everything in it")
+	addTransferFlags(addCmd, "print only the hash, without progress")
+
 	rootCmd.AddCommand(addCmd)
 }
@@ -0,0 +1,195 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/agent"
+	"github.com/Noah-Wilderom/dfs/pkg/keystore"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Keep the keystore unlocked for a while",
+	Long: `Agent keeps the keystore unlocked in a background process, as ssh-agent
+keeps keys, so commands using it don't ask for the passphrase every time.
+The agent holds the key derived from the passphrase, not the passphrase,
+and hands it only to processes of the same user. It forgets the key when
+its time is up or when it is locked.`,
+}
+
+var agentStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Unlock the keystore for a while",
+	Long: `Start asks for the keystore passphrase once and starts an agent that keeps
+the keystore unlocked for --ttl. Commands run meanwhile take the keystore
+from the agent instead of asking for the passphrase.
+
+With --foreground the agent runs until interrupted rather than in the
+background.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := cfg.KeystorePath()
+		socket := agent.SocketPath(path)
+		if status, err := agent.GetStatus(socket); err == nil {
+			return fmt.Errorf("an agent holds %s unlocked until %s, \"dfs agent lock\" stops it",
+				status.Keystore, status.Expires.Format(time.TimeOnly))
+		}
+		ks, err := mustOpenKeystore()
+		if err != nil {
+			return err
+		}
+		key := ks.UnlockKey()
+		defer clear(key)
+
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		if foreground, _ := cmd.Flags().GetBool("foreground"); foreground {
+			return serveAgent(cmd, path, key, ttl, cmd.OutOrStdout())
+		}
+
+		// The agent is this program run again, detached, with the key
+		// written to its stdin; it answers once it listens.
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		childArgs := []string{"agent", "serve", "--ttl", ttl.String()}
+		if config, _ := cmd.Flags().GetString("config"); config != "" {
+			childArgs = append(childArgs, "--config", config)
+		}
+		child := exec.Command(exe, childArgs...)
+		agent.Detach(child)
+		stdin, err := child.StdinPipe()
+		if err != nil {
+			return err
+		}
+		stdout, err := child.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := child.Start(); err != nil {
+			return err
+		}
+		_, err = stdin.Write(key)
+		stdin.Close()
+		if err != nil {
+			return err
+		}
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if msg, ok := strings.CutPrefix(strings.TrimSpace(line), "error: "); ok {
+			child.Wait()
+			return errors.New(msg)
+		}
+		if err != nil {
+			child.Wait()
+			return fmt.Errorf("agent failed to start: %w", err)
+		}
+		pid := child.Process.Pid
+		child.Process.Release()
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Keystore unlocked until %s (agent pid %d)\n",
+			time.Now().Add(ttl).Format(time.TimeOnly), pid)
+		return nil
+	},
+}
+
+// agentServeCmd is the background agent agent start runs, reading the
+// unlock key from stdin.
+var agentServeCmd = &cobra.Command{
+	Use:    "serve",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := io.ReadAll(io.LimitReader(os.Stdin, 1<<10))
+		if err != nil {
+			return err
+		}
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		err = serveAgent(cmd, cfg.KeystorePath(), key, ttl, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "error: %v\n", err)
+		}
+		return err
+	},
+}
+
+// serveAgent runs an agent for the keystore at path until it is locked,
+// its TTL is up or the command is interrupted. Without out, readiness is
+// reported on stdout, which is then closed, for agent start to read.
+func serveAgent(cmd *cobra.Command, path string, key []byte, ttl time.Duration, out io.Writer) error {
+	// Check the key before serving it
+	if _, err := keystore.OpenUnlocked(path, key); err != nil {
+		return err
+	}
+	ln, err := agent.Listen(agent.SocketPath(path))
+	if err != nil {
+		return err
+	}
+	a := agent.New(agent.AgentOpts{Keystore: path, Key: key, TTL: ttl})
+
+	if out != nil {
+		fmt.Fprintf(out, "Keystore unlocked until %s, interrupt to lock it\n", a.Expires().Format(time.TimeOnly))
+	} else {
+		fmt.Fprintln(os.Stdout, "ready")
+		os.Stdout.Close()
+	}
+	return a.Serve(cmd.Context(), ln)
+}
+
+var agentLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Make the agent forget the keystore key",
+	Long: `Lock makes the running agent forget the keystore key and exit. Commands
+ask for the passphrase again afterwards.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := agent.Lock(agent.SocketPath(cfg.KeystorePath()))
+		if errors.Is(err, agent.ErrNotRunning) {
+			fmt.Fprintln(cmd.OutOrStdout(), "No agent running")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Keystore locked")
+		return nil
+	},
+}
+
+var agentStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether an agent holds the keystore unlocked",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, err := agent.GetStatus(agent.SocketPath(cfg.KeystorePath()))
+		if errors.Is(err, agent.ErrNotRunning) {
+			fmt.Fprintln(cmd.OutOrStdout(), "No agent running")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Keystore %s unlocked until %s (%s left)\n",
+			status.Keystore, status.Expires.Format(time.TimeOnly), time.Until(status.Expires).Round(time.Second))
+		return nil
+	},
+}
+
+func init() {
+	agentStartCmd.Flags().Duration("ttl", agent.DefaultTTL, "how long to keep the keystore unlocked")
+	agentStartCmd.Flags().Bool("foreground", false, "run the agent in the foreground until interrupted")
+	agentServeCmd.Flags().Duration("ttl", agent.DefaultTTL, "how long to keep the keystore unlocked")
+
+	agentCmd.AddCommand(agentStartCmd)
+	agentCmd.AddCommand(agentServeCmd)
+	agentCmd.AddCommand(agentLockCmd)
+	agentCmd.AddCommand(agentStatusCmd)
+	rootCmd.AddCommand(agentCmd)
+}
@@ -0,0 +1,333 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/aws"
+	"github.com/Noah-Wilderom/dfs/pkg/backup"
+	"github.com/Noah-Wilderom/dfs/pkg/keystore"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/repo"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+// backupPassphraseEnv is the environment variable the backup passphrase
+// can be given in, for scheduled backups.
+const backupPassphraseEnv = "DFS_BACKUP_PASSPHRASE"
+
+var seriesNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the repo to encrypted archives, and restore it",
+	Long: `Backup writes the pins, the published names and the blocks of everything
+pinned to encrypted archives, from which the repo can be restored without
+any peer's help.
+
+Archives come in series: the first export of a series is a full backup,
+every later one a delta holding only what changed since. Restoring takes
+the full backup and its deltas, in order. An archive goes to a file, to
+stdout with -, or to S3 with s3://bucket/key, using the credentials and
+region in the AWS_* environment variables; AWS_ENDPOINT_URL points it at
+another S3 compatible service.
+
+Archives are encrypted with a passphrase, read from DFS_BACKUP_PASSPHRASE,
+then from --passphrase-file, and otherwise asked for on the terminal. Keys
+kept in the keystore are not in the archives: back them up with "dfs keys
+backup".`,
+}
+
+var backupExportCmd = &cobra.Command{
+	Use:   "export -o <file|-|s3://bucket/key>",
+	Short: "Write a full or delta backup archive",
+	Long: `Export writes the next archive of a series: a full backup the first time,
+or with --full, and a delta of what changed since the last archive
+otherwise. This node keeps track of what its series hold in the data dir,
+and starts a series anew when that record is lost.
+
+It reads the repo without changing it, so it can run next to the daemon.
+Blocks of pinned content this node doesn't hold are left out, with a
+warning.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		series, _ := cmd.Flags().GetString("series")
+		full, _ := cmd.Flags().GetBool("full")
+		if output == "" {
+			return errors.New("--output is required")
+		}
+		if !seriesNameRe.MatchString(series) {
+			return fmt.Errorf("bad series name %q: use up to 64 letters, digits, - and _", series)
+		}
+
+		catalogPath := filepath.Join(cfg.BackupsPath(), series+".json")
+		var base *backup.Catalog
+		if !full {
+			var err error
+			if base, err = backup.LoadCatalog(catalogPath); err != nil {
+				return err
+			}
+		}
+		passphrase, err := backupPassphrase(cmd, true)
+		if err != nil {
+			return err
+		}
+
+		lock, err := repo.AcquireRead(cfg.DataDir)
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+		store, err := storage.OpenReadOnly(cfg.StoragePath())
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		pins, err := pin.OpenReadOnly(cfg.PinsPath())
+		if err != nil {
+			return err
+		}
+
+		w, err := createArchive(cmd, output)
+		if err != nil {
+			return err
+		}
+		catalog, report, err := backup.Export(cmd.Context(), w, backup.ExportOptions{
+			Store:      store,
+			Pins:       pins.List(),
+			NamesDir:   cfg.NamesPath(),
+			Passphrase: passphrase,
+			Base:       base,
+		})
+		if err != nil {
+			w.Abort()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if err := catalog.Save(catalogPath); err != nil {
+			return fmt.Errorf("archive written, but its series not recorded: %w", err)
+		}
+
+		kind := "Delta"
+		if report.Seq == 0 {
+			kind = "Full backup"
+		}
+		out := cmd.ErrOrStderr()
+		if output != "-" {
+			out = cmd.OutOrStdout()
+		}
+		fmt.Fprintf(out, "%s %d of series %s (%s): %d pins, %d name files, %d blocks (%s), %d unchanged\n",
+			kind, report.Seq, series, report.Series, report.Pins, report.Names, report.Blocks, formatBytes(report.Bytes), report.Unchanged)
+		if len(report.Missing) > 0 {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %d blocks of pinned content are not stored here and were left out\n", len(report.Missing))
+		}
+		return nil
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <archive>...",
+	Short: "Restore the repo from a full backup and its deltas",
+	Long: `Restore stores the blocks of the archives given, a full backup followed by
+any number of its deltas in order, then pins what the last one pins and
+puts back the names it holds. Pins the repo already has gain the
+settings they had in the backup; name files the repo already has are
+kept. Each archive is a file, - for stdin, or s3://bucket/key.
+
+It needs the repo's write lock, so stop the daemon first. Pins whose
+content the archives don't hold in full are listed; the daemon fetches
+the rest from peers, if any hold it.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := backupPassphrase(cmd, false)
+		if err != nil {
+			return err
+		}
+
+		lock, err := lockRepo()
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+		store, err := openRepoStore()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		pins, err := pin.Open(cfg.PinsPath())
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		r := backup.NewRestorer(backup.RestoreOptions{
+			Store:      store,
+			Pins:       pins,
+			NamesDir:   cfg.NamesPath(),
+			Passphrase: passphrase,
+		})
+		for _, arg := range args {
+			rc, err := openArchive(cmd, arg)
+			if err != nil {
+				return err
+			}
+			_, err = r.Apply(ctx, rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+		}
+		report, err := r.Finish(ctx)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Restored %d archives of series %s: %d blocks (%s), %d pins, %d name files\n",
+			report.Archives, report.Series, report.Blocks, formatBytes(report.Bytes), report.Pins, report.Names)
+		if report.NamesKept > 0 {
+			fmt.Fprintf(out, "Kept %d name files the repo already had\n", report.NamesKept)
+		}
+		if len(report.Incomplete) > 0 {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: the archives don't hold all the content of %d pins:\n", len(report.Incomplete))
+			for _, c := range report.Incomplete {
+				fmt.Fprintf(cmd.ErrOrStderr(), "  %s\n", c)
+			}
+		}
+		return nil
+	},
+}
+
+// backupPassphrase returns the passphrase archives are sealed with. On
+// the terminal a new one is asked twice, to catch typos.
+func backupPassphrase(cmd *cobra.Command, confirm bool) ([]byte, error) {
+	if p := os.Getenv(backupPassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	if file, _ := cmd.Flags().GetString("passphrase-file"); file != "" {
+		return keystore.ReadPassphraseFile(file)
+	}
+	p, err := keystore.Prompt("Backup passphrase: ")
+	if errors.Is(err, keystore.ErrNoPassphrase) {
+		return nil, fmt.Errorf("no passphrase; set %s or --passphrase-file", backupPassphraseEnv)
+	}
+	if err != nil || !confirm {
+		return p, err
+	}
+	again, err := keystore.Prompt("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(p, again) {
+		return nil, errors.New("passphrases don't match")
+	}
+	return p, nil
+}
+
+// archiveWriter is where an archive is written. It is only complete once
+// closed.
+type archiveWriter interface {
+	io.WriteCloser
+	Abort()
+}
+
+// createArchive opens the target of an archive: stdout for -, an S3
+// object, or a new file, which appears once the archive is complete.
+func createArchive(cmd *cobra.Command, target string) (archiveWriter, error) {
+	if target == "-" {
+		return stdoutArchive{cmd.OutOrStdout()}, nil
+	}
+	if strings.HasPrefix(target, "s3://") {
+		bucket, key, err := aws.ParseS3URL(target)
+		if err != nil {
+			return nil, err
+		}
+		s3, err := aws.NewS3FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return s3.Upload(cmd.Context(), bucket, key), nil
+	}
+
+	// An existing archive may be part of a series, so it isn't replaced
+	if _, err := os.Lstat(target); err == nil {
+		return nil, fmt.Errorf("%s: %w", target, fs.ErrExist)
+	}
+	f, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileArchive{File: f, path: target}, nil
+}
+
+type stdoutArchive struct{ io.Writer }
+
+func (stdoutArchive) Close() error { return nil }
+func (stdoutArchive) Abort()       {}
+
+type fileArchive struct {
+	*os.File
+	path string
+}
+
+func (f *fileArchive) Close() error {
+	err := f.File.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (f *fileArchive) Abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// openArchive opens an archive to restore: stdin for -, an S3 object or
+// a file.
+func openArchive(cmd *cobra.Command, source string) (io.ReadCloser, error) {
+	if source == "-" {
+		return io.NopCloser(cmd.InOrStdin()), nil
+	}
+	if strings.HasPrefix(source, "s3://") {
+		bucket, key, err := aws.ParseS3URL(source)
+		if err != nil {
+			return nil, err
+		}
+		s3, err := aws.NewS3FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return s3.Get(cmd.Context(), bucket, key)
+	}
+	return os.Open(source)
+}
+
+func init() {
+	backupExportCmd.Flags().StringP("output", "o", "", "where to write the archive: a file, - for stdout, or s3://bucket/key")
+	backupExportCmd.Flags().String("series", "default", "series the archive belongs to")
+	backupExportCmd.Flags().Bool("full", false, "start the series anew with a full backup")
+	backupExportCmd.Flags().String("passphrase-file", "", "read the passphrase from the first line of this file")
+	backupRestoreCmd.Flags().String("passphrase-file", "", "read the passphrase from the first line of this file")
+
+	backupCmd.AddCommand(backupExportCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	rootCmd.AddCommand(backupCmd)
+}
@@ -0,0 +1,360 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/gc"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/repo"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// testDaemon serves a node without networking over a Unix socket and
+// writes a config for the CLI, returning the flags that point the CLI at
+// both.
+func testDaemon(t *testing.T) []string {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	pins, err := pin.Open(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := repo.AcquireWrite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lock.Close() })
+
+	srv := api.NewServer(api.ServerOpts{
+		Node: node.NewNode(node.NodeOpts{
+			Store:    store,
+			Pins:     pins,
+			Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 1024},
+		}),
+		SocketPath: filepath.Join(dir, "api.sock"),
+		GC:         gc.NewCollector(gc.CollectorOpts{Store: store, Pins: pins, Lock: lock}),
+		Ops:        ops.NewRegistry(),
+		Logger:     zap.NewNop(),
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	config := filepath.Join(dir, "config.yaml")
+	data := fmt.Sprintf("data_dir: %s\nlogging:\n  level: error\n", filepath.Join(dir, "cli"))
+	if err := os.WriteFile(config, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"--config", config, "--api", srv.SocketPath}
+}
+
+// runCLI runs the dfs command line args and returns what it wrote to
+// stdout and stderr. Flags are reset first, as every run starts a new
+// process outside tests. It runs in a temporary directory, where a
+// development build writes its logs.
+func runCLI(t *testing.T, args ...string) (string, string, error) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	resetFlags(rootCmd)
+	var stdout, stderr bytes.Buffer
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetErr(&stderr)
+	rootCmd.SetArgs(args)
+	err := rootCmd.ExecuteContext(context.Background())
+	cancelTimeout()
+	return stdout.String(), stderr.String(), err
+}
+
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			s.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// addFile adds data as a file named name and returns its hash.
+func addFile(t *testing.T, daemon []string, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err := runCLI(t, append(daemon, "add", "--quiet", path)...)
+	if err != nil {
+		t.Fatalf("add: %v\n%s", err, stderr)
+	}
+	return strings.TrimSpace(stdout)
+}
+
+func TestAddGet(t *testing.T) {
+	daemon := testDaemon(t)
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	hash := addFile(t, daemon, "digits.txt", data)
+
+	out := filepath.Join(t.TempDir(), "out.txt")
+	stdout, _, err := runCLI(t, append(daemon, "get", hash, "-o", out)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes back, want %d", len(got), len(data))
+	}
+	if want := fmt.Sprintf("sha256 %x\n", sha256.Sum256(data)); !strings.HasSuffix(stdout, want) {
+		t.Errorf("stdout = %q, want it to end in %q", stdout, want)
+	}
+}
+
+// With the file going to stdout, the JSON lines go to stderr so the two
+// can be told apart.
+func TestGetStdoutJSON(t *testing.T) {
+	daemon := testDaemon(t)
+	data := bytes.Repeat([]byte("json "), 2000)
+	hash := addFile(t, daemon, "data.bin", data)
+
+	stdout, stderr, err := runCLI(t, append(daemon, "get", hash, "-o", "-", "--json")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != string(data) {
+		t.Errorf("stdout holds %d bytes, want the %d of the file", len(stdout), len(data))
+	}
+
+	var events []transferEvent
+	sc := bufio.NewScanner(strings.NewReader(stderr))
+	for sc.Scan() {
+		var ev transferEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("stderr line %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) == 0 {
+		t.Fatal("no JSON events on stderr")
+	}
+	last := events[len(events)-1]
+	if last.Event != "saved" || last.Path != "-" || last.Size != int64(len(data)) || last.SHA256 != fmt.Sprintf("%x", sha256.Sum256(data)) {
+		t.Errorf("last event = %+v", last)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	daemon := testDaemon(t)
+	hash := addFile(t, daemon, "a.txt", []byte("a"))
+	dir := filepath.Join(t.TempDir(), "d")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err := runCLI(t, append(daemon, "add", "-r", "--quiet", dir)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirHash := strings.TrimSpace(stdout)
+	missing := addFile(t, testDaemon(t), "elsewhere.txt", []byte("not on this daemon"))
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"get"}, "accepts 1 arg"},
+		{[]string{"get", hash, "--quiet", "--json"}, "can't be used together"},
+		{[]string{"get", hash, "--archive", "rar"}, "rar"},
+		{[]string{"get", dirHash, "-o", "-"}, "is a directory"},
+		{[]string{"get", "--no-such-flag", hash}, "unknown flag"},
+		{[]string{"get", missing, "-o", filepath.Join(t.TempDir(), "x")}, "not found"},
+		{[]string{"privacy", "purge", "--origin", "everything"}, "--origin must be"},
+		{[]string{"privacy", "purge", "--origin", "peer"}, "needs a peer ID"},
+		{[]string{"privacy", "purge", "--origin", "peer", "nobody"}, "peer nobody"},
+		{[]string{"publish-site", dir}, "--name is required"},
+		{[]string{"publish-site", dir, "--name", "site", "--dns", "example.com"}, "go together"},
+		{[]string{"publish-site", dir, "--name", "site", "--dns", "example.com", "--dns-provider", "bind"}, "unknown provider"},
+		{[]string{"push", "bafy"}, "--to is required"},
+		{[]string{"inbox", "accept", "first"}, "invalid inbox item"},
+		{[]string{"inbox", "reject", "1"}, "no inbox"},
+	} {
+		_, _, err := runCLI(t, append(daemon, tc.args...)...)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("dfs %s: error %v, want one mentioning %q", strings.Join(tc.args, " "), err, tc.want)
+		}
+	}
+}
+
+func TestPurgeJSON(t *testing.T) {
+	daemon := testDaemon(t)
+	stdout, _, err := runCLI(t, append(daemon, "privacy", "purge", "--origin", "cache", "--dry-run", "--json")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report purgeReport
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("stdout %q: %v", stdout, err)
+	}
+	if report.Origin != api.PurgeCache || !report.DryRun {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+// repoConfig writes a config for a repo of its own, for commands that
+// work on the repo rather than through the daemon, and returns the flags
+// pointing the CLI at it and its data dir.
+func repoConfig(t *testing.T) ([]string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	data := fmt.Sprintf("data_dir: %s\nlogging:\n  level: error\n", filepath.Join(dir, "data"))
+	if err := os.WriteFile(config, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"--config", config}, filepath.Join(dir, "data")
+}
+
+// pinFile adds data to the repo in dataDir, pinned, and returns its hash.
+func pinFile(t *testing.T, dataDir, data string) string {
+	t.Helper()
+	store, err := storage.Open(filepath.Join(dataDir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	pins, err := pin.Open(filepath.Join(dataDir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := node.NewNode(node.NodeOpts{Store: store, Pins: pins, Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 64}})
+	res, err := n.Add(context.Background(), strings.NewReader(data), node.AddOptions{Name: "f.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pins.AddWith(res.CID, pin.AddOptions{Name: "f"}); err != nil {
+		t.Fatal(err)
+	}
+	return res.CID.String()
+}
+
+func TestBackupRestore(t *testing.T) {
+	t.Setenv(backupPassphraseEnv, "secret")
+	src, srcDir := repoConfig(t)
+	archives := t.TempDir()
+	first := pinFile(t, srcDir, strings.Repeat("first ", 100))
+	full := filepath.Join(archives, "full.dfsbak")
+	stdout, _, err := runCLI(t, append(src, "backup", "export", "-o", full)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stdout, "Full backup 0 of series default") {
+		t.Errorf("stdout = %q", stdout)
+	}
+	if _, _, err := runCLI(t, append(src, "backup", "export", "-o", full)...); err == nil || !strings.Contains(err.Error(), "exists") {
+		t.Errorf("export over an archive: %v", err)
+	}
+
+	second := pinFile(t, srcDir, strings.Repeat("second ", 100))
+	delta := filepath.Join(archives, "delta.dfsbak")
+	stdout, _, err = runCLI(t, append(src, "backup", "export", "-o", delta)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stdout, "Delta 1 of series default") {
+		t.Errorf("stdout = %q", stdout)
+	}
+
+	dst, dstDir := repoConfig(t)
+	if _, _, err := runCLI(t, append(dst, "backup", "restore", delta)...); err == nil || !strings.Contains(err.Error(), "full backup") {
+		t.Errorf("restore of a delta alone: %v", err)
+	}
+	stdout, stderr, err := runCLI(t, append(dst, "backup", "restore", full, delta)...)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr)
+	}
+	if !strings.HasPrefix(stdout, "Restored 2 archives") || stderr != "" {
+		t.Errorf("stdout = %q, stderr = %q", stdout, stderr)
+	}
+	pins, err := pin.Open(filepath.Join(dstDir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{first, second} {
+		c, _ := cid.Decode(hash)
+		if p, ok := pins.Get(c); !ok || p.Name != "f" {
+			t.Errorf("pin %s restored as %+v, %v", hash, p, ok)
+		}
+	}
+}
+
+// The refs of a repository read back as the list command answers with
+// them, HEAD first.
+func TestGitRefs(t *testing.T) {
+	const list = "@refs/heads/main HEAD\n" +
+		"aaaa refs/heads/dev\n" +
+		"bbbb refs/heads/main\n" +
+		"cccc refs/tags/v1\n"
+	refs, err := parseGitRefs(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs.head != "refs/heads/main" || refs.refs["refs/tags/v1"] != "cccc" {
+		t.Errorf("parsed %+v", refs)
+	}
+	if got := refs.String(); got != list {
+		t.Errorf("formatted as %q, want %q", got, list)
+	}
+
+	delete(refs.refs, "refs/heads/main")
+	if head := refs.defaultHead(); head != "refs/heads/dev" {
+		t.Errorf("default HEAD %q, want refs/heads/dev", head)
+	}
+	if _, err := parseGitRefs("refs/heads/main\n"); err == nil {
+		t.Error("malformed refs accepted")
+	}
+
+	for _, tt := range []struct{ url, key, name string }{
+		{"dfs://myproject", "myproject", ""},
+		{"dfs://k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8/", "", "k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8"},
+	} {
+		r := &gitRemote{}
+		if err := r.parseURL(tt.url); err != nil || r.key != tt.key || r.name != tt.name {
+			t.Errorf("parseURL(%q): key %q, name %q, %v", tt.url, r.key, r.name, err)
+		}
+	}
+	if err := (&gitRemote{}).parseURL("dfs://a/b"); err == nil {
+		t.Error("URL with a path accepted")
+	}
+}
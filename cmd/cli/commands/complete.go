@@ -0,0 +1,202 @@
+package commands
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/config"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+// completeTimeout bounds how long tab completion waits for the daemon, so
+// a stopped or busy daemon leaves the shell with no suggestions rather than
+// hanging.
+const completeTimeout = 2 * time.Second
+
+// completeWith runs ask against the daemon for a completion function. Shell
+// completion skips PersistentPreRunE, so the config is loaded here.
+// Anything going wrong yields no suggestions.
+func completeWith(cmd *cobra.Command, ask func(ctx context.Context, client *api.Client) ([]cobra.Completion, cobra.ShellCompDirective)) ([]cobra.Completion, cobra.ShellCompDirective) {
+	c, err := config.LoadFromFlags(cmd.Flags())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	cfg = c
+
+	client, err := dialDaemon(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), completeTimeout)
+	defer cancel()
+	return ask(ctx, client)
+}
+
+// firstArg limits a completion function to the command's first argument.
+func firstArg(complete cobra.CompletionFunc) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return complete(cmd, args, toComplete)
+	}
+}
+
+// completeSnapshot completes the local directory, then the stored one, of
+// dfs sync.
+func completeSnapshot(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	case 1:
+		return completePaths(cmd, args, toComplete)
+	default:
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeVerify completes the local file, then the hash, of dfs
+// verify-file.
+func completeVerify(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return nil, cobra.ShellCompDirectiveDefault
+	case 1:
+		return completePins(cmd, args, toComplete)
+	default:
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completePins suggests the hashes of pinned files and directories.
+func completePins(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	return completeWith(cmd, func(ctx context.Context, client *api.Client) ([]cobra.Completion, cobra.ShellCompDirective) {
+		return pinCompletions(ctx, client, "", toComplete), cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// completePaths suggests pinned hashes and, once a path names a directory,
+// the entries in it: /<hash>/ then /<hash>/sub/ and so on.
+func completePaths(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	return completeWith(cmd, func(ctx context.Context, client *api.Client) ([]cobra.Completion, cobra.ShellCompDirective) {
+		dir, prefix, below := cutLast(toComplete)
+		if !below {
+			// Still typing the root; directories get a slash to carry on
+			// into them.
+			lead := ""
+			if strings.HasPrefix(toComplete, "/") {
+				lead = "/"
+			}
+			return withDirective(pinCompletions(ctx, client, lead, toComplete))
+		}
+
+		res, err := client.ListDirectory(ctx, dir)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		var comps []cobra.Completion
+		for _, e := range res.Entries {
+			if !strings.HasPrefix(e.Name, prefix) {
+				continue
+			}
+			name, desc := dir+"/"+e.Name, "file, "+formatBytes(e.Size)
+			if e.Dir {
+				name, desc = name+"/", "directory, "+formatBytes(e.Size)
+			}
+			comps = append(comps, cobra.CompletionWithDesc(name, desc))
+		}
+		return withDirective(comps)
+	})
+}
+
+// pinCompletions lists the pinned hashes starting with toComplete once lead
+// is put in front of them. Directories end in a slash.
+func pinCompletions(ctx context.Context, client *api.Client, lead, toComplete string) []cobra.Completion {
+	res, err := client.ListPins(ctx)
+	if err != nil {
+		return nil
+	}
+	var comps []cobra.Completion
+	for _, p := range res.Pins {
+		name, desc := lead+p.CID, "file"
+		if c, err := cid.Decode(p.CID); err == nil && c.Type() == manifest.DirectoryCodec {
+			desc = "directory"
+			if lead != "" {
+				name += "/"
+			}
+		}
+		if strings.HasPrefix(name, toComplete) {
+			comps = append(comps, cobra.CompletionWithDesc(name, desc))
+		}
+	}
+	return comps
+}
+
+// cutLast splits a path being typed at its last slash, when there is one
+// after the root.
+func cutLast(p string) (dir, prefix string, below bool) {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 || strings.Trim(p[:i], "/") == "" {
+		return "", "", false
+	}
+	return p[:i], p[i+1:], true
+}
+
+// withDirective keeps the shell from adding a space after a directory, so
+// completion can carry on into it.
+func withDirective(comps []cobra.Completion) ([]cobra.Completion, cobra.ShellCompDirective) {
+	directive := cobra.ShellCompDirectiveNoFileComp
+	if slices.ContainsFunc(comps, func(c cobra.Completion) bool {
+		name, _, _ := strings.Cut(c, "\t")
+		return strings.HasSuffix(name, "/")
+	}) {
+		directive |= cobra.ShellCompDirectiveNoSpace
+	}
+	return comps, directive
+}
+
+// completePeers suggests the IDs of connected peers.
+func completePeers(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	return completeWith(cmd, func(ctx context.Context, client *api.Client) ([]cobra.Completion, cobra.ShellCompDirective) {
+		res, err := client.ListPeers(ctx)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		var comps []cobra.Completion
+		for _, p := range res.Peers {
+			if !strings.HasPrefix(p.ID, toComplete) {
+				continue
+			}
+			desc := "connected"
+			if len(p.Addresses) > 0 {
+				desc = p.Addresses[0]
+			}
+			comps = append(comps, cobra.CompletionWithDesc(p.ID, desc))
+		}
+		return comps, cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// completeBlocked suggests the deny rules in place.
+func completeBlocked(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	return completeWith(cmd, func(ctx context.Context, client *api.Client) ([]cobra.Completion, cobra.ShellCompDirective) {
+		res, err := client.ListBlocked(ctx)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		var comps []cobra.Completion
+		for _, rule := range res.Deny {
+			if strings.HasPrefix(rule, toComplete) {
+				comps = append(comps, rule)
+			}
+		}
+		return comps, cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with the config file",
+	// The point is to look at a config that may not load.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [path]",
+	Short: "Check a config file before a node uses it",
+	Long: `Validate checks a config file, the given path, --config or the default
+location, and prints the problems it finds with their lines:
+
+  config.yaml:4: network.dht_mode: unknown mode "sever"
+  config.yaml:9: warning: gc.intervall: unknown key
+
+Unknown keys are warnings, since the daemon ignores them, unless --strict
+is given. Environment overrides are not applied. The exit status is
+non-zero when there are errors, so it can gate a deployment:
+
+  dfs config validate /etc/dfs/config.yaml && systemctl restart dfs
+
+dfs config schema lists the keys a file may set.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("config")
+		if len(args) == 1 {
+			path = args[0]
+		}
+		if path == "" {
+			path = config.DefaultPath()
+		}
+		strict, _ := cmd.Flags().GetBool("strict")
+
+		problems, err := config.Check(path)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		errs := 0
+		for _, p := range problems {
+			line := path
+			if p.Line > 0 {
+				line = fmt.Sprintf("%s:%d", path, p.Line)
+			}
+			msg := p.Message
+			if p.Key != "" {
+				msg = p.Key + ": " + msg
+			}
+
+			if p.Warning && !strict {
+				fmt.Fprintf(out, "%s: warning: %s\n", line, msg)
+				continue
+			}
+			fmt.Fprintf(out, "%s: %s\n", line, msg)
+			errs++
+		}
+
+		if errs > 0 {
+			return errors.New("config is invalid")
+		}
+		fmt.Fprintf(out, "%s: OK\n", path)
+		return nil
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "List the config keys with their types and defaults",
+	Long: `Schema lists every key the config file accepts, with its type and the
+value used when the file leaves it out:
+
+  network.port                 int       9000
+  network.dht_mode             string    "off"
+  replication.rules            list      []
+  replication.rules[].label    string
+
+Items of a list are written key[].field and entries of a map
+key.<name>.field; they have no defaults. Durations are written like 90s,
+5m or 1h30m.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		settings := config.Schema()
+
+		out := cmd.OutOrStdout()
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(settings)
+		}
+
+		keyWidth, typeWidth := len("KEY"), len("TYPE")
+		for _, s := range settings {
+			keyWidth = max(keyWidth, len(s.Key))
+			typeWidth = max(typeWidth, len(s.Type))
+		}
+		fmt.Fprintf(out, "%-*s  %-*s  %s\n", keyWidth, "KEY", typeWidth, "TYPE", "DEFAULT")
+		for _, s := range settings {
+			line := fmt.Sprintf("%-*s  %-*s  %s", keyWidth, s.Key, typeWidth, s.Type, s.Default)
+			fmt.Fprintln(out, strings.TrimRight(line, " "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	configValidateCmd.Flags().Bool("strict", false, "treat unknown keys as errors")
+	configSchemaCmd.Flags().Bool("json", false, "print the schema as JSON")
+
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,193 @@
+package commands
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/spf13/cobra"
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "Debugging tools",
+}
+
+// replayCmd feeds a recorded daemon event log back into the state machines
+var replayCmd = &cobra.Command{
+	Use:   "replay <event-log>",
+	Short: "Replay a recorded daemon event log",
+	Long: `Replay reads an event log written by the daemon (set DFS_EVENT_LOG when
+starting it) and feeds the events into the subsystem state machines, printing
+the timeline, the resulting state and any events that did not make sense.
+The log holds peer connections, the blocks wanted from and served to peers,
+replication and admission decisions, garbage collections and resource
+pressure.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		out := cmd.OutOrStdout()
+
+		peers := eventlog.NewPeerTable()
+		wants := eventlog.NewWantTable()
+		replicas := eventlog.NewReplicaTable()
+		collections := &eventlog.GCTable{}
+		timeline := machineFunc(func(e eventlog.Event) error {
+			if verbose {
+				fmt.Fprintf(out, "%s  %-20s %s %s\n", e.Time.Format(time.RFC3339Nano), e.Type, e.Peer, e.Fields["cid"])
+			}
+			return nil
+		})
+
+		if err := eventlog.Replay(f, timeline, peers, wants, replicas, collections); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Connected peers: %d\n", len(peers.Peers))
+		for _, id := range peers.Connected() {
+			p := peers.Peers[id]
+			fmt.Fprintf(out, "  %s conns=%d addr=%s\n", id, p.Conns, p.Addr)
+		}
+
+		fmt.Fprintf(out, "Wants: %d pending, %d received, %d not found, %d failed, %d cancelled\n",
+			wants.PendingCount(), wants.Results[eventlog.WantReceived], wants.Results[eventlog.WantNotFound],
+			wants.Results[eventlog.WantFailed], wants.Results[eventlog.WantCancelled])
+		fmt.Fprintf(out, "Served: %d blocks, %d wanted that we lacked\n", wants.Served, wants.Missing)
+
+		fmt.Fprintf(out, "Replicas: %d pins placed, %d copies kept for peers, %d requests refused, %d declined\n",
+			len(replicas.Placed), len(replicas.Kept), len(replicas.Refused), len(replicas.Declined))
+		for _, e := range replicas.Refused {
+			fmt.Fprintf(out, "  refused %s from %s: %s\n", e.Fields["cid"], e.Peer, e.Fields["reason"])
+		}
+		for _, c := range slices.Sorted(maps.Keys(replicas.UnderReplicated)) {
+			e := replicas.UnderReplicated[c]
+			fmt.Fprintf(out, "  under-replicated %s: %s of %s copies\n", c, e.Fields["copies"], e.Fields["factor"])
+		}
+
+		fmt.Fprintf(out, "Garbage collections: %d, %d failed, %d blocks (%s) removed\n",
+			collections.Runs, collections.Failed, collections.Removed, formatBytes(collections.RemovedBytes))
+
+		anomalies := append(peers.Anomalies, wants.Anomalies...)
+		slices.SortStableFunc(anomalies, func(a, b eventlog.Anomaly) int { return a.Event.Time.Compare(b.Event.Time) })
+		if len(anomalies) > 0 {
+			fmt.Fprintf(out, "Anomalies: %d\n", len(anomalies))
+			for _, a := range anomalies {
+				fmt.Fprintf(out, "  %s\n", a)
+			}
+		}
+		return nil
+	},
+}
+
+// routesCmd shows how the daemon retrieves a file
+var routesCmd = &cobra.Command{
+	Use:   "routes <hash>",
+	Short: "Show how a file is retrieved from the network",
+	Long: `Routes has the daemon retrieve a file and reports the retrieval plan it
+followed: the provider lookups, the round trip time to every provider, the
+byte ranges each peer supplied and where the time was spent. Fetched blocks
+are kept, so run it before "dfs get" to debug a slow download; a second run
+reports every chunk as local.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.Routes(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		out := cmd.OutOrStdout()
+
+		fmt.Fprintf(out, "%s (%s, %d chunks)\n", res.CID, formatBytes(res.Size), len(res.Chunks))
+		fmt.Fprintf(out, "Time:    %s total, %s finding providers, %s fetching\n",
+			roundDuration(res.Total), roundDuration(res.Lookup), roundDuration(res.Fetch))
+
+		fmt.Fprintf(out, "Lookups: %d\n", len(res.Lookups))
+		for _, l := range res.Lookups {
+			if l.Error != "" {
+				fmt.Fprintf(out, "  %s  %s  failed: %s\n", l.CID, roundDuration(l.Duration), l.Error)
+				continue
+			}
+			fmt.Fprintf(out, "  %s  %s  %d providers\n", l.CID, roundDuration(l.Duration), len(l.Providers))
+		}
+
+		fmt.Fprintf(out, "Peers:   %d\n", len(res.Peers))
+		for _, p := range res.Peers {
+			rtt := roundDuration(p.RTT).String()
+			if p.RTTError != "" {
+				rtt = "unreachable"
+			}
+			fmt.Fprintf(out, "  %s  rtt %s  %d chunks  %s  %s fetching  %d failures\n",
+				p.ID, rtt, p.Blocks, formatBytes(p.Bytes), roundDuration(p.Duration), p.Failures)
+			for _, r := range p.Ranges {
+				fmt.Fprintf(out, "    bytes %d-%d\n", r.Offset, r.Offset+r.Size-1)
+			}
+		}
+
+		local := 0
+		for _, c := range res.Chunks {
+			if c.Source == "" {
+				local++
+			}
+		}
+		fmt.Fprintf(out, "Local:   %d of %d chunks\n", local, len(res.Chunks))
+
+		if verbose {
+			fmt.Fprintln(out, "Chunks:")
+			for i, c := range res.Chunks {
+				source := "local"
+				if c.Source != "" {
+					source = fmt.Sprintf("%s in %s", c.Source, roundDuration(c.Duration))
+				}
+				fmt.Fprintf(out, "  %4d  bytes %d-%d  %s\n", i, c.Offset, c.Offset+c.Size-1, source)
+				for _, id := range c.Failed {
+					fmt.Fprintf(out, "        failed: %s\n", id)
+				}
+			}
+		}
+		return nil
+	},
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+type machineFunc func(eventlog.Event) error
+
+func (f machineFunc) Apply(e eventlog.Event) error {
+	return f(e)
+}
+
+func init() {
+	replayCmd.Flags().BoolP("verbose", "v", false, "print every event")
+
+	routesCmd.Flags().BoolP("verbose", "v", false, "list where every chunk came from")
+
+	debugCmd.AddCommand(replayCmd)
+	debugCmd.AddCommand(routesCmd)
+	rootCmd.AddCommand(debugCmd)
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// natPollInterval is how often `doctor nat --wait` asks the daemon again.
+const natPollInterval = 2 * time.Second
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose common problems with a running node",
+}
+
+var doctorNATCmd = &cobra.Command{
+	Use:   "nat",
+	Short: "Check port mappings and whether peers can reach the node",
+	Long: `Nat shows the port mappings the node asked its gateway for over UPnP or
+NAT-PMP, or the one set by hand with network.external_addr, and whether
+peers managed to dial the node through them.
+
+Peers test the node's public addresses in the background, which takes a
+few minutes after the daemon starts and needs connected peers running a
+recent release. --wait keeps asking until every mapped address has been
+tested. The exit status is non-zero when a mapped address turned out
+unreachable, or when the node has no mapping and peers can't reach it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		wait, _ := cmd.Flags().GetDuration("wait")
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		deadline := time.Now().Add(wait)
+		for {
+			st, err := client.NATStatus(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if len(untested(st)) == 0 || !time.Now().Before(deadline) {
+				return reportNAT(cmd.OutOrStdout(), st)
+			}
+
+			select {
+			case <-cmd.Context().Done():
+				return cmd.Context().Err()
+			case <-time.After(natPollInterval):
+			}
+		}
+	},
+}
+
+// mapped returns the external addresses of the node's port mappings.
+func mapped(st *api.NATStatusResponse) []string {
+	addrs := slices.Clone(st.Static)
+	for _, m := range st.Mappings {
+		if m.External != "" {
+			addrs = append(addrs, m.External)
+		}
+	}
+	return addrs
+}
+
+// untested returns the mapped addresses peers haven't tried yet.
+func untested(st *api.NATStatusResponse) []string {
+	var addrs []string
+	for _, addr := range mapped(st) {
+		if !slices.Contains(st.Reachable, addr) && !slices.Contains(st.Unreachable, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func reportNAT(out io.Writer, st *api.NATStatusResponse) error {
+	switch {
+	case len(st.Static) > 0:
+		fmt.Fprintln(out, "Port mapping:  static (network.external_addr)")
+	case !st.PortMap:
+		fmt.Fprintln(out, "Port mapping:  off")
+	case !st.Gateway:
+		fmt.Fprintln(out, "Port mapping:  on, no UPnP or NAT-PMP gateway found")
+	default:
+		fmt.Fprintln(out, "Port mapping:  on, gateway found")
+	}
+	for _, m := range st.Mappings {
+		external := "not mapped"
+		if m.External != "" {
+			external = m.External
+		}
+		fmt.Fprintf(out, "  %s -> %s\n", m.Internal, external)
+	}
+	fmt.Fprintf(out, "Reachability:  %s\n", st.Reachability)
+
+	addrs := mapped(st)
+	var failed bool
+	if len(addrs) > 0 {
+		fmt.Fprintln(out, "Mapped addresses:")
+	}
+	for _, addr := range addrs {
+		state := "not tested yet"
+		switch {
+		case slices.Contains(st.Reachable, addr):
+			state = "reachable"
+		case slices.Contains(st.Unreachable, addr):
+			state = "UNREACHABLE"
+			failed = true
+		}
+		fmt.Fprintf(out, "  %-14s %s\n", state, addr)
+	}
+
+	switch {
+	case failed:
+		return errors.New("peers can't reach the node through its port mapping")
+	case len(addrs) == 0 && st.Reachability == "private":
+		return errors.New("peers can't reach the node and it has no port mapping")
+	}
+	return nil
+}
+
+func init() {
+	doctorNATCmd.Flags().Duration("wait", 0, "wait up to this long for mapped addresses to be tested")
+
+	doctorCmd.AddCommand(doctorNATCmd)
+	rootCmd.AddCommand(doctorCmd)
+}
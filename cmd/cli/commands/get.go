@@ -1,27 +1,344 @@
 package commands
 
 import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/archive"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // getCmd represents the get command
 var getCmd = &cobra.Command{
-	Use:   "get",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Args: cobra.MaximumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		filePath := args[0]
-
-		logger.Info("Reading file: " + filePath)
+	Use:   "get <hash|path>",
+	Short: "Fetch a file or directory from DFS",
+	Long: `Get reassembles the file with the given content hash. It is written to
+the path given with -o, to the file's original name in the current
+directory by default, or to stdout with "-o -". The sha256 of what was
+written is printed afterwards, on stderr when the file went to stdout.
+
+Files in a directory can be fetched by path, /<hash>/sub/dir/file.txt. A
+directory is fetched with everything in it, into the directory given with
+-o or one named after it.
+
+--archive=tar or --archive=zip streams a file or directory tree as one
+archive instead, to <name>.tar or <name>.zip by default or to stdout with
+"-o -". Files are fetched one at a time and written into the archive as
+they arrive; nothing is unpacked on disk.
+
+On a terminal, a progress bar shows the chunks fetched from peers and the
+data written. --quiet prints nothing but errors, and --json prints
+progress and the result as JSON lines for scripts, on stderr when the
+file goes to stdout.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePaths),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		target, err := expandHash(cmd, client, args[0])
+		if err != nil {
+			return err
+		}
+		root, names, err := manifest.ParsePath(target)
+		if err != nil {
+			return err
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		jsonOut := cmd.OutOrStdout()
+		if output == "-" {
+			jsonOut = cmd.ErrOrStderr()
+		}
+		report, err := newTransferReport(cmd, jsonOut)
+		if err != nil {
+			return err
+		}
+		if output != "-" && report.verbose() {
+			// The logger writes to stdout as well, so stay quiet when the
+			// file itself goes there.
+			logger.Info("Reading file", zap.String("path", target))
+		}
+
+		format, _ := cmd.Flags().GetString("archive")
+		if format != "" {
+			if err := archive.ValidFormat(format); err != nil {
+				return err
+			}
+		}
+
+		// A path may lead to a file or a directory; only asking tells.
+		if len(names) > 0 || root.Type() == manifest.DirectoryCodec {
+			dir, err := client.ListDirectory(cmd.Context(), target)
+			switch {
+			case err == nil && format != "":
+				name := root.String()
+				if len(names) > 0 {
+					name = names[len(names)-1]
+				}
+				return getArchive(cmd, client, report, archive.Entry{Name: name, CID: dir.CID, Dir: true}, format, output)
+			case err == nil:
+				if output == "-" {
+					return fmt.Errorf("%s is a directory and can't go to stdout", target)
+				}
+				if output == "" {
+					output = root.String()
+					if len(names) > 0 {
+						output = names[len(names)-1]
+					}
+				}
+				return getDirectory(cmd, client, report, dir, output)
+			case status.Code(err) != codes.FailedPrecondition:
+				return err
+			}
+		}
+
+		if format != "" {
+			stat, err := client.Stat(cmd.Context(), target)
+			if err != nil {
+				return err
+			}
+			name := filepath.Base(stat.Name)
+			if stat.Name == "" {
+				name = root.String()
+			}
+			return getArchive(cmd, client, report, archive.Entry{Name: name, CID: target}, format, output)
+		}
+
+		progress := report.track(target, 0, true)
+		stream, err := client.Get(cmd.Context(), target, progress)
+		if err != nil {
+			return err
+		}
+		report.setFile(stream.Name, stream.Size, stream.Chunks)
+
+		if output == "" {
+			output = filepath.Base(stream.Name)
+		}
+
+		sum := sha256.New()
+		err = writeOutput(cmd, output, func(w io.Writer) error {
+			_, err := stream.WriteTo(io.MultiWriter(w, sum))
+			return err
+		})
+		report.clear()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case report.enc != nil:
+			report.emit(transferEvent{
+				Event:  "saved",
+				Name:   stream.Name,
+				Path:   output,
+				Size:   stream.Size,
+				Chunks: stream.Chunks,
+				SHA256: fmt.Sprintf("%x", sum.Sum(nil)),
+			})
+			return nil
+		case report.quiet:
+			return nil
+		case output == "-":
+			fmt.Fprintf(cmd.ErrOrStderr(), "sha256 %x\n", sum.Sum(nil))
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Saved %s (%d bytes)\n", output, stream.Size)
+		fmt.Fprintf(cmd.OutOrStdout(), "sha256 %x\n", sum.Sum(nil))
+		return nil
 	},
 }
 
+// getDirectory writes the directory dir and everything under it to dest.
+func getDirectory(cmd *cobra.Command, client *api.Client, report *transferReport, dir *api.ListDirectoryResponse, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	for _, e := range dir.Entries {
+		// Don't let a bad name write outside dest.
+		if err := manifest.ValidName(e.Name); err != nil {
+			return err
+		}
+		entryPath := filepath.Join(dest, e.Name)
+
+		if e.Dir {
+			sub, err := client.ListDirectory(cmd.Context(), e.CID)
+			if err != nil {
+				return fmt.Errorf("%s: %w", entryPath, err)
+			}
+			if err := getDirectory(cmd, client, report, sub, entryPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := getFile(cmd, client, report, e.CID, entryPath); err != nil {
+			return fmt.Errorf("%s: %w", entryPath, err)
+		}
+		switch {
+		case report.enc != nil:
+			report.emit(transferEvent{Event: "saved", Name: e.Name, Path: entryPath, CID: e.CID, Size: e.Size})
+		case !report.quiet:
+			fmt.Fprintf(cmd.OutOrStdout(), "Saved %s (%d bytes)\n", entryPath, e.Size)
+		}
+	}
+	return nil
+}
+
+// getArchive streams root, a file or a directory tree, to output as an
+// archive in format, fetching one file at a time.
+func getArchive(cmd *cobra.Command, client *api.Client, report *transferReport, root archive.Entry, format, output string) error {
+	if output == "" {
+		output = root.Name + archive.Ext(format)
+	}
+	sum := sha256.New()
+	var counter *countingWriter
+	err := writeOutput(cmd, output, func(w io.Writer) error {
+		counter = &countingWriter{w: io.MultiWriter(w, sum)}
+		return archive.Write(cmd.Context(), counter, format, root, archiveSource{client: client, report: report})
+	})
+	report.clear()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case report.enc != nil:
+		report.emit(transferEvent{
+			Event:  "saved",
+			Name:   root.Name,
+			Path:   output,
+			CID:    root.CID,
+			Dir:    root.Dir,
+			Size:   counter.n,
+			SHA256: fmt.Sprintf("%x", sum.Sum(nil)),
+		})
+		return nil
+	case report.quiet:
+		return nil
+	case output == "-":
+		fmt.Fprintf(cmd.ErrOrStderr(), "sha256 %x\n", sum.Sum(nil))
+		return nil
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Saved %s (%d bytes)\n", output, counter.n)
+	fmt.Fprintf(cmd.OutOrStdout(), "sha256 %x\n", sum.Sum(nil))
+	return nil
+}
+
+// archiveSource reads the tree archive.Write streams from the daemon.
+type archiveSource struct {
+	client *api.Client
+	report *transferReport
+}
+
+func (s archiveSource) List(ctx context.Context, c string) ([]archive.Entry, error) {
+	dir, err := s.client.ListDirectory(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]archive.Entry, len(dir.Entries))
+	for i, e := range dir.Entries {
+		entries[i] = archive.Entry{Name: e.Name, CID: e.CID, Dir: e.Dir}
+	}
+	return entries, nil
+}
+
+func (s archiveSource) Open(ctx context.Context, c string) (io.Reader, int64, error) {
+	stream, err := s.client.Get(ctx, c, s.report.track(c, 0, true))
+	if err != nil {
+		return nil, 0, err
+	}
+	s.report.setFile(stream.Name, stream.Size, stream.Chunks)
+	return stream, stream.Size, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func getFile(cmd *cobra.Command, client *api.Client, report *transferReport, c, dest string) error {
+	progress := report.track(dest, 0, true)
+	stream, err := client.Get(cmd.Context(), c, progress)
+	if err != nil {
+		return err
+	}
+	report.setFile(filepath.Base(dest), stream.Size, stream.Chunks)
+	err = writeOutput(cmd, dest, func(w io.Writer) error {
+		_, err := stream.WriteTo(w)
+		return err
+	})
+	report.clear()
+	return err
+}
+
+// writeOutput has write write to output, or to stdout for "-". A file is
+// written under a temporary name next to it and only renamed to output
+// once written and closed, so a failed write, a full disk showing up as
+// late as Close, leaves no truncated file looking like a good one.
+// Devices and pipes are written to as they are.
+func writeOutput(cmd *cobra.Command, output string, write func(io.Writer) error) (err error) {
+	if output == "-" {
+		return write(cmd.OutOrStdout())
+	}
+	if info, err := os.Stat(output); err == nil && !info.Mode().IsRegular() && !info.IsDir() {
+		f, err := os.OpenFile(output, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		if err := write(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".part-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), output)
+}
+
 func init() {
+	getCmd.Flags().StringP("output", "o", "", "output path, - for stdout")
+	getCmd.Flags().String("archive", "", "stream a file or directory as a tar or zip archive")
+	addTransferFlags(getCmd, "print nothing but errors")
+
 	rootCmd.AddCommand(getCmd)
 }
@@ -0,0 +1,575 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/config"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A repository is stored as a directory holding the refs, in the format
+// of the remote helper's list command, and the packs pushed to it, each
+// with the objects the refs before it didn't reach.
+const (
+	gitRefsFile = "refs"
+	gitPacksDir = "packs"
+)
+
+var gitCmd = &cobra.Command{
+	Use:   "git",
+	Short: "Push and fetch git repositories over DFS",
+	Long: `Git repositories can be kept in DFS and pushed and fetched like any other
+remote, with the git-remote-dfs helper on the PATH; it is built from
+cmd/git-remote-dfs and runs "dfs git remote-helper" for git.
+
+A remote named dfs://<key> is published under the name of this node's key
+so called, created the first time it is used, and can be pushed to:
+
+  git remote add origin dfs://myproject
+  git push origin main
+
+Others clone and fetch it by that name, which "dfs name resolve" or the
+push prints:
+
+  git clone dfs://<name>
+
+Every push stores the objects the remote didn't have yet as a git pack,
+and publishes the refs and packs as a directory that replaces the last
+one's pin. Updates that don't fast-forward are refused unless forced.
+The config and daemon are those of the dfs command, set with DFS_CONFIG
+and DFS_API for git.`,
+}
+
+var gitRemoteHelperCmd = &cobra.Command{
+	Use:   "remote-helper <remote> <url>",
+	Short: "Speak the git remote helper protocol on stdin and stdout",
+	Long: `Remote-helper is what git-remote-dfs runs, for git to fetch from and push
+to dfs:// remotes; see "dfs git" and gitremote-helpers(7).`,
+	Args: cobra.ExactArgs(2),
+	// Stdout belongs to git, and the working tree to the user, so unlike
+	// other commands this one sets up no logging
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		cfg, err = config.LoadFromFlags(cmd.Flags())
+		return err
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r := &gitRemote{
+			cmd:     cmd,
+			in:      bufio.NewReader(cmd.InOrStdin()),
+			out:     cmd.OutOrStdout(),
+			log:     cmd.ErrOrStderr(),
+			remote:  args[0],
+			gitDir:  os.Getenv("GIT_DIR"),
+			fetched: make(map[string]bool),
+		}
+		if err := r.parseURL(args[1]); err != nil {
+			return err
+		}
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		r.client = client
+		return r.serve()
+	},
+}
+
+// gitRemote answers git for one remote.
+type gitRemote struct {
+	cmd    *cobra.Command
+	client *api.Client
+	in     *bufio.Reader
+	out    io.Writer
+	log    io.Writer
+
+	remote string
+	gitDir string
+	// key is the key the repository is published under when this node
+	// holds it, name the name of someone else's otherwise.
+	key, name string
+
+	// root is the directory the name points at, empty for a repository
+	// nothing was pushed to yet.
+	root  string
+	refs  gitRefs
+	packs []api.DirectoryEntry
+	// fetched holds the packs this repository has fetched already.
+	fetched map[string]bool
+}
+
+// gitRefs are the refs of a repository, with the ref HEAD points at.
+type gitRefs struct {
+	head string
+	refs map[string]string
+}
+
+func (r *gitRemote) parseURL(s string) error {
+	rest, ok := strings.CutPrefix(s, "dfs://")
+	rest = strings.TrimSuffix(rest, "/")
+	if !ok || rest == "" || strings.Contains(rest, "/") {
+		return fmt.Errorf("%q is not a dfs://<key> or dfs://<name> URL", s)
+	}
+	if _, err := ipns.NameFromString(rest); err == nil {
+		r.name = rest
+	} else {
+		r.key = rest
+	}
+	return nil
+}
+
+func (r *gitRemote) serve() error {
+	for {
+		line, err := r.readLine()
+		if err == io.EOF || (err == nil && line == "") {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch cmd, arg, _ := strings.Cut(line, " "); cmd {
+		case "capabilities":
+			fmt.Fprint(r.out, "fetch\npush\n\n")
+		case "list":
+			if err := r.list(); err != nil {
+				return err
+			}
+		case "fetch":
+			if err := r.fetch(r.batch(arg)); err != nil {
+				return err
+			}
+		case "push":
+			if err := r.push(r.batch(arg)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported command %q", line)
+		}
+	}
+}
+
+func (r *gitRemote) readLine() (string, error) {
+	line, err := r.in.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// batch returns the arguments of a batch of commands, which ends with a
+// blank line, starting with first.
+func (r *gitRemote) batch(first string) []string {
+	args := []string{first}
+	for {
+		line, err := r.readLine()
+		if err != nil || line == "" {
+			return args
+		}
+		_, arg, _ := strings.Cut(line, " ")
+		args = append(args, arg)
+	}
+}
+
+// load reads the refs and packs the repository's name points at.
+func (r *gitRemote) load() error {
+	ctx := r.cmd.Context()
+	var (
+		res *api.ResolveNameResponse
+		err error
+	)
+	if r.key != "" {
+		res, err = r.client.ResolveKey(ctx, r.key)
+	} else {
+		res, err = r.client.ResolveName(ctx, r.name)
+	}
+	switch {
+	case r.key != "" && status.Code(err) == codes.NotFound:
+		// Nothing pushed yet
+		r.root, r.refs, r.packs = "", gitRefs{refs: map[string]string{}}, nil
+		return nil
+	case err != nil:
+		return fmt.Errorf("resolving the repository: %w", err)
+	}
+
+	dir, err := r.client.ListDirectory(ctx, res.CID)
+	if err != nil {
+		return fmt.Errorf("%s is not a repository: %w", res.CID, err)
+	}
+	var refsFile, packsDir string
+	for _, e := range dir.Entries {
+		switch e.Name {
+		case gitRefsFile:
+			refsFile = e.CID
+		case gitPacksDir:
+			packsDir = e.CID
+		}
+	}
+	if refsFile == "" || packsDir == "" {
+		return fmt.Errorf("%s is not a repository: no %s and %s in it", res.CID, gitRefsFile, gitPacksDir)
+	}
+	packs, err := r.client.ListDirectory(ctx, packsDir)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := r.download(refsFile, &buf); err != nil {
+		return err
+	}
+	refs, err := parseGitRefs(buf.String())
+	if err != nil {
+		return fmt.Errorf("%s: %w", res.CID, err)
+	}
+	r.root, r.refs, r.packs = res.CID, refs, packs.Entries
+	return nil
+}
+
+func (r *gitRemote) download(c string, w io.Writer) error {
+	stream, err := r.client.Get(r.cmd.Context(), c, nil)
+	if err != nil {
+		return err
+	}
+	_, err = stream.WriteTo(w)
+	return err
+}
+
+func (r *gitRemote) list() error {
+	if err := r.load(); err != nil {
+		return err
+	}
+	fmt.Fprint(r.out, r.refs.String(), "\n")
+	return nil
+}
+
+// fetch indexes the packs not fetched before, unless every object wanted
+// is here already.
+func (r *gitRemote) fetch(args []string) error {
+	var missing bool
+	for _, arg := range args {
+		sha, _, _ := strings.Cut(arg, " ")
+		if !gitHasObject(sha) {
+			missing = true
+		}
+	}
+	if missing {
+		if err := r.loadFetched(); err != nil {
+			return err
+		}
+		for i, p := range r.packs {
+			if r.fetched[p.CID] {
+				continue
+			}
+			fmt.Fprintf(r.log, "Fetching pack %d of %d (%s)\n", i+1, len(r.packs), formatBytes(p.Size))
+			if err := r.indexPack(p.CID); err != nil {
+				return fmt.Errorf("pack %s: %w", p.Name, err)
+			}
+			r.fetched[p.CID] = true
+		}
+		if err := r.saveFetched(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(r.out, "\n")
+	return nil
+}
+
+func (r *gitRemote) indexPack(c string) error {
+	stream, err := r.client.Get(r.cmd.Context(), c, nil)
+	if err != nil {
+		return err
+	}
+	index := exec.CommandContext(r.cmd.Context(), "git", "index-pack", "--stdin")
+	index.Stdin = stream
+	index.Stderr = r.log
+	return index.Run()
+}
+
+// fetchedPath keeps the packs this repository fetched from the remote,
+// not to fetch them again.
+func (r *gitRemote) fetchedPath() string {
+	if r.gitDir == "" {
+		return ""
+	}
+	return filepath.Join(r.gitDir, "dfs", url.PathEscape(r.remote), "fetched")
+}
+
+func (r *gitRemote) loadFetched() error {
+	path := r.fetchedPath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	for _, c := range strings.Fields(string(data)) {
+		r.fetched[c] = true
+	}
+	return err
+}
+
+func (r *gitRemote) saveFetched() error {
+	path := r.fetchedPath()
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, c := range slices.Sorted(maps.Keys(r.fetched)) {
+		fmt.Fprintln(&b, c)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// push updates the refs as the refspecs say, storing the objects they
+// need in a new pack, and answers for every ref.
+func (r *gitRemote) push(specs []string) error {
+	ctx := r.cmd.Context()
+	if err := r.load(); err != nil {
+		return err
+	}
+	if r.key == "" {
+		return fmt.Errorf("dfs://%s is someone else's name, push to dfs://<key> with a key of this node", r.name)
+	}
+
+	refs := gitRefs{head: r.refs.head, refs: make(map[string]string, len(r.refs.refs))}
+	for ref, sha := range r.refs.refs {
+		refs.refs[ref] = sha
+	}
+	var (
+		results []string
+		updated []string
+		wants   []string
+	)
+	for _, spec := range specs {
+		force := strings.HasPrefix(spec, "+")
+		src, dst, _ := strings.Cut(strings.TrimPrefix(spec, "+"), ":")
+		if src == "" {
+			delete(refs.refs, dst)
+			updated = append(updated, dst)
+			continue
+		}
+		sha, err := gitOutput("rev-parse", "--verify", src)
+		if err != nil {
+			results = append(results, fmt.Sprintf("error %s %s is not an object", dst, src))
+			continue
+		}
+		if reason := updateRefused(dst, refs.refs[dst], sha, force); reason != "" {
+			results = append(results, fmt.Sprintf("error %s %s", dst, reason))
+			continue
+		}
+		refs.refs[dst] = sha
+		updated = append(updated, dst)
+		wants = append(wants, sha)
+	}
+	if refs.refs[refs.head] == "" {
+		refs.head = refs.defaultHead()
+	}
+
+	if len(updated) > 0 {
+		root, err := r.store(ctx, refs, wants)
+		if err != nil {
+			return err
+		}
+		if r.root != "" && r.root != root {
+			if _, err := r.client.UpdatePin(ctx, r.root, root); err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+		res, err := r.client.PublishName(ctx, r.key, root)
+		if err != nil {
+			return err
+		}
+		if res.Warning != "" {
+			fmt.Fprintf(r.log, "warning: %s, will retry when republishing\n", res.Warning)
+		}
+		fmt.Fprintf(r.log, "Published %s -> %s\n", res.Name, root)
+		for _, ref := range updated {
+			results = append(results, "ok "+ref)
+		}
+		r.root, r.refs = root, refs
+	}
+	for _, res := range results {
+		fmt.Fprintln(r.out, res)
+	}
+	fmt.Fprint(r.out, "\n")
+	return nil
+}
+
+// updateRefused returns why ref may not move from old to sha, empty when
+// it may.
+func updateRefused(ref, old, sha string, force bool) string {
+	switch {
+	case force || old == "" || old == sha:
+		return ""
+	case strings.HasPrefix(ref, "refs/tags/"):
+		return "already exists"
+	case !gitHasObject(old):
+		return "fetch first"
+	case exec.Command("git", "merge-base", "--is-ancestor", old, sha).Run() != nil:
+		return "non-fast-forward"
+	}
+	return ""
+}
+
+// store adds a pack with the objects wants reach that the remote doesn't
+// have yet, and the directory of the repository with refs, pinned.
+func (r *gitRemote) store(ctx context.Context, refs gitRefs, wants []string) (string, error) {
+	packs := slices.Clone(r.packs)
+	if len(wants) > 0 {
+		pack, err := r.addPack(ctx, len(packs)+1, wants)
+		if err != nil {
+			return "", err
+		}
+		if pack != nil {
+			packs = append(packs, *pack)
+		}
+	}
+
+	refsFile, err := r.client.Add(ctx, &api.AddRequest{Name: gitRefsFile, NoPin: true}, strings.NewReader(refs.String()), nil)
+	if err != nil {
+		return "", err
+	}
+	packsDir, err := r.client.MakeDirectory(ctx, &api.MakeDirectoryRequest{Entries: packs, NoPin: true})
+	if err != nil {
+		return "", err
+	}
+	root, err := r.client.MakeDirectory(ctx, &api.MakeDirectoryRequest{Entries: []api.DirectoryEntry{
+		{Name: gitRefsFile, CID: refsFile.CID},
+		{Name: gitPacksDir, CID: packsDir.CID},
+	}})
+	if err != nil {
+		return "", err
+	}
+	return root.CID, nil
+}
+
+// addPack adds the pack of the objects wants reach and the remote's refs
+// don't, nil when there are none.
+func (r *gitRemote) addPack(ctx context.Context, n int, wants []string) (*api.DirectoryEntry, error) {
+	revs := slices.Clone(wants)
+	for _, sha := range r.refs.refs {
+		// Objects the remote has that aren't here can't be left out
+		if gitHasObject(sha) {
+			revs = append(revs, "^"+sha)
+		}
+	}
+	pack := exec.CommandContext(ctx, "git", "pack-objects", "--stdout", "--revs", "--delta-base-offset", "-q")
+	pack.Stdin = strings.NewReader(strings.Join(revs, "\n") + "\n")
+	pack.Stderr = r.log
+	stdout, err := pack.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := pack.Start(); err != nil {
+		return nil, err
+	}
+
+	// The header counts the objects in the pack
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(stdout, header); err != nil {
+		pack.Wait()
+		return nil, fmt.Errorf("git pack-objects: %w", err)
+	}
+	if binary.BigEndian.Uint32(header[8:]) == 0 {
+		io.Copy(io.Discard, stdout)
+		return nil, pack.Wait()
+	}
+	name := fmt.Sprintf("%08d.pack", n)
+	res, err := r.client.Add(ctx, &api.AddRequest{Name: name, NoPin: true}, io.MultiReader(bytes.NewReader(header), stdout), nil)
+	if werr := pack.Wait(); err == nil && werr != nil {
+		err = fmt.Errorf("git pack-objects: %w", werr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(r.log, "Stored %s of objects\n", formatBytes(res.Size))
+	return &api.DirectoryEntry{Name: name, CID: res.CID}, nil
+}
+
+func parseGitRefs(s string) (gitRefs, error) {
+	refs := gitRefs{refs: make(map[string]string)}
+	for _, line := range strings.Split(s, "\n") {
+		if line == "" {
+			continue
+		}
+		value, ref, ok := strings.Cut(line, " ")
+		switch {
+		case !ok || value == "":
+			return gitRefs{}, fmt.Errorf("malformed refs line %q", line)
+		case ref == "HEAD" && value[0] == '@':
+			refs.head = value[1:]
+		default:
+			refs.refs[ref] = value
+		}
+	}
+	return refs, nil
+}
+
+// String formats the refs as the list command answers with them.
+func (g gitRefs) String() string {
+	var b strings.Builder
+	if g.head != "" && g.refs[g.head] != "" {
+		fmt.Fprintf(&b, "@%s HEAD\n", g.head)
+	}
+	names := make([]string, 0, len(g.refs))
+	for ref := range g.refs {
+		names = append(names, ref)
+	}
+	slices.Sort(names)
+	for _, ref := range names {
+		fmt.Fprintf(&b, "%s %s\n", g.refs[ref], ref)
+	}
+	return b.String()
+}
+
+// defaultHead picks the branch HEAD points at in a repository it doesn't
+// point at any yet: main or master if there is one, else the first.
+func (g gitRefs) defaultHead() string {
+	for _, ref := range []string{"refs/heads/main", "refs/heads/master"} {
+		if g.refs[ref] != "" {
+			return ref
+		}
+	}
+	var first string
+	for ref := range g.refs {
+		if strings.HasPrefix(ref, "refs/heads/") && (first == "" || ref < first) {
+			first = ref
+		}
+	}
+	return first
+}
+
+func gitHasObject(sha string) bool {
+	return exec.Command("git", "cat-file", "-e", sha).Run() == nil
+}
+
+func gitOutput(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func init() {
+	gitCmd.AddCommand(gitRemoteHelperCmd)
+	rootCmd.AddCommand(gitCmd)
+}
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Share encrypted files with a group of nodes",
+	Long: `Groups let a team read each other's encrypted files without sharing a
+secret for good. Files added with "dfs add --group <name>" are encrypted
+with the group's key, which every member's node holds, sealed to its node
+key, in a document the admin publishes under a name.
+
+The node that creates a group is its admin, and adds and removes members.
+A member removed loses the group's keys, and the group moves on to a new
+key only the remaining members get: files added from then on are out of
+its reach. Files added before stay as they are, encrypted with the key
+they were added with, rather than being encrypted again.
+
+Members join with the name "dfs group create" prints, and pick up changes
+to the group by joining again; adding files for it does so too.`,
+}
+
+var groupCreateCmd = &cobra.Command{
+	Use:   "create <group> [peer...]",
+	Short: "Create a group administered by this node",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.CreateGroup(cmd.Context(), &api.CreateGroupRequest{Name: args[0], Members: args[1:]})
+		if err != nil {
+			return err
+		}
+		printGroup(cmd, res, "Created")
+		fmt.Fprintf(cmd.OutOrStdout(), "Members join with: dfs group join %s\n", res.Group.Name)
+		return nil
+	},
+}
+
+var groupAddCmd = &cobra.Command{
+	Use:   "add <group> <peer>...",
+	Short: "Add members to a group",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateGroup(cmd, &api.UpdateGroupRequest{Name: args[0], Add: args[1:]})
+	},
+}
+
+var groupRmCmd = &cobra.Command{
+	Use:   "rm <group> <peer>...",
+	Short: "Remove members from a group, moving it on to a new key",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateGroup(cmd, &api.UpdateGroupRequest{Name: args[0], Remove: args[1:]})
+	},
+}
+
+var groupJoinCmd = &cobra.Command{
+	Use:   "join <name>",
+	Short: "Join a group, or pick up its changes",
+	Long: `Join looks up the group document published under a name, the one "dfs
+group create" printed, and keeps it once it checked this node is a
+member. Files added for the group can then be read like any other.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.JoinGroup(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		printGroup(cmd, res, "Joined")
+		return nil
+	},
+}
+
+var groupLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the groups this node created or joined",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListGroups(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if members, _ := cmd.Flags().GetBool("members"); members {
+			for _, g := range res.Groups {
+				fmt.Fprintf(out, "%s (%s)\n", g.Group, g.Name)
+				for _, m := range g.Members {
+					role := ""
+					if m == g.Admin {
+						role = " (admin)"
+					}
+					fmt.Fprintf(out, "  %s%s\n", m, role)
+				}
+			}
+			return nil
+		}
+		fmt.Fprintf(out, "%-20s  %-52s  %7s  %4s  %s\n", "GROUP", "ADMIN", "MEMBERS", "KEYS", "UPDATED")
+		for _, g := range res.Groups {
+			fmt.Fprintf(out, "%-20s  %-52s  %7d  %4d  %s\n",
+				g.Group, g.Admin, len(g.Members), g.Keys, g.Updated.Local().Format(time.DateTime))
+		}
+		return nil
+	},
+}
+
+func updateGroup(cmd *cobra.Command, req *api.UpdateGroupRequest) error {
+	client, err := dialDaemon(cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	before, err := client.ListGroups(cmd.Context())
+	if err != nil {
+		return err
+	}
+	res, err := client.UpdateGroup(cmd.Context(), req)
+	if err != nil {
+		return err
+	}
+	printGroup(cmd, res, "Updated")
+	for _, g := range before.Groups {
+		if g.Group == req.Name && g.KeyID != res.Group.KeyID {
+			fmt.Fprintf(cmd.OutOrStdout(), "Files added from now on use the new key %s; earlier ones keep theirs\n", res.Group.KeyID)
+		}
+	}
+	return nil
+}
+
+func printGroup(cmd *cobra.Command, res *api.GroupResponse, verb string) {
+	g := res.Group
+	fmt.Fprintf(cmd.OutOrStdout(), "%s group %s: %d members (%s), key %s\n",
+		verb, g.Group, len(g.Members), strings.Join(g.Members, ", "), g.KeyID)
+	if res.Warning != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %s\n", res.Warning)
+	}
+}
+
+func init() {
+	groupLsCmd.Flags().Bool("members", false, "list the members of each group")
+
+	groupCmd.AddCommand(groupCreateCmd)
+	groupCmd.AddCommand(groupAddCmd)
+	groupCmd.AddCommand(groupRmCmd)
+	groupCmd.AddCommand(groupJoinCmd)
+	groupCmd.AddCommand(groupLsCmd)
+	rootCmd.AddCommand(groupCmd)
+}
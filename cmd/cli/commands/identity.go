@@ -0,0 +1,315 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/keystore"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Show, rotate or revoke the node identity",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ks, err := identityKeystore(cmd)
+		if err != nil {
+			return err
+		}
+		path := identityPath(cmd)
+
+		var priv crypto.PrivKey
+		if ks != nil {
+			priv, err = ks.Identity()
+			path = "keystore " + ks.Path()
+		} else {
+			priv, err = network.LoadOrCreateIdentity(path)
+		}
+		if err != nil {
+			return err
+		}
+		id, err := network.IdentityPeerID(priv)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Peer ID: %s\nKey:     %s\n", id, path)
+		return nil
+	},
+}
+
+var identityRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the node key with a new one",
+	Long: `Rotate generates a new node key, which gives the node a new peer ID.
+The previous key is kept next to the new one with a timestamp suffix, or
+retired in the keystore when there is one. Restart the daemon for the new
+identity to take effect.
+
+The previous key signs a continuity record naming the new peer ID, which
+the daemon announces to the cluster. Peers that see it give the new ID what
+they granted the old one: allow and deny rules, the replicas they keep for
+it, and the node's name, which then resolves to what the new ID publishes.
+
+--dry-run shows the peer ID that would be given up, without rotating.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ks, err := identityKeystore(cmd)
+		if err != nil {
+			return err
+		}
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			return identityRotateDryRun(cmd, ks)
+		}
+		var (
+			old, priv crypto.PrivKey
+			retired   string
+		)
+		if ks != nil {
+			if old, err = ks.Identity(); err != nil {
+				return err
+			}
+			if _, _, err := ks.Rotate(keystore.KindIdentity, keystore.IdentityName); err != nil {
+				return err
+			}
+			if priv, err = ks.Identity(); err != nil {
+				return err
+			}
+			retired = "Previous key retired in the keystore"
+		} else {
+			path := identityPath(cmd)
+			old, err = network.LoadIdentity(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+
+			var backup string
+			if priv, backup, err = network.RotateIdentity(path); err != nil {
+				return err
+			}
+			if backup != "" {
+				retired = "Previous key saved to " + backup
+			}
+		}
+
+		id, err := network.IdentityPeerID(priv)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "New peer ID: %s\n", id)
+		if retired != "" {
+			fmt.Fprintln(out, retired)
+		}
+		if old == nil {
+			return nil
+		}
+		return recordContinuity(cmd, old, priv)
+	},
+}
+
+// recordContinuity has the previous node key sign over to the new one and
+// keeps the record for the daemon to announce.
+func recordContinuity(cmd *cobra.Command, old, priv crypto.PrivKey) error {
+	c, err := network.NewContinuity(old, priv, time.Now())
+	if err != nil {
+		return fmt.Errorf("identity rotated, but not signed over: %w", err)
+	}
+	successions, err := network.OpenSuccessions(cfg.SuccessionsPath())
+	if err == nil {
+		_, err = successions.Add(*c)
+	}
+	if err != nil {
+		return fmt.Errorf("identity rotated, but the continuity record wasn't kept: %w", err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Signed over from %s; peers carry its allow rules, replicas and names over\n", c.Old)
+	return nil
+}
+
+var identityRevokeCmd = &cobra.Command{
+	Use:   "revoke [revocation-file]",
+	Short: "Revoke a node key cluster-wide",
+	Long: `Revoke retires a node key for good, for when it is lost or compromised.
+The key signs its own revocation, which the daemon announces to the
+cluster. Peers that see it refuse connections from the key's peer ID and
+stop honouring what it signs: allow rules, replica requests and cluster
+events, shares and names. A rotation away from the key holds only on peers
+that learned of it before the time of the revocation, so rotate first and
+revoke after to keep the node's grants; a rotation announced later, or
+dated back, is refused.
+
+Without a file, revoke signs the revocation of the node key, or of the key
+file given with --identity, such as a key saved by identity rotate. With
+--output the revocation is written to a file instead, to keep offline and
+submit later by passing the file, even once the key itself is lost.
+
+When the daemon isn't running the revocation is kept for it to announce
+when it starts.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r *network.Revocation
+		if len(args) == 1 {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			r = new(network.Revocation)
+			if err := json.Unmarshal(data, r); err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+		} else {
+			priv, err := loadIdentity(cmd)
+			if err != nil {
+				return err
+			}
+			reason, _ := cmd.Flags().GetString("reason")
+			if r, err = network.NewRevocation(priv, time.Now(), reason); err != nil {
+				return err
+			}
+		}
+		if _, err := r.Verify(); err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if output, _ := cmd.Flags().GetString("output"); output != "" {
+			data, err := json.MarshalIndent(r, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(output, data, 0600); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Revocation of %s written to %s\n", r.Peer, output)
+			return nil
+		}
+		return submitRevocation(cmd, r)
+	},
+}
+
+// submitRevocation has the daemon honour and announce r, or keeps r for
+// the daemon to pick up when it starts.
+func submitRevocation(cmd *cobra.Command, r *network.Revocation) error {
+	out := cmd.OutOrStdout()
+
+	client, err := dialDaemon(cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	res, err := client.RevokeKey(cmd.Context(), r)
+	if status.Code(err) != codes.Unavailable {
+		if err != nil {
+			return err
+		}
+		if !res.Added {
+			fmt.Fprintf(out, "%s was already revoked\n", r.Peer)
+			return nil
+		}
+		fmt.Fprintf(out, "Revoked %s and announced it to the cluster\n", r.Peer)
+		return nil
+	}
+
+	revocations, err := network.OpenRevocations(cfg.RevocationsPath())
+	if err != nil {
+		return err
+	}
+	added, err := revocations.Add(*r)
+	if err != nil {
+		return err
+	}
+	if !added {
+		fmt.Fprintf(out, "%s was already revoked\n", r.Peer)
+		return nil
+	}
+	fmt.Fprintf(out, "Revoked %s; the daemon announces it when it starts\n", r.Peer)
+	return nil
+}
+
+// identityRotateDryRun shows what identity rotate would replace.
+func identityRotateDryRun(cmd *cobra.Command, ks *keystore.Keystore) error {
+	out := cmd.OutOrStdout()
+	fmt.Fprintln(out, "Dry run, nothing was changed.")
+
+	if ks != nil {
+		key, _, err := ks.Get(keystore.KindIdentity, keystore.IdentityName)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Would retire peer ID %s in the keystore for a new one\n", key.ID)
+		fmt.Fprintln(out, "Would sign a continuity record from it to the new one")
+		return nil
+	}
+
+	path := identityPath(cmd)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(out, "Would create a node key at %s\n", path)
+		return nil
+	}
+	priv, err := network.LoadIdentity(path)
+	if err != nil {
+		return err
+	}
+	id, err := network.IdentityPeerID(priv)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Would replace peer ID %s with a new one\n", id)
+	fmt.Fprintf(out, "Would save the key at %s to %s.<timestamp>.old\n", path, path)
+	fmt.Fprintln(out, "Would sign a continuity record from it to the new one")
+	return nil
+}
+
+// loadIdentity reads the node key from the keystore, or from its file when
+// there is no keystore or --identity is given.
+func loadIdentity(cmd *cobra.Command) (crypto.PrivKey, error) {
+	ks, err := identityKeystore(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if ks != nil {
+		return ks.Identity()
+	}
+	return network.LoadIdentity(identityPath(cmd))
+}
+
+// identityKeystore opens the keystore holding the node key, nil when
+// there is none or --identity names a key file.
+func identityKeystore(cmd *cobra.Command) (*keystore.Keystore, error) {
+	if cmd.Flags().Changed("identity") {
+		return nil, nil
+	}
+	return openKeystore()
+}
+
+// identityPath prefers --identity over the configured key location.
+func identityPath(cmd *cobra.Command) string {
+	if cmd.Flags().Changed("identity") {
+		path, _ := cmd.Flags().GetString("identity")
+		return path
+	}
+	return cfg.IdentityPath()
+}
+
+func init() {
+	identityCmd.PersistentFlags().String("identity", "", "path to the node key (default from config)")
+
+	identityRotateCmd.Flags().Bool("dry-run", false, "show the peer ID that would be replaced, without rotating")
+
+	identityRevokeCmd.Flags().String("reason", "", "why the key is revoked, announced with the revocation")
+	identityRevokeCmd.Flags().StringP("output", "o", "", "write the revocation to this file instead of submitting it")
+
+	identityCmd.AddCommand(identityRotateCmd)
+	identityCmd.AddCommand(identityRevokeCmd)
+	rootCmd.AddCommand(identityCmd)
+}
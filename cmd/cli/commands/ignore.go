@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile lists the files "dfs sync" leaves out, in the top directory
+// being synced.
+const ignoreFile = ".dfsignore"
+
+// ignoreRules are the patterns of an ignore file. They follow .gitignore
+// loosely: one pattern per line, # starts a comment, a pattern ending in /
+// only matches directories, one starting with ! takes a file back in, and
+// the last matching pattern wins. A pattern without a slash matches names
+// at any depth, one with a slash matches paths from the top directory.
+// * and ? don't match slashes.
+type ignoreRules struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored patterns match the whole relative path, others the name.
+	anchored bool
+}
+
+// loadIgnore reads the ignore file in dir. A missing file ignores nothing.
+func loadIgnore(dir string) (*ignoreRules, error) {
+	f, err := os.Open(filepath.Join(dir, ignoreFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &ignoreRules{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ig := &ignoreRules{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := ig.add(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	return ig, scanner.Err()
+}
+
+// add adds the pattern on a line of an ignore file.
+func (ig *ignoreRules) add(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	var r ignoreRule
+	if r.negate = strings.HasPrefix(line, "!"); r.negate {
+		line = line[1:]
+	}
+	if r.dirOnly = strings.HasSuffix(line, "/"); r.dirOnly {
+		line = strings.TrimSuffix(line, "/")
+	}
+	r.anchored = strings.Contains(line, "/")
+	r.pattern = strings.TrimPrefix(line, "/")
+	// Reject bad patterns now rather than on every match
+	if _, err := path.Match(r.pattern, ""); err != nil {
+		return err
+	}
+	ig.rules = append(ig.rules, r)
+	return nil
+}
+
+// match reports whether rel, a slash-separated path below the top
+// directory, is ignored.
+func (ig *ignoreRules) match(rel string, dir bool) bool {
+	if ig == nil {
+		return false
+	}
+
+	ignored := false
+	for _, r := range ig.rules {
+		if r.dirOnly && !dir {
+			continue
+		}
+		name := rel
+		if !r.anchored {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(r.pattern, name); ok {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
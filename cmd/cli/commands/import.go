@@ -0,0 +1,377 @@
+package commands
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/importer"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Load content from another machine or an IPFS repo",
+}
+
+var importSSHCmd = &cobra.Command{
+	Use:   "ssh [user@]host:path",
+	Short: "Import a remote directory over SSH",
+	Long: `Import loads a directory on another machine, a NAS for one, over
+SSH. The files are streamed from a tar run there straight to the daemon,
+without a copy on the local disk, and put in a directory that is pinned.
+The remote machine needs a POSIX shell, find, stat and tar. Only regular
+files are imported; symlinks, empty directories and special files are
+left out.
+
+  dfs import ssh backup@nas.local:/volume1/photos
+
+Every minute, or as often as --checkpoint says, the files imported so far
+are pinned as a directory in place of the one before, so an interrupted
+import keeps them. Running it again resumes: files imported with the same
+size and modification time are not sent again, and the pin is moved to
+the complete directory. Later runs import what changed since the same
+way, and leave out what was removed. --restart imports every file again.
+
+The ssh command can be set with --rsh, which is split on spaces:
+
+  dfs import ssh --rsh "ssh -p 2222 -i ~/.ssh/nas" nas:/share
+
+Files are chunked as the config says, or with --profile. Changed files
+imported with the paged profile only have their changed chunks hashed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		remote, err := importer.ParseRemote(args[0])
+		if err != nil {
+			return err
+		}
+		rsh, _ := cmd.Flags().GetString("rsh")
+		if len(strings.Fields(rsh)) == 0 {
+			return fmt.Errorf("--rsh is empty")
+		}
+		every, _ := cmd.Flags().GetDuration("checkpoint")
+		if every <= 0 {
+			return fmt.Errorf("--checkpoint must be positive")
+		}
+
+		params := cfg.Chunking.Params()
+		if profile, _ := cmd.Flags().GetString("profile"); profile != "" {
+			compression := params.Compression
+			if params, err = chunking.Profile(profile); err != nil {
+				return err
+			}
+			params.Compression = compression
+		}
+		if err := params.Validate(); err != nil {
+			return err
+		}
+
+		report, err := newTransferReport(cmd, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+
+		journal, err := importer.Open(cfg.ImportsPath())
+		if err != nil {
+			return err
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		imp := &sshImport{
+			cmd:     cmd,
+			client:  client,
+			rsh:     strings.Fields(rsh),
+			remote:  remote,
+			params:  &params,
+			journal: journal,
+			report:  report,
+			every:   every,
+		}
+		restart, _ := cmd.Flags().GetBool("restart")
+		if err := imp.load(restart); err != nil {
+			return err
+		}
+		if err := imp.run(); err != nil {
+			return err
+		}
+
+		if report.enc != nil {
+			report.emit(transferEvent{Event: "added", Name: remote.String(), CID: imp.job.Root, Dir: true})
+			return nil
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), imp.job.Root)
+		return nil
+	},
+}
+
+// sshImport is a run of "dfs import ssh".
+type sshImport struct {
+	cmd     *cobra.Command
+	client  *api.Client
+	rsh     []string
+	remote  importer.Remote
+	params  *chunking.Params
+	journal *importer.Journal
+	report  *transferReport
+	every   time.Duration
+
+	job  importer.Job
+	tree *importer.Tree
+	// dirty reports whether the tree changed since the last checkpoint.
+	dirty bool
+}
+
+// load picks up the journal of an earlier run, as long as the directory
+// it pinned still is.
+func (imp *sshImport) load(restart bool) error {
+	source := imp.remote.String()
+	job, ok := imp.journal.Get(source)
+	if !ok {
+		job = importer.Job{Source: source}
+	}
+	if job.Root != "" {
+		pinned, err := imp.pinned(job.Root)
+		if err != nil {
+			return err
+		}
+		if !pinned {
+			fmt.Fprintf(imp.cmd.ErrOrStderr(), "%s was unpinned since the last import, importing everything again\n", job.Root)
+			job.Root = ""
+			restart = true
+		}
+	}
+	if restart {
+		job.Files = nil
+	}
+	if job.Files == nil {
+		job.Files = make(map[string]importer.ImportedFile)
+	}
+	if ok && !job.Done && len(job.Files) > 0 && imp.report.verbose() {
+		fmt.Fprintf(imp.cmd.ErrOrStderr(), "Resuming the import of %s, %d files imported\n", source, len(job.Files))
+	}
+
+	imp.job = job
+	imp.tree = importer.NewTree()
+	for rel, f := range job.Files {
+		imp.tree.Set(rel, f.CID)
+	}
+	imp.dirty = true
+	return nil
+}
+
+func (imp *sshImport) pinned(c string) (bool, error) {
+	pins, err := imp.client.ListPins(imp.cmd.Context())
+	if err != nil {
+		return false, err
+	}
+	for _, p := range pins.Pins {
+		if p.CID == c {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// run lists the remote files and imports those not imported yet,
+// checkpointing on the way and at the end, also when it fails.
+func (imp *sshImport) run() (err error) {
+	ctx := imp.cmd.Context()
+
+	listing, err := imp.remoteOutput(ctx, imp.remote.ListCommand())
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", imp.remote, err)
+	}
+	files, err := importer.ParseListing(bytes.NewReader(listing))
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", imp.remote, err)
+	}
+
+	listed := make(map[string]bool, len(files))
+	var names bytes.Buffer
+	for _, f := range files {
+		listed[f.Path] = true
+		if prev, ok := imp.job.Files[f.Path]; !ok || !prev.Current(f) {
+			names.WriteString("./" + f.Path + "\n")
+		}
+	}
+	for rel := range imp.job.Files {
+		if !listed[rel] {
+			delete(imp.job.Files, rel)
+			imp.tree.Remove(rel)
+		}
+	}
+	imp.job.Done = false
+
+	defer func() {
+		// What was imported is kept even if the run was interrupted
+		if cerr := imp.checkpoint(context.WithoutCancel(ctx)); err == nil {
+			err = cerr
+		}
+	}()
+	if names.Len() == 0 {
+		imp.job.Done = true
+		return nil
+	}
+	if err := imp.stream(ctx, &names); err != nil {
+		return err
+	}
+	imp.job.Done = true
+	return nil
+}
+
+// stream imports the files named in names from a tar stream of them.
+func (imp *sshImport) stream(ctx context.Context, names io.Reader) error {
+	c := imp.remoteCommand(ctx, imp.remote.TarCommand())
+	c.Stdin = names
+	out, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	if err := imp.untar(ctx, tar.NewReader(out)); err != nil {
+		// Stop the remote tar rather than wait for a stream nobody reads
+		c.Process.Kill()
+		c.Wait()
+		return err
+	}
+	if err := c.Wait(); err != nil {
+		return fmt.Errorf("tar on %s: %w", imp.remote.Host, err)
+	}
+	return nil
+}
+
+// untar adds the regular files in tr, checkpointing every imp.every.
+func (imp *sshImport) untar(ctx context.Context, tr *tar.Reader) error {
+	last := time.Now()
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tar stream from %s: %w", imp.remote.Host, err)
+		}
+		rel := importer.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || rel == "" {
+			continue
+		}
+		if err := imp.addFile(ctx, rel, hdr, tr); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if time.Since(last) >= imp.every {
+			if err := imp.checkpoint(ctx); err != nil {
+				return err
+			}
+			last = time.Now()
+		}
+	}
+}
+
+func (imp *sshImport) addFile(ctx context.Context, rel string, hdr *tar.Header, r io.Reader) error {
+	req := &api.AddRequest{Name: path.Base(rel), Chunking: imp.params, NoPin: true}
+	prev, had := imp.job.Files[rel]
+	if had {
+		req.Base = prev.CID
+	}
+	progress := imp.report.track(rel, hdr.Size, false)
+	res, err := imp.client.Add(ctx, req, r, progress)
+	imp.report.clear()
+	if err != nil {
+		return err
+	}
+
+	imp.job.Files[rel] = importer.ImportedFile{Size: hdr.Size, ModTime: hdr.ModTime.Unix(), CID: res.CID}
+	imp.tree.Set(rel, res.CID)
+	imp.dirty = true
+	switch {
+	case imp.report.enc != nil:
+		imp.report.emit(transferEvent{Event: "added", Name: rel, CID: res.CID, Size: res.Size, Chunks: res.Chunks})
+	case !imp.report.quiet:
+		fmt.Fprintf(imp.cmd.OutOrStdout(), "%s  %s\n", res.CID, rel)
+	}
+	return nil
+}
+
+// checkpoint makes the directories that changed and pins the top one in
+// place of the last, then records the job. Until then the files added
+// are only kept by their session pins, which a restart of the daemon
+// drops.
+func (imp *sshImport) checkpoint(ctx context.Context) error {
+	if !imp.dirty && imp.job.Root != "" {
+		return imp.save()
+	}
+	first := imp.job.Root == ""
+	root, err := imp.tree.Build(func(rel string, entries []importer.Entry) (string, error) {
+		req := &api.MakeDirectoryRequest{NoPin: rel != "" || !first}
+		for _, e := range entries {
+			req.Entries = append(req.Entries, api.DirectoryEntry{Name: e.Name, CID: e.CID})
+		}
+		res, err := imp.client.MakeDirectory(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		return res.CID, nil
+	})
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if !first && root != imp.job.Root {
+		if _, err := imp.client.UpdatePin(ctx, imp.job.Root, root); err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+	}
+	imp.job.Root = root
+	imp.dirty = false
+	return imp.save()
+}
+
+func (imp *sshImport) save() error {
+	imp.job.Updated = time.Now().UTC()
+	return imp.journal.Put(imp.job)
+}
+
+// remoteCommand runs the shell command script on the remote host.
+func (imp *sshImport) remoteCommand(ctx context.Context, script string) *exec.Cmd {
+	args := append(imp.rsh[1:len(imp.rsh):len(imp.rsh)], imp.remote.Host, script)
+	c := exec.CommandContext(ctx, imp.rsh[0], args...)
+	c.Stderr = imp.cmd.ErrOrStderr()
+	return c
+}
+
+func (imp *sshImport) remoteOutput(ctx context.Context, script string) ([]byte, error) {
+	c := imp.remoteCommand(ctx, script)
+	var out bytes.Buffer
+	c.Stdout = &out
+	if err := c.Run(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func init() {
+	importSSHCmd.Flags().String("rsh", "ssh", "command run to reach the remote host, split on spaces")
+	importSSHCmd.Flags().Duration("checkpoint", time.Minute, "how often the files imported so far are pinned")
+	importSSHCmd.Flags().String("profile", "", "chunking profile: "+chunking.ProfilePaged)
+	importSSHCmd.Flags().Bool("restart", false, "import every file again, not only new and changed ones")
+	addTransferFlags(importSSHCmd, "print only the hash of the directory, without progress")
+
+	importCmd.AddCommand(importSSHCmd)
+	rootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,250 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/Noah-Wilderom/dfs/pkg/ipfsrepo"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+// ipfsCIDLabel is the label an imported pin keeps its IPFS hash in.
+const ipfsCIDLabel = "ipfs.cid"
+
+var importIPFSCmd = &cobra.Command{
+	Use:   "ipfs",
+	Short: "Import the pinned content of an IPFS repo",
+	Long: `Import reads the repo of an IPFS (Kubo) node in place and adds what it
+pins to the daemon: every pinned file and directory tree is chunked anew
+into DFS blocks and pinned under the pin's name, with label ipfs.cid set
+to its IPFS hash. The files kept with "ipfs files", the MFS, are imported
+and pinned as "mfs" too, unless --mfs=false.
+
+  dfs import ipfs --repo ~/.ipfs --map ipfs-to-dfs.txt
+
+The IPFS daemon must be stopped, as it keeps its datastore locked. The
+repo must use the default flatfs blockstore and leveldb datastore. Only
+UnixFS files and directories are imported; symlinks are left out, and a
+pin of other content, or a direct pin of a directory whose entries the
+repo doesn't hold, is reported and skipped.
+
+Every pin imported is printed with its IPFS hash, then its new hash.
+--map writes the same for every file and directory imported, one
+"ipfs-hash dfs-hash" line each.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repoPath, _ := cmd.Flags().GetString("repo")
+		if repoPath == "" {
+			repoPath = os.Getenv("IPFS_PATH")
+		}
+		if repoPath == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			repoPath = filepath.Join(home, ".ipfs")
+		}
+
+		params := cfg.Chunking.Params()
+		if profile, _ := cmd.Flags().GetString("profile"); profile != "" {
+			compression := params.Compression
+			var err error
+			if params, err = chunking.Profile(profile); err != nil {
+				return err
+			}
+			params.Compression = compression
+		}
+		if err := params.Validate(); err != nil {
+			return err
+		}
+
+		report, err := newTransferReport(cmd, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+
+		repo, err := ipfsrepo.Open(repoPath)
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+		pins, err := repo.Pins()
+		if err != nil {
+			return err
+		}
+		if mfs, _ := cmd.Flags().GetBool("mfs"); mfs {
+			root, err := repo.FilesRoot()
+			if err != nil {
+				return err
+			}
+			pinned := slices.ContainsFunc(pins, func(p ipfsrepo.Pin) bool { return p.CID == root })
+			if root.Defined() && !pinned {
+				pins = append(pins, ipfsrepo.Pin{CID: root, Recursive: true, Name: "mfs"})
+			}
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		imp := &ipfsImport{
+			cmd:       cmd,
+			client:    client,
+			repo:      repo,
+			params:    &params,
+			report:    report,
+			converted: make(map[cid.Cid]api.DirectoryEntry),
+		}
+		skipped := 0
+		for _, p := range pins {
+			if err := imp.importPin(cmd.Context(), p); err != nil {
+				if !errors.Is(err, ipfsrepo.ErrNotFound) && !errors.Is(err, ipfsrepo.ErrUnsupported) {
+					return fmt.Errorf("%s: %w", p.CID, err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "Skipping %s: %v\n", p.CID, err)
+				skipped++
+			}
+		}
+
+		if mapPath, _ := cmd.Flags().GetString("map"); mapPath != "" {
+			if err := imp.writeMap(mapPath); err != nil {
+				return err
+			}
+		}
+		if skipped > 0 {
+			return fmt.Errorf("%d of %d pins not imported", skipped, len(pins))
+		}
+		return nil
+	},
+}
+
+// ipfsImport is a run of "dfs import ipfs".
+type ipfsImport struct {
+	cmd    *cobra.Command
+	client *api.Client
+	repo   *ipfsrepo.Repo
+	params *chunking.Params
+	report *transferReport
+
+	// converted maps the IPFS hash of every file and directory imported to
+	// the DFS one, so content in several pins is imported once.
+	converted map[cid.Cid]api.DirectoryEntry
+}
+
+// importPin imports the content of p and pins it.
+func (imp *ipfsImport) importPin(ctx context.Context, p ipfsrepo.Pin) error {
+	name := p.Name
+	if name == "" {
+		name = p.CID.String()
+	}
+	e, err := imp.convert(ctx, p.CID, name)
+	if err != nil {
+		return err
+	}
+	if e.CID == "" {
+		return fmt.Errorf("%w: a symlink", ipfsrepo.ErrUnsupported)
+	}
+	req := &api.PinRequest{CID: e.CID, Name: p.Name, Labels: map[string]string{ipfsCIDLabel: p.CID.String()}}
+	if err := imp.client.Pin(ctx, req); err != nil {
+		return err
+	}
+
+	switch {
+	case imp.report.enc != nil:
+		imp.report.emit(transferEvent{Event: "pinned", Name: p.Name, CID: e.CID, From: p.CID.String(), Dir: e.Dir, Size: e.Size})
+	case !imp.report.quiet:
+		fmt.Fprintln(imp.cmd.OutOrStdout(), strings.TrimSpace(p.CID.String()+"  "+e.CID+"  "+p.Name))
+	}
+	return nil
+}
+
+// convert adds the file or directory tree c, named name, unpinned. A
+// symlink is left out, with an empty entry.
+func (imp *ipfsImport) convert(ctx context.Context, c cid.Cid, name string) (api.DirectoryEntry, error) {
+	if e, ok := imp.converted[c]; ok {
+		return e, nil
+	}
+	n, err := imp.repo.Stat(c)
+	if err != nil {
+		return api.DirectoryEntry{}, err
+	}
+
+	var e api.DirectoryEntry
+	switch n.Kind {
+	case ipfsrepo.Symlink:
+		if imp.report.verbose() {
+			fmt.Fprintf(imp.cmd.ErrOrStderr(), "Skipping %s: symlinks are not imported\n", name)
+		}
+		return api.DirectoryEntry{}, nil
+
+	case ipfsrepo.File:
+		r, err := imp.repo.Reader(n)
+		if err != nil {
+			return api.DirectoryEntry{}, err
+		}
+		progress := imp.report.track(name, int64(n.Size), false)
+		res, err := imp.client.Add(ctx, &api.AddRequest{Name: name, Chunking: imp.params, NoPin: true}, r, progress)
+		imp.report.clear()
+		if err != nil {
+			return api.DirectoryEntry{}, fmt.Errorf("%s: %w", name, err)
+		}
+		e = api.DirectoryEntry{CID: res.CID, Size: res.Size}
+
+	case ipfsrepo.Directory:
+		entries, err := imp.repo.Entries(n)
+		if err != nil {
+			return api.DirectoryEntry{}, err
+		}
+		req := &api.MakeDirectoryRequest{NoPin: true}
+		for _, entry := range entries {
+			sub, err := imp.convert(ctx, entry.CID, entry.Name)
+			if err != nil {
+				return api.DirectoryEntry{}, fmt.Errorf("%s/%s: %w", name, entry.Name, err)
+			}
+			if sub.CID == "" {
+				continue
+			}
+			sub.Name = entry.Name
+			req.Entries = append(req.Entries, sub)
+		}
+		res, err := imp.client.MakeDirectory(ctx, req)
+		if err != nil {
+			return api.DirectoryEntry{}, fmt.Errorf("%s: %w", name, err)
+		}
+		e = api.DirectoryEntry{CID: res.CID, Size: res.Size, Dir: true}
+	}
+	imp.converted[c] = e
+	return e, nil
+}
+
+// writeMap writes the hashes of everything imported, IPFS one first.
+func (imp *ipfsImport) writeMap(path string) error {
+	lines := make([]string, 0, len(imp.converted))
+	for old, e := range imp.converted {
+		lines = append(lines, old.String()+" "+e.CID+"\n")
+	}
+	sort.Strings(lines)
+	return fsutil.AtomicWrite(path, []byte(strings.Join(lines, "")), 0644)
+}
+
+func init() {
+	importIPFSCmd.Flags().String("repo", "", "IPFS repo to import (default $IPFS_PATH or ~/.ipfs)")
+	importIPFSCmd.Flags().Bool("mfs", true, `import the files kept with "ipfs files" too`)
+	importIPFSCmd.Flags().String("map", "", "write the IPFS and DFS hashes of everything imported to this file")
+	importIPFSCmd.Flags().String("profile", "", "chunking profile: "+chunking.ProfilePaged)
+	addTransferFlags(importIPFSCmd, "print only errors")
+
+	importCmd.AddCommand(importIPFSCmd)
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var inboxCmd = &cobra.Command{
+	Use:   "inbox",
+	Short: "List, accept and reject what peers pushed",
+	Long: `What peers push with "dfs push" waits in the inbox until you accept it,
+which pins it, or reject it, which discards it. Until then it is kept from
+garbage collection but not pinned, so it isn't served or replicated as
+content this node keeps.
+
+Each push that comes in is logged and recorded in the event log.`,
+}
+
+var inboxLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List what waits in the inbox",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListInbox(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if len(res.Items) == 0 {
+			fmt.Fprintln(out, "Inbox is empty.")
+			return nil
+		}
+		fmt.Fprintf(out, "%-6s  %10s  %9s  %-52s  %-59s  %s\n", "ID", "SIZE", "AGE", "FROM", "CID", "NAME")
+		for _, item := range res.Items {
+			age := time.Since(item.Offered).Round(time.Second).String()
+			if item.Received.IsZero() {
+				age = "receiving"
+			}
+			fmt.Fprintf(out, "%-6d  %10s  %9s  %-52s  %-59s  %s\n", item.ID, formatBytes(item.Size), age, item.From, item.CID, item.Name)
+		}
+		return nil
+	},
+}
+
+var inboxAcceptCmd = &cobra.Command{
+	Use:   "accept <id>",
+	Short: "Pin a push waiting in the inbox",
+	Long: `Accept pins an item listed by "dfs inbox ls" under the name it was pushed
+with, and takes it out of the inbox. An item still being received can't be
+accepted yet.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid inbox item %q", args[0])
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.AcceptInbox(cmd.Context(), id)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Pinned %s\n", res.Item.CID)
+		return nil
+	},
+}
+
+var inboxRejectCmd = &cobra.Command{
+	Use:   "reject <id>",
+	Short: "Discard a push waiting in the inbox",
+	Long: `Reject takes an item listed by "dfs inbox ls" out of the inbox, stopping
+its fetch if it is still being received. Its blocks stay until garbage
+collection removes them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid inbox item %q", args[0])
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.RejectInbox(cmd.Context(), id)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Rejected %s\n", res.Item.CID)
+		return nil
+	},
+}
+
+func init() {
+	inboxCmd.AddCommand(inboxLsCmd)
+	inboxCmd.AddCommand(inboxAcceptCmd)
+	inboxCmd.AddCommand(inboxRejectCmd)
+	rootCmd.AddCommand(inboxCmd)
+}
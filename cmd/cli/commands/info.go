@@ -0,0 +1,243 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var infoCmd = &cobra.Command{
+	Use:     "info",
+	Aliases: []string{"id"},
+	Short:   "Show the running node's identity and addresses",
+	Long: `Info shows the running node's peer ID and the addresses it announces.
+
+With network.proxy set, it also shows the proxy and what the node gives
+up to keep its IP address hidden. Such a node announces only its onion
+address, from network.onion, or no address at all.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		info, err := client.NodeInfo(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Peer ID: %s\n", info.PeerID)
+		fmt.Fprintln(out, "Addresses:")
+		for _, addr := range info.Addresses {
+			fmt.Fprintf(out, "  %s/p2p/%s\n", addr, info.PeerID)
+		}
+		if info.Proxy != "" {
+			fmt.Fprintf(out, "Proxy:   %s (SOCKS5)\n", info.Proxy)
+			fmt.Fprintln(out, "Limitations:")
+			for _, l := range info.Limitations {
+				fmt.Fprintf(out, "  - %s\n", l)
+			}
+		}
+		return nil
+	},
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show storage and network statistics",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		stats, err := client.Stats(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Blocks: %d (%s)\n", stats.Blocks, formatBytes(stats.Bytes))
+		fmt.Fprintf(out, "Pins:   %d\n", stats.Pins)
+		fmt.Fprintf(out, "Peers:  %d\n", stats.Peers)
+
+		if len(stats.Replication) > 0 {
+			fmt.Fprintln(out, "\nReplication:")
+			fmt.Fprintf(out, "  %-16s  %6s  %9s  %16s  %11s\n", "CLASS", "PINS", "COMPLIANT", "UNDER-REPLICATED", "ZONES SHORT")
+			for _, c := range stats.Replication {
+				class := c.Class
+				if class == "" {
+					class = "default"
+				}
+				fmt.Fprintf(out, "  %-16s  %6d  %9d  %16d  %11d\n", class, c.Pins, c.Compliant, c.UnderReplicated, c.ZonesShort)
+			}
+		}
+		return nil
+	},
+}
+
+var statsBwCmd = &cobra.Command{
+	Use:   "bw",
+	Short: "Show the bandwidth used, by protocol and peer",
+	Long: `Bw shows the data the daemon exchanged with peers since it started, and
+how fast it is exchanging it now, in total, for each protocol and for the
+peers that used the most. Peers idle for an hour are left out.
+
+The same figures are served on the metrics endpoint.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.Bandwidth(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		}
+
+		row := func(name string, t api.Traffic) {
+			fmt.Fprintf(out, "%-52s  %10s  %10s  %12s  %12s\n", name,
+				formatBytes(t.TotalIn), formatBytes(t.TotalOut), formatRate(t.RateIn), formatRate(t.RateOut))
+		}
+		header := func(name string) {
+			fmt.Fprintf(out, "%-52s  %10s  %10s  %12s  %12s\n", name, "IN", "OUT", "RATE IN", "RATE OUT")
+		}
+
+		header("")
+		row("Total", res.Total)
+		if len(res.Protocols) > 0 {
+			fmt.Fprintln(out)
+			header("PROTOCOL")
+			for _, p := range res.Protocols {
+				name := p.Protocol
+				if name == "" {
+					// Streams still negotiating their protocol
+					name = "(negotiating)"
+				}
+				row(name, p.Traffic)
+			}
+		}
+
+		peers := res.Peers
+		if limit, _ := cmd.Flags().GetInt("peers"); limit > 0 && len(peers) > limit {
+			peers = peers[:limit]
+		}
+		if len(peers) > 0 {
+			fmt.Fprintln(out)
+			header("PEER")
+			for _, p := range peers {
+				row(p.Peer, p.Traffic)
+			}
+		}
+		if len(peers) < len(res.Peers) {
+			fmt.Fprintf(out, "(%d more, use --peers 0 for all)\n", len(res.Peers)-len(peers))
+		}
+		return nil
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the node's operations fail more than they should",
+	Long: `Status sums up how often dials to peers, DHT provider lookups and block
+fetches failed over the last five minutes and the last hour, against the
+objectives in the health config. Each kind of operation has an error
+budget, the failures its objective allows. It is yellow while failing
+faster than the budget allows over either period, and red over both.
+
+The node's status is the worst of them. Periods with fewer than ten
+operations aren't judged.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.Health(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		}
+
+		color := false
+		if f, ok := out.(*os.File); ok {
+			if info, err := f.Stat(); err == nil {
+				color = info.Mode()&os.ModeCharDevice != 0
+			}
+		}
+		paint := func(status string, width int) string {
+			text := fmt.Sprintf("%-*s", width, status)
+			code, ok := statusColors[status]
+			if !color || !ok {
+				return text
+			}
+			return "\x1b[" + code + "m" + text + "\x1b[0m"
+		}
+
+		fmt.Fprintf(out, "Status: %s\n\n", paint(strings.ToUpper(res.Status), 0))
+		fmt.Fprintf(out, "%-9s  %-6s  %9s  %-20s  %-20s  %11s\n",
+			"SUBSYSTEM", "STATUS", "OBJECTIVE", "FAILED (5M)", "FAILED (1H)", "BUDGET LEFT")
+		for _, sub := range res.Subsystems {
+			fmt.Fprintf(out, "%-9s  %s  %8.1f%%  %-20s  %-20s  %10.0f%%\n",
+				sub.Name, paint(sub.Status, 6), sub.Objective*100,
+				formatFailures(sub.Short), formatFailures(sub.Long), sub.BudgetLeft*100)
+		}
+		return nil
+	},
+}
+
+// statusColors are the ANSI colors of the health statuses.
+var statusColors = map[string]string{
+	"green":  "32",
+	"yellow": "33",
+	"red":    "31",
+}
+
+func formatFailures(w api.HealthWindow) string {
+	if w.Ops == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d/%d (%.1f%%)", w.Failures, w.Ops, w.ErrorRatio*100)
+}
+
+func formatRate(bytesPerSecond float64) string {
+	return formatBytes(int64(bytesPerSecond)) + "/s"
+}
+
+func init() {
+	statsBwCmd.Flags().Int("peers", 10, "number of peers listed (0 for all)")
+	statsBwCmd.Flags().Bool("json", false, "print the figures as JSON")
+
+	statusCmd.Flags().Bool("json", false, "print the health summary as JSON")
+
+	statsCmd.AddCommand(statsBwCmd)
+	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a config file for a new node",
+	Long: `Init writes a config file, --config or the default location, and creates
+the data dir. --profile starts from settings suited to a deployment:
+
+  server   public DHT server and relay, many connections, metrics on
+  desktop  DHT client behind NAT, relays and hole punching, little
+           background work
+  test     no DHT, quick replication and collection, quiet logs
+
+Without a profile the defaults are written. The file is a starting point to
+edit; an existing one is only replaced with --force.`,
+	Args: cobra.NoArgs,
+	// The config file doesn't exist yet, so don't load it.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profile, _ := cmd.Flags().GetString("profile")
+		force, _ := cmd.Flags().GetBool("force")
+
+		c := config.Default()
+		if dir, _ := cmd.Flags().GetString("data-dir"); dir != "" {
+			c.DataDir = dir
+		} else if dir := os.Getenv("DFS_DATA_DIR"); dir != "" {
+			c.DataDir = dir
+		}
+		if profile != "" {
+			if err := c.ApplyProfile(profile); err != nil {
+				return err
+			}
+		}
+		if err := c.Validate(); err != nil {
+			return err
+		}
+
+		path, _ := cmd.Flags().GetString("config")
+		if path == "" {
+			path = config.DefaultPath()
+		}
+		if err := c.Write(path, force); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("%s already exists, use --force to replace it", path)
+			}
+			return err
+		}
+		if err := os.MkdirAll(c.DataDir, 0700); err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Config written to %s\n", path)
+		if profile != "" {
+			fmt.Fprintf(out, "Profile: %s\n", profile)
+		}
+		fmt.Fprintf(out, "Data dir: %s\n", c.DataDir)
+		return nil
+	},
+}
+
+func init() {
+	initCmd.Flags().String("profile", "", "start from a profile: "+strings.Join(config.ProfileNames(), ", "))
+	initCmd.Flags().String("data-dir", "", "directory holding node state (default "+config.DefaultDataDir()+")")
+	initCmd.Flags().Bool("force", false, "replace an existing config file")
+	rootCmd.AddCommand(initCmd)
+}
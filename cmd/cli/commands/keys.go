@@ -0,0 +1,529 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/agent"
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/keystore"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/shamir"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the passphrase protected keystore",
+	Long: `Keys manages the keystore, one file holding the node key, the master keys
+that encrypted files are read with, the keys names are published under and
+the key of every file encrypted on this node, all encrypted with a
+passphrase. Without a keystore each of these keys is a file of its own,
+readable by anyone who can read the data dir.
+
+The passphrase is read from DFS_PASSPHRASE, then from the file set as
+keystore.passphrase_file, and otherwise asked for on the terminal. The
+daemon needs it at every start. "dfs agent start" keeps the keystore
+unlocked for a while, so commands don't ask for it every time.`,
+}
+
+var keysCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create the keystore from the node's keys",
+	Long: `Create makes the keystore at keystore.path, protected by a new passphrase.
+The node key, master key and name keys found in their usual files are
+copied into it, and those missing are generated. The files themselves are
+left in place: remove them once the daemon runs from the keystore, as the
+keys in them stay readable without the passphrase.
+
+Restart the daemon to have it take its keys from the keystore.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := cfg.KeystorePath()
+		if keystore.Exists(path) {
+			return fmt.Errorf("%w: %s", keystore.ErrExists, path)
+		}
+		passphrase, err := keystore.NewPassphrase(cfg.PassphrasePath())
+		if err != nil {
+			return err
+		}
+		ks, err := keystore.Create(path, passphrase)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		copied, err := importKeyFiles(ks, out)
+		if err != nil {
+			return fmt.Errorf("keystore %s is incomplete: %w", path, err)
+		}
+		fmt.Fprintf(out, "Keystore written to %s\n", path)
+		if len(copied) > 0 {
+			fmt.Fprintln(out, "Once the daemon runs from it, remove the key files copied into it:")
+			for _, p := range copied {
+				fmt.Fprintf(out, "  %s\n", p)
+			}
+		}
+		return nil
+	},
+}
+
+// importKeyFiles copies the node's key files into ks, generating the node
+// and master keys when there are none, and returns the files copied.
+func importKeyFiles(ks *keystore.Keystore, out io.Writer) ([]string, error) {
+	var copied []string
+
+	priv, err := network.LoadIdentity(cfg.IdentityPath())
+	switch {
+	case err == nil:
+		if err := ks.PutIdentity(priv); err != nil {
+			return nil, err
+		}
+		copied = append(copied, cfg.IdentityPath())
+	case errors.Is(err, fs.ErrNotExist):
+		if _, err := ks.Generate(keystore.KindIdentity, keystore.IdentityName); err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(out, "Generated a node key")
+	default:
+		return nil, err
+	}
+
+	master, err := crypt.LoadMasterKey(cfg.MasterKeyPath())
+	switch {
+	case err == nil:
+		if err := ks.PutMasterKey(master); err != nil {
+			return nil, err
+		}
+		copied = append(copied, cfg.MasterKeyPath())
+	case errors.Is(err, fs.ErrNotExist):
+		if _, err := ks.Generate(keystore.KindMaster, keystore.MasterName); err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(out, "Generated a master key")
+	default:
+		return nil, err
+	}
+
+	// Named keys sit next to the records published under them
+	files, err := filepath.Glob(filepath.Join(cfg.NamesPath(), "*.key"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		priv, err := network.LoadIdentity(file)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".key")
+		if err := ks.PutNameKey(name, priv); err != nil {
+			return nil, err
+		}
+		copied = append(copied, file)
+	}
+	return copied, nil
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the keys in the keystore",
+	Long: `List shows the keys in the keystore, without revealing them. The ID of a
+node or name key is its peer ID, that of a file key the file's hash.
+Retired keys were replaced by "dfs keys rotate" and are kept to read what
+they encrypted.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var kind keystore.Kind
+		if k, _ := cmd.Flags().GetString("kind"); k != "" {
+			var err error
+			if kind, err = keystore.ParseKind(k); err != nil {
+				return err
+			}
+		}
+		ks, err := mustOpenKeystore()
+		if err != nil {
+			return err
+		}
+
+		var keys []keystore.Key
+		width := len("ID")
+		for _, k := range ks.List() {
+			if kind == "" || k.Kind == kind {
+				keys = append(keys, k)
+				width = max(width, len(k.ID))
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "%-8s  %-16s  %-19s  %-*s  %s\n", "KIND", "NAME", "CREATED", width, "ID", "STATUS")
+		for _, k := range keys {
+			state := "in use"
+			if !k.Retired.IsZero() {
+				state = "retired " + k.Retired.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(out, "%-8s  %-16s  %-19s  %-*s  %s\n",
+				k.Kind, trimName(k.Name, 16), k.Created.Local().Format(time.DateTime), width, k.ID, state)
+		}
+		return nil
+	},
+}
+
+var keysExportCmd = &cobra.Command{
+	Use:   "export <kind> [name]",
+	Short: "Write a key out of the keystore",
+	Long: `Export writes a key in the form of the key file a node without a keystore
+reads: encoded as protobuf for the node key and name keys, hex for master
+and file keys. The name defaults to the key in use for the node and master
+kinds; file keys are named by their file's hash. --id picks a retired key.
+
+An exported key is not protected by the passphrase; anyone holding an
+exported master key can read every file it encrypted.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, name, err := keyArgs(args)
+		if err != nil {
+			return err
+		}
+		ks, err := mustOpenKeystore()
+		if err != nil {
+			return err
+		}
+
+		var secret []byte
+		if id, _ := cmd.Flags().GetString("id"); id != "" {
+			_, secret, err = ks.GetID(kind, id)
+		} else {
+			_, secret, err = ks.Get(kind, name)
+		}
+		if err != nil {
+			return err
+		}
+
+		if output, _ := cmd.Flags().GetString("output"); output != "" && output != "-" {
+			if err := os.WriteFile(output, secret, 0600); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "Key written to %s\n", output)
+			return nil
+		}
+		_, err = cmd.OutOrStdout().Write(secret)
+		return err
+	},
+}
+
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate <kind> [name]",
+	Short: "Replace a key with a new one",
+	Long: `Rotate generates a new key in place of the one in use, which is kept as a
+retired key. Restart the daemon for the new key to take effect.
+
+Rotating the master key encrypts files added from then on with the new key;
+those added before stay readable with the retired one. Rotating the node
+key gives the node a new peer ID, and rotating a name key gives the name a
+new address, to publish to again. File keys can't be rotated.
+
+--dry-run shows the key that would be retired, without rotating it.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, name, err := keyArgs(args)
+		if err != nil {
+			return err
+		}
+		ks, err := mustOpenKeystore()
+		if err != nil {
+			return err
+		}
+
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			if kind == keystore.KindFile {
+				return fmt.Errorf("file keys can't be rotated")
+			}
+			current, _, err := ks.Get(kind, name)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintln(out, "Dry run, nothing was changed.")
+			fmt.Fprintf(out, "Would rotate %s key %s\n", kind, name)
+			fmt.Fprintf(out, "  retire: %s\n", current.ID)
+			return nil
+		}
+
+		old, key, err := ks.Rotate(kind, name)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Rotated %s key %s\n", kind, name)
+		fmt.Fprintf(out, "  new:     %s\n  retired: %s\n", key.ID, old.ID)
+		fmt.Fprintln(out, "Restart the daemon for the new key to take effect.")
+		return nil
+	},
+}
+
+var keysBackupCmd = &cobra.Command{
+	Use:   "backup --shares <n> --threshold <k>",
+	Short: "Split the keystore's keys into shares for safekeeping",
+	Long: `Backup splits the node key, the master keys and the name keys in the
+keystore, retired ones included, into --shares shares. Any --threshold of
+them rebuild the keys with "dfs keys recover", and fewer reveal nothing
+about them. Keep the shares in different places, or with different
+people, so losing a machine doesn't lose the identity and no single share
+gives it away.
+
+Every share is one line of text: "dfs-share-" and groups of letters and
+digits with a checksum that catches typos, to print or copy by hand. With
+--output-dir each is written to a file of its own; otherwise they are
+printed.
+
+File keys are left out unless --file-keys is given, as the master key
+unwraps them from the files' manifests as well. Including them makes the
+shares longer with every file encrypted.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n, _ := cmd.Flags().GetInt("shares")
+		threshold, _ := cmd.Flags().GetInt("threshold")
+		dir, _ := cmd.Flags().GetString("output-dir")
+		kinds := []keystore.Kind{keystore.KindIdentity, keystore.KindMaster, keystore.KindName}
+		if fileKeys, _ := cmd.Flags().GetBool("file-keys"); fileKeys {
+			kinds = append(kinds, keystore.KindFile)
+		}
+
+		ks, err := mustOpenKeystore()
+		if err != nil {
+			return err
+		}
+		secret, err := ks.Backup(kinds...)
+		if err != nil {
+			return err
+		}
+		defer clear(secret)
+		shares, err := shamir.Split(secret, n, threshold)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if dir == "" {
+			for i, share := range shares {
+				fmt.Fprintf(out, "Share %d of %d, any %d rebuild the keys:\n%s\n\n", i+1, n, threshold, share)
+			}
+			return nil
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		for i, share := range shares {
+			path := filepath.Join(dir, fmt.Sprintf("share-%d-of-%d.txt", i+1, n))
+			if err := os.WriteFile(path, []byte(share.String()+"\n"), 0600); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Share %d written to %s\n", i+1, path)
+		}
+		fmt.Fprintf(out, "Any %d of the %d shares rebuild the keys.\n", threshold, n)
+		return nil
+	},
+}
+
+var keysRecoverCmd = &cobra.Command{
+	Use:   "recover [share-file...]",
+	Short: "Rebuild the keys from the shares of a backup",
+	Long: `Recover rebuilds the keys "dfs keys backup" split, from at least as many of
+its shares as its threshold. Shares are read from the files given, or from
+standard input without any, one per line; other lines are skipped, so the
+output of "dfs keys backup" can be given as it is.
+
+The keys are added to the keystore, which is created with a new
+passphrase when there is none. A node, master or name key that was in use
+when the backup was made is put in use, and the key it replaces kept as a
+retired key. Restart the daemon for the recovered keys to take effect.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var shares []shamir.Share
+		read := func(r io.Reader, name string) error {
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if !strings.HasPrefix(strings.ToLower(line), "dfs-share-") {
+					continue
+				}
+				share, err := shamir.ParseShare(line)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				shares = append(shares, share)
+			}
+			return scanner.Err()
+		}
+		if len(args) == 0 {
+			if err := read(cmd.InOrStdin(), "stdin"); err != nil {
+				return err
+			}
+		}
+		for _, path := range args {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			err = read(f, path)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if len(shares) == 0 {
+			return fmt.Errorf("no shares given")
+		}
+
+		secret, err := shamir.Combine(shares)
+		if errors.Is(err, shamir.ErrTooFewShares) {
+			return fmt.Errorf("the backup needs %d shares, got %d", shares[0].Threshold, len(shares))
+		}
+		if err != nil {
+			return err
+		}
+		defer clear(secret)
+
+		ks, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		if ks == nil {
+			passphrase, err := keystore.NewPassphrase(cfg.PassphrasePath())
+			if err != nil {
+				return err
+			}
+			if ks, err = keystore.Create(cfg.KeystorePath(), passphrase); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Keystore written to %s\n", cfg.KeystorePath())
+		}
+		added, err := ks.Restore(secret)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		for _, k := range added {
+			state := "in use"
+			if !k.Retired.IsZero() {
+				state = "retired"
+			}
+			fmt.Fprintf(out, "Recovered %s key %s (%s, %s)\n", k.Kind, trimName(k.Name, 16), k.ID, state)
+		}
+		if len(added) == 0 {
+			fmt.Fprintln(out, "The keystore holds every key of the backup already.")
+			return nil
+		}
+		fmt.Fprintln(out, "Restart the daemon for the recovered keys to take effect.")
+		return nil
+	},
+}
+
+// keyArgs parses the kind and name of a key, the name defaulting to the
+// node's own key of that kind.
+func keyArgs(args []string) (keystore.Kind, string, error) {
+	kind, err := keystore.ParseKind(args[0])
+	if err != nil {
+		return "", "", err
+	}
+	if len(args) == 2 {
+		return kind, args[1], nil
+	}
+	switch kind {
+	case keystore.KindIdentity:
+		return kind, keystore.IdentityName, nil
+	case keystore.KindMaster:
+		return kind, keystore.MasterName, nil
+	default:
+		return "", "", fmt.Errorf("give the name of the %s key", kind)
+	}
+}
+
+// openKeystore opens the configured keystore, asking for its passphrase,
+// or returns nil when there is none.
+func openKeystore() (*keystore.Keystore, error) {
+	path := cfg.KeystorePath()
+	if !keystore.Exists(path) {
+		return nil, nil
+	}
+	// An agent spares asking for the passphrase, see dfs agent
+	if key, err := agent.Key(agent.SocketPath(path), path); err == nil {
+		ks, err := keystore.OpenUnlocked(path, key)
+		clear(key)
+		if err == nil {
+			return ks, nil
+		}
+	}
+	passphrase, err := keystore.Passphrase(cfg.PassphrasePath())
+	if err != nil {
+		return nil, err
+	}
+	return keystore.Open(path, passphrase)
+}
+
+// mustOpenKeystore is openKeystore for commands that need a keystore.
+func mustOpenKeystore() (*keystore.Keystore, error) {
+	ks, err := openKeystore()
+	if err == nil && ks == nil {
+		err = fmt.Errorf("no keystore at %s, create one with \"dfs keys create\"", cfg.KeystorePath())
+	}
+	return ks, err
+}
+
+// unwrapFileKey returns the key the file c is encrypted with, from the
+// keystore when there is one and the master key file otherwise.
+func unwrapFileKey(c cid.Cid, e *api.Encryption) (*crypt.FileKey, error) {
+	ks, err := openKeystore()
+	if err != nil {
+		return nil, err
+	}
+	if ks == nil {
+		master, err := crypt.LoadMasterKey(cfg.MasterKeyPath())
+		if err != nil {
+			return nil, fmt.Errorf("load master key: %w", err)
+		}
+		return master.Unwrap(e.KeyID, e.WrappedKey)
+	}
+
+	if key, err := ks.FileKey(c); err == nil {
+		return key, nil
+	}
+	if _, data, err := ks.GetID(keystore.KindMaster, e.KeyID); err == nil {
+		master, err := crypt.DecodeMasterKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return master.Unwrap(e.KeyID, e.WrappedKey)
+	}
+	return nil, fmt.Errorf("%w %s, which the keystore doesn't hold", crypt.ErrWrongKey, e.KeyID)
+}
+
+func init() {
+	keysListCmd.Flags().String("kind", "", "list only keys of this kind: identity, master, name or file")
+	keysExportCmd.Flags().StringP("output", "o", "", "write the key to this file instead of stdout")
+	keysExportCmd.Flags().String("id", "", "export the key with this ID, which may be retired")
+	keysRotateCmd.Flags().Bool("dry-run", false, "show the key that would be retired, without rotating it")
+	keysBackupCmd.Flags().Int("shares", 5, "number of shares to split the keys into")
+	keysBackupCmd.Flags().Int("threshold", 3, "number of shares that rebuild the keys")
+	keysBackupCmd.Flags().String("output-dir", "", "write every share to a file of its own in this directory")
+	keysBackupCmd.Flags().Bool("file-keys", false, "include the keys of the files encrypted on this node")
+
+	keysCmd.AddCommand(keysCreateCmd)
+	keysCmd.AddCommand(keysListCmd)
+	keysCmd.AddCommand(keysExportCmd)
+	keysCmd.AddCommand(keysRotateCmd)
+	keysCmd.AddCommand(keysBackupCmd)
+	keysCmd.AddCommand(keysRecoverCmd)
+	rootCmd.AddCommand(keysCmd)
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var lsCmd = &cobra.Command{
+	Use:   "ls <hash|path>",
+	Short: "List a directory",
+	Long: `Ls lists the files and directories in a directory added with "dfs add -r",
+given by its hash or by a path below one:
+
+  dfs ls <hash>
+  dfs ls /<hash>/2024
+
+Each entry is printed with its hash and size; directories end in a slash
+and their size covers everything under them. --short abbreviates the
+hashes, like git does, to prefixes no other stored file or directory
+shares; commands taking a hash accept those in its place.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePaths),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListDirectory(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+
+		cids := make([]string, len(res.Entries))
+		for i, e := range res.Entries {
+			cids[i] = e.CID
+		}
+		hashes, err := displayHashes(cmd, client, cids)
+		if err != nil {
+			return err
+		}
+		width := 0
+		for _, h := range hashes {
+			width = max(width, len(h))
+		}
+
+		out := cmd.OutOrStdout()
+		for i, e := range res.Entries {
+			name := e.Name
+			if e.Dir {
+				name += "/"
+			}
+			fmt.Fprintf(out, "%-*s  %10s  %s\n", width, hashes[i], formatBytes(e.Size), name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	lsCmd.Flags().Bool("short", false, "abbreviate hashes to the shortest unambiguous prefix")
+
+	rootCmd.AddCommand(lsCmd)
+}
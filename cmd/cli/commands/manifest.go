@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Inspect and share file manifests",
+}
+
+var manifestExportCmd = &cobra.Command{
+	Use:   "export <hash>",
+	Short: "Export a signed listing of a file's contents",
+	Long: `Export writes a JSON listing of every file under the given content hash
+with its path, size, content hash and chunks, signed with this node's key.
+Recipients can check it with "dfs manifest verify" and audit what they are
+about to fetch. Only the manifest is fetched to build the listing.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		priv, err := loadIdentity(cmd)
+		if err != nil {
+			return fmt.Errorf("load signing key: %w", err)
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		hash, err := expandHash(cmd, client, args[0])
+		if err != nil {
+			return err
+		}
+		c, err := cid.Decode(hash)
+		if err != nil {
+			return fmt.Errorf("invalid hash %q: %w", args[0], err)
+		}
+
+		stat, err := client.Stat(cmd.Context(), c.String())
+		if err != nil {
+			return err
+		}
+
+		// Rebuild the manifest rather than trusting the daemon's account
+		// of it: it has to hash to the requested CID.
+		list := &chunking.ChunkList{Params: stat.Params, Size: stat.Size}
+		for _, ref := range stat.Chunks {
+			chunkCID, err := cid.Decode(ref.CID)
+			if err != nil {
+				return err
+			}
+			list.Chunks = append(list.Chunks, chunking.Chunk{CID: chunkCID, Size: ref.Size})
+		}
+		m, err := manifest.New(stat.Name, list)
+		if err != nil {
+			return err
+		}
+		if e := stat.Encryption; e != nil {
+			m.Encryption = &manifest.Encryption{Cipher: e.Cipher, KeyID: e.KeyID, WrappedKey: e.WrappedKey, Envelope: e.Envelope}
+		}
+		block, err := m.Block()
+		if err != nil {
+			return err
+		}
+		if !block.CID.Equals(c) {
+			return fmt.Errorf("manifest from daemon hashes to %s, not %s", block.CID, c)
+		}
+
+		listing := manifest.NewListing(c, m)
+		if err := listing.Sign(priv); err != nil {
+			return err
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			output = "-"
+		}
+		return writeOutput(cmd, output, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(listing)
+		})
+	},
+}
+
+var manifestVerifyCmd = &cobra.Command{
+	Use:   "verify <listing>",
+	Short: "Check the signature of an exported listing",
+	Long: `Verify checks that a listing written by "dfs manifest export" is intact
+and prints who signed it and what it lists. Pass --signer to also require
+a particular peer's signature. Use - to read the listing from stdin.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r io.Reader = cmd.InOrStdin()
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		var listing manifest.Listing
+		if err := json.NewDecoder(r).Decode(&listing); err != nil {
+			return fmt.Errorf("read listing: %w", err)
+		}
+
+		signer, err := listing.Verify()
+		if err != nil {
+			return err
+		}
+		if want, _ := cmd.Flags().GetString("signer"); want != "" && want != signer.String() {
+			return fmt.Errorf("listing is signed by %s, not %s", signer, want)
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Root:      %s\n", listing.Root)
+		fmt.Fprintf(out, "Signed by: %s\n", signer)
+		for _, file := range listing.Files {
+			fmt.Fprintf(out, "  %s  %s  %s (%d chunks)\n", file.CID, formatBytes(file.Size), file.Path, len(file.Chunks))
+		}
+		return nil
+	},
+}
+
+func init() {
+	manifestExportCmd.Flags().StringP("output", "o", "", "write the listing to a file instead of stdout")
+	manifestExportCmd.Flags().String("identity", "", "path to the signing key (default: the node key)")
+	manifestVerifyCmd.Flags().String("signer", "", "peer ID the listing must be signed by")
+
+	manifestCmd.AddCommand(manifestExportCmd)
+	manifestCmd.AddCommand(manifestVerifyCmd)
+	rootCmd.AddCommand(manifestCmd)
+}
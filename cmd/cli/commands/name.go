@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var nameCmd = &cobra.Command{
+	Use:   "name",
+	Short: "Publish and resolve names that point to content",
+	Long: `A name is a fixed address that can be pointed at a different hash every
+time the content changes. It is derived from a key, so only the node
+holding the key can change where it points. Names are published in the
+DHT, so resolving another node's name needs network.dht_mode to be on.`,
+}
+
+var namePublishCmd = &cobra.Command{
+	Use:   "publish <hash|path>",
+	Short: "Point a name at a hash",
+	Long: `Publish points the node's name at the given hash or path, replacing what
+it pointed to before, and prints the name:
+
+  dfs name publish <hash>
+  dfs name publish --key blog /<hash>/public
+
+--key publishes under a separate name instead, with a key created the
+first time it is used. The daemon republishes its names periodically;
+see names.lifetime and names.republish_interval in the config.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePaths),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, _ := cmd.Flags().GetString("key")
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.PublishName(cmd.Context(), key, args[0])
+		if err != nil {
+			return err
+		}
+		if res.Warning != "" {
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s, will retry when republishing\n", res.Warning)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Published %s -> %s\n", res.Name, res.CID)
+		return nil
+	},
+}
+
+var nameResolveCmd = &cobra.Command{
+	Use:   "resolve [name]",
+	Short: "Print the hash a name points to",
+	Long: `Resolve prints the hash a name currently points to, the node's own name
+when none is given. Names may be given with or without the /ipns/ prefix.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var name string
+		if len(args) == 1 {
+			name = args[0]
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ResolveName(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), res.CID)
+		return nil
+	},
+}
+
+func init() {
+	namePublishCmd.Flags().String("key", "self", "key to publish under")
+
+	nameCmd.AddCommand(namePublishCmd)
+	nameCmd.AddCommand(nameResolveCmd)
+	rootCmd.AddCommand(nameCmd)
+}
@@ -0,0 +1,144 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "Inspect and manage peer connections",
+}
+
+var peersLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List connected peers",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListPeers(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		for _, p := range res.Peers {
+			fmt.Fprintln(out, p.ID)
+			for _, addr := range p.Addresses {
+				fmt.Fprintf(out, "  %s\n", addr)
+			}
+		}
+		return nil
+	},
+}
+
+var peersConnectCmd = &cobra.Command{
+	Use:   "connect <multiaddr>",
+	Short: "Connect to a peer",
+	Long: `Connect dials a peer by its full multiaddr, for example
+/ip4/192.0.2.1/tcp/9000/p2p/12D3KooW...`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ConnectPeer(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Connected to %s\n", res.PeerID)
+		return nil
+	},
+}
+
+var peersBlockCmd = &cobra.Command{
+	Use:   "block <peer-id|ip|cidr>",
+	Short: "Refuse connections from a peer or address range",
+	Long: `Block closes and refuses connections matching a peer ID, IP address or
+CIDR range, e.g. 12D3KooW..., 198.51.100.7 or 198.51.100.0/24. The block
+lasts until the daemon restarts; add the rule to network.deny in the
+config to keep it.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePeers),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if err := client.BlockPeer(cmd.Context(), args[0]); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Blocked %s\n", args[0])
+		return nil
+	},
+}
+
+var peersUnblockCmd = &cobra.Command{
+	Use:               "unblock <peer-id|ip|cidr>",
+	Short:             "Remove a block",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeBlocked),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if err := client.UnblockPeer(cmd.Context(), args[0]); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Unblocked %s\n", args[0])
+		return nil
+	},
+}
+
+var peersBlockedCmd = &cobra.Command{
+	Use:   "blocked",
+	Short: "List connection allow and deny rules",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListBlocked(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		for _, rule := range res.Deny {
+			fmt.Fprintf(out, "deny   %s\n", rule)
+		}
+		for _, rule := range res.Allow {
+			fmt.Fprintf(out, "allow  %s\n", rule)
+		}
+		return nil
+	},
+}
+
+func init() {
+	peersCmd.AddCommand(peersLsCmd)
+	peersCmd.AddCommand(peersConnectCmd)
+	peersCmd.AddCommand(peersBlockCmd)
+	peersCmd.AddCommand(peersUnblockCmd)
+	peersCmd.AddCommand(peersBlockedCmd)
+	rootCmd.AddCommand(peersCmd)
+}
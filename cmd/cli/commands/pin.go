@@ -0,0 +1,682 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+// pinsetVersion is the version of the format pin export writes.
+const pinsetVersion = 1
+
+// pinPollInterval is how often pin import asks the daemon how a fetch is
+// going.
+const pinPollInterval = 250 * time.Millisecond
+
+// pinset is what pin export writes and pin import reads: a node's pin
+// policy, without the replicas it keeps for other peers.
+type pinset struct {
+	Version int           `json:"version"`
+	Pins    []pinsetEntry `json:"pins"`
+}
+
+type pinsetEntry struct {
+	CID string `json:"cid"`
+	// Replicas is the replication factor set on the pin, left out for
+	// the configured default, Class the replication class set on it.
+	Replicas int    `json:"replicas,omitempty"`
+	Class    string `json:"class,omitempty"`
+	// Name and Labels are those given to the pin, if any.
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+var pinCmd = &cobra.Command{
+	Use:   "pin",
+	Short: "Manage pinned content",
+}
+
+var pinAddCmd = &cobra.Command{
+	Use:   "add <hash | path>",
+	Short: "Pin a file so it is kept",
+	Long: `Pin fetches whatever part of the file isn't stored locally and keeps it.
+A path such as /<hash>/photos/2024 pins what it leads to; a peer holding
+the tree sends everything under the path at once, and whole directories
+pinned by hash come the same way.
+
+With --replicas the daemon also keeps that many copies, its own included,
+on connected peers that accept replicas, and restores the count when a
+peer holding a copy goes away.
+
+--class keeps it by one of the replication.classes of the config instead,
+such as gold for 3 copies across 2 zones. Pinning again with --replicas or
+--class replaces the one set before.
+
+--name and --label tell pins apart: "dfs pin ls" shows them and selects
+pins by them, and the replication.rules of the config assign classes or
+factors by label, e.g. class gold to the pins labelled tier=gold. A label
+given without a value is a tag. Pinning again adds to the labels a pin
+has; "dfs pin label" changes them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		replicas, _ := cmd.Flags().GetInt("replicas")
+		if replicas < 0 {
+			return fmt.Errorf("--replicas must not be negative")
+		}
+		class, _ := cmd.Flags().GetString("class")
+		if class != "" && replicas > 0 {
+			return fmt.Errorf("give either --replicas or --class")
+		}
+		name, _ := cmd.Flags().GetString("name")
+		flags, _ := cmd.Flags().GetStringArray("label")
+		labels, err := parseLabels(flags)
+		if err != nil {
+			return err
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		req := &api.PinRequest{CID: args[0], Replicas: replicas, Class: class, Name: name, Labels: labels}
+		if err := client.Pin(cmd.Context(), req); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Pinned %s\n", args[0])
+		return nil
+	},
+}
+
+var pinRmCmd = &cobra.Command{
+	Use:   "rm <hash>",
+	Short: "Remove a pin",
+	Long: `Rm removes the pin of a file. Its blocks are deleted by the next garbage
+collection that finds them unreferenced, see "dfs repo gc". Chunks it
+shares with other stored files stay until those are deleted too.
+
+--dry-run keeps the pin and shows how much of the file a garbage
+collection would delete once it is gone, grace period aside.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			// Count recent blocks too, the grace period only delays them
+			req := &api.GCRequest{GracePeriod: time.Nanosecond, DryRun: true, Unpinned: []string{args[0]}}
+			res, err := client.GC(cmd.Context(), req)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintln(out, "Dry run, nothing was changed.")
+			fmt.Fprintf(out, "Would unpin %s\n", args[0])
+			fmt.Fprintf(out, "A garbage collection would then remove %d of its blocks (%s)\n", res.Freed, formatBytes(res.FreedBytes))
+			return nil
+		}
+
+		if err := client.Unpin(cmd.Context(), args[0]); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Unpinned %s\n", args[0])
+		return nil
+	},
+}
+
+var pinUpdateCmd = &cobra.Command{
+	Use:   "update <old-hash> <new-hash>",
+	Short: "Move a pin to a new version of its file",
+	Long: `Update moves the pin of a file or directory to a later version of it,
+keeping the pin's name, labels and replication. Only the chunks the new
+version doesn't share with the old one are fetched.
+
+Peers keeping replicas of the old version are asked to keep the new one
+in its place. They send over the chunks they have and get only the rest,
+rather than fetching the new version whole. The old version's blocks
+stay until a garbage collection finds them unreferenced.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.UpdatePin(cmd.Context(), args[0], args[1])
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Updated pin %s to %s\n", args[0], args[1])
+		if res.Replicas > 0 {
+			fmt.Fprintf(out, "%d peers updated their replicas\n", res.Replicas)
+		}
+		return nil
+	},
+}
+
+var pinArchiveCmd = &cobra.Command{
+	Use:   "archive <hash>",
+	Short: "Move a pin to the cold store",
+	Long: `Archive copies a pin's blocks to the cold store set up under archive in
+the config, a slow mount or an S3 bucket, then removes those no other pin
+uses from this node's store. The pin stays, marked archived in "dfs pin
+ls": it can't be read, and the node neither serves nor replicates it,
+until it is recalled with "dfs pin recall".
+
+The pin must be stored in full here; pin it again first to fetch what
+is missing. Archiving a pin already archived does nothing.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ArchivePin(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Archived %s: copied %d blocks (%s), %d already in the cold store\n",
+			args[0], res.Blocks, formatBytes(res.Bytes), res.Present)
+		fmt.Fprintf(out, "Removed %d blocks (%s) from the hot store\n", res.Evicted, formatBytes(res.EvictedBytes))
+		if res.Warning != "" {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %s\n", res.Warning)
+		}
+		return nil
+	},
+}
+
+var pinRecallCmd = &cobra.Command{
+	Use:   "recall <hash>",
+	Short: "Bring an archived pin back from the cold store",
+	Long: `Recall copies the blocks of an archived pin that this node's store lacks
+back from the cold store, after which the pin is read, served and
+replicated as before. The cold store keeps its copy, so archiving the pin
+again copies nothing. On a terminal a progress bar shows the copy.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report, err := newTransferReport(cmd, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		ctx := cmd.Context()
+		var res *api.RecallPinResponse
+		err = opTracked(ctx, client, ops.KindRecall, args[0], func() (err error) {
+			res, err = client.RecallPin(ctx, args[0])
+			return err
+		}, report.track(args[0], 0, true))
+		report.clear()
+		if err != nil {
+			return err
+		}
+		switch {
+		case report.enc != nil:
+			report.emit(transferEvent{Event: "recalled", CID: res.CID, Size: res.Bytes})
+		case !report.quiet:
+			fmt.Fprintf(cmd.OutOrStdout(), "Recalled %s: copied %d blocks (%s), %d still stored\n",
+				args[0], res.Blocks, formatBytes(res.Bytes), res.Present)
+		}
+		return nil
+	},
+}
+
+var pinLabelCmd = &cobra.Command{
+	Use:   "label <hash> [key=value | key | key-]...",
+	Short: "Change the name and labels of a pin",
+	Long: `Label sets labels on a pin, given as key=value or as a bare key for a tag,
+and removes those given as key-. --name renames the pin. The replication
+factor rules give the pin follow its new labels.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("name")
+		req := &api.LabelPinRequest{CID: args[0], Name: name}
+		var set []string
+		for _, arg := range args[1:] {
+			if key, ok := strings.CutSuffix(arg, "-"); ok && !strings.Contains(arg, "=") {
+				req.Remove = append(req.Remove, key)
+			} else {
+				set = append(set, arg)
+			}
+		}
+		if len(args) == 1 && name == "" {
+			return fmt.Errorf("give labels to set or remove, or --name")
+		}
+		labels, err := parseLabels(set)
+		if err != nil {
+			return err
+		}
+		req.Labels = labels
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if err := client.LabelPin(cmd.Context(), req); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Labelled %s\n", args[0])
+		return nil
+	},
+}
+
+var pinLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List pins with their replication state",
+	Long: `Ls lists the pins with the copies known to be available out of the
+replication factor, the replication class and the name and labels of each.
+Pins lacking copies are marked under-replicated, those whose class wants
+copies in more zones than they are in show the zones spanned, and those
+moved to the cold store archived.
+
+--label keeps the pins with a label, given as key=value or as a bare key
+for any value; given more than once, pins must have every label. --name
+keeps the pins whose name matches a pattern such as "photos-*", --class
+those kept by a class.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var selectors []pin.Selector
+		flags, _ := cmd.Flags().GetStringArray("label")
+		for _, f := range flags {
+			sel, err := pin.ParseSelector(f)
+			if err != nil {
+				return fmt.Errorf("--label: %w", err)
+			}
+			selectors = append(selectors, sel)
+		}
+		pattern, _ := cmd.Flags().GetString("name")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("--name: %w", err)
+		}
+		class, _ := cmd.Flags().GetString("class")
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListPins(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		var pins []api.PinInfo
+		for _, p := range res.Pins {
+			if matchPin(p, pattern, selectors) && (class == "" || p.Class == class) {
+				pins = append(pins, p)
+			}
+		}
+
+		cids := make([]string, len(pins))
+		for i, p := range pins {
+			cids[i] = p.CID
+		}
+		hashes, err := displayHashes(cmd, client, cids)
+		if err != nil {
+			return err
+		}
+		var width, classWidth, nameWidth, labelsWidth int
+		labels := make([]string, len(pins))
+		for i, p := range pins {
+			labels[i] = pin.FormatLabels(p.Labels)
+			width = max(width, len(hashes[i]))
+			classWidth = max(classWidth, len(p.Class))
+			nameWidth = max(nameWidth, len(p.Name))
+			labelsWidth = max(labelsWidth, len(labels[i]))
+		}
+
+		out := cmd.OutOrStdout()
+		for i, p := range pins {
+			line := fmt.Sprintf("%-*s  %d/%d copies", width, hashes[i], p.Copies, p.Replicas)
+			if classWidth > 0 {
+				line += fmt.Sprintf("  %-*s", classWidth, p.Class)
+			}
+			if nameWidth > 0 {
+				line += fmt.Sprintf("  %-*s", nameWidth, p.Name)
+			}
+			if labelsWidth > 0 {
+				line += fmt.Sprintf("  %-*s", labelsWidth, labels[i])
+			}
+			switch {
+			case !p.Archived.IsZero():
+				line += "  archived"
+			case p.Copies < p.Replicas:
+				line += "  under-replicated"
+			case p.Spanned < p.Zones:
+				line += fmt.Sprintf("  in %d of %d zones", p.Spanned, p.Zones)
+			}
+			fmt.Fprintln(out, strings.TrimRight(line, " "))
+		}
+		return nil
+	},
+}
+
+// matchPin reports whether p's name matches pattern, when set, and p has
+// every label selected.
+func matchPin(p api.PinInfo, pattern string, selectors []pin.Selector) bool {
+	if pattern != "" {
+		if ok, _ := path.Match(pattern, p.Name); !ok {
+			return false
+		}
+	}
+	for _, sel := range selectors {
+		if !sel.Matches(pin.Pin{Labels: p.Labels}) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLabels parses labels given as key=value, or as a bare key for a
+// tag.
+func parseLabels(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, err := pin.ParseLabel(arg)
+		if err != nil {
+			return nil, err
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+var pinExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the pins and their replication factors as JSON",
+	Long: `Export writes the files and directories pinned on this node, with the
+replication factor or class, name and labels set on each, for "dfs pin
+import" to pin on another node. Replicas kept for other peers are left out.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListPins(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		set := pinset{Version: pinsetVersion, Pins: []pinsetEntry{}}
+		for _, p := range res.Pins {
+			if p.KeptFor == "" {
+				set.Pins = append(set.Pins, pinsetEntry{
+					CID:      p.CID,
+					Replicas: p.Requested,
+					Class:    p.RequestedClass,
+					Name:     p.Name,
+					Labels:   p.Labels,
+				})
+			}
+		}
+
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(set)
+	},
+}
+
+var pinImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Pin what a pin export lists",
+	Long: `Import pins the files and directories listed by "dfs pin export", with the
+replication factors or classes, names and labels they had, so this node
+keeps what another one does; a class must be configured here too. Use -
+to read the export from stdin. Pins already in place keep their
+replication factor or class and name unless the export sets them, and
+gain the labels it lists.
+
+Without --fetch the pins are only recorded: what isn't stored locally is
+fetched when it is read, or pinned again with "dfs pin add". With --fetch
+each is fetched in turn, with a progress bar on a terminal; a pin that
+fails is reported and the import carries on with the rest.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fetch, _ := cmd.Flags().GetBool("fetch")
+
+		set, err := readPinset(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		report, err := newTransferReport(cmd, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		ctx := cmd.Context()
+		res, err := client.ListPins(ctx)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]api.PinInfo)
+		for _, p := range res.Pins {
+			if p.KeptFor == "" {
+				existing[p.CID] = p
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		var pinned, kept, failed int
+		for i, p := range set.Pins {
+			if e, ok := existing[p.CID]; ok && pinnedAs(e, p) {
+				kept++
+				continue
+			}
+
+			req := &api.PinRequest{
+				CID:      p.CID,
+				Replicas: p.Replicas,
+				Class:    p.Class,
+				NoFetch:  !fetch,
+				Name:     p.Name,
+				Labels:   p.Labels,
+			}
+			if fetch {
+				err = pinTracked(ctx, client, req, report.track(p.CID, 0, true))
+				report.clear()
+			} else {
+				err = client.Pin(ctx, req)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "Failed to pin %s: %v\n", p.CID, err)
+				failed++
+				continue
+			}
+			pinned++
+
+			switch {
+			case report.enc != nil:
+				report.emit(transferEvent{Event: "pinned", CID: p.CID})
+			case !report.quiet:
+				fmt.Fprintf(out, "[%d/%d] Pinned %s\n", i+1, len(set.Pins), p.CID)
+			}
+		}
+
+		if report.verbose() {
+			fmt.Fprintf(out, "Imported %s, %d already pinned\n", plural(pinned, "pin"), kept)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d pins failed", failed, len(set.Pins))
+		}
+		return nil
+	},
+}
+
+// pinnedAs reports whether importing p would leave the pin e unchanged.
+func pinnedAs(e api.PinInfo, p pinsetEntry) bool {
+	if p.Replicas != 0 && p.Replicas != e.Requested || p.Class != "" && p.Class != e.RequestedClass {
+		return false
+	}
+	if p.Name != "" && p.Name != e.Name {
+		return false
+	}
+	for k, v := range p.Labels {
+		if ev, ok := e.Labels[k]; !ok || ev != v {
+			return false
+		}
+	}
+	return true
+}
+
+// readPinset reads and checks a pin export from path, or stdin for -.
+func readPinset(cmd *cobra.Command, path string) (*pinset, error) {
+	var r io.Reader = cmd.InOrStdin()
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var set pinset
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, fmt.Errorf("read pin export: %w", err)
+	}
+	if set.Version != pinsetVersion {
+		return nil, fmt.Errorf("unsupported pin export version %d", set.Version)
+	}
+	for _, p := range set.Pins {
+		if _, err := cid.Decode(p.CID); err != nil {
+			return nil, fmt.Errorf("pin export lists an invalid hash %q: %w", p.CID, err)
+		}
+		if p.Replicas < 0 {
+			return nil, fmt.Errorf("pin export sets negative replicas for %s", p.CID)
+		}
+		if p.Replicas > 0 && p.Class != "" {
+			return nil, fmt.Errorf("pin export sets both replicas and a class for %s", p.CID)
+		}
+		if err := pin.CheckName(p.Name); err != nil {
+			return nil, fmt.Errorf("pin export names %s badly: %w", p.CID, err)
+		}
+		for k, v := range p.Labels {
+			if err := pin.CheckLabel(k, v); err != nil {
+				return nil, fmt.Errorf("pin export labels %s badly: %w", p.CID, err)
+			}
+		}
+	}
+	return &set, nil
+}
+
+// pinTracked pins with req, passing progress what the daemon has fetched
+// so far.
+func pinTracked(ctx context.Context, client *api.Client, req *api.PinRequest, progress func(*api.Progress)) error {
+	return opTracked(ctx, client, ops.KindPin, req.CID, func() error { return client.Pin(ctx, req) }, progress)
+}
+
+// opTracked calls run, which starts an operation of kind on target in the
+// daemon, passing progress what it has fetched so far. Such a call reports
+// nothing until it is done, so the daemon's list of running operations is
+// polled meanwhile.
+func opTracked(ctx context.Context, client *api.Client, kind, target string, run func() error, progress func(*api.Progress)) error {
+	if progress == nil {
+		return run()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- run() }()
+
+	tick := time.NewTicker(pinPollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-tick.C:
+		}
+
+		res, err := client.ListOperations(ctx)
+		if err != nil {
+			continue
+		}
+		for _, op := range res.Operations {
+			if op.Kind != kind || op.Target != target || op.Missing == 0 {
+				continue
+			}
+			progress(&api.Progress{
+				FetchedBytes:  op.Fetched,
+				MissingBytes:  op.Missing,
+				FetchedChunks: op.FetchedChunks,
+				MissingChunks: op.MissingChunks,
+				Peers:         op.Peers,
+			})
+		}
+	}
+}
+
+func init() {
+	pinAddCmd.Flags().Int("replicas", 0, "copies to keep across peers, this node's included (default from config)")
+	pinAddCmd.Flags().String("class", "", "replication class to keep the pin by, from the config")
+	pinAddCmd.Flags().String("name", "", "name to tell the pin by")
+	pinAddCmd.Flags().StringArray("label", nil, "label the pin key=value, or key for a tag (repeatable)")
+	pinRmCmd.Flags().Bool("dry-run", false, "show what removing the pin would free, without removing it")
+	pinLabelCmd.Flags().String("name", "", "rename the pin")
+	pinLsCmd.Flags().Bool("short", false, "abbreviate hashes to the shortest unambiguous prefix")
+	pinLsCmd.Flags().StringArray("label", nil, "only list pins labelled key=value, or with key (repeatable)")
+	pinLsCmd.Flags().String("name", "", "only list pins whose name matches this pattern")
+	pinLsCmd.Flags().String("class", "", "only list pins kept by this replication class")
+	pinImportCmd.Flags().Bool("fetch", false, "fetch what isn't stored locally before pinning it")
+	addTransferFlags(pinImportCmd, "print only errors, without progress")
+	addTransferFlags(pinRecallCmd, "print only errors, without progress")
+
+	pinCmd.AddCommand(pinAddCmd)
+	pinCmd.AddCommand(pinRmCmd)
+	pinCmd.AddCommand(pinUpdateCmd)
+	pinCmd.AddCommand(pinLabelCmd)
+	pinCmd.AddCommand(pinArchiveCmd)
+	pinCmd.AddCommand(pinRecallCmd)
+	pinCmd.AddCommand(pinLsCmd)
+	pinCmd.AddCommand(pinExportCmd)
+	pinCmd.AddCommand(pinImportCmd)
+	rootCmd.AddCommand(pinCmd)
+}
@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+)
+
+// purgeReport is what privacy purge did, or would do with --dry-run.
+type purgeReport struct {
+	Time   time.Time `json:"time"`
+	Origin string    `json:"origin"`
+	Peer   string    `json:"peer,omitempty"`
+	DryRun bool      `json:"dry_run,omitempty"`
+	// Unpinned are the replicas unpinned.
+	Unpinned     []string `json:"unpinned,omitempty"`
+	Removed      int      `json:"removed"`
+	RemovedBytes int64    `json:"removed_bytes"`
+	// Linked counts blocks kept because pins of other origins use them,
+	// Recent those kept for files still being pinned.
+	Linked int `json:"linked"`
+	Recent int `json:"recent"`
+}
+
+var privacyCmd = &cobra.Command{
+	Use:   "privacy",
+	Short: "List and remove stored content by where it came from",
+}
+
+var privacyInventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "List the stored content by origin",
+	Long: `Inventory sorts every block the node stores by where it came from:
+
+  own       files added or pinned on this node
+  replica   replicas kept for a peer of the cluster, one line per peer
+  pending   adds and downloads still in progress
+  cache     fetched but not pinned, or left by removed pins
+
+A block an own pin uses counts as own only. One in the replicas of
+several peers counts for each of them.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.Inventory(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		}
+
+		row := func(origin, from string, o api.Origin) {
+			fmt.Fprintf(out, "%-8s  %-52s  %6d  %8d  %10s\n", origin, from, o.Pins, o.Blocks, formatBytes(o.Bytes))
+		}
+		fmt.Fprintf(out, "%-8s  %-52s  %6s  %8s  %10s\n", "ORIGIN", "PEER", "PINS", "BLOCKS", "SIZE")
+		row("own", "-", res.Own)
+		for _, r := range res.Replicas {
+			row("replica", r.Peer, r.Origin)
+		}
+		row("pending", "-", res.Pending)
+		row("cache", "-", res.Cache)
+		return nil
+	},
+}
+
+var privacyPurgeCmd = &cobra.Command{
+	Use:   "purge --origin cache|peer [peer-id]",
+	Short: "Remove the stored content of an origin",
+	Long: `Purge removes what "dfs privacy inventory" lists under an origin:
+
+  --origin cache         every block no pin uses, whatever its age
+  --origin peer <id>     the replicas kept for the peer, then the cache
+
+Blocks the pins of other origins use stay, and so do adds and
+downloads in progress. Purging a peer's replicas unpins them and runs a
+garbage collection that ignores the grace period, so the cache goes
+too. The peer may ask this node to keep them again; block it with
+"dfs peers block" to refuse.
+
+The report lists what was unpinned and removed. The daemon records
+purges in its event log, if it has one.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		origin, _ := cmd.Flags().GetString("origin")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		report := purgeReport{Time: time.Now().UTC(), Origin: origin, DryRun: dryRun}
+		switch {
+		case origin == api.PurgeCache && len(args) == 0:
+		case origin == api.PurgePeer && len(args) == 1:
+			id, err := peer.Decode(args[0])
+			if err != nil {
+				return fmt.Errorf("peer %s: %w", args[0], err)
+			}
+			report.Peer = id.String()
+		case origin == api.PurgePeer:
+			return fmt.Errorf("--origin peer needs a peer ID")
+		case origin == api.PurgeCache:
+			return fmt.Errorf("--origin cache takes no arguments")
+		default:
+			return fmt.Errorf("--origin must be %s or %s", api.PurgeCache, api.PurgePeer)
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.Purge(cmd.Context(), &api.PurgeRequest{Origin: origin, Peer: report.Peer, DryRun: dryRun})
+		if err != nil {
+			return err
+		}
+		report.Unpinned = res.Unpinned
+		report.Removed, report.RemovedBytes = res.Removed, res.RemovedBytes
+		report.Linked, report.Recent = res.Linked, res.Recent
+
+		out := cmd.OutOrStdout()
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		}
+		printPurgeReport(out, report)
+		return nil
+	},
+}
+
+func printPurgeReport(out io.Writer, r purgeReport) {
+	if r.DryRun {
+		fmt.Fprintln(out, "Dry run, nothing was changed.")
+	}
+	if r.Peer != "" {
+		fmt.Fprintf(out, "Unpinned:  %d replicas kept for %s\n", len(r.Unpinned), r.Peer)
+		for _, c := range r.Unpinned {
+			fmt.Fprintf(out, "           %s\n", c)
+		}
+	}
+	fmt.Fprintf(out, "Removed:   %d blocks (%s)\n", r.Removed, formatBytes(r.RemovedBytes))
+	if r.Linked > 0 {
+		fmt.Fprintf(out, "Kept:      %d blocks used by pins of other origins\n", r.Linked)
+	}
+	if r.Recent > 0 {
+		fmt.Fprintf(out, "Kept:      %d blocks of files still being pinned\n", r.Recent)
+	}
+}
+
+func init() {
+	privacyInventoryCmd.Flags().Bool("json", false, "print the inventory as JSON")
+	privacyPurgeCmd.Flags().String("origin", "", "origin to purge: cache or peer")
+	privacyPurgeCmd.Flags().Bool("dry-run", false, "report what would be removed without removing anything")
+	privacyPurgeCmd.Flags().Bool("json", false, "print the report as JSON")
+
+	privacyCmd.AddCommand(privacyInventoryCmd)
+	privacyCmd.AddCommand(privacyPurgeCmd)
+	rootCmd.AddCommand(privacyCmd)
+}
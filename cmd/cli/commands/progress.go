@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// progressBarWidth is the number of cells in the progress bar.
+const progressBarWidth = 24
+
+// transferEvent is a line of --json output from add, get, pin import, pin
+// recall and import.
+type transferEvent struct {
+	// Event is "progress" while a file transfers, then "added", "saved",
+	// "pinned" or "recalled".
+	Event string `json:"event"`
+	Name  string `json:"name,omitempty"`
+	Path  string `json:"path,omitempty"`
+	CID   string `json:"cid,omitempty"`
+	// From is the IPFS hash of what an IPFS import pinned as CID.
+	From   string `json:"from,omitempty"`
+	Dir    bool   `json:"dir,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Chunks int    `json:"chunks,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	Progress *api.Progress `json:"progress,omitempty"`
+}
+
+// transferReport shows how add and get are doing: a progress bar on
+// stderr when it is a terminal, JSON lines with --json, or only the
+// essentials with --quiet.
+type transferReport struct {
+	quiet bool
+	enc   *json.Encoder
+	// bar is drawn on a terminal, and the current one cleared before
+	// printing anything else.
+	tty bool
+	w   io.Writer
+	bar *progressBar
+	// size of the current transfer, for --json progress.
+	size int64
+}
+
+// newTransferReport reads --quiet and --json. JSON lines go to out.
+func newTransferReport(cmd *cobra.Command, out io.Writer) (*transferReport, error) {
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	if quiet && asJSON {
+		return nil, fmt.Errorf("--quiet and --json can't be used together")
+	}
+
+	t := &transferReport{quiet: quiet, w: cmd.ErrOrStderr()}
+	if asJSON {
+		t.enc = json.NewEncoder(out)
+	}
+	if f, ok := t.w.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			t.tty = info.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return t, nil
+}
+
+// verbose reports whether the usual text output is wanted.
+func (t *transferReport) verbose() bool {
+	return !t.quiet && t.enc == nil
+}
+
+// track returns the progress callback for a transfer of name, or nil when
+// progress isn't shown. A get sets the size once the daemon tells it.
+func (t *transferReport) track(name string, size int64, get bool) func(*api.Progress) {
+	t.size = size
+	switch {
+	case t.enc != nil:
+		return func(p *api.Progress) {
+			t.emit(transferEvent{Event: "progress", Name: name, Size: t.size, Progress: p})
+		}
+	case t.quiet || !t.tty:
+		return nil
+	}
+
+	t.clear()
+	t.bar = &progressBar{w: t.w, name: name, size: size, get: get}
+	return t.bar.update
+}
+
+// setFile records the name, size and chunk count of the file being
+// fetched.
+func (t *transferReport) setFile(name string, size int64, chunks int) {
+	t.size = size
+	if t.bar != nil {
+		t.bar.mu.Lock()
+		t.bar.name, t.bar.size, t.bar.chunks = name, size, chunks
+		t.bar.mu.Unlock()
+	}
+}
+
+// clear removes the progress bar of the last transfer.
+func (t *transferReport) clear() {
+	if t.bar != nil {
+		t.bar.clear()
+		t.bar = nil
+	}
+}
+
+func (t *transferReport) emit(ev transferEvent) {
+	t.enc.Encode(ev)
+}
+
+// progressBar draws one line, redrawn in place as progress comes in.
+type progressBar struct {
+	mu     sync.Mutex
+	w      io.Writer
+	name   string
+	size   int64
+	chunks int
+	get    bool
+
+	// phase is what a get is doing, fetching from peers or writing out;
+	// the rate is measured from when it started and from base.
+	phase string
+	start time.Time
+	base  int64
+	width int
+}
+
+func (b *progressBar) update(p *api.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	phase := "adding"
+	done, total := p.Bytes, b.size
+	chunks := fmt.Sprintf("%d chunks", p.Chunks)
+	if b.get {
+		phase = "writing"
+		chunks = fmt.Sprintf("%d chunks", b.chunks)
+		// Everything missing is fetched before the file is written
+		if p.MissingBytes > 0 && p.Bytes == 0 {
+			phase = "fetching"
+			done, total = p.FetchedBytes, p.MissingBytes
+			chunks = fmt.Sprintf("%d/%d chunks", min(p.FetchedChunks, p.MissingChunks), p.MissingChunks)
+		}
+	}
+	done = min(done, total)
+	if phase != b.phase {
+		b.phase, b.start, b.base = phase, time.Now(), done
+	}
+
+	var frac float64
+	if total > 0 {
+		frac = float64(done) / float64(total)
+	}
+	filled := int(frac * progressBarWidth)
+	parts := []string{
+		fmt.Sprintf("%s %-8s [%s%s] %3.0f%%", trimName(b.name, 20), phase,
+			strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), frac*100),
+		formatBytes(done) + "/" + formatBytes(total),
+		chunks,
+	}
+	if b.phase == "fetching" {
+		parts = append(parts, plural(p.Peers, "peer"))
+	}
+	if elapsed := time.Since(b.start).Seconds(); elapsed >= 1 && done > b.base {
+		rate := float64(done-b.base) / elapsed
+		eta := time.Duration(float64(total-done)/rate) * time.Second
+		parts = append(parts, formatBytes(int64(rate))+"/s", "ETA "+eta.Round(time.Second).String())
+	}
+
+	line := strings.Join(parts, "  ")
+	fmt.Fprintf(b.w, "\r%-*s", b.width, line)
+	b.width = utf8.RuneCountInString(line)
+}
+
+func (b *progressBar) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.width > 0 {
+		fmt.Fprintf(b.w, "\r%s\r", strings.Repeat(" ", b.width))
+		b.width = 0
+	}
+}
+
+// trimName shortens name to n characters, keeping its end.
+func trimName(name string, n int) string {
+	r := []rune(name)
+	if len(r) <= n {
+		return name
+	}
+	return "…" + string(r[len(r)-n+1:])
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// addTransferFlags registers --quiet and --json on a transfer command.
+func addTransferFlags(cmd *cobra.Command, quietUsage string) {
+	cmd.Flags().BoolP("quiet", "q", false, quietUsage)
+	cmd.Flags().Bool("json", false, "print progress and the result as JSON lines")
+}
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Noah-Wilderom/dfs/pkg/dnslink"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var publishSiteCmd = &cobra.Command{
+	Use:   "publish-site <dir>",
+	Short: "Publish a directory as a website",
+	Long: `Publish-site adds a directory, such as the output of a static site
+generator, points a name at it and prints where the gateway serves it:
+
+  dfs publish-site ./public --name blog
+
+The name is that of the key given with --name, created the first time it
+is used, so publishing the directory again after it changed moves the
+same name on to the new version, which replaces the last one's pin. A
+directory is served by its index.html, or else listed.
+
+The gateway serves what the network holds over HTTP; set gateway.addr in
+the config for the daemon to run it, and gateway.url when it is reached
+through a proxy. Encrypted files are never served, so --encrypt isn't
+offered here.
+
+--dns points a domain at the name with a DNSLink record, a TXT record on
+_dnslink.<domain>, for the gateway to serve the site when the domain
+resolves to it. The record is set through the DNS provider's API, with
+the credentials in the environment: CLOUDFLARE_API_TOKEN for cloudflare,
+the AWS_* variables for route53.
+
+  dfs publish-site ./public --name blog --dns blog.example.com --dns-provider cloudflare
+
+The record keeps pointing at the name, so it only needs setting once.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+		key, _ := cmd.Flags().GetString("name")
+		domain, _ := cmd.Flags().GetString("dns")
+		provider, _ := cmd.Flags().GetString("dns-provider")
+		if key == "" {
+			return fmt.Errorf("--name is required")
+		}
+		if (domain == "") != (provider == "") {
+			return fmt.Errorf("--dns and --dns-provider go together")
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+
+		// Fail on missing credentials before adding anything
+		var dns dnslink.Provider
+		if provider != "" {
+			if dns, err = dnslink.NewProvider(provider); err != nil {
+				return err
+			}
+		}
+
+		report, err := newTransferReport(cmd, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		ctx := cmd.Context()
+		out := cmd.OutOrStdout()
+
+		// What the name points to now is the last version, if anything
+		var old string
+		if res, err := client.ResolveKey(ctx, key); err == nil {
+			old = res.CID
+		}
+
+		params := cfg.Chunking.Params()
+		a := &dirAdder{cmd: cmd, client: client, params: &params, report: report}
+		root, err := a.add(dir, "", false)
+		if err != nil {
+			return err
+		}
+		if report.verbose() {
+			fmt.Fprintf(out, "Added %s\n", root)
+		}
+		if old != "" && old != root {
+			// A last version not pinned anymore has nothing to hand over
+			if _, err := client.UpdatePin(ctx, old, root); err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+
+		name, err := publishRoot(cmd, client, key, root)
+		if err != nil {
+			return err
+		}
+		if dns != nil {
+			if err := dnslink.Set(ctx, dns, domain, "/ipns/"+name); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Pointed %s at %s\n", dnslink.Record(domain), name)
+		}
+
+		if base := cfg.Gateway.PublicURL(); base != "" {
+			fmt.Fprintf(out, "Serving at %s/ipns/%s/\n", base, name)
+		} else {
+			fmt.Fprintln(cmd.ErrOrStderr(), "warning: no gateway is configured to serve the site, set gateway.addr")
+		}
+		if domain != "" {
+			fmt.Fprintf(out, "Serving at http://%s/ once %s resolves to a gateway\n", domain, domain)
+		}
+		return nil
+	},
+}
+
+func init() {
+	publishSiteCmd.Flags().String("name", "", "key whose name points at the site (required)")
+	publishSiteCmd.Flags().String("dns", "", "domain to point at the site with a DNSLink record")
+	publishSiteCmd.Flags().String("dns-provider", "", "DNS provider hosting the domain: cloudflare or route53")
+	publishSiteCmd.Flags().BoolP("quiet", "q", false, "print only where the site is published, without the files added or progress")
+	rootCmd.AddCommand(publishSiteCmd)
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var pushCmd = &cobra.Command{
+	Use:   "push <hash> --to <peer>",
+	Short: "Send a file to a peer now",
+	Long: `Push sends a file or directory tree this node stores to another node,
+such as your other machine, without either looking anything up:
+
+  dfs push bafy... --to 12D3KooW...
+  dfs push bafy... --to /ip4/192.0.2.1/tcp/9000/p2p/12D3KooW...
+
+--to is the ID of a connected peer, or a multiaddr to connect to it. The
+peer fetches everything from this node into its inbox, and push returns
+once it has; it is pinned there once accepted with "dfs inbox accept".
+Peers only take pushes with push.accept set in their config, and refuse
+those their push.peers, push.max_size and push.capacity rules don't allow.
+
+What isn't stored here whole can't be pushed; pin it first.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		to, _ := cmd.Flags().GetString("to")
+		if to == "" {
+			return fmt.Errorf("--to is required")
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.Push(cmd.Context(), args[0], to)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Pushed %s to %s\n", args[0], res.PeerID)
+		return nil
+	},
+}
+
+func init() {
+	pushCmd.Flags().String("to", "", "peer ID or multiaddr of the peer to send to (required)")
+	pushCmd.RegisterFlagCompletionFunc("to", completePeers)
+	rootCmd.AddCommand(pushCmd)
+}
@@ -0,0 +1,464 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/gc"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/push"
+	"github.com/Noah-Wilderom/dfs/pkg/repo"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Manage the local repository",
+}
+
+var repoCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Reclaim space in the block store",
+	Long: `Compact removes temp files left behind by interrupted writes, removes
+empty shard directories and moves misplaced blocks back into their shard.
+It is safe to run while the daemon is running; use --rate to limit the
+number of filesystem changes per second.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rate, _ := cmd.Flags().GetInt("rate")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		// Compaction only makes changes that are safe next to a running
+		// daemon, so a read lease is enough.
+		lock, err := repo.AcquireRead(cfg.DataDir)
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+
+		store, err := openRepoStore()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		report, err := store.Compact(cmd.Context(), storage.CompactOptions{
+			OpsPerSecond: rate,
+			DryRun:       dryRun,
+		})
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if dryRun {
+			fmt.Fprintln(out, "Dry run, nothing was changed.")
+		}
+		fmt.Fprintf(out, "Temp files removed:   %d (%s)\n", report.TempFilesRemoved, formatBytes(report.TempBytesRemoved))
+		fmt.Fprintf(out, "Empty shards removed: %d\n", report.EmptyShardsRemoved)
+		fmt.Fprintf(out, "Blocks relocated:     %d\n", report.BlocksRelocated)
+		printUsage(out, "Before", report.Before)
+		printUsage(out, "After", report.After)
+		return nil
+	},
+}
+
+var repoRebuildIndexCmd = &cobra.Command{
+	Use:   "rebuild-index",
+	Short: "Rebuild the block index and pin set by scanning the block store",
+	Long: `Rebuild-index re-reads every block, verifies it against its hash, sets
+corrupt blocks aside and recomputes the size accounting. It then lists the
+file manifests and directories found in the store and any chunks no
+manifest refers to, and the roots: the files and directories no stored
+directory refers to, which is what the pin set holds. Pass --pin to pin
+the roots it is missing, such as after pins.json was lost.
+It needs the repo's write lock, so stop the daemon first or pass
+--read-only, which reports corrupt blocks but leaves them in place.
+--dry-run does the same and lists the blocks that would be set aside. Both
+are safe to run while the daemon is running.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		var (
+			lock  *repo.Lock
+			store *storage.FSBlockstore
+			err   error
+		)
+		if dryRun {
+			lock, err = repo.AcquireRead(cfg.DataDir)
+		} else {
+			lock, err = lockRepo()
+		}
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+
+		if dryRun {
+			store, err = storage.OpenReadOnly(cfg.StoragePath())
+		} else {
+			store, err = openRepoStore()
+		}
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		ctx := cmd.Context()
+		report, err := store.Check(ctx, !store.ReadOnly())
+		if err != nil {
+			return err
+		}
+
+		idx, err := gc.ScanStore(ctx, store)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if dryRun {
+			fmt.Fprintln(out, "Dry run, nothing was changed.")
+		}
+		fmt.Fprintf(out, "Blocks:  %d (%s)\n", report.Blocks, formatBytes(report.Bytes))
+		if dryRun && len(report.Corrupt) > 0 {
+			fmt.Fprintf(out, "Corrupt: %d, would be set aside\n", len(report.Corrupt))
+		} else {
+			fmt.Fprintf(out, "Corrupt: %d\n", len(report.Corrupt))
+		}
+		for _, c := range report.Corrupt {
+			fmt.Fprintf(out, "  %s\n", c)
+		}
+		fmt.Fprintf(out, "Files:   %d\n", len(idx.Manifests))
+		for _, c := range idx.Manifests {
+			fmt.Fprintf(out, "  %s\n", c)
+		}
+		fmt.Fprintf(out, "Directories: %d\n", len(idx.Directories))
+		for _, c := range idx.Directories {
+			fmt.Fprintf(out, "  %s\n", c)
+		}
+		fmt.Fprintf(out, "Unreferenced chunks: %d\n", idx.Unreferenced)
+
+		// The roots are what a lost pin set held
+		restore, _ := cmd.Flags().GetBool("pin")
+		var pins *pin.Set
+		if restore && !dryRun && !store.ReadOnly() {
+			pins, err = pin.Open(cfg.PinsPath())
+		} else {
+			pins, err = pin.OpenReadOnly(cfg.PinsPath())
+		}
+		if err != nil {
+			return err
+		}
+		var unpinned []cid.Cid
+		for _, c := range idx.Roots {
+			if !pins.Has(c) {
+				unpinned = append(unpinned, c)
+			}
+		}
+		fmt.Fprintf(out, "Roots:   %d, %d not pinned\n", len(idx.Roots), len(unpinned))
+		for _, c := range unpinned {
+			fmt.Fprintf(out, "  %s\n", c)
+		}
+		if len(unpinned) == 0 {
+			return nil
+		}
+		if !restore {
+			fmt.Fprintln(out, "Pass --pin to pin them, so garbage collection keeps them.")
+			return nil
+		}
+		if dryRun || store.ReadOnly() {
+			return nil
+		}
+		added, err := gc.RestorePins(pins, idx)
+		fmt.Fprintf(out, "Pinned %d roots.\n", len(added))
+		return err
+	},
+}
+
+var repoStatCmd = &cobra.Command{
+	Use:   "stat",
+	Short: "Show what the repository holds and what deduplication saves",
+	Long: `Stat counts the blocks, files and directories in the block store and how
+many blocks are shared between files. Files that have chunks in common,
+such as versions of the same file, store those chunks once; the savings
+are what the shared chunks would take up again if every file kept its own
+copy. It is safe to run while the daemon is running.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		lock, err := repo.AcquireRead(cfg.DataDir)
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+
+		store, err := openRepoStore()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		ctx := cmd.Context()
+		if err := store.CountRefs(ctx, manifest.DecodeLinks, manifest.LinkCodecs...); err != nil {
+			return err
+		}
+		refs, err := store.RefStats(ctx)
+		if err != nil {
+			return err
+		}
+
+		var files, directories int
+		err = store.List(ctx, func(c cid.Cid) error {
+			switch c.Type() {
+			case manifest.Codec:
+				files++
+			case manifest.DirectoryCodec:
+				directories++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		pins, err := pin.OpenReadOnly(cfg.PinsPath())
+		if err != nil {
+			return err
+		}
+
+		stats := store.Stats()
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Path:        %s\n", store.Root())
+		fmt.Fprintf(out, "Blocks:      %d (%s)\n", stats.Blocks, formatBytes(stats.Bytes))
+		fmt.Fprintf(out, "Files:       %d\n", files)
+		fmt.Fprintf(out, "Directories: %d\n", directories)
+		fmt.Fprintf(out, "Pins:        %d\n", pins.Len())
+		fmt.Fprintf(out, "Referenced:  %d blocks, %d shared\n", refs.Referenced, refs.Shared)
+		fmt.Fprintf(out, "Dedup:       %s saved, %s stored for %s of references",
+			formatBytes(refs.SavedBytes()), formatBytes(refs.StoredBytes), formatBytes(refs.LinkedBytes))
+		if refs.StoredBytes > 0 {
+			fmt.Fprintf(out, " (%.2fx)", float64(refs.LinkedBytes)/float64(refs.StoredBytes))
+		}
+		fmt.Fprintln(out)
+		return nil
+	},
+}
+
+var repoGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove blocks no pin refers to",
+	Long: `GC deletes every block that isn't part of a pinned file, except blocks
+written within the grace period, which may belong to a file that is still
+being added. When the daemon is running it collects on its behalf; set
+gc.interval in the config to have it collect in the background.
+
+Blocks are only deleted while no read-only process is using the repo.
+Every collection is recorded; --history lists the recent ones.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if history, _ := cmd.Flags().GetBool("history"); history {
+			limit, _ := cmd.Flags().GetInt("limit")
+			return printGCHistory(cmd.OutOrStdout(), limit)
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		grace, _ := cmd.Flags().GetDuration("grace")
+		if grace < 0 {
+			return fmt.Errorf("--grace must not be negative")
+		}
+		if cfg.Storage.ReadOnly && !dryRun {
+			return fmt.Errorf("gc deletes blocks, use --dry-run with --read-only")
+		}
+		if grace == 0 {
+			grace = cfg.GC.GracePeriod
+		}
+
+		var res *api.GCResponse
+		lock, err := lockRepo()
+		switch {
+		case errors.Is(err, repo.ErrLocked):
+			res, err = gcViaDaemon(cmd, &api.GCRequest{GracePeriod: grace, DryRun: dryRun})
+		case err == nil:
+			defer lock.Close()
+			res, err = gcLocal(cmd, lock, gc.Options{GracePeriod: grace, DryRun: dryRun})
+		}
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if dryRun {
+			fmt.Fprintln(out, "Dry run, nothing was changed.")
+		}
+		fmt.Fprintf(out, "Pins:      %d (%d blocks)\n", res.Pins, res.Reachable)
+		fmt.Fprintf(out, "Scanned:   %d blocks\n", res.Scanned)
+		fmt.Fprintf(out, "Removed:   %d blocks (%s)\n", res.Removed, formatBytes(res.RemovedBytes))
+		fmt.Fprintf(out, "Kept:      %d unreferenced blocks younger than %s\n", res.Recent, res.GracePeriod)
+		if res.Linked > 0 {
+			fmt.Fprintf(out, "           %d unpinned blocks still used by kept files\n", res.Linked)
+		}
+		fmt.Fprintf(out, "Took:      %s\n", roundDuration(res.Duration))
+		return nil
+	},
+}
+
+// gcViaDaemon asks the daemon holding the repo to collect.
+func gcViaDaemon(cmd *cobra.Command, req *api.GCRequest) (*api.GCResponse, error) {
+	client, err := dialDaemon(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.GC(cmd.Context(), req)
+}
+
+func gcLocal(cmd *cobra.Command, lock *repo.Lock, gcOpts gc.Options) (*api.GCResponse, error) {
+	store, err := openRepoStore()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	// Keep chunks that files swept share with files kept
+	if err := store.CountRefs(cmd.Context(), manifest.DecodeLinks, manifest.LinkCodecs...); err != nil {
+		return nil, err
+	}
+
+	pins, err := pin.OpenReadOnly(cfg.PinsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep what unfinished downloads fetched so far,
+	downloads, err := node.OpenDownloads(cfg.DownloadsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	// and what waits in the inbox
+	inbox, err := push.OpenInbox(cfg.InboxPath())
+	if err != nil {
+		return nil, err
+	}
+
+	roots := func() []cid.Cid { return append(downloads.Roots(), inbox.Roots()...) }
+	opts := gc.CollectorOpts{Store: store, Pins: pins, Roots: roots, Lock: lock}
+	if !cfg.Storage.ReadOnly {
+		opts.HistoryPath = cfg.GCHistoryPath()
+	}
+	collector := gc.NewCollector(opts)
+	report, err := collector.Run(cmd.Context(), gcOpts)
+	if errors.Is(err, repo.ErrReadersAlive) {
+		return nil, fmt.Errorf("%w, try again once they exit", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &api.GCResponse{
+		Pins:         report.Pins,
+		Reachable:    report.Reachable,
+		Scanned:      report.Scanned,
+		Removed:      report.Removed,
+		RemovedBytes: report.RemovedBytes,
+		Recent:       report.Recent,
+		Linked:       report.Linked,
+		GracePeriod:  report.GracePeriod,
+		Duration:     report.Duration,
+	}, nil
+}
+
+// printGCHistory lists the last limit collections, newest first.
+func printGCHistory(out io.Writer, limit int) error {
+	records, err := gc.ReadHistory(cfg.GCHistoryPath())
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(out, "No garbage collections recorded.")
+		return nil
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+
+	fmt.Fprintf(out, "%-20s  %9s  %8s  %8s  %10s  %s\n", "STARTED", "TOOK", "SCANNED", "REMOVED", "RECLAIMED", "RESULT")
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		result := "ok"
+		switch {
+		case r.Error != "":
+			result = "error: " + r.Error
+		case r.DryRun:
+			result = "dry run"
+		}
+		fmt.Fprintf(out, "%-20s  %9s  %8d  %8d  %10s  %s\n",
+			r.Started.Local().Format("2006-01-02 15:04:05"), roundDuration(r.Duration),
+			r.Scanned, r.Removed, formatBytes(r.RemovedBytes), result)
+	}
+	return nil
+}
+
+// lockRepo takes the write lock, or a read lease when the repo is opened
+// read-only.
+func lockRepo() (*repo.Lock, error) {
+	if cfg.Storage.ReadOnly {
+		return repo.AcquireRead(cfg.DataDir)
+	}
+
+	lock, err := repo.AcquireWrite(cfg.DataDir)
+	if errors.Is(err, repo.ErrLocked) {
+		return nil, fmt.Errorf("%w (use --read-only to inspect it without changes)", err)
+	}
+	return lock, err
+}
+
+// openRepoStore opens the block store, read-only when configured so.
+func openRepoStore() (*storage.FSBlockstore, error) {
+	if cfg.Storage.ReadOnly {
+		return storage.OpenReadOnly(cfg.StoragePath())
+	}
+	return storage.Open(cfg.StoragePath())
+}
+
+func printUsage(out io.Writer, label string, du storage.DiskUsage) {
+	fmt.Fprintf(out, "%-7s %s on disk, %s apparent, %d files, %d dirs\n",
+		label+":", formatBytes(du.Allocated), formatBytes(du.Apparent), du.Files, du.Dirs)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	repoCmd.PersistentFlags().Bool("read-only", false, "open the repo without writing to it")
+
+	repoCompactCmd.Flags().Int("rate", 0, "maximum filesystem changes per second (0 is unlimited)")
+	repoCompactCmd.Flags().Bool("dry-run", false, "report what would change without changing anything")
+
+	repoRebuildIndexCmd.Flags().Bool("dry-run", false, "report the corrupt blocks that would be set aside without changing anything")
+	repoRebuildIndexCmd.Flags().Bool("pin", false, "pin the roots found that aren't pinned")
+	repoGCCmd.Flags().Bool("dry-run", false, "report what would be removed without removing anything")
+	repoGCCmd.Flags().Duration("grace", 0, "keep unpinned blocks younger than this (default from config)")
+	repoGCCmd.Flags().Bool("history", false, "list recent collections instead of collecting")
+	repoGCCmd.Flags().Int("limit", 20, "number of collections --history lists (0 for all)")
+
+	repoCmd.AddCommand(repoCompactCmd)
+	repoCmd.AddCommand(repoGCCmd)
+	repoCmd.AddCommand(repoRebuildIndexCmd)
+	repoCmd.AddCommand(repoStatCmd)
+	rootCmd.AddCommand(repoCmd)
+}
@@ -1,29 +1,116 @@
 package commands
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/config"
 	"github.com/Noah-Wilderom/dfs/pkg/logging"
+	"github.com/ipfs/go-cid"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 )
 
 var (
-	logger  = logging.MustNew()
+	// cancelTimeout releases the --timeout deadline.
+	cancelTimeout context.CancelFunc = func() {}
+
+	// logger is set up once the config is loaded, for a command to run.
+	logger  = zap.NewNop()
+	cfg     = config.Default()
 	rootCmd = &cobra.Command{
 		Use:   "dfs",
 		Short: "Distributed File System",
 		Long: `P2P File System
 			long description...`,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if cfg, err = config.LoadFromFlags(cmd.Flags()); err != nil {
+				return err
+			}
+
+			l, err := logging.New(logging.WithLevel(cfg.Logging.Level))
+			if err != nil {
+				return err
+			}
+			logger = l
+
+			// The deadline travels with every API call, so the daemon
+			// gives up on the work too.
+			if timeout, _ := cmd.Flags().GetDuration("timeout"); timeout > 0 {
+				ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+				cmd.SetContext(ctx)
+				cancelTimeout = cancel
+			}
+			return nil
+		},
 	}
 )
 
 func Execute() {
-	err := rootCmd.Execute()
+	// Interrupting the CLI cancels the request it is waiting on.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	cancelTimeout()
+	stop()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
+// ExecuteArgs runs the command line args in place of those dfs was run
+// with, for programs wrapping it.
+func ExecuteArgs(args []string) {
+	rootCmd.SetArgs(args)
+	Execute()
+}
+
 func init() {
-	//
+	config.AddFlags(rootCmd.PersistentFlags())
+	rootCmd.PersistentFlags().String("api", "", "daemon API socket path or host:port (default from config)")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "give up after this long (0 waits indefinitely)")
+}
+
+// dialDaemon connects to the control API of the running daemon.
+func dialDaemon(cmd *cobra.Command) (*api.Client, error) {
+	target := cfg.APISocketPath()
+	if cmd.Flags().Changed("api") {
+		target, _ = cmd.Flags().GetString("api")
+	}
+	return api.Dial(target)
+}
+
+// displayHashes returns cids as they are to be printed, abbreviated with
+// --short.
+func displayHashes(cmd *cobra.Command, client *api.Client, cids []string) ([]string, error) {
+	if short, _ := cmd.Flags().GetBool("short"); !short || len(cids) == 0 {
+		return cids, nil
+	}
+	return client.Abbreviate(cmd.Context(), cids)
+}
+
+// expandHash replaces an abbreviated hash at the start of p, a hash or a
+// path below one, with the full hash the daemon finds for it.
+func expandHash(cmd *cobra.Command, client *api.Client, p string) (string, error) {
+	lead := ""
+	if strings.HasPrefix(p, "/") {
+		lead = "/"
+	}
+	first, rest, below := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if _, err := cid.Decode(first); err == nil {
+		return p, nil
+	}
+	c, err := client.ResolvePath(cmd.Context(), first)
+	if err != nil {
+		return "", err
+	}
+	if !below {
+		return lead + c, nil
+	}
+	return lead + c + "/" + rest, nil
 }
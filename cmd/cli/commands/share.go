@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share <hash|path> --to <peer>",
+	Short: "Give a peer access to an encrypted file",
+	Long: `Share prints a token granting one peer access to an encrypted file added
+on this node, without giving it the master key. The token carries the
+file's key, wrapped so that only the node key of --to unwraps it, and is
+signed by this node; it may be passed on through any channel. The peer
+redeems it with "dfs share redeem" and can then fetch and read the file.
+
+With --expires the token stops working after that long, also for a peer
+that redeemed it already: the key is unwrapped again at every read. A
+peer that saved the file or its key meanwhile keeps them.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePaths),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		to, _ := cmd.Flags().GetString("to")
+		if to == "" {
+			return fmt.Errorf("--to is required: give the peer ID to share with")
+		}
+		req := &api.ShareRequest{Path: args[0], To: to}
+		expires, _ := cmd.Flags().GetDuration("expires")
+		switch {
+		case expires < 0:
+			return fmt.Errorf("--expires must not be negative")
+		case expires > 0:
+			req.Expires = time.Now().Add(expires)
+		}
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.Share(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), res.Token)
+		fmt.Fprintf(cmd.ErrOrStderr(), "Shared %s with %s; they redeem it with \"dfs share redeem <token>\"\n", res.CID, to)
+		return nil
+	},
+}
+
+var shareRedeemCmd = &cobra.Command{
+	Use:   "redeem <token|->",
+	Short: "Accept a token another node shared a file with",
+	Long: `Redeem checks that a token made by "dfs share" was signed by its issuer,
+grants this node access and hasn't expired, and keeps it: the file it
+names can then be read with "dfs get" like one added here. Use - to read
+the token from stdin. --pin also fetches the file and pins it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token := args[0]
+		if token == "-" {
+			data, err := io.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			token = string(data)
+		}
+		token = strings.TrimSpace(token)
+
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.RedeemShare(cmd.Context(), token)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Redeemed %s, shared by %s\n", res.Share.CID, res.Share.Issuer)
+		if !res.Share.Expires.IsZero() {
+			fmt.Fprintf(out, "Expires %s\n", res.Share.Expires.Local().Format(time.DateTime))
+		}
+
+		if pin, _ := cmd.Flags().GetBool("pin"); pin {
+			if err := client.Pin(cmd.Context(), &api.PinRequest{CID: res.Share.CID}); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Pinned %s\n", res.Share.CID)
+		}
+		return nil
+	},
+}
+
+var shareLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the shares this node redeemed",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListShares(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		cids := make([]string, len(res.Shares))
+		for i, s := range res.Shares {
+			cids[i] = s.CID
+		}
+		hashes, err := displayHashes(cmd, client, cids)
+		if err != nil {
+			return err
+		}
+		width := len("HASH")
+		for _, h := range hashes {
+			width = max(width, len(h))
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "%-*s  %-52s  %s\n", width, "HASH", "SHARED BY", "EXPIRES")
+		for i, s := range res.Shares {
+			expires := "never"
+			if !s.Expires.IsZero() {
+				expires = s.Expires.Local().Format(time.DateTime)
+			}
+			if s.Expired {
+				expires += " (expired)"
+			}
+			fmt.Fprintf(out, "%-*s  %-52s  %s\n", width, hashes[i], s.Issuer, expires)
+		}
+		return nil
+	},
+}
+
+func init() {
+	shareCmd.Flags().String("to", "", "peer ID to grant access to")
+	shareCmd.Flags().Duration("expires", 0, "stop the token working after this long, e.g. 72h (default never)")
+	shareCmd.RegisterFlagCompletionFunc("to", completePeers)
+	shareRedeemCmd.Flags().Bool("pin", false, "fetch and pin the shared file")
+	shareLsCmd.Flags().Bool("short", false, "abbreviate hashes to the shortest unambiguous prefix")
+
+	shareCmd.AddCommand(shareRedeemCmd)
+	shareCmd.AddCommand(shareLsCmd)
+	rootCmd.AddCommand(shareCmd)
+}
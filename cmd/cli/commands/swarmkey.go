@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/spf13/cobra"
+)
+
+var swarmKeyCmd = &cobra.Command{
+	Use:   "swarm-key",
+	Short: "Manage the private network key",
+}
+
+var swarmKeyGenerateCmd = &cobra.Command{
+	Use:   "generate [path]",
+	Short: "Create a key for a private network",
+	Long: `Generate writes a new pre-shared key for a private network. Copy it to
+every node that should be a member and set network.swarm_key to its path;
+nodes with the key only talk to each other. The path defaults to the
+configured network.swarm_key, or swarm.key in the data dir. An existing key
+is never overwritten.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := cfg.SwarmKeyPath()
+		switch {
+		case len(args) == 1:
+			path = args[0]
+		case path == "":
+			path = filepath.Join(cfg.DataDir, "swarm.key")
+		}
+
+		if err := network.GenerateSwarmKey(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Swarm key written to %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	swarmKeyCmd.AddCommand(swarmKeyGenerateCmd)
+	rootCmd.AddCommand(swarmKeyCmd)
+}
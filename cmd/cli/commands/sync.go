@@ -0,0 +1,963 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/syncpair"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// syncRetryInterval is how long --watch waits after a failed sync.
+const syncRetryInterval = 30 * time.Second
+
+// syncRemoteInterval is how often --watch looks whether the remote name
+// moved on.
+const syncRemoteInterval = time.Minute
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <dir> [hash|path]",
+	Short: "Store the changes to a directory since an earlier snapshot",
+	Long: `Sync compares a local directory with a directory stored earlier, given by
+its hash or a path below one, and stores a new snapshot of it. Files whose
+hash didn't change are taken over from the old snapshot without being
+read by the daemon; changed files are sent, and of those only the chunks
+the node doesn't have yet are stored. The hash of the new snapshot is
+printed last:
+
+  dfs sync ./photos              # first snapshot, like dfs add -r
+  dfs sync ./photos <hash>       # only what changed since <hash>
+
+The node remembers the last snapshot of each directory it synced, so
+later runs without a hash store only what changed since then.
+
+Changes are listed as they are found: "+" for new files, "M" for changed
+ones and "-" for files that are gone. With --dry-run nothing is stored.
+Only the new snapshot is pinned; old ones stay pinned until unpinned.
+Files matching the patterns in a .dfsignore file in the directory, written
+like a .gitignore, are left out. --exclude adds patterns like those and
+--include limits the sync to the paths given and what is under them. Both
+are remembered for the directory; an empty one, like --include=, clears
+them. "dfs sync policy" keeps the files of a directory online only, and
+"dfs sync ls" lists what is remembered.
+
+With --watch, sync keeps running and stores a new snapshot whenever files
+change, once they have been quiet for --debounce. It keeps only the
+latest snapshot it made pinned, which makes it suitable as a background
+backup agent:
+
+  dfs sync --watch --publish ./notes <hash>
+
+--publish points the node's name, or that of --key, at the new snapshot
+(see "dfs name"), so the latest backup can always be found under the same
+name. Both are remembered for the directory.
+
+--remote keeps the directory in step with a name other nodes publish to,
+for example one whose key they share. When the name moved on since the
+last sync, both sides are merged: what changed on one side only is taken
+from it, listed as "<" for files written from the remote and "x" for
+files removed because they are gone there. A file changed differently on
+both sides is a conflict, listed as "C": the remote version is written
+under its name and the local one kept as name.conflict-<peer>-<time>,
+where peer ends this node's ID. Conflicts are recorded in the event log
+and listed by "dfs sync conflicts ls" until resolved:
+
+  dfs sync --watch --remote <name> --publish --key notes ./notes`,
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: completeSnapshot,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+
+		params := cfg.Chunking.Params()
+		if err := params.Validate(); err != nil {
+			return err
+		}
+		opts := syncOptions{params: params}
+		opts.dryRun, _ = cmd.Flags().GetBool("dry-run")
+		opts.publish, _ = cmd.Flags().GetBool("publish")
+		opts.key, _ = cmd.Flags().GetString("key")
+		watch, _ := cmd.Flags().GetBool("watch")
+		debounce, _ := cmd.Flags().GetDuration("debounce")
+		if watch && opts.dryRun {
+			return fmt.Errorf("--watch and --dry-run don't go together")
+		}
+
+		job, err := openSyncJob(cmd, dir, opts)
+		if err != nil {
+			return err
+		}
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		job.client = client
+
+		base := job.pair.Base
+		if len(args) == 2 {
+			base = args[1]
+		}
+		run, err := job.sync(base, true)
+		if err != nil || opts.dryRun {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), run.root)
+
+		if !watch {
+			return nil
+		}
+		w := &syncWatcher{job: job, debounce: debounce}
+		return w.run(run.root, run.made)
+	},
+}
+
+type syncOptions struct {
+	params  chunking.Params
+	dryRun  bool
+	publish bool
+	key     string
+	// pair holds the settings of the directory's pair: the name it
+	// follows, its filters and its policies.
+	pair syncpair.Pair
+}
+
+// syncJob syncs a directory and keeps its pair: the name it follows, the
+// key it publishes under and the snapshot both last agreed on.
+type syncJob struct {
+	cmd    *cobra.Command
+	client *api.Client
+	dir    string
+	opts   syncOptions
+
+	pairs *syncpair.Store
+	pair  syncpair.Pair
+}
+
+// openSyncJob loads the pair of dir. Flags given override what the pair
+// remembers, and are remembered in turn.
+func openSyncJob(cmd *cobra.Command, dir string, opts syncOptions) (*syncJob, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := syncpair.Open(cfg.SyncPairsPath())
+	if err != nil {
+		return nil, err
+	}
+	pair, _ := pairs.Get(abs)
+	pair.Dir = abs
+
+	flags := cmd.Flags()
+	if flags.Changed("remote") {
+		pair.Remote, _ = flags.GetString("remote")
+	}
+	if flags.Changed("include") {
+		include, _ := flags.GetStringArray("include")
+		pair.Include = nil
+		for _, p := range include {
+			rel, err := syncRel(p)
+			if err != nil {
+				return nil, fmt.Errorf("--include: %w", err)
+			}
+			if rel != "" {
+				pair.Include = append(pair.Include, rel)
+			}
+		}
+	}
+	if flags.Changed("exclude") {
+		exclude, _ := flags.GetStringArray("exclude")
+		pair.Exclude = nil
+		for _, p := range exclude {
+			if err := new(ignoreRules).add(p); err != nil {
+				return nil, fmt.Errorf("--exclude %s: %w", p, err)
+			}
+			if p != "" {
+				pair.Exclude = append(pair.Exclude, p)
+			}
+		}
+	}
+	switch {
+	case flags.Changed("publish"):
+		pair.Key = ""
+		if opts.publish {
+			pair.Key = opts.key
+		}
+	case pair.Key != "":
+		opts.publish = true
+		if flags.Changed("key") {
+			pair.Key = opts.key
+		}
+		opts.key = pair.Key
+	}
+	return &syncJob{cmd: cmd, dir: dir, opts: opts, pairs: pairs, pair: pair}, nil
+}
+
+// sync stores a snapshot of the directory against base and publishes it,
+// when publishing, if it is new or always is set. Unless it is a dry run,
+// the pair then keeps the snapshot agreed on and the conflicts found.
+func (j *syncJob) sync(base string, always bool) (*syncRun, error) {
+	opts := j.opts
+	opts.pair = j.pair
+	run, err := syncOnce(j.cmd, j.client, j.dir, base, opts)
+	if j.opts.dryRun {
+		return run, err
+	}
+
+	if err == nil && j.opts.publish && (always || run.made) {
+		var name string
+		if name, err = publishRoot(j.cmd, j.client, j.opts.key, run.root); err == nil && name == j.pair.Remote {
+			run.remote = run.root
+		}
+	}
+	if err == nil {
+		// A directory that follows a name agrees with what the name points
+		// at, which is its own snapshot only once published there.
+		j.pair.Base = run.root
+		if j.pair.Remote != "" {
+			j.pair.Base = run.remote
+		}
+	}
+	// Conflict copies are on disk even when the sync failed after them
+	j.pair.Conflicts = append(j.pair.Conflicts, run.conflicts...)
+	if perr := j.pairs.Put(j.pair); perr != nil && err == nil {
+		err = perr
+	}
+	return run, err
+}
+
+// syncRel turns p, a path below a synced directory, into the slash-separated
+// form pairs keep. The directory itself is "".
+func syncRel(p string) (string, error) {
+	rel := path.Clean(filepath.ToSlash(p))
+	rel = strings.TrimPrefix(rel, "/")
+	if rel == "." || rel == "" {
+		return "", nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s is not below the directory", p)
+	}
+	return rel, nil
+}
+
+// syncRun is what syncOnce stored.
+type syncRun struct {
+	// root is the new snapshot, made whether it is a new one.
+	root string
+	made bool
+	// remote is what the remote name pointed at, empty when there is no
+	// remote or nothing was published under it yet.
+	remote    string
+	conflicts []syncpair.Conflict
+}
+
+// syncOnce stores a snapshot of dir against the earlier one at base, if
+// any, merged with the remote name when that moved on since base. The run
+// returned holds the conflicts found even when it fails.
+func syncOnce(cmd *cobra.Command, client *api.Client, dir, base string, opts syncOptions) (*syncRun, error) {
+	ctx := cmd.Context()
+	run := &syncRun{}
+	filter, err := loadSyncFilter(dir, opts.pair)
+	if err != nil {
+		return run, err
+	}
+
+	var old *api.ListDirectoryResponse
+	if base != "" {
+		old, err = client.ListDirectory(ctx, base)
+		if status.Code(err) == codes.FailedPrecondition {
+			return run, fmt.Errorf("%s is not a directory", base)
+		}
+		if err != nil {
+			return run, err
+		}
+	}
+
+	var remote *api.ListDirectoryResponse
+	if name := opts.pair.Remote; name != "" {
+		res, err := client.ResolveName(ctx, name)
+		switch {
+		case status.Code(err) == codes.NotFound:
+			// Nothing published under it yet
+		case err != nil:
+			return run, fmt.Errorf("resolving %s: %w", name, err)
+		default:
+			run.remote = res.CID
+		}
+		if run.remote != "" && run.remote != base {
+			remote, err = client.ListDirectory(ctx, run.remote)
+			if status.Code(err) == codes.FailedPrecondition {
+				return run, fmt.Errorf("%s doesn't point at a directory", name)
+			}
+			if err != nil {
+				return run, err
+			}
+		}
+	}
+
+	s := &syncer{
+		cmd:    cmd,
+		client: client,
+		params: &opts.params,
+		dryRun: opts.dryRun,
+		filter: filter,
+		// What this node leaves out is still the remote's
+		keepIgnored: opts.pair.Remote != "",
+	}
+	var m *merger
+	if remote == nil {
+		run.root, err = s.sync(dir, "", old, false)
+	} else {
+		var info *api.NodeInfoResponse
+		if info, err = client.NodeInfo(ctx); err != nil {
+			return run, err
+		}
+		m = &merger{syncer: s, transfers: &transferReport{quiet: true}, peer: conflictPeer(info.PeerID), now: time.Now().UTC()}
+		run.root, err = m.merge(dir, "", old, remote, false)
+		run.conflicts = m.conflicts
+		if !opts.dryRun {
+			if rerr := m.record(dir); rerr != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: recording conflicts: %v\n", rerr)
+			}
+		}
+		// Taken over as it is, so pinned like a snapshot stored here
+		if err == nil && !opts.dryRun && run.root == remote.CID {
+			err = client.Pin(ctx, &api.PinRequest{CID: run.root})
+		}
+	}
+	if err != nil {
+		return run, err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Added %d, changed %d, removed %d, unchanged %d", s.added, s.changed, s.removed, s.unchanged)
+	if m != nil {
+		fmt.Fprintf(out, ", fetched %d, conflicts %d", m.fetched, len(m.conflicts))
+	}
+	if opts.dryRun {
+		fmt.Fprintln(out)
+		return run, nil
+	}
+	fmt.Fprintf(out, "; stored %d new chunks (%s)\n", s.newChunks, formatBytes(s.newBytes))
+	run.made = old == nil || run.root != old.CID
+	return run, nil
+}
+
+// conflictPeer is the end of a peer ID that names it in conflict names.
+func conflictPeer(id string) string {
+	const n = 8
+	if len(id) > n {
+		return id[len(id)-n:]
+	}
+	return id
+}
+
+// publishRoot points the name of key at root and returns the name.
+func publishRoot(cmd *cobra.Command, client *api.Client, key, root string) (string, error) {
+	res, err := client.PublishName(cmd.Context(), key, root)
+	if err != nil {
+		return "", err
+	}
+	if res.Warning != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s, will retry when republishing\n", res.Warning)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Published %s\n", res.Name)
+	return res.Name, nil
+}
+
+// syncWatcher syncs a directory again whenever something in it changes,
+// or the name it follows moves on.
+type syncWatcher struct {
+	job      *syncJob
+	debounce time.Duration
+
+	watcher *fsnotify.Watcher
+	filter  *syncFilter
+}
+
+// run watches until the command is interrupted. root is the current
+// snapshot, own whether this run made it; a snapshot it made is unpinned
+// once a newer one replaces it.
+func (w *syncWatcher) run(root string, own bool) error {
+	cmd, client := w.job.cmd, w.job.client
+	ctx := cmd.Context()
+	out := cmd.OutOrStdout()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	w.watcher = watcher
+
+	if w.filter, err = loadSyncFilter(w.job.dir, w.job.pair); err != nil {
+		return err
+	}
+	if err := w.add(w.job.dir); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Watching %s for changes\n", w.job.dir)
+
+	var poll <-chan time.Time
+	if w.job.pair.Remote != "" {
+		ticker := time.NewTicker(syncRemoteInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if w.ignored(ev.Name) {
+				continue
+			}
+			// New directories need watching too
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err := w.add(ev.Name); err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "warning: can't watch %s: %v\n", ev.Name, err)
+					}
+				}
+			}
+			// Wait for things to settle before syncing
+			timer.Reset(w.debounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: watching: %v\n", err)
+
+		case <-poll:
+			res, err := client.ResolveName(ctx, w.job.pair.Remote)
+			if err == nil && res.CID != w.job.pair.Base {
+				timer.Reset(0)
+			}
+
+		case <-timer.C:
+			run, err := w.job.sync(w.job.pair.Base, false)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "sync failed, retrying in %s: %v\n", syncRetryInterval, err)
+				timer.Reset(syncRetryInterval)
+				continue
+			}
+			// The rules may have changed with the files
+			if filter, err := loadSyncFilter(w.job.dir, w.job.pair); err == nil {
+				w.filter = filter
+			}
+			if run.root == root {
+				continue
+			}
+
+			if own {
+				if err := client.Unpin(ctx, root); err != nil && status.Code(err) != codes.NotFound {
+					fmt.Fprintf(cmd.ErrOrStderr(), "warning: unpinning %s: %v\n", root, err)
+				}
+			}
+			fmt.Fprintln(out, run.root)
+			root, own = run.root, true
+		}
+	}
+}
+
+// add watches the directory at path and those below it, except ignored
+// ones.
+func (w *syncWatcher) add(path string) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Gone again before it could be watched
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != w.job.dir && w.ignored(p) {
+			return filepath.SkipDir
+		}
+		return w.watcher.Add(p)
+	})
+}
+
+// ignored reports whether an event for path can be left alone. Removed
+// paths can't be told apart, so directory patterns apply to any path.
+// Nothing under an online-only directory is synced from disk.
+func (w *syncWatcher) ignored(path string) bool {
+	rel, err := filepath.Rel(w.job.dir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	return w.filter.match(rel, false) || w.filter.match(rel, true) || w.filter.policy(rel) == syncpair.OnlineOnly
+}
+
+// syncer stores a directory tree bottom up like dirAdder, taking over
+// unchanged files and directories from an earlier snapshot.
+type syncer struct {
+	cmd    *cobra.Command
+	client *api.Client
+	params *chunking.Params
+	dryRun bool
+	filter *syncFilter
+	// keepIgnored keeps what the earlier snapshot has under names left
+	// out, rather than dropping them from the new one.
+	keepIgnored bool
+
+	added, changed, removed, unchanged int
+	newChunks                          int
+	newBytes                           int64
+}
+
+// sync stores the directory at path, shown as rel, and returns its hash.
+// old is the directory's earlier snapshot, nil if there was none. With
+// dryRun the hash is only right when nothing changed.
+func (s *syncer) sync(path, rel string, old *api.ListDirectoryResponse, nested bool) (string, error) {
+	ctx := s.cmd.Context()
+
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	before := make(map[string]api.DirectoryEntry)
+	if old != nil {
+		for _, e := range old.Entries {
+			before[e.Name] = e
+		}
+	}
+
+	req := &api.MakeDirectoryRequest{NoPin: nested}
+	stored := make(map[string]bool)
+	same := old != nil
+	for _, e := range dirEntries {
+		entryPath := filepath.Join(path, e.Name())
+		entryRel := filepath.Join(rel, e.Name())
+		slashRel := filepath.ToSlash(entryRel)
+		if s.filter.match(slashRel, e.IsDir()) || s.filter.policy(slashRel) == syncpair.OnlineOnly {
+			continue
+		}
+		prev, existed := before[e.Name()]
+		if existed && (prev.Dir != e.IsDir() || !e.IsDir() && !e.Type().IsRegular()) {
+			// Replaced by something else, which is new
+			existed = false
+		} else {
+			delete(before, e.Name())
+		}
+
+		var c string
+		switch {
+		case e.IsDir():
+			var sub *api.ListDirectoryResponse
+			if existed {
+				if sub, err = s.client.ListDirectory(ctx, prev.CID); err != nil {
+					return "", fmt.Errorf("%s: %w", entryRel, err)
+				}
+			}
+			if c, err = s.sync(entryPath, entryRel, sub, true); err != nil {
+				return "", err
+			}
+		case e.Type().IsRegular():
+			if c, err = s.syncFile(entryPath, entryRel, prev, existed); err != nil {
+				return "", fmt.Errorf("%s: %w", entryRel, err)
+			}
+		default:
+			fmt.Fprintf(s.cmd.ErrOrStderr(), "Skipping %s: not a regular file\n", entryRel)
+			continue
+		}
+
+		if !existed || c != prev.CID {
+			same = false
+		}
+		req.Entries = append(req.Entries, api.DirectoryEntry{Name: e.Name(), CID: c})
+		stored[e.Name()] = true
+	}
+
+	// Whatever is left is gone from disk, or was never read from it
+	for _, name := range slices.Sorted(maps.Keys(before)) {
+		e := before[name]
+		entryRel := filepath.Join(rel, name)
+		if !stored[name] && s.keeps(filepath.ToSlash(entryRel), e.Dir) {
+			req.Entries = append(req.Entries, e)
+			continue
+		}
+		if e.Dir {
+			entryRel += string(filepath.Separator)
+		}
+		s.report("-", entryRel)
+		s.removed++
+		same = false
+	}
+
+	if same {
+		return old.CID, nil
+	}
+	if s.dryRun {
+		return "", nil
+	}
+	res, err := s.client.MakeDirectory(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return res.CID, nil
+}
+
+// keeps reports whether the earlier snapshot's entry at rel, which isn't
+// on disk or was left out, stays in the new one. Files of online-only and
+// cloud-first directories aren't expected on disk.
+func (s *syncer) keeps(rel string, dir bool) bool {
+	if s.filter.policy(rel) != syncpair.Local {
+		return true
+	}
+	return s.keepIgnored && s.filter.match(rel, dir)
+}
+
+// syncFile stores the file at path unless it still matches prev.
+func (s *syncer) syncFile(path, rel string, prev api.DirectoryEntry, existed bool) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if existed {
+		info, err := f.Stat()
+		if err != nil {
+			return "", err
+		}
+		// Only a file of the same size can still have the same hash
+		if info.Size() == prev.Size {
+			c, err := manifest.Hash(s.cmd.Context(), f, filepath.Base(path), *s.params)
+			if err != nil {
+				return "", err
+			}
+			if c.String() == prev.CID {
+				s.unchanged++
+				return prev.CID, nil
+			}
+			if _, err := f.Seek(0, 0); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if existed {
+		s.report("M", rel)
+		s.changed++
+	} else {
+		s.report("+", rel)
+		s.added++
+	}
+	if s.dryRun {
+		return "", nil
+	}
+
+	req := &api.AddRequest{Name: filepath.Base(path), Chunking: s.params, NoPin: true}
+	if existed {
+		req.Base = prev.CID
+	}
+	res, err := s.client.Add(s.cmd.Context(), req, f, nil)
+	if err != nil {
+		return "", err
+	}
+	if res.Stats != nil {
+		s.newChunks += res.Chunks - res.Stats.DedupChunks
+		s.newBytes += res.Size - res.Stats.DedupBytes
+	}
+	return res.CID, nil
+}
+
+func (s *syncer) report(change, rel string) {
+	fmt.Fprintf(s.cmd.OutOrStdout(), "%s %s\n", change, rel)
+}
+
+var syncLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the synced directories and their settings",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pairs, err := syncpair.Open(cfg.SyncPairsPath())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		for _, p := range pairs.List() {
+			fmt.Fprintln(out, p.Dir)
+			if p.Base != "" {
+				fmt.Fprintf(out, "  snapshot   %s\n", p.Base)
+			}
+			if p.Remote != "" {
+				fmt.Fprintf(out, "  remote     %s\n", p.Remote)
+			}
+			if p.Key != "" {
+				fmt.Fprintf(out, "  publish    %s\n", p.Key)
+			}
+			if len(p.Include) > 0 {
+				fmt.Fprintf(out, "  include    %s\n", strings.Join(p.Include, ", "))
+			}
+			if len(p.Exclude) > 0 {
+				fmt.Fprintf(out, "  exclude    %s\n", strings.Join(p.Exclude, ", "))
+			}
+			for _, rel := range slices.Sorted(maps.Keys(p.Policies)) {
+				fmt.Fprintf(out, "  policy     %s %s\n", rel, p.Policies[rel])
+			}
+			if len(p.Conflicts) > 0 {
+				fmt.Fprintf(out, "  conflicts  %d\n", len(p.Conflicts))
+			}
+		}
+		return nil
+	},
+}
+
+var syncPolicyCmd = &cobra.Command{
+	Use:   "policy <dir> <path> <local|cloud-first|online-only>",
+	Short: "Set how the files of a directory below a synced one are kept on disk",
+	Long: `Policy sets how the files of path, a directory below the synced directory
+dir, are kept on disk. Directories below path follow it unless they have
+a policy of their own:
+
+  local        files are on disk and synced both ways, the default
+  cloud-first  files on disk are synced both ways, but new ones from the
+               remote aren't fetched and a file removed from disk stays
+               stored rather than being removed from the snapshot
+  online-only  nothing under path is read or written on disk; the
+               snapshot keeps what it had, and the remote's changes
+
+Once the directory is online-only, its files can be removed from disk
+without being removed from the snapshot; sync it first so the snapshot
+has the latest of them. Setting a directory back to local fetches what
+the last snapshot has under it and isn't on disk.
+
+There is no virtual filesystem showing online-only files as placeholders:
+"dfs get" fetches one when it is needed.`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		abs, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		rel, err := syncRel(args[1])
+		if err != nil {
+			return err
+		}
+		if rel == "" {
+			return fmt.Errorf("the policy is set on a directory below %s", args[0])
+		}
+		policy, err := syncpair.ParsePolicy(args[2])
+		if err != nil {
+			return err
+		}
+
+		pairs, err := syncpair.Open(cfg.SyncPairsPath())
+		if err != nil {
+			return err
+		}
+		pair, _ := pairs.Get(abs)
+		pair.Dir = abs
+		was := pair.PolicyOf(rel)
+
+		policies := maps.Clone(pair.Policies)
+		delete(policies, rel)
+		// Only what differs from the directory above is kept
+		if (syncpair.Pair{Policies: policies}).PolicyOf(rel) != policy {
+			if policies == nil {
+				policies = make(map[string]syncpair.Policy)
+			}
+			policies[rel] = policy
+		}
+		pair.Policies = policies
+		if len(pair.Policies) == 0 {
+			pair.Policies = nil
+		}
+		if err := pairs.Put(pair); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s is %s\n", rel, policy)
+
+		if policy != syncpair.Local || was == syncpair.Local || pair.Base == "" {
+			return nil
+		}
+		return materialize(cmd, pair, rel)
+	},
+}
+
+// materialize fetches what the last snapshot of pair has under rel and
+// isn't on disk.
+func materialize(cmd *cobra.Command, pair syncpair.Pair, rel string) error {
+	client, err := dialDaemon(cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	dir, err := client.ListDirectory(cmd.Context(), pair.Base+"/"+rel)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	filter, err := loadSyncFilter(pair.Dir, pair)
+	if err != nil {
+		return err
+	}
+	m := &merger{
+		syncer:    &syncer{cmd: cmd, client: client, filter: filter},
+		transfers: &transferReport{quiet: true},
+	}
+	if err := m.fetchDir(filepath.Join(pair.Dir, filepath.FromSlash(rel)), filepath.FromSlash(rel), dir); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Fetched %s from %s\n", rel, pair.Base)
+	return nil
+}
+
+var syncConflictsCmd = &cobra.Command{
+	Use:   "conflicts",
+	Short: "List and resolve the conflicts of synced directories",
+}
+
+var syncConflictsLsCmd = &cobra.Command{
+	Use:   "ls [dir]",
+	Short: "List the unresolved conflicts, of dir or of every synced directory",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pairs, err := syncpair.Open(cfg.SyncPairsPath())
+		if err != nil {
+			return err
+		}
+		list := pairs.List()
+		if len(args) == 1 {
+			abs, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			pair, ok := pairs.Get(abs)
+			if !ok {
+				return fmt.Errorf("%s is not synced", args[0])
+			}
+			list = []syncpair.Pair{pair}
+		}
+
+		out := cmd.OutOrStdout()
+		for _, p := range list {
+			for _, c := range p.Conflicts {
+				fmt.Fprintf(out, "%s  %s  %s (local version)\n", c.Time.Local().Format(time.DateTime),
+					filepath.Join(p.Dir, filepath.FromSlash(c.Path)), c.Copy)
+			}
+		}
+		return nil
+	},
+}
+
+var syncConflictsResolveCmd = &cobra.Command{
+	Use:   "resolve <dir> <path> --keep local|remote|both",
+	Short: "Resolve a conflict by keeping one version or both",
+	Long: `Resolve settles the conflict at path, relative to the synced directory dir,
+given by the file's name or that of its conflict copy. --keep local moves
+the local version back over the remote one, --keep remote removes the
+local version, and --keep both leaves both files as they are. The next
+sync stores the result.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keep, _ := cmd.Flags().GetString("keep")
+		switch keep {
+		case "local", "remote", "both":
+		default:
+			return fmt.Errorf("--keep must be local, remote or both")
+		}
+		abs, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		pairs, err := syncpair.Open(cfg.SyncPairsPath())
+		if err != nil {
+			return err
+		}
+		pair, ok := pairs.Get(abs)
+		if !ok {
+			return fmt.Errorf("%s is not synced", args[0])
+		}
+		path := filepath.ToSlash(filepath.Clean(args[1]))
+		var c syncpair.Conflict
+		for _, pc := range pair.Conflicts {
+			if pc.Path == path || pc.Copy == path {
+				c = pc
+			}
+		}
+		if c.Path == "" {
+			return fmt.Errorf("no conflict at %s", args[1])
+		}
+
+		file := filepath.Join(abs, filepath.FromSlash(c.Path))
+		copyPath := filepath.Join(abs, filepath.FromSlash(c.Copy))
+		switch keep {
+		case "local":
+			if err := os.RemoveAll(file); err != nil {
+				return err
+			}
+			if err := os.Rename(copyPath, file); err != nil {
+				return err
+			}
+		case "remote":
+			if err := os.RemoveAll(copyPath); err != nil {
+				return err
+			}
+		}
+		if _, err := pairs.Resolve(abs, c.Path); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Resolved %s, keeping %s\n", c.Path, keep)
+		return nil
+	},
+}
+
+func init() {
+	syncCmd.Flags().Bool("dry-run", false, "list the changes without storing anything")
+	syncCmd.Flags().Bool("publish", false, "point a name at the new snapshot")
+	syncCmd.Flags().String("key", "self", "key of the name to publish under")
+	syncCmd.Flags().Bool("watch", false, "keep syncing as files change, until interrupted")
+	syncCmd.Flags().Duration("debounce", 2*time.Second, "with --watch, wait for changes to stop this long before syncing")
+	syncCmd.Flags().String("remote", "", "name to keep the directory in step with, merging its changes")
+	syncCmd.Flags().StringArray("include", nil, "only sync this path below the directory and what is under it, repeatable")
+	syncCmd.Flags().StringArray("exclude", nil, "leave out paths matching this .dfsignore pattern, repeatable")
+	syncConflictsResolveCmd.Flags().String("keep", "", "version to keep: local, remote or both")
+
+	syncConflictsCmd.AddCommand(syncConflictsLsCmd, syncConflictsResolveCmd)
+	syncCmd.AddCommand(syncLsCmd, syncPolicyCmd, syncConflictsCmd)
+
+	rootCmd.AddCommand(syncCmd)
+}
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/syncpair"
+)
+
+// syncFilter is what "dfs sync" leaves out of a directory: what its
+// .dfsignore file and the pair's exclude patterns ignore and what lies
+// outside the pair's include paths. It also holds the policies of the
+// directories below it.
+type syncFilter struct {
+	ignore *ignoreRules
+	pair   syncpair.Pair
+}
+
+// loadSyncFilter reads the ignore file in dir and adds the filters of
+// pair.
+func loadSyncFilter(dir string, pair syncpair.Pair) (*syncFilter, error) {
+	ignore, err := loadIgnore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ignoreFile, err)
+	}
+	// Patterns of the pair come last, so they win
+	for _, line := range pair.Exclude {
+		if err := ignore.add(line); err != nil {
+			return nil, fmt.Errorf("exclude pattern %s: %w", line, err)
+		}
+	}
+	return &syncFilter{ignore: ignore, pair: pair}, nil
+}
+
+// match reports whether rel, a slash-separated path below the top
+// directory, is left out. Directories leading to an included path are
+// kept, for what is included below them.
+func (f *syncFilter) match(rel string, dir bool) bool {
+	if f == nil {
+		return false
+	}
+	if f.ignore.match(rel, dir) {
+		return true
+	}
+	if len(f.pair.Include) == 0 {
+		return false
+	}
+	for _, inc := range f.pair.Include {
+		if rel == inc || strings.HasPrefix(rel, inc+"/") || dir && strings.HasPrefix(inc, rel+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// policy returns the policy of rel, a slash-separated path below the top
+// directory.
+func (f *syncFilter) policy(rel string) syncpair.Policy {
+	if f == nil {
+		return syncpair.Local
+	}
+	return f.pair.PolicyOf(rel)
+}
@@ -0,0 +1,395 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/syncpair"
+)
+
+// merger stores a directory merged with a remote snapshot that moved on
+// since the one both last agreed on. What changed on one side only is
+// taken from it; a file changed differently on both is kept twice, the
+// local version under a conflict name. Local changes are stored through
+// the syncer.
+type merger struct {
+	*syncer
+	// transfers reports the downloads, quietly.
+	transfers *transferReport
+	// peer names this node in conflict names, now dates them.
+	peer string
+	now  time.Time
+
+	fetched   int
+	conflicts []syncpair.Conflict
+}
+
+// merge stores the directory at path, shown as rel, merged with remote
+// against base, nil when there was none, and returns its hash. With dryRun
+// nothing is changed on disk and the hash is only right when it is the
+// remote's.
+func (m *merger) merge(path, rel string, base, remote *api.ListDirectoryResponse, nested bool) (string, error) {
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	local := make(map[string]fs.DirEntry, len(dirEntries))
+	for _, e := range dirEntries {
+		local[e.Name()] = e
+	}
+	before, after := entriesByName(base), entriesByName(remote)
+
+	names := make(map[string]bool)
+	for _, entries := range []map[string]api.DirectoryEntry{before, after} {
+		for name := range entries {
+			names[name] = true
+		}
+	}
+	for name := range local {
+		names[name] = true
+	}
+
+	req := &api.MakeDirectoryRequest{NoPin: nested}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		entries, err := m.mergeEntry(path, rel, name, local, before, after)
+		if err != nil {
+			return "", err
+		}
+		req.Entries = append(req.Entries, entries...)
+	}
+
+	if sameEntries(req.Entries, remote.Entries) {
+		return remote.CID, nil
+	}
+	if m.dryRun {
+		return "", nil
+	}
+	res, err := m.client.MakeDirectory(m.cmd.Context(), req)
+	if err != nil {
+		return "", err
+	}
+	return res.CID, nil
+}
+
+// mergeEntry merges what the local directory, base and remote have under
+// name and returns the entries that stand for it in the merged directory.
+func (m *merger) mergeEntry(path, rel, name string, local map[string]fs.DirEntry, before, after map[string]api.DirectoryEntry) ([]api.DirectoryEntry, error) {
+	ctx := m.cmd.Context()
+	entryPath := filepath.Join(path, name)
+	entryRel := filepath.Join(rel, name)
+	e, hasLocal := local[name]
+	b, hadBase := before[name]
+	r, hasRemote := after[name]
+
+	// Ignored and special files and what is online only are left alone
+	// on disk, and the remote keeps what it has under their names. So does
+	// a cloud-first file that isn't on disk.
+	slashRel := filepath.ToSlash(entryRel)
+	isDir := hasLocal && e.IsDir() || !hasLocal && r.Dir
+	special := hasLocal && !e.IsDir() && !e.Type().IsRegular()
+	if special {
+		fmt.Fprintf(m.cmd.ErrOrStderr(), "Skipping %s: not a regular file\n", entryRel)
+	}
+	policy := m.filter.policy(slashRel)
+	if special || m.filter.match(slashRel, isDir) || policy == syncpair.OnlineOnly ||
+		policy == syncpair.CloudFirst && !hasLocal {
+		if hasRemote {
+			return []api.DirectoryEntry{r}, nil
+		}
+		return nil, nil
+	}
+
+	ls := syncpair.Side{Present: hasLocal, Dir: hasLocal && e.IsDir()}
+	if hasLocal && !e.IsDir() {
+		c, err := m.hashLocal(entryPath, b, r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entryRel, err)
+		}
+		ls.CID = c
+	}
+
+	var c string
+	var err error
+	switch syncpair.Decide(side(b, hadBase), ls, side(r, hasRemote)) {
+	case syncpair.Keep:
+		m.unchanged++
+		return []api.DirectoryEntry{b}, nil
+
+	case syncpair.TakeLocal:
+		if !hasLocal {
+			if hadBase {
+				m.report("-", dirRel(entryRel, b.Dir))
+				m.removed++
+			}
+			return nil, nil
+		}
+		if e.IsDir() {
+			var sub *api.ListDirectoryResponse
+			if hadBase && b.Dir {
+				if sub, err = m.client.ListDirectory(ctx, b.CID); err != nil {
+					return nil, fmt.Errorf("%s: %w", entryRel, err)
+				}
+			}
+			c, err = m.sync(entryPath, entryRel, sub, true)
+		} else if c, err = m.syncFile(entryPath, entryRel, b, hadBase && !b.Dir); err != nil {
+			err = fmt.Errorf("%s: %w", entryRel, err)
+		}
+
+	case syncpair.TakeRemote:
+		if !hasRemote {
+			m.report("x", entryRel)
+			m.removed++
+			if !m.dryRun {
+				if err := os.Remove(entryPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return nil, err
+				}
+			}
+			return nil, nil
+		}
+		m.report("<", dirRel(entryRel, r.Dir))
+		m.fetched++
+		return []api.DirectoryEntry{r}, m.fetch(entryPath, entryRel, r)
+
+	case syncpair.Descend:
+		var sub, remoteSub *api.ListDirectoryResponse
+		if hadBase && b.Dir {
+			if sub, err = m.client.ListDirectory(ctx, b.CID); err != nil {
+				return nil, fmt.Errorf("%s: %w", entryRel, err)
+			}
+		}
+		if remoteSub, err = m.client.ListDirectory(ctx, r.CID); err != nil {
+			return nil, fmt.Errorf("%s: %w", entryRel, err)
+		}
+		c, err = m.merge(entryPath, entryRel, sub, remoteSub, true)
+
+	case syncpair.DropUnlessChanged:
+		var sub *api.ListDirectoryResponse
+		if sub, err = m.client.ListDirectory(ctx, b.CID); err != nil {
+			return nil, fmt.Errorf("%s: %w", entryRel, err)
+		}
+		// Only a directory that didn't change goes; otherwise the local
+		// changes are kept and the directory with them.
+		if c, err = m.sync(entryPath, entryRel, sub, true); err != nil {
+			return nil, err
+		}
+		if c != b.CID {
+			break
+		}
+		m.report("x", dirRel(entryRel, true))
+		m.removed++
+		if m.dryRun {
+			return nil, nil
+		}
+		return nil, os.RemoveAll(entryPath)
+
+	case syncpair.KeepBoth:
+		return m.keepBoth(path, rel, name, e.IsDir(), r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []api.DirectoryEntry{{Name: name, CID: c}}, nil
+}
+
+// hashLocal hashes the local file at path when it can be the same as the
+// file base or remote has; otherwise it differs from both and its hash is
+// left empty.
+func (m *merger) hashLocal(path string, base, remote api.DirectoryEntry) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !(base.CID != "" && !base.Dir && base.Size == info.Size()) &&
+		!(remote.CID != "" && !remote.Dir && remote.Size == info.Size()) {
+		return "", nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	c, err := manifest.Hash(m.cmd.Context(), f, filepath.Base(path), *m.params)
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+// keepBoth moves the local version of name out of the way under a conflict
+// name, stores it there and puts the remote version in its place.
+func (m *merger) keepBoth(path, rel, name string, isDir bool, remote api.DirectoryEntry) ([]api.DirectoryEntry, error) {
+	copyName := syncpair.ConflictName(name, m.peer, m.now)
+	entryPath, copyPath := filepath.Join(path, name), filepath.Join(path, copyName)
+	entryRel, copyRel := filepath.Join(rel, name), filepath.Join(rel, copyName)
+
+	fmt.Fprintf(m.cmd.OutOrStdout(), "C %s -> %s\n", dirRel(entryRel, isDir), copyName)
+	m.conflicts = append(m.conflicts, syncpair.Conflict{
+		Path:   filepath.ToSlash(entryRel),
+		Copy:   filepath.ToSlash(copyRel),
+		Remote: remote.CID,
+		Time:   m.now,
+	})
+	if m.dryRun {
+		return nil, nil
+	}
+
+	if err := os.Rename(entryPath, copyPath); err != nil {
+		return nil, err
+	}
+	var c string
+	var err error
+	if isDir {
+		c, err = m.sync(copyPath, copyRel, nil, true)
+	} else if c, err = m.syncFile(copyPath, copyRel, api.DirectoryEntry{}, false); err != nil {
+		err = fmt.Errorf("%s: %w", copyRel, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.fetch(entryPath, entryRel, remote); err != nil {
+		return nil, err
+	}
+	return []api.DirectoryEntry{remote, {Name: copyName, CID: c}}, nil
+}
+
+// fetch writes the remote entry e to path, shown as rel, replacing what is
+// there. A file is written next to it first, so a failed download leaves
+// it alone.
+func (m *merger) fetch(path, rel string, e api.DirectoryEntry) error {
+	if m.dryRun {
+		return nil
+	}
+	// Don't let a bad name write outside the directory.
+	if err := manifest.ValidName(e.Name); err != nil {
+		return err
+	}
+
+	if e.Dir {
+		sub, err := m.client.ListDirectory(m.cmd.Context(), e.CID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		return m.fetchDir(path, rel, sub)
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+e.Name+".dfs-sync")
+	if err := getFile(m.cmd, m.client, m.transfers, e.CID, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if info, err := os.Lstat(path); err == nil && info.IsDir() {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+// fetchDir writes the remote directory dir to path, shown as rel, like
+// getDirectory but leaving out what the filter does, what isn't kept on
+// disk and files that are there already.
+func (m *merger) fetchDir(path, rel string, dir *api.ListDirectoryResponse) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	for _, e := range dir.Entries {
+		if err := manifest.ValidName(e.Name); err != nil {
+			return err
+		}
+		entryPath, entryRel := filepath.Join(path, e.Name), filepath.Join(rel, e.Name)
+		slashRel := filepath.ToSlash(entryRel)
+		if m.filter.match(slashRel, e.Dir) || m.filter.policy(slashRel) != syncpair.Local {
+			continue
+		}
+
+		if !e.Dir {
+			// What is on disk already stays as it is
+			if _, err := os.Lstat(entryPath); err == nil {
+				continue
+			}
+			if err := getFile(m.cmd, m.client, m.transfers, e.CID, entryPath); err != nil {
+				return fmt.Errorf("%s: %w", entryPath, err)
+			}
+			continue
+		}
+		sub, err := m.client.ListDirectory(m.cmd.Context(), e.CID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", entryPath, err)
+		}
+		if err := m.fetchDir(entryPath, entryRel, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record appends the conflicts found to the event log, if there is one.
+func (m *merger) record(dir string) error {
+	path := cfg.Resolve(cfg.Logging.EventLog)
+	if path == "" || len(m.conflicts) == 0 {
+		return nil
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	events, err := eventlog.Open(path)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+	for _, c := range m.conflicts {
+		events.Record(eventlog.Event{
+			Time: c.Time,
+			Type: eventlog.SyncConflict,
+			Fields: map[string]string{
+				"dir":    dir,
+				"path":   c.Path,
+				"copy":   c.Copy,
+				"remote": c.Remote,
+			},
+		})
+	}
+	return nil
+}
+
+func entriesByName(dir *api.ListDirectoryResponse) map[string]api.DirectoryEntry {
+	m := make(map[string]api.DirectoryEntry)
+	if dir != nil {
+		for _, e := range dir.Entries {
+			m[e.Name] = e
+		}
+	}
+	return m
+}
+
+func side(e api.DirectoryEntry, present bool) syncpair.Side {
+	return syncpair.Side{Present: present, Dir: e.Dir, CID: e.CID}
+}
+
+// sameEntries reports whether a and b, both sorted by name, name the same
+// hashes.
+func sameEntries(a, b []api.DirectoryEntry) bool {
+	return slices.EqualFunc(a, b, func(x, y api.DirectoryEntry) bool {
+		return x.Name == y.Name && x.CID == y.CID
+	})
+}
+
+func dirRel(rel string, dir bool) string {
+	if dir {
+		return rel + string(filepath.Separator)
+	}
+	return rel
+}
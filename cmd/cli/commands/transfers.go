@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var transfersCmd = &cobra.Command{
+	Use:   "transfers",
+	Short: "List and cancel running daemon operations",
+}
+
+var transfersLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List running operations",
+	Long: `Ls lists the adds, gets, pins, replica transfers and garbage collections
+the daemon is running, with the data each has moved so far. Downloads show
+how much of what was missing locally has been fetched, and from how many
+peers.
+
+Downloads that were interrupted, by the network, a restart or their client
+going away, are listed after that. The daemon resumes them where they
+stopped.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		res, err := client.ListOperations(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if len(res.Operations) == 0 {
+			fmt.Fprintln(out, "No operations running.")
+		} else {
+			fmt.Fprintf(out, "%-6s  %-9s  %10s  %8s  %5s  %9s  %s\n", "ID", "KIND", "MOVED", "FETCHED", "PEERS", "RUNNING", "TARGET")
+		}
+		for _, op := range res.Operations {
+			fetched, peers := "-", "-"
+			if op.Missing > 0 {
+				fetched = fmt.Sprintf("%d%%", min(100, op.Fetched*100/op.Missing))
+			}
+			if op.Peers > 0 {
+				peers = strconv.Itoa(op.Peers)
+			}
+			running := time.Since(op.Started).Round(time.Second)
+			fmt.Fprintf(out, "%-6d  %-9s  %10s  %8s  %5s  %9s  %s\n", op.ID, op.Kind, formatBytes(op.Bytes), fetched, peers, running, op.Target)
+		}
+
+		var waiting []api.Download
+		for _, dl := range res.Downloads {
+			if !dl.Running {
+				waiting = append(waiting, dl)
+			}
+		}
+		if len(waiting) > 0 {
+			fmt.Fprintln(out, "\nInterrupted downloads:")
+		}
+		for _, dl := range waiting {
+			retry := "resuming"
+			if wait := time.Until(dl.RetryAt).Round(time.Second); wait > 0 {
+				retry = "retrying in " + wait.String()
+			}
+			fmt.Fprintf(out, "  %s  %s", dl.CID, retry)
+			if dl.Error != "" {
+				fmt.Fprintf(out, " after %d failures: %s", dl.Failures, dl.Error)
+			}
+			fmt.Fprintln(out)
+		}
+		return nil
+	},
+}
+
+var transfersCancelCmd = &cobra.Command{
+	Use:   "cancel <id|hash>",
+	Short: "Cancel a running operation or an interrupted download",
+	Long: `Cancel aborts an operation listed by "dfs transfers ls". The client that
+started it gets a cancellation error, and blocks already stored stay until
+garbage collection removes them.
+
+Cancelling a download gives it up for good, where it would otherwise be
+resumed. Give the hash of an interrupted download to give it up before it
+is resumed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			if err := client.CancelDownload(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Gave up download %s\n", args[0])
+			return nil
+		}
+
+		if err := client.CancelOperation(cmd.Context(), id); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Cancelled operation %d\n", id)
+		return nil
+	},
+}
+
+func init() {
+	transfersCmd.AddCommand(transfersLsCmd)
+	transfersCmd.AddCommand(transfersCancelCmd)
+	rootCmd.AddCommand(transfersCmd)
+}
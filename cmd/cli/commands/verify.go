@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <hash>",
+	Short: "Check the stored copy of a file or directory tree",
+	Long: `Verify reads every block of a stored file or directory tree and checks it
+against its hash, listing the blocks that are missing or corrupt. Nothing
+is fetched.
+
+--deep also checks the copies other peers keep: each peer the daemon has
+recorded as holding a copy, by replicating it or announcing it since the
+daemon started, is asked for --samples blocks of the tree picked at random,
+the root always among them. The blocks are checked but not stored. Peers
+that lack blocks, send corrupt ones or can't be reached are reported.
+
+The command exits with an error when anything failed to check out.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completePins),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		deep, _ := cmd.Flags().GetBool("deep")
+		samples, _ := cmd.Flags().GetInt("samples")
+		res, err := client.Verify(cmd.Context(), &api.VerifyRequest{CID: args[0], Deep: deep, Samples: samples})
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		failed := len(res.Missing) > 0 || len(res.Corrupt) > 0
+		if failed {
+			fmt.Fprintf(out, "Local copy of %s: %d blocks read, %d missing, %d corrupt\n", res.CID, res.Blocks, len(res.Missing), len(res.Corrupt))
+		} else {
+			fmt.Fprintf(out, "Local copy of %s: %d blocks intact\n", res.CID, res.Blocks)
+		}
+		for _, b := range res.Missing {
+			fmt.Fprintf(out, "  missing  %s\n", b)
+		}
+		for _, b := range res.Corrupt {
+			fmt.Fprintf(out, "  corrupt  %s\n", b)
+		}
+
+		if deep {
+			if len(res.Replicas) == 0 {
+				fmt.Fprintln(out, "No peers are recorded as holding a copy.")
+			} else {
+				fmt.Fprintf(out, "\n%-52s  %7s  %5s  %7s  %7s  %s\n", "PEER", "SAMPLED", "VALID", "MISSING", "CORRUPT", "STATUS")
+			}
+			for _, r := range res.Replicas {
+				state := "ok"
+				switch {
+				case r.Error != "":
+					state = "unreachable: " + r.Error
+				case r.Valid < r.Sampled:
+					state = "damaged"
+				}
+				if state != "ok" {
+					failed = true
+				}
+				fmt.Fprintf(out, "%-52s  %7d  %5d  %7d  %7d  %s\n", r.Peer, r.Sampled, r.Valid, r.Missing, r.Corrupt, state)
+			}
+		}
+
+		if failed {
+			return errors.New("verification failed for " + res.CID)
+		}
+		return nil
+	},
+}
+
+// verifyFileCmd checks a local file against a stored one
+var verifyFileCmd = &cobra.Command{
+	Use:   "verify-file <path> <hash>",
+	Short: "Check that a local file matches a content hash",
+	Long: `Verify-file chunks a local file the way the file with the given content
+hash was chunked and checks that it produces the same hash. The chunk
+params and name are taken from the file's manifest, which the daemon
+fetches if needed; the file data itself is not downloaded. Checking an
+encrypted file takes the master key it was encrypted with.
+
+When the file doesn't match, the byte ranges that differ are listed and
+the command exits with an error.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeVerify,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialDaemon(cmd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		hash, err := expandHash(cmd, client, args[1])
+		if err != nil {
+			return err
+		}
+		c, err := cid.Decode(hash)
+		if err != nil {
+			return fmt.Errorf("invalid hash %q: %w", args[1], err)
+		}
+
+		stat, err := client.Stat(cmd.Context(), c.String())
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// An encrypted file is sealed again with its own key to compare
+		var sealer chunking.Sealer
+		if e := stat.Encryption; e != nil {
+			key, err := unwrapFileKey(c, e)
+			if err != nil {
+				return fmt.Errorf("%s is encrypted: %w", c, err)
+			}
+			sealer = key
+		}
+
+		sum := sha256.New()
+		list, err := chunking.SplitSealed(cmd.Context(), io.TeeReader(f, sum), stat.Params, sealer, discardBlocks{})
+		if err != nil {
+			return err
+		}
+
+		m, err := manifest.New(stat.Name, list)
+		if err != nil {
+			return err
+		}
+		if e := stat.Encryption; e != nil {
+			m.Encryption = &manifest.Encryption{Cipher: e.Cipher, KeyID: e.KeyID, WrappedKey: e.WrappedKey}
+			if e.Envelope != nil {
+				if err := m.Seal(sealer); err != nil {
+					return err
+				}
+			}
+		}
+		block, err := m.Block()
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "sha256 %x\n", sum.Sum(nil))
+		if block.CID.Equals(c) {
+			fmt.Fprintf(out, "%s matches %s\n", args[0], c)
+			return nil
+		}
+
+		if list.Size != stat.Size {
+			fmt.Fprintf(out, "Size: %s locally, %s stored\n", formatBytes(list.Size), formatBytes(stat.Size))
+		}
+		for _, r := range diffChunks(list.Chunks, stat.Chunks) {
+			fmt.Fprintf(out, "Differs: %d-%d (%s)\n", r.offset, r.offset+r.size, formatBytes(r.size))
+		}
+		return errors.New("file does not match " + c.String())
+	},
+}
+
+type byteRange struct {
+	offset, size int64
+}
+
+// diffChunks returns the ranges of the local file covered by chunks that
+// are not at the same position in the stored file. Adjacent ranges are
+// merged.
+func diffChunks(local []chunking.Chunk, stored []api.ChunkRef) []byteRange {
+	var ranges []byteRange
+	for i, chunk := range local {
+		if i < len(stored) && stored[i].CID == chunk.CID.String() {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].offset+ranges[n-1].size == chunk.Offset {
+			ranges[n-1].size += chunk.Size
+			continue
+		}
+		ranges = append(ranges, byteRange{chunk.Offset, chunk.Size})
+	}
+	return ranges
+}
+
+// discardBlocks lets a file be chunked without storing anything.
+type discardBlocks struct{}
+
+func (discardBlocks) Put(context.Context, cid.Cid, []byte) error { return nil }
+
+func init() {
+	verifyCmd.Flags().Bool("deep", false, "also sample the copies peers recorded as holders keep")
+	verifyCmd.Flags().Int("samples", 0, "blocks to ask each holder for with --deep (default 8)")
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(verifyFileCmd)
+}
@@ -2,32 +2,283 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/Noah-Wilderom/dfs/pkg/api"
+	"github.com/Noah-Wilderom/dfs/pkg/coldstore"
+	"github.com/Noah-Wilderom/dfs/pkg/config"
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/faults"
+	"github.com/Noah-Wilderom/dfs/pkg/gateway"
+	"github.com/Noah-Wilderom/dfs/pkg/gc"
+	"github.com/Noah-Wilderom/dfs/pkg/group"
+	"github.com/Noah-Wilderom/dfs/pkg/health"
+	"github.com/Noah-Wilderom/dfs/pkg/keystore"
 	"github.com/Noah-Wilderom/dfs/pkg/logging"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/metrics"
 	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/pressure"
+	"github.com/Noah-Wilderom/dfs/pkg/push"
+	"github.com/Noah-Wilderom/dfs/pkg/replication"
+	"github.com/Noah-Wilderom/dfs/pkg/repo"
+	"github.com/Noah-Wilderom/dfs/pkg/share"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
 
+// statusInterval is how often the node announces its status to the
+// cluster.
+const statusInterval = time.Minute
+
+// standbyInterval is how often a standby daemon checks whether the repo
+// is free, which bounds how long a takeover takes.
+const standbyInterval = time.Second
+
+// takeoverInterval is how often a daemon taking over checks whether the one
+// it replaces has released the repo.
+const takeoverInterval = 50 * time.Millisecond
+
 func main() {
-	logger := logging.MustNew()
+	flags := pflag.NewFlagSet("dfs-daemon", pflag.ExitOnError)
+	config.AddFlags(flags)
+	config.AddOverrideFlags(flags)
+	takeover := flags.Bool("takeover", false, "replace the daemon running on this repo, for an upgrade, without dropping its transfers")
+	drainTimeout := flags.Duration("drain-timeout", api.DefaultDrainTimeout, "with --takeover, how long the old daemon waits for its transfers")
+	flags.Parse(os.Args[1:])
+
+	cfg, err := config.LoadFromFlags(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	logger := logging.MustNew(logOptions(cfg)...)
 	defer logger.Sync()
 
 	logger.Info("DFS Daemon starting...")
+	warnUnknownKeys(flags, logger)
+	if *takeover && (cfg.Storage.ReadOnly || cfg.Storage.Standby) {
+		logger.Fatal("--takeover can't be used with storage.read_only or storage.standby")
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Only one daemon may write the repo; read-only ones run alongside it
+	acquire := repo.AcquireWrite
+	if cfg.Storage.ReadOnly {
+		acquire = repo.AcquireRead
+	}
+	if cfg.Storage.Standby {
+		acquire = func(dir string) (*repo.Lock, error) {
+			return standby(ctx, dir, logger)
+		}
+	}
+	// The listeners of the daemon taken over, served by this one
+	var listeners []net.Listener
+	if *takeover {
+		acquire = func(dir string) (lock *repo.Lock, err error) {
+			lock, listeners, err = takeOver(ctx, cfg, *drainTimeout, logger)
+			return lock, err
+		}
+	}
+	lock, err := acquire(cfg.DataDir)
+	if errors.Is(err, context.Canceled) {
+		logger.Info("Stopped before taking over the repo")
+		return
+	}
+	if err != nil {
+		if errors.Is(err, repo.ErrLocked) && !cfg.Storage.ReadOnly {
+			logger.Fatal("Repo is in use, start with --read-only to run next to it or --takeover to replace it", zap.Error(err))
+		}
+		logger.Fatal("Failed to lock repo", zap.Error(err))
+	}
+	defer lock.Close()
+
+	// Open local block storage
+	openStore := storage.Open
+	if cfg.Storage.ReadOnly {
+		openStore = storage.OpenReadOnly
+	}
+	fsStore, err := openStore(cfg.StoragePath())
+	if err != nil {
+		logger.Fatal("Failed to open block store", zap.Error(err))
+	}
+	// Count which blocks files share, so none is deleted while in use
+	if err := fsStore.CountRefs(ctx, manifest.DecodeLinks, manifest.LinkCodecs...); err != nil {
+		logger.Fatal("Failed to count block references", zap.Error(err))
+	}
+
+	stats := fsStore.Stats()
+	logger.Info("Block store opened",
+		zap.String("path", fsStore.Root()),
+		zap.Int64("blocks", stats.Blocks),
+		zap.Int64("bytes", stats.Bytes),
+		zap.Bool("read_only", cfg.Storage.ReadOnly),
+	)
+
+	// In read-only mode, blocks added or fetched are only cached in memory
+	var store storage.Blockstore = fsStore
+	if cfg.Storage.ReadOnly {
+		store = storage.NewOverlay(fsStore, storage.NewMemBlockstore())
+	}
+	defer store.Close()
+
+	// Record events for `dfs debug replay` when requested
+	var events *eventlog.Recorder
+	if path := cfg.Resolve(cfg.Logging.EventLog); path != "" {
+		if events, err = eventlog.Open(path); err != nil {
+			logger.Fatal("Failed to open event log", zap.Error(err))
+		}
+		defer events.Close()
+	}
+
+	injector, err := faults.FromEnv()
+	if err != nil {
+		logger.Fatal("Invalid "+faults.EnvVar, zap.Error(err))
+	}
+	if injector.Enabled() {
+		logger.Warn("Fault injection enabled", zap.String("spec", os.Getenv(faults.EnvVar)))
+	}
+
+	// Throttle the node as memory, open files or the disk queue run short
+	disk := pressure.NewDisk(store)
+	store = disk
+	monitor := pressure.NewMonitor(pressure.MonitorOpts{
+		HeapLimit:      cfg.Pressure.HeapLimit,
+		FDLimit:        cfg.Pressure.FDLimit,
+		DiskQueueLimit: cfg.Pressure.DiskQueue,
+		Disk:           disk,
+		Interval:       cfg.Pressure.Interval,
+		Events:         events,
+		Logger:         logger,
+	})
+	monitor.Start(ctx)
+
+	// Judge dial, lookup and fetch failures against their error budgets
+	tracker := health.NewTracker(health.TrackerOpts{
+		Objectives: map[string]float64{
+			health.DHT:   cfg.Health.DHT,
+			health.Dial:  cfg.Health.Dial,
+			health.Fetch: cfg.Health.Fetch,
+		},
+		Logger: logger,
+	})
+	tracker.Start(ctx)
+
+	gater, err := network.NewGater(cfg.Network.Allow, cfg.Network.Deny)
+	if err != nil {
+		logger.Fatal("Invalid connection rules", zap.Error(err))
+	}
+
+	// Once there is a keystore the node's keys are taken from it
+	var keys *keystore.Keystore
+	if keystore.Exists(cfg.KeystorePath()) {
+		passphrase, err := keystore.Passphrase(cfg.PassphrasePath())
+		if err != nil {
+			logger.Fatal("Failed to read keystore passphrase", zap.Error(err))
+		}
+		keys, err = keystore.Open(cfg.KeystorePath(), passphrase)
+		if err != nil {
+			logger.Fatal("Failed to open keystore", zap.Error(err))
+		}
+		logger.Info("Keys taken from the keystore", zap.String("path", cfg.KeystorePath()))
+	}
+
+	// Identity rotations, which carry rules, replicas and names over
+	successionsPath := cfg.SuccessionsPath()
+	if cfg.Storage.ReadOnly {
+		successionsPath = ""
+	}
+	successions, err := network.OpenSuccessions(successionsPath)
+	if err != nil {
+		logger.Fatal("Failed to open continuity records", zap.Error(err))
+	}
+	revocationsPath := cfg.RevocationsPath()
+	if cfg.Storage.ReadOnly {
+		revocationsPath = ""
+	}
+	revocations, err := network.OpenRevocations(revocationsPath)
+	if err != nil {
+		logger.Fatal("Failed to open key revocations", zap.Error(err))
+	}
+
 	// Create and configure network
 	opts := network.P2PNetworkingOpts{
-		Port:           9000,
-		EnableDHT:      false, // Start simple - no DHT
-		BootstrapPeers: []string{},
-		Logger:         logger,
+		Port:                  cfg.Network.Port,
+		DisableIPv4:           !cfg.Network.IPv4,
+		DisableIPv6:           !cfg.Network.IPv6,
+		QUIC:                  cfg.Network.QUIC,
+		Browser:               cfg.Network.Browser,
+		BootstrapPeers:        cfg.Network.BootstrapPeers,
+		IdentityPath:          cfg.IdentityPath(),
+		Logger:                logger,
+		Events:                events,
+		Blocks:                store,
+		ReprovideInterval:     cfg.Network.ReprovideInterval,
+		InventoryInterval:     cfg.Network.InventoryInterval,
+		NamesDir:              cfg.NamesPath(),
+		NameLifetime:          cfg.Names.Lifetime,
+		NameRepublishInterval: cfg.Names.RepublishInterval,
+		Faults:                injector,
+		AutoRelay:             cfg.Network.AutoRelay,
+		StaticRelays:          cfg.Network.StaticRelays,
+		HolePunching:          cfg.Network.HolePunching,
+		DisablePortMap:        !cfg.Network.PortMap,
+		ExternalAddr:          cfg.Network.ExternalAddr,
+		RelayServer:           cfg.Network.RelayServer,
+		Proxy:                 cfg.Network.Proxy,
+		OnionControl:          cfg.Network.Onion.Control,
+		OnionControlPassword:  cfg.Network.Onion.Password,
+		OnionKeyPath:          cfg.OnionKeyPath(),
+		SwarmKeyPath:          cfg.SwarmKeyPath(),
+		ConnLow:               cfg.Network.ConnLow,
+		ConnHigh:              cfg.Network.ConnHigh,
+		Gater:                 gater,
+		Successions:           successions,
+		Revocations:           revocations,
+		Bandwidth: network.Bandwidth{
+			Upload:       cfg.Network.Bandwidth.Upload,
+			Download:     cfg.Network.Bandwidth.Download,
+			PeerUpload:   cfg.Network.Bandwidth.PeerUpload,
+			PeerDownload: cfg.Network.Bandwidth.PeerDownload,
+		},
+		StreamPools: cfg.Network.Streams.Pools(),
+		Pressure:    monitor,
+		Health:      tracker,
+		// The repo's key may belong to a daemon that is already running
+		EphemeralIdentity: cfg.Storage.ReadOnly,
+	}
+	if keys != nil && !cfg.Storage.ReadOnly {
+		if opts.Identity, err = keys.Identity(); err != nil {
+			logger.Fatal("Failed to load node key from the keystore", zap.Error(err))
+		}
+		opts.NameKeys = keys
+	}
+	// Names and the onion address belong to the daemon that writes the repo
+	if cfg.Storage.ReadOnly {
+		opts.NamesDir = ""
+		opts.OnionKeyPath = ""
 	}
+	opts.EnableDHT, opts.DHTMode = dhtMode(cfg.Network.DHTMode)
 
 	p2pNet := network.NewP2PNetworking(opts)
 	defer p2pNet.Close()
@@ -37,6 +288,251 @@ func main() {
 		logger.Fatal("Failed to start network", zap.Error(err))
 	}
 
+	// Serve the control API
+	openPins := pin.Open
+	if cfg.Storage.ReadOnly {
+		openPins = pin.OpenReadOnly
+	}
+	pins, err := openPins(cfg.PinsPath())
+	if err != nil {
+		logger.Fatal("Failed to open pin set", zap.Error(err))
+	}
+
+	// Unfinished downloads, resumed below
+	downloadsPath := cfg.DownloadsPath()
+	if cfg.Storage.ReadOnly {
+		downloadsPath = ""
+	}
+	downloads, err := node.OpenDownloads(downloadsPath)
+	if err != nil {
+		logger.Fatal("Failed to open download list", zap.Error(err))
+	}
+
+	// Pushes waiting to be accepted, kept from collection
+	inboxPath := cfg.InboxPath()
+	if cfg.Storage.ReadOnly {
+		inboxPath = ""
+	}
+	inbox, err := push.OpenInbox(inboxPath)
+	if err != nil {
+		logger.Fatal("Failed to open inbox", zap.Error(err))
+	}
+
+	// Capabilities to read files other nodes shared
+	sharesPath := cfg.SharesPath()
+	if cfg.Storage.ReadOnly {
+		sharesPath = ""
+	}
+	shares, err := share.OpenStore(sharesPath)
+	if err != nil {
+		logger.Fatal("Failed to open share store", zap.Error(err))
+	}
+
+	// Groups whose keys encrypt files for their members
+	groupsPath := cfg.GroupsPath()
+	if cfg.Storage.ReadOnly {
+		groupsPath = ""
+	}
+	groups, err := group.OpenStore(groupsPath)
+	if err != nil {
+		logger.Fatal("Failed to open group store", zap.Error(err))
+	}
+
+	// The cold tier pins are archived to, which a read-only daemon can't do
+	var cold coldstore.Store
+	if !cfg.Storage.ReadOnly {
+		cold, err = coldstore.Open(coldstore.Options{
+			Path:         cfg.Resolve(cfg.Archive.Path),
+			S3:           cfg.Archive.S3,
+			StorageClass: cfg.Archive.StorageClass,
+		})
+		if err != nil {
+			logger.Fatal("Failed to open cold store", zap.Error(err))
+		}
+	}
+	if cold != nil {
+		defer cold.Close()
+	}
+
+	// Chunk checksums of files added with aligned chunking, to add them
+	// again incrementally
+	chunkIndexPath := cfg.ChunkIndexPath()
+	if cfg.Storage.ReadOnly {
+		chunkIndexPath = ""
+	}
+	chunkIndex, err := node.OpenChunkIndex(chunkIndexPath)
+	if err != nil {
+		logger.Fatal("Failed to open chunk index", zap.Error(err))
+	}
+
+	// Long-running work, listed and cancelled through the API
+	operations := ops.NewRegistry()
+
+	nodeOpts := node.NodeOpts{
+		Store:      store,
+		Pins:       pins,
+		Network:    p2pNet,
+		Chunking:   cfg.Chunking.Params(),
+		Downloads:  downloads,
+		Ops:        operations,
+		Pressure:   monitor,
+		Shares:     shares,
+		Groups:     groups,
+		ColdStore:  cold,
+		ChunkIndex: chunkIndex,
+		Logger:     logger,
+	}
+	if keys != nil {
+		if nodeOpts.MasterKey, err = keys.MasterKey(); err != nil {
+			logger.Fatal("Failed to load master key from the keystore", zap.Error(err))
+		}
+		if nodeOpts.RetiredMasterKeys, err = keys.RetiredMasterKeys(); err != nil {
+			logger.Fatal("Failed to load retired master keys from the keystore", zap.Error(err))
+		}
+		nodeOpts.FileKeys = keys
+	} else {
+		// A read-only daemon can decrypt with the master key but not create it
+		loadMasterKey := crypt.LoadOrCreateMasterKey
+		if cfg.Storage.ReadOnly {
+			loadMasterKey = crypt.LoadMasterKey
+		}
+		nodeOpts.MasterKey, err = loadMasterKey(cfg.MasterKeyPath())
+		if err != nil && !(cfg.Storage.ReadOnly && errors.Is(err, fs.ErrNotExist)) {
+			logger.Fatal("Failed to load master key", zap.Error(err))
+		}
+	}
+
+	n := node.NewNode(nodeOpts)
+	n.ResumeDownloads(ctx)
+
+	// Keep pinned files replicated across peers
+	replOpts := replication.ManagerOpts{
+		Network:  p2pNet,
+		Pins:     pins,
+		Factor:   cfg.Replication.Factor,
+		Zone:     cfg.Replication.Zone,
+		Ops:      operations,
+		Events:   events,
+		Interval: cfg.Replication.Interval,
+		Logger:   logger,
+	}
+	// A read-only node can't keep copies for others beyond its lifetime
+	if cfg.Replication.Accept && !cfg.Storage.ReadOnly {
+		replOpts.Store = func(ctx context.Context, c cid.Cid) error {
+			return n.Pin(ctx, c, node.PinOptions{})
+		}
+		replOpts.Update = func(ctx context.Context, from peer.ID, c, base cid.Cid) error {
+			return n.UpdatePin(ctx, base, c, from)
+		}
+		replOpts.Stat = n.Describe
+		replOpts.Policy = replicationPolicy(cfg.Replication.Policy)
+		replOpts.Donation.Capacity = cfg.Replication.Donation.Capacity
+	}
+	// Checked by config validation
+	replOpts.Donation.Window, _ = replication.ParseWindow(cfg.Replication.Donation.Window)
+	replOpts.Classes = cfg.Replication.ReplicationClasses()
+	replOpts.Rules, _ = cfg.Replication.ReplicationRules()
+	replicator := replication.NewManager(replOpts)
+	// Peers holding an older version of a file get only what changed
+	p2pNet.HandleDiff(n.DAG)
+	// and those fetching a deep tree get it in one exchange
+	p2pNet.HandleSelect(n.Select)
+	// What peers push waits in the inbox until accepted; a read-only
+	// node can't keep it
+	var pushReceiver *push.Receiver
+	if !cfg.Storage.ReadOnly {
+		pushReceiver = push.NewReceiver(push.ReceiverOpts{
+			Network: p2pNet,
+			Inbox:   inbox,
+			Stat:    n.DescribeFrom,
+			Fetch:   n.Fetch,
+			Keep: func(ctx context.Context, c cid.Cid, name string) error {
+				return n.Pin(ctx, c, node.PinOptions{Name: name})
+			},
+			Policy: pushPolicy(cfg.Push),
+			Ops:    operations,
+			Events: events,
+			Logger: logger,
+		})
+		if cfg.Push.Accept {
+			pushReceiver.Start()
+		}
+	}
+	replicator.Start(ctx)
+	go announceStatus(ctx, n, p2pNet.Bus(), replOpts.Store != nil, cfg.Replication.Zone, logger)
+
+	// Remove unpinned blocks, on request and every gc.interval
+	var collector *gc.Collector
+	if !cfg.Storage.ReadOnly {
+		collector = gc.NewCollector(gc.CollectorOpts{
+			Store:       fsStore,
+			Pins:        pins,
+			Roots:       func() []cid.Cid { return append(downloads.Roots(), inbox.Roots()...) },
+			Lock:        lock,
+			GracePeriod: cfg.GC.GracePeriod,
+			Interval:    cfg.GC.Interval,
+			HistoryPath: cfg.GCHistoryPath(),
+			Ops:         operations,
+			Events:      events,
+			Logger:      logger,
+		})
+		collector.Start(ctx)
+	}
+
+	handedOff := make(chan struct{})
+	apiServer := api.NewServer(api.ServerOpts{
+		Node:        n,
+		SocketPath:  cfg.APISocketPath(),
+		TCPAddr:     cfg.API.TCPAddr,
+		Replication: replicator,
+		GC:          collector,
+		Ops:         operations,
+		Health:      tracker,
+		Events:      events,
+		Push:        pushReceiver,
+		Listeners:   listeners,
+		Logger:      logger,
+	})
+	// A read-only daemon doesn't own the repo, so it has nothing to hand over
+	if !cfg.Storage.ReadOnly {
+		apiServer.OnHandoff = func() { close(handedOff) }
+	}
+	if err := apiServer.Start(); err != nil {
+		logger.Fatal("Failed to start API", zap.Error(err))
+	}
+	defer apiServer.Close()
+
+	// Serve metrics when configured
+	if cfg.Metrics.Addr != "" {
+		mux := http.NewServeMux()
+		metrics.Registry.MustRegister(p2pNet.TrafficCollector(), tracker.Collector())
+		mux.Handle("/metrics", metrics.Handler())
+		metricsServer := &http.Server{Addr: cfg.Metrics.Addr, Handler: mux}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Metrics server stopped", zap.Error(err))
+			}
+		}()
+		defer metricsServer.Close()
+		logger.Info("Metrics listening", zap.String("addr", cfg.Metrics.Addr))
+	}
+
+	// Serve the gateway when configured
+	if cfg.Gateway.Addr != "" {
+		gatewayServer := &http.Server{
+			Addr:              cfg.Gateway.Addr,
+			Handler:           gateway.New(gateway.Options{Node: n, Logger: logger}),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := gatewayServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Gateway stopped", zap.Error(err))
+			}
+		}()
+		defer gatewayServer.Close()
+		logger.Info("Gateway listening", zap.String("addr", cfg.Gateway.Addr))
+	}
+
 	// Print connection info
 	host := p2pNet.Host()
 	fmt.Println("\n══════════════════════════════════════")
@@ -47,13 +543,213 @@ func main() {
 	for _, addr := range host.Addrs() {
 		fmt.Printf("  %s/p2p/%s\n", addr, host.ID())
 	}
-	fmt.Println("\n══════════════════════════════════════\n")
+	fmt.Print("\n══════════════════════════════════════\n\n")
 
 	logger.Info("Daemon ready. Press Ctrl+C to stop.")
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	<-sigCh
+	select {
+	case <-sigCh:
+	case <-handedOff:
+		logger.Info("Handed over to the new daemon")
+	}
 
 	logger.Info("Shutting down...")
 }
+
+// standby waits for the daemon holding the repo at dir to stop, then takes
+// the write lock. The rest of startup then brings the node back under the
+// same identity: it rejoins the network, resumes downloads and replication
+// and republishes its names. It returns context.Canceled when stopped while
+// waiting.
+func standby(ctx context.Context, dir string, logger *zap.Logger) (*repo.Lock, error) {
+	lock, err := repo.AcquireWrite(dir)
+	if !errors.Is(err, repo.ErrLocked) {
+		return lock, err
+	}
+	logger.Info("Standing by until the active daemon stops",
+		zap.String("path", dir),
+		zap.Int("pid", repo.Holder(dir)),
+	)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if lock, err = repo.WaitWrite(ctx, dir, standbyInterval); err != nil {
+		return nil, err
+	}
+	logger.Warn("Active daemon stopped, taking over the repo", zap.String("path", dir))
+	return lock, nil
+}
+
+// takeOver asks the daemon holding the repo to hand over to this one: it
+// drains its transfers, passes on its API listeners and stops. The write
+// lock is taken as soon as it is released; the rest of startup then brings
+// the node back under the same identity, resuming interrupted downloads.
+func takeOver(ctx context.Context, cfg *config.Config, drain time.Duration, logger *zap.Logger) (*repo.Lock, []net.Listener, error) {
+	lock, err := repo.AcquireWrite(cfg.DataDir)
+	if !errors.Is(err, repo.ErrLocked) {
+		if err == nil {
+			logger.Info("No daemon to take over from, starting normally")
+		}
+		return lock, nil, err
+	}
+
+	target := cfg.APISocketPath()
+	if target == "" {
+		target = cfg.API.TCPAddr
+	}
+	if target == "" {
+		return nil, nil, errors.New("takeover: the running daemon has no API to reach")
+	}
+
+	handoff, err := api.ListenHandoff(cfg.HandoffSocketPath())
+	if err != nil {
+		return nil, nil, fmt.Errorf("takeover: %w", err)
+	}
+	defer handoff.Close()
+
+	type handed struct {
+		listeners []net.Listener
+		err       error
+	}
+	received := make(chan handed, 1)
+	go func() {
+		ls, err := handoff.Accept()
+		received <- handed{ls, err}
+	}()
+
+	client, err := api.Dial(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("takeover: %w", err)
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("Asking the running daemon to hand over",
+		zap.String("api", target),
+		zap.Int("pid", repo.Holder(cfg.DataDir)),
+		zap.Duration("drain_timeout", drain),
+	)
+	res, err := client.Handoff(ctx, &api.HandoffRequest{Socket: handoff.Path(), Drain: drain})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, fmt.Errorf("takeover: %w", err)
+	}
+	// The listeners were sent before the reply
+	h := <-received
+	if h.err != nil {
+		return nil, nil, fmt.Errorf("takeover: %w", h.err)
+	}
+	if !res.Drained {
+		logger.Warn("Some transfers were interrupted by the handoff")
+	}
+
+	lock, err = repo.WaitWrite(ctx, cfg.DataDir, takeoverInterval)
+	if err != nil {
+		for _, l := range h.listeners {
+			l.Close()
+		}
+		return nil, nil, err
+	}
+	logger.Info("Took over the repo", zap.String("path", cfg.DataDir), zap.Int("old_pid", res.PID))
+	return lock, h.listeners, nil
+}
+
+// warnUnknownKeys logs config keys the daemon ignores, which are most
+// likely typos.
+func warnUnknownKeys(flags *pflag.FlagSet, logger *zap.Logger) {
+	path, _ := flags.GetString("config")
+	if path == "" {
+		path = config.DefaultPath()
+	}
+	// The file loaded, so only a missing default can fail here.
+	problems, _ := config.Check(path)
+	for _, p := range problems {
+		if p.Warning {
+			logger.Warn("Config: "+p.String(), zap.String("path", path))
+		}
+	}
+}
+
+func logOptions(cfg *config.Config) []logging.Option {
+	opts := []logging.Option{logging.WithLevel(cfg.Logging.Level)}
+	if len(cfg.Logging.Redact) > 0 {
+		cats, err := logging.ParseCategories(strings.Join(cfg.Logging.Redact, ","))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid logging.redact:", err)
+			os.Exit(1)
+		}
+		opts = append(opts, logging.WithRedaction(cats...))
+	}
+	return opts
+}
+
+// announceStatus publishes the node's status on the event bus every
+// statusInterval.
+func announceStatus(ctx context.Context, n *node.Node, bus *network.EventBus, accepts bool, zone string, logger *zap.Logger) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
+	for {
+		stats := n.Stats()
+		status := network.NodeStatus{
+			Blocks:          stats.Storage.Blocks,
+			Bytes:           stats.Storage.Bytes,
+			Pins:            stats.Pins,
+			Peers:           stats.Peers,
+			AcceptsReplicas: accepts,
+			Zone:            zone,
+		}
+		if err := network.NodeStatusTopic.Publish(ctx, bus, status); err != nil && ctx.Err() == nil {
+			logger.Debug("Failed to announce status", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func replicationPolicy(c config.ReplicationPolicy) replication.Policy {
+	policy := replication.Policy{
+		MaxSize:         c.MaxSize,
+		ContentTypes:    c.ContentTypes,
+		MaxBytesPerPeer: c.MaxBytesPerPeer,
+	}
+	for _, s := range c.Peers {
+		// Checked by config validation
+		id, _ := peer.Decode(s)
+		policy.Peers = append(policy.Peers, id)
+	}
+	return policy
+}
+
+func pushPolicy(c config.PushConfig) push.Policy {
+	policy := push.Policy{MaxSize: c.MaxSize, Capacity: c.Capacity}
+	for _, s := range c.Peers {
+		// Checked by config validation
+		id, _ := peer.Decode(s)
+		policy.Peers = append(policy.Peers, id)
+	}
+	return policy
+}
+
+func dhtMode(mode string) (bool, dht.ModeOpt) {
+	switch mode {
+	case config.DHTClient:
+		return true, dht.ModeClient
+	case config.DHTServer:
+		return true, dht.ModeServer
+	case config.DHTAuto:
+		return true, dht.ModeAuto
+	default:
+		return false, dht.ModeAuto
+	}
+}
@@ -0,0 +1,19 @@
+// Command git-remote-dfs lets git push to and fetch from dfs:// remotes:
+// git runs it for them when it is on the PATH. It is "dfs git
+// remote-helper", with the config file given by DFS_CONFIG since git
+// passes no flags.
+package main
+
+import (
+	"os"
+
+	"github.com/Noah-Wilderom/dfs/cmd/cli/commands"
+)
+
+func main() {
+	args := []string{"git", "remote-helper"}
+	if config := os.Getenv("DFS_CONFIG"); config != "" {
+		args = append(args, "--config", config)
+	}
+	commands.ExecuteArgs(append(args, os.Args[1:]...))
+}
@@ -0,0 +1,251 @@
+// Package agent keeps a keystore unlocked for a while, as ssh-agent keeps
+// keys, so commands using the keystore don't ask for its passphrase every
+// time.
+//
+// The agent holds the key derived from the passphrase rather than the
+// passphrase itself, and hands it out over a Unix socket in a directory
+// only its user can enter, see rundir. It forgets the key and exits when its time is
+// up or when it is locked.
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/rundir"
+)
+
+// DefaultTTL is how long an agent keeps the keystore unlocked.
+const DefaultTTL = 15 * time.Minute
+
+const requestTimeout = 5 * time.Second
+
+var (
+	// ErrNotRunning is returned when no agent answers on the socket.
+	ErrNotRunning = errors.New("agent: not running")
+	// ErrRunning is returned when starting an agent where one runs.
+	ErrRunning = errors.New("agent: already running")
+)
+
+// SocketPath is where the agent for the keystore at path listens. Each
+// keystore has its own, in the per-user socket directory.
+func SocketPath(keystore string) string {
+	sum := sha256.Sum256([]byte(keystore))
+	return filepath.Join(rundir.Dir(), fmt.Sprintf("agent-%x.sock", sum[:6]))
+}
+
+// Status describes a running agent.
+type Status struct {
+	Keystore string    `json:"keystore"`
+	Expires  time.Time `json:"expires"`
+}
+
+type request struct {
+	Op       string `json:"op"`
+	Keystore string `json:"keystore,omitempty"`
+}
+
+type response struct {
+	Status
+	Key   []byte `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	opKey    = "key"
+	opStatus = "status"
+	opLock   = "lock"
+)
+
+type Agent struct {
+	mu      sync.Mutex
+	key     []byte
+	expires time.Time
+	// locked is closed by Lock.
+	locked   chan struct{}
+	lockOnce sync.Once
+
+	AgentOpts
+}
+
+type AgentOpts struct {
+	// Keystore is the path of the unlocked keystore.
+	Keystore string
+	// Key is the keystore's unlock key, see keystore.UnlockKey. The agent
+	// clears it when it stops.
+	Key []byte
+	// TTL is how long the agent serves the key. Defaults to DefaultTTL.
+	TTL time.Duration
+}
+
+func New(opts AgentOpts) *Agent {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	return &Agent{
+		key:       opts.Key,
+		expires:   time.Now().Add(opts.TTL),
+		locked:    make(chan struct{}),
+		AgentOpts: opts,
+	}
+}
+
+// Expires is when the agent forgets the key.
+func (a *Agent) Expires() time.Time {
+	return a.expires
+}
+
+// Listen creates the socket an agent serves on, which only the current
+// user can connect to: its directory must be private to the user, so the
+// socket is never reachable by others, even before its own mode is set.
+// It fails with ErrRunning when an agent answers there already, and
+// replaces the socket of one that died.
+func Listen(socket string) (net.Listener, error) {
+	if err := rundir.Ensure(filepath.Dir(socket)); err != nil {
+		return nil, err
+	}
+	if _, err := GetStatus(socket); err == nil {
+		return nil, ErrRunning
+	}
+	os.Remove(socket)
+
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Serve answers requests on ln until the TTL is up, the agent is locked
+// or ctx is done, then clears the key and closes ln.
+func (a *Agent) Serve(ctx context.Context, ln net.Listener) error {
+	timer := time.NewTimer(time.Until(a.expires))
+	defer timer.Stop()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		case <-a.locked:
+		case <-done:
+		}
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	defer a.Lock()
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.handle(conn)
+		}()
+	}
+}
+
+// Lock makes the agent forget the key and stop serving.
+func (a *Agent) Lock() {
+	a.mu.Lock()
+	clear(a.key)
+	a.key = nil
+	a.mu.Unlock()
+	a.lockOnce.Do(func() { close(a.locked) })
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	res := response{Status: Status{Keystore: a.Keystore, Expires: a.expires}}
+	switch req.Op {
+	case opKey:
+		a.mu.Lock()
+		switch {
+		case a.key == nil:
+			res.Error = "locked"
+		case req.Keystore != a.Keystore:
+			res.Error = fmt.Sprintf("holds keystore %s, not %s", a.Keystore, req.Keystore)
+		default:
+			res.Key = a.key
+		}
+		res.Key = append([]byte(nil), res.Key...)
+		a.mu.Unlock()
+	case opStatus:
+	case opLock:
+		a.Lock()
+	default:
+		res.Error = fmt.Sprintf("unknown request %q", req.Op)
+	}
+	json.NewEncoder(conn).Encode(res)
+	clear(res.Key)
+}
+
+// Key asks the agent at socket for the unlock key of keystore.
+func Key(socket, keystore string) ([]byte, error) {
+	res, err := call(socket, request{Op: opKey, Keystore: keystore})
+	if err != nil {
+		return nil, err
+	}
+	return res.Key, nil
+}
+
+// GetStatus asks the agent at socket what it holds and until when.
+func GetStatus(socket string) (Status, error) {
+	res, err := call(socket, request{Op: opStatus})
+	return res.Status, err
+}
+
+// Lock makes the agent at socket forget its key and exit.
+func Lock(socket string) error {
+	_, err := call(socket, request{Op: opLock})
+	return err
+}
+
+func call(socket string, req request) (response, error) {
+	conn, err := net.DialTimeout("unix", socket, requestTimeout)
+	if err != nil {
+		return response{}, fmt.Errorf("%w: %v", ErrNotRunning, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return response{}, err
+	}
+	var res response
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return response{}, fmt.Errorf("agent: %w", err)
+	}
+	if res.Error != "" {
+		return res, fmt.Errorf("agent: %s", res.Error)
+	}
+	return res, nil
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/rundir"
+)
+
+func startAgent(t *testing.T, ttl time.Duration) (string, chan error) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "run", "agent.sock")
+	ln, err := Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(AgentOpts{Keystore: "/keystore.json", Key: []byte("unlock key"), TTL: ttl})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- a.Serve(ctx, ln) }()
+	return socket, done
+}
+
+func wait(t *testing.T, done chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent still serving")
+	}
+}
+
+func TestKeyAndLock(t *testing.T) {
+	socket, done := startAgent(t, time.Hour)
+
+	key, err := Key(socket, "/keystore.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, []byte("unlock key")) {
+		t.Errorf("key = %q", key)
+	}
+	if _, err := Key(socket, "/other.json"); err == nil {
+		t.Error("agent handed out the key for another keystore")
+	}
+	if _, err := Listen(socket); !errors.Is(err, ErrRunning) {
+		t.Errorf("second Listen = %v, want %v", err, ErrRunning)
+	}
+
+	if err := Lock(socket); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, done)
+	if _, err := Key(socket, "/keystore.json"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Key after Lock = %v, want %v", err, ErrNotRunning)
+	}
+}
+
+func TestExpires(t *testing.T) {
+	socket, done := startAgent(t, 200*time.Millisecond)
+
+	status, err := GetStatus(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Keystore != "/keystore.json" || time.Until(status.Expires) > 200*time.Millisecond {
+		t.Errorf("status = %+v", status)
+	}
+
+	wait(t, done)
+	if _, err := GetStatus(socket); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("GetStatus after the TTL = %v, want %v", err, ErrNotRunning)
+	}
+}
+
+func TestListenRefusesSharedDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "shared")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(filepath.Join(dir, "agent.sock")); !errors.Is(err, rundir.ErrUnsafe) {
+		t.Errorf("Listen in a shared directory = %v, want %v", err, rundir.ErrUnsafe)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package agent
+
+import "os/exec"
+
+// Detach does nothing where there are no sessions; the agent then ends
+// with the console it was started from.
+func Detach(cmd *exec.Cmd) {}
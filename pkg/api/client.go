@@ -0,0 +1,485 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client talks to a running daemon.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// Dial connects to the daemon API at target, either a Unix socket path or a
+// host:port TCP address.
+func Dial(target string) (*Client, error) {
+	if !strings.Contains(target, ":") || strings.HasPrefix(target, "/") {
+		target = "unix://" + target
+	}
+
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) NodeInfo(ctx context.Context) (*NodeInfoResponse, error) {
+	res := new(NodeInfoResponse)
+	return res, c.conn.Invoke(ctx, methodNodeInfo, &NodeInfoRequest{}, res)
+}
+
+func (c *Client) ListPeers(ctx context.Context) (*ListPeersResponse, error) {
+	res := new(ListPeersResponse)
+	return res, c.conn.Invoke(ctx, methodListPeers, &ListPeersRequest{}, res)
+}
+
+func (c *Client) ConnectPeer(ctx context.Context, addr string) (*ConnectPeerResponse, error) {
+	res := new(ConnectPeerResponse)
+	return res, c.conn.Invoke(ctx, methodConnectPeer, &ConnectPeerRequest{Address: addr}, res)
+}
+
+// Pin pins the file or directory req names.
+func (c *Client) Pin(ctx context.Context, req *PinRequest) error {
+	return c.conn.Invoke(ctx, methodPin, req, new(PinResponse))
+}
+
+// BlockPeer denies a peer ID, IP address or CIDR range until the daemon
+// restarts.
+func (c *Client) BlockPeer(ctx context.Context, rule string) error {
+	return c.conn.Invoke(ctx, methodBlockPeer, &BlockPeerRequest{Rule: rule}, new(BlockPeerResponse))
+}
+
+func (c *Client) UnblockPeer(ctx context.Context, rule string) error {
+	return c.conn.Invoke(ctx, methodUnblockPeer, &UnblockPeerRequest{Rule: rule}, new(UnblockPeerResponse))
+}
+
+func (c *Client) ListBlocked(ctx context.Context) (*ListBlockedResponse, error) {
+	res := new(ListBlockedResponse)
+	return res, c.conn.Invoke(ctx, methodListBlocked, &ListBlockedRequest{}, res)
+}
+
+// RevokeKey has the daemon honour a key revocation and announce it to
+// the cluster.
+func (c *Client) RevokeKey(ctx context.Context, r *network.Revocation) (*RevokeKeyResponse, error) {
+	res := new(RevokeKeyResponse)
+	return res, c.conn.Invoke(ctx, methodRevokeKey, &RevokeKeyRequest{Revocation: *r}, res)
+}
+
+// NATStatus reports the daemon's port mappings and reachability.
+func (c *Client) NATStatus(ctx context.Context) (*NATStatusResponse, error) {
+	res := new(NATStatusResponse)
+	return res, c.conn.Invoke(ctx, methodNATStatus, &NATStatusRequest{}, res)
+}
+
+// LabelPin renames a pin and changes its labels.
+func (c *Client) LabelPin(ctx context.Context, req *LabelPinRequest) error {
+	return c.conn.Invoke(ctx, methodLabelPin, req, new(LabelPinResponse))
+}
+
+// UpdatePin moves the pin of base to cid, and the copies peers keep of it.
+func (c *Client) UpdatePin(ctx context.Context, base, cid string) (*UpdatePinResponse, error) {
+	res := new(UpdatePinResponse)
+	return res, c.conn.Invoke(ctx, methodUpdatePin, &UpdatePinRequest{Base: base, CID: cid}, res)
+}
+
+// ArchivePin moves a pin to the cold store, and removes the blocks no
+// other pin keeps from the hot store.
+func (c *Client) ArchivePin(ctx context.Context, cid string) (*ArchivePinResponse, error) {
+	res := new(ArchivePinResponse)
+	return res, c.conn.Invoke(ctx, methodArchivePin, &ArchivePinRequest{CID: cid}, res)
+}
+
+// RecallPin copies an archived pin back from the cold store.
+func (c *Client) RecallPin(ctx context.Context, cid string) (*RecallPinResponse, error) {
+	res := new(RecallPinResponse)
+	return res, c.conn.Invoke(ctx, methodRecallPin, &RecallPinRequest{CID: cid}, res)
+}
+
+// Push sends the file or directory tree under cid to the peer to, a peer
+// ID or a multiaddr, and waits until it has fetched it.
+func (c *Client) Push(ctx context.Context, cid, to string) (*PushResponse, error) {
+	res := new(PushResponse)
+	return res, c.conn.Invoke(ctx, methodPush, &PushRequest{CID: cid, To: to}, res)
+}
+
+// ListInbox returns what peers pushed that waits to be accepted or
+// rejected.
+func (c *Client) ListInbox(ctx context.Context) (*ListInboxResponse, error) {
+	res := new(ListInboxResponse)
+	return res, c.conn.Invoke(ctx, methodListInbox, &ListInboxRequest{}, res)
+}
+
+// AcceptInbox pins the inbox item id.
+func (c *Client) AcceptInbox(ctx context.Context, id uint64) (*AcceptInboxResponse, error) {
+	res := new(AcceptInboxResponse)
+	return res, c.conn.Invoke(ctx, methodAcceptInbox, &AcceptInboxRequest{ID: id}, res)
+}
+
+// RejectInbox discards the inbox item id.
+func (c *Client) RejectInbox(ctx context.Context, id uint64) (*RejectInboxResponse, error) {
+	res := new(RejectInboxResponse)
+	return res, c.conn.Invoke(ctx, methodRejectInbox, &RejectInboxRequest{ID: id}, res)
+}
+
+// Verify checks the local copy of a file or directory tree, and with
+// req.Deep samples the copies peers keep of it.
+func (c *Client) Verify(ctx context.Context, req *VerifyRequest) (*VerifyResponse, error) {
+	res := new(VerifyResponse)
+	return res, c.conn.Invoke(ctx, methodVerify, req, res)
+}
+
+func (c *Client) Unpin(ctx context.Context, cid string) error {
+	return c.conn.Invoke(ctx, methodUnpin, &UnpinRequest{CID: cid}, new(UnpinResponse))
+}
+
+// ListOperations returns the daemon's running operations.
+func (c *Client) ListOperations(ctx context.Context) (*ListOperationsResponse, error) {
+	res := new(ListOperationsResponse)
+	return res, c.conn.Invoke(ctx, methodListOperations, &ListOperationsRequest{}, res)
+}
+
+// CancelOperation aborts a running operation.
+func (c *Client) CancelOperation(ctx context.Context, id uint64) error {
+	return c.conn.Invoke(ctx, methodCancelOperation, &CancelOperationRequest{ID: id}, new(CancelOperationResponse))
+}
+
+// CancelDownload gives up an interrupted download waiting to be resumed.
+func (c *Client) CancelDownload(ctx context.Context, hash string) error {
+	return c.conn.Invoke(ctx, methodCancelOperation, &CancelOperationRequest{CID: hash}, new(CancelOperationResponse))
+}
+
+// Handoff asks the daemon to drain its transfers, pass its API listeners
+// to the process listening on req.Socket and stop.
+func (c *Client) Handoff(ctx context.Context, req *HandoffRequest) (*HandoffResponse, error) {
+	res := new(HandoffResponse)
+	return res, c.conn.Invoke(ctx, methodHandoff, req, res)
+}
+
+// GC runs a garbage collection on the daemon.
+func (c *Client) GC(ctx context.Context, req *GCRequest) (*GCResponse, error) {
+	res := new(GCResponse)
+	return res, c.conn.Invoke(ctx, methodGC, req, res)
+}
+
+// Inventory sorts the daemon's stored blocks by origin.
+func (c *Client) Inventory(ctx context.Context) (*InventoryResponse, error) {
+	res := new(InventoryResponse)
+	return res, c.conn.Invoke(ctx, methodInventory, &InventoryRequest{}, res)
+}
+
+// Purge removes the stored content of an origin, recording it in the
+// daemon's event log.
+func (c *Client) Purge(ctx context.Context, req *PurgeRequest) (*PurgeResponse, error) {
+	res := new(PurgeResponse)
+	return res, c.conn.Invoke(ctx, methodPurge, req, res)
+}
+
+// Health reports how often the daemon's operations fail against their
+// error budgets.
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	res := new(HealthResponse)
+	return res, c.conn.Invoke(ctx, methodHealth, &HealthRequest{}, res)
+}
+
+// Bandwidth reports the data exchanged with peers.
+func (c *Client) Bandwidth(ctx context.Context) (*BandwidthResponse, error) {
+	res := new(BandwidthResponse)
+	return res, c.conn.Invoke(ctx, methodBandwidth, &BandwidthRequest{}, res)
+}
+
+func (c *Client) ListPins(ctx context.Context) (*ListPinsResponse, error) {
+	res := new(ListPinsResponse)
+	return res, c.conn.Invoke(ctx, methodListPins, &ListPinsRequest{}, res)
+}
+
+func (c *Client) Stats(ctx context.Context) (*StatsResponse, error) {
+	res := new(StatsResponse)
+	return res, c.conn.Invoke(ctx, methodStats, &StatsRequest{}, res)
+}
+
+// Add streams r to the daemon and returns the added file's CID. req
+// carries the file name and options. A non-nil progress is called, from
+// another goroutine, as the daemon reports how far it has got.
+func (c *Client) Add(ctx context.Context, req *AddRequest, r io.Reader, progress func(*Progress)) (*AddResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	desc := &nodeServiceDesc.Streams[0]
+	cs, err := c.conn.NewStream(ctx, desc, methodAdd)
+	if err != nil {
+		return nil, err
+	}
+	stream := &grpc.GenericClientStream[AddRequest, AddResponse]{ClientStream: cs}
+
+	first := *req
+	first.Progress = progress != nil
+	if err := stream.Send(&first); err != nil {
+		return nil, err
+	}
+
+	// Read while sending, so progress is seen as it happens
+	type result struct {
+		res *AddResponse
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		for {
+			res, err := stream.Recv()
+			switch {
+			case errors.Is(err, io.EOF):
+				err = io.ErrUnexpectedEOF
+			case err == nil && res.Progress != nil:
+				progress(res.Progress)
+				continue
+			}
+			results <- result{res, err}
+			return
+		}
+	}()
+
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			// A failed send means the daemon ended the stream; Recv tells why
+			if err := stream.Send(&AddRequest{Data: buf[:n]}); err != nil {
+				break
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := cs.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	res := <-results
+	return res.res, res.err
+}
+
+// Routes retrieves the file addressed by cid on the daemon and reports how
+// it was retrieved.
+func (c *Client) Routes(ctx context.Context, cid string) (*RoutesResponse, error) {
+	res := new(RoutesResponse)
+	return res, c.conn.Invoke(ctx, methodRoutes, &RoutesRequest{CID: cid}, res)
+}
+
+// MakeDirectory stores a directory of already added files and
+// directories.
+func (c *Client) MakeDirectory(ctx context.Context, req *MakeDirectoryRequest) (*MakeDirectoryResponse, error) {
+	res := new(MakeDirectoryResponse)
+	return res, c.conn.Invoke(ctx, methodMakeDirectory, req, res)
+}
+
+// ListDirectory lists the directory at path, a CID or /<root>/sub/dir.
+func (c *Client) ListDirectory(ctx context.Context, path string) (*ListDirectoryResponse, error) {
+	res := new(ListDirectoryResponse)
+	return res, c.conn.Invoke(ctx, methodListDirectory, &ListDirectoryRequest{Path: path}, res)
+}
+
+// ResolvePath returns the CID path leads to, expanding an abbreviated CID.
+func (c *Client) ResolvePath(ctx context.Context, path string) (string, error) {
+	res := new(ResolvePathResponse)
+	if err := c.conn.Invoke(ctx, methodResolvePath, &ResolvePathRequest{Path: path}, res); err != nil {
+		return "", err
+	}
+	return res.CID, nil
+}
+
+// Abbreviate returns the shortest unambiguous prefixes of cids.
+func (c *Client) Abbreviate(ctx context.Context, cids []string) ([]string, error) {
+	res := new(AbbreviateResponse)
+	if err := c.conn.Invoke(ctx, methodAbbreviate, &AbbreviateRequest{CIDs: cids}, res); err != nil {
+		return nil, err
+	}
+	return res.Short, nil
+}
+
+// PublishName points the name of key at path and returns the name.
+func (c *Client) PublishName(ctx context.Context, key, path string) (*PublishNameResponse, error) {
+	res := new(PublishNameResponse)
+	return res, c.conn.Invoke(ctx, methodPublishName, &PublishNameRequest{Key: key, Path: path}, res)
+}
+
+// Share issues a capability granting another peer access to an encrypted
+// file.
+func (c *Client) Share(ctx context.Context, req *ShareRequest) (*ShareResponse, error) {
+	res := new(ShareResponse)
+	return res, c.conn.Invoke(ctx, methodShare, req, res)
+}
+
+// RedeemShare has the daemon keep a capability issued to it.
+func (c *Client) RedeemShare(ctx context.Context, token string) (*RedeemShareResponse, error) {
+	res := new(RedeemShareResponse)
+	return res, c.conn.Invoke(ctx, methodRedeemShare, &RedeemShareRequest{Token: token}, res)
+}
+
+// ListShares returns the capabilities the daemon redeemed.
+func (c *Client) ListShares(ctx context.Context) (*ListSharesResponse, error) {
+	res := new(ListSharesResponse)
+	return res, c.conn.Invoke(ctx, methodListShares, &ListSharesRequest{}, res)
+}
+
+// CreateGroup has the daemon create a group it administers.
+func (c *Client) CreateGroup(ctx context.Context, req *CreateGroupRequest) (*GroupResponse, error) {
+	res := new(GroupResponse)
+	return res, c.conn.Invoke(ctx, methodCreateGroup, req, res)
+}
+
+// UpdateGroup adds and removes members of a group the daemon administers.
+func (c *Client) UpdateGroup(ctx context.Context, req *UpdateGroupRequest) (*GroupResponse, error) {
+	res := new(GroupResponse)
+	return res, c.conn.Invoke(ctx, methodUpdateGroup, req, res)
+}
+
+// JoinGroup has the daemon keep the group published under a name.
+func (c *Client) JoinGroup(ctx context.Context, name string) (*GroupResponse, error) {
+	res := new(GroupResponse)
+	return res, c.conn.Invoke(ctx, methodJoinGroup, &JoinGroupRequest{Name: name}, res)
+}
+
+// ListGroups returns the groups the daemon created or joined.
+func (c *Client) ListGroups(ctx context.Context) (*ListGroupsResponse, error) {
+	res := new(ListGroupsResponse)
+	return res, c.conn.Invoke(ctx, methodListGroups, &ListGroupsRequest{}, res)
+}
+
+// ResolveName looks up the CID name points to.
+func (c *Client) ResolveName(ctx context.Context, name string) (*ResolveNameResponse, error) {
+	res := new(ResolveNameResponse)
+	return res, c.conn.Invoke(ctx, methodResolveName, &ResolveNameRequest{Name: name}, res)
+}
+
+// ResolveKey looks up the CID the name of this node's key points to.
+func (c *Client) ResolveKey(ctx context.Context, key string) (*ResolveNameResponse, error) {
+	res := new(ResolveNameResponse)
+	return res, c.conn.Invoke(ctx, methodResolveName, &ResolveNameRequest{Key: key}, res)
+}
+
+// Stat loads the manifest of the file addressed by cid, or a path.
+func (c *Client) Stat(ctx context.Context, cid string) (*StatResponse, error) {
+	res := new(StatResponse)
+	return res, c.conn.Invoke(ctx, methodStat, &StatRequest{CID: cid}, res)
+}
+
+type GetStream struct {
+	Name   string
+	Size   int64
+	Chunks int
+
+	stream   grpc.ServerStreamingClient[GetResponse]
+	buf      []byte
+	progress func(*Progress)
+}
+
+// Get opens a stream of the file addressed by cid, or a path. The file
+// name and size are available before any data is read. A non-nil progress
+// is called while reading, as the daemon reports how far it has got.
+func (c *Client) Get(ctx context.Context, cid string, progress func(*Progress)) (*GetStream, error) {
+	desc := &nodeServiceDesc.Streams[1]
+	cs, err := c.conn.NewStream(ctx, desc, methodGet)
+	if err != nil {
+		return nil, err
+	}
+	stream := &grpc.GenericClientStream[GetRequest, GetResponse]{ClientStream: cs}
+
+	if err := stream.SendMsg(&GetRequest{CID: cid, Progress: progress != nil}); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	header, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return &GetStream{
+		Name:     header.Name,
+		Size:     header.Size,
+		Chunks:   header.Chunks,
+		stream:   stream,
+		buf:      header.Data,
+		progress: progress,
+	}, nil
+}
+
+// recv returns the data of the next message, handing progress messages to
+// g.progress.
+func (g *GetStream) recv() ([]byte, error) {
+	for {
+		msg, err := g.stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if msg.Progress == nil {
+			return msg.Data, nil
+		}
+		if g.progress != nil {
+			g.progress(msg.Progress)
+		}
+	}
+}
+
+func (g *GetStream) Read(p []byte) (int, error) {
+	for len(g.buf) == 0 {
+		data, err := g.recv()
+		if err != nil {
+			return 0, err
+		}
+		g.buf = data
+	}
+
+	n := copy(p, g.buf)
+	g.buf = g.buf[n:]
+	return n, nil
+}
+
+// WriteTo copies the whole file to w and checks the length.
+func (g *GetStream) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for {
+		if len(g.buf) > 0 {
+			n, err := w.Write(g.buf)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+			g.buf = nil
+		}
+
+		data, err := g.recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+		g.buf = data
+	}
+
+	if written != g.Size {
+		return written, fmt.Errorf("received %d bytes, expected %d", written, g.Size)
+	}
+	return written, nil
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"encoding/json"
+)
+
+// jsonCodec encodes messages as JSON. The API messages are plain Go structs,
+// so no protobuf code generation step is needed to build the daemon.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/rundir"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A handoff replaces a running daemon with a new process on the same repo,
+// as in an upgrade. The new process listens on a private socket and calls
+// Handoff. The old one stops taking transfers, waits for those running,
+// passes its API listeners over the socket and shuts down, releasing the
+// repo. Clients connecting meanwhile wait in the listeners' backlog rather
+// than being refused.
+
+// DefaultDrainTimeout is how long a daemon handing over waits for running
+// transfers when the request doesn't say.
+const DefaultDrainTimeout = 2 * time.Minute
+
+var errHandingOff = status.Error(codes.Unavailable, "daemon is handing over to a new process, try again")
+
+// drainer counts transfers in flight and refuses new ones while draining.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	active   sync.WaitGroup
+}
+
+// begin registers a transfer; call the returned func when it ends.
+func (d *drainer) begin() (func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return nil, errHandingOff
+	}
+	d.active.Add(1)
+	return d.active.Done, nil
+}
+
+// drain refuses new transfers and waits up to timeout for the running
+// ones. It reports whether they all finished.
+func (d *drainer) drain(ctx context.Context, timeout time.Duration) (bool, error) {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.active.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// resume takes transfers again after a failed handoff.
+func (d *drainer) resume() {
+	d.mu.Lock()
+	d.draining = false
+	d.mu.Unlock()
+}
+
+func (ns *nodeService) Handoff(ctx context.Context, req *HandoffRequest) (*HandoffResponse, error) {
+	s := ns.server
+	if s.OnHandoff == nil {
+		return nil, status.Error(codes.FailedPrecondition, "this daemon can't hand over")
+	}
+	if req.Socket == "" {
+		return nil, status.Error(codes.InvalidArgument, "socket is required")
+	}
+	if !s.handingOff.CompareAndSwap(false, true) {
+		return nil, status.Error(codes.FailedPrecondition, "already handing over")
+	}
+
+	timeout := req.Drain
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	s.logger.Info("Handing over to a new daemon, draining transfers", zap.Duration("timeout", timeout))
+
+	drained, err := s.transfers.drain(ctx, timeout)
+	if err == nil {
+		if !drained {
+			s.logger.Warn("Transfers still running at the drain timeout, they will be interrupted")
+		}
+		err = sendListeners(req.Socket, s.listeners)
+	}
+	if err != nil {
+		s.transfers.resume()
+		s.handingOff.Store(false)
+		s.logger.Error("Handoff failed, carrying on", zap.Error(err))
+		return nil, toStatus(err)
+	}
+
+	s.handedOff.Store(true)
+	s.OnHandoff()
+	return &HandoffResponse{PID: os.Getpid(), Drained: drained}, nil
+}
+
+// HandoffListener receives the API listeners of a daemon handing over.
+type HandoffListener struct {
+	l    *net.UnixListener
+	path string
+}
+
+// ListenHandoff listens at path, which only the current user may use: its
+// directory must be private to the user, see rundir.Ensure.
+func ListenHandoff(path string) (*HandoffListener, error) {
+	if err := rundir.Ensure(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &HandoffListener{l: l, path: path}, nil
+}
+
+func (h *HandoffListener) Path() string {
+	return h.path
+}
+
+// Accept waits for the old daemon and returns the listeners it passed.
+// Closing h makes it return.
+func (h *HandoffListener) Accept() ([]net.Listener, error) {
+	conn, err := h.l.AcceptUnix()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return receiveListeners(conn)
+}
+
+func (h *HandoffListener) Close() error {
+	return h.l.Close()
+}
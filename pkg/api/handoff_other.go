@@ -0,0 +1,18 @@
+//go:build !unix
+
+package api
+
+import (
+	"errors"
+	"net"
+)
+
+var errHandoffUnsupported = errors.New("handoff: passing listeners needs a Unix system")
+
+func sendListeners(path string, ls []net.Listener) error {
+	return errHandoffUnsupported
+}
+
+func receiveListeners(conn *net.UnixConn) ([]net.Listener, error) {
+	return nil, errHandoffUnsupported
+}
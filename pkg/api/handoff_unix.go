@@ -0,0 +1,93 @@
+//go:build unix
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxHandoffListeners bounds the descriptors read in one handoff: the API
+// has a Unix socket and a TCP address at most.
+const maxHandoffListeners = 4
+
+// sendListeners passes the descriptors of ls to the process listening at
+// path. The Unix socket files are left in place for it.
+func sendListeners(path string, ls []net.Listener) error {
+	var fds []int
+	for _, l := range ls {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("handoff: can't pass a %T", l)
+		}
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("handoff: %w", err)
+		}
+		defer f.Close()
+		fds = append(fds, int(f.Fd()))
+	}
+
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.WriteMsgUnix([]byte{byte(len(fds))}, unix.UnixRights(fds...), nil); err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	return nil
+}
+
+// receiveListeners reads the listeners sent by sendListeners.
+func receiveListeners(conn *net.UnixConn) ([]net.Listener, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(maxHandoffListeners*4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("handoff: %w", err)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, fmt.Errorf("handoff: %w", err)
+	}
+
+	var fds []int
+	for _, m := range msgs {
+		rights, err := unix.ParseUnixRights(&m)
+		if err != nil {
+			return nil, fmt.Errorf("handoff: %w", err)
+		}
+		fds = append(fds, rights...)
+	}
+
+	var ls []net.Listener
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "api")
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			for _, fd := range fds[i+1:] {
+				unix.Close(fd)
+			}
+			return nil, fmt.Errorf("handoff: %w", err)
+		}
+		ls = append(ls, l)
+	}
+	if len(ls) != int(buf[0]) {
+		for _, l := range ls {
+			l.Close()
+		}
+		return nil, fmt.Errorf("handoff: got %d of %d listeners", len(ls), buf[0])
+	}
+	return ls, nil
+}
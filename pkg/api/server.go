@@ -0,0 +1,1593 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/coldstore"
+	"github.com/Noah-Wilderom/dfs/pkg/config"
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/gc"
+	"github.com/Noah-Wilderom/dfs/pkg/group"
+	"github.com/Noah-Wilderom/dfs/pkg/health"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/push"
+	"github.com/Noah-Wilderom/dfs/pkg/replication"
+	"github.com/Noah-Wilderom/dfs/pkg/repo"
+	"github.com/Noah-Wilderom/dfs/pkg/rundir"
+	"github.com/Noah-Wilderom/dfs/pkg/share"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// streamChunkSize is the amount of file data carried per stream message.
+const streamChunkSize = 256 << 10
+
+// progressInterval is how often an add or get asking for it is told how
+// far it has got.
+const progressInterval = 250 * time.Millisecond
+
+// Server exposes a Node over gRPC on a Unix socket and, optionally, on a
+// local TCP address.
+type Server struct {
+	node   *node.Node
+	grpc   *grpc.Server
+	logger *zap.Logger
+
+	listeners []net.Listener
+
+	// transfers are drained before handing over to a new daemon.
+	transfers  drainer
+	handingOff atomic.Bool
+	handedOff  atomic.Bool
+
+	ServerOpts
+}
+
+type ServerOpts struct {
+	Node       *node.Node
+	SocketPath string
+	// TCPAddr additionally listens on a TCP address, which must be a
+	// loopback one: the API is not authenticated.
+	TCPAddr string
+	// Replication is told about pin changes and asked for copy counts.
+	// Optional.
+	Replication *replication.Manager
+	// GC runs garbage collections on request. Optional.
+	GC *gc.Collector
+	// Ops tracks requests so they can be listed and cancelled. Optional.
+	Ops *ops.Registry
+	// Health judges operation failures for the Health call. Optional.
+	Health *health.Tracker
+	// Events records purges. Optional.
+	Events *eventlog.Recorder
+	// Push holds the inbox of what peers pushed. Optional.
+	Push *push.Receiver
+	// Listeners, when set, are served instead of listening on SocketPath
+	// and TCPAddr; they are those handed over by the daemon being replaced.
+	Listeners []net.Listener
+	// OnHandoff is called once the API listeners are handed to a new
+	// daemon, which should then stop this one. Without it, handoffs are
+	// refused.
+	OnHandoff func()
+	Logger    *zap.Logger
+}
+
+func NewServer(opts ServerOpts) *Server {
+	s := &Server{
+		node:       opts.Node,
+		grpc:       grpc.NewServer(grpc.ForceServerCodec(jsonCodec{})),
+		logger:     opts.Logger,
+		ServerOpts: opts,
+	}
+	RegisterNodeServer(s.grpc, &nodeService{node: opts.Node, replication: opts.Replication, gc: opts.GC, ops: opts.Ops, events: opts.Events, push: opts.Push, server: s})
+	return s
+}
+
+// ErrSocketInUse is returned by Start when another daemon answers on the
+// socket.
+var ErrSocketInUse = errors.New("api: socket in use by a running daemon")
+
+func (s *Server) Start() error {
+	if len(s.Listeners) > 0 {
+		for _, l := range s.Listeners {
+			s.serve(l)
+		}
+		return nil
+	}
+
+	if s.SocketPath != "" {
+		// The shared socket directory may have been made by someone else
+		mkdir := func(dir string) error { return os.MkdirAll(dir, 0700) }
+		if filepath.Dir(s.SocketPath) == rundir.Dir() {
+			mkdir = rundir.Ensure
+		}
+		if err := mkdir(filepath.Dir(s.SocketPath)); err != nil {
+			return err
+		}
+		// A stale socket from a previous run would make Listen fail, but
+		// one a daemon still answers on is that daemon's.
+		if conn, err := net.DialTimeout("unix", s.SocketPath, time.Second); err == nil {
+			conn.Close()
+			return fmt.Errorf("%w: %s", ErrSocketInUse, s.SocketPath)
+		}
+		if err := os.Remove(s.SocketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		l, err := net.Listen("unix", s.SocketPath)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(s.SocketPath, 0600); err != nil {
+			l.Close()
+			return err
+		}
+		s.serve(l)
+	}
+
+	if s.TCPAddr != "" {
+		if err := config.CheckAPIAddr(s.TCPAddr); err != nil {
+			s.Close()
+			return err
+		}
+		l, err := net.Listen("tcp", s.TCPAddr)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.serve(l)
+	}
+
+	return nil
+}
+
+func (s *Server) serve(l net.Listener) {
+	s.listeners = append(s.listeners, l)
+	s.logger.Info("API listening", zap.String("addr", l.Addr().String()))
+
+	go func() {
+		if err := s.grpc.Serve(l); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			s.logger.Error("API server stopped", zap.Error(err))
+		}
+	}()
+}
+
+func (s *Server) Close() error {
+	s.grpc.GracefulStop()
+	// The socket now belongs to the daemon that took over
+	if s.SocketPath != "" && !s.handedOff.Load() {
+		os.Remove(s.SocketPath)
+	}
+	return nil
+}
+
+type nodeService struct {
+	node        *node.Node
+	replication *replication.Manager
+	gc          *gc.Collector
+	ops         *ops.Registry
+	events      *eventlog.Recorder
+	push        *push.Receiver
+	server      *Server
+}
+
+func (ns *nodeService) NodeInfo(ctx context.Context, _ *NodeInfoRequest) (*NodeInfoResponse, error) {
+	net := ns.node.Network()
+	if net == nil || net.Host() == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	h := net.Host()
+	res := &NodeInfoResponse{
+		PeerID:      h.ID().String(),
+		Proxy:       net.Proxy,
+		Limitations: net.ProxyLimitations(),
+	}
+	for _, addr := range h.Addrs() {
+		res.Addresses = append(res.Addresses, addr.String())
+	}
+	return res, nil
+}
+
+func (ns *nodeService) ListPeers(ctx context.Context, _ *ListPeersRequest) (*ListPeersResponse, error) {
+	net := ns.node.Network()
+	if net == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	res := &ListPeersResponse{Peers: []Peer{}}
+	for _, pi := range net.Peers() {
+		p := Peer{ID: pi.ID.String()}
+		for _, addr := range pi.Addrs {
+			p.Addresses = append(p.Addresses, addr.String())
+		}
+		res.Peers = append(res.Peers, p)
+	}
+	return res, nil
+}
+
+func (ns *nodeService) ConnectPeer(ctx context.Context, req *ConnectPeerRequest) (*ConnectPeerResponse, error) {
+	net := ns.node.Network()
+	if net == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	id, err := net.Connect(ctx, req.Address)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &ConnectPeerResponse{PeerID: id.String()}, nil
+}
+
+func (ns *nodeService) BlockPeer(ctx context.Context, req *BlockPeerRequest) (*BlockPeerResponse, error) {
+	net := ns.node.Network()
+	if net == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	if err := net.Block(req.Rule); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &BlockPeerResponse{}, nil
+}
+
+func (ns *nodeService) UnblockPeer(ctx context.Context, req *UnblockPeerRequest) (*UnblockPeerResponse, error) {
+	net := ns.node.Network()
+	if net == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	err := net.Unblock(req.Rule)
+	switch {
+	case errors.Is(err, network.ErrNotBlocked):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &UnblockPeerResponse{}, nil
+}
+
+func (ns *nodeService) ListBlocked(ctx context.Context, _ *ListBlockedRequest) (*ListBlockedResponse, error) {
+	net := ns.node.Network()
+	if net == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	allow, deny := net.Gater.Rules()
+	return &ListBlockedResponse{Allow: allow, Deny: deny}, nil
+}
+
+func (ns *nodeService) RevokeKey(ctx context.Context, req *RevokeKeyRequest) (*RevokeKeyResponse, error) {
+	net := ns.node.Network()
+	if net == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	added, err := net.Revoke(req.Revocation)
+	switch {
+	case errors.Is(err, network.ErrBadRevocation):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &RevokeKeyResponse{Added: added}, nil
+}
+
+func (ns *nodeService) NATStatus(ctx context.Context, _ *NATStatusRequest) (*NATStatusResponse, error) {
+	net := ns.node.Network()
+	if net == nil || net.Host() == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	st := net.NATStatus()
+	res := &NATStatusResponse{
+		PortMap:      st.PortMap,
+		Gateway:      st.Gateway,
+		Static:       addrStrings(st.Static),
+		Reachability: strings.ToLower(st.Reachability.String()),
+		Reachable:    addrStrings(st.Reachable),
+		Unreachable:  addrStrings(st.Unreachable),
+		Unknown:      addrStrings(st.Unknown),
+	}
+	for _, m := range st.Mappings {
+		pm := PortMapping{Internal: m.Internal.String()}
+		if m.External != nil {
+			pm.External = m.External.String()
+		}
+		res.Mappings = append(res.Mappings, pm)
+	}
+	return res, nil
+}
+
+func (ns *nodeService) Add(stream grpc.BidiStreamingServer[AddRequest, AddResponse]) error {
+	end, err := ns.server.transfers.begin()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	opts := node.AddOptions{Name: first.Name, NoPin: first.NoPin, Encrypt: first.Encrypt, Group: first.Group}
+	if first.Chunking != nil {
+		if err := first.Chunking.Validate(); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		opts.Chunking = *first.Chunking
+	}
+	if first.Base != "" {
+		if opts.Base, err = ns.parseHash(stream.Context(), first.Base); err != nil {
+			return err
+		}
+	}
+
+	ctx, op, done := ns.ops.Start(stream.Context(), ops.KindAdd, first.Name)
+	defer done()
+
+	pr, pw := io.Pipe()
+	go func() {
+		msg := first
+		for {
+			if len(msg.Data) > 0 {
+				if _, err := pw.Write(msg.Data); err != nil {
+					return
+				}
+				op.AddBytes(len(msg.Data))
+			}
+
+			var err error
+			if msg, err = stream.Recv(); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	stopProgress := func() {}
+	if first.Progress {
+		stopProgress = reportProgress(op, func(p *Progress) error {
+			return stream.Send(&AddResponse{Progress: p})
+		})
+	}
+	res, err := ns.node.Add(ctx, pr, opts)
+	pr.CloseWithError(err)
+	stopProgress()
+	if err != nil {
+		return opStatus(ctx, err)
+	}
+
+	stats := &AddStats{
+		Strategy:     res.Stats.Strategy,
+		MinChunk:     res.Stats.MinChunk,
+		MaxChunk:     res.Stats.MaxChunk,
+		DedupChunks:  res.Stats.DedupChunks,
+		DedupBytes:   res.Stats.DedupBytes,
+		ReusedChunks: res.Stats.ReusedChunks,
+		ReusedBytes:  res.Stats.ReusedBytes,
+	}
+	for _, class := range res.Stats.Sizes {
+		stats.Sizes = append(stats.Sizes, SizeClass{UpTo: class.UpTo, Count: class.Count})
+	}
+
+	return stream.Send(&AddResponse{
+		CID:    res.CID.String(),
+		Size:   res.Manifest.Size,
+		Chunks: len(res.Manifest.Chunks),
+		Stats:  stats,
+	})
+}
+
+func (ns *nodeService) Get(req *GetRequest, stream grpc.ServerStreamingServer[GetResponse]) error {
+	end, err := ns.server.transfers.begin()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	ctx, op, done := ns.ops.Start(stream.Context(), ops.KindGet, req.CID)
+	defer done()
+
+	c, err := ns.resolveFile(ctx, req.CID)
+	if err != nil {
+		return err
+	}
+	m, err := ns.node.Stat(ctx, c)
+	if err != nil {
+		return opStatus(ctx, err)
+	}
+	if err := stream.Send(&GetResponse{Name: m.Name, Size: m.Size, Chunks: len(m.Chunks)}); err != nil {
+		return err
+	}
+
+	// Progress is sent from its own goroutine, between data messages
+	var sendMu sync.Mutex
+	stopProgress := func() {}
+	if req.Progress {
+		stopProgress = reportProgress(op, func(p *Progress) error {
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			return stream.Send(&GetResponse{Progress: p})
+		})
+	}
+	defer stopProgress()
+
+	w := &streamWriter{send: func(data []byte) error {
+		op.AddBytes(len(data))
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(&GetResponse{Data: data})
+	}}
+	if _, err := ns.node.Get(ctx, c, w); err != nil {
+		return opStatus(ctx, err)
+	}
+	return nil
+}
+
+// reportProgress sends the progress of op every progressInterval until
+// the returned function is called.
+func reportProgress(op *ops.Op, send func(*Progress) error) func() {
+	if op == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if err := send(opProgress(op)); err != nil {
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+func opProgress(op *ops.Op) *Progress {
+	fetched, missing := op.Fetched()
+	fetchedChunks, missingChunks := op.FetchedBlocks()
+	return &Progress{
+		Bytes:         op.Bytes(),
+		Chunks:        op.Chunks(),
+		FetchedBytes:  fetched,
+		MissingBytes:  missing,
+		FetchedChunks: fetchedChunks,
+		MissingChunks: missingChunks,
+		Peers:         op.Peers(),
+	}
+}
+
+func (ns *nodeService) Pin(ctx context.Context, req *PinRequest) (*PinResponse, error) {
+	c, names, err := ns.parsePath(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Replicas < 0 {
+		return nil, status.Error(codes.InvalidArgument, "replicas must not be negative")
+	}
+	if req.Class != "" && (ns.replication == nil || !ns.replication.HasClass(req.Class)) {
+		return nil, status.Errorf(codes.InvalidArgument, "no replication class %q in replication.classes", req.Class)
+	}
+	if err := checkPinLabels(req.Name, req.Labels); err != nil {
+		return nil, err
+	}
+	opts := node.PinOptions{
+		Replicas: req.Replicas,
+		Class:    req.Class,
+		NoFetch:  req.NoFetch,
+		Name:     req.Name,
+		Labels:   req.Labels,
+	}
+	if req.NoFetch {
+		if len(names) > 0 {
+			if c, err = ns.node.Resolve(ctx, c, names); err != nil {
+				return nil, toStatus(err)
+			}
+		}
+		// Nothing is fetched to find out what c is, so it is checked here
+		if !slices.Contains(manifest.LinkCodecs, c.Type()) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not the hash of a file or directory", c)
+		}
+		if err := ns.node.Pin(ctx, c, opts); err != nil {
+			return nil, toStatus(err)
+		}
+		if ns.replication != nil {
+			ns.replication.Trigger()
+		}
+		return &PinResponse{}, nil
+	}
+
+	end, err := ns.server.transfers.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	ctx, _, done := ns.ops.Start(ctx, ops.KindPin, req.CID)
+	defer done()
+
+	if len(names) > 0 {
+		// Everything under the path comes in one exchange where a peer
+		// serves it, rather than a directory level at a time
+		if c, err = ns.node.FetchPath(ctx, c, names); err != nil {
+			return nil, opStatus(ctx, err)
+		}
+	}
+	if err := ns.node.Pin(ctx, c, opts); err != nil {
+		return nil, opStatus(ctx, err)
+	}
+	if ns.replication != nil {
+		ns.replication.Trigger()
+	}
+	return &PinResponse{}, nil
+}
+
+func (ns *nodeService) LabelPin(ctx context.Context, req *LabelPinRequest) (*LabelPinResponse, error) {
+	if ns.node.Pins == nil {
+		return nil, status.Error(codes.Unavailable, "node has no pin set")
+	}
+	c, err := ns.parseHash(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPinLabels(req.Name, req.Labels); err != nil {
+		return nil, err
+	}
+	if err := ns.node.Pins.Label(c, req.Name, req.Labels, req.Remove); err != nil {
+		return nil, toStatus(err)
+	}
+	// A label may change the replication factor a policy gives the pin
+	if ns.replication != nil {
+		ns.replication.Trigger()
+	}
+	return &LabelPinResponse{}, nil
+}
+
+func (ns *nodeService) UpdatePin(ctx context.Context, req *UpdatePinRequest) (*UpdatePinResponse, error) {
+	base, err := ns.parseHash(ctx, req.Base)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ns.parseHash(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+	if base.Equals(c) {
+		return nil, status.Error(codes.InvalidArgument, "a pin can't be updated to itself")
+	}
+
+	end, err := ns.server.transfers.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	ctx, _, done := ns.ops.Start(ctx, ops.KindPin, req.CID)
+	defer done()
+
+	if err := ns.node.UpdatePin(ctx, base, c, ""); err != nil {
+		return nil, opStatus(ctx, err)
+	}
+	res := &UpdatePinResponse{}
+	if ns.replication != nil {
+		res.Replicas = ns.replication.Updated(ctx, base, c)
+	}
+	return res, nil
+}
+
+func (ns *nodeService) Verify(ctx context.Context, req *VerifyRequest) (*VerifyResponse, error) {
+	c, err := ns.parseHash(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Deep && ns.replication == nil {
+		return nil, status.Error(codes.Unavailable, "replication is not running")
+	}
+
+	ctx, _, done := ns.ops.Start(ctx, ops.KindVerify, req.CID)
+	defer done()
+
+	damage, err := ns.node.Verify(ctx, c)
+	if err != nil {
+		return nil, opStatus(ctx, err)
+	}
+	res := &VerifyResponse{CID: c.String(), Blocks: damage.Blocks}
+	for _, b := range damage.Missing {
+		res.Missing = append(res.Missing, b.String())
+	}
+	for _, b := range damage.Corrupt {
+		res.Corrupt = append(res.Corrupt, b.String())
+	}
+	if !req.Deep {
+		return res, nil
+	}
+
+	// Holders are sampled from the blocks this node could list
+	for _, r := range ns.replication.Verify(ctx, c, damage.DAG, req.Samples) {
+		res.Replicas = append(res.Replicas, ReplicaIntegrity{
+			Peer:    r.Peer.String(),
+			Sampled: r.Sampled,
+			Valid:   r.Valid,
+			Missing: r.Missing,
+			Corrupt: r.Corrupt,
+			Error:   errString(r.Err),
+		})
+	}
+	if err := context.Cause(ctx); err != nil {
+		return nil, opStatus(ctx, err)
+	}
+	return res, nil
+}
+
+func (ns *nodeService) ArchivePin(ctx context.Context, req *ArchivePinRequest) (*ArchivePinResponse, error) {
+	c, err := ns.parseHash(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+
+	opCtx, _, done := ns.ops.Start(ctx, ops.KindArchive, req.CID)
+	report, err := ns.node.ArchivePin(opCtx, c)
+	done()
+	if err != nil {
+		return nil, opStatus(opCtx, err)
+	}
+	res := &ArchivePinResponse{CID: c.String(), Blocks: report.Blocks, Bytes: report.Bytes, Present: report.Present}
+
+	// The blocks are evicted now rather than once the grace period is over
+	if ns.gc == nil {
+		res.Warning = "garbage collection is not available on this node, the blocks stay in the hot store"
+		return res, nil
+	}
+	gcReport, err := ns.runGC(ctx, gc.Options{Evict: []cid.Cid{c}})
+	if err != nil {
+		res.Warning = fmt.Sprintf("the blocks stay in the hot store until the next garbage collection: %v", status.Convert(err).Message())
+		return res, nil
+	}
+	res.Evicted, res.EvictedBytes = gcReport.Evicted, gcReport.EvictedBytes
+	return res, nil
+}
+
+func (ns *nodeService) RecallPin(ctx context.Context, req *RecallPinRequest) (*RecallPinResponse, error) {
+	c, err := ns.parseHash(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := ns.server.transfers.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	ctx, _, done := ns.ops.Start(ctx, ops.KindRecall, req.CID)
+	defer done()
+
+	report, err := ns.node.RecallPin(ctx, c)
+	if err != nil {
+		return nil, opStatus(ctx, err)
+	}
+	if ns.replication != nil {
+		ns.replication.Trigger()
+	}
+	return &RecallPinResponse{CID: c.String(), Blocks: report.Blocks, Bytes: report.Bytes, Present: report.Present}, nil
+}
+
+func (ns *nodeService) Push(ctx context.Context, req *PushRequest) (*PushResponse, error) {
+	net := ns.node.Network()
+	if net == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+	c, err := ns.parseHash(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+
+	var to peer.ID
+	if strings.HasPrefix(req.To, "/") {
+		if to, err = net.Connect(ctx, req.To); err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+	} else if to, err = peer.Decode(req.To); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "peer %s: %v", req.To, err)
+	}
+
+	end, err := ns.server.transfers.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	ctx, _, done := ns.ops.Start(ctx, ops.KindPush, req.CID)
+	defer done()
+
+	if err := ns.node.Push(ctx, c, to); err != nil {
+		return nil, opStatus(ctx, err)
+	}
+	return &PushResponse{PeerID: to.String()}, nil
+}
+
+func (ns *nodeService) ListInbox(_ context.Context, _ *ListInboxRequest) (*ListInboxResponse, error) {
+	if ns.push == nil {
+		return nil, status.Error(codes.Unavailable, "daemon has no inbox: it is read-only")
+	}
+	res := &ListInboxResponse{Items: []InboxItem{}}
+	for _, item := range ns.push.Inbox.List() {
+		res.Items = append(res.Items, inboxItem(item))
+	}
+	return res, nil
+}
+
+func (ns *nodeService) AcceptInbox(ctx context.Context, req *AcceptInboxRequest) (*AcceptInboxResponse, error) {
+	if ns.push == nil {
+		return nil, status.Error(codes.Unavailable, "daemon has no inbox: it is read-only")
+	}
+	item, err := ns.push.Accept(ctx, req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if ns.replication != nil {
+		ns.replication.Trigger()
+	}
+	return &AcceptInboxResponse{Item: inboxItem(item)}, nil
+}
+
+func (ns *nodeService) RejectInbox(_ context.Context, req *RejectInboxRequest) (*RejectInboxResponse, error) {
+	if ns.push == nil {
+		return nil, status.Error(codes.Unavailable, "daemon has no inbox: it is read-only")
+	}
+	item, err := ns.push.Reject(req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &RejectInboxResponse{Item: inboxItem(item)}, nil
+}
+
+func inboxItem(item push.Item) InboxItem {
+	return InboxItem{
+		ID:       item.ID,
+		CID:      item.CID.String(),
+		Name:     item.Name,
+		Size:     item.Size,
+		From:     item.From.String(),
+		Offered:  item.Offered,
+		Received: item.Received,
+	}
+}
+
+// checkPinLabels checks a name and labels to give a pin.
+func checkPinLabels(name string, labels map[string]string) error {
+	if err := pin.CheckName(name); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for k, v := range labels {
+		if err := pin.CheckLabel(k, v); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
+
+func (ns *nodeService) Unpin(ctx context.Context, req *UnpinRequest) (*UnpinResponse, error) {
+	c, err := ns.parseHash(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ns.node.Unpin(c); err != nil {
+		return nil, toStatus(err)
+	}
+	return &UnpinResponse{}, nil
+}
+
+func (ns *nodeService) GC(ctx context.Context, req *GCRequest) (*GCResponse, error) {
+	if ns.gc == nil {
+		return nil, status.Error(codes.Unavailable, "garbage collection is not available on this node")
+	}
+
+	opts := gc.Options{GracePeriod: req.GracePeriod, DryRun: req.DryRun}
+	for _, hash := range req.Unpinned {
+		c, err := ns.parseHash(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if !ns.node.Pins.Has(c) {
+			return nil, toStatus(pin.ErrNotPinned)
+		}
+		opts.Unpinned = append(opts.Unpinned, c)
+	}
+	if len(opts.Unpinned) > 0 && !opts.DryRun {
+		return nil, status.Error(codes.InvalidArgument, "unpinned needs a dry run")
+	}
+
+	report, err := ns.runGC(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &GCResponse{
+		Pins:         report.Pins,
+		Reachable:    report.Reachable,
+		Scanned:      report.Scanned,
+		Removed:      report.Removed,
+		RemovedBytes: report.RemovedBytes,
+		Recent:       report.Recent,
+		Linked:       report.Linked,
+		Freed:        report.Freed,
+		FreedBytes:   report.FreedBytes,
+		GracePeriod:  report.GracePeriod,
+		Duration:     report.Duration,
+	}, nil
+}
+
+// runGC runs a collection as an operation that can be listed and
+// cancelled.
+func (ns *nodeService) runGC(ctx context.Context, opts gc.Options) (gc.Report, error) {
+	ctx, _, done := ns.ops.Start(ctx, ops.KindGC, "")
+	defer done()
+
+	report, err := ns.gc.Run(ctx, opts)
+	if errors.Is(err, repo.ErrReadersAlive) {
+		return report, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return report, opStatus(ctx, err)
+	}
+	return report, nil
+}
+
+func (ns *nodeService) Purge(ctx context.Context, req *PurgeRequest) (*PurgeResponse, error) {
+	var from peer.ID
+	switch {
+	case req.Origin == PurgeCache && req.Peer == "":
+	case req.Origin == PurgePeer && req.Peer != "":
+		id, err := peer.Decode(req.Peer)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "peer %s: %v", req.Peer, err)
+		}
+		from = id
+	case req.Origin == PurgePeer:
+		return nil, status.Error(codes.InvalidArgument, "purging a peer's replicas needs its ID")
+	case req.Origin == PurgeCache:
+		return nil, status.Error(codes.InvalidArgument, "purging the cache takes no peer")
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "origin must be %s or %s", PurgeCache, PurgePeer)
+	}
+	if ns.gc == nil {
+		return nil, status.Error(codes.Unavailable, "garbage collection is not available on this node")
+	}
+
+	res := &PurgeResponse{}
+	var kept []cid.Cid
+	if from != "" && ns.node.Pins != nil {
+		for _, p := range ns.node.Pins.List() {
+			if p.KeptFor == from {
+				kept = append(kept, p.CID)
+				res.Unpinned = append(res.Unpinned, p.CID.String())
+			}
+		}
+	}
+
+	// The grace period only protects blocks for pins about to be made,
+	// which a purge doesn't wait for
+	opts := gc.Options{GracePeriod: time.Nanosecond, DryRun: req.DryRun}
+	if req.DryRun {
+		opts.Unpinned = kept
+	} else {
+		for _, c := range kept {
+			if err := ns.node.Unpin(c); err != nil {
+				return nil, toStatus(err)
+			}
+		}
+	}
+	report, err := ns.runGC(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.Removed, res.RemovedBytes = report.Removed, report.RemovedBytes
+	res.Linked, res.Recent = report.Linked, report.Recent
+
+	if !req.DryRun {
+		fields := map[string]string{
+			"origin":        req.Origin,
+			"unpinned":      strconv.Itoa(len(res.Unpinned)),
+			"removed":       strconv.Itoa(res.Removed),
+			"removed_bytes": strconv.FormatInt(res.RemovedBytes, 10),
+		}
+		if from != "" {
+			fields["peer"] = from.String()
+		}
+		ns.events.Record(eventlog.Event{Type: eventlog.PrivacyPurged, Fields: fields})
+	}
+	return res, nil
+}
+
+func (ns *nodeService) Inventory(ctx context.Context, _ *InventoryRequest) (*InventoryResponse, error) {
+	if ns.gc == nil {
+		return nil, status.Error(codes.Unavailable, "garbage collection is not available on this node")
+	}
+
+	inv, err := ns.gc.Inventory(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	res := &InventoryResponse{
+		Own:      Origin(inv.Own),
+		Replicas: []ReplicaOrigin{},
+		Pending:  Origin(inv.Pending),
+		Cache:    Origin(inv.Cache),
+	}
+	for id, o := range inv.Replicas {
+		res.Replicas = append(res.Replicas, ReplicaOrigin{Peer: id.String(), Origin: Origin(o)})
+	}
+	slices.SortFunc(res.Replicas, func(a, b ReplicaOrigin) int { return strings.Compare(a.Peer, b.Peer) })
+	return res, nil
+}
+
+func (ns *nodeService) MakeDirectory(ctx context.Context, req *MakeDirectoryRequest) (*MakeDirectoryResponse, error) {
+	entries := make([]node.DirectoryEntry, len(req.Entries))
+	for i, e := range req.Entries {
+		c, err := parseCID(e.CID)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = node.DirectoryEntry{Name: e.Name, CID: c}
+	}
+
+	c, d, err := ns.node.MakeDirectory(ctx, entries, node.DirectoryOptions{NoPin: req.NoPin})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &MakeDirectoryResponse{CID: c.String(), Size: d.Size()}, nil
+}
+
+func (ns *nodeService) ListDirectory(ctx context.Context, req *ListDirectoryRequest) (*ListDirectoryResponse, error) {
+	ctx, _, done := ns.ops.Start(ctx, ops.KindStat, req.Path)
+	defer done()
+
+	c, err := ns.resolve(ctx, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ns.node.List(ctx, c)
+	if err != nil {
+		return nil, opStatus(ctx, err)
+	}
+
+	res := &ListDirectoryResponse{CID: c.String(), Entries: make([]DirectoryEntry, len(d.Entries))}
+	for i, e := range d.Entries {
+		res.Entries[i] = DirectoryEntry{Name: e.Name, CID: e.CID.String(), Size: e.Size, Dir: e.IsDir()}
+	}
+	return res, nil
+}
+
+func (ns *nodeService) ResolvePath(ctx context.Context, req *ResolvePathRequest) (*ResolvePathResponse, error) {
+	ctx, _, done := ns.ops.Start(ctx, ops.KindStat, req.Path)
+	defer done()
+
+	c, err := ns.resolve(ctx, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &ResolvePathResponse{CID: c.String()}, nil
+}
+
+func (ns *nodeService) Abbreviate(ctx context.Context, req *AbbreviateRequest) (*AbbreviateResponse, error) {
+	cids := make([]cid.Cid, len(req.CIDs))
+	for i, s := range req.CIDs {
+		c, err := parseCID(s)
+		if err != nil {
+			return nil, err
+		}
+		cids[i] = c
+	}
+	short, err := ns.node.Abbreviate(ctx, cids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &AbbreviateResponse{Short: short}, nil
+}
+
+func (ns *nodeService) PublishName(ctx context.Context, req *PublishNameRequest) (*PublishNameResponse, error) {
+	net := ns.node.Network()
+	if net == nil || net.Names() == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	c, err := ns.resolve(ctx, req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := net.Names().Publish(ctx, req.Key, c)
+	if err != nil && !errors.Is(err, network.ErrNameNotAnnounced) {
+		return nil, toStatus(err)
+	}
+
+	res := &PublishNameResponse{Name: name.String(), CID: c.String()}
+	if err != nil {
+		res.Warning = err.Error()
+	}
+	return res, nil
+}
+
+func (ns *nodeService) Share(ctx context.Context, req *ShareRequest) (*ShareResponse, error) {
+	to, err := peer.Decode(req.To)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid peer ID %q: %v", req.To, err)
+	}
+	c, err := ns.resolve(ctx, req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	capability, err := ns.node.Share(ctx, c, to, req.Expires)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	token, err := capability.Token()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ShareResponse{CID: c.String(), Token: token}, nil
+}
+
+func (ns *nodeService) RedeemShare(ctx context.Context, req *RedeemShareRequest) (*RedeemShareResponse, error) {
+	capability, err := share.Parse(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := ns.node.Redeem(capability); err != nil {
+		return nil, toStatus(err)
+	}
+	info, _ := ns.node.Shares.Get(capability.CID)
+	return &RedeemShareResponse{Share: shareInfo(info)}, nil
+}
+
+func (ns *nodeService) ListShares(ctx context.Context, _ *ListSharesRequest) (*ListSharesResponse, error) {
+	res := &ListSharesResponse{Shares: []ShareInfo{}}
+	if ns.node.Shares == nil {
+		return res, nil
+	}
+	for _, r := range ns.node.Shares.List() {
+		res.Shares = append(res.Shares, shareInfo(r))
+	}
+	return res, nil
+}
+
+func shareInfo(r share.Redeemed) ShareInfo {
+	return ShareInfo{
+		CID:      r.CID,
+		Issuer:   r.Issuer,
+		Expires:  r.Expires,
+		Redeemed: r.Redeemed,
+		Expired:  r.Expired(time.Now()),
+	}
+}
+
+func (ns *nodeService) CreateGroup(ctx context.Context, req *CreateGroupRequest) (*GroupResponse, error) {
+	members, err := decodePeers(req.Members)
+	if err != nil {
+		return nil, err
+	}
+	e, err := ns.node.CreateGroup(ctx, req.Name, members)
+	return groupResponse(e, err)
+}
+
+func (ns *nodeService) UpdateGroup(ctx context.Context, req *UpdateGroupRequest) (*GroupResponse, error) {
+	add, err := decodePeers(req.Add)
+	if err != nil {
+		return nil, err
+	}
+	remove, err := decodePeers(req.Remove)
+	if err != nil {
+		return nil, err
+	}
+	e, err := ns.node.UpdateGroup(ctx, req.Name, add, remove)
+	return groupResponse(e, err)
+}
+
+func (ns *nodeService) JoinGroup(ctx context.Context, req *JoinGroupRequest) (*GroupResponse, error) {
+	e, err := ns.node.JoinGroup(ctx, req.Name)
+	return groupResponse(e, err)
+}
+
+func (ns *nodeService) ListGroups(ctx context.Context, _ *ListGroupsRequest) (*ListGroupsResponse, error) {
+	res := &ListGroupsResponse{Groups: []GroupInfo{}}
+	if ns.node.Groups == nil {
+		return res, nil
+	}
+	for _, e := range ns.node.Groups.List() {
+		res.Groups = append(res.Groups, groupInfo(e))
+	}
+	return res, nil
+}
+
+// groupResponse answers with the group e, err being a warning when the
+// group document wasn't announced.
+func groupResponse(e *group.Entry, err error) (*GroupResponse, error) {
+	if err != nil && !errors.Is(err, network.ErrNameNotAnnounced) {
+		return nil, toStatus(err)
+	}
+	res := &GroupResponse{Group: groupInfo(*e)}
+	if err != nil {
+		res.Warning = err.Error()
+	}
+	return res, nil
+}
+
+func groupInfo(e group.Entry) GroupInfo {
+	return GroupInfo{
+		Group:   e.Group.Name,
+		Admin:   e.Group.Admin,
+		Members: e.Group.Members,
+		Name:    e.Name,
+		CID:     e.CID,
+		KeyID:   e.Group.Current(),
+		Keys:    len(e.Group.Generations),
+		Updated: e.Updated,
+	}
+}
+
+func decodePeers(ids []string) ([]peer.ID, error) {
+	peers := make([]peer.ID, 0, len(ids))
+	for _, s := range ids {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid peer ID %q: %v", s, err)
+		}
+		peers = append(peers, id)
+	}
+	return peers, nil
+}
+
+func (ns *nodeService) ResolveName(ctx context.Context, req *ResolveNameRequest) (*ResolveNameResponse, error) {
+	net := ns.node.Network()
+	if net == nil || net.Names() == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	name := req.Name
+	if name == "" {
+		key := req.Key
+		if key == "" {
+			key = network.SelfKey
+		}
+		own, err := net.Names().Name(key)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		name = own.String()
+	}
+
+	c, err := net.Names().Resolve(ctx, name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ResolveNameResponse{Name: name, CID: c.String()}, nil
+}
+
+func (ns *nodeService) ListOperations(ctx context.Context, _ *ListOperationsRequest) (*ListOperationsResponse, error) {
+	res := &ListOperationsResponse{Operations: []Operation{}}
+	for _, op := range ns.ops.List() {
+		fetched, missing := op.Fetched()
+		fetchedChunks, missingChunks := op.FetchedBlocks()
+		res.Operations = append(res.Operations, Operation{
+			ID:            op.ID,
+			Kind:          op.Kind,
+			Target:        op.Target,
+			Started:       op.Started,
+			Bytes:         op.Bytes(),
+			Fetched:       fetched,
+			Missing:       missing,
+			Peers:         op.Peers(),
+			FetchedChunks: fetchedChunks,
+			MissingChunks: missingChunks,
+		})
+	}
+	for _, dl := range ns.node.Downloads.List() {
+		res.Downloads = append(res.Downloads, Download{
+			CID:      dl.CID.String(),
+			Started:  dl.Started,
+			Running:  dl.Running,
+			Failures: dl.Failures,
+			Error:    dl.Error,
+			RetryAt:  dl.RetryAt,
+		})
+	}
+	return res, nil
+}
+
+func (ns *nodeService) CancelOperation(ctx context.Context, req *CancelOperationRequest) (*CancelOperationResponse, error) {
+	if req.CID != "" {
+		c, err := ns.parseHash(ctx, req.CID)
+		if err != nil {
+			return nil, err
+		}
+		if err := ns.node.Downloads.Remove(c); err != nil {
+			return nil, toStatus(err)
+		}
+		return &CancelOperationResponse{}, nil
+	}
+	if err := ns.ops.Cancel(req.ID); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &CancelOperationResponse{}, nil
+}
+
+func (ns *nodeService) ListPins(ctx context.Context, _ *ListPinsRequest) (*ListPinsResponse, error) {
+	res := &ListPinsResponse{Pins: []PinInfo{}}
+	if ns.node.Pins == nil {
+		return res, nil
+	}
+
+	for _, p := range ns.node.Pins.List() {
+		info := PinInfo{
+			CID:            p.CID.String(),
+			Created:        p.Created,
+			Replicas:       1,
+			Copies:         1,
+			Requested:      p.Replicas,
+			RequestedClass: p.Class,
+			Name:           p.Name,
+			Labels:         p.Labels,
+			Archived:       p.Archived,
+		}
+		if p.KeptFor != "" {
+			info.KeptFor = p.KeptFor.String()
+		}
+		if ns.replication != nil {
+			st := ns.replication.Status(p)
+			info.Replicas, info.Copies = st.Replicas, st.Copies
+			info.Zones, info.Spanned = st.Zones, st.Spanned
+			info.Class = st.Class
+		}
+		res.Pins = append(res.Pins, info)
+	}
+	return res, nil
+}
+
+func (ns *nodeService) Stats(ctx context.Context, _ *StatsRequest) (*StatsResponse, error) {
+	stats := ns.node.Stats()
+	res := &StatsResponse{
+		Blocks: stats.Storage.Blocks,
+		Bytes:  stats.Storage.Bytes,
+		Pins:   stats.Pins,
+		Peers:  stats.Peers,
+	}
+	if ns.replication != nil {
+		for _, c := range ns.replication.Compliance() {
+			res.Replication = append(res.Replication, ClassCompliance(c))
+		}
+	}
+	return res, nil
+}
+
+func (ns *nodeService) Bandwidth(ctx context.Context, _ *BandwidthRequest) (*BandwidthResponse, error) {
+	net := ns.node.Network()
+	if net == nil || net.Host() == nil {
+		return nil, status.Error(codes.Unavailable, "networking is not running")
+	}
+
+	t := net.Traffic()
+	res := &BandwidthResponse{Total: apiTraffic(t.Total)}
+	for proto, s := range t.Protocols {
+		res.Protocols = append(res.Protocols, ProtocolTraffic{Protocol: string(proto), Traffic: apiTraffic(s)})
+	}
+	for p, s := range t.Peers {
+		res.Peers = append(res.Peers, PeerTraffic{Peer: p.String(), Traffic: apiTraffic(s)})
+	}
+	sort.Slice(res.Protocols, func(i, j int) bool {
+		return res.Protocols[i].exchanged() > res.Protocols[j].exchanged()
+	})
+	sort.Slice(res.Peers, func(i, j int) bool {
+		return res.Peers[i].exchanged() > res.Peers[j].exchanged()
+	})
+	return res, nil
+}
+
+func (ns *nodeService) Health(ctx context.Context, _ *HealthRequest) (*HealthResponse, error) {
+	h := ns.server.Health.Health()
+	res := &HealthResponse{Status: h.Status}
+	for _, sub := range h.Subsystems {
+		res.Subsystems = append(res.Subsystems, SubsystemHealth{
+			Name:       sub.Name,
+			Status:     sub.Status,
+			Objective:  sub.Objective,
+			Short:      apiWindow(sub, sub.Short),
+			Long:       apiWindow(sub, sub.Long),
+			BudgetLeft: sub.BudgetLeft(),
+		})
+	}
+	return res, nil
+}
+
+func apiWindow(sub health.Subsystem, w health.Window) HealthWindow {
+	return HealthWindow{
+		Duration:   w.Duration,
+		Ops:        w.Ops,
+		Failures:   w.Failures,
+		ErrorRatio: w.ErrorRatio(),
+		BurnRate:   sub.BurnRate(w),
+	}
+}
+
+func apiTraffic(s network.TrafficStats) Traffic {
+	return Traffic{TotalIn: s.TotalIn, TotalOut: s.TotalOut, RateIn: s.RateIn, RateOut: s.RateOut}
+}
+
+func (t Traffic) exchanged() int64 {
+	return t.TotalIn + t.TotalOut
+}
+
+func (ns *nodeService) Stat(ctx context.Context, req *StatRequest) (*StatResponse, error) {
+	ctx, _, done := ns.ops.Start(ctx, ops.KindStat, req.CID)
+	defer done()
+
+	c, err := ns.resolveFile(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ns.node.Stat(ctx, c)
+	if err != nil {
+		return nil, opStatus(ctx, err)
+	}
+
+	res := &StatResponse{
+		Name:   m.Name,
+		Size:   m.Size,
+		Params: m.Params,
+		Chunks: make([]ChunkRef, len(m.Chunks)),
+	}
+	fsStore, _ := ns.node.Store().(*storage.FSBlockstore)
+	for i, ref := range m.Chunks {
+		res.Chunks[i] = ChunkRef{CID: ref.CID.String(), Size: ref.Size}
+		if fsStore != nil {
+			res.Chunks[i].Compression, _ = fsStore.Compression(ctx, ref.CID)
+		}
+	}
+	if e := m.Encryption; e != nil {
+		res.Encryption = &Encryption{Cipher: e.Cipher, KeyID: e.KeyID, WrappedKey: e.WrappedKey, Envelope: e.Envelope}
+	}
+	return res, nil
+}
+
+func (ns *nodeService) Routes(ctx context.Context, req *RoutesRequest) (*RoutesResponse, error) {
+	c, err := ns.parseHash(ctx, req.CID)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := ns.node.Routes(ctx, c)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	res := &RoutesResponse{
+		CID:    report.CID.String(),
+		Size:   report.Size,
+		Total:  report.Total,
+		Lookup: report.Lookup,
+		Fetch:  report.Fetch,
+	}
+	for _, l := range report.Lookups {
+		rl := RouteLookup{CID: l.CID.String(), Duration: l.Duration, Error: errString(l.Err)}
+		for _, id := range l.Providers {
+			rl.Providers = append(rl.Providers, id.String())
+		}
+		res.Lookups = append(res.Lookups, rl)
+	}
+	for _, p := range report.Peers {
+		rp := RoutePeer{
+			ID:       p.ID.String(),
+			RTT:      p.RTT,
+			RTTError: errString(p.RTTErr),
+			Blocks:   p.Blocks,
+			Bytes:    p.Bytes,
+			Duration: p.Duration,
+			Failures: p.Failures,
+		}
+		for _, r := range p.Ranges {
+			rp.Ranges = append(rp.Ranges, ByteRange{Offset: r.Offset, Size: r.Size})
+		}
+		res.Peers = append(res.Peers, rp)
+	}
+	for _, chunk := range report.Chunks {
+		rc := RouteChunk{
+			CID:      chunk.CID.String(),
+			Offset:   chunk.Offset,
+			Size:     chunk.Size,
+			Duration: chunk.Duration,
+		}
+		if chunk.Source != "" {
+			rc.Source = chunk.Source.String()
+		}
+		for _, id := range chunk.Failed {
+			rc.Failed = append(rc.Failed, id.String())
+		}
+		res.Chunks = append(res.Chunks, rc)
+	}
+	return res, nil
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// streamWriter splits writes into stream sized messages.
+type streamWriter struct {
+	send func([]byte) error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), streamChunkSize)
+		if err := w.send(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func addrStrings(addrs []multiaddr.Multiaddr) []string {
+	out := make([]string, len(addrs))
+	for i, addr := range addrs {
+		out[i] = addr.String()
+	}
+	return out
+}
+
+func parseCID(s string) (cid.Cid, error) {
+	c, err := cid.Decode(s)
+	if err != nil {
+		return cid.Undef, status.Errorf(codes.InvalidArgument, "invalid cid %q: %v", s, err)
+	}
+	return c, nil
+}
+
+// parseHash reads a CID, or a prefix of one stored that no other stored
+// file or directory shares.
+func (ns *nodeService) parseHash(ctx context.Context, s string) (cid.Cid, error) {
+	c, err := cid.Decode(s)
+	if err == nil {
+		return c, nil
+	}
+	if !isHashPrefix(s) {
+		return cid.Undef, status.Errorf(codes.InvalidArgument, "invalid cid %q: %v", s, err)
+	}
+	c, err = ns.node.Expand(ctx, s)
+	if err != nil {
+		return cid.Undef, toStatus(err)
+	}
+	return c, nil
+}
+
+// isHashPrefix tells whether s could start a CID as this node prints them,
+// in lowercase base32.
+func isHashPrefix(s string) bool {
+	if !strings.HasPrefix(s, "b") {
+		return false
+	}
+	for _, r := range s {
+		if !('a' <= r && r <= 'z' || '2' <= r && r <= '7') {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve turns a CID or a path below one into the CID it names. The CID
+// may be abbreviated, see parseHash.
+func (ns *nodeService) resolve(ctx context.Context, s string) (cid.Cid, error) {
+	root, names, err := ns.parsePath(ctx, s)
+	if err != nil || len(names) == 0 {
+		return root, err
+	}
+	c, err := ns.node.Resolve(ctx, root, names)
+	if err != nil {
+		return cid.Undef, opStatus(ctx, err)
+	}
+	return c, nil
+}
+
+// parsePath splits a CID or a path below one into the CID and the names
+// below it, see manifest.ParsePath. The CID may be abbreviated.
+func (ns *nodeService) parsePath(ctx context.Context, s string) (cid.Cid, []string, error) {
+	first, rest, below := strings.Cut(strings.TrimPrefix(s, "/"), "/")
+	root, err := ns.parseHash(ctx, first)
+	if err != nil || !below {
+		return root, nil, err
+	}
+	root, names, err := manifest.ParsePath(root.String() + "/" + rest)
+	if err != nil {
+		return cid.Undef, nil, status.Errorf(codes.InvalidArgument, "invalid path %q: %v", s, err)
+	}
+	return root, names, nil
+}
+
+// resolveFile is resolve for requests that need a file.
+func (ns *nodeService) resolveFile(ctx context.Context, s string) (cid.Cid, error) {
+	c, err := ns.resolve(ctx, s)
+	if err == nil && c.Type() == manifest.DirectoryCodec {
+		return cid.Undef, status.Errorf(codes.FailedPrecondition, "%s is a directory", s)
+	}
+	return c, err
+}
+
+// opStatus is toStatus for an operation run under ctx, which reports being
+// cancelled through the ops registry as such whatever err it caused.
+func opStatus(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ops.ErrCanceled) {
+		return status.Error(codes.Canceled, ops.ErrCanceled.Error())
+	}
+	return toStatus(err)
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, pin.ErrNotPinned), errors.Is(err, manifest.ErrNoEntry),
+		errors.Is(err, network.ErrNameNotFound), errors.Is(err, node.ErrNoDownload), errors.Is(err, node.ErrNoGroup),
+		errors.Is(err, push.ErrNoItem):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, network.ErrInvalidName), errors.Is(err, network.ErrInvalidKeyName), errors.Is(err, node.ErrAmbiguous),
+		errors.Is(err, share.ErrBadSignature), errors.Is(err, group.ErrInvalidName), errors.Is(err, group.ErrBadSignature):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, manifest.ErrNotDirectory), errors.Is(err, node.ErrNotEncrypted), errors.Is(err, node.ErrArchived),
+		errors.Is(err, node.ErrNotArchived), errors.Is(err, coldstore.ErrNotConfigured), errors.Is(err, node.ErrIncomplete),
+		errors.Is(err, push.ErrReceiving):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, node.ErrNoMasterKey), errors.Is(err, crypt.ErrWrongKey), errors.Is(err, share.ErrExpired),
+		errors.Is(err, share.ErrNotRecipient), errors.Is(err, network.ErrRevoked), errors.Is(err, group.ErrNotMember),
+		errors.Is(err, group.ErrNotAdmin), errors.Is(err, network.ErrPushRefused):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/gc"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/repo"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stalled is a block source that never answers, like a peer gone quiet
+// in the middle of a transfer.
+type stalled struct{}
+
+func (stalled) Get(ctx context.Context, _ cid.Cid) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stalled) List(context.Context, func(cid.Cid) error) error { return nil }
+
+// startDaemon serves a node over a Unix socket, connected to one peer that
+// never answers a fetch.
+func startDaemon(t *testing.T) (*Client, *ops.Registry) {
+	t.Helper()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	nets := make([]*network.P2PNetworking, 2)
+	for i, blocks := range []network.BlockSource{nil, stalled{}} {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets[i] = network.NewP2PNetworking(network.P2PNetworkingOpts{
+			Blocks:     blocks,
+			CustomHost: h,
+			Logger:     zap.NewNop(),
+		})
+		if err := nets[i].Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nets[i].Close() })
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	registry := ops.NewRegistry()
+	srv := NewServer(ServerOpts{
+		Node:       node.NewNode(node.NodeOpts{Store: store, Network: nets[0]}),
+		SocketPath: filepath.Join(t.TempDir(), "api.sock"),
+		Ops:        registry,
+		Logger:     zap.NewNop(),
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	client, err := Dial(srv.SocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, registry
+}
+
+// TestDeadlineAbortsFetch checks that the deadline a client sets, as
+// dfs --timeout does, reaches the daemon and ends the fetch it started
+// there rather than just the wait for its answer.
+func TestDeadlineAbortsFetch(t *testing.T) {
+	client, registry := startDaemon(t)
+
+	hash, err := multihash.Sum([]byte("held by nobody"), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := cid.NewCidV1(manifest.Codec, hash)
+
+	const timeout = 500 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		g, err := client.Get(ctx, missing.String(), nil)
+		if err == nil {
+			_, err = io.Copy(io.Discard, g)
+		}
+		errc <- err
+	}()
+
+	// The fetch runs on the daemon until the deadline
+	time.Sleep(timeout / 2)
+	if n := len(registry.List()); n != 1 {
+		t.Fatalf("%d operations running on the daemon during the fetch, want 1", n)
+	}
+
+	select {
+	case err := <-errc:
+		if code := status.Code(err); code != codes.DeadlineExceeded {
+			t.Fatalf("get ended with %v, want %v", err, codes.DeadlineExceeded)
+		}
+	case <-time.After(10 * timeout):
+		t.Fatal("get outlived its deadline")
+	}
+
+	// The daemon's handler gave up too, instead of fetching on for nobody
+	deadline := time.Now().Add(2 * time.Second)
+	for len(registry.List()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("fetch still running on the daemon after the deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestTCPAddrLoopbackOnly checks the unauthenticated API is only served
+// on TCP on a loopback address.
+func TestTCPAddrLoopbackOnly(t *testing.T) {
+	for _, addr := range []string{":0", "0.0.0.0:0", "[::]:0", "192.0.2.1:0", "example.com:0", "127.0.0.1"} {
+		srv := NewServer(ServerOpts{TCPAddr: addr, Logger: zap.NewNop()})
+		if err := srv.Start(); err == nil {
+			srv.Close()
+			t.Errorf("API served on %s", addr)
+		}
+	}
+
+	srv := NewServer(ServerOpts{TCPAddr: "127.0.0.1:0", Logger: zap.NewNop()})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+}
+
+// A daemon must not take the socket of one still running, only a stale
+// one left by a daemon gone.
+func TestSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	first := NewServer(ServerOpts{SocketPath: path, Logger: zap.NewNop()})
+	if err := first.Start(); err != nil {
+		t.Fatal(err)
+	}
+	second := NewServer(ServerOpts{SocketPath: path, Logger: zap.NewNop()})
+	if err := second.Start(); !errors.Is(err, ErrSocketInUse) {
+		t.Errorf("second Start = %v, want %v", err, ErrSocketInUse)
+	}
+	if conn, err := net.Dial("unix", path); err != nil {
+		t.Errorf("first daemon lost its socket: %v", err)
+	} else {
+		conn.Close()
+	}
+	first.Close()
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	stale := NewServer(ServerOpts{SocketPath: path, Logger: zap.NewNop()})
+	if err := stale.Start(); err != nil {
+		t.Errorf("Start over a stale socket: %v", err)
+	}
+	stale.Close()
+}
+
+// A purge of a peer's replicas unpins them and removes their blocks, and
+// the daemon records it in its event log, a dry run neither.
+func TestPurgePeer(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	pins, err := pin.Open(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := repo.AcquireWrite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lock.Close() })
+
+	n := node.NewNode(node.NodeOpts{
+		Store:    store,
+		Pins:     pins,
+		Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 256},
+	})
+	var events bytes.Buffer
+	srv := NewServer(ServerOpts{
+		Node:       n,
+		SocketPath: filepath.Join(dir, "api.sock"),
+		GC:         gc.NewCollector(gc.CollectorOpts{Store: store, Pins: pins, Lock: lock}),
+		Ops:        ops.NewRegistry(),
+		Events:     eventlog.NewRecorder(&events),
+		Logger:     zap.NewNop(),
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	client, err := Dial(srv.SocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	ctx := context.Background()
+	own, err := n.Add(ctx, strings.NewReader(strings.Repeat("own ", 200)), node.AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := n.Add(ctx, strings.NewReader(strings.Repeat("replica ", 200)), node.AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pins.SetKeptFor(replica.CID, from, 1600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Purge(ctx, &PurgeRequest{Origin: PurgeCache, Peer: from.String()}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("purging the cache with a peer: %v", err)
+	}
+	if _, err := client.Purge(ctx, &PurgeRequest{Origin: PurgePeer}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("purging a peer without its ID: %v", err)
+	}
+
+	dry, err := client.Purge(ctx, &PurgeRequest{Origin: PurgePeer, Peer: from.String(), DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Unpinned) != 1 || dry.Unpinned[0] != replica.CID.String() || dry.Removed == 0 {
+		t.Errorf("dry run = %+v", dry)
+	}
+	if !pins.Has(replica.CID) || events.Len() != 0 {
+		t.Fatal("dry run unpinned the replica or was recorded")
+	}
+
+	res, err := client.Purge(ctx, &PurgeRequest{Origin: PurgePeer, Peer: from.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Removed != dry.Removed || res.RemovedBytes != dry.RemovedBytes {
+		t.Errorf("purge = %+v, dry run said %+v", res, dry)
+	}
+	if pins.Has(replica.CID) || !pins.Has(own.CID) {
+		t.Error("purge left the replica pinned or unpinned the own file")
+	}
+	var recorded []eventlog.Event
+	if err := eventlog.Read(&events, func(e eventlog.Event) error {
+		recorded = append(recorded, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].Type != eventlog.PrivacyPurged ||
+		recorded[0].Fields["peer"] != from.String() || recorded[0].Fields["unpinned"] != "1" {
+		t.Errorf("recorded %+v", recorded)
+	}
+}
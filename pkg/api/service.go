@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	serviceName = "dfs.api.v1.Node"
+
+	methodNodeInfo    = "/" + serviceName + "/NodeInfo"
+	methodListPeers   = "/" + serviceName + "/ListPeers"
+	methodConnectPeer = "/" + serviceName + "/ConnectPeer"
+	methodBlockPeer   = "/" + serviceName + "/BlockPeer"
+	methodUnblockPeer = "/" + serviceName + "/UnblockPeer"
+	methodListBlocked = "/" + serviceName + "/ListBlocked"
+	methodRevokeKey   = "/" + serviceName + "/RevokeKey"
+	methodNATStatus   = "/" + serviceName + "/NATStatus"
+	methodAdd         = "/" + serviceName + "/Add"
+	methodGet         = "/" + serviceName + "/Get"
+	methodPin         = "/" + serviceName + "/Pin"
+	methodListPins    = "/" + serviceName + "/ListPins"
+	methodLabelPin    = "/" + serviceName + "/LabelPin"
+	methodUpdatePin   = "/" + serviceName + "/UpdatePin"
+	methodVerify      = "/" + serviceName + "/Verify"
+	methodArchivePin  = "/" + serviceName + "/ArchivePin"
+	methodRecallPin   = "/" + serviceName + "/RecallPin"
+	methodPush        = "/" + serviceName + "/Push"
+	methodListInbox   = "/" + serviceName + "/ListInbox"
+	methodAcceptInbox = "/" + serviceName + "/AcceptInbox"
+	methodRejectInbox = "/" + serviceName + "/RejectInbox"
+	methodStats       = "/" + serviceName + "/Stats"
+	methodBandwidth   = "/" + serviceName + "/Bandwidth"
+	methodHealth      = "/" + serviceName + "/Health"
+	methodRoutes      = "/" + serviceName + "/Routes"
+	methodStat        = "/" + serviceName + "/Stat"
+	methodUnpin       = "/" + serviceName + "/Unpin"
+	methodGC          = "/" + serviceName + "/GC"
+	methodInventory   = "/" + serviceName + "/Inventory"
+	methodPurge       = "/" + serviceName + "/Purge"
+
+	methodMakeDirectory = "/" + serviceName + "/MakeDirectory"
+	methodListDirectory = "/" + serviceName + "/ListDirectory"
+	methodResolvePath   = "/" + serviceName + "/ResolvePath"
+	methodAbbreviate    = "/" + serviceName + "/Abbreviate"
+
+	methodPublishName = "/" + serviceName + "/PublishName"
+	methodResolveName = "/" + serviceName + "/ResolveName"
+
+	methodShare       = "/" + serviceName + "/Share"
+	methodRedeemShare = "/" + serviceName + "/RedeemShare"
+	methodListShares  = "/" + serviceName + "/ListShares"
+
+	methodCreateGroup = "/" + serviceName + "/CreateGroup"
+	methodUpdateGroup = "/" + serviceName + "/UpdateGroup"
+	methodJoinGroup   = "/" + serviceName + "/JoinGroup"
+	methodListGroups  = "/" + serviceName + "/ListGroups"
+
+	methodListOperations  = "/" + serviceName + "/ListOperations"
+	methodCancelOperation = "/" + serviceName + "/CancelOperation"
+
+	methodHandoff = "/" + serviceName + "/Handoff"
+)
+
+// NodeServer is the daemon control service.
+type NodeServer interface {
+	NodeInfo(context.Context, *NodeInfoRequest) (*NodeInfoResponse, error)
+	ListPeers(context.Context, *ListPeersRequest) (*ListPeersResponse, error)
+	ConnectPeer(context.Context, *ConnectPeerRequest) (*ConnectPeerResponse, error)
+	BlockPeer(context.Context, *BlockPeerRequest) (*BlockPeerResponse, error)
+	UnblockPeer(context.Context, *UnblockPeerRequest) (*UnblockPeerResponse, error)
+	ListBlocked(context.Context, *ListBlockedRequest) (*ListBlockedResponse, error)
+	RevokeKey(context.Context, *RevokeKeyRequest) (*RevokeKeyResponse, error)
+	NATStatus(context.Context, *NATStatusRequest) (*NATStatusResponse, error)
+	Add(grpc.BidiStreamingServer[AddRequest, AddResponse]) error
+	Get(*GetRequest, grpc.ServerStreamingServer[GetResponse]) error
+	Pin(context.Context, *PinRequest) (*PinResponse, error)
+	ListPins(context.Context, *ListPinsRequest) (*ListPinsResponse, error)
+	LabelPin(context.Context, *LabelPinRequest) (*LabelPinResponse, error)
+	UpdatePin(context.Context, *UpdatePinRequest) (*UpdatePinResponse, error)
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	ArchivePin(context.Context, *ArchivePinRequest) (*ArchivePinResponse, error)
+	RecallPin(context.Context, *RecallPinRequest) (*RecallPinResponse, error)
+	Push(context.Context, *PushRequest) (*PushResponse, error)
+	ListInbox(context.Context, *ListInboxRequest) (*ListInboxResponse, error)
+	AcceptInbox(context.Context, *AcceptInboxRequest) (*AcceptInboxResponse, error)
+	RejectInbox(context.Context, *RejectInboxRequest) (*RejectInboxResponse, error)
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	Bandwidth(context.Context, *BandwidthRequest) (*BandwidthResponse, error)
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	Routes(context.Context, *RoutesRequest) (*RoutesResponse, error)
+	Stat(context.Context, *StatRequest) (*StatResponse, error)
+	Unpin(context.Context, *UnpinRequest) (*UnpinResponse, error)
+	GC(context.Context, *GCRequest) (*GCResponse, error)
+	Inventory(context.Context, *InventoryRequest) (*InventoryResponse, error)
+	Purge(context.Context, *PurgeRequest) (*PurgeResponse, error)
+	MakeDirectory(context.Context, *MakeDirectoryRequest) (*MakeDirectoryResponse, error)
+	ListDirectory(context.Context, *ListDirectoryRequest) (*ListDirectoryResponse, error)
+	ResolvePath(context.Context, *ResolvePathRequest) (*ResolvePathResponse, error)
+	Abbreviate(context.Context, *AbbreviateRequest) (*AbbreviateResponse, error)
+	PublishName(context.Context, *PublishNameRequest) (*PublishNameResponse, error)
+	ResolveName(context.Context, *ResolveNameRequest) (*ResolveNameResponse, error)
+	Share(context.Context, *ShareRequest) (*ShareResponse, error)
+	RedeemShare(context.Context, *RedeemShareRequest) (*RedeemShareResponse, error)
+	ListShares(context.Context, *ListSharesRequest) (*ListSharesResponse, error)
+	CreateGroup(context.Context, *CreateGroupRequest) (*GroupResponse, error)
+	UpdateGroup(context.Context, *UpdateGroupRequest) (*GroupResponse, error)
+	JoinGroup(context.Context, *JoinGroupRequest) (*GroupResponse, error)
+	ListGroups(context.Context, *ListGroupsRequest) (*ListGroupsResponse, error)
+	ListOperations(context.Context, *ListOperationsRequest) (*ListOperationsResponse, error)
+	CancelOperation(context.Context, *CancelOperationRequest) (*CancelOperationResponse, error)
+	Handoff(context.Context, *HandoffRequest) (*HandoffResponse, error)
+}
+
+func RegisterNodeServer(s grpc.ServiceRegistrar, srv NodeServer) {
+	s.RegisterService(&nodeServiceDesc, srv)
+}
+
+// unary adapts a typed NodeServer method to a grpc.MethodDesc handler.
+func unary[Req, Res any](method string, call func(NodeServer, context.Context, *Req) (*Res, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method[len(serviceName)+2:],
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := new(Req)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(NodeServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: method}
+			return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+				return call(srv.(NodeServer), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+var nodeServiceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*NodeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary(methodNodeInfo, NodeServer.NodeInfo),
+		unary(methodListPeers, NodeServer.ListPeers),
+		unary(methodConnectPeer, NodeServer.ConnectPeer),
+		unary(methodBlockPeer, NodeServer.BlockPeer),
+		unary(methodUnblockPeer, NodeServer.UnblockPeer),
+		unary(methodListBlocked, NodeServer.ListBlocked),
+		unary(methodRevokeKey, NodeServer.RevokeKey),
+		unary(methodNATStatus, NodeServer.NATStatus),
+		unary(methodPin, NodeServer.Pin),
+		unary(methodListPins, NodeServer.ListPins),
+		unary(methodLabelPin, NodeServer.LabelPin),
+		unary(methodUpdatePin, NodeServer.UpdatePin),
+		unary(methodVerify, NodeServer.Verify),
+		unary(methodArchivePin, NodeServer.ArchivePin),
+		unary(methodRecallPin, NodeServer.RecallPin),
+		unary(methodPush, NodeServer.Push),
+		unary(methodListInbox, NodeServer.ListInbox),
+		unary(methodAcceptInbox, NodeServer.AcceptInbox),
+		unary(methodRejectInbox, NodeServer.RejectInbox),
+		unary(methodStats, NodeServer.Stats),
+		unary(methodBandwidth, NodeServer.Bandwidth),
+		unary(methodHealth, NodeServer.Health),
+		unary(methodRoutes, NodeServer.Routes),
+		unary(methodStat, NodeServer.Stat),
+		unary(methodUnpin, NodeServer.Unpin),
+		unary(methodGC, NodeServer.GC),
+		unary(methodInventory, NodeServer.Inventory),
+		unary(methodPurge, NodeServer.Purge),
+		unary(methodMakeDirectory, NodeServer.MakeDirectory),
+		unary(methodListDirectory, NodeServer.ListDirectory),
+		unary(methodResolvePath, NodeServer.ResolvePath),
+		unary(methodAbbreviate, NodeServer.Abbreviate),
+		unary(methodPublishName, NodeServer.PublishName),
+		unary(methodResolveName, NodeServer.ResolveName),
+		unary(methodShare, NodeServer.Share),
+		unary(methodRedeemShare, NodeServer.RedeemShare),
+		unary(methodListShares, NodeServer.ListShares),
+		unary(methodCreateGroup, NodeServer.CreateGroup),
+		unary(methodUpdateGroup, NodeServer.UpdateGroup),
+		unary(methodJoinGroup, NodeServer.JoinGroup),
+		unary(methodListGroups, NodeServer.ListGroups),
+		unary(methodListOperations, NodeServer.ListOperations),
+		unary(methodCancelOperation, NodeServer.CancelOperation),
+		unary(methodHandoff, NodeServer.Handoff),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Add",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(NodeServer).Add(&grpc.GenericServerStream[AddRequest, AddResponse]{ServerStream: stream})
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName: "Get",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				in := new(GetRequest)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(NodeServer).Get(in, &grpc.GenericServerStream[GetRequest, GetResponse]{ServerStream: stream})
+			},
+			ServerStreams: true,
+		},
+	},
+}
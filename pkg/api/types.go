@@ -0,0 +1,817 @@
+package api
+
+import (
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+)
+
+type NodeInfoRequest struct{}
+
+type NodeInfoResponse struct {
+	PeerID    string   `json:"peer_id"`
+	Addresses []string `json:"addresses"`
+	// Proxy is the SOCKS5 proxy the node connects through, and
+	// Limitations what that leaves out.
+	Proxy       string   `json:"proxy,omitempty"`
+	Limitations []string `json:"limitations,omitempty"`
+}
+
+type ListPeersRequest struct{}
+
+type Peer struct {
+	ID        string   `json:"id"`
+	Addresses []string `json:"addresses"`
+}
+
+type ListPeersResponse struct {
+	Peers []Peer `json:"peers"`
+}
+
+type ConnectPeerRequest struct {
+	// Address is a full multiaddr including the /p2p/<peer id> part.
+	Address string `json:"address"`
+}
+
+type ConnectPeerResponse struct {
+	PeerID string `json:"peer_id"`
+}
+
+type BlockPeerRequest struct {
+	// Rule is a peer ID, IP address or CIDR range.
+	Rule string `json:"rule"`
+}
+
+type BlockPeerResponse struct{}
+
+type UnblockPeerRequest struct {
+	Rule string `json:"rule"`
+}
+
+type UnblockPeerResponse struct{}
+
+type ListBlockedRequest struct{}
+
+type ListBlockedResponse struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+type RevokeKeyRequest struct {
+	Revocation network.Revocation `json:"revocation"`
+}
+
+// RevokeKeyResponse tells whether the daemon didn't know of the
+// revocation yet, and so announced it.
+type RevokeKeyResponse struct {
+	Added bool `json:"added"`
+}
+
+type NATStatusRequest struct{}
+
+// NATStatusResponse mirrors network.NATStatus.
+type NATStatusResponse struct {
+	PortMap      bool          `json:"port_map"`
+	Gateway      bool          `json:"gateway"`
+	Mappings     []PortMapping `json:"mappings,omitempty"`
+	Static       []string      `json:"static,omitempty"`
+	Reachability string        `json:"reachability"`
+	Reachable    []string      `json:"reachable,omitempty"`
+	Unreachable  []string      `json:"unreachable,omitempty"`
+	Unknown      []string      `json:"unknown,omitempty"`
+}
+
+type PortMapping struct {
+	Internal string `json:"internal"`
+	// External is empty while the gateway hasn't granted the mapping.
+	External string `json:"external,omitempty"`
+}
+
+// AddRequest is streamed by the client. The first message carries the file
+// name and options, every message may carry data.
+type AddRequest struct {
+	Name     string           `json:"name,omitempty"`
+	Chunking *chunking.Params `json:"chunking,omitempty"`
+	// NoPin leaves the file unpinned, for one about to go in a directory.
+	// A session pin keeps it until the directory is pinned.
+	NoPin bool `json:"no_pin,omitempty"`
+	// Encrypt seals the file's chunks with a key wrapped by the daemon's
+	// master key.
+	Encrypt bool `json:"encrypt,omitempty"`
+	// Group encrypts the file with the key of the group so called, for its
+	// members to read it. Implies Encrypt.
+	Group string `json:"group,omitempty"`
+	// Base is the hash of an earlier version of the file, see
+	// node.AddOptions.Base.
+	Base string `json:"base,omitempty"`
+	// Progress asks for progress messages while the file is added.
+	Progress bool   `json:"progress,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+// AddResponse is streamed by the server: progress messages when asked for,
+// then the added file.
+type AddResponse struct {
+	CID      string    `json:"cid,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Chunks   int       `json:"chunks,omitempty"`
+	Stats    *AddStats `json:"stats,omitempty"`
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// Progress is how far an add or get has got.
+type Progress struct {
+	// Bytes is the data received by the daemon for an add, and sent by it
+	// for a get. Chunks counts the chunks an add stored.
+	Bytes  int64 `json:"bytes"`
+	Chunks int64 `json:"chunks,omitempty"`
+	// FetchedBytes and FetchedChunks count what a get fetched from peers so
+	// far, out of MissingBytes and MissingChunks not stored locally. Peers
+	// is how many peers it fetches from.
+	FetchedBytes  int64 `json:"fetched_bytes,omitempty"`
+	MissingBytes  int64 `json:"missing_bytes,omitempty"`
+	FetchedChunks int64 `json:"fetched_chunks,omitempty"`
+	MissingChunks int64 `json:"missing_chunks,omitempty"`
+	Peers         int   `json:"peers,omitempty"`
+}
+
+// AddStats describes how an added file was chunked and deduplicated.
+type AddStats struct {
+	Strategy    string      `json:"strategy"`
+	MinChunk    int64       `json:"min_chunk"`
+	MaxChunk    int64       `json:"max_chunk"`
+	Sizes       []SizeClass `json:"sizes"`
+	DedupChunks int         `json:"dedup_chunks"`
+	DedupBytes  int64       `json:"dedup_bytes"`
+	// ReusedChunks and ReusedBytes count the chunks taken over from the
+	// base without being hashed.
+	ReusedChunks int   `json:"reused_chunks,omitempty"`
+	ReusedBytes  int64 `json:"reused_bytes,omitempty"`
+}
+
+// SizeClass counts the chunks with a size in (UpTo/2, UpTo].
+type SizeClass struct {
+	UpTo  int64 `json:"up_to"`
+	Count int   `json:"count"`
+}
+
+// GetRequest names a file by CID or by path, /<root>/sub/file.txt.
+type GetRequest struct {
+	CID string `json:"cid"`
+	// Progress asks for progress messages while the file is fetched.
+	Progress bool `json:"progress,omitempty"`
+}
+
+// GetResponse is streamed by the server. The first message carries the file
+// name, size and number of chunks, the others data or progress.
+type GetResponse struct {
+	Name     string    `json:"name,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Chunks   int       `json:"chunks,omitempty"`
+	Data     []byte    `json:"data,omitempty"`
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// StatRequest names a file by CID or by path.
+type StatRequest struct {
+	CID string `json:"cid"`
+}
+
+// StatResponse describes a file as recorded in its manifest.
+type StatResponse struct {
+	Name   string          `json:"name"`
+	Size   int64           `json:"size"`
+	Params chunking.Params `json:"params"`
+	Chunks []ChunkRef      `json:"chunks"`
+	// Encryption is set for an encrypted file.
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// Encryption mirrors manifest.Encryption.
+type Encryption struct {
+	Cipher     string `json:"cipher"`
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Envelope   []byte `json:"envelope,omitempty"`
+}
+
+type ChunkRef struct {
+	CID  string `json:"cid"`
+	Size int64  `json:"size"`
+	// Compression is how this node keeps the chunk on disk. It isn't part
+	// of the manifest.
+	Compression string `json:"compression,omitempty"`
+}
+
+// PinRequest names what to pin by CID or by path, /<root>/sub/dir.
+type PinRequest struct {
+	CID string `json:"cid"`
+	// Replicas sets the pin's replication factor when positive, Class its
+	// replication class when not empty. Either replaces the other.
+	Replicas int    `json:"replicas,omitempty"`
+	Class    string `json:"class,omitempty"`
+	// NoFetch records the pin without fetching what isn't stored locally.
+	NoFetch bool `json:"no_fetch,omitempty"`
+	// Name and Labels are set on the pin, added to those it has.
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type PinResponse struct{}
+
+type LabelPinRequest struct {
+	CID string `json:"cid"`
+	// Name renames the pin when not empty.
+	Name string `json:"name,omitempty"`
+	// Labels are set on the pin, then those keyed in Remove removed.
+	Labels map[string]string `json:"labels,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+type LabelPinResponse struct{}
+
+// UpdatePinRequest moves the pin of Base to CID, a later version of the
+// same file or directory tree.
+type UpdatePinRequest struct {
+	Base string `json:"base"`
+	CID  string `json:"cid"`
+}
+
+type UpdatePinResponse struct {
+	// Replicas is the number of peers that updated their copy of Base.
+	Replicas int `json:"replicas"`
+}
+
+type ArchivePinRequest struct {
+	CID string `json:"cid"`
+}
+
+// ArchivePinResponse mirrors node.TierReport, and what the collection that
+// followed removed from the hot store.
+type ArchivePinResponse struct {
+	CID          string `json:"cid"`
+	Blocks       int    `json:"blocks"`
+	Bytes        int64  `json:"bytes"`
+	Present      int    `json:"present,omitempty"`
+	Evicted      int    `json:"evicted"`
+	EvictedBytes int64  `json:"evicted_bytes"`
+	// Warning is set when the pin was archived but its blocks couldn't be
+	// removed yet.
+	Warning string `json:"warning,omitempty"`
+}
+
+type RecallPinRequest struct {
+	CID string `json:"cid"`
+}
+
+// RecallPinResponse mirrors node.TierReport.
+type RecallPinResponse struct {
+	CID     string `json:"cid"`
+	Blocks  int    `json:"blocks"`
+	Bytes   int64  `json:"bytes"`
+	Present int    `json:"present,omitempty"`
+}
+
+// PushRequest sends a file or directory tree to a peer accepting pushes.
+// To is the peer's ID, if it is connected, or a multiaddr ending in
+// /p2p/<id> to connect to it.
+type PushRequest struct {
+	CID string `json:"cid"`
+	To  string `json:"to"`
+}
+
+type PushResponse struct {
+	PeerID string `json:"peer_id"`
+}
+
+// InboxItem mirrors push.Item, a push waiting to be accepted or rejected.
+// Received is unset while it is still being fetched.
+type InboxItem struct {
+	ID       uint64    `json:"id"`
+	CID      string    `json:"cid"`
+	Name     string    `json:"name,omitempty"`
+	Size     int64     `json:"size"`
+	From     string    `json:"from"`
+	Offered  time.Time `json:"offered"`
+	Received time.Time `json:"received,omitzero"`
+}
+
+type ListInboxRequest struct{}
+
+type ListInboxResponse struct {
+	Items []InboxItem `json:"items"`
+}
+
+// AcceptInboxRequest pins the inbox item ID and takes it out of the inbox.
+type AcceptInboxRequest struct {
+	ID uint64 `json:"id"`
+}
+
+type AcceptInboxResponse struct {
+	Item InboxItem `json:"item"`
+}
+
+// RejectInboxRequest discards the inbox item ID, leaving its blocks to
+// garbage collection.
+type RejectInboxRequest struct {
+	ID uint64 `json:"id"`
+}
+
+type RejectInboxResponse struct {
+	Item InboxItem `json:"item"`
+}
+
+type UnpinRequest struct {
+	CID string `json:"cid"`
+}
+
+type UnpinResponse struct{}
+
+type MakeDirectoryRequest struct {
+	Entries []DirectoryEntry `json:"entries"`
+	// NoPin leaves the directory unpinned, for one that goes in another.
+	NoPin bool `json:"no_pin,omitempty"`
+}
+
+type MakeDirectoryResponse struct {
+	CID  string `json:"cid"`
+	Size int64  `json:"size"`
+}
+
+// DirectoryEntry mirrors manifest.Entry. Size and Dir are ignored when
+// making a directory.
+type DirectoryEntry struct {
+	Name string `json:"name"`
+	CID  string `json:"cid"`
+	Size int64  `json:"size,omitempty"`
+	Dir  bool   `json:"dir,omitempty"`
+}
+
+// ListDirectoryRequest names a directory by CID or by path.
+type ListDirectoryRequest struct {
+	Path string `json:"path"`
+}
+
+type ListDirectoryResponse struct {
+	CID     string           `json:"cid"`
+	Entries []DirectoryEntry `json:"entries"`
+}
+
+// PublishNameRequest points the name of Key, the node's own key when
+// empty, at Path, a CID or a path below one.
+type PublishNameRequest struct {
+	Key  string `json:"key,omitempty"`
+	Path string `json:"path"`
+}
+
+type PublishNameResponse struct {
+	Name string `json:"name"`
+	CID  string `json:"cid"`
+	// Warning is set when the record was kept but couldn't be announced
+	// in the DHT yet.
+	Warning string `json:"warning,omitempty"`
+}
+
+// ResolveNameRequest asks what Name points to, the node's own name when
+// empty.
+// ResolveNameRequest resolves Name, or when it is empty the name of this
+// node's key called Key, its own name when both are.
+type ResolveNameRequest struct {
+	Name string `json:"name,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+type ResolveNameResponse struct {
+	Name string `json:"name"`
+	CID  string `json:"cid"`
+}
+
+// ShareRequest asks for a capability granting the peer To access to the
+// encrypted file at Path, a CID or a path below one, until Expires unless
+// it is zero.
+type ShareRequest struct {
+	Path    string    `json:"path"`
+	To      string    `json:"to"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+type ShareResponse struct {
+	CID   string `json:"cid"`
+	Token string `json:"token"`
+}
+
+type RedeemShareRequest struct {
+	Token string `json:"token"`
+}
+
+type RedeemShareResponse struct {
+	Share ShareInfo `json:"share"`
+}
+
+type ListSharesRequest struct{}
+
+type ListSharesResponse struct {
+	Shares []ShareInfo `json:"shares"`
+}
+
+// ShareInfo describes a capability this node redeemed.
+type ShareInfo struct {
+	CID      string    `json:"cid"`
+	Issuer   string    `json:"issuer"`
+	Expires  time.Time `json:"expires,omitzero"`
+	Redeemed time.Time `json:"redeemed"`
+	Expired  bool      `json:"expired,omitempty"`
+}
+
+// CreateGroupRequest creates the group Name, administered by the daemon,
+// with the peers Members besides it.
+type CreateGroupRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members,omitempty"`
+}
+
+// UpdateGroupRequest adds the peers Add to the group Name and removes the
+// peers Remove.
+type UpdateGroupRequest struct {
+	Name   string   `json:"name"`
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// JoinGroupRequest joins the group published under the name Name.
+type JoinGroupRequest struct {
+	Name string `json:"name"`
+}
+
+type GroupResponse struct {
+	Group GroupInfo `json:"group"`
+	// Warning is set when the group document was published but not
+	// announced.
+	Warning string `json:"warning,omitempty"`
+}
+
+type ListGroupsRequest struct{}
+
+type ListGroupsResponse struct {
+	Groups []GroupInfo `json:"groups"`
+}
+
+// GroupInfo describes a group the node created or joined.
+type GroupInfo struct {
+	Group   string   `json:"group"`
+	Admin   string   `json:"admin"`
+	Members []string `json:"members"`
+	// Name is the name the group document is published under, CID the
+	// version of it last seen.
+	Name string `json:"name"`
+	CID  string `json:"cid"`
+	// KeyID is the key files are encrypted with, Keys how many the group
+	// had.
+	KeyID   string    `json:"key_id"`
+	Keys    int       `json:"keys"`
+	Updated time.Time `json:"updated"`
+}
+
+// ResolvePathRequest names a file or directory by CID, by a prefix of a
+// stored one's CID that no other stored file or directory shares, or by a
+// path below either.
+type ResolvePathRequest struct {
+	Path string `json:"path"`
+}
+
+type ResolvePathResponse struct {
+	CID string `json:"cid"`
+}
+
+// AbbreviateRequest asks for the shortest prefixes of CIDs that
+// ResolvePath resolves back to them.
+type AbbreviateRequest struct {
+	CIDs []string `json:"cids"`
+}
+
+type AbbreviateResponse struct {
+	Short []string `json:"short"`
+}
+
+type ListPinsRequest struct{}
+
+type ListPinsResponse struct {
+	Pins []PinInfo `json:"pins"`
+}
+
+type PinInfo struct {
+	CID     string    `json:"cid"`
+	Created time.Time `json:"created"`
+	// Replicas is the effective replication factor, Copies the number of
+	// copies currently known to be available. Zones is the number of zones
+	// the copies must span, Spanned those they do.
+	Replicas int `json:"replicas"`
+	Copies   int `json:"copies"`
+	Zones    int `json:"zones,omitempty"`
+	Spanned  int `json:"spanned,omitempty"`
+	// Class is the replication class the pin is kept by, set on the pin
+	// or by a rule.
+	Class string `json:"class,omitempty"`
+	// Requested and RequestedClass are the replication factor and class
+	// set on the pin, zero and empty for the configured default. KeptFor
+	// is the peer a replica is kept for, empty for local pins.
+	Requested      int    `json:"requested,omitempty"`
+	RequestedClass string `json:"requested_class,omitempty"`
+	KeptFor        string `json:"kept_for,omitempty"`
+	// Name and Labels were given to the pin by pin add or pin label.
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Archived is when the pin was moved to the cold store, zero for a
+	// pin in the hot store.
+	Archived time.Time `json:"archived,omitzero"`
+}
+
+// VerifyRequest checks the local copy of a file or directory tree. Deep
+// also asks each peer recorded as holding a copy for Samples of its
+// blocks, replication.DefaultSamples when zero.
+type VerifyRequest struct {
+	CID     string `json:"cid"`
+	Deep    bool   `json:"deep,omitempty"`
+	Samples int    `json:"samples,omitempty"`
+}
+
+// VerifyResponse mirrors node.Damage and, for a deep verification,
+// replication.Integrity per holder.
+type VerifyResponse struct {
+	CID      string             `json:"cid"`
+	Blocks   int                `json:"blocks"`
+	Missing  []string           `json:"missing,omitempty"`
+	Corrupt  []string           `json:"corrupt,omitempty"`
+	Replicas []ReplicaIntegrity `json:"replicas,omitempty"`
+}
+
+type ReplicaIntegrity struct {
+	Peer    string `json:"peer"`
+	Sampled int    `json:"sampled"`
+	Valid   int    `json:"valid"`
+	Missing int    `json:"missing,omitempty"`
+	Corrupt int    `json:"corrupt,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type StatsRequest struct{}
+
+type StatsResponse struct {
+	Blocks int64 `json:"blocks"`
+	Bytes  int64 `json:"bytes"`
+	Pins   int   `json:"pins"`
+	Peers  int   `json:"peers"`
+	// Replication counts the pins meeting their replication requirement,
+	// by class.
+	Replication []ClassCompliance `json:"replication,omitempty"`
+}
+
+// ClassCompliance mirrors replication.Compliance. Class is empty for pins
+// kept by a factor rather than a class.
+type ClassCompliance struct {
+	Class           string `json:"class,omitempty"`
+	Pins            int    `json:"pins"`
+	Compliant       int    `json:"compliant"`
+	UnderReplicated int    `json:"under_replicated"`
+	ZonesShort      int    `json:"zones_short"`
+}
+
+type HealthRequest struct{}
+
+// HealthResponse judges the daemon's operation failures against their
+// error budgets. Status is the worst of the subsystems': "green",
+// "yellow" or "red".
+type HealthResponse struct {
+	Status     string            `json:"status"`
+	Subsystems []SubsystemHealth `json:"subsystems"`
+}
+
+// SubsystemHealth mirrors health.Subsystem. Short covers the last five
+// minutes, Long the last hour; BudgetLeft is the share of the hour's
+// error budget not spent yet.
+type SubsystemHealth struct {
+	Name       string       `json:"name"`
+	Status     string       `json:"status"`
+	Objective  float64      `json:"objective"`
+	Short      HealthWindow `json:"short"`
+	Long       HealthWindow `json:"long"`
+	BudgetLeft float64      `json:"budget_left"`
+}
+
+type HealthWindow struct {
+	Duration   time.Duration `json:"duration"`
+	Ops        int64         `json:"ops"`
+	Failures   int64         `json:"failures"`
+	ErrorRatio float64       `json:"error_ratio"`
+	BurnRate   float64       `json:"burn_rate"`
+}
+
+type BandwidthRequest struct{}
+
+// BandwidthResponse is the data exchanged with peers since the daemon
+// started. Peers and Protocols are sorted by bytes exchanged, most first;
+// peers idle for an hour are left out.
+type BandwidthResponse struct {
+	Total     Traffic           `json:"total"`
+	Protocols []ProtocolTraffic `json:"protocols"`
+	Peers     []PeerTraffic     `json:"peers"`
+}
+
+// Traffic is bytes exchanged and the current rates in bytes per second.
+type Traffic struct {
+	TotalIn  int64   `json:"total_in"`
+	TotalOut int64   `json:"total_out"`
+	RateIn   float64 `json:"rate_in"`
+	RateOut  float64 `json:"rate_out"`
+}
+
+type ProtocolTraffic struct {
+	Protocol string `json:"protocol"`
+	Traffic
+}
+
+type PeerTraffic struct {
+	Peer string `json:"peer"`
+	Traffic
+}
+
+type GCRequest struct {
+	// GracePeriod overrides the daemon's grace period when positive.
+	GracePeriod time.Duration `json:"grace_period,omitempty"`
+	DryRun      bool          `json:"dry_run,omitempty"`
+	// Unpinned are the hashes of pins a dry run treats as removed, see
+	// gc.Options.
+	Unpinned []string `json:"unpinned,omitempty"`
+}
+
+// GCResponse mirrors gc.Report.
+type GCResponse struct {
+	Pins         int           `json:"pins"`
+	Reachable    int           `json:"reachable"`
+	Scanned      int           `json:"scanned"`
+	Removed      int           `json:"removed"`
+	RemovedBytes int64         `json:"removed_bytes"`
+	Recent       int           `json:"recent"`
+	Linked       int           `json:"linked"`
+	Freed        int           `json:"freed,omitempty"`
+	FreedBytes   int64         `json:"freed_bytes,omitempty"`
+	GracePeriod  time.Duration `json:"grace_period"`
+	Duration     time.Duration `json:"duration"`
+}
+
+// Origins PurgeRequest removes.
+const (
+	PurgeCache = "cache"
+	PurgePeer  = "peer"
+)
+
+// PurgeRequest removes the stored content of an origin: the blocks no pin
+// uses for PurgeCache, and for PurgePeer the replicas kept for Peer too.
+type PurgeRequest struct {
+	Origin string `json:"origin"`
+	Peer   string `json:"peer,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// PurgeResponse is what a purge did, or would do for a dry run.
+type PurgeResponse struct {
+	// Unpinned are the replicas unpinned.
+	Unpinned     []string `json:"unpinned,omitempty"`
+	Removed      int      `json:"removed"`
+	RemovedBytes int64    `json:"removed_bytes"`
+	// Linked counts blocks kept because pins of other origins use them,
+	// Recent those kept for files still being pinned.
+	Linked int `json:"linked"`
+	Recent int `json:"recent"`
+}
+
+type InventoryRequest struct{}
+
+// InventoryResponse mirrors gc.Inventory. Replicas are sorted by peer.
+type InventoryResponse struct {
+	Own      Origin          `json:"own"`
+	Replicas []ReplicaOrigin `json:"replicas"`
+	Pending  Origin          `json:"pending"`
+	Cache    Origin          `json:"cache"`
+}
+
+// Origin mirrors gc.Origin.
+type Origin struct {
+	Pins   int   `json:"pins"`
+	Blocks int   `json:"blocks"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// ReplicaOrigin is what the replicas kept for a peer refer to.
+type ReplicaOrigin struct {
+	Peer string `json:"peer"`
+	Origin
+}
+
+type ListOperationsRequest struct{}
+
+// Operation mirrors ops.Op.
+type Operation struct {
+	ID      uint64    `json:"id"`
+	Kind    string    `json:"kind"`
+	Target  string    `json:"target,omitempty"`
+	Started time.Time `json:"started"`
+	Bytes   int64     `json:"bytes"`
+	// Fetched and Missing are the bytes fetched from peers so far and the
+	// bytes that were missing locally, Peers how many peers are asked.
+	Fetched int64 `json:"fetched,omitempty"`
+	Missing int64 `json:"missing,omitempty"`
+	Peers   int   `json:"peers,omitempty"`
+	// FetchedChunks and MissingChunks are Fetched and Missing counted in
+	// blocks.
+	FetchedChunks int64 `json:"fetched_chunks,omitempty"`
+	MissingChunks int64 `json:"missing_chunks,omitempty"`
+}
+
+// Download mirrors node.Download, an unfinished download.
+type Download struct {
+	CID      string    `json:"cid"`
+	Started  time.Time `json:"started"`
+	Running  bool      `json:"running"`
+	Failures int       `json:"failures,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	RetryAt  time.Time `json:"retry_at,omitzero"`
+}
+
+type ListOperationsResponse struct {
+	Operations []Operation `json:"operations"`
+	Downloads  []Download  `json:"downloads,omitempty"`
+}
+
+// CancelOperationRequest names a running operation by ID, or an
+// interrupted download waiting to be resumed by CID.
+type CancelOperationRequest struct {
+	ID  uint64 `json:"id,omitempty"`
+	CID string `json:"cid,omitempty"`
+}
+
+type CancelOperationResponse struct{}
+
+// HandoffRequest asks the daemon to hand over to a new process listening
+// on Socket.
+type HandoffRequest struct {
+	Socket string `json:"socket"`
+	// Drain is how long to wait for running transfers, DefaultDrainTimeout
+	// when zero.
+	Drain time.Duration `json:"drain,omitempty"`
+}
+
+type HandoffResponse struct {
+	PID int `json:"pid"`
+	// Drained is false when transfers were still running at the deadline.
+	Drained bool `json:"drained"`
+}
+
+type RoutesRequest struct {
+	CID string `json:"cid"`
+}
+
+// RoutesResponse mirrors node.RouteReport. Errors are carried as strings.
+type RoutesResponse struct {
+	CID     string        `json:"cid"`
+	Size    int64         `json:"size"`
+	Total   time.Duration `json:"total"`
+	Lookup  time.Duration `json:"lookup"`
+	Fetch   time.Duration `json:"fetch"`
+	Lookups []RouteLookup `json:"lookups"`
+	Peers   []RoutePeer   `json:"peers"`
+	Chunks  []RouteChunk  `json:"chunks"`
+}
+
+type RouteLookup struct {
+	CID       string        `json:"cid"`
+	Providers []string      `json:"providers"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type RoutePeer struct {
+	ID       string        `json:"id"`
+	RTT      time.Duration `json:"rtt"`
+	RTTError string        `json:"rtt_error,omitempty"`
+	Blocks   int           `json:"blocks"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	Failures int           `json:"failures"`
+	Ranges   []ByteRange   `json:"ranges"`
+}
+
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+type RouteChunk struct {
+	CID      string        `json:"cid"`
+	Offset   int64         `json:"offset"`
+	Size     int64         `json:"size"`
+	Source   string        `json:"source,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Failed   []string      `json:"failed,omitempty"`
+}
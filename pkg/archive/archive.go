@@ -0,0 +1,170 @@
+// Package archive streams a stored directory tree as a tar or zip
+// archive. Entries are written as they are read, so nothing of the tree
+// is kept on disk or in memory on the way.
+//
+// Archives carry no timestamps, owners or permissions beyond fixed
+// defaults: the same tree always gives the same archive.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+)
+
+const (
+	FormatTar = "tar"
+	FormatZip = "zip"
+)
+
+// Entry is a file or directory in a tree.
+type Entry struct {
+	Name string
+	CID  string
+	Dir  bool
+}
+
+// Source reads the tree being archived.
+type Source interface {
+	// List returns the entries of the directory c.
+	List(ctx context.Context, c string) ([]Entry, error)
+	// Open returns the content of the file c and its size.
+	Open(ctx context.Context, c string) (io.Reader, int64, error)
+}
+
+// ValidFormat checks that format names a supported archive format.
+func ValidFormat(format string) error {
+	switch format {
+	case FormatTar, FormatZip:
+		return nil
+	}
+	return fmt.Errorf("archive: unknown format %q, use %s or %s", format, FormatTar, FormatZip)
+}
+
+// Ext returns the file extension of archives in format.
+func Ext(format string) string {
+	return "." + format
+}
+
+// Write streams root, a file or the directory tree under it, to w as an
+// archive in format. Everything is placed under root's name.
+func Write(ctx context.Context, w io.Writer, format string, root Entry, src Source) error {
+	if err := ValidFormat(format); err != nil {
+		return err
+	}
+	if err := manifest.ValidName(root.Name); err != nil {
+		return err
+	}
+
+	var a writer
+	if format == FormatZip {
+		a = &zipWriter{zip.NewWriter(w)}
+	} else {
+		a = &tarWriter{tar.NewWriter(w)}
+	}
+	if err := walk(ctx, a, root, root.Name, src); err != nil {
+		return err
+	}
+	return a.Close()
+}
+
+// walk adds e, found at name in the archive, and everything under it.
+func walk(ctx context.Context, a writer, e Entry, name string, src Source) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !e.Dir {
+		r, size, err := src.Open(ctx, e.CID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := a.File(name, size, r); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := a.Dir(name); err != nil {
+		return err
+	}
+	entries, err := src.List(ctx, e.CID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, sub := range entries {
+		// Don't let a bad name point outside the archive's root
+		if err := manifest.ValidName(sub.Name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := walk(ctx, a, sub, path.Join(name, sub.Name), src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writer adds entries to an archive.
+type writer interface {
+	Dir(name string) error
+	File(name string, size int64, r io.Reader) error
+	Close() error
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+}
+
+func (t *tarWriter) Dir(name string) error {
+	return t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0755, Format: tar.FormatPAX})
+}
+
+func (t *tarWriter) File(name string, size int64, r io.Reader) error {
+	err := t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644, Format: tar.FormatPAX})
+	if err != nil {
+		return err
+	}
+	// The header promised size bytes; tar refuses more and Flush fewer
+	if _, err := io.Copy(t.tw, r); err != nil {
+		return err
+	}
+	return t.tw.Flush()
+}
+
+func (t *tarWriter) Close() error {
+	return t.tw.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) Dir(name string) error {
+	_, err := z.zw.CreateHeader(&zip.FileHeader{Name: name + "/"})
+	return err
+}
+
+func (z *zipWriter) File(name string, size int64, r io.Reader) error {
+	h := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	h.SetMode(0644)
+	fw, err := z.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(fw, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("archive: read %d bytes, expected %d", n, size)
+	}
+	return nil
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
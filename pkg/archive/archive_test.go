@@ -0,0 +1,174 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tree is a Source over directories and files kept in maps.
+type tree struct {
+	dirs  map[string][]Entry
+	files map[string]string
+	// sizes overrides the size reported for a file.
+	sizes map[string]int64
+}
+
+func (t tree) List(_ context.Context, c string) ([]Entry, error) {
+	entries, ok := t.dirs[c]
+	if !ok {
+		return nil, errors.New("not a directory")
+	}
+	return entries, nil
+}
+
+func (t tree) Open(_ context.Context, c string) (io.Reader, int64, error) {
+	data, ok := t.files[c]
+	if !ok {
+		return nil, 0, errors.New("not a file")
+	}
+	size, ok := t.sizes[c]
+	if !ok {
+		size = int64(len(data))
+	}
+	return strings.NewReader(data), size, nil
+}
+
+func testTree() tree {
+	return tree{
+		dirs: map[string][]Entry{
+			"root":  {{Name: "a.txt", CID: "a"}, {Name: "sub", CID: "sub", Dir: true}},
+			"sub":   {{Name: "b.txt", CID: "b"}, {Name: "empty", CID: "empty", Dir: true}},
+			"empty": nil,
+		},
+		files: map[string]string{"a": "first file", "b": strings.Repeat("second ", 1000)},
+	}
+}
+
+// wantTree is what the archive of testTree holds: directories end in /.
+var wantTree = map[string]string{
+	"photos/":           "",
+	"photos/a.txt":      "first file",
+	"photos/sub/":       "",
+	"photos/sub/b.txt":  strings.Repeat("second ", 1000),
+	"photos/sub/empty/": "",
+}
+
+func readTar(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[h.Name] = string(content)
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[f.Name] = string(content)
+	}
+	return got
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		format string
+		read   func(*testing.T, []byte) map[string]string
+	}{
+		{FormatTar, readTar},
+		{FormatZip, readZip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			root := Entry{Name: "photos", CID: "root", Dir: true}
+			var buf bytes.Buffer
+			if err := Write(context.Background(), &buf, tt.format, root, testTree()); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.read(t, buf.Bytes()); !reflect.DeepEqual(got, wantTree) {
+				t.Errorf("archive holds %v, want %v", got, wantTree)
+			}
+
+			// Nothing depends on when the archive is written
+			var again bytes.Buffer
+			if err := Write(context.Background(), &again, tt.format, root, testTree()); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+				t.Error("the same tree archived twice differs")
+			}
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(context.Background(), &buf, FormatTar, Entry{Name: "a.txt", CID: "a"}, testTree()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a.txt": "first file"}
+	if got := readTar(t, buf.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+}
+
+func TestWriteFails(t *testing.T) {
+	escaping := testTree()
+	escaping.dirs["root"] = []Entry{{Name: "..", CID: "a"}}
+	short := testTree()
+	short.sizes = map[string]int64{"a": 100}
+	long := testTree()
+	long.sizes = map[string]int64{"a": 1}
+
+	tests := []struct {
+		name   string
+		format string
+		src    tree
+	}{
+		{"unknown format", "rar", testTree()},
+		{"escaping name", FormatTar, escaping},
+		{"short file tar", FormatTar, short},
+		{"long file tar", FormatTar, long},
+		{"short file zip", FormatZip, short},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := Entry{Name: "photos", CID: "root", Dir: true}
+			if err := Write(context.Background(), io.Discard, tt.format, root, tt.src); err == nil {
+				t.Error("Write succeeded")
+			}
+		})
+	}
+}
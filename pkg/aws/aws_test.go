@@ -0,0 +1,237 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The get-vanilla case of the AWS Signature Version 4 test suite.
+func TestSign(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	Sign(req, creds, "us-east-1", "service", hashHex(nil), time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %s\nwant %s", got, want)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if got := escapePath("/backups/a b+c~.tar"); got != "/backups/a%20b%2Bc~.tar" {
+		t.Errorf("escapePath = %s", got)
+	}
+	if got := escape("a/b", false); got != "a%2Fb" {
+		t.Errorf("escape = %s", got)
+	}
+}
+
+// fakeS3 keeps objects uploaded whole or in parts.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string][][]byte
+	classes map[string]string
+	aborted int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if sum := sha256.Sum256(body); r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) ||
+		!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/") {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>bad</Message></Error>")
+		return
+	}
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.parts[r.URL.Path] = nil
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPut && q.Get("uploadId") == "u1":
+		f.parts[r.URL.Path] = append(f.parts[r.URL.Path], body)
+		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, q.Get("partNumber")))
+	case r.Method == http.MethodPost && q.Get("uploadId") == "u1":
+		if !bytes.Contains(body, []byte(`<PartNumber>2</PartNumber><ETag>&#34;2&#34;</ETag>`)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = bytes.Join(f.parts[r.URL.Path], nil)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult/>")
+	case r.Method == http.MethodDelete:
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[r.URL.Path] = body
+		if f.classes != nil {
+			f.classes[r.URL.Path] = r.Header.Get("X-Amz-Storage-Class")
+		}
+	case r.Method == http.MethodHead:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func TestS3Upload(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), parts: make(map[string][][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s3 := &S3{Creds: Credentials{AccessKey: "AK", SecretKey: "SK"}, Region: "eu-west-1", Endpoint: srv.URL}
+	ctx := context.Background()
+
+	for _, size := range []int{10, PartSize + 10} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		w := s3.Upload(ctx, "bucket", "dir/obj")
+		if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := s3.Get(ctx, "bucket", "dir/obj")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("object of %d bytes read back as %d: %v", size, len(got), err)
+		}
+	}
+
+	_, err := s3.Get(ctx, "bucket", "missing")
+	if err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("Get of a missing object = %v", err)
+	}
+
+	s3.Creds.AccessKey = "other"
+	w := s3.Upload(ctx, "bucket", "denied")
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Close of a rejected upload = %v", err)
+	}
+}
+
+func TestS3Exists(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), classes: make(map[string]string)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s3 := &S3{Creds: Credentials{AccessKey: "AK", SecretKey: "SK"}, Region: "eu-west-1", Endpoint: srv.URL, StorageClass: "STANDARD_IA"}
+	ctx := context.Background()
+
+	if ok, err := s3.Exists(ctx, "bucket", "obj"); err != nil || ok {
+		t.Fatalf("Exists before Put = %v, %v", ok, err)
+	}
+	if err := s3.Put(ctx, "bucket", "obj", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s3.Exists(ctx, "bucket", "obj"); err != nil || !ok {
+		t.Fatalf("Exists after Put = %v, %v", ok, err)
+	}
+	if size, err := s3.Size(ctx, "bucket", "obj"); err != nil || size != 4 {
+		t.Errorf("Size = %d, %v, want 4", size, err)
+	}
+	if got := fake.classes["/bucket/obj"]; got != "STANDARD_IA" {
+		t.Errorf("storage class = %q, want STANDARD_IA", got)
+	}
+	if _, err := s3.Get(ctx, "bucket", "missing"); !IsNotFound(err) {
+		t.Errorf("IsNotFound(%v) = false", err)
+	}
+}
+
+func TestParseS3URL(t *testing.T) {
+	if b, k, err := ParseS3URL("s3://bucket/a/b.dfsbak"); err != nil || b != "bucket" || k != "a/b.dfsbak" {
+		t.Errorf("ParseS3URL = %s, %s, %v", b, k, err)
+	}
+	for _, s := range []string{"s3://bucket", "s3:///key", "https://bucket/key"} {
+		if _, _, err := ParseS3URL(s); err == nil {
+			t.Errorf("ParseS3URL(%s) succeeded", s)
+		}
+	}
+}
+
+// fakeRoute53 holds the zone example.com, keeping the changes sent to it.
+type fakeRoute53 struct {
+	changes []string
+}
+
+func (f *fakeRoute53) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/route53/aws4_request") {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<ErrorResponse><Error><Code>SignatureDoesNotMatch</Code><Message>bad</Message></Error></ErrorResponse>")
+		return
+	}
+	switch {
+	case r.URL.Path == "/2013-04-01/hostedzonesbyname":
+		// Zones are listed in order from the name asked for
+		name := "example.com."
+		if r.URL.Query().Get("dnsname") > "example.com" {
+			name = "example.net."
+		}
+		fmt.Fprintf(w, "<ListHostedZonesByNameResponse><HostedZones><HostedZone><Id>/hostedzone/Z1</Id><Name>%s</Name></HostedZone></HostedZones></ListHostedZonesByNameResponse>", name)
+	case r.Method == http.MethodPost && r.URL.Path == "/2013-04-01/hostedzone/Z1/rrset":
+		f.changes = append(f.changes, string(body))
+		fmt.Fprint(w, "<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id></ChangeInfo></ChangeResourceRecordSetsResponse>")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRoute53SetTXT(t *testing.T) {
+	fake := &fakeRoute53{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	r53 := &Route53{Creds: Credentials{AccessKey: "AK", SecretKey: "SK"}, Endpoint: srv.URL}
+	ctx := context.Background()
+
+	if err := r53.SetTXT(ctx, "_dnslink.www.example.com", "dnslink=/ipns/k51"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.changes) != 1 {
+		t.Fatalf("%d changes sent, want 1", len(fake.changes))
+	}
+	for _, want := range []string{
+		"<Action>UPSERT</Action>",
+		"<Name>_dnslink.www.example.com.</Name><Type>TXT</Type>",
+		"<Value>&#34;dnslink=/ipns/k51&#34;</Value>",
+	} {
+		if !strings.Contains(fake.changes[0], want) {
+			t.Errorf("change %s lacks %s", fake.changes[0], want)
+		}
+	}
+	if err := r53.SetTXT(ctx, "_dnslink.example.org", "dnslink=/ipns/k51"); err == nil {
+		t.Error("set a record outside any hosted zone")
+	}
+}
@@ -0,0 +1,152 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const route53Namespace = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+// Route53 edits DNS records in the hosted zones of the account.
+type Route53 struct {
+	Creds Credentials
+	// Endpoint is the base URL of the service. Empty for AWS itself.
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewRoute53FromEnv configures Route53 from the environment: the
+// credentials and AWS_ENDPOINT_URL_ROUTE_53 or AWS_ENDPOINT_URL.
+func NewRoute53FromEnv() (*Route53, error) {
+	creds, err := CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_ROUTE_53")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	return &Route53{Creds: creds, Endpoint: endpoint}, nil
+}
+
+// do sends a signed request to the API path and decodes the response into
+// out, returning an *Error for any status but 2xx.
+func (r *Route53) do(ctx context.Context, method, path string, query url.Values, body []byte, out any) error {
+	endpoint := r.Endpoint
+	if endpoint == "" {
+		endpoint = "https://route53.amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("aws: endpoint: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/2013-04-01/" + path
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	// Route53 is global, signed for us-east-1 wherever the zone is
+	Sign(req, r.Creds, defaultRegion, "route53", hashHex(body), time.Now())
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 {
+		var e struct {
+			Error Error `xml:"Error"`
+		}
+		xml.Unmarshal(data, &e)
+		e.Error.Status = res.StatusCode
+		return &e.Error
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// HostedZone returns the ID of the hosted zone name is in: the one named
+// after it or after the closest of its parents.
+func (r *Route53) HostedZone(ctx context.Context, name string) (string, error) {
+	name = strings.TrimSuffix(name, ".")
+	for zone := name; zone != ""; {
+		var list struct {
+			Zones []struct {
+				ID   string `xml:"Id"`
+				Name string `xml:"Name"`
+			} `xml:"HostedZones>HostedZone"`
+		}
+		query := url.Values{"dnsname": {zone}, "maxitems": {"1"}}
+		if err := r.do(ctx, http.MethodGet, "hostedzonesbyname", query, nil, &list); err != nil {
+			return "", fmt.Errorf("route53: hosted zone of %s: %w", name, err)
+		}
+		if len(list.Zones) > 0 && strings.EqualFold(strings.TrimSuffix(list.Zones[0].Name, "."), zone) {
+			return strings.TrimPrefix(list.Zones[0].ID, "/hostedzone/"), nil
+		}
+		_, zone, _ = strings.Cut(zone, ".")
+	}
+	return "", fmt.Errorf("route53: no hosted zone holds %s", name)
+}
+
+// SetTXT creates or replaces the TXT record on name with one holding
+// value.
+func (r *Route53) SetTXT(ctx context.Context, name, value string) error {
+	zone, err := r.HostedZone(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	type change struct {
+		Action string `xml:"Action"`
+		Name   string `xml:"ResourceRecordSet>Name"`
+		Type   string `xml:"ResourceRecordSet>Type"`
+		TTL    int    `xml:"ResourceRecordSet>TTL"`
+		Value  string `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ChangeResourceRecordSetsRequest"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Changes []change `xml:"ChangeBatch>Changes>Change"`
+	}{
+		Xmlns: route53Namespace,
+		Changes: []change{{
+			Action: "UPSERT",
+			Name:   strings.TrimSuffix(name, ".") + ".",
+			Type:   "TXT",
+			TTL:    300,
+			// TXT values are quoted strings in the zone file syntax
+			Value: strconv.Quote(value),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	var res struct {
+		ID string `xml:"ChangeInfo>Id"`
+	}
+	if err := r.do(ctx, http.MethodPost, "hostedzone/"+zone+"/rrset", nil, body, &res); err != nil {
+		return fmt.Errorf("route53: setting TXT on %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PartSize is the size of the parts large objects are uploaded in. With
+// S3's limit of 10000 parts, objects can be up to about 80 GiB.
+const PartSize = 8 << 20
+
+const maxParts = 10000
+
+// S3 reads and writes objects.
+type S3 struct {
+	Creds  Credentials
+	Region string
+	// Endpoint is the base URL of the service. Buckets are then addressed
+	// by path, as S3 compatible services expect, rather than by host name.
+	// Empty for AWS itself.
+	Endpoint string
+	// StorageClass is the storage class of the objects written, the
+	// bucket's default when empty.
+	StorageClass string
+	Client       *http.Client
+}
+
+// NewS3FromEnv configures S3 from the environment: the credentials, the
+// region and AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL.
+func NewS3FromEnv() (*S3, error) {
+	creds, err := CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	return &S3{Creds: creds, Region: RegionFromEnv(), Endpoint: endpoint}, nil
+}
+
+// ParseS3URL splits an s3://bucket/key URL.
+func ParseS3URL(s string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	bucket, key, _ = strings.Cut(rest, "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("aws: %q is not an s3://bucket/key URL", s)
+	}
+	return bucket, key, nil
+}
+
+// Error is an error response of the service.
+type Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("aws: %s", http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("aws: %s: %s", e.Code, e.Message)
+}
+
+func (s *S3) objectURL(bucket, key string, query url.Values) (*url.URL, error) {
+	var u *url.URL
+	if s.Endpoint == "" {
+		u = &url.URL{Scheme: "https", Host: bucket + ".s3." + s.Region + ".amazonaws.com", Path: "/" + key}
+	} else {
+		var err error
+		if u, err = url.Parse(s.Endpoint); err != nil {
+			return nil, fmt.Errorf("aws: endpoint: %w", err)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = query.Encode()
+	return u, nil
+}
+
+// do sends a signed request with body and returns the response, an
+// *Error for any status but 2xx.
+func (s *S3) do(ctx context.Context, method, bucket, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := s.objectURL(bucket, key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	Sign(req, s.Creds, s.Region, "s3", payloadHash, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+		e := &Error{Status: res.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+		xml.Unmarshal(data, e)
+		return nil, e
+	}
+	return res, nil
+}
+
+// Get returns the content of an object.
+func (s *S3) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	res, err := s.do(ctx, http.MethodGet, bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, err)
+	}
+	return res.Body, nil
+}
+
+// Exists reports whether an object exists.
+func (s *S3) Exists(ctx context.Context, bucket, key string) (bool, error) {
+	_, err := s.Size(ctx, bucket, key)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Size returns the size of an object. A missing one is an error
+// IsNotFound reports.
+func (s *S3) Size(ctx context.Context, bucket, key string) (int64, error) {
+	res, err := s.do(ctx, http.MethodHead, bucket, key, nil, nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("s3://%s/%s: %w", bucket, key, err)
+	}
+	return res.ContentLength, res.Body.Close()
+}
+
+// IsNotFound reports whether err is the service's answer for a missing
+// object.
+func IsNotFound(err error) bool {
+	e := (*Error)(nil)
+	return errors.As(err, &e) && (e.Status == http.StatusNotFound || e.Code == "NoSuchKey")
+}
+
+// Put writes an object in one request.
+func (s *S3) Put(ctx context.Context, bucket, key string, data []byte) error {
+	res, err := s.do(ctx, http.MethodPut, bucket, key, nil, s.objectHeader(), data)
+	if err != nil {
+		return fmt.Errorf("s3://%s/%s: %w", bucket, key, err)
+	}
+	return res.Body.Close()
+}
+
+// objectHeader is set on the requests creating objects.
+func (s *S3) objectHeader() http.Header {
+	if s.StorageClass == "" {
+		return nil
+	}
+	return http.Header{"X-Amz-Storage-Class": {s.StorageClass}}
+}
+
+// Upload returns a writer storing what is written to it as an object,
+// sent in parts of PartSize as it is written. The object only appears
+// once the writer is closed. An upload that fails is aborted, its parts
+// dropped; call Abort to give one up.
+func (s *S3) Upload(ctx context.Context, bucket, key string) *Upload {
+	return &Upload{s3: s, ctx: ctx, bucket: bucket, key: key}
+}
+
+// Upload writes an object, see S3.Upload.
+type Upload struct {
+	s3          *S3
+	ctx         context.Context
+	bucket, key string
+
+	buf []byte
+	// id is the multipart upload started once a part is full.
+	id    string
+	parts []completedPart
+	err   error
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (u *Upload) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		k := min(len(p), PartSize-len(u.buf))
+		u.buf = append(u.buf, p[:k]...)
+		p = p[k:]
+		if len(u.buf) == PartSize {
+			if u.err = u.flush(); u.err != nil {
+				u.abort()
+				return 0, u.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush uploads the buffer as the next part.
+func (u *Upload) flush() error {
+	if u.id == "" {
+		res, err := u.s3.do(u.ctx, http.MethodPost, u.bucket, u.key, url.Values{"uploads": {""}}, u.s3.objectHeader(), nil)
+		if err != nil {
+			return u.wrap(err)
+		}
+		defer res.Body.Close()
+		var created struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := xml.NewDecoder(res.Body).Decode(&created); err != nil || created.UploadID == "" {
+			return u.wrap(fmt.Errorf("no upload ID in response: %v", err))
+		}
+		u.id = created.UploadID
+	}
+	if len(u.parts) == maxParts {
+		return u.wrap(fmt.Errorf("object exceeds %d parts", maxParts))
+	}
+
+	number := len(u.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {u.id}}
+	res, err := u.s3.do(u.ctx, http.MethodPut, u.bucket, u.key, query, nil, u.buf)
+	if err != nil {
+		return u.wrap(err)
+	}
+	res.Body.Close()
+	u.parts = append(u.parts, completedPart{PartNumber: number, ETag: res.Header.Get("ETag")})
+	u.buf = u.buf[:0]
+	return nil
+}
+
+func (u *Upload) Close() error {
+	if u.err != nil {
+		return u.err
+	}
+	u.err = errors.New("aws: upload closed")
+	if u.id == "" {
+		return u.s3.Put(u.ctx, u.bucket, u.key, u.buf)
+	}
+	if len(u.buf) > 0 {
+		if err := u.flush(); err != nil {
+			u.abort()
+			return err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: u.parts})
+	if err != nil {
+		return err
+	}
+	res, err := u.s3.do(u.ctx, http.MethodPost, u.bucket, u.key, url.Values{"uploadId": {u.id}}, nil, body)
+	if err != nil {
+		u.abort()
+		return u.wrap(err)
+	}
+	defer res.Body.Close()
+	// A failure after the 200 is sent comes in the body
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return u.wrap(err)
+	}
+	if e := (&Error{Status: res.StatusCode}); xml.Unmarshal(data, e) == nil && e.Code != "" {
+		u.abort()
+		return u.wrap(e)
+	}
+	return nil
+}
+
+// Abort gives up an upload not closed yet.
+func (u *Upload) Abort() {
+	if u.err == nil {
+		u.err = errors.New("aws: upload aborted")
+	}
+	u.abort()
+}
+
+// abort drops the parts uploaded, which S3 would otherwise keep and bill.
+func (u *Upload) abort() {
+	if u.id == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(u.ctx), time.Minute)
+	defer cancel()
+	if res, err := u.s3.do(ctx, http.MethodDelete, u.bucket, u.key, url.Values{"uploadId": {u.id}}, nil, nil); err == nil {
+		res.Body.Close()
+	}
+	u.id = ""
+}
+
+func (u *Upload) wrap(err error) error {
+	return fmt.Errorf("s3://%s/%s: %w", u.bucket, u.key, err)
+}
@@ -0,0 +1,175 @@
+// Package aws talks to the few AWS services DFS uses, S3 for backups and
+// archived pins and Route53 for the DNS records of published sites, over
+// plain HTTP requests signed with Signature Version 4, so it needs none of
+// the AWS SDK. Anything speaking the same API, such as MinIO, can
+// stand in for them through AWS_ENDPOINT_URL.
+package aws
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Environment variables credentials and settings are read from, as the
+// AWS command line tools read them.
+const (
+	accessKeyEnv    = "AWS_ACCESS_KEY_ID"
+	secretKeyEnv    = "AWS_SECRET_ACCESS_KEY"
+	sessionTokenEnv = "AWS_SESSION_TOKEN"
+	regionEnv       = "AWS_REGION"
+	defaultRegion   = "us-east-1"
+)
+
+const (
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+	algorithm  = "AWS4-HMAC-SHA256"
+)
+
+// ErrNoCredentials is returned when the environment holds no credentials.
+var ErrNoCredentials = errors.New("aws: no credentials; set " + accessKeyEnv + " and " + secretKeyEnv)
+
+// Credentials sign requests.
+type Credentials struct {
+	AccessKey string
+	SecretKey string
+	// SessionToken is set for temporary credentials.
+	SessionToken string
+}
+
+// CredentialsFromEnv reads the credentials in the environment.
+func CredentialsFromEnv() (Credentials, error) {
+	c := Credentials{
+		AccessKey:    os.Getenv(accessKeyEnv),
+		SecretKey:    os.Getenv(secretKeyEnv),
+		SessionToken: os.Getenv(sessionTokenEnv),
+	}
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return c, nil
+}
+
+// RegionFromEnv returns the configured region, us-east-1 when none is.
+func RegionFromEnv() string {
+	for _, env := range []string{regionEnv, "AWS_DEFAULT_REGION"} {
+		if r := os.Getenv(env); r != "" {
+			return r
+		}
+	}
+	return defaultRegion
+}
+
+// Sign signs req for service in region at t. payloadHash is the hex
+// SHA-256 of the body, or UNSIGNED-PAYLOAD where the service allows it.
+// Every header set on req so far is signed; req.URL.RawPath, when set,
+// must be the path as escapePath escapes it.
+func Sign(req *http.Request, creds Credentials, region, service, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(timeFormat))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(trimAll(v), ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := t.Format(dateFormat) + "/" + region + "/" + service + "/aws4_request"
+	toSign := algorithm + "\n" + t.Format(timeFormat) + "\n" + scope + "\n" + hashHex([]byte(canonical))
+
+	key := hmacSum([]byte("AWS4"+creds.SecretKey), t.Format(dateFormat))
+	for _, s := range []string{region, service, "aws4_request"} {
+		key = hmacSum(key, s)
+	}
+	signature := hex.EncodeToString(hmacSum(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, creds.AccessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(req *http.Request) string {
+	var pairs []string
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			pairs = append(pairs, escape(k, false)+"="+escape(v, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func trimAll(vs []string) []string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return out
+}
+
+// escapePath escapes a path as signatures take it: every byte but the
+// unreserved characters of RFC 3986 and the slashes.
+func escapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return escape(p, true)
+}
+
+func escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSum(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
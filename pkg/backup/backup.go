@@ -0,0 +1,350 @@
+// Package backup writes a repo's pins, names and the blocks they need to
+// encrypted archives, and restores a repo from them, for recovering from
+// the loss of a node without relying on peers.
+//
+// Archives come in series: the first is a full backup, every later one a
+// delta holding only the blocks the series doesn't hold yet, along with
+// the pin set and names as they are then. A repo is restored from the
+// full archive and the deltas after it, in order. The node exporting
+// keeps a Catalog of what its series holds to write the next delta.
+//
+// An archive is a header in the clear, naming the series and the
+// archive's place in it, followed by a tar stream sealed in segments
+// with XChaCha20-Poly1305, under a key derived from a passphrase with
+// scrypt and a salt of the archive's own.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+// Version is the version of the archive format.
+const Version = 1
+
+// magic starts every archive.
+const magic = "dfs-backup\n"
+
+// maxHeaderSize bounds the header read before anything is checked.
+const maxHeaderSize = 64 << 10
+
+// Names of the entries of the tar stream. Blocks are stored under
+// blocksDir by CID, name records and keys under namesDir.
+const (
+	pinsEntry = "pins.json"
+	blocksDir = "blocks/"
+	namesDir  = "names/"
+)
+
+// Header describes an archive. It is readable without the passphrase.
+type Header struct {
+	Version int `json:"version"`
+	// Series identifies the series the archive belongs to, Seq its place
+	// in it: zero for the full backup, counting up for the deltas.
+	Series  string    `json:"series"`
+	Seq     int       `json:"seq"`
+	Created time.Time `json:"created"`
+	KDF     kdfParams `json:"kdf"`
+}
+
+// Catalog is what the archives of a series written so far hold.
+type Catalog struct {
+	Series string `json:"series"`
+	// Seq is that of the last archive written.
+	Seq    int      `json:"seq"`
+	Blocks []string `json:"blocks"`
+}
+
+// LoadCatalog reads the catalog at path, nil when there is none.
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("backup catalog %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the catalog to path.
+func (c *Catalog) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(path, data, 0600)
+}
+
+type ExportOptions struct {
+	Store storage.Blockstore
+	// Pins are backed up along with the blocks of what they pin.
+	Pins []pin.Pin
+	// NamesDir holds the named keys and published name records to back
+	// up. Optional.
+	NamesDir   string
+	Passphrase []byte
+	// Base is the catalog of the series to write a delta of. A nil Base
+	// starts a new series with a full backup.
+	Base *Catalog
+}
+
+// ExportReport describes an archive written.
+type ExportReport struct {
+	Series string
+	Seq    int
+	Pins   int
+	Names  int
+	// Blocks and Bytes count the blocks written, Unchanged those left
+	// out as earlier archives of the series hold them.
+	Blocks    int
+	Bytes     int64
+	Unchanged int
+	// Missing lists the blocks of pinned content the store doesn't hold,
+	// which the archive goes without.
+	Missing []cid.Cid
+}
+
+// Export writes an archive to w. It returns the catalog of the series
+// with the archive added, to be saved once w is known to hold it.
+func Export(ctx context.Context, w io.Writer, opts ExportOptions) (*Catalog, *ExportReport, error) {
+	h := Header{Version: Version, Created: time.Now().UTC()}
+	catalog := &Catalog{}
+	held := make(map[string]bool)
+	if opts.Base != nil {
+		h.Series, h.Seq = opts.Base.Series, opts.Base.Seq+1
+		catalog.Blocks = append(catalog.Blocks, opts.Base.Blocks...)
+		for _, b := range opts.Base.Blocks {
+			held[b] = true
+		}
+	} else {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, nil, err
+		}
+		h.Series = hex.EncodeToString(id)
+	}
+	catalog.Series, catalog.Seq = h.Series, h.Seq
+	report := &ExportReport{Series: h.Series, Seq: h.Seq, Pins: len(opts.Pins)}
+
+	tw, seal, err := newArchive(w, opts.Passphrase, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	pins, err := json.Marshal(opts.Pins)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeEntry(tw, pinsEntry, pins); err != nil {
+		return nil, nil, err
+	}
+	if opts.NamesDir != "" {
+		if report.Names, err = exportNames(tw, opts.NamesDir); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	seen := make(map[cid.Cid]bool)
+	for _, p := range opts.Pins {
+		queue := []cid.Cid{p.CID}
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+
+			// Links are followed below blocks the series holds too, as
+			// their own links may have been missing then.
+			data, err := opts.Store.Get(ctx, c)
+			if errors.Is(err, storage.ErrNotFound) {
+				report.Missing = append(report.Missing, c)
+				continue
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", c, err)
+			}
+			if err := storage.Verify(c, data); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", c, err)
+			}
+			links, err := manifest.DecodeLinks(c, data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", c, err)
+			}
+			queue = append(queue, links...)
+
+			if held[c.String()] {
+				report.Unchanged++
+				continue
+			}
+			if err := writeEntry(tw, blocksDir+c.String(), data); err != nil {
+				return nil, nil, err
+			}
+			catalog.Blocks = append(catalog.Blocks, c.String())
+			report.Blocks++
+			report.Bytes += int64(len(data))
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := seal.Close(); err != nil {
+		return nil, nil, err
+	}
+	return catalog, report, nil
+}
+
+// exportNames writes the files of dir, leaving out temp files.
+func exportNames(tw *tar.Writer, dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.Contains(e.Name(), ".tmp-") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return 0, err
+		}
+		if err := writeEntry(tw, namesDir+e.Name(), data); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// newArchive writes the header of an archive to w and returns the tar
+// writer its entries go to. Closing the tar writer, then the seal, ends
+// the archive.
+func newArchive(w io.Writer, passphrase []byte, h Header) (*tar.Writer, *sealWriter, error) {
+	h.KDF = kdfParams{N: kdfN, R: kdfR, P: kdfP, Salt: make([]byte, saltLen)}
+	if _, err := rand.Read(h.KDF.Salt); err != nil {
+		return nil, nil, err
+	}
+	aead, err := deriveAEAD(passphrase, h.KDF)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := json.Marshal(h)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := io.WriteString(w, magic); err != nil {
+		return nil, nil, err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(header))); err != nil {
+		return nil, nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, nil, err
+	}
+	seal := &sealWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, segmentSize)}
+	return tar.NewWriter(seal), seal, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ReadHeader reads the header of the archive r.
+func ReadHeader(r io.Reader) (Header, error) {
+	h, _, err := readHeader(r)
+	return h, err
+}
+
+// readHeader reads the header of the archive r, leaving r at its content,
+// and returns it as read too, for openArchive.
+func readHeader(r io.Reader) (Header, []byte, error) {
+	var h Header
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, m); err != nil || string(m) != magic {
+		return h, nil, errors.New("backup: not a backup archive")
+	}
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return h, nil, truncated(err)
+	}
+	if length > maxHeaderSize {
+		return h, nil, fmt.Errorf("backup: header of %d bytes, archive damaged", length)
+	}
+	raw := make([]byte, length)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return h, nil, truncated(err)
+	}
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return h, nil, fmt.Errorf("backup: header: %w", err)
+	}
+	if h.Version != Version {
+		return h, nil, fmt.Errorf("backup: archive version %d, want %d", h.Version, Version)
+	}
+	return h, raw, nil
+}
+
+// openArchive returns the tar stream of the archive r, whose header h
+// was read as raw.
+func openArchive(r io.Reader, passphrase []byte, h Header, raw []byte) (*tar.Reader, *openReader, error) {
+	aead, err := deriveAEAD(passphrase, h.KDF)
+	if err != nil {
+		return nil, nil, fmt.Errorf("backup: %w", err)
+	}
+	open := &openReader{r: bufio.NewReader(r), aead: aead, header: raw}
+	return tar.NewReader(open), open, nil
+}
+
+// readEntry reads the content of the current entry, bounded by max.
+func readEntry(tr *tar.Reader, hdr *tar.Header, max int64) ([]byte, error) {
+	if hdr.Size > max {
+		return nil, fmt.Errorf("backup: entry %s of %d bytes, archive damaged", hdr.Name, hdr.Size)
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(hdr.Size))
+	if _, err := io.Copy(&buf, tr); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
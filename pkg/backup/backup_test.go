@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+var passphrase = []byte("correct horse")
+
+// addFile adds data to store and returns the file's CID.
+func addFile(t *testing.T, store storage.Blockstore, data string) cid.Cid {
+	t.Helper()
+	n := node.NewNode(node.NodeOpts{Store: store, Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 16}})
+	res, err := n.Add(context.Background(), strings.NewReader(data), node.AddOptions{Name: "f.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.CID
+}
+
+func export(t *testing.T, opts ExportOptions) ([]byte, *Catalog, *ExportReport) {
+	t.Helper()
+	opts.Passphrase = passphrase
+	var buf bytes.Buffer
+	catalog, report, err := Export(context.Background(), &buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), catalog, report
+}
+
+func TestExportRestore(t *testing.T) {
+	ctx := context.Background()
+	src := storage.NewMemBlockstore()
+	names := t.TempDir()
+	if err := os.WriteFile(filepath.Join(names, "blog.record"), []byte("record"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(names, "blog.record.tmp-123"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	first := addFile(t, src, strings.Repeat("first file, ", 10))
+	pins := []pin.Pin{{CID: first, Name: "first", Labels: map[string]string{"k": "v"}}}
+	full, catalog, report := export(t, ExportOptions{Store: src, Pins: pins, NamesDir: names})
+	if report.Seq != 0 || report.Blocks == 0 || report.Names != 1 || len(report.Missing) != 0 {
+		t.Fatalf("full backup report = %+v", report)
+	}
+
+	second := addFile(t, src, strings.Repeat("first file, ", 10)+"and more")
+	pins = append(pins, pin.Pin{CID: second, Replicas: 3})
+	delta, catalog2, report := export(t, ExportOptions{Store: src, Pins: pins, NamesDir: names, Base: catalog})
+	if report.Seq != 1 || report.Series != catalog.Series || report.Unchanged == 0 {
+		t.Fatalf("delta report = %+v", report)
+	}
+	if len(catalog2.Blocks) != len(catalog.Blocks)+report.Blocks {
+		t.Errorf("catalog holds %d blocks, want %d", len(catalog2.Blocks), len(catalog.Blocks)+report.Blocks)
+	}
+	if len(delta) >= len(full) {
+		t.Errorf("delta of %d bytes is no smaller than the full backup of %d", len(delta), len(full))
+	}
+
+	dst := storage.NewMemBlockstore()
+	set, err := pin.Open(filepath.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredNames := filepath.Join(t.TempDir(), "names")
+	r := NewRestorer(RestoreOptions{Store: dst, Pins: set, NamesDir: restoredNames, Passphrase: passphrase})
+	for _, archive := range [][]byte{full, delta} {
+		if _, err := r.Apply(ctx, bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := r.Finish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Archives != 2 || res.Pins != 2 || res.Names != 1 || len(res.Incomplete) != 0 {
+		t.Errorf("restore report = %+v", res)
+	}
+	if dst.Stats() != src.Stats() {
+		t.Errorf("restored store holds %+v, want %+v", dst.Stats(), src.Stats())
+	}
+	if p, ok := set.Get(first); !ok || p.Name != "first" || p.Labels["k"] != "v" {
+		t.Errorf("restored pin = %+v", p)
+	}
+	if p, ok := set.Get(second); !ok || p.Replicas != 3 {
+		t.Errorf("restored pin = %+v", p)
+	}
+	if data, err := os.ReadFile(filepath.Join(restoredNames, "blog.record")); err != nil || string(data) != "record" {
+		t.Errorf("restored name record = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(restoredNames, "blog.record.tmp-123")); err == nil {
+		t.Error("temp file was backed up")
+	}
+}
+
+func TestExportMissing(t *testing.T) {
+	src := storage.NewMemBlockstore()
+	c := addFile(t, src, strings.Repeat("data ", 20))
+	links, err := storage.NewRawBlock([]byte("data data data d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Delete(context.Background(), links.CID); err != nil {
+		t.Fatal(err)
+	}
+	archive, _, report := export(t, ExportOptions{Store: src, Pins: []pin.Pin{{CID: c}}})
+	if !slices.Equal(report.Missing, []cid.Cid{links.CID}) {
+		t.Fatalf("missing = %v, want %s", report.Missing, links.CID)
+	}
+
+	set, err := pin.Open(filepath.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRestorer(RestoreOptions{Store: storage.NewMemBlockstore(), Pins: set, Passphrase: passphrase})
+	if _, err := r.Apply(context.Background(), bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := r.Finish(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Incomplete, []cid.Cid{c}) {
+		t.Errorf("incomplete = %v, want %s", res.Incomplete, c)
+	}
+}
+
+func TestRestoreRejects(t *testing.T) {
+	src := storage.NewMemBlockstore()
+	pins := []pin.Pin{{CID: addFile(t, src, strings.Repeat("x", 100000))}}
+	full, catalog, _ := export(t, ExportOptions{Store: src, Pins: pins})
+	delta, _, _ := export(t, ExportOptions{Store: src, Pins: pins, Base: catalog})
+	other, _, _ := export(t, ExportOptions{Store: src, Pins: pins})
+
+	tampered := slices.Clone(full)
+	tampered[len(tampered)-5] ^= 1
+
+	for _, tc := range []struct {
+		name       string
+		archives   [][]byte
+		passphrase string
+		want       string
+	}{
+		{"wrong passphrase", [][]byte{full}, "wrong", ErrWrongPassphrase.Error()},
+		{"tampered", [][]byte{tampered}, string(passphrase), ErrWrongPassphrase.Error()},
+		{"truncated", [][]byte{full[:len(full)-100]}, string(passphrase), "truncated"},
+		{"delta first", [][]byte{delta}, string(passphrase), "start with the full backup"},
+		{"other series", [][]byte{full, other}, string(passphrase), "series"},
+		{"delta twice", [][]byte{full, delta, delta}, string(passphrase), "want 2"},
+		{"not an archive", [][]byte{[]byte("hello")}, string(passphrase), "not a backup archive"},
+	} {
+		set, err := pin.Open(filepath.Join(t.TempDir(), "pins.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewRestorer(RestoreOptions{Store: storage.NewMemBlockstore(), Pins: set, Passphrase: []byte(tc.passphrase)})
+		for _, archive := range tc.archives {
+			if _, err = r.Apply(context.Background(), bytes.NewReader(archive)); err != nil {
+				break
+			}
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want an error mentioning %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backups", "nightly.json")
+	if c, err := LoadCatalog(path); c != nil || err != nil {
+		t.Fatalf("LoadCatalog of a new series = %v, %v", c, err)
+	}
+	want := &Catalog{Series: "abc", Seq: 2, Blocks: []string{"b1", "b2"}}
+	if err := want.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadCatalog(path)
+	if err != nil || got.Series != want.Series || got.Seq != want.Seq || !slices.Equal(got.Blocks, want.Blocks) {
+		t.Errorf("LoadCatalog = %+v, %v", got, err)
+	}
+	if _, err := ReadHeader(strings.NewReader("dfs-backup\n")); err == nil || errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("ReadHeader of a cut header = %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+// maxEntrySize bounds the pin set and name entries read into memory.
+const maxEntrySize = 256 << 20
+
+type RestoreOptions struct {
+	Store storage.Blockstore
+	Pins  *pin.Set
+	// NamesDir receives the named keys and name records. Optional.
+	NamesDir   string
+	Passphrase []byte
+}
+
+// RestoreReport describes a restore.
+type RestoreReport struct {
+	Series   string
+	Archives int
+	Blocks   int
+	Bytes    int64
+	Pins     int
+	// Names counts the name files restored, NamesKept those left as the
+	// repo already had them.
+	Names     int
+	NamesKept int
+	// Incomplete lists the pins whose content the archives don't hold
+	// all of.
+	Incomplete []cid.Cid
+}
+
+// Restorer restores a repo from the archives of a series, given in order
+// to Apply.
+type Restorer struct {
+	opts   RestoreOptions
+	report RestoreReport
+	seq    int
+
+	// The pin set and names of the last archive applied, restored by
+	// Finish.
+	pins  []pin.Pin
+	names map[string][]byte
+}
+
+func NewRestorer(opts RestoreOptions) *Restorer {
+	return &Restorer{opts: opts}
+}
+
+// Apply stores the blocks of the archive r, which must follow the one
+// applied before it in its series, the full backup first.
+func (r *Restorer) Apply(ctx context.Context, rd io.Reader) (Header, error) {
+	h, raw, err := readHeader(rd)
+	if err != nil {
+		return h, err
+	}
+	switch {
+	case r.report.Archives == 0 && h.Seq != 0:
+		return h, fmt.Errorf("backup: archive %d of series %s is a delta; start with the full backup", h.Seq, h.Series)
+	case r.report.Archives > 0 && h.Series != r.report.Series:
+		return h, fmt.Errorf("backup: archive of series %s, want %s", h.Series, r.report.Series)
+	case r.report.Archives > 0 && h.Seq != r.seq+1:
+		return h, fmt.Errorf("backup: archive %d of series %s, want %d", h.Seq, h.Series, r.seq+1)
+	}
+
+	tr, open, err := openArchive(rd, r.opts.Passphrase, h, raw)
+	if err != nil {
+		return h, err
+	}
+	var (
+		pins  []pin.Pin
+		names = make(map[string][]byte)
+	)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return h, err
+		}
+		if err := ctx.Err(); err != nil {
+			return h, err
+		}
+		switch name := hdr.Name; {
+		case name == pinsEntry:
+			data, err := readEntry(tr, hdr, maxEntrySize)
+			if err != nil {
+				return h, err
+			}
+			if err := json.Unmarshal(data, &pins); err != nil {
+				return h, fmt.Errorf("backup: pin set: %w", err)
+			}
+		case strings.HasPrefix(name, namesDir):
+			base := strings.TrimPrefix(name, namesDir)
+			if base == "" || base != filepath.Base(base) || strings.HasPrefix(base, ".") {
+				return h, fmt.Errorf("backup: bad name entry %q", name)
+			}
+			if names[base], err = readEntry(tr, hdr, maxEntrySize); err != nil {
+				return h, err
+			}
+		case strings.HasPrefix(name, blocksDir):
+			if err := r.putBlock(ctx, tr, hdr); err != nil {
+				return h, err
+			}
+		default:
+			return h, fmt.Errorf("backup: unknown entry %q", name)
+		}
+	}
+	// The tar stream ends before the last segment may have been read
+	if _, err := io.Copy(io.Discard, open); err != nil {
+		return h, err
+	}
+	if !open.done {
+		return h, errors.New("backup: archive is truncated")
+	}
+
+	r.report.Series, r.seq = h.Series, h.Seq
+	r.report.Archives++
+	r.pins, r.names = pins, names
+	return h, nil
+}
+
+func (r *Restorer) putBlock(ctx context.Context, tr *tar.Reader, hdr *tar.Header) error {
+	c, err := cid.Decode(strings.TrimPrefix(hdr.Name, blocksDir))
+	if err != nil {
+		return fmt.Errorf("backup: bad block entry %q", hdr.Name)
+	}
+	data, err := readEntry(tr, hdr, storage.MaxBlockSize)
+	if err != nil {
+		return err
+	}
+	if err := storage.Verify(c, data); err != nil {
+		return fmt.Errorf("backup: block %s: %w", c, err)
+	}
+	if err := r.opts.Store.Put(ctx, c, data); err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	r.report.Blocks++
+	r.report.Bytes += int64(len(data))
+	return nil
+}
+
+// Finish restores the pin set and names of the last archive applied and
+// checks the content of every pin is whole.
+func (r *Restorer) Finish(ctx context.Context) (*RestoreReport, error) {
+	if r.report.Archives == 0 {
+		return nil, errors.New("backup: no archive restored")
+	}
+	for _, p := range r.pins {
+		opts := pin.AddOptions{Replicas: p.Replicas, Class: p.Class, Name: p.Name, Labels: p.Labels}
+		if err := r.opts.Pins.AddWith(p.CID, opts); err != nil {
+			return nil, fmt.Errorf("pin %s: %w", p.CID, err)
+		}
+		if p.KeptFor != "" {
+			if err := r.opts.Pins.SetKeptFor(p.CID, p.KeptFor, p.Size); err != nil {
+				return nil, fmt.Errorf("pin %s: %w", p.CID, err)
+			}
+		}
+		r.report.Pins++
+
+		whole, err := r.whole(ctx, p.CID)
+		if err != nil {
+			return nil, err
+		}
+		if !whole {
+			r.report.Incomplete = append(r.report.Incomplete, p.CID)
+		}
+	}
+
+	if r.opts.NamesDir != "" && len(r.names) > 0 {
+		if err := os.MkdirAll(r.opts.NamesDir, 0700); err != nil {
+			return nil, err
+		}
+		for name, data := range r.names {
+			path := filepath.Join(r.opts.NamesDir, name)
+			if _, err := os.Stat(path); err == nil {
+				r.report.NamesKept++
+				continue
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			if err := fsutil.AtomicWrite(path, data, 0600); err != nil {
+				return nil, err
+			}
+			r.report.Names++
+		}
+	}
+	return &r.report, nil
+}
+
+// whole reports whether the store holds every block below c.
+func (r *Restorer) whole(ctx context.Context, c cid.Cid) (bool, error) {
+	queue := []cid.Cid{c}
+	seen := make(map[cid.Cid]bool)
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		links, err := manifest.Links(ctx, r.opts.Store, c)
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", c, err)
+		}
+		if len(links) == 0 {
+			// Links doesn't read leaves
+			if ok, err := r.opts.Store.Has(ctx, c); err != nil || !ok {
+				return false, err
+			}
+		}
+		queue = append(queue, links...)
+	}
+	return true, nil
+}
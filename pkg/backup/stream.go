@@ -0,0 +1,181 @@
+package backup
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters for new archives, as for keystores.
+const (
+	kdfN    = 1 << 15
+	kdfR    = 8
+	kdfP    = 1
+	saltLen = 16
+)
+
+// Bounds on the scrypt parameters an archive may ask for, checked before
+// deriving anything, so a tampered header can't make reading it take
+// gigabytes of memory.
+const (
+	maxKDFN      = 1 << 20
+	maxKDFR      = 16
+	maxKDFP      = 16
+	maxKDFMemory = 1 << 30
+)
+
+// segmentSize is how much content each sealed segment holds. The last
+// one of an archive holds less, and is flagged as last.
+const segmentSize = 64 << 10
+
+// lastSegment flags the last segment in its length prefix and its nonce,
+// so an archive cut short at a segment boundary doesn't read as whole.
+const lastSegment = 1 << 31
+
+// ErrWrongPassphrase is returned when an archive can't be decrypted with
+// the passphrase given: it is wrong, or the archive damaged.
+var ErrWrongPassphrase = errors.New("backup: wrong passphrase or damaged archive")
+
+type kdfParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+func (p kdfParams) check() error {
+	switch {
+	case p.N < 2 || p.N > maxKDFN || p.N&(p.N-1) != 0:
+		return fmt.Errorf("scrypt N %d: want a power of two up to %d", p.N, maxKDFN)
+	case p.R < 1 || p.R > maxKDFR:
+		return fmt.Errorf("scrypt r %d: want 1 to %d", p.R, maxKDFR)
+	case p.P < 1 || p.P > maxKDFP:
+		return fmt.Errorf("scrypt p %d: want 1 to %d", p.P, maxKDFP)
+	case 128*int64(p.N)*int64(p.R) > maxKDFMemory:
+		return fmt.Errorf("scrypt N %d and r %d need more than %d MiB", p.N, p.R, maxKDFMemory>>20)
+	case len(p.Salt) < saltLen:
+		return fmt.Errorf("scrypt salt of %d bytes, want %d", len(p.Salt), saltLen)
+	}
+	return nil
+}
+
+func deriveAEAD(passphrase []byte, p kdfParams) (cipher.AEAD, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, p.Salt, p.N, p.R, p.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	return chacha20poly1305.NewX(key)
+}
+
+// Every archive has a salt of its own, so a key never seals two archives
+// and nonces can simply count segments.
+func segmentNonce(n uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	binary.BigEndian.PutUint64(nonce, n)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// sealWriter seals what is written to it in segments, each prefixed by
+// its length, authenticating the archive header with every one.
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	n      uint64
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(s.buf) == segmentSize {
+			if err := s.seal(false); err != nil {
+				return 0, err
+			}
+		}
+		k := min(len(p), segmentSize-len(s.buf))
+		s.buf = append(s.buf, p[:k]...)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Close seals what is buffered as the last segment. It doesn't close the
+// underlying writer.
+func (s *sealWriter) Close() error {
+	return s.seal(true)
+}
+
+func (s *sealWriter) seal(last bool) error {
+	sealed := s.aead.Seal(nil, segmentNonce(s.n, last), s.buf, s.header)
+	s.n++
+	s.buf = s.buf[:0]
+	length := uint32(len(sealed))
+	if last {
+		length |= lastSegment
+	}
+	if err := binary.Write(s.w, binary.BigEndian, length); err != nil {
+		return err
+	}
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+// openReader opens the segments sealWriter writes.
+type openReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	n      uint64
+	done   bool
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		var length uint32
+		if err := binary.Read(o.r, binary.BigEndian, &length); err != nil {
+			return 0, truncated(err)
+		}
+		last := length&lastSegment != 0
+		length &^= lastSegment
+		if length > segmentSize+uint32(o.aead.Overhead()) {
+			return 0, fmt.Errorf("backup: segment of %d bytes, archive damaged", length)
+		}
+		sealed := make([]byte, length)
+		if _, err := io.ReadFull(o.r, sealed); err != nil {
+			return 0, truncated(err)
+		}
+		var err error
+		if o.buf, err = o.aead.Open(sealed[:0], segmentNonce(o.n, last), sealed, o.header); err != nil {
+			return 0, ErrWrongPassphrase
+		}
+		o.n++
+		o.done = last
+	}
+	k := copy(p, o.buf)
+	o.buf = o.buf[k:]
+	return k, nil
+}
+
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.New("backup: archive is truncated")
+	}
+	return err
+}
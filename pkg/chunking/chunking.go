@@ -1 +1,218 @@
 package chunking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+const DefaultChunkSize = 1 << 20 // 1 MiB
+
+// MaxChunkSize bounds chunks, and with them what chunking allocates, so
+// that every chunk fits in a block peers exchange once sealed. The rest
+// of storage.MaxBlockSize is headroom for what sealing adds.
+const MaxChunkSize = storage.MaxBlockSize - 1<<20
+
+// Chunker splits a stream into chunks. Next returns io.EOF once the stream
+// is exhausted. The returned slice is owned by the caller.
+type Chunker interface {
+	Next() ([]byte, error)
+}
+
+type BlockPutter interface {
+	Put(ctx context.Context, c cid.Cid, data []byte) error
+}
+
+// Reuser is a store that can take over a chunk of an earlier version of a
+// file, see SplitOptions.Earlier. Reuse reports false when the chunk is no
+// longer stored, and keeps one it reports true for from a collection
+// running meanwhile.
+type Reuser interface {
+	Reuse(ctx context.Context, chunk Chunk) (bool, error)
+}
+
+type BlockGetter interface {
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+}
+
+// Sealer encrypts chunks before they are stored and decrypts them when
+// they are read back. Sealing must be deterministic, or a file can't be
+// chunked again to check it against its hash.
+type Sealer interface {
+	Seal(data []byte) []byte
+	Open(sealed []byte) ([]byte, error)
+}
+
+// Chunk locates one chunk of a file. Offset and Size are those of the
+// chunk's content, before sealing.
+type Chunk struct {
+	CID    cid.Cid
+	Offset int64
+	Size   int64
+}
+
+// ChunkList is the ordered list of chunks a file was split into.
+type ChunkList struct {
+	Params Params
+	Size   int64
+	Chunks []Chunk
+	// Sums holds the Checksum of every chunk's content when the file was
+	// split with SplitOptions.Sums.
+	Sums []uint64
+}
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Checksum is a fast checksum of a chunk's content, to tell whether a
+// chunk changed since it was last split off without hashing it again. It
+// catches accidental changes, not forged ones.
+func Checksum(data []byte) uint64 {
+	return crc64.Checksum(data, crcTable)
+}
+
+// SplitOptions change how SplitWith stores chunks.
+type SplitOptions struct {
+	// Sealer seals every chunk before it is stored. Nil stores chunks as
+	// they are.
+	Sealer Sealer
+	// Sums records the checksum of every chunk in ChunkList.Sums.
+	Sums bool
+	// Earlier is an earlier version of the file, split with the same
+	// params and with Sums. A chunk with the offset, size and checksum of
+	// one of its chunks is taken over without being hashed or stored
+	// again, as long as the store, a Reuser, still holds it. Only a
+	// file that changed in place or was appended to keeps its offsets; a
+	// sealed file never takes chunks over.
+	Earlier *ChunkList
+}
+
+// Split chunks r according to p, stores every chunk as a raw block and
+// returns the resulting chunk list. Only one chunk is held in memory at a
+// time.
+func Split(ctx context.Context, r io.Reader, p Params, store BlockPutter) (*ChunkList, error) {
+	return SplitSealed(ctx, r, p, nil, store)
+}
+
+// SplitSealed is Split storing every chunk sealed by s. A nil s stores
+// chunks as they are.
+func SplitSealed(ctx context.Context, r io.Reader, p Params, s Sealer, store BlockPutter) (*ChunkList, error) {
+	return SplitWith(ctx, r, p, SplitOptions{Sealer: s}, store)
+}
+
+// SplitWith is Split with options.
+func SplitWith(ctx context.Context, r io.Reader, p Params, opts SplitOptions, store BlockPutter) (*ChunkList, error) {
+	p, err := p.normalize()
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.New(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := opts.Sealer
+	earlier := opts.Earlier
+	reuser, ok := store.(Reuser)
+	if !ok || s != nil || earlier != nil && (earlier.Params.cuts() != p.cuts() || len(earlier.Sums) != len(earlier.Chunks)) {
+		earlier = nil
+	}
+	var next int // the first chunk of earlier not behind the file yet
+
+	list := &ChunkList{Params: p}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			return list, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		chunk := Chunk{Offset: list.Size, Size: int64(len(data))}
+		var sum uint64
+		if opts.Sums || earlier != nil {
+			sum = Checksum(data)
+			if opts.Sums {
+				list.Sums = append(list.Sums, sum)
+			}
+		}
+		list.Size += chunk.Size
+
+		if earlier != nil {
+			for next < len(earlier.Chunks) && earlier.Chunks[next].Offset < chunk.Offset {
+				next++
+			}
+			if next < len(earlier.Chunks) {
+				old := earlier.Chunks[next]
+				if old.Offset == chunk.Offset && old.Size == chunk.Size && earlier.Sums[next] == sum {
+					reused, err := reuser.Reuse(ctx, old)
+					if err != nil {
+						return nil, err
+					}
+					if reused {
+						list.Chunks = append(list.Chunks, old)
+						continue
+					}
+				}
+			}
+		}
+
+		stored := data
+		if s != nil {
+			stored = s.Seal(data)
+		}
+		block, err := storage.NewRawBlock(stored)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Put(storage.WithCompression(ctx, p.Compression), block.CID, block.Data); err != nil {
+			return nil, err
+		}
+
+		chunk.CID = block.CID
+		list.Chunks = append(list.Chunks, chunk)
+	}
+}
+
+// Reassemble writes the chunks in order to w, verifying each one against its
+// hash and recorded size.
+func Reassemble(ctx context.Context, w io.Writer, chunks []Chunk, store BlockGetter) error {
+	return ReassembleSealed(ctx, w, chunks, nil, store)
+}
+
+// ReassembleSealed is Reassemble for chunks sealed by s, which are opened
+// after being verified against their hash.
+func ReassembleSealed(ctx context.Context, w io.Writer, chunks []Chunk, s Sealer, store BlockGetter) error {
+	for _, chunk := range chunks {
+		data, err := store.Get(ctx, chunk.CID)
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", chunk.CID, err)
+		}
+		if err := storage.Verify(chunk.CID, data); err != nil {
+			return fmt.Errorf("chunk %s: %w", chunk.CID, err)
+		}
+		if s != nil {
+			if data, err = s.Open(data); err != nil {
+				return fmt.Errorf("chunk %s: %w", chunk.CID, err)
+			}
+		}
+		if int64(len(data)) != chunk.Size {
+			return fmt.Errorf("chunk %s: size %d, expected %d", chunk.CID, len(data), chunk.Size)
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package chunking
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// gear maps every byte value to a pseudo random 64 bit number. It is
+// generated from a fixed seed so chunk boundaries, and therefore CIDs, are
+// identical on every platform and release.
+var gear = func() (table [256]uint64) {
+	state := uint64(0x64667363646321) // "dfscdc!"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// FastCDC implements content defined chunking with normalized chunk sizes
+// as described in "FastCDC: a Fast and Efficient Content-Defined Chunking
+// Approach for Data Deduplication" (Xia et al., 2016). An edit only moves
+// the boundaries near it, so the remaining chunks keep their hashes.
+type FastCDC struct {
+	r             io.Reader
+	min, avg, max int
+	maskS, maskL  uint64
+	// align, when set, restricts cuts to multiples of it, see
+	// NewAlignedFastCDC.
+	align int
+
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func NewFastCDC(r io.Reader, min, avg, max int) (*FastCDC, error) {
+	if min <= 0 || min > avg || avg > max {
+		return nil, fmt.Errorf("chunking: need 0 < min (%d) <= avg (%d) <= max (%d)", min, avg, max)
+	}
+	if max > MaxChunkSize {
+		return nil, fmt.Errorf("chunking: maximum chunk size %d exceeds the limit of %d", max, MaxChunkSize)
+	}
+
+	b := bits.Len(uint(avg)) - 1
+	return &FastCDC{
+		r:   r,
+		min: min,
+		avg: avg,
+		max: max,
+		// Before the average size a stricter mask (more bits) makes a cut
+		// less likely, after it a looser one makes it more likely.
+		maskS: topBits(b + 1),
+		maskL: topBits(b - 1),
+		buf:   make([]byte, max),
+	}, nil
+}
+
+// NewAlignedFastCDC is NewFastCDC cutting only where a chunk is a
+// multiple of align long, align a power of two that min and max are
+// multiples of. Only the last chunk of a stream may end elsewhere.
+//
+// With cut points align bytes apart the fingerprint is only taken where
+// one can fall: over the 64 bytes before it, which is all the rolling
+// fingerprint depends on anyway. The masks are looser to match, so chunks
+// keep their average size.
+func NewAlignedFastCDC(r io.Reader, min, avg, max, align int) (*FastCDC, error) {
+	if align <= 0 || align&(align-1) != 0 || min%align != 0 || max%align != 0 {
+		return nil, fmt.Errorf("chunking: need min (%d) and max (%d) multiples of align (%d), a power of two", min, max, align)
+	}
+	f, err := NewFastCDC(r, min, avg, max)
+	if err != nil {
+		return nil, err
+	}
+	b := bits.Len(uint(avg/align)) - 1
+	f.maskS, f.maskL = topBits(b+1), topBits(b-1)
+	f.align = align
+	return f, nil
+}
+
+func topBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	if n > 64 {
+		n = 64
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+func (f *FastCDC) Next() ([]byte, error) {
+	if err := f.fill(); err != nil {
+		return nil, err
+	}
+	if f.start == f.end {
+		return nil, io.EOF
+	}
+
+	n := f.cut(f.buf[f.start:f.end])
+	chunk := make([]byte, n)
+	copy(chunk, f.buf[f.start:f.start+n])
+	f.start += n
+
+	return chunk, nil
+}
+
+// fill tops the buffer up to max bytes unless the stream has ended.
+func (f *FastCDC) fill() error {
+	if f.eof || f.end-f.start >= f.max {
+		return nil
+	}
+
+	copy(f.buf, f.buf[f.start:f.end])
+	f.end -= f.start
+	f.start = 0
+
+	n, err := io.ReadFull(f.r, f.buf[f.end:])
+	f.end += n
+
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		f.eof = true
+	case err != nil:
+		return err
+	}
+	return nil
+}
+
+// cut returns the length of the next chunk at the start of data.
+func (f *FastCDC) cut(data []byte) int {
+	n := len(data)
+	if n <= f.min {
+		return n
+	}
+	if n > f.max {
+		n = f.max
+	}
+
+	normal := f.avg
+	if n < normal {
+		normal = n
+	}
+	if f.align > 0 {
+		return f.cutAligned(data[:n], normal)
+	}
+
+	var fp uint64
+	i := f.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&f.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&f.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// cutAligned is cut for an aligned chunker: the first multiple of align
+// past min where the fingerprint matches, or n.
+func (f *FastCDC) cutAligned(data []byte, normal int) int {
+	n := len(data)
+	for end := f.min + f.align; end <= n; end += f.align {
+		var fp uint64
+		for _, b := range data[max(end-64, 0):end] {
+			fp = (fp << 1) + gear[b]
+		}
+		mask := f.maskS
+		if end > normal {
+			mask = f.maskL
+		}
+		if fp&mask == 0 {
+			return end
+		}
+	}
+	return n
+}
@@ -0,0 +1,40 @@
+package chunking
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FixedSize cuts a stream into chunks of the same size. Only the last chunk
+// may be shorter.
+type FixedSize struct {
+	r    io.Reader
+	size int
+}
+
+func NewFixedSize(r io.Reader, size int) (*FixedSize, error) {
+	if size == 0 {
+		size = DefaultChunkSize
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("chunking: invalid chunk size %d", size)
+	}
+	if size > MaxChunkSize {
+		return nil, fmt.Errorf("chunking: chunk size %d exceeds the maximum of %d", size, MaxChunkSize)
+	}
+	return &FixedSize{r: r, size: size}, nil
+}
+
+func (f *FixedSize) Next() ([]byte, error) {
+	buf := make([]byte, f.size)
+
+	n, err := io.ReadFull(f.r, buf)
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return buf[:n], nil
+	case err != nil:
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,89 @@
+package chunking
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+)
+
+// goldenData returns n bytes that are the same on every platform and
+// release: sha256 over a counter, so not compressible either.
+func goldenData(n int) []byte {
+	var b []byte
+	var ctr [8]byte
+	for i := uint64(0); len(b) < n; i++ {
+		binary.BigEndian.PutUint64(ctr[:], i)
+		sum := sha256.Sum256(ctr[:])
+		b = append(b, sum[:]...)
+	}
+	return b[:n]
+}
+
+type goldenChunk struct {
+	size int64
+	cid  string
+}
+
+// Changing how files are chunked changes their CIDs, and the CIDs of
+// every file already added with the same params stop matching: a file
+// added again is stored twice, and dfs verify reports it altered. These
+// tests catch that before a release does.
+func testGolden(t *testing.T, p Params, data []byte, want []goldenChunk) {
+	t.Helper()
+	list, err := Split(context.Background(), bytes.NewReader(data), p, storage.NewMemBlockstore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Chunks) != len(want) {
+		for _, c := range list.Chunks {
+			t.Logf("{%d, %q},", c.Size, c.CID)
+		}
+		t.Fatalf("%d chunks, want %d", len(list.Chunks), len(want))
+	}
+	for i, c := range list.Chunks {
+		if c.Size != want[i].size || c.CID.String() != want[i].cid {
+			t.Errorf("chunk %d = %d bytes %s, want %d bytes %s", i, c.Size, c.CID, want[i].size, want[i].cid)
+		}
+	}
+}
+
+func TestGoldenFixed(t *testing.T) {
+	testGolden(t, Params{Strategy: StrategyFixed, Size: 4096}, goldenData(10000), []goldenChunk{
+		{4096, "bafkreicxgsmmdlnvlw7jbdgvi23vdtcoyh2zjfwunxzmalwkziu62z7gf4"},
+		{4096, "bafkreiezamr7joshv5r4frrisbxovjjyhzs3fysalt5ncx2altqv5elvku"},
+		{1808, "bafkreibcefjsvena4li37iusq5lc3pzefddpclewqxbdws6neny6cdm2ia"},
+	})
+}
+
+func TestGoldenFastCDC(t *testing.T) {
+	testGolden(t, Params{Strategy: StrategyFastCDC, Size: 4096}, goldenData(40000), []goldenChunk{
+		{2337, "bafkreifmhtfaii2o7u3n3cv3qp4tdl6qscla4apam7xslv2j5fw5vzlbei"},
+		{5469, "bafkreigrgsnkonno7udpjwxyf2u4s2sm2u2xtikofavfjd3fpw6e3np4s4"},
+		{6211, "bafkreigvbpupj3ol23liy7wwcb7gj6wqmi74as2jb76ieky4qhjy3p36c4"},
+		{4526, "bafkreif3egyfmtvkvigdpba76yhhq4nuzdmhj5vffkkqnrxugm6g3x4lom"},
+		{5744, "bafkreicik5phrribqsndtzzjgjlrfup33rssp66moa5ix4ylaik5ohfhcm"},
+		{5828, "bafkreiawdsq43llecqaweiricu5yzkj6vuravg47ynsoxbpipzdh5l2bnq"},
+		{2560, "bafkreif3wuhp6xb6oqn2ukwctsyf4g3qwxqzzwu3ebynp5jm7sx6kij5f4"},
+		{1886, "bafkreiasbryfilpd34vk5u6ks7co34doj5pucpfqpmxplqbk2kpihepmna"},
+		{4292, "bafkreiaaf5d5vwyefsoariwitaac3zybjsumzbzpnfemnjoq62xjh6bchu"},
+		{1147, "bafkreie466i6floguw46sebziw5wkwrou2fp22hlw3mmybp4mkbrt6dwp4"},
+	})
+}
+
+func TestGoldenFastCDCAligned(t *testing.T) {
+	testGolden(t, Params{Strategy: StrategyFastCDC, Size: 4096, Align: 512}, goldenData(40000), []goldenChunk{
+		{4608, "bafkreielmy6etinw7t3vg5kh75ktgv5zq7g5f27i3xdjbpwmyr3xxftkpq"},
+		{5120, "bafkreia6y6lk2v74uyjur4udcgof2tfgj5ufyr3fq4x4jxpatmlx54tcvq"},
+		{6656, "bafkreigzvfk2dlp7rur64pdq5gmmbsp3ugsqf7c3y54ac6c2bz3k54ybjm"},
+		{5632, "bafkreibcvc4gztyay774rpgdl3vj6iss3yt5iw5isduxdn5nxzbg7y73xu"},
+		{2048, "bafkreihj7ixmpj2kqjibfteyqs752puu4dcnwwxyvfnyzcchkqnelimyim"},
+		{2560, "bafkreiani73n243rji32c6a3eho2jwvu333pv7qtndvb3rrgxmm6hnxina"},
+		{5120, "bafkreibkvub42akwf3b5aspmasmah7ibrtx3k6q3jxtzlnytwfvnntwyau"},
+		{5632, "bafkreicrehbo7wmh7bvcfusuvqavcqxojoqhqr25k7hxfno7p2ve3m3xe4"},
+		{2624, "bafkreifmwxaa6qnuaur4kppx3nr52l4xttalavlgyb5eznvivj24s7awra"},
+	})
+}
@@ -0,0 +1,152 @@
+package chunking
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+)
+
+const (
+	StrategyFixed   = "fixed"
+	StrategyFastCDC = "fastcdc"
+)
+
+// Params describe how a file is split. They are recorded alongside the
+// chunk list so readers know how a file was chunked.
+type Params struct {
+	Strategy string `json:"strategy"`
+	// Size is the chunk size for fixed chunking and the target average
+	// size for content defined chunking.
+	Size int `json:"size"`
+	// MinSize and MaxSize bound content defined chunks. Zero picks Size/4
+	// and Size*4, the latter at most MaxChunkSize.
+	MinSize int `json:"min_size,omitempty"`
+	MaxSize int `json:"max_size,omitempty"`
+	// Align, a power of two, makes content defined chunks start and end
+	// at multiples of it, such as the pages of a database or disk image,
+	// so an edit to a page changes the chunk holding it only. MinSize and
+	// MaxSize are multiples of it. Zero cuts anywhere.
+	Align int `json:"align,omitempty"`
+	// Compression is the codec chunks are stored with, see
+	// storage.WithCompression. Chunks that look incompressible are stored
+	// as they are. Empty or "none" stores every chunk uncompressed. It is
+	// a storage detail and left out of manifests.
+	Compression string `json:"compression,omitempty"`
+}
+
+func DefaultParams() Params {
+	return Params{Strategy: StrategyFixed, Size: DefaultChunkSize}
+}
+
+// ProfilePaged names the params for large files that are appended to or
+// rewritten a page at a time: disk images, SQLite databases, mailboxes.
+const ProfilePaged = "paged"
+
+// Profile returns the params a profile names.
+func Profile(name string) (Params, error) {
+	switch name {
+	case ProfilePaged:
+		// Large chunks keep the manifests of huge files small, and 4 KiB
+		// is the page size of most filesystems and databases
+		return Params{Strategy: StrategyFastCDC, Size: 4 << 20, MinSize: 1 << 20, MaxSize: 16 << 20, Align: 4096}, nil
+	default:
+		return Params{}, fmt.Errorf("chunking: unknown profile %q", name)
+	}
+}
+
+func (p Params) Validate() error {
+	_, err := p.normalize()
+	return err
+}
+
+// MaxChunk returns the size of the largest chunk p splits off.
+func (p Params) MaxChunk() int {
+	p, err := p.normalize()
+	if err != nil {
+		return MaxChunkSize
+	}
+	if p.Strategy == StrategyFastCDC {
+		return p.MaxSize
+	}
+	return p.Size
+}
+
+// cuts returns the params that decide where chunks are cut and what they
+// hash to, leaving out how they are stored.
+func (p Params) cuts() Params {
+	p.Compression = ""
+	return p
+}
+
+// normalize fills in defaults and validates p.
+func (p Params) normalize() (Params, error) {
+	if p.Strategy == "" {
+		p.Strategy = StrategyFixed
+	}
+	if p.Size == 0 {
+		p.Size = DefaultChunkSize
+	}
+	if p.Size < 0 {
+		return p, fmt.Errorf("chunking: invalid chunk size %d", p.Size)
+	}
+	if p.Size > MaxChunkSize {
+		return p, fmt.Errorf("chunking: chunk size %d exceeds the maximum of %d", p.Size, MaxChunkSize)
+	}
+	if err := storage.ValidCompression(p.Compression); err != nil {
+		return p, err
+	}
+	if p.Compression == storage.CompressionNone {
+		p.Compression = ""
+	}
+
+	switch p.Strategy {
+	case StrategyFixed:
+		if p.Align != 0 {
+			return p, fmt.Errorf("chunking: alignment needs the %s strategy", StrategyFastCDC)
+		}
+		p.MinSize, p.MaxSize = 0, 0
+	case StrategyFastCDC:
+		a := max(p.Align, 1)
+		if p.Align < 0 || p.Align&(p.Align-1) != 0 || p.Align > p.Size/2 {
+			return p, fmt.Errorf("chunking: alignment %d is not a power of two up to half the size", p.Align)
+		}
+		if p.MinSize == 0 {
+			p.MinSize = (p.Size/4 + a - 1) / a * a
+		}
+		if p.MaxSize == 0 {
+			p.MaxSize = min(p.Size*4, MaxChunkSize) / a * a
+		}
+		if p.MinSize%a != 0 || p.MaxSize%a != 0 {
+			return p, fmt.Errorf("chunking: min (%d) and max (%d) must be multiples of the alignment %d", p.MinSize, p.MaxSize, p.Align)
+		}
+		if p.MinSize <= 0 || p.MinSize > p.Size || p.MaxSize < p.Size {
+			return p, fmt.Errorf("chunking: need 0 < min (%d) <= size (%d) <= max (%d)", p.MinSize, p.Size, p.MaxSize)
+		}
+		if p.MaxSize > MaxChunkSize {
+			return p, fmt.Errorf("chunking: maximum chunk size %d exceeds the limit of %d", p.MaxSize, MaxChunkSize)
+		}
+	default:
+		return p, fmt.Errorf("chunking: unknown strategy %q", p.Strategy)
+	}
+
+	return p, nil
+}
+
+// New returns a chunker for r that splits according to p.
+func (p Params) New(r io.Reader) (Chunker, error) {
+	p, err := p.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.Strategy {
+	case StrategyFastCDC:
+		if p.Align > 0 {
+			return NewAlignedFastCDC(r, p.MinSize, p.Size, p.MaxSize, p.Align)
+		}
+		return NewFastCDC(r, p.MinSize, p.Size, p.MaxSize)
+	default:
+		return NewFixedSize(r, p.Size)
+	}
+}
@@ -0,0 +1,78 @@
+package chunking
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParamsSizeCap(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Params
+		ok   bool
+	}{
+		{"fixed at the cap", Params{Strategy: StrategyFixed, Size: MaxChunkSize}, true},
+		{"fixed above the cap", Params{Strategy: StrategyFixed, Size: MaxChunkSize + 1}, false},
+		{"fastcdc default max capped", Params{Strategy: StrategyFastCDC, Size: MaxChunkSize / 2}, true},
+		{"fastcdc size above the cap", Params{Strategy: StrategyFastCDC, Size: 1 << 40}, false},
+		{"fastcdc max above the cap", Params{Strategy: StrategyFastCDC, Size: 1 << 20, MaxSize: MaxChunkSize + 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.p.normalize()
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.MaxSize > MaxChunkSize {
+					t.Errorf("max size %d above the cap", p.MaxSize)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "exceeds") {
+				t.Errorf("normalize(%+v) = %v, want an error about the cap", tt.p, err)
+			}
+			if _, err := tt.p.New(strings.NewReader("")); err == nil {
+				t.Error("New accepted params above the cap")
+			}
+		})
+	}
+}
+
+func TestChunkersRejectOversize(t *testing.T) {
+	if _, err := NewFixedSize(strings.NewReader(""), MaxChunkSize+1); err == nil {
+		t.Error("NewFixedSize accepted a chunk size above the cap")
+	}
+	if _, err := NewFastCDC(strings.NewReader(""), 1<<20, 1<<22, 1<<40); err == nil {
+		t.Error("NewFastCDC accepted a maximum above the cap")
+	}
+}
+
+func TestParamsAlign(t *testing.T) {
+	paged, err := Profile(ProfilePaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := paged.Validate(); err != nil {
+		t.Errorf("paged profile: %v", err)
+	}
+
+	p, err := Params{Strategy: StrategyFastCDC, Size: 10000, Align: 512}.normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MinSize%512 != 0 || p.MaxSize%512 != 0 || p.MinSize < 2500 || p.MaxSize > 40000 {
+		t.Errorf("default bounds %d and %d, want multiples of 512 around 2500 and 40000", p.MinSize, p.MaxSize)
+	}
+
+	for _, p := range []Params{
+		{Strategy: StrategyFixed, Size: 4096, Align: 512},
+		{Strategy: StrategyFastCDC, Size: 4096, Align: 500},
+		{Strategy: StrategyFastCDC, Size: 4096, Align: 4096},
+		{Strategy: StrategyFastCDC, Size: 4096, MinSize: 1000, Align: 512},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted a bad alignment", p)
+		}
+	}
+}
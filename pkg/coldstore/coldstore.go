@@ -0,0 +1,135 @@
+// Package coldstore is the cold tier pins are archived to: slow, cheap
+// storage such as a tape library or network mount, or an infrequent
+// access S3 storage class. It keeps the blocks of archived pins while the
+// hot store no longer does and the network no longer serves them;
+// recalling a pin copies them back.
+package coldstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/aws"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrNotConfigured is returned when archiving or recalling without a cold
+// store configured.
+var ErrNotConfigured = errors.New("coldstore: none configured")
+
+// Store keeps archived blocks. Get returns storage.ErrNotFound for a block
+// it doesn't hold. A storage.FSBlockstore is one.
+type Store interface {
+	Put(ctx context.Context, c cid.Cid, data []byte) error
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	// Size returns the stored size of a block in bytes.
+	Size(ctx context.Context, c cid.Cid) (int64, error)
+	Close() error
+}
+
+// Options selects the store, see config.ArchiveConfig.
+type Options struct {
+	// Path is a directory to keep blocks in.
+	Path string
+	// S3 is an s3://bucket/prefix URL to keep them under instead, as
+	// objects of StorageClass.
+	S3           string
+	StorageClass string
+}
+
+// Open opens the store opts select, nil when they select none.
+func Open(opts Options) (Store, error) {
+	switch {
+	case opts.Path != "" && opts.S3 != "":
+		return nil, errors.New("coldstore: set a path or an S3 URL, not both")
+	case opts.Path != "":
+		return storage.Open(opts.Path)
+	case opts.S3 != "":
+		bucket, prefix, err := ParseS3(opts.S3)
+		if err != nil {
+			return nil, err
+		}
+		s3, err := aws.NewS3FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		s3.StorageClass = opts.StorageClass
+		return NewS3(s3, bucket, prefix), nil
+	}
+	return nil, nil
+}
+
+// ParseS3 splits an s3://bucket/prefix URL. The prefix is optional.
+func ParseS3(s string) (bucket, prefix string, err error) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return "", "", fmt.Errorf("coldstore: %q is not an s3://bucket/prefix URL", s)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// S3 keeps blocks as objects named by their CID under a prefix.
+type S3 struct {
+	s3             *aws.S3
+	bucket, prefix string
+}
+
+var _ Store = (*S3)(nil)
+
+func NewS3(s3 *aws.S3, bucket, prefix string) *S3 {
+	return &S3{s3: s3, bucket: bucket, prefix: prefix}
+}
+
+func (s *S3) key(c cid.Cid) string {
+	if s.prefix == "" {
+		return c.String()
+	}
+	return s.prefix + "/" + c.String()
+}
+
+func (s *S3) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	if err := storage.Verify(c, data); err != nil {
+		return err
+	}
+	return s.s3.Put(ctx, s.bucket, s.key(c), data)
+}
+
+// Get returns the block c, checked against its CID.
+func (s *S3) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	r, err := s.s3.Get(ctx, s.bucket, s.key(c))
+	if aws.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, storage.MaxBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Verify(c, data); err != nil {
+		return nil, fmt.Errorf("coldstore: %s: %w", c, err)
+	}
+	return data, nil
+}
+
+func (s *S3) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return s.s3.Exists(ctx, s.bucket, s.key(c))
+}
+
+func (s *S3) Size(ctx context.Context, c cid.Cid) (int64, error) {
+	size, err := s.s3.Size(ctx, s.bucket, s.key(c))
+	if aws.IsNotFound(err) {
+		return 0, fmt.Errorf("%w: %s", storage.ErrNotFound, c)
+	}
+	return size, err
+}
+
+func (s *S3) Close() error { return nil }
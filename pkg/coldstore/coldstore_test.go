@@ -0,0 +1,40 @@
+package coldstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseS3(t *testing.T) {
+	for _, tt := range []struct {
+		url, bucket, prefix string
+	}{
+		{"s3://bucket", "bucket", ""},
+		{"s3://bucket/", "bucket", ""},
+		{"s3://bucket/cold/dfs/", "bucket", "cold/dfs"},
+	} {
+		bucket, prefix, err := ParseS3(tt.url)
+		if err != nil || bucket != tt.bucket || prefix != tt.prefix {
+			t.Errorf("ParseS3(%q) = %q, %q, %v, want %q, %q", tt.url, bucket, prefix, err, tt.bucket, tt.prefix)
+		}
+	}
+	for _, url := range []string{"bucket/prefix", "s3://", "s3:///prefix"} {
+		if _, _, err := ParseS3(url); err == nil {
+			t.Errorf("ParseS3(%q) accepted", url)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if s, err := Open(Options{}); s != nil || err != nil {
+		t.Errorf("Open without a store = %v, %v", s, err)
+	}
+	if _, err := Open(Options{Path: t.TempDir(), S3: "s3://bucket"}); err == nil {
+		t.Error("opened both a path and S3")
+	}
+	s, err := Open(Options{Path: filepath.Join(t.TempDir(), "cold")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+// Problem is something wrong with a config file.
+type Problem struct {
+	// Line is where in the file the problem is, 0 when unknown.
+	Line int
+	// Key is the dotted path of the setting, such as network.port.
+	Key     string
+	Message string
+	// Warning marks problems that don't stop the file from loading, such
+	// as keys this release doesn't know.
+	Warning bool
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", p.Line)
+	}
+	if p.Key != "" {
+		b.WriteString(p.Key + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// lineRe finds the line number in yaml error messages.
+var lineRe = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// Check reports the problems with the config file at path: a syntax
+// error, or keys that don't exist, values of the wrong type and the first
+// setting Validate refuses. Environment overrides are not applied, so it checks
+// the file as written. The error is only set when the file can't be read.
+func Check(path string) ([]Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil {
+		return []Problem{lineProblem(err.Error())}, nil
+	}
+
+	var problems []Problem
+	lines := make(map[string]int)
+	if len(root.Content) > 0 {
+		checkKeys(root.Content[0], reflect.TypeOf(Config{}), "", lines, &problems)
+	}
+
+	// Decode the way Load does, so types are judged the same way.
+	cfg := Default()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return append(problems, lineProblem(err.Error())), nil
+		}
+		for _, msg := range typeErr.Errors {
+			p := lineProblem(msg)
+			p.Key = keyAt(lines, p.Line)
+			problems = append(problems, p)
+		}
+		return problems, nil
+	}
+
+	if err := cfg.Validate(); err != nil {
+		key, msg, _ := strings.Cut(err.Error(), ": ")
+		problems = append(problems, Problem{Line: keyLine(lines, key), Key: key, Message: msg})
+	}
+	return problems, nil
+}
+
+// checkKeys walks a node against the type t, reporting mapping keys a
+// struct has no field for and recording the line of every key. List items
+// are recorded as key[i], map entries as key.name.
+func checkKeys(node *yamlv3.Node, t reflect.Type, prefix string, lines map[string]int, problems *[]Problem) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case node.Kind == yamlv3.SequenceNode && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		key := strings.TrimSuffix(prefix, ".")
+		for i, item := range node.Content {
+			path := fmt.Sprintf("%s[%d]", key, i)
+			lines[path] = item.Line
+			checkKeys(item, t.Elem(), path+".", lines, problems)
+		}
+
+	case node.Kind == yamlv3.MappingNode && t.Kind() == reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			path := prefix + key.Value
+			lines[path] = key.Line
+			checkKeys(value, t.Elem(), path+".", lines, problems)
+		}
+
+	case node.Kind == yamlv3.MappingNode && t.Kind() == reflect.Struct:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			path := prefix + key.Value
+			lines[path] = key.Line
+
+			field, ok := fieldByTag(t, key.Value)
+			if !ok {
+				*problems = append(*problems, Problem{Line: key.Line, Key: path, Message: "unknown key", Warning: true})
+				continue
+			}
+			checkKeys(value, field.Type, path+".", lines, problems)
+		}
+	}
+}
+
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if tag == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// keyLine finds the line of key, or of the closest section holding it.
+func keyLine(lines map[string]int, key string) int {
+	for key != "" {
+		if line, ok := lines[key]; ok {
+			return line
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
+
+// keyAt finds the key on line.
+func keyAt(lines map[string]int, line int) string {
+	key := ""
+	for k, l := range lines {
+		// A value on the key's line belongs to the deepest key there.
+		if l == line && len(k) > len(key) {
+			key = k
+		}
+	}
+	return key
+}
+
+func lineProblem(msg string) Problem {
+	m := lineRe.FindStringSubmatch(msg)
+	if m == nil {
+		return Problem{Message: strings.TrimPrefix(msg, "yaml: ")}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return Problem{Line: line, Message: msg[len(m[0]):]}
+}
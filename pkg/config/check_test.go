@@ -0,0 +1,78 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		want []Problem
+	}{
+		{
+			name: "valid",
+			file: "network:\n  port: 9100\n  bootstrap_peers:\n    - /ip4/1.2.3.4/tcp/9000/p2p/12D3KooWGRUVh5gNvHHaWVJhAiTwnBcDkCvMZkGsUDRYTJ5MGsCn\n",
+		},
+		{
+			name: "syntax",
+			file: "network:\n  port: [\n",
+			want: []Problem{{Line: 2, Message: "did not find expected node content"}},
+		},
+		{
+			name: "unknown key",
+			file: "gc:\n  intervall: 1h\n",
+			want: []Problem{{Line: 2, Key: "gc.intervall", Message: "unknown key", Warning: true}},
+		},
+		{
+			name: "unknown key in a list item",
+			file: "replication:\n  classes:\n    gold: {replicas: 3}\n  rules:\n    - label: tier=gold\n      clas: gold\n",
+			want: []Problem{
+				{Line: 6, Key: "replication.rules[0].clas", Message: "unknown key", Warning: true},
+				// Without the class the rule asks for no replicas
+				{Line: 5, Key: "replication.rules[0].replicas", Message: "must be at least 1, got 0"},
+			},
+		},
+		{
+			name: "unknown key in a map entry",
+			file: "replication:\n  classes:\n    gold:\n      replicas: 3\n      zone: 2\n",
+			want: []Problem{{Line: 5, Key: "replication.classes.gold.zone", Message: "unknown key", Warning: true}},
+		},
+		{
+			name: "wrong type",
+			file: "network:\n  port: lots\n",
+			want: []Problem{{Line: 2, Key: "network.port", Message: "cannot unmarshal !!str `lots` into int"}},
+		},
+		{
+			name: "invalid value",
+			file: "network:\n  port: 9100\n  dht_mode: sever\n",
+			want: []Problem{{Line: 3, Key: "network.dht_mode", Message: `unknown mode "sever"`}},
+		},
+		{
+			name: "invalid list item",
+			file: "network:\n  bootstrap_peers:\n    - /ip4/1.2.3.4/tcp/9000/p2p/12D3KooWGRUVh5gNvHHaWVJhAiTwnBcDkCvMZkGsUDRYTJ5MGsCn\n    - /ip4/1.2.3.4/tcp/9000\n",
+			want: []Problem{{
+				Line:    4,
+				Key:     "network.bootstrap_peers[1]",
+				Message: `"/ip4/1.2.3.4/tcp/9000": invalid p2p multiaddr`,
+			}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := Check(writeConfig(t, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(problems, tt.want) {
+				t.Errorf("problems\n%+v\nwant\n%+v", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckMissingFile(t *testing.T) {
+	if _, err := Check("/nonexistent/config.yaml"); err == nil {
+		t.Error("missing file checked")
+	}
+}
@@ -0,0 +1,1087 @@
+package config
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/coldstore"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/replication"
+	"github.com/Noah-Wilderom/dfs/pkg/rundir"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.yaml.in/yaml/v2"
+)
+
+// DHT modes accepted in the config file.
+const (
+	DHTOff    = "off"
+	DHTClient = "client"
+	DHTServer = "server"
+	DHTAuto   = "auto"
+)
+
+type Config struct {
+	// DataDir is the root for all node state. Relative paths elsewhere in the
+	// config are resolved against it.
+	DataDir string `yaml:"data_dir"`
+
+	Network     NetworkConfig     `yaml:"network"`
+	Storage     StorageConfig     `yaml:"storage"`
+	Chunking    ChunkingConfig    `yaml:"chunking"`
+	Encryption  EncryptionConfig  `yaml:"encryption"`
+	Keystore    KeystoreConfig    `yaml:"keystore"`
+	API         APIConfig         `yaml:"api"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Gateway     GatewayConfig     `yaml:"gateway"`
+	Pressure    PressureConfig    `yaml:"pressure"`
+	Health      HealthConfig      `yaml:"health"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Push        PushConfig        `yaml:"push"`
+	GC          GCConfig          `yaml:"gc"`
+	Archive     ArchiveConfig     `yaml:"archive"`
+	Names       NamesConfig       `yaml:"names"`
+	Logging     LoggingConfig     `yaml:"logging"`
+}
+
+type NetworkConfig struct {
+	// Port is used for TCP and QUIC alike.
+	Port int `yaml:"port"`
+	// IPv4 and IPv6 listen on that address family. Both are on by
+	// default.
+	IPv4 bool `yaml:"ipv4"`
+	IPv6 bool `yaml:"ipv6"`
+	// QUIC accepts QUIC connections, on UDP. On by default.
+	QUIC bool `yaml:"quic"`
+	// Browser lets browser-based clients connect to the node as peers,
+	// over WebRTC-direct, and over WebTransport when QUIC is on too. Both
+	// use UDP on Port. Off by default; ignored with a swarm key or proxy.
+	Browser        bool     `yaml:"browser"`
+	BootstrapPeers []string `yaml:"bootstrap_peers"`
+	DHTMode        string   `yaml:"dht_mode"`
+	IdentityPath   string   `yaml:"identity_path"`
+	// ReprovideInterval is how often stored blocks are re-announced in the
+	// DHT. Zero uses the network default.
+	ReprovideInterval time.Duration `yaml:"reprovide_interval"`
+	// InventoryInterval is how often the node advertises a Bloom filter of
+	// the blocks it stores to the cluster, so peers ask it for them
+	// without a DHT lookup. Zero uses the network default, which is also
+	// the most; negative turns advertising off.
+	InventoryInterval time.Duration `yaml:"inventory_interval"`
+
+	// PortMap asks the gateway to forward the port to the node, over UPnP
+	// or NAT-PMP. On by default. `dfs doctor nat` shows what it got.
+	PortMap bool `yaml:"port_map"`
+	// ExternalAddr is a port forward set up by hand on the gateway, as
+	// "ip:port". The node announces it and stops asking the gateway.
+	ExternalAddr string `yaml:"external_addr"`
+
+	// AutoRelay lets a node behind NAT be reached through circuit relays,
+	// StaticRelays (multiaddrs ending in /p2p/<id>) or else connected
+	// peers that offer relaying.
+	AutoRelay    bool     `yaml:"auto_relay"`
+	StaticRelays []string `yaml:"static_relays"`
+	// HolePunching turns relayed connections into direct ones when both
+	// sides are behind NAT.
+	HolePunching bool `yaml:"hole_punching"`
+	// RelayServer relays connections for other peers. Only for publicly
+	// reachable nodes.
+	RelayServer bool `yaml:"relay_server"`
+
+	// ConnLow and ConnHigh bound the number of open connections: above
+	// conn_high, the least useful are closed until conn_low remain. Zero
+	// uses the defaults, 100 and 400.
+	ConnLow  int `yaml:"conn_low"`
+	ConnHigh int `yaml:"conn_high"`
+
+	// Bandwidth caps the blocks served to and fetched from peers, so
+	// seeding doesn't saturate a home connection's uplink.
+	Bandwidth BandwidthConfig `yaml:"bandwidth"`
+
+	// Streams bounds the inbound streams served per protocol, shedding
+	// the rest under a burst of requests.
+	Streams StreamsConfig `yaml:"streams"`
+
+	// Proxy sends every connection to peers through a SOCKS5 proxy, as
+	// "host:port", such as Tor's on 127.0.0.1:9050, to keep the node's IP
+	// address from them. The node then listens on localhost only, and
+	// port mapping, relays and hole punching are off. `dfs id` lists what
+	// else changes.
+	Proxy string `yaml:"proxy"`
+	// Onion lets peers connect through a Tor onion service. Needs Proxy.
+	Onion OnionConfig `yaml:"onion"`
+
+	// SwarmKey is a pre-shared key file (see `dfs swarm-key generate`).
+	// When set, the node joins a private network and only talks to peers
+	// holding the same key. Bootstrap peers must be members too.
+	SwarmKey string `yaml:"swarm_key"`
+
+	// Allow and Deny gate connections by peer ID, IP address or CIDR
+	// range. Deny wins; with any Allow rules, peers must match one. `dfs
+	// peers block` adds deny rules until the daemon restarts.
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// BandwidthConfig holds rate limits in bytes per second. Zero is
+// unlimited, the default.
+type BandwidthConfig struct {
+	Upload   int64 `yaml:"upload"`
+	Download int64 `yaml:"download"`
+	// PeerUpload and PeerDownload cap the traffic with any one peer.
+	PeerUpload   int64 `yaml:"peer_upload"`
+	PeerDownload int64 `yaml:"peer_download"`
+}
+
+// StreamsConfig sizes the worker pool of each protocol peers open streams
+// for.
+type StreamsConfig struct {
+	Block     StreamPoolConfig `yaml:"block"`
+	Want      StreamPoolConfig `yaml:"want"`
+	Replicate StreamPoolConfig `yaml:"replicate"`
+	Diff      StreamPoolConfig `yaml:"diff"`
+	Select    StreamPoolConfig `yaml:"select"`
+	Push      StreamPoolConfig `yaml:"push"`
+}
+
+// StreamPoolConfig has Workers handle streams at once, with up to Queue
+// more waiting; further streams are reset. Zero uses the protocol's
+// default.
+type StreamPoolConfig struct {
+	Workers int `yaml:"workers"`
+	Queue   int `yaml:"queue"`
+}
+
+// Pools returns the pools for the network, by protocol.
+func (c StreamsConfig) Pools() map[protocol.ID]network.StreamPool {
+	return map[protocol.ID]network.StreamPool{
+		network.BlockProtocol:     {Workers: c.Block.Workers, Queue: c.Block.Queue},
+		network.WantProtocol:      {Workers: c.Want.Workers, Queue: c.Want.Queue},
+		network.ReplicateProtocol: {Workers: c.Replicate.Workers, Queue: c.Replicate.Queue},
+		network.DiffProtocol:      {Workers: c.Diff.Workers, Queue: c.Diff.Queue},
+		network.SelectProtocol:    {Workers: c.Select.Workers, Queue: c.Select.Queue},
+		network.PushProtocol:      {Workers: c.Push.Workers, Queue: c.Push.Queue},
+	}
+}
+
+// OnionConfig has the node ask Tor for an onion service forwarding to its
+// port, and announce only the onion address. The service key is kept in
+// the data directory, so the address survives restarts.
+type OnionConfig struct {
+	// Control is Tor's control port, such as 127.0.0.1:9051. Empty
+	// leaves the onion service out.
+	Control string `yaml:"control"`
+	// Password for the control port, when Tor is set up with
+	// HashedControlPassword. Cookie authentication needs none.
+	Password string `yaml:"password"`
+}
+
+type StorageConfig struct {
+	Path string `yaml:"path"`
+	Pins string `yaml:"pins"`
+	// ReadOnly opens the repo without writing to it. New blocks and pins
+	// are kept in memory, and the node runs with a throwaway identity.
+	ReadOnly bool `yaml:"read_only"`
+	// Standby waits for the daemon holding the repo to stop and then takes
+	// over, with the same identity, names and pins. The data dir may be on
+	// shared storage, as long as it supports flock.
+	Standby bool `yaml:"standby"`
+}
+
+type APIConfig struct {
+	// Socket is the Unix socket the daemon serves its control API on.
+	Socket string `yaml:"socket"`
+	// TCPAddr optionally serves the API on TCP too, on a loopback address
+	// such as 127.0.0.1:5001. Others are refused, as the API is not
+	// authenticated.
+	TCPAddr string `yaml:"tcp_addr"`
+}
+
+type ReplicationConfig struct {
+	// Factor is the number of copies, this node's included, kept of every
+	// pin that doesn't set its own. 1 keeps only the local copy.
+	Factor int `yaml:"factor"`
+	// Accept stores copies that other peers ask this node to keep. Only
+	// enable it among trusted peers.
+	Accept bool `yaml:"accept"`
+	// Interval between replication checks.
+	Interval time.Duration `yaml:"interval"`
+	// Policy limits which replicas are accepted.
+	Policy ReplicationPolicy `yaml:"policy"`
+	// Donation limits what this node gives to the cluster.
+	Donation DonationConfig `yaml:"donation"`
+	// Zone is the zone this node is in, such as a site or an availability
+	// zone, announced to peers so classes can spread copies across zones.
+	Zone string `yaml:"zone"`
+	// Classes are named replication policies, e.g. gold with 3 copies in
+	// 2 zones, silver with 2, cache with none. Pins are assigned one with
+	// "dfs pin add --class" or by rules.
+	Classes map[string]ReplicationClass `yaml:"classes"`
+	// Rules assign a class, or a factor, to pins by label, e.g. class
+	// gold to the pins labelled tier=gold. A pin matching several gets the
+	// one asking for the most copies; a factor or class set on the pin
+	// itself overrides them all.
+	Rules []ReplicationRule `yaml:"rules"`
+}
+
+// className is what a replication class may be called.
+var className = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ReplicationClass is a named replication policy.
+type ReplicationClass struct {
+	// Replicas is the number of copies, this node's included. Zero asks
+	// peers for none.
+	Replicas int `yaml:"replicas"`
+	// Zones is the number of zones the copies must span.
+	Zones int `yaml:"zones"`
+}
+
+// ReplicationRule assigns the pins with a label a class or a factor.
+type ReplicationRule struct {
+	// Label selects pins as key=value, or by key alone for any value.
+	Label    string `yaml:"label"`
+	Class    string `yaml:"class"`
+	Replicas int    `yaml:"replicas"`
+}
+
+// DonationConfig is the space and time a node donates to other peers'
+// replicas.
+type DonationConfig struct {
+	// Capacity caps the total size of replicas kept for others, in bytes.
+	// Zero is unlimited.
+	Capacity int64 `yaml:"capacity"`
+	// Window restricts replication, both ways, to a daily range of local
+	// time such as "01:00-06:00". Empty allows it all day.
+	Window string `yaml:"window"`
+}
+
+// ReplicationPolicy holds the rules a replica request must pass to be
+// accepted. Rules left unset don't apply.
+type ReplicationPolicy struct {
+	// MaxSize refuses files larger than this many bytes.
+	MaxSize int64 `yaml:"max_size"`
+	// Peers only accepts requests from these peer IDs.
+	Peers []string `yaml:"peers"`
+	// ContentTypes only accepts files whose media type, guessed from the
+	// file name, matches one of these, e.g. "image/*" or "application/pdf".
+	ContentTypes []string `yaml:"content_types"`
+	// MaxBytesPerPeer caps the total size of the replicas kept for any one
+	// peer.
+	MaxBytesPerPeer int64 `yaml:"max_bytes_per_peer"`
+}
+
+// PushConfig lets peers send this node files with `dfs push`, without
+// anyone looking them up. What they send waits in the inbox, kept but not
+// pinned, until accepted with `dfs inbox accept`.
+type PushConfig struct {
+	// Accept takes what peers push into the inbox. Off by default; only
+	// enable it among trusted peers or with Peers set.
+	Accept bool `yaml:"accept"`
+	// MaxSize refuses files and directories larger than this many bytes.
+	// Zero is unlimited.
+	MaxSize int64 `yaml:"max_size"`
+	// Peers only takes pushes from these peer IDs. Empty takes them from
+	// any peer.
+	Peers []string `yaml:"peers"`
+	// Capacity caps the total size of what waits in the inbox, in bytes.
+	// Zero is unlimited.
+	Capacity int64 `yaml:"capacity"`
+}
+
+type GCConfig struct {
+	// Interval runs garbage collection in the background. Zero disables
+	// it; `dfs repo gc` still works.
+	Interval time.Duration `yaml:"interval"`
+	// GracePeriod keeps unpinned blocks younger than it.
+	GracePeriod time.Duration `yaml:"grace_period"`
+}
+
+// ArchiveConfig sets up the cold store `dfs pin archive` moves pins to.
+// Without a path or S3 URL pins can't be archived.
+type ArchiveConfig struct {
+	// Path is a directory, usually on a slow or network mount, to keep
+	// archived blocks in.
+	Path string `yaml:"path"`
+	// S3 is an s3://bucket/prefix URL to keep them under instead, with
+	// the credentials and region in the AWS_* environment variables.
+	S3 string `yaml:"s3"`
+	// StorageClass is the S3 storage class of the objects, such as
+	// STANDARD_IA or GLACIER_IR. Empty uses the bucket default. Classes
+	// whose objects must be restored before being read are refused.
+	StorageClass string `yaml:"storage_class"`
+}
+
+// NamesConfig tunes the records behind `dfs name publish`.
+type NamesConfig struct {
+	// Lifetime is how long a published record stays valid. Resolvers
+	// stop accepting it afterwards unless it was republished. Zero uses
+	// the network default of 48h.
+	Lifetime time.Duration `yaml:"lifetime"`
+	// RepublishInterval is how often records are renewed and announced
+	// again. Zero uses the network default of 4h.
+	RepublishInterval time.Duration `yaml:"republish_interval"`
+}
+
+type MetricsConfig struct {
+	// Addr serves Prometheus metrics on http://<addr>/metrics. Empty
+	// disables it.
+	Addr string `yaml:"addr"`
+}
+
+// GatewayConfig sets up the HTTP gateway serving what the network holds
+// to browsers, such as sites published with `dfs publish-site`.
+type GatewayConfig struct {
+	// Addr serves the gateway on http://<addr>. Empty disables it.
+	Addr string `yaml:"addr"`
+	// URL is where the gateway is reached from outside, such as behind a
+	// proxy, for the links commands print. Defaults to http://<addr>.
+	URL string `yaml:"url"`
+}
+
+// PublicURL is the base URL of the gateway, empty when it isn't served.
+func (g GatewayConfig) PublicURL() string {
+	switch {
+	case g.URL != "":
+		return strings.TrimSuffix(g.URL, "/")
+	case g.Addr == "":
+		return ""
+	}
+	host, port, err := net.SplitHostPort(g.Addr)
+	if err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		return "http://" + net.JoinHostPort("localhost", port)
+	}
+	return "http://" + g.Addr
+}
+
+// PressureConfig holds the limits the daemon throttles itself to stay
+// under: past three quarters of a limit it serves fewer peers and fetches
+// fewer blocks at once, down to an eighth of its usual concurrency at the
+// limit.
+type PressureConfig struct {
+	// HeapLimit is the memory, in bytes, the Go runtime may hold. Zero
+	// uses GOMEMLIMIT or the cgroup memory limit, if there is either.
+	HeapLimit int64 `yaml:"heap_limit"`
+	// FDLimit is the number of open files. Zero uses the process limit.
+	FDLimit int `yaml:"fd_limit"`
+	// DiskQueue is the number of block store operations in flight. Zero
+	// uses 64.
+	DiskQueue int `yaml:"disk_queue"`
+	// Interval between checks. Zero uses 2s.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// HealthConfig holds the objectives `dfs status` judges the daemon by: the
+// share of operations of each kind expected to succeed. Failing more than
+// that over five minutes or an hour turns the kind yellow, over both red.
+type HealthConfig struct {
+	// DHT is for provider lookups. Zero uses 0.95.
+	DHT float64 `yaml:"dht"`
+	// Dial is for connecting to peers. Zero uses 0.9.
+	Dial float64 `yaml:"dial"`
+	// Fetch is for block requests to peers. Zero uses 0.99.
+	Fetch float64 `yaml:"fetch"`
+}
+
+type ChunkingConfig struct {
+	// Strategy is "fixed" or "fastcdc".
+	Strategy string `yaml:"strategy"`
+	// ChunkSize is the fixed chunk size, or the target average for fastcdc.
+	ChunkSize int `yaml:"chunk_size"`
+	MinSize   int `yaml:"min_size"`
+	MaxSize   int `yaml:"max_size"`
+	// Align makes fastcdc cut only at multiples of it, see
+	// chunking.Params.
+	Align int `yaml:"align"`
+	// Compression is "zstd" to store chunks compressed when they compress
+	// well, or "none".
+	Compression string `yaml:"compression"`
+}
+
+func (c ChunkingConfig) Params() chunking.Params {
+	return chunking.Params{
+		Strategy:    c.Strategy,
+		Size:        c.ChunkSize,
+		MinSize:     c.MinSize,
+		MaxSize:     c.MaxSize,
+		Align:       c.Align,
+		Compression: c.Compression,
+	}
+}
+
+// EncryptionConfig holds the key for files added with `dfs add --encrypt`.
+type EncryptionConfig struct {
+	// MasterKey is the file holding the master key, generated on first
+	// start. Files encrypted on one node can be read on another given a
+	// copy of it.
+	MasterKey string `yaml:"master_key"`
+}
+
+// KeystoreConfig locates the passphrase protected keystore made by `dfs
+// keys create`.
+type KeystoreConfig struct {
+	// Path is the keystore. Once it exists, the node key, the master keys
+	// and the name keys are taken from it rather than from their own
+	// files, and the keys of encrypted files are kept in it.
+	Path string `yaml:"path"`
+	// PassphraseFile holds the passphrase, for a daemon started without a
+	// terminal to ask on. DFS_PASSPHRASE takes precedence.
+	PassphraseFile string `yaml:"passphrase_file"`
+}
+
+type LoggingConfig struct {
+	Level  string   `yaml:"level"`
+	Redact []string `yaml:"redact"`
+	// EventLog records daemon events for `dfs debug replay`. Empty disables it.
+	EventLog string `yaml:"event_log"`
+}
+
+func DefaultDataDir() string {
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(homeDir, ".local", "share", "dfs")
+	}
+	return ".dfs"
+}
+
+// DefaultPath is the config file used when --config is not given.
+func DefaultPath() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "dfs", "config.yaml")
+	}
+	return "config.yaml"
+}
+
+func Default() *Config {
+	return &Config{
+		DataDir: DefaultDataDir(),
+		Network: NetworkConfig{
+			Port:           9000,
+			IPv4:           true,
+			IPv6:           true,
+			QUIC:           true,
+			PortMap:        true,
+			BootstrapPeers: []string{},
+			DHTMode:        DHTOff,
+			IdentityPath:   "identity.key",
+		},
+		Storage: StorageConfig{
+			Path: "blocks",
+			Pins: "pins.json",
+		},
+		API: APIConfig{
+			Socket: "api.sock",
+		},
+		Replication: ReplicationConfig{
+			Factor:   1,
+			Interval: 5 * time.Minute,
+		},
+		GC: GCConfig{
+			GracePeriod: time.Hour,
+		},
+		Chunking: ChunkingConfig{
+			Strategy:  chunking.StrategyFixed,
+			ChunkSize: chunking.DefaultChunkSize,
+		},
+		Encryption: EncryptionConfig{
+			MasterKey: "master.key",
+		},
+		Keystore: KeystoreConfig{
+			Path: "keystore.json",
+		},
+		Logging: LoggingConfig{
+			Level: "debug",
+		},
+	}
+}
+
+// Load builds the effective config: defaults, then the file at path, then
+// DFS_* environment variables. An empty path falls back to DefaultPath and
+// tolerates the file not existing.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath()
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+	default:
+		return nil, err
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func (c *Config) applyEnv() error {
+	if v := os.Getenv("DFS_DATA_DIR"); v != "" {
+		c.DataDir = v
+	}
+	if v := os.Getenv("DFS_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("DFS_PORT: %w", err)
+		}
+		c.Network.Port = port
+	}
+	if v := os.Getenv("DFS_UPLOAD_LIMIT"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("DFS_UPLOAD_LIMIT: %w", err)
+		}
+		c.Network.Bandwidth.Upload = limit
+	}
+	if v := os.Getenv("DFS_DOWNLOAD_LIMIT"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("DFS_DOWNLOAD_LIMIT: %w", err)
+		}
+		c.Network.Bandwidth.Download = limit
+	}
+	if v := os.Getenv("DFS_BOOTSTRAP_PEERS"); v != "" {
+		c.Network.BootstrapPeers = splitList(v)
+	}
+	if v := os.Getenv("DFS_DHT_MODE"); v != "" {
+		c.Network.DHTMode = v
+	}
+	if v := os.Getenv("DFS_IDENTITY"); v != "" {
+		c.Network.IdentityPath = v
+	}
+	if v := os.Getenv("DFS_SWARM_KEY"); v != "" {
+		c.Network.SwarmKey = v
+	}
+	if v := os.Getenv("DFS_EXTERNAL_ADDR"); v != "" {
+		c.Network.ExternalAddr = v
+	}
+	if v := os.Getenv("DFS_PROXY"); v != "" {
+		c.Network.Proxy = v
+	}
+	if v := os.Getenv("DFS_STORAGE_PATH"); v != "" {
+		c.Storage.Path = v
+	}
+	if v := os.Getenv("DFS_READ_ONLY"); v != "" {
+		readOnly, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("DFS_READ_ONLY: %w", err)
+		}
+		c.Storage.ReadOnly = readOnly
+	}
+	if v := os.Getenv("DFS_STANDBY"); v != "" {
+		standby, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("DFS_STANDBY: %w", err)
+		}
+		c.Storage.Standby = standby
+	}
+	if v := os.Getenv("DFS_API"); v != "" {
+		c.API.Socket = v
+	}
+	if v := os.Getenv("DFS_METRICS_ADDR"); v != "" {
+		c.Metrics.Addr = v
+	}
+	if v := os.Getenv("DFS_GATEWAY_ADDR"); v != "" {
+		c.Gateway.Addr = v
+	}
+	if v := os.Getenv("DFS_LOG_LEVEL"); v != "" {
+		c.Logging.Level = v
+	}
+	if v := os.Getenv("DFS_EVENT_LOG"); v != "" {
+		c.Logging.EventLog = v
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Network.Port < 0 || c.Network.Port > 65535 {
+		return fmt.Errorf("network.port: %d out of range", c.Network.Port)
+	}
+	if !c.Network.IPv4 && !c.Network.IPv6 {
+		return fmt.Errorf("network.ipv6: can't be off when ipv4 is off too")
+	}
+
+	if c.API.TCPAddr != "" {
+		if err := CheckAPIAddr(c.API.TCPAddr); err != nil {
+			return fmt.Errorf("api.tcp_addr: %w", err)
+		}
+	}
+
+	if c.Storage.Standby && c.Storage.ReadOnly {
+		return fmt.Errorf("storage.standby: can't be used with storage.read_only")
+	}
+
+	if c.Chunking.ChunkSize <= 0 {
+		return fmt.Errorf("chunking.chunk_size: must be positive, got %d", c.Chunking.ChunkSize)
+	}
+	if err := c.Chunking.Params().Validate(); err != nil {
+		return fmt.Errorf("chunking: %w", err)
+	}
+
+	if c.Replication.Factor < 1 {
+		return fmt.Errorf("replication.factor: must be at least 1, got %d", c.Replication.Factor)
+	}
+	if c.Replication.Interval <= 0 {
+		return fmt.Errorf("replication.interval: must be positive")
+	}
+	if err := c.Replication.Policy.validate(); err != nil {
+		return fmt.Errorf("replication.policy.%w", err)
+	}
+	if c.Push.MaxSize < 0 {
+		return fmt.Errorf("push.max_size: must not be negative")
+	}
+	if c.Push.Capacity < 0 {
+		return fmt.Errorf("push.capacity: must not be negative")
+	}
+	for _, s := range c.Push.Peers {
+		if _, err := peer.Decode(s); err != nil {
+			return fmt.Errorf("push.peers: %q: %w", s, err)
+		}
+	}
+	if c.Replication.Donation.Capacity < 0 {
+		return fmt.Errorf("replication.donation.capacity: must not be negative")
+	}
+	if _, err := replication.ParseWindow(c.Replication.Donation.Window); err != nil {
+		return fmt.Errorf("replication.donation.%w", err)
+	}
+	for name, class := range c.Replication.Classes {
+		if !className.MatchString(name) {
+			return fmt.Errorf("replication.classes: invalid class name %q: use letters, digits and . _ -", name)
+		}
+		if err := class.validate(); err != nil {
+			return fmt.Errorf("replication.classes.%s.%w", name, err)
+		}
+	}
+	if _, err := c.Replication.ReplicationRules(); err != nil {
+		return err
+	}
+
+	if c.GC.Interval < 0 {
+		return fmt.Errorf("gc.interval: must not be negative")
+	}
+	if c.GC.GracePeriod < 0 {
+		return fmt.Errorf("gc.grace_period: must not be negative")
+	}
+
+	if c.Gateway.URL != "" {
+		u, err := url.Parse(c.Gateway.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("gateway.url: %q is not an http or https URL", c.Gateway.URL)
+		}
+	}
+
+	if c.Archive.Path != "" && c.Archive.S3 != "" {
+		return fmt.Errorf("archive.s3: can't be used with archive.path")
+	}
+	if c.Archive.S3 != "" {
+		if _, _, err := coldstore.ParseS3(c.Archive.S3); err != nil {
+			return fmt.Errorf("archive.s3: %w", err)
+		}
+	}
+	switch {
+	case c.Archive.StorageClass != "" && c.Archive.S3 == "":
+		return fmt.Errorf("archive.storage_class: only applies to archive.s3")
+	case c.Archive.StorageClass == "GLACIER", c.Archive.StorageClass == "DEEP_ARCHIVE":
+		return fmt.Errorf("archive.storage_class: %s objects can't be read without a restore, use GLACIER_IR", c.Archive.StorageClass)
+	}
+
+	if c.Names.Lifetime < 0 {
+		return fmt.Errorf("names.lifetime: must not be negative")
+	}
+	if c.Names.RepublishInterval < 0 {
+		return fmt.Errorf("names.republish_interval: must not be negative")
+	}
+	lifetime, republish := c.Names.Lifetime, c.Names.RepublishInterval
+	if lifetime == 0 {
+		lifetime = network.DefaultNameLifetime
+	}
+	if republish == 0 {
+		republish = network.DefaultNameRepublishInterval
+	}
+	if republish >= lifetime {
+		return fmt.Errorf("names.republish_interval: %s must be below the lifetime %s", republish, lifetime)
+	}
+
+	if c.Network.ConnLow < 0 {
+		return fmt.Errorf("network.conn_low: must not be negative")
+	}
+	if c.Network.ConnHigh < 0 {
+		return fmt.Errorf("network.conn_high: must not be negative")
+	}
+	low, high := c.Network.ConnLow, c.Network.ConnHigh
+	if low == 0 {
+		low = network.DefaultConnLow
+	}
+	if high == 0 {
+		high = network.DefaultConnHigh
+	}
+	if low > high {
+		return fmt.Errorf("network.conn_low: %d is above conn_high %d", low, high)
+	}
+
+	bw := c.Network.Bandwidth
+	for _, limit := range []struct {
+		key   string
+		value int64
+	}{
+		{"upload", bw.Upload},
+		{"download", bw.Download},
+		{"peer_upload", bw.PeerUpload},
+		{"peer_download", bw.PeerDownload},
+	} {
+		if limit.value < 0 {
+			return fmt.Errorf("network.bandwidth.%s: must not be negative", limit.key)
+		}
+	}
+
+	for _, pool := range []struct {
+		key string
+		StreamPoolConfig
+	}{
+		{"block", c.Network.Streams.Block},
+		{"want", c.Network.Streams.Want},
+		{"replicate", c.Network.Streams.Replicate},
+		{"diff", c.Network.Streams.Diff},
+		{"select", c.Network.Streams.Select},
+		{"push", c.Network.Streams.Push},
+	} {
+		if pool.Workers < 0 {
+			return fmt.Errorf("network.streams.%s.workers: must not be negative", pool.key)
+		}
+		if pool.Queue < 0 {
+			return fmt.Errorf("network.streams.%s.queue: must not be negative", pool.key)
+		}
+	}
+
+	if c.Pressure.HeapLimit < 0 {
+		return fmt.Errorf("pressure.heap_limit: must not be negative")
+	}
+	if c.Pressure.FDLimit < 0 {
+		return fmt.Errorf("pressure.fd_limit: must not be negative")
+	}
+	if c.Pressure.DiskQueue < 0 {
+		return fmt.Errorf("pressure.disk_queue: must not be negative")
+	}
+	if c.Pressure.Interval < 0 {
+		return fmt.Errorf("pressure.interval: must not be negative")
+	}
+	for _, objective := range []struct {
+		key   string
+		value float64
+	}{
+		{"dht", c.Health.DHT},
+		{"dial", c.Health.Dial},
+		{"fetch", c.Health.Fetch},
+	} {
+		if objective.value < 0 || objective.value >= 1 {
+			return fmt.Errorf("health.%s: must be at least 0 and below 1, got %v", objective.key, objective.value)
+		}
+	}
+
+	if c.Network.ReprovideInterval < 0 {
+		return fmt.Errorf("network.reprovide_interval: must not be negative")
+	}
+	if c.Network.ExternalAddr != "" {
+		if _, err := network.ParseExternalAddr(c.Network.ExternalAddr); err != nil {
+			return fmt.Errorf("network.external_addr: %w", err)
+		}
+	}
+	if c.Network.Proxy != "" {
+		if _, _, err := net.SplitHostPort(c.Network.Proxy); err != nil {
+			return fmt.Errorf("network.proxy: %w", err)
+		}
+		// Both announce the node's own address
+		if c.Network.ExternalAddr != "" {
+			return fmt.Errorf("network.external_addr: can't be used with network.proxy")
+		}
+		if c.Network.RelayServer {
+			return fmt.Errorf("network.relay_server: can't be used with network.proxy")
+		}
+	}
+	if c.Network.Onion.Control != "" {
+		if c.Network.Proxy == "" {
+			return fmt.Errorf("network.onion.control: needs network.proxy, to reach other onion addresses")
+		}
+		if _, _, err := net.SplitHostPort(c.Network.Onion.Control); err != nil {
+			return fmt.Errorf("network.onion.control: %w", err)
+		}
+	}
+	for i, s := range c.Network.BootstrapPeers {
+		if _, err := peer.AddrInfoFromString(s); err != nil {
+			return fmt.Errorf("network.bootstrap_peers[%d]: %q: %w", i, s, err)
+		}
+	}
+	for _, s := range c.Network.StaticRelays {
+		if _, err := peer.AddrInfoFromString(s); err != nil {
+			return fmt.Errorf("network.static_relays: %q: %w", s, err)
+		}
+	}
+
+	for _, s := range c.Network.Allow {
+		if _, _, err := network.ParseGateRule(s); err != nil {
+			return fmt.Errorf("network.allow: %w", err)
+		}
+	}
+	for _, s := range c.Network.Deny {
+		if _, _, err := network.ParseGateRule(s); err != nil {
+			return fmt.Errorf("network.deny: %w", err)
+		}
+	}
+
+	switch c.Network.DHTMode {
+	case DHTOff, DHTClient, DHTServer, DHTAuto:
+	default:
+		return fmt.Errorf("network.dht_mode: unknown mode %q", c.Network.DHTMode)
+	}
+
+	return nil
+}
+
+// ReplicationClasses returns the replication classes by name.
+func (c ReplicationConfig) ReplicationClasses() map[string]replication.Class {
+	classes := make(map[string]replication.Class, len(c.Classes))
+	for name, class := range c.Classes {
+		classes[name] = replication.Class{Replicas: class.Replicas, Zones: class.Zones}
+	}
+	return classes
+}
+
+// ReplicationRules parses the replication rules.
+func (c ReplicationConfig) ReplicationRules() ([]replication.Rule, error) {
+	rules := make([]replication.Rule, len(c.Rules))
+	for i, r := range c.Rules {
+		sel, err := pin.ParseSelector(r.Label)
+		if err != nil {
+			return nil, fmt.Errorf("replication.rules[%d].label: %w", i, err)
+		}
+		switch {
+		case r.Class != "" && r.Replicas != 0:
+			return nil, fmt.Errorf("replication.rules[%d]: set either class or replicas", i)
+		case r.Class != "":
+			if _, ok := c.Classes[r.Class]; !ok {
+				return nil, fmt.Errorf("replication.rules[%d].class: no class %q in replication.classes", i, r.Class)
+			}
+		case r.Replicas < 1:
+			return nil, fmt.Errorf("replication.rules[%d].replicas: must be at least 1, got %d", i, r.Replicas)
+		}
+		rules[i] = replication.Rule{Selector: sel, Class: r.Class, Replicas: r.Replicas}
+	}
+	return rules, nil
+}
+
+func (c ReplicationClass) validate() error {
+	if c.Replicas < 0 {
+		return fmt.Errorf("replicas: must not be negative")
+	}
+	if c.Zones < 0 {
+		return fmt.Errorf("zones: must not be negative")
+	}
+	if c.Zones > max(c.Replicas, 1) {
+		return fmt.Errorf("zones: can't exceed the %d replicas", c.Replicas)
+	}
+	return nil
+}
+
+func (p ReplicationPolicy) validate() error {
+	if p.MaxSize < 0 {
+		return fmt.Errorf("max_size: must not be negative")
+	}
+	if p.MaxBytesPerPeer < 0 {
+		return fmt.Errorf("max_bytes_per_peer: must not be negative")
+	}
+	for _, s := range p.Peers {
+		if _, err := peer.Decode(s); err != nil {
+			return fmt.Errorf("peers: %q: %w", s, err)
+		}
+	}
+	for _, t := range p.ContentTypes {
+		if !strings.Contains(t, "/") {
+			return fmt.Errorf("content_types: %q is not a media type", t)
+		}
+	}
+	return nil
+}
+
+// Resolve returns p relative to the data dir unless it is absolute.
+func (c *Config) Resolve(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	if strings.HasPrefix(p, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, p[2:])
+		}
+	}
+	return filepath.Join(c.DataDir, p)
+}
+
+func (c *Config) IdentityPath() string {
+	return c.Resolve(c.Network.IdentityPath)
+}
+
+// MasterKeyPath is the key wrapping the keys of encrypted files.
+func (c *Config) MasterKeyPath() string {
+	return c.Resolve(c.Encryption.MasterKey)
+}
+
+// KeystorePath is the keystore, which may not exist.
+func (c *Config) KeystorePath() string {
+	return c.Resolve(c.Keystore.Path)
+}
+
+// PassphrasePath is the file holding the keystore passphrase, empty when
+// it is asked for.
+func (c *Config) PassphrasePath() string {
+	return c.Resolve(c.Keystore.PassphraseFile)
+}
+
+// GCHistoryPath is where garbage collections are recorded.
+func (c *Config) GCHistoryPath() string {
+	return filepath.Join(c.DataDir, "gc-history.jsonl")
+}
+
+// NamesPath holds named keys and the records of published names.
+func (c *Config) NamesPath() string {
+	return filepath.Join(c.DataDir, "names")
+}
+
+// DownloadsPath lists the downloads to resume after a restart.
+func (c *Config) DownloadsPath() string {
+	return filepath.Join(c.DataDir, "downloads.json")
+}
+
+// InboxPath lists what peers pushed that waits to be accepted.
+func (c *Config) InboxPath() string {
+	return filepath.Join(c.DataDir, "inbox.json")
+}
+
+// ChunkIndexPath holds the chunk checksums of files added with aligned
+// chunking, see node.ChunkIndex.
+func (c *Config) ChunkIndexPath() string {
+	return filepath.Join(c.DataDir, "chunk-index")
+}
+
+// SharesPath keeps the capabilities redeemed with "dfs share redeem".
+func (c *Config) SharesPath() string {
+	return filepath.Join(c.DataDir, "shares.json")
+}
+
+// GroupsPath keeps the groups created with "dfs group create" or joined
+// with "dfs group join".
+func (c *Config) GroupsPath() string {
+	return filepath.Join(c.DataDir, "groups.json")
+}
+
+// SyncPairsPath keeps the directories "dfs sync" keeps in step with a name.
+func (c *Config) SyncPairsPath() string {
+	return filepath.Join(c.DataDir, "sync-pairs.json")
+}
+
+// ImportsPath keeps the state of "dfs import" runs, so interrupted ones
+// resume.
+func (c *Config) ImportsPath() string {
+	return filepath.Join(c.DataDir, "imports.json")
+}
+
+// BackupsPath keeps the catalog of every backup series "dfs backup export"
+// writes, what the next delta is made against.
+func (c *Config) BackupsPath() string {
+	return filepath.Join(c.DataDir, "backups")
+}
+
+// SuccessionsPath keeps the continuity records of rotated identities.
+func (c *Config) SuccessionsPath() string {
+	return filepath.Join(c.DataDir, "successions.json")
+}
+
+// RevocationsPath keeps the revocations of node keys.
+func (c *Config) RevocationsPath() string {
+	return filepath.Join(c.DataDir, "revocations.json")
+}
+
+// OnionKeyPath is where the onion service key is kept.
+func (c *Config) OnionKeyPath() string {
+	return filepath.Join(c.DataDir, "onion.key")
+}
+
+// SwarmKeyPath is the private network key, or "" on the public network.
+func (c *Config) SwarmKeyPath() string {
+	return c.Resolve(c.Network.SwarmKey)
+}
+
+func (c *Config) StoragePath() string {
+	return c.Resolve(c.Storage.Path)
+}
+
+func (c *Config) PinsPath() string {
+	return c.Resolve(c.Storage.Pins)
+}
+
+// APISocketPath is the control socket. A read-only repo may sit on
+// read-only media or belong to another daemon, so a socket inside the data
+// dir is then moved to the per-user socket directory, under a name telling
+// the repos apart: readonly-<hash of the data dir>-<name>.
+func (c *Config) APISocketPath() string {
+	path := c.Resolve(c.API.Socket)
+	dataDir := filepath.Clean(c.DataDir)
+	if c.Storage.ReadOnly && path != "" && strings.HasPrefix(path, dataDir+string(filepath.Separator)) {
+		if abs, err := filepath.Abs(dataDir); err == nil {
+			dataDir = abs
+		}
+		sum := sha256.Sum256([]byte(dataDir))
+		return filepath.Join(rundir.Dir(), fmt.Sprintf("readonly-%x-%s", sum[:6], filepath.Base(path)))
+	}
+	return path
+}
+
+// HandoffSocketPath is where a daemon started with --takeover receives the
+// API listeners of the daemon it replaces, in the per-user socket
+// directory.
+func (c *Config) HandoffSocketPath() string {
+	return filepath.Join(rundir.Dir(), fmt.Sprintf("handoff-%d.sock", os.Getpid()))
+}
+
+// CheckAPIAddr refuses a TCP address for the API unless it is on a
+// loopback interface. Anyone who can reach the API can pin, remove and
+// collect content and use the node's keys.
+func CheckAPIAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s is not a loopback address, and the API is not authenticated", addr)
+	}
+	return nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
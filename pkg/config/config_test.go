@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// writeConfig writes a config file and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestPrecedence checks each layer overrides the one before: defaults,
+// the file, DFS_* variables, then flags set on the command line.
+func TestPrecedence(t *testing.T) {
+	file := writeConfig(t, "network:\n  port: 9100\n  dht_mode: client\nlogging:\n  level: warn\n")
+
+	for _, tt := range []struct {
+		name  string
+		file  bool
+		env   map[string]string
+		args  []string
+		port  int
+		dht   string
+		level string
+	}{
+		{name: "defaults", port: 9000, dht: DHTOff, level: "debug"},
+		{name: "file", file: true, port: 9100, dht: DHTClient, level: "warn"},
+		{
+			name: "env over file", file: true,
+			env:  map[string]string{"DFS_PORT": "9200", "DFS_LOG_LEVEL": "info"},
+			port: 9200, dht: DHTClient, level: "info",
+		},
+		{
+			name: "flags over env", file: true,
+			env:  map[string]string{"DFS_PORT": "9200", "DFS_DHT_MODE": "server"},
+			args: []string{"--port", "9300", "--log-level", "error"},
+			port: 9300, dht: DHTServer, level: "error",
+		},
+		{
+			name: "flags over defaults",
+			args: []string{"--dht", "auto"},
+			port: 9000, dht: DHTAuto, level: "debug",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Keep the default config file and the caller's DFS_* out
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("HOME", t.TempDir())
+			for _, kv := range os.Environ() {
+				if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "DFS_") {
+					t.Setenv(name, "")
+				}
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			AddFlags(fs)
+			AddOverrideFlags(fs)
+			args := tt.args
+			if tt.file {
+				args = append([]string{"--config", file}, args...)
+			}
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadFromFlags(fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Network.Port != tt.port || cfg.Network.DHTMode != tt.dht || cfg.Logging.Level != tt.level {
+				t.Errorf("port %d, dht %q, level %q; want %d, %q, %q",
+					cfg.Network.Port, cfg.Network.DHTMode, cfg.Logging.Level, tt.port, tt.dht, tt.level)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("DFS_PORT", "")
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing explicit config file accepted")
+	}
+	if _, err := Load(""); err != nil {
+		t.Errorf("missing default config file: %v", err)
+	}
+	if _, err := Load(writeConfig(t, "network:\n  port: 70000\n")); err == nil {
+		t.Error("invalid port in the file accepted")
+	}
+
+	t.Setenv("DFS_PORT", "ninety")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "DFS_PORT") {
+		t.Errorf("DFS_PORT=ninety: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		change func(*Config)
+		key    string
+	}{
+		{"port", func(c *Config) { c.Network.Port = -1 }, "network.port"},
+		{"no address family", func(c *Config) { c.Network.IPv4, c.Network.IPv6 = false, false }, "network.ipv6"},
+		{"dht mode", func(c *Config) { c.Network.DHTMode = "sever" }, "network.dht_mode"},
+		{"bootstrap peer", func(c *Config) { c.Network.BootstrapPeers = []string{"/ip4/1.2.3.4/tcp/1"} }, "network.bootstrap_peers[0]"},
+		{"connection limits", func(c *Config) { c.Network.ConnLow, c.Network.ConnHigh = 50, 10 }, "network.conn_low"},
+		{"factor", func(c *Config) { c.Replication.Factor = 0 }, "replication.factor"},
+		{"class zones", func(c *Config) { c.Replication.Classes = map[string]ReplicationClass{"gold": {Replicas: 2, Zones: 3}} }, "replication.classes.gold.zones"},
+		{"rule class", func(c *Config) { c.Replication.Rules = []ReplicationRule{{Label: "tier=gold", Class: "gold"}} }, "replication.rules[0].class"},
+		{"push peer", func(c *Config) { c.Push.Peers = []string{"12D3Koo"} }, "push.peers"},
+		{"name lifetimes", func(c *Config) { c.Names.Lifetime, c.Names.RepublishInterval = 1, 2 }, "names.republish_interval"},
+		{"api on all interfaces", func(c *Config) { c.API.TCPAddr = ":5001" }, "api.tcp_addr"},
+		{"api on a public address", func(c *Config) { c.API.TCPAddr = "192.0.2.1:5001" }, "api.tcp_addr"},
+		{"standby read-only", func(c *Config) { c.Storage.Standby, c.Storage.ReadOnly = true, true }, "storage.standby"},
+		{"archive path and s3", func(c *Config) { c.Archive.Path, c.Archive.S3 = "cold", "s3://bucket" }, "archive.s3"},
+		{"archive storage class", func(c *Config) { c.Archive.S3, c.Archive.StorageClass = "s3://bucket", "DEEP_ARCHIVE" }, "archive.storage_class"},
+		{"gateway url", func(c *Config) { c.Gateway.URL = "example.com/dfs" }, "gateway.url"},
+		{"onion without proxy", func(c *Config) { c.Network.Onion.Control = "127.0.0.1:9051" }, "network.onion.control"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(cfg)
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatal("accepted")
+			}
+			if key, _, _ := strings.Cut(err.Error(), ": "); key != tt.key {
+				t.Errorf("error %q, want it about %s", err, tt.key)
+			}
+		})
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Errorf("defaults: %v", err)
+	}
+	for _, addr := range []string{"127.0.0.1:5001", "[::1]:5001", "localhost:5001"} {
+		cfg := Default()
+		cfg.API.TCPAddr = addr
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("api.tcp_addr %s: %v", addr, err)
+		}
+	}
+}
+
+func TestGatewayPublicURL(t *testing.T) {
+	for _, tt := range []struct {
+		gateway GatewayConfig
+		want    string
+	}{
+		{GatewayConfig{}, ""},
+		{GatewayConfig{Addr: "127.0.0.1:8080"}, "http://127.0.0.1:8080"},
+		{GatewayConfig{Addr: ":8080"}, "http://localhost:8080"},
+		{GatewayConfig{Addr: "0.0.0.0:8080", URL: "https://dfs.example.com/"}, "https://dfs.example.com"},
+	} {
+		if got := tt.gateway.PublicURL(); got != tt.want {
+			t.Errorf("%+v: PublicURL() = %q, want %q", tt.gateway, got, tt.want)
+		}
+	}
+}
+
+// Read-only daemons serving different repos each get their own socket.
+func TestReadOnlySocketPath(t *testing.T) {
+	socket := func(dataDir string) string {
+		cfg := Default()
+		cfg.DataDir = dataDir
+		cfg.Storage.ReadOnly = true
+		return cfg.APISocketPath()
+	}
+	a, b := socket("/srv/a/.dfs"), socket("/srv/b/.dfs")
+	if a == b {
+		t.Errorf("repos share the socket %s", a)
+	}
+	if a != socket("/srv/a/.dfs/") {
+		t.Errorf("the same repo got different sockets")
+	}
+	if !strings.HasPrefix(filepath.Base(a), "readonly-") || !strings.HasSuffix(a, filepath.Base(Default().API.Socket)) {
+		t.Errorf("socket %s", a)
+	}
+}
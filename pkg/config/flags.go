@@ -0,0 +1,84 @@
+package config
+
+import (
+	"github.com/spf13/pflag"
+)
+
+// AddFlags registers the --config flag on fs.
+func AddFlags(fs *pflag.FlagSet) {
+	fs.String("config", "", "path to the config file (default "+DefaultPath()+")")
+}
+
+// AddOverrideFlags registers flags that override individual settings.
+func AddOverrideFlags(fs *pflag.FlagSet) {
+	fs.String("data-dir", "", "directory holding node state")
+	fs.Int("port", 0, "listen port")
+	fs.Bool("ipv4", true, "listen on IPv4")
+	fs.Bool("ipv6", true, "listen on IPv6")
+	fs.Bool("quic", true, "accept QUIC connections")
+	fs.StringSlice("bootstrap", nil, "bootstrap peer multiaddrs")
+	fs.String("dht", "", "DHT mode: off, client, server or auto")
+	fs.Bool("relay-server", false, "relay connections for peers behind NAT (public nodes only)")
+	fs.Int64("upload-limit", 0, "cap on blocks sent to peers, in bytes per second (0 is unlimited)")
+	fs.Int64("download-limit", 0, "cap on blocks fetched from peers, in bytes per second (0 is unlimited)")
+	fs.String("storage", "", "block storage path")
+	fs.Bool("read-only", false, "open the repo read-only, keeping new data in memory")
+	fs.Bool("standby", false, "wait for the daemon using the repo to stop, then take over")
+	fs.String("log-level", "", "log level")
+}
+
+// LoadFromFlags loads the config named by --config and applies every
+// override flag that was registered and set explicitly.
+func LoadFromFlags(fs *pflag.FlagSet) (*Config, error) {
+	path, _ := fs.GetString("config")
+
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.Changed("data-dir") {
+		cfg.DataDir, _ = fs.GetString("data-dir")
+	}
+	if fs.Changed("port") {
+		cfg.Network.Port, _ = fs.GetInt("port")
+	}
+	if fs.Changed("ipv4") {
+		cfg.Network.IPv4, _ = fs.GetBool("ipv4")
+	}
+	if fs.Changed("ipv6") {
+		cfg.Network.IPv6, _ = fs.GetBool("ipv6")
+	}
+	if fs.Changed("quic") {
+		cfg.Network.QUIC, _ = fs.GetBool("quic")
+	}
+	if fs.Changed("bootstrap") {
+		cfg.Network.BootstrapPeers, _ = fs.GetStringSlice("bootstrap")
+	}
+	if fs.Changed("dht") {
+		cfg.Network.DHTMode, _ = fs.GetString("dht")
+	}
+	if fs.Changed("relay-server") {
+		cfg.Network.RelayServer, _ = fs.GetBool("relay-server")
+	}
+	if fs.Changed("upload-limit") {
+		cfg.Network.Bandwidth.Upload, _ = fs.GetInt64("upload-limit")
+	}
+	if fs.Changed("download-limit") {
+		cfg.Network.Bandwidth.Download, _ = fs.GetInt64("download-limit")
+	}
+	if fs.Changed("storage") {
+		cfg.Storage.Path, _ = fs.GetString("storage")
+	}
+	if fs.Changed("read-only") {
+		cfg.Storage.ReadOnly, _ = fs.GetBool("read-only")
+	}
+	if fs.Changed("standby") {
+		cfg.Storage.Standby, _ = fs.GetBool("standby")
+	}
+	if fs.Changed("log-level") {
+		cfg.Logging.Level, _ = fs.GetString("log-level")
+	}
+
+	return cfg, cfg.Validate()
+}
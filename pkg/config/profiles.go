@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Profiles are starting points for common deployments, applied on top of
+// the defaults by `dfs init --profile`.
+var Profiles = map[string]func(*Config){
+	// A publicly reachable node that helps others: it serves the DHT,
+	// relays for peers behind NAT and keeps many connections.
+	"server": func(c *Config) {
+		c.Network.DHTMode = DHTServer
+		c.Network.RelayServer = true
+		c.Network.ConnLow = 600
+		c.Network.ConnHigh = 900
+		c.GC.Interval = time.Hour
+		c.Metrics.Addr = "127.0.0.1:9090"
+		c.Logging.Level = "info"
+	},
+	// A machine behind NAT that is not always on: it uses the DHT without
+	// serving it, gets reached through relays and does little background
+	// work.
+	"desktop": func(c *Config) {
+		c.Network.DHTMode = DHTClient
+		c.Network.AutoRelay = true
+		c.Network.HolePunching = true
+		c.Network.ConnLow = 32
+		c.Network.ConnHigh = 96
+		c.Network.ReprovideInterval = 22 * time.Hour
+		c.Replication.Interval = 30 * time.Minute
+		c.GC.Interval = 24 * time.Hour
+		c.Logging.Level = "info"
+	},
+	// A throwaway node for tests and local clusters: no DHT, quick
+	// replication and collection, and quiet logs.
+	"test": func(c *Config) {
+		c.Network.DHTMode = DHTOff
+		c.Replication.Interval = 10 * time.Second
+		c.GC.GracePeriod = 0
+		c.Logging.Level = "error"
+	},
+}
+
+// ProfileNames lists the profiles in order.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile changes c as the named profile does.
+func (c *Config) ApplyProfile(name string) error {
+	apply, ok := Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q (want one of %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	apply(c)
+	return nil
+}
+
+// Write saves c as a config file at path. An existing file is only
+// replaced when overwrite is set.
+func (c *Config) Write(path string, overwrite bool) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting is one key of the config file.
+type Setting struct {
+	// Key is the dotted path of the setting. Items of a list are written
+	// key[].field, entries of a map key.<name>.field.
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	// Default is the value used when the key is left out, as it would be
+	// written in the file. Empty for list items and map entries.
+	Default string `json:"default,omitempty"`
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Schema lists the settings the config file accepts, in the order of the
+// Config struct, which is the schema: Check reports keys it doesn't have.
+func Schema() []Setting {
+	var settings []Setting
+	schema(reflect.TypeOf(Config{}), reflect.ValueOf(*Default()), "", &settings)
+	return settings
+}
+
+// schema adds the settings of the struct type t to settings, with the
+// defaults in v. List items and map entries have none, and no v.
+func schema(t reflect.Type, v reflect.Value, prefix string, settings *[]Setting) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		key := prefix + tag
+
+		var value reflect.Value
+		if v.IsValid() {
+			value = v.Field(i)
+		}
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			schema(f.Type, value, key+".", settings)
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct:
+			*settings = append(*settings, Setting{Key: key, Type: "list", Default: formatDefault(value)})
+			schema(f.Type.Elem(), reflect.Value{}, key+"[].", settings)
+		case f.Type.Kind() == reflect.Map && f.Type.Elem().Kind() == reflect.Struct:
+			*settings = append(*settings, Setting{Key: key, Type: "map", Default: formatDefault(value)})
+			schema(f.Type.Elem(), reflect.Value{}, key+".<name>.", settings)
+		default:
+			*settings = append(*settings, Setting{Key: key, Type: typeName(f.Type), Default: formatDefault(value)})
+		}
+	}
+}
+
+func typeName(t reflect.Type) string {
+	if t == durationType {
+		return "duration"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int64:
+		return "int"
+	case reflect.Float64:
+		return "float"
+	case reflect.Slice:
+		return "list of " + typeName(t.Elem())
+	}
+	return t.Kind().String()
+}
+
+func formatDefault(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Slice, reflect.Map:
+		if v.Len() == 0 {
+			if v.Kind() == reflect.Map {
+				return "{}"
+			}
+			return "[]"
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.yaml.in/yaml/v2"
+)
+
+func TestSchema(t *testing.T) {
+	settings := make(map[string]Setting)
+	for _, s := range Schema() {
+		if _, ok := settings[s.Key]; ok {
+			t.Errorf("%s listed twice", s.Key)
+		}
+		settings[s.Key] = s
+	}
+
+	for _, want := range []Setting{
+		{Key: "network.port", Type: "int", Default: "9000"},
+		{Key: "network.dht_mode", Type: "string", Default: `"off"`},
+		{Key: "network.bootstrap_peers", Type: "list of string", Default: "[]"},
+		{Key: "network.streams.want.workers", Type: "int", Default: "0"},
+		{Key: "gc.grace_period", Type: "duration", Default: "1h0m0s"},
+		{Key: "health.dht", Type: "float", Default: "0"},
+		{Key: "replication.classes", Type: "map", Default: "{}"},
+		{Key: "replication.classes.<name>.zones", Type: "int"},
+		{Key: "replication.rules", Type: "list", Default: "[]"},
+		{Key: "replication.rules[].label", Type: "string"},
+	} {
+		if got := settings[want.Key]; got != want {
+			t.Errorf("%s = %+v, want %+v", want.Key, got, want)
+		}
+	}
+}
+
+// TestSchemaMatchesCheck writes the defaults out as a file: Check must
+// know every key, and the schema must list every one.
+func TestSchemaMatchesCheck(t *testing.T) {
+	data, err := yaml.Marshal(Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := Check(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("defaults have problems: %v", problems)
+	}
+
+	var written map[string]any
+	if err := yaml.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]bool)
+	for _, s := range Schema() {
+		keys[s.Key] = true
+	}
+	var walk func(m map[any]any, prefix string)
+	walk = func(m map[any]any, prefix string) {
+		for k, v := range m {
+			key := prefix + k.(string)
+			if sub, ok := v.(map[any]any); ok && !keys[key] {
+				walk(sub, key+".")
+				continue
+			}
+			if !keys[key] {
+				t.Errorf("%s missing from the schema", key)
+			}
+		}
+	}
+	for k, v := range written {
+		if sub, ok := v.(map[any]any); ok {
+			walk(sub, k+".")
+		} else if !keys[k] {
+			t.Errorf("%s missing from the schema", k)
+		}
+	}
+}
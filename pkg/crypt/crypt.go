@@ -1 +1,230 @@
+// Package crypt encrypts file content before it is stored, so the peers
+// holding an encrypted file's blocks never see what is in it.
+//
+// Every file gets its own random key, which seals its chunks with
+// XChaCha20-Poly1305. The file key is kept in the file's manifest, wrapped
+// by the master key of the user who added it; only nodes holding that
+// master key can read the file. Chunk nonces are derived from the chunk
+// and the file key, so sealing is deterministic: the same file key seals
+// the same chunk to the same block, which lets a local copy be checked
+// against a stored file without decrypting it.
 package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher names the cipher sealing chunks and file keys, as recorded in
+// manifests.
+const Cipher = "xchacha20-poly1305"
+
+// Overhead is what sealing adds to a chunk: the nonce and the tag.
+const Overhead = chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+
+var (
+	// ErrDecrypt is returned for sealed data that was altered or sealed
+	// with another key.
+	ErrDecrypt = errors.New("crypt: message authentication failed")
+	// ErrWrongKey is returned when unwrapping a file key wrapped by another
+	// master key.
+	ErrWrongKey = errors.New("crypt: encrypted with another master key")
+)
+
+// Labels keep the keys derived from one key apart.
+const (
+	keyIDLabel   = "dfs master key id"
+	nonceLabel   = "dfs chunk nonce"
+	wrapAADLabel = "dfs file key"
+)
+
+// MasterKey wraps the keys of the files a user adds.
+type MasterKey struct {
+	key []byte
+	id  string
+}
+
+// LoadOrCreateMasterKey reads the master key at path, generating and
+// storing a new one on first use.
+func LoadOrCreateMasterKey(path string) (*MasterKey, error) {
+	m, err := LoadMasterKey(path)
+	if err == nil {
+		return m, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	m, err = GenerateMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := fsutil.AtomicWrite(path, m.Encode(), 0600); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GenerateMasterKey returns a new random master key.
+func GenerateMasterKey() (*MasterKey, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return newMasterKey(key), nil
+}
+
+// LoadMasterKey reads the hex encoded master key at path.
+func LoadMasterKey(path string) (*MasterKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := DecodeMasterKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key %s", path)
+	}
+	return m, nil
+}
+
+// DecodeMasterKey reads a master key in the hex form Encode writes.
+func DecodeMasterKey(data []byte) (*MasterKey, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return nil, errors.New("crypt: invalid master key")
+	}
+	return newMasterKey(key), nil
+}
+
+func newMasterKey(key []byte) *MasterKey {
+	id := derive(key, keyIDLabel)
+	return &MasterKey{key: key, id: hex.EncodeToString(id[:8])}
+}
+
+// ID identifies the key without revealing it, so a node can tell whose key
+// a file was encrypted with.
+func (m *MasterKey) ID() string {
+	return m.id
+}
+
+// Encode returns the key hex encoded, as master key files hold it.
+func (m *MasterKey) Encode() []byte {
+	return []byte(hex.EncodeToString(m.key) + "\n")
+}
+
+// Wrap encrypts k with the master key.
+func (m *MasterKey) Wrap(k *FileKey) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(m.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(k.key)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, k.key, []byte(wrapAADLabel)), nil
+}
+
+// Unwrap decrypts a file key wrapped by the master key with the given ID.
+func (m *MasterKey) Unwrap(keyID string, wrapped []byte) (*FileKey, error) {
+	if keyID != m.id {
+		return nil, fmt.Errorf("%w %s, this node has %s", ErrWrongKey, keyID, m.id)
+	}
+	aead, err := chacha20poly1305.NewX(m.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(wrapAADLabel))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return newFileKey(key)
+}
+
+// FileKey seals the chunks of one file.
+type FileKey struct {
+	key      []byte
+	aead     cipher.AEAD
+	nonceKey [sha256.Size]byte
+}
+
+// NewFileKey generates a random file key.
+func NewFileKey() (*FileKey, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return newFileKey(key)
+}
+
+// DecodeFileKey reads a file key in the hex form Encode writes.
+func DecodeFileKey(data []byte) (*FileKey, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return nil, errors.New("crypt: invalid file key")
+	}
+	return newFileKey(key)
+}
+
+func newFileKey(key []byte) (*FileKey, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &FileKey{key: key, aead: aead, nonceKey: derive(key, nonceLabel)}, nil
+}
+
+// Encode returns the key hex encoded.
+func (k *FileKey) Encode() []byte {
+	return []byte(hex.EncodeToString(k.key) + "\n")
+}
+
+// Seal encrypts a chunk, prefixed by its nonce.
+func (k *FileKey) Seal(data []byte) []byte {
+	mac := hmac.New(sha256.New, k.nonceKey[:])
+	mac.Write(data)
+	nonce := mac.Sum(nil)[:k.aead.NonceSize()]
+
+	out := make([]byte, 0, len(data)+Overhead)
+	out = append(out, nonce...)
+	return k.aead.Seal(out, nonce, data, nil)
+}
+
+// Open decrypts a chunk sealed with Seal.
+func (k *FileKey) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < Overhead {
+		return nil, ErrDecrypt
+	}
+	n := k.aead.NonceSize()
+	data, err := k.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return data, nil
+}
+
+func derive(key []byte, label string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	var out [sha256.Size]byte
+	copy(out[:], mac.Sum(nil))
+	return out
+}
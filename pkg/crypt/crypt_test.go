@@ -0,0 +1,183 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func mustFileKey(t *testing.T) *FileKey {
+	t.Helper()
+	k, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func mustMasterKey(t *testing.T) *MasterKey {
+	t.Helper()
+	m, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// Sealing hides the chunk and is undone by the same key only. The same
+// chunk seals to the same block.
+func TestSealOpen(t *testing.T) {
+	k := mustFileKey(t)
+	data := []byte("the content of a chunk")
+
+	sealed := k.Seal(data)
+	if len(sealed) != len(data)+Overhead {
+		t.Errorf("sealed %d bytes to %d, want %d", len(data), len(sealed), len(data)+Overhead)
+	}
+	if bytes.Contains(sealed, data) {
+		t.Error("sealed chunk holds the plaintext")
+	}
+	if !bytes.Equal(k.Seal(data), sealed) {
+		t.Error("sealing the same chunk twice differs")
+	}
+	if bytes.Equal(k.Seal([]byte("another chunk")), sealed) {
+		t.Error("different chunks seal the same")
+	}
+
+	opened, err := k.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Errorf("Open = %q, want %q", opened, data)
+	}
+}
+
+func TestOpenFails(t *testing.T) {
+	k := mustFileKey(t)
+	sealed := k.Seal([]byte("the content of a chunk"))
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	nonce := bytes.Clone(sealed)
+	nonce[0] ^= 1
+
+	tests := []struct {
+		name   string
+		key    *FileKey
+		sealed []byte
+	}{
+		{"wrong key", mustFileKey(t), sealed},
+		{"tampered", k, tampered},
+		{"tampered nonce", k, nonce},
+		{"truncated", k, sealed[:Overhead-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.key.Open(tt.sealed); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Open: %v, want ErrDecrypt", err)
+			}
+		})
+	}
+}
+
+// A wrapped file key unwraps to one that opens what the original sealed,
+// and never holds the key in clear.
+func TestWrapUnwrap(t *testing.T) {
+	master := mustMasterKey(t)
+	k := mustFileKey(t)
+
+	wrapped, err := master.Wrap(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wrapped, k.key) {
+		t.Error("wrapped key holds the file key")
+	}
+
+	unwrapped, err := master.Unwrap(master.ID(), wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("the content of a chunk")
+	if opened, err := unwrapped.Open(k.Seal(data)); err != nil || !bytes.Equal(opened, data) {
+		t.Errorf("unwrapped key opens to %q, %v", opened, err)
+	}
+}
+
+func TestUnwrapFails(t *testing.T) {
+	master := mustMasterKey(t)
+	other := mustMasterKey(t)
+	wrapped, err := master.Wrap(mustFileKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master.ID() == other.ID() {
+		t.Fatal("two master keys share an ID")
+	}
+
+	tampered := bytes.Clone(wrapped)
+	tampered[len(tampered)-1] ^= 1
+
+	if _, err := other.Unwrap(master.ID(), wrapped); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Unwrap with another master key: %v, want ErrWrongKey", err)
+	}
+	// Claiming the other key's ID gets past the check but not the cipher
+	if _, err := other.Unwrap(other.ID(), wrapped); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Unwrap with another master key's ID: %v, want ErrDecrypt", err)
+	}
+	if _, err := master.Unwrap(master.ID(), tampered); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Unwrap of a tampered key: %v, want ErrDecrypt", err)
+	}
+	if _, err := master.Unwrap(master.ID(), wrapped[:4]); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Unwrap of a truncated key: %v, want ErrDecrypt", err)
+	}
+}
+
+// Keys read back from their encoding are the same keys.
+func TestEncodeDecode(t *testing.T) {
+	master := mustMasterKey(t)
+	decoded, err := DecodeMasterKey(master.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID() != master.ID() {
+		t.Errorf("decoded master key ID = %s, want %s", decoded.ID(), master.ID())
+	}
+
+	k := mustFileKey(t)
+	dk, err := DecodeFileKey(k.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("the content of a chunk")
+	if !bytes.Equal(dk.Seal(data), k.Seal(data)) {
+		t.Error("decoded file key seals differently")
+	}
+
+	for _, bad := range []string{"", "zz", "00ff"} {
+		if _, err := DecodeFileKey([]byte(bad)); err == nil {
+			t.Errorf("DecodeFileKey(%q) succeeded", bad)
+		}
+		if _, err := DecodeMasterKey([]byte(bad)); err == nil {
+			t.Errorf("DecodeMasterKey(%q) succeeded", bad)
+		}
+	}
+}
+
+// The master key is created once and read back after.
+func TestLoadOrCreateMasterKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "master.key")
+	created, err := LoadOrCreateMasterKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOrCreateMasterKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ID() != created.ID() {
+		t.Errorf("loaded key %s, created %s", loaded.ID(), created.ID())
+	}
+}
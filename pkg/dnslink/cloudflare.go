@@ -0,0 +1,150 @@
+package dnslink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const cloudflareTokenEnv = "CLOUDFLARE_API_TOKEN"
+
+// Cloudflare sets records through the Cloudflare API, with a token
+// allowed to edit the DNS of the zone.
+type Cloudflare struct {
+	Token string
+	// BaseURL is the API root. Empty for Cloudflare itself.
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewCloudflareFromEnv reads the API token from CLOUDFLARE_API_TOKEN.
+func NewCloudflareFromEnv() (*Cloudflare, error) {
+	token := os.Getenv(cloudflareTokenEnv)
+	if token == "" {
+		return nil, errors.New("dnslink: no Cloudflare API token; set " + cloudflareTokenEnv)
+	}
+	return &Cloudflare{Token: token}, nil
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// do sends a request to the API path and decodes the result of the
+// response into out.
+func (c *Cloudflare) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	base := c.BaseURL
+	if base == "" {
+		base = "https://api.cloudflare.com/client/v4"
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	u := strings.TrimSuffix(base, "/") + "/" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare: %s: %w", res.Status, err)
+	}
+	if !envelope.Success {
+		if len(envelope.Errors) == 0 {
+			return fmt.Errorf("cloudflare: %s", res.Status)
+		}
+		return fmt.Errorf("cloudflare: %s (code %d)", envelope.Errors[0].Message, envelope.Errors[0].Code)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, out)
+}
+
+// zone returns the ID of the zone name is in: the one named after it or
+// after the closest of its parents.
+func (c *Cloudflare) zone(ctx context.Context, name string) (string, error) {
+	for zone := name; strings.Contains(zone, "."); {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := c.do(ctx, http.MethodGet, "zones", url.Values{"name": {zone}}, nil, &zones); err != nil {
+			return "", fmt.Errorf("dnslink: zone of %s: %w", name, err)
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+		_, zone, _ = strings.Cut(zone, ".")
+	}
+	return "", fmt.Errorf("dnslink: no Cloudflare zone holds %s", name)
+}
+
+func (c *Cloudflare) SetTXT(ctx context.Context, name, value string) error {
+	name = strings.TrimSuffix(name, ".")
+	zone, err := c.zone(ctx, name)
+	if err != nil {
+		return err
+	}
+	records := "zones/" + url.PathEscape(zone) + "/dns_records"
+	var existing []cloudflareRecord
+	if err := c.do(ctx, http.MethodGet, records, url.Values{"type": {"TXT"}, "name": {name}}, nil, &existing); err != nil {
+		return fmt.Errorf("dnslink: records of %s: %w", name, err)
+	}
+
+	// A TTL of 1 is Cloudflare's automatic one
+	record := cloudflareRecord{Type: "TXT", Name: name, Content: value, TTL: 1}
+	// Only a DNSLink is replaced, other records on the name are kept
+	id := ""
+	for _, r := range existing {
+		if Parse([]string{strings.Trim(r.Content, `"`)}) != "" {
+			id = r.ID
+			break
+		}
+	}
+	if id != "" {
+		err = c.do(ctx, http.MethodPut, records+"/"+url.PathEscape(id), nil, record, nil)
+	} else {
+		err = c.do(ctx, http.MethodPost, records, nil, record, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("dnslink: setting TXT on %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Package dnslink points domain names at content with DNSLink: a TXT
+// record on _dnslink.<domain> holding dnslink=/ipns/<name> or
+// dnslink=/dfs/<hash>, which the gateway serves requests for the domain
+// from. The records are set through the API of the DNS provider hosting
+// the domain.
+package dnslink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/aws"
+)
+
+// Record is the name of the TXT record of domain.
+func Record(domain string) string {
+	return "_dnslink." + strings.TrimSuffix(domain, ".")
+}
+
+// Value is the record pointing at path, such as /ipns/<name>.
+func Value(path string) string {
+	return "dnslink=" + path
+}
+
+// Parse returns the content path in the TXT records of a domain, empty
+// when none is a DNSLink.
+func Parse(records []string) string {
+	for _, r := range records {
+		path, ok := strings.CutPrefix(r, "dnslink=")
+		if ok && (strings.HasPrefix(path, "/dfs/") || strings.HasPrefix(path, "/ipns/")) {
+			return strings.TrimSuffix(path, "/")
+		}
+	}
+	return ""
+}
+
+// Provider sets records on the domains a DNS provider hosts.
+type Provider interface {
+	// SetTXT creates or replaces the TXT record on name with one holding
+	// value.
+	SetTXT(ctx context.Context, name, value string) error
+}
+
+// Providers are the names NewProvider accepts.
+var Providers = []string{"cloudflare", "route53"}
+
+// NewProvider returns the named provider, with its credentials read from
+// the environment: CLOUDFLARE_API_TOKEN, or the AWS_* variables.
+func NewProvider(name string) (Provider, error) {
+	switch name {
+	case "cloudflare":
+		return NewCloudflareFromEnv()
+	case "route53":
+		return aws.NewRoute53FromEnv()
+	}
+	return nil, fmt.Errorf("dnslink: unknown provider %q, use one of %s", name, strings.Join(Providers, ", "))
+}
+
+// Set points domain at path.
+func Set(ctx context.Context, p Provider, domain, path string) error {
+	return p.SetTXT(ctx, Record(domain), Value(path))
+}
@@ -0,0 +1,119 @@
+package dnslink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		records []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"v=spf1 -all"}, ""},
+		{[]string{"dnslink=/https/example.com"}, ""},
+		{[]string{"v=spf1 -all", "dnslink=/ipns/k51/"}, "/ipns/k51"},
+		{[]string{Value("/dfs/bafy")}, "/dfs/bafy"},
+	} {
+		if got := Parse(tt.records); got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.records, got, tt.want)
+		}
+	}
+}
+
+// fakeCloudflare hosts the zone example.com.
+type fakeCloudflare struct {
+	records map[string]cloudflareRecord
+	nextID  int
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(result any) {
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "result": result})
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`)
+		return
+	}
+	var body cloudflareRecord
+	json.NewDecoder(r.Body).Decode(&body)
+	switch path := r.URL.Path; {
+	case path == "/zones":
+		if r.URL.Query().Get("name") == "example.com" {
+			reply([]map[string]string{{"id": "z1"}})
+		} else {
+			reply([]any{})
+		}
+	case path == "/zones/z1/dns_records" && r.Method == http.MethodGet:
+		var found []cloudflareRecord
+		for _, rec := range f.records {
+			if rec.Name == r.URL.Query().Get("name") {
+				found = append(found, rec)
+			}
+		}
+		reply(found)
+	case path == "/zones/z1/dns_records" && r.Method == http.MethodPost:
+		f.nextID++
+		body.ID = fmt.Sprint("r", f.nextID)
+		f.records[body.ID] = body
+		reply(body)
+	case strings.HasPrefix(path, "/zones/z1/dns_records/") && r.Method == http.MethodPut:
+		body.ID = strings.TrimPrefix(path, "/zones/z1/dns_records/")
+		f.records[body.ID] = body
+		reply(body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":7003,"message":"Could not route"}]}`)
+	}
+}
+
+func TestCloudflare(t *testing.T) {
+	fake := &fakeCloudflare{records: map[string]cloudflareRecord{
+		"other": {ID: "other", Type: "TXT", Name: "_dnslink.www.example.com", Content: "verification"},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	cf := &Cloudflare{Token: "token", BaseURL: srv.URL}
+	ctx := context.Background()
+
+	// Set twice, the second replacing the first
+	for _, name := range []string{"k51a", "k51b"} {
+		if err := Set(ctx, cf, "www.example.com", "/ipns/"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.records) != 2 {
+		t.Fatalf("records %v, want the DNSLink next to the other record", fake.records)
+	}
+	if rec := fake.records["r1"]; rec.Name != "_dnslink.www.example.com" || rec.Content != "dnslink=/ipns/k51b" {
+		t.Errorf("record %+v", rec)
+	}
+	if fake.records["other"].Content != "verification" {
+		t.Error("other record replaced")
+	}
+
+	if err := Set(ctx, cf, "example.org", "/ipns/k51"); err == nil || !strings.Contains(err.Error(), "no Cloudflare zone") {
+		t.Errorf("set outside any zone: %v", err)
+	}
+	cf.Token = "wrong"
+	if err := Set(ctx, cf, "www.example.com", "/ipns/k51"); err == nil || !strings.Contains(err.Error(), "Authentication error") {
+		t.Errorf("set with a wrong token: %v", err)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	t.Setenv(cloudflareTokenEnv, "token")
+	if p, err := NewProvider("cloudflare"); err != nil || p.(*Cloudflare).Token != "token" {
+		t.Errorf("NewProvider(cloudflare) = %v, %v", p, err)
+	}
+	if _, err := NewProvider("bind"); err == nil {
+		t.Error("unknown provider accepted")
+	}
+}
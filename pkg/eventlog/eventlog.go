@@ -0,0 +1,157 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	PeerConnected    = "peer.connected"
+	PeerDisconnected = "peer.disconnected"
+	// KeyRevoked marks the key of Peer revoked, with fields revoked, the
+	// time of the revocation, and reason.
+	KeyRevoked = "key.revoked"
+
+	// PressureThrottled and PressureRelieved mark when the daemon starts
+	// and stops scaling down its work for lack of resources.
+	PressureThrottled = "pressure.throttled"
+	PressureRelieved  = "pressure.relieved"
+
+	// WantSent marks a block wanted from Peer, field cid. WantDone ends
+	// it, with field result one of the Want results. WantServed is Peer
+	// wanting a block from us, field found telling whether it got it.
+	WantSent   = "want.sent"
+	WantDone   = "want.done"
+	WantServed = "want.served"
+
+	// ReplicaStored and ReplicaRefused are the decisions on Peer asking
+	// us to keep a copy of field cid, the latter with field reason.
+	ReplicaStored  = "replica.stored"
+	ReplicaRefused = "replica.refused"
+	// ReplicaPlaced and ReplicaDeclined are Peer's answers to us asking
+	// it to keep a copy of field cid. PinUnderReplicated marks a check
+	// that left a pin with fewer copies than it needs, fields cid, copies
+	// and factor.
+	ReplicaPlaced      = "replica.placed"
+	ReplicaDeclined    = "replica.declined"
+	PinUnderReplicated = "pin.under_replicated"
+	// ReplicaVerified is Peer's copy of field cid sampled by a deep
+	// verification, with fields sampled, valid, missing and corrupt, and
+	// error when the peer stopped answering.
+	ReplicaVerified = "replica.verified"
+
+	// PushReceived is Peer's push of field cid put in the inbox, with
+	// fields id, name and size. PushRefused is one declined, with field
+	// reason; PushAccepted and PushRejected are the user's decisions on
+	// inbox item id, field cid.
+	PushReceived = "push.received"
+	PushRefused  = "push.refused"
+	PushAccepted = "push.accepted"
+	PushRejected = "push.rejected"
+
+	// GCCollected is a garbage collection that removed blocks, with fields
+	// removed, removed_bytes and recent, or error when it failed.
+	GCCollected = "gc.collected"
+
+	// SyncConflict is a file "dfs sync" found changed both locally and
+	// remotely, with fields dir, path, copy, the name the local version
+	// was kept under, and remote, the hash of the remote version.
+	SyncConflict = "sync.conflict"
+
+	// PrivacyPurged is a "dfs privacy purge", with fields origin, peer for
+	// a peer's replicas, unpinned, the number of pins removed, removed and
+	// removed_bytes.
+	PrivacyPurged = "privacy.purged"
+)
+
+// Results of a WantDone event.
+const (
+	WantReceived  = "received"
+	WantNotFound  = "not_found"
+	WantFailed    = "failed"
+	WantCancelled = "cancelled"
+)
+
+// Event is a single entry of the daemon event stream. Events are stored as
+// one JSON object per line.
+type Event struct {
+	Time   time.Time         `json:"time"`
+	Type   string            `json:"type"`
+	Peer   string            `json:"peer,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Recorder appends events to a log. A nil Recorder discards everything, so
+// callers don't need to check whether recording is enabled.
+type Recorder struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	r := &Recorder{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		r.closer = c
+	}
+	return r
+}
+
+// Open appends to the event log at path, creating it if needed.
+func Open(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(f), nil
+}
+
+func (r *Recorder) Record(e Event) {
+	if r == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Recording is best effort and must never fail the operation itself.
+	_ = r.enc.Encode(e)
+}
+
+func (r *Recorder) Close() error {
+	if r == nil || r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Read decodes events from r in order and calls fn for each of them.
+func Read(r io.Reader, fn func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("eventlog: line %d: %w", line, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,66 @@
+package eventlog
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordRead(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := []Event{
+		{Time: at, Type: PeerConnected, Peer: "a", Fields: map[string]string{"addr": "/ip4/127.0.0.1/tcp/1"}},
+		{Time: at.Add(time.Second), Type: WantSent, Peer: "a", Fields: map[string]string{"cid": "c1"}},
+		{Time: at.Add(2 * time.Second), Type: GCCollected, Fields: map[string]string{"removed": "1", "removed_bytes": "10", "recent": "0"}},
+	}
+	for _, e := range want {
+		r.Record(e)
+	}
+	r.Record(Event{Type: PeerDisconnected, Peer: "a"})
+
+	var got []Event
+	if err := Read(&buf, func(e Event) error {
+		got = append(got, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("read %d events, want 4", len(got))
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Type != want[i].Type || got[i].Peer != want[i].Peer || !reflect.DeepEqual(got[i].Fields, want[i].Fields) {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got[3].Time.IsZero() {
+		t.Error("event recorded without a time got none")
+	}
+}
+
+// A nil Recorder is recording turned off.
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.Record(Event{Type: PeerConnected})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	log := "{\"type\":\"peer.connected\",\"peer\":\"a\"}\n\nnot json\n"
+	err := Read(strings.NewReader(log), func(Event) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Read = %v, want an error on line 3", err)
+	}
+
+	stop := errors.New("stop")
+	if err := Read(strings.NewReader(log), func(Event) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("Read = %v, want the callback's error", err)
+	}
+}
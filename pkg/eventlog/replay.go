@@ -0,0 +1,229 @@
+package eventlog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Machine is a subsystem state machine that can be driven from a recorded
+// event stream.
+type Machine interface {
+	Apply(Event) error
+}
+
+// Replay feeds every event from r into the given machines.
+func Replay(r io.Reader, machines ...Machine) error {
+	return Read(r, func(e Event) error {
+		for _, m := range machines {
+			if err := m.Apply(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PeerState is what the peer table knows about a single peer.
+type PeerState struct {
+	Conns     int
+	Addr      string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Anomaly is an event that didn't make sense for the state at that point.
+type Anomaly struct {
+	Event  Event
+	Reason string
+}
+
+// PeerTable rebuilds the connected peer set from peer events.
+type PeerTable struct {
+	Peers     map[string]*PeerState
+	Anomalies []Anomaly
+}
+
+func NewPeerTable() *PeerTable {
+	return &PeerTable{Peers: make(map[string]*PeerState)}
+}
+
+func (t *PeerTable) Apply(e Event) error {
+	switch e.Type {
+	case PeerConnected:
+		p, ok := t.Peers[e.Peer]
+		if !ok {
+			p = &PeerState{FirstSeen: e.Time}
+			t.Peers[e.Peer] = p
+		}
+		p.Conns++
+		p.LastSeen = e.Time
+		p.Addr = e.Fields["addr"]
+	case PeerDisconnected:
+		p, ok := t.Peers[e.Peer]
+		if !ok {
+			t.Anomalies = append(t.Anomalies, Anomaly{Event: e, Reason: "disconnect from unknown peer"})
+			return nil
+		}
+		p.Conns--
+		p.LastSeen = e.Time
+		if p.Conns <= 0 {
+			delete(t.Peers, e.Peer)
+		}
+	}
+	return nil
+}
+
+// Connected returns the ids of connected peers in sorted order.
+func (t *PeerTable) Connected() []string {
+	ids := make([]string, 0, len(t.Peers))
+	for id := range t.Peers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%s %s %s: %s", a.Event.Time.Format(time.RFC3339Nano), a.Event.Type, a.Event.Peer, a.Reason)
+}
+
+// WantTable rebuilds the blocks wanted from peers and how the wants ended.
+type WantTable struct {
+	// Pending holds, per peer, the wanted blocks with no answer yet and
+	// when they were wanted.
+	Pending map[string]map[string]time.Time
+	// Results counts the ended wants by result.
+	Results map[string]int
+	// Served and Missing count the wants of peers we had the block for
+	// and didn't.
+	Served    int
+	Missing   int
+	Anomalies []Anomaly
+}
+
+func NewWantTable() *WantTable {
+	return &WantTable{
+		Pending: make(map[string]map[string]time.Time),
+		Results: make(map[string]int),
+	}
+}
+
+func (t *WantTable) Apply(e Event) error {
+	switch e.Type {
+	case WantSent:
+		wants := t.Pending[e.Peer]
+		if wants == nil {
+			wants = make(map[string]time.Time)
+			t.Pending[e.Peer] = wants
+		}
+		if _, ok := wants[e.Fields["cid"]]; ok {
+			t.Anomalies = append(t.Anomalies, Anomaly{Event: e, Reason: "block wanted twice"})
+		}
+		wants[e.Fields["cid"]] = e.Time
+	case WantDone:
+		wants := t.Pending[e.Peer]
+		if _, ok := wants[e.Fields["cid"]]; !ok {
+			t.Anomalies = append(t.Anomalies, Anomaly{Event: e, Reason: "want ended that wasn't sent"})
+			return nil
+		}
+		delete(wants, e.Fields["cid"])
+		if len(wants) == 0 {
+			delete(t.Pending, e.Peer)
+		}
+		t.Results[e.Fields["result"]]++
+	case WantServed:
+		if e.Fields["found"] == "true" {
+			t.Served++
+		} else {
+			t.Missing++
+		}
+	}
+	return nil
+}
+
+// PendingCount is the number of wants without an answer.
+func (t *WantTable) PendingCount() int {
+	n := 0
+	for _, wants := range t.Pending {
+		n += len(wants)
+	}
+	return n
+}
+
+// ReplicaTable rebuilds the replication decisions: which peers took
+// copies of our pins, which copies we keep for peers and what was refused.
+type ReplicaTable struct {
+	// Placed holds, per pin, the peers that agreed to keep a copy.
+	Placed map[string]map[string]bool
+	// Kept holds, per copy kept here, the peer it is kept for.
+	Kept map[string]string
+	// Refused are the requests we refused, Declined the peers' refusals
+	// of ours.
+	Refused  []Event
+	Declined []Event
+	// UnderReplicated holds the latest under-replication of every pin no
+	// copy was placed for since.
+	UnderReplicated map[string]Event
+}
+
+func NewReplicaTable() *ReplicaTable {
+	return &ReplicaTable{
+		Placed:          make(map[string]map[string]bool),
+		Kept:            make(map[string]string),
+		UnderReplicated: make(map[string]Event),
+	}
+}
+
+func (t *ReplicaTable) Apply(e Event) error {
+	c := e.Fields["cid"]
+	switch e.Type {
+	case ReplicaPlaced:
+		if t.Placed[c] == nil {
+			t.Placed[c] = make(map[string]bool)
+		}
+		t.Placed[c][e.Peer] = true
+		delete(t.UnderReplicated, c)
+	case ReplicaDeclined:
+		t.Declined = append(t.Declined, e)
+	case ReplicaStored:
+		t.Kept[c] = e.Peer
+	case ReplicaRefused:
+		t.Refused = append(t.Refused, e)
+	case PinUnderReplicated:
+		t.UnderReplicated[c] = e
+	}
+	return nil
+}
+
+// GCTable sums up the garbage collections.
+type GCTable struct {
+	Runs         int
+	Failed       int
+	Removed      int64
+	RemovedBytes int64
+}
+
+func (t *GCTable) Apply(e Event) error {
+	if e.Type != GCCollected {
+		return nil
+	}
+	if e.Fields["error"] != "" {
+		t.Failed++
+		return nil
+	}
+	removed, err := strconv.ParseInt(e.Fields["removed"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("eventlog: %s: removed: %w", e.Type, err)
+	}
+	bytes, err := strconv.ParseInt(e.Fields["removed_bytes"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("eventlog: %s: removed_bytes: %w", e.Type, err)
+	}
+	t.Runs++
+	t.Removed += removed
+	t.RemovedBytes += bytes
+	return nil
+}
@@ -0,0 +1,129 @@
+package eventlog
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// record writes events a second apart to a log.
+func record(t *testing.T, events ...Event) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, e := range events {
+		e.Time = at.Add(time.Duration(i) * time.Second)
+		r.Record(e)
+	}
+	return &buf
+}
+
+func cidField(c string) map[string]string {
+	return map[string]string{"cid": c}
+}
+
+func TestReplayPeers(t *testing.T) {
+	log := record(t,
+		Event{Type: PeerConnected, Peer: "a", Fields: map[string]string{"addr": "addr-a"}},
+		Event{Type: PeerConnected, Peer: "b"},
+		Event{Type: PeerConnected, Peer: "a", Fields: map[string]string{"addr": "addr-a2"}},
+		Event{Type: PeerDisconnected, Peer: "b"},
+		Event{Type: PeerDisconnected, Peer: "a"},
+		Event{Type: PeerDisconnected, Peer: "c"},
+	)
+	peers := NewPeerTable()
+	if err := Replay(log, peers); err != nil {
+		t.Fatal(err)
+	}
+	if got := peers.Connected(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("connected = %v, want [a]", got)
+	}
+	if p := peers.Peers["a"]; p.Conns != 1 || p.Addr != "addr-a2" {
+		t.Errorf("peer a = %+v", p)
+	}
+	if len(peers.Anomalies) != 1 || peers.Anomalies[0].Event.Peer != "c" {
+		t.Errorf("anomalies = %v, want the disconnect of c", peers.Anomalies)
+	}
+}
+
+func TestReplayWants(t *testing.T) {
+	log := record(t,
+		Event{Type: WantSent, Peer: "a", Fields: cidField("c1")},
+		Event{Type: WantSent, Peer: "b", Fields: cidField("c1")},
+		Event{Type: WantSent, Peer: "a", Fields: cidField("c2")},
+		Event{Type: WantDone, Peer: "a", Fields: map[string]string{"cid": "c1", "result": WantReceived}},
+		Event{Type: WantDone, Peer: "b", Fields: map[string]string{"cid": "c1", "result": WantCancelled}},
+		Event{Type: WantDone, Peer: "b", Fields: map[string]string{"cid": "c3", "result": WantNotFound}},
+		Event{Type: WantServed, Peer: "b", Fields: map[string]string{"cid": "c4", "found": "true"}},
+		Event{Type: WantServed, Peer: "b", Fields: map[string]string{"cid": "c5", "found": "false"}},
+	)
+	wants := NewWantTable()
+	if err := Replay(log, wants); err != nil {
+		t.Fatal(err)
+	}
+	if wants.PendingCount() != 1 || wants.Pending["a"]["c2"].IsZero() {
+		t.Errorf("pending = %v, want c2 from a", wants.Pending)
+	}
+	want := map[string]int{WantReceived: 1, WantCancelled: 1}
+	if !reflect.DeepEqual(wants.Results, want) {
+		t.Errorf("results = %v, want %v", wants.Results, want)
+	}
+	if wants.Served != 1 || wants.Missing != 1 {
+		t.Errorf("served %d, missing %d, want 1 and 1", wants.Served, wants.Missing)
+	}
+	if len(wants.Anomalies) != 1 || wants.Anomalies[0].Event.Fields["cid"] != "c3" {
+		t.Errorf("anomalies = %v, want the end of the unsent want for c3", wants.Anomalies)
+	}
+}
+
+func TestReplayReplicas(t *testing.T) {
+	log := record(t,
+		Event{Type: PinUnderReplicated, Fields: map[string]string{"cid": "p1", "copies": "1", "factor": "3"}},
+		Event{Type: PinUnderReplicated, Fields: map[string]string{"cid": "p2", "copies": "1", "factor": "2"}},
+		Event{Type: ReplicaDeclined, Peer: "a", Fields: cidField("p1")},
+		Event{Type: ReplicaPlaced, Peer: "b", Fields: cidField("p1")},
+		Event{Type: ReplicaStored, Peer: "c", Fields: cidField("k1")},
+		Event{Type: ReplicaRefused, Peer: "d", Fields: map[string]string{"cid": "k2", "reason": "too large"}},
+	)
+	replicas := NewReplicaTable()
+	if err := Replay(log, replicas); err != nil {
+		t.Fatal(err)
+	}
+	if !replicas.Placed["p1"]["b"] || len(replicas.Placed) != 1 {
+		t.Errorf("placed = %v, want p1 at b", replicas.Placed)
+	}
+	if _, ok := replicas.UnderReplicated["p1"]; ok || len(replicas.UnderReplicated) != 1 {
+		t.Errorf("under-replicated = %v, want only p2", replicas.UnderReplicated)
+	}
+	if replicas.Kept["k1"] != "c" {
+		t.Errorf("kept = %v, want k1 for c", replicas.Kept)
+	}
+	if len(replicas.Refused) != 1 || replicas.Refused[0].Fields["reason"] != "too large" {
+		t.Errorf("refused = %v", replicas.Refused)
+	}
+	if len(replicas.Declined) != 1 || replicas.Declined[0].Peer != "a" {
+		t.Errorf("declined = %v", replicas.Declined)
+	}
+}
+
+func TestReplayGC(t *testing.T) {
+	log := record(t,
+		Event{Type: GCCollected, Fields: map[string]string{"removed": "3", "removed_bytes": "300", "recent": "1"}},
+		Event{Type: GCCollected, Fields: map[string]string{"error": "readers alive"}},
+		Event{Type: GCCollected, Fields: map[string]string{"removed": "2", "removed_bytes": "50", "recent": "0"}},
+	)
+	gc := &GCTable{}
+	if err := Replay(log, gc); err != nil {
+		t.Fatal(err)
+	}
+	if want := (GCTable{Runs: 2, Failed: 1, Removed: 5, RemovedBytes: 350}); *gc != want {
+		t.Errorf("gc = %+v, want %+v", *gc, want)
+	}
+
+	bad := record(t, Event{Type: GCCollected, Fields: map[string]string{"removed": "x"}})
+	if err := Replay(bad, &GCTable{}); err == nil {
+		t.Error("replayed a collection with a malformed count")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build faults
+
+package faults
+
+// enabled reports whether fault injection is compiled in. Build with
+// `-tags faults` to turn it on.
+const enabled = true
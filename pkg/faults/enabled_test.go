@@ -0,0 +1,63 @@
+//go:build faults
+
+package faults
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/bits"
+	"testing"
+)
+
+func TestDropBlock(t *testing.T) {
+	if !New(Config{DropBlockRate: 1}).DropBlock() {
+		t.Error("DropBlock = false at rate 1")
+	}
+	if New(Config{DropBlockRate: 0, KillRate: 1}).DropBlock() {
+		t.Error("DropBlock = true at rate 0")
+	}
+}
+
+// Every read corrupted at rate 1 has exactly one bit flipped.
+func TestCorrupt(t *testing.T) {
+	data := bytes.Repeat([]byte{0x55}, 64)
+	inj := New(Config{CorruptRate: 1, Seed: 1})
+	got, err := io.ReadAll(inj.WrapReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := 0
+	for i := range data {
+		flipped += bits.OnesCount8(got[i] ^ data[i])
+	}
+	if flipped != 1 {
+		t.Errorf("%d bits flipped, want 1", flipped)
+	}
+}
+
+func TestKill(t *testing.T) {
+	inj := New(Config{KillRate: 1})
+	_, err := inj.WrapReader(bytes.NewReader([]byte("data"))).Read(make([]byte, 4))
+	if !errors.Is(err, ErrInjected) {
+		t.Errorf("Read = %v, want ErrInjected", err)
+	}
+}
+
+// The same seed injects the same faults.
+func TestSeedReproducible(t *testing.T) {
+	decisions := func() []bool {
+		inj := New(Config{DropBlockRate: 0.5, Seed: 42})
+		var got []bool
+		for range 64 {
+			got = append(got, inj.DropBlock())
+		}
+		return got
+	}
+	a, b := decisions(), decisions()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("decision %d differs between runs with the same seed", i)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// EnvVar holds the fault spec read by FromEnv, e.g.
+// "drop=0.1,delay=50ms,corrupt=0.01,kill=0.05".
+const EnvVar = "DFS_FAULTS"
+
+var ErrInjected = errors.New("faults: injected failure")
+
+type Config struct {
+	// DropBlockRate is the probability that a block is treated as missing.
+	DropBlockRate float64
+	// ReadDelay is added before every stream read.
+	ReadDelay time.Duration
+	// CorruptRate is the probability that a read has one byte flipped.
+	CorruptRate float64
+	// KillRate is the probability that a read kills the underlying connection.
+	KillRate float64
+	// Seed makes runs reproducible. Zero picks a time based seed.
+	Seed int64
+}
+
+func (c Config) active() bool {
+	return c.DropBlockRate > 0 || c.ReadDelay > 0 || c.CorruptRate > 0 || c.KillRate > 0
+}
+
+// Injector decides when to inject faults. A nil Injector, or one in a binary
+// built without the faults tag, never injects anything.
+type Injector struct {
+	cfg Config
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func New(cfg Config) *Injector {
+	if !enabled || !cfg.active() {
+		return nil
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &Injector{
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// FromEnv builds an Injector from the DFS_FAULTS environment variable.
+func FromEnv() (*Injector, error) {
+	spec := os.Getenv(EnvVar)
+	if spec == "" {
+		return nil, nil
+	}
+
+	cfg, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg), nil
+}
+
+func ParseSpec(spec string) (Config, error) {
+	var cfg Config
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return cfg, fmt.Errorf("faults: invalid entry %q", part)
+		}
+
+		var err error
+		switch key {
+		case "drop":
+			cfg.DropBlockRate, err = parseRate(value)
+		case "delay":
+			cfg.ReadDelay, err = time.ParseDuration(value)
+		case "corrupt":
+			cfg.CorruptRate, err = parseRate(value)
+		case "kill":
+			cfg.KillRate, err = parseRate(value)
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			err = fmt.Errorf("unknown fault %q", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("faults: %s: %w", key, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate %v out of range [0,1]", rate)
+	}
+	return rate, nil
+}
+
+func (i *Injector) Enabled() bool {
+	return enabled && i != nil
+}
+
+func (i *Injector) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rng.Float64() < rate
+}
+
+// DropBlock reports whether the caller should behave as if a block was not
+// available.
+func (i *Injector) DropBlock() bool {
+	if !i.Enabled() {
+		return false
+	}
+	return i.roll(i.cfg.DropBlockRate)
+}
+
+func (i *Injector) WrapReader(r io.Reader) io.Reader {
+	if !i.Enabled() {
+		return r
+	}
+	return &faultyReader{r: r, inj: i}
+}
+
+func (i *Injector) WrapStream(s network.Stream) network.Stream {
+	if !i.Enabled() {
+		return s
+	}
+	return &faultyStream{Stream: s, inj: i}
+}
+
+// corrupt flips a single random bit in buf.
+func (i *Injector) corrupt(buf []byte) {
+	if len(buf) == 0 || !i.roll(i.cfg.CorruptRate) {
+		return
+	}
+
+	i.mu.Lock()
+	pos := i.rng.Intn(len(buf))
+	bit := byte(1) << i.rng.Intn(8)
+	i.mu.Unlock()
+
+	buf[pos] ^= bit
+}
+
+type faultyReader struct {
+	r   io.Reader
+	inj *Injector
+}
+
+func (fr *faultyReader) Read(p []byte) (int, error) {
+	if fr.inj.cfg.ReadDelay > 0 {
+		time.Sleep(fr.inj.cfg.ReadDelay)
+	}
+	if fr.inj.roll(fr.inj.cfg.KillRate) {
+		return 0, ErrInjected
+	}
+
+	n, err := fr.r.Read(p)
+	fr.inj.corrupt(p[:n])
+	return n, err
+}
+
+type faultyStream struct {
+	network.Stream
+	inj *Injector
+}
+
+func (fs *faultyStream) Read(p []byte) (int, error) {
+	if fs.inj.cfg.ReadDelay > 0 {
+		time.Sleep(fs.inj.cfg.ReadDelay)
+	}
+	if fs.inj.roll(fs.inj.cfg.KillRate) {
+		// Kill the whole connection, not just the stream, to mimic a peer
+		// dropping off mid-transfer.
+		fs.Stream.Conn().Close()
+		return 0, ErrInjected
+	}
+
+	n, err := fs.Stream.Read(p)
+	fs.inj.corrupt(p[:n])
+	return n, err
+}
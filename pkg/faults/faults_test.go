@@ -0,0 +1,95 @@
+package faults
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Config
+		wantErr string
+	}{
+		{"", Config{}, ""},
+		{
+			"drop=0.1,delay=50ms,corrupt=0.01,kill=0.05,seed=7",
+			Config{DropBlockRate: 0.1, ReadDelay: 50 * time.Millisecond, CorruptRate: 0.01, KillRate: 0.05, Seed: 7},
+			"",
+		},
+		{" drop=1 , ,kill=0 ", Config{DropBlockRate: 1}, ""},
+		{"drop", Config{}, `invalid entry "drop"`},
+		{"flood=0.5", Config{}, `unknown fault "flood"`},
+		{"drop=1.5", Config{}, "out of range"},
+		{"corrupt=-0.1", Config{}, "out of range"},
+		{"kill=often", Config{}, "kill"},
+		{"delay=5", Config{}, "delay"},
+		{"seed=x", Config{}, "seed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseSpec(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseSpec(%q) error = %v, want it to mention %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(EnvVar, "")
+	if inj, err := FromEnv(); inj != nil || err != nil {
+		t.Errorf("FromEnv without a spec = %v, %v", inj, err)
+	}
+	t.Setenv(EnvVar, "drop=2")
+	if _, err := FromEnv(); err == nil {
+		t.Error("FromEnv accepted an invalid spec")
+	}
+	t.Setenv(EnvVar, "drop=1")
+	inj, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inj.Enabled() != enabled {
+		t.Errorf("Enabled = %v in a build with enabled = %v", inj.Enabled(), enabled)
+	}
+}
+
+// Without faults configured, or compiled in, nothing is injected.
+func TestInactive(t *testing.T) {
+	injectors := map[string]*Injector{
+		"nil":         nil,
+		"no faults":   New(Config{}),
+		"zero config": New(Config{Seed: 1}),
+	}
+	if !enabled {
+		injectors["not compiled in"] = New(Config{DropBlockRate: 1, CorruptRate: 1, KillRate: 1})
+	}
+	data := []byte("untouched")
+	for name, inj := range injectors {
+		t.Run(name, func(t *testing.T) {
+			if inj.Enabled() {
+				t.Fatal("Enabled = true")
+			}
+			if inj.DropBlock() {
+				t.Error("DropBlock = true")
+			}
+			r := bytes.NewReader(data)
+			if inj.WrapReader(r) != io.Reader(r) {
+				t.Error("WrapReader wrapped the reader")
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+// Package gateway serves files and directory trees over HTTP, for
+// browsers to read what the network holds, such as sites published with
+// "dfs publish-site".
+//
+// Content is addressed by hash as /dfs/<hash>/path, or by name as
+// /ipns/<name>/path, following the name to what it points to now. A
+// request for any other path is served from the tree a DNSLink record
+// of the host it was sent to points at: a TXT record on _dnslink.<host>
+// holding dnslink=/ipns/<name> or dnslink=/dfs/<hash>. A directory is
+// served by its index.html, or else listed.
+//
+// Encrypted files are never served, even when the node holds their key:
+// the gateway answers whoever asks.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/dnslink"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+// dnslinkTTL is how long a host's DNSLink record is cached.
+const dnslinkTTL = time.Minute
+
+var (
+	// errEncrypted is returned for a file the gateway doesn't serve.
+	errEncrypted = errors.New("gateway: encrypted files are not served")
+	errOffline   = errors.New("gateway: names can't be resolved, networking is not running")
+)
+
+// TXTResolver looks up TXT records, as a net.Resolver does.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+type Options struct {
+	Node *node.Node
+	// Resolver looks up DNSLink records. Defaults to net.DefaultResolver.
+	Resolver TXTResolver
+	Logger   *zap.Logger
+}
+
+// Gateway is the http.Handler serving content.
+type Gateway struct {
+	Options
+
+	mu    sync.Mutex
+	links map[string]cachedLink
+}
+
+type cachedLink struct {
+	value   string
+	expires time.Time
+}
+
+func New(opts Options) *Gateway {
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	return &Gateway{Options: opts, links: make(map[string]cachedLink)}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+
+	// The content path is /<dfs|ipns>/<root>/<names...>
+	p := r.URL.Path
+	if !strings.HasPrefix(p, "/dfs/") && !strings.HasPrefix(p, "/ipns/") {
+		link, err := g.resolveHost(ctx, r.Host)
+		if err != nil {
+			g.fail(w, r, err)
+			return
+		}
+		p = link + p
+	}
+	kind, rest, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	rootName, sub, _ := strings.Cut(rest, "/")
+
+	root, immutable, err := g.root(ctx, kind, rootName)
+	if err != nil {
+		g.fail(w, r, err)
+		return
+	}
+	var names []string
+	for _, name := range strings.Split(sub, "/") {
+		if name == "" {
+			continue
+		}
+		if err := manifest.ValidName(name); err != nil {
+			g.fail(w, r, fmt.Errorf("%w: %v", manifest.ErrNoEntry, err))
+			return
+		}
+		names = append(names, name)
+	}
+	c, err := g.Node.Resolve(ctx, root, names)
+	if err != nil {
+		g.fail(w, r, err)
+		return
+	}
+
+	if c.Type() == manifest.DirectoryCodec {
+		// Relative links in the directory's pages resolve under it
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		d, err := g.Node.List(ctx, c)
+		if err != nil {
+			g.fail(w, r, err)
+			return
+		}
+		if index, ok := d.Lookup("index.html"); ok && !index.IsDir() {
+			g.serveFile(w, r, index.CID, "index.html", immutable)
+			return
+		}
+		g.serveListing(w, r, d)
+		return
+	}
+	name := ""
+	if len(names) > 0 {
+		name = names[len(names)-1]
+	}
+	g.serveFile(w, r, c, name, immutable)
+}
+
+// root returns the root of a content path, and whether it is addressed by
+// hash, so that what it serves never changes.
+func (g *Gateway) root(ctx context.Context, kind, s string) (cid.Cid, bool, error) {
+	switch kind {
+	case "dfs":
+		c, err := cid.Decode(s)
+		if err != nil {
+			return cid.Undef, false, fmt.Errorf("%w: %q is not a hash", manifest.ErrNoEntry, s)
+		}
+		return c, true, nil
+	case "ipns":
+		p2p := g.Node.Network()
+		if p2p == nil || p2p.Names() == nil {
+			return cid.Undef, false, errOffline
+		}
+		c, err := p2p.Names().Resolve(ctx, s)
+		return c, false, err
+	}
+	return cid.Undef, false, fmt.Errorf("%w: %s", manifest.ErrNoEntry, kind)
+}
+
+// resolveHost returns the content path the DNSLink record of host points
+// at.
+func (g *Gateway) resolveHost(ctx context.Context, host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	g.mu.Lock()
+	link, ok := g.links[host]
+	g.mu.Unlock()
+	if ok && time.Now().Before(link.expires) {
+		if link.value == "" {
+			return "", fmt.Errorf("%w: no DNSLink record for %s", manifest.ErrNoEntry, host)
+		}
+		return link.value, nil
+	}
+
+	// Hosts without a record are cached too, not to look them up for
+	// every request
+	link = cachedLink{expires: time.Now().Add(dnslinkTTL)}
+	if net.ParseIP(host) == nil && host != "localhost" {
+		records, err := g.Resolver.LookupTXT(ctx, dnslink.Record(host))
+		var dnsErr *net.DNSError
+		if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return "", fmt.Errorf("gateway: DNSLink of %s: %w", host, err)
+		}
+		link.value = dnslink.Parse(records)
+	}
+	g.mu.Lock()
+	g.links[host] = link
+	g.mu.Unlock()
+	if link.value == "" {
+		return "", fmt.Errorf("%w: no DNSLink record for %s", manifest.ErrNoEntry, host)
+	}
+	return link.value, nil
+}
+
+func (g *Gateway) serveFile(w http.ResponseWriter, r *http.Request, c cid.Cid, name string, immutable bool) {
+	ctx := r.Context()
+	m, err := g.Node.Stat(ctx, c)
+	if err != nil {
+		g.fail(w, r, err)
+		return
+	}
+	if m.Encryption != nil {
+		g.fail(w, r, errEncrypted)
+		return
+	}
+
+	etag := `"` + c.String() + `"`
+	h := w.Header()
+	h.Set("Etag", etag)
+	h.Set("X-Content-Hash", c.String())
+	if immutable {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if name == "" {
+		name = m.Name
+	}
+	// Left unset, the type is sniffed from the start of the file
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		h.Set("Content-Type", t)
+	}
+	h.Set("Content-Length", strconv.FormatInt(m.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := g.Node.Get(ctx, c, w); err != nil {
+		// Nothing is written until every chunk is stored, so the error
+		// can still be reported unless writing out failed
+		g.fail(w, r, err)
+	}
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<ul>
+{{- range .Entries}}
+<li><a href="{{.Href}}">{{.Name}}</a> {{.Size}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+func (g *Gateway) serveListing(w http.ResponseWriter, r *http.Request, d *manifest.Directory) {
+	type entry struct{ Name, Href, Size string }
+	data := struct {
+		Path    string
+		Entries []entry
+	}{Path: r.URL.Path}
+	for _, e := range d.Entries {
+		name, size := e.Name, strconv.FormatInt(e.Size, 10)
+		if e.IsDir() {
+			name += "/"
+			size = ""
+		}
+		data.Entries = append(data.Entries, entry{Name: name, Href: "./" + (&url.URL{Path: name}).EscapedPath(), Size: size})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := listingTemplate.Execute(w, data); err != nil {
+		g.Logger.Debug("Failed to list directory", zap.String("path", r.URL.Path), zap.Error(err))
+	}
+}
+
+// fail answers with the status matching err.
+func (g *Gateway) fail(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, manifest.ErrNoEntry), errors.Is(err, manifest.ErrNotDirectory),
+		errors.Is(err, network.ErrNameNotFound), errors.Is(err, network.ErrInvalidName):
+		code = http.StatusNotFound
+	case errors.Is(err, errEncrypted):
+		code = http.StatusForbidden
+	case errors.Is(err, node.ErrArchived), errors.Is(err, errOffline):
+		code = http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		code = http.StatusGatewayTimeout
+	}
+	if code == http.StatusInternalServerError {
+		g.Logger.Warn("Gateway request failed", zap.String("path", r.URL.Path), zap.Error(err))
+	}
+	http.Error(w, err.Error(), code)
+}
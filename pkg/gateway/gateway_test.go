@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+type txtRecords map[string][]string
+
+func (r txtRecords) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+// site stores a tree of files, encrypting the ones named secret, and
+// returns its root.
+func site(t *testing.T, n *node.Node, files map[string]string) cid.Cid {
+	t.Helper()
+	ctx := context.Background()
+	dirs := map[string][]manifest.Entry{}
+	for p, content := range files {
+		dir, name := filepath.Split(p)
+		res, err := n.Add(ctx, strings.NewReader(content), node.AddOptions{Name: name, Encrypt: name == "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs[dir] = append(dirs[dir], manifest.Entry{Name: name, CID: res.CID, Size: int64(len(content))})
+	}
+	// Only one level of subdirectories
+	var root []manifest.Entry
+	for dir, entries := range dirs {
+		if dir == "" {
+			root = append(root, entries...)
+			continue
+		}
+		d, err := manifest.NewDirectory(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := manifest.PutDirectory(ctx, n.Store(), d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root = append(root, manifest.Entry{Name: strings.TrimSuffix(dir, "/"), CID: c, Size: d.Size()})
+	}
+	d, err := manifest.NewDirectory(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := manifest.PutDirectory(ctx, n.Store(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGateway(t *testing.T) {
+	store, err := storage.Open(filepath.Join(t.TempDir(), "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	key, err := crypt.GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := node.NewNode(node.NodeOpts{Store: store, MasterKey: key})
+	root := site(t, n, map[string]string{
+		"index.html":     "<p>home</p>",
+		"docs/guide.txt": "read me",
+		"docs/secret":    "private",
+	})
+	resolver := txtRecords{"_dnslink.example.com": {"v=spf1", "dnslink=/dfs/" + root.String()}}
+	srv := httptest.NewServer(New(Options{Node: n, Resolver: resolver}))
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	for _, tt := range []struct {
+		host, path  string
+		code        int
+		contentType string
+		body        string
+	}{
+		{"", "/dfs/" + root.String() + "/", 200, "text/html; charset=utf-8", "<p>home</p>"},
+		{"", "/dfs/" + root.String() + "/docs/guide.txt", 200, "text/plain; charset=utf-8", "read me"},
+		{"", "/dfs/" + root.String() + "/docs", 301, "", ""},
+		{"", "/dfs/" + root.String() + "/docs/", 200, "text/html; charset=utf-8", `<a href="./guide.txt">guide.txt</a>`},
+		{"", "/dfs/" + root.String() + "/docs/secret", 403, "", ""},
+		{"", "/dfs/" + root.String() + "/missing", 404, "", ""},
+		{"", "/dfs/not-a-hash/", 404, "", ""},
+		{"", "/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8/", 503, "", ""},
+		{"example.com", "/docs/guide.txt", 200, "text/plain; charset=utf-8", "read me"},
+		{"other.example.com", "/", 404, "", ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s%s: status %d, want %d: %s", tt.host, tt.path, res.StatusCode, tt.code, body)
+			continue
+		}
+		if tt.contentType != "" && res.Header.Get("Content-Type") != tt.contentType {
+			t.Errorf("%s%s: type %q, want %q", tt.host, tt.path, res.Header.Get("Content-Type"), tt.contentType)
+		}
+		if !strings.Contains(string(body), tt.body) {
+			t.Errorf("%s%s: body %q, want %q", tt.host, tt.path, body, tt.body)
+		}
+	}
+}
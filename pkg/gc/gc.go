@@ -0,0 +1,445 @@
+// Package gc removes blocks that no pin refers to.
+//
+// A collection marks every pinned file's manifest and chunks as reachable
+// and then sweeps the block store, deleting the rest. Blocks written within
+// the grace period are kept even when unreferenced: they may belong to an
+// add or fetch that hasn't pinned its file yet. Re-writing an existing
+// block refreshes its write time, so a file added again is covered too.
+//
+// Nothing stored or pinned waits for a collection. Files being pinned
+// touch the blocks they find stored (see pin.Set.Pinning), so a file
+// pinned while a collection runs is either marked, or has its blocks
+// written after the cutoff, which the sweep checks again as it deletes
+// each block. The cutoff goes back to when the oldest file still being
+// pinned started, and session pins (see pin.Set.Hold) are marked like
+// pins.
+//
+// Files share the chunks they have in common. A chunk whose file is swept
+// stays as long as another stored file, one kept by the grace period say,
+// still refers to it; the block store counts those references.
+//
+// Deleting blocks can break read-only processes reading the repo, so the
+// sweep only deletes while no read lease is held (see repo.Lock).
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/metrics"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/repo"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+const DefaultGracePeriod = time.Hour
+
+type Collector struct {
+	logger *zap.Logger
+
+	// running serializes collections.
+	running sync.Mutex
+
+	CollectorOpts
+}
+
+type CollectorOpts struct {
+	Store *storage.FSBlockstore
+	Pins  *pin.Set
+	// Roots returns more roots to keep along with the pins, such as
+	// unfinished downloads. Optional.
+	Roots func() []cid.Cid
+	// Lock is the repo's write lock. It may be nil for dry runs only.
+	Lock *repo.Lock
+	// GracePeriod keeps unreferenced blocks younger than it. Defaults to
+	// DefaultGracePeriod.
+	GracePeriod time.Duration
+	// Interval between background collections. Zero disables them.
+	Interval time.Duration
+	// HistoryPath records every collection, see ReadHistory. Optional.
+	HistoryPath string
+	// Ops tracks background collections so they can be cancelled.
+	// Optional.
+	Ops *ops.Registry
+	// Events records collections that removed blocks or failed. Optional.
+	Events *eventlog.Recorder
+	Logger *zap.Logger
+}
+
+type Options struct {
+	// GracePeriod overrides the collector's grace period when positive.
+	GracePeriod time.Duration
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+	// Unpinned are pins a dry run treats as removed, to report what
+	// unpinning them would free. Dry runs only.
+	Unpinned []cid.Cid
+	// Evict are roots just archived, whose blocks no pin keeps are removed
+	// whatever their age: the grace period only protects blocks written
+	// since the oldest file still being pinned started.
+	Evict []cid.Cid
+}
+
+type Report struct {
+	Pins int
+	// Reachable counts the blocks referenced by pins, stored or not.
+	Reachable int
+	// Scanned counts the blocks in the store.
+	Scanned int
+	Removed int
+	// RemovedBytes is the size of the removed blocks.
+	RemovedBytes int64
+	// Recent counts unreferenced blocks kept because of the grace period.
+	Recent int
+	// Linked counts unpinned blocks kept because a block that stays, such
+	// as a recent file, still refers to them.
+	Linked int
+	// Freed counts the removed blocks that belong to the Unpinned pins,
+	// FreedBytes their size.
+	Freed      int
+	FreedBytes int64
+	// Evicted counts the removed blocks that belong to the Evict roots,
+	// EvictedBytes their size.
+	Evicted      int
+	EvictedBytes int64
+	GracePeriod  time.Duration
+	Duration     time.Duration
+}
+
+func NewCollector(opts CollectorOpts) *Collector {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = DefaultGracePeriod
+	}
+
+	return &Collector{
+		logger:        opts.Logger,
+		CollectorOpts: opts,
+	}
+}
+
+// Start runs a collection every Interval until ctx is done.
+func (c *Collector) Start(ctx context.Context) {
+	if c.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			runCtx, _, done := c.Ops.Start(ctx, ops.KindGC, "")
+			report, err := c.Run(runCtx, Options{})
+			done()
+			switch {
+			case errors.Is(err, repo.ErrReadersAlive):
+				c.logger.Info("Skipping garbage collection, read-only processes are using the repo")
+			case errors.Is(context.Cause(runCtx), ops.ErrCanceled):
+				c.logger.Info("Garbage collection cancelled")
+			case err != nil && ctx.Err() == nil:
+				c.logger.Error("Garbage collection failed", zap.Error(err))
+			case err == nil:
+				log := c.logger.Info
+				if report.Removed == 0 {
+					log = c.logger.Debug
+				}
+				log("Garbage collection finished",
+					zap.Int("removed", report.Removed),
+					zap.Int64("removed_bytes", report.RemovedBytes),
+					zap.Int("recent", report.Recent),
+					zap.Duration("duration", report.Duration),
+				)
+			}
+		}
+	}()
+}
+
+// Run collects garbage once and records the collection in the history
+// and metrics.
+func (c *Collector) Run(ctx context.Context, opts Options) (Report, error) {
+	c.running.Lock()
+	defer c.running.Unlock()
+
+	start := time.Now()
+	report, err := c.run(ctx, opts, start)
+	report.Duration = time.Since(start)
+	c.record(start, opts, report, err)
+	return report, err
+}
+
+func (c *Collector) run(ctx context.Context, opts Options, start time.Time) (Report, error) {
+	report := Report{GracePeriod: c.GracePeriod}
+	if opts.GracePeriod > 0 {
+		report.GracePeriod = opts.GracePeriod
+	}
+
+	if len(opts.Unpinned) > 0 && !opts.DryRun {
+		return report, errors.New("gc: treating pins as removed needs a dry run")
+	}
+	if !opts.DryRun {
+		if c.Lock == nil {
+			return report, errors.New("gc: removing blocks needs the repo's write lock")
+		}
+		// Hold readers off for the whole collection so none of them starts
+		// reading a file that is being swept.
+		if err := c.Lock.TryExclusive(); err != nil {
+			return report, err
+		}
+		defer c.Lock.Done()
+	}
+
+	// Taken before the pins are read: files pinned later write or touch
+	// their blocks after start
+	cutoff := start.Add(-report.GracePeriod)
+	if since := c.Pins.PinningSince(); !since.IsZero() && since.Before(cutoff) {
+		cutoff = since
+	}
+
+	reachable := make(map[cid.Cid]bool)
+	marked := make(map[cid.Cid]bool)
+	// Marking the unpinned roots already leaves them out of the mark,
+	// unless another root links to them
+	for _, root := range opts.Unpinned {
+		marked[root] = true
+	}
+	if err := c.mark(ctx, reachable, marked); err != nil {
+		return report, err
+	}
+	freed := make(map[cid.Cid]bool)
+	for _, root := range opts.Unpinned {
+		if err := c.walk(ctx, root, freed); err != nil {
+			return report, err
+		}
+	}
+	evict := make(map[cid.Cid]bool)
+	for _, root := range opts.Evict {
+		if err := c.walk(ctx, root, evict); err != nil {
+			return report, err
+		}
+	}
+	// Blocks being stored or fetched to be pinned are written after the
+	// oldest such file started, or touched if stored already
+	evictCutoff := start
+	if since := c.Pins.PinningSince(); !since.IsZero() && since.Before(evictCutoff) {
+		evictCutoff = since
+	}
+
+	var garbage []storage.BlockInfo
+	err := c.Store.ListInfo(ctx, func(info storage.BlockInfo) error {
+		report.Scanned++
+		switch {
+		case reachable[info.CID]:
+		case evict[info.CID] && !info.ModTime.After(evictCutoff):
+			garbage = append(garbage, info)
+		case info.ModTime.After(cutoff):
+			report.Recent++
+		default:
+			garbage = append(garbage, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// Roots recorded while the store was listed, such as downloads, may
+	// be made of blocks that looked like garbage.
+	if err := c.mark(ctx, reachable, marked); err != nil {
+		return report, err
+	}
+
+	// Sweep directories before the files in them and files before their
+	// chunks, so each block's references are gone by the time it is
+	// reached. A chunk shared with a file that stays is kept.
+	slices.SortStableFunc(garbage, func(a, b storage.BlockInfo) int {
+		return sweepOrder(a.CID) - sweepOrder(b.CID)
+	})
+	// Dry runs delete nothing, so track the references they would drop
+	dropped := make(map[cid.Cid]int)
+	for _, info := range garbage {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if reachable[info.CID] {
+			continue
+		}
+		if c.Store.Refs(info.CID) > dropped[info.CID] {
+			report.Linked++
+			continue
+		}
+		if opts.DryRun {
+			links, err := manifest.Links(ctx, c.Store, info.CID)
+			if err == nil {
+				for _, link := range links {
+					dropped[link]++
+				}
+			}
+		} else {
+			// A block written since it was listed may belong to a file
+			// pinned after the mark
+			before := cutoff
+			if evict[info.CID] {
+				before = evictCutoff
+			}
+			err := c.Store.DeleteOlder(ctx, info.CID, before)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if errors.Is(err, storage.ErrRecent) {
+				report.Recent++
+				continue
+			}
+			if errors.Is(err, storage.ErrReferenced) {
+				report.Linked++
+				continue
+			}
+			if err != nil {
+				return report, err
+			}
+		}
+		report.Removed++
+		report.RemovedBytes += info.Size
+		if freed[info.CID] {
+			report.Freed++
+			report.FreedBytes += info.Size
+		}
+		if evict[info.CID] {
+			report.Evicted++
+			report.EvictedBytes += info.Size
+		}
+	}
+
+	report.Pins = c.Pins.Len()
+	report.Reachable = len(reachable)
+	return report, nil
+}
+
+// sweepOrder ranks blocks by when they are swept: directories, then
+// files, then chunks.
+func sweepOrder(c cid.Cid) int {
+	switch c.Type() {
+	case manifest.DirectoryCodec:
+		return 0
+	case manifest.Codec:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (c *Collector) record(start time.Time, opts Options, report Report, err error) {
+	if !opts.DryRun {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics.GCRuns.WithLabelValues(result).Inc()
+		metrics.GCDuration.Observe(report.Duration.Seconds())
+		metrics.GCRemovedBlocks.Add(float64(report.Removed))
+		metrics.GCRemovedBytes.Add(float64(report.RemovedBytes))
+		if err == nil {
+			metrics.GCScannedBlocks.Set(float64(report.Scanned))
+			metrics.GCLastSuccess.SetToCurrentTime()
+		}
+
+		switch {
+		case err != nil:
+			c.Events.Record(eventlog.Event{Type: eventlog.GCCollected, Fields: map[string]string{"error": err.Error()}})
+		case report.Removed > 0:
+			c.Events.Record(eventlog.Event{Type: eventlog.GCCollected, Fields: map[string]string{
+				"removed":       strconv.Itoa(report.Removed),
+				"removed_bytes": strconv.FormatInt(report.RemovedBytes, 10),
+				"recent":        strconv.Itoa(report.Recent),
+			}})
+		}
+	}
+
+	if c.HistoryPath == "" {
+		return
+	}
+	r := Record{
+		Started:      start,
+		Duration:     report.Duration,
+		DryRun:       opts.DryRun,
+		Scanned:      report.Scanned,
+		Removed:      report.Removed,
+		RemovedBytes: report.RemovedBytes,
+		Recent:       report.Recent,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if err := appendHistory(c.HistoryPath, r); err != nil {
+		c.logger.Warn("Failed to record garbage collection", zap.String("path", c.HistoryPath), zap.Error(err))
+	}
+}
+
+// mark adds the blocks of every pin or other root not in marked yet to
+// reachable, following directories down to the chunks of every file in
+// them. Archived pins keep their blocks in the cold store instead.
+func (c *Collector) mark(ctx context.Context, reachable, marked map[cid.Cid]bool) error {
+	var roots []cid.Cid
+	for _, p := range c.Pins.List() {
+		if p.Archived.IsZero() {
+			roots = append(roots, p.CID)
+		}
+	}
+	roots = append(roots, c.Pins.Held()...)
+	if c.Roots != nil {
+		roots = append(roots, c.Roots()...)
+	}
+
+	for _, root := range roots {
+		if marked[root] {
+			continue
+		}
+		marked[root] = true
+		if err := c.walk(ctx, root, reachable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walk adds root and the blocks it links to, down to the chunks, to seen.
+func (c *Collector) walk(ctx context.Context, root cid.Cid, seen map[cid.Cid]bool) error {
+	queue := []cid.Cid{root}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+
+		links, err := manifest.Links(ctx, c.Store, next)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Nothing of it is stored, so nothing can be swept by mistake.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("gc: load root %s: %w", root, err)
+		}
+		queue = append(queue, links...)
+	}
+	return nil
+}
@@ -0,0 +1,538 @@
+package gc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/node"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/repo"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// slowStore makes the node's reads slow, so that a pin takes long enough
+// checking a file's blocks for a collection to run meanwhile.
+type slowStore struct {
+	*storage.FSBlockstore
+}
+
+func (s slowStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	time.Sleep(time.Millisecond)
+	return s.FSBlockstore.Has(ctx, c)
+}
+
+func (s slowStore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	time.Sleep(time.Millisecond)
+	return s.FSBlockstore.Get(ctx, c)
+}
+
+// TestPinDuringCollection races pinning files stored long ago against
+// collections sweeping them. A pin that succeeds must keep every block of
+// its file; one that loses the race fails, as nothing can fetch the swept
+// blocks again.
+//
+// Each collection pauses between its last look at the pins and the sweep,
+// which is where a pin made meanwhile would go unnoticed. Pins start
+// before and after collections in turn.
+func TestPinDuringCollection(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	pins, err := pin.Open(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := repo.AcquireWrite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+
+	n := node.NewNode(node.NodeOpts{
+		Store:    slowStore{store},
+		Pins:     pins,
+		Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 256},
+	})
+	var marks atomic.Int32
+	collector := NewCollector(CollectorOpts{
+		Store: store,
+		Pins:  pins,
+		Lock:  lock,
+		Roots: func() []cid.Cid {
+			// Every collection marks twice; linger in the second
+			if marks.Add(1)%2 == 0 {
+				time.Sleep(100 * time.Millisecond)
+			}
+			return nil
+		},
+	})
+	ctx := context.Background()
+
+	var pinned, swept int
+	for i := range 10 {
+		data := bytes.Repeat([]byte(fmt.Sprintf("file %d ", i)), 2048)
+		res, err := n.Add(ctx, bytes.NewReader(data), node.AddOptions{NoPin: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the pin may keep the file
+		pins.Release(res.CID)
+
+		var (
+			wg     sync.WaitGroup
+			pinErr error
+			gcErr  error
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i%2) * 20 * time.Millisecond)
+			pinErr = n.Pin(ctx, res.CID, node.PinOptions{})
+		}()
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration((i+1)%2) * 20 * time.Millisecond)
+			// Everything stored so far is older than this
+			_, gcErr = collector.Run(ctx, Options{GracePeriod: time.Nanosecond})
+		}()
+		wg.Wait()
+
+		if gcErr != nil {
+			t.Fatalf("collection %d: %v", i, gcErr)
+		}
+		if pinErr != nil {
+			if pins.Has(res.CID) {
+				t.Fatalf("file %d pinned although pinning failed: %v", i, pinErr)
+			}
+			swept++
+			continue
+		}
+		pinned++
+		var got bytes.Buffer
+		if _, err := n.Get(ctx, res.CID, &got); err != nil {
+			t.Fatalf("file %d pinned but unreadable after a collection: %v", i, err)
+		}
+		if !bytes.Equal(got.Bytes(), data) {
+			t.Fatalf("file %d pinned but read back wrong", i)
+		}
+	}
+	t.Logf("%d files pinned first, %d swept first", pinned, swept)
+}
+
+func TestDryRunUnpinned(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	pins, err := pin.Open(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := node.NewNode(node.NodeOpts{
+		Store:    store,
+		Pins:     pins,
+		Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 256},
+	})
+	ctx := context.Background()
+
+	// Two pinned files sharing their first chunks
+	shared := bytes.Repeat([]byte("shared "), 128)
+	a, err := n.Add(ctx, bytes.NewReader(append(bytes.Clone(shared), bytes.Repeat([]byte("a"), 1000)...)), node.AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.Add(ctx, bytes.NewReader(append(bytes.Clone(shared), bytes.Repeat([]byte("b"), 1000)...)), node.AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := store.Stats().Blocks
+
+	collector := NewCollector(CollectorOpts{Store: store, Pins: pins})
+	report, err := collector.Run(ctx, Options{GracePeriod: time.Nanosecond, DryRun: true, Unpinned: []cid.Cid{a.CID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a's manifest and the chunks of its own tail, not the shared ones
+	links, err := manifest.Links(ctx, store, a.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own := map[cid.Cid]bool{a.CID: true}
+	for _, l := range links {
+		if !slices.Contains(mustLinks(t, store, b.CID), l) {
+			own[l] = true
+		}
+	}
+	if len(own) == len(links)+1 {
+		t.Fatal("the files share no chunks")
+	}
+	if report.Freed != len(own) || report.Removed != len(own) {
+		t.Errorf("unpinning a frees %d blocks (%d removed), want %d", report.Freed, report.Removed, len(own))
+	}
+	if !pins.Has(a.CID) {
+		t.Error("dry run removed the pin")
+	}
+	if left := store.Stats().Blocks; left != blocks {
+		t.Errorf("dry run left %d of %d blocks", left, blocks)
+	}
+
+	if _, err := collector.Run(ctx, Options{Unpinned: []cid.Cid{a.CID}}); err == nil {
+		t.Error("collection treating a pin as removed ran for real")
+	}
+}
+
+// Archived pins are evicted at once, without the blocks they share with
+// other pins.
+func TestEvictArchived(t *testing.T) {
+	n, store, pins, collector := openCollector(t)
+	ctx := context.Background()
+
+	shared := bytes.Repeat([]byte("shared "), 128)
+	a, err := n.Add(ctx, bytes.NewReader(append(bytes.Clone(shared), bytes.Repeat([]byte("a"), 1000)...)), node.AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.Add(ctx, bytes.NewReader(append(bytes.Clone(shared), bytes.Repeat([]byte("b"), 1000)...)), node.AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := store.Stats().Blocks
+
+	// A pin not archived keeps its blocks
+	report, err := collector.Run(ctx, Options{Evict: []cid.Cid{a.CID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Evicted != 0 || store.Stats().Blocks != blocks {
+		t.Fatalf("evicted %d blocks of a pin not archived", report.Evicted)
+	}
+
+	if err := pins.SetArchived(a.CID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// An ordinary collection leaves the blocks to the grace period
+	report, err = collector.Run(ctx, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != 0 {
+		t.Fatalf("removed %d blocks within the grace period", report.Removed)
+	}
+
+	own := map[cid.Cid]bool{a.CID: true}
+	for _, l := range mustLinks(t, store, a.CID) {
+		if !slices.Contains(mustLinks(t, store, b.CID), l) {
+			own[l] = true
+		}
+	}
+	report, err = collector.Run(ctx, Options{Evict: []cid.Cid{a.CID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Evicted != len(own) || report.Removed != len(own) {
+		t.Errorf("evicted %d blocks (%d removed), want %d", report.Evicted, report.Removed, len(own))
+	}
+	for c := range own {
+		if has, _ := store.Has(ctx, c); has {
+			t.Errorf("%s of the archived pin kept", c)
+		}
+	}
+	var out bytes.Buffer
+	if _, err := n.Get(ctx, b.CID, &out); err != nil {
+		t.Fatalf("b after evicting a: %v", err)
+	}
+}
+
+func mustLinks(t *testing.T, store *storage.FSBlockstore, c cid.Cid) []cid.Cid {
+	t.Helper()
+	links, err := manifest.Links(context.Background(), store, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return links
+}
+
+// openCollector returns a node and a collector sharing a fresh repo.
+func openCollector(t *testing.T) (*node.Node, *storage.FSBlockstore, *pin.Set, *Collector) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	pins, err := pin.Open(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := repo.AcquireWrite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lock.Close() })
+
+	n := node.NewNode(node.NodeOpts{
+		Store:    store,
+		Pins:     pins,
+		Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 256},
+	})
+	return n, store, pins, NewCollector(CollectorOpts{Store: store, Pins: pins, Lock: lock})
+}
+
+// Files added to be put in a directory are kept until the directory is
+// pinned, however long that takes.
+func TestSessionPins(t *testing.T) {
+	ctx := context.Background()
+	n, store, pins, collector := openCollector(t)
+
+	res, err := n.Add(ctx, bytes.NewReader(bytes.Repeat([]byte("entry "), 200)), node.AddOptions{Name: "a.txt", NoPin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := store.Stats().Blocks
+	report, err := collector.Run(ctx, Options{GracePeriod: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != 0 {
+		t.Fatalf("collection removed %d blocks of a file held for a directory", report.Removed)
+	}
+
+	dir, _, err := n.MakeDirectory(ctx, []node.DirectoryEntry{{Name: "a.txt", CID: res.CID}}, node.DirectoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held := pins.Held(); len(held) != 0 {
+		t.Errorf("session pins %v left once the directory is pinned", held)
+	}
+	if err := n.Unpin(dir); err != nil {
+		t.Fatal(err)
+	}
+	report, err = collector.Run(ctx, Options{GracePeriod: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != int(blocks)+1 {
+		t.Errorf("removed %d blocks after unpinning, want all %d", report.Removed, blocks+1)
+	}
+}
+
+// Blocks written since a file still being pinned started are kept, as the
+// file may need them.
+func TestPinningKeepsNewBlocks(t *testing.T) {
+	ctx := context.Background()
+	_, store, pins, collector := openCollector(t)
+
+	done := pins.Pinning()
+	// File times lag the clock by up to a tick
+	time.Sleep(10 * time.Millisecond)
+	b, err := storage.NewRawBlock([]byte("written while pinning"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, b.CID, b.Data); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	report, err := collector.Run(ctx, Options{GracePeriod: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != 0 || report.Recent != 1 {
+		t.Errorf("collection removed %d, kept %d recent blocks, want the block kept", report.Removed, report.Recent)
+	}
+
+	done()
+	report, err = collector.Run(ctx, Options{GracePeriod: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != 1 {
+		t.Errorf("collection removed %d blocks once pinning gave up, want 1", report.Removed)
+	}
+}
+
+// With pins.json lost, the roots found in the store pin everything that
+// was pinned, and a collection after keeps it all.
+func TestRestorePins(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.CountRefs(ctx, manifest.DecodeLinks, manifest.LinkCodecs...); err != nil {
+		t.Fatal(err)
+	}
+	pinsPath := filepath.Join(dir, "pins.json")
+	pins, err := pin.Open(pinsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := repo.AcquireWrite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	n := node.NewNode(node.NodeOpts{
+		Store:    store,
+		Pins:     pins,
+		Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 256},
+	})
+
+	add := func(name string, opts node.AddOptions) cid.Cid {
+		t.Helper()
+		opts.Name = name
+		res, err := n.Add(ctx, bytes.NewReader(bytes.Repeat([]byte(name), 300)), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.CID
+	}
+	file := add("file", node.AddOptions{})
+	inner, _, err := n.MakeDirectory(ctx, []node.DirectoryEntry{{Name: "b", CID: add("b", node.AddOptions{NoPin: true})}}, node.DirectoryOptions{NoPin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _, err := n.MakeDirectory(ctx, []node.DirectoryEntry{
+		{Name: "a", CID: add("a", node.AddOptions{NoPin: true})},
+		{Name: "inner", CID: inner},
+	}, node.DirectoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := store.Stats().Blocks
+
+	if err := os.Remove(pinsPath); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := pin.Open(pinsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ScanStore(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := RestorePins(restored, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cid.Cid{tree, file}
+	if !slices.Equal(sortCIDs(added), sortCIDs(want)) {
+		t.Errorf("restored pins %v, want %v", added, want)
+	}
+	if idx.Unreferenced != 0 {
+		t.Errorf("%d unreferenced chunks", idx.Unreferenced)
+	}
+
+	collector := NewCollector(CollectorOpts{Store: store, Pins: restored, Lock: lock})
+	report, err := collector.Run(ctx, Options{GracePeriod: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != 0 || store.Stats().Blocks != blocks {
+		t.Errorf("collection after restoring the pins removed %d of %d blocks", report.Removed, blocks)
+	}
+}
+
+func sortCIDs(cids []cid.Cid) []cid.Cid {
+	sorted := slices.Clone(cids)
+	slices.SortFunc(sorted, func(a, b cid.Cid) int { return strings.Compare(a.KeyString(), b.KeyString()) })
+	return sorted
+}
+
+// The inventory tells own files from replicas kept for peers, adds in
+// progress and cached blocks, and purging a peer's replicas leaves only
+// what is own or pending.
+func TestInventory(t *testing.T) {
+	ctx := context.Background()
+	n, store, pins, collector := openCollector(t)
+
+	add := func(name string, noPin bool) (cid.Cid, int) {
+		t.Helper()
+		var b strings.Builder
+		for i := range 40 {
+			fmt.Fprintf(&b, "%s line %d\n", name, i)
+		}
+		res, err := n.Add(ctx, strings.NewReader(b.String()), node.AddOptions{Name: name, NoPin: noPin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks := map[cid.Cid]bool{res.CID: true}
+		for _, link := range mustLinks(t, store, res.CID) {
+			blocks[link] = true
+		}
+		return res.CID, len(blocks)
+	}
+	_, ownBlocks := add("own", false)
+	replica, replicaBlocks := add("replica", false)
+	_, pendingBlocks := add("pending", true)
+	from := peer.ID("peer-a")
+	if err := pins.SetKeptFor(replica, from, 0); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := storage.NewRawBlock([]byte("fetched and never pinned"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, cached.CID, cached.Data); err != nil {
+		t.Fatal(err)
+	}
+
+	inv, err := collector.Inventory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Own.Pins != 1 || inv.Own.Blocks != ownBlocks {
+		t.Errorf("own = %+v, want 1 pin, %d blocks", inv.Own, ownBlocks)
+	}
+	if got := inv.Replicas[from]; got.Pins != 1 || got.Blocks != replicaBlocks {
+		t.Errorf("replicas for %s = %+v, want 1 pin, %d blocks", from, got, replicaBlocks)
+	}
+	if inv.Pending.Pins != 1 || inv.Pending.Blocks != pendingBlocks {
+		t.Errorf("pending = %+v, want 1 pin, %d blocks", inv.Pending, pendingBlocks)
+	}
+	if inv.Cache.Blocks != 1 || inv.Cache.Bytes != int64(len(cached.Data)) {
+		t.Errorf("cache = %+v, want the one unpinned block", inv.Cache)
+	}
+
+	// Purging the peer's replicas
+	if _, err := pins.Remove(replica); err != nil {
+		t.Fatal(err)
+	}
+	report, err := collector.Run(ctx, Options{GracePeriod: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != replicaBlocks+1 {
+		t.Errorf("purge removed %d blocks, want %d", report.Removed, replicaBlocks+1)
+	}
+	inv, err = collector.Inventory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Replicas) != 0 || inv.Cache.Blocks != 0 || inv.Own.Blocks != ownBlocks || inv.Pending.Blocks != pendingBlocks {
+		t.Errorf("inventory after the purge = %+v", inv)
+	}
+}
@@ -0,0 +1,88 @@
+package gc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaxHistory is the number of collections kept in the history file.
+const MaxHistory = 200
+
+// Record is a collection as kept in the history.
+type Record struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	DryRun   bool          `json:"dry_run,omitempty"`
+	// Scanned counts the blocks in the store when it was swept.
+	Scanned      int    `json:"scanned"`
+	Removed      int    `json:"removed"`
+	RemovedBytes int64  `json:"removed_bytes"`
+	Recent       int    `json:"recent"`
+	Error        string `json:"error,omitempty"`
+}
+
+// ReadHistory returns the recorded collections, oldest first. A missing
+// file is an empty history.
+func ReadHistory(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		// A line cut short by a crash is skipped rather than failing the
+		// whole history.
+		if json.Unmarshal(scanner.Bytes(), &r) == nil {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// appendHistory adds r to the history at path, dropping the oldest
+// records beyond MaxHistory.
+func appendHistory(path string, r Record) error {
+	records, err := ReadHistory(path)
+	if err != nil {
+		return err
+	}
+	records = append(records, r)
+	if len(records) > MaxHistory {
+		records = records[len(records)-MaxHistory:]
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".gc-history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
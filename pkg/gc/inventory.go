@@ -0,0 +1,105 @@
+package gc
+
+import (
+	"context"
+
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Origin sums up the stored content of one origin.
+type Origin struct {
+	// Pins counts the pins of the origin, for Pending the session pins
+	// and downloads. Blocks and Bytes are the stored blocks they refer to.
+	Pins   int
+	Blocks int
+	Bytes  int64
+}
+
+func (o *Origin) add(info storage.BlockInfo) {
+	o.Blocks++
+	o.Bytes += info.Size
+}
+
+// Inventory is what the block store holds, by where it came from.
+type Inventory struct {
+	// Own is what this node's own pins refer to: files added or pinned
+	// here.
+	Own Origin
+	// Replicas is what the replicas kept for each peer refer to. A block
+	// of replicas kept for several peers counts for each of them, and one
+	// an own pin refers to counts as own only.
+	Replicas map[peer.ID]Origin
+	// Pending is what adds and downloads still in progress hold, which a
+	// collection keeps.
+	Pending Origin
+	// Cache is every other block: fetched and not pinned, or left by
+	// removed pins. A collection removes it once the grace period is over.
+	Cache Origin
+}
+
+// Inventory sorts the stored blocks by origin. Nothing is removed.
+func (c *Collector) Inventory(ctx context.Context) (*Inventory, error) {
+	inv := &Inventory{Replicas: make(map[peer.ID]Origin)}
+
+	own := make(map[cid.Cid]bool)
+	kept := make(map[peer.ID]map[cid.Cid]bool)
+	for _, p := range c.Pins.List() {
+		seen := own
+		if p.KeptFor != "" {
+			if kept[p.KeptFor] == nil {
+				kept[p.KeptFor] = make(map[cid.Cid]bool)
+			}
+			seen = kept[p.KeptFor]
+			o := inv.Replicas[p.KeptFor]
+			o.Pins++
+			inv.Replicas[p.KeptFor] = o
+		} else {
+			inv.Own.Pins++
+		}
+		if err := c.walk(ctx, p.CID, seen); err != nil {
+			return nil, err
+		}
+	}
+
+	pending := make(map[cid.Cid]bool)
+	roots := c.Pins.Held()
+	if c.Roots != nil {
+		roots = append(roots, c.Roots()...)
+	}
+	inv.Pending.Pins = len(roots)
+	for _, root := range roots {
+		if err := c.walk(ctx, root, pending); err != nil {
+			return nil, err
+		}
+	}
+
+	err := c.Store.ListInfo(ctx, func(info storage.BlockInfo) error {
+		if own[info.CID] {
+			inv.Own.add(info)
+			return nil
+		}
+		replica := false
+		for id, seen := range kept {
+			if seen[info.CID] {
+				o := inv.Replicas[id]
+				o.add(info)
+				inv.Replicas[id] = o
+				replica = true
+			}
+		}
+		switch {
+		case replica:
+		case pending[info.CID]:
+			inv.Pending.add(info)
+		default:
+			inv.Cache.add(info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
@@ -0,0 +1,89 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+// Index is what the block store holds, as found by ScanStore.
+type Index struct {
+	Manifests   []cid.Cid
+	Directories []cid.Cid
+	// Roots are the manifests and directories no stored directory refers
+	// to: the files and trees added on their own, which is what a lost
+	// pin set pinned.
+	Roots []cid.Cid
+	// Unreferenced counts the chunks no stored manifest refers to.
+	Unreferenced int
+}
+
+// ScanStore lists the file manifests and directories in store and
+// derives the roots among them.
+func ScanStore(ctx context.Context, store storage.Blockstore) (*Index, error) {
+	var (
+		idx        Index
+		chunks     []cid.Cid
+		referenced = make(map[cid.Cid]bool)
+	)
+	err := store.List(ctx, func(c cid.Cid) error {
+		switch c.Type() {
+		case manifest.Codec:
+			idx.Manifests = append(idx.Manifests, c)
+		case manifest.DirectoryCodec:
+			idx.Directories = append(idx.Directories, c)
+		default:
+			chunks = append(chunks, c)
+			return nil
+		}
+
+		links, err := manifest.Links(ctx, store, c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c, err)
+		}
+		for _, link := range links {
+			referenced[link] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range idx.Directories {
+		if !referenced[c] {
+			idx.Roots = append(idx.Roots, c)
+		}
+	}
+	for _, c := range idx.Manifests {
+		if !referenced[c] {
+			idx.Roots = append(idx.Roots, c)
+		}
+	}
+	for _, c := range chunks {
+		if !referenced[c] {
+			idx.Unreferenced++
+		}
+	}
+	return &idx, nil
+}
+
+// RestorePins pins the roots of idx that pins doesn't hold yet and
+// returns them.
+func RestorePins(pins *pin.Set, idx *Index) ([]cid.Cid, error) {
+	var added []cid.Cid
+	for _, c := range idx.Roots {
+		if pins.Has(c) {
+			continue
+		}
+		if err := pins.Add(c); err != nil {
+			return added, err
+		}
+		added = append(added, c)
+	}
+	return added, nil
+}
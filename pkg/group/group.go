@@ -0,0 +1,323 @@
+// Package group lets the members of a group read each other's encrypted
+// files, for folders a team shares, without a secret every member holds
+// for good.
+//
+// A group's files are encrypted as any others, with their keys wrapped
+// by a group key where a user's own files have their master key. The
+// group document lists the group keys, each sealed to the node key of
+// every member allowed to read with it, so only members can open them.
+// The admin, the node that created the group, adds and removes members.
+// A new member gets every key, and can read what the group holds. A
+// removed member loses them from the document and the group moves on to
+// a new key, which only the remaining members get: files added from then
+// on are out of the removed member's reach, while those it could read
+// before are left as they are, rather than encrypted again.
+//
+// The document is signed by the admin and published under a name of its,
+// so members follow its changes by resolving it.
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/share"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Version is the version of the group document format.
+const Version = 1
+
+// domain binds sealed group keys to their purpose, and signDomain the
+// admin's signatures.
+const (
+	domain     = "dfs-group:"
+	signDomain = "dfs-group-doc:"
+)
+
+// KeyPrefix starts the name of the key a group's document is published
+// under, followed by the group name.
+const KeyPrefix = "group-"
+
+var (
+	// ErrInvalidName is returned for a name a group can't have.
+	ErrInvalidName = errors.New("group: invalid name")
+	// ErrNotMember is returned when a node that isn't a member of a
+	// group asks for its keys.
+	ErrNotMember = errors.New("group: not a member")
+	// ErrNotAdmin is returned when changing a group from a node other
+	// than its admin.
+	ErrNotAdmin = errors.New("group: only the admin can change the members")
+	// ErrBadSignature is returned for a document not signed by its admin.
+	ErrBadSignature = errors.New("group: bad signature")
+)
+
+// Names leave room for KeyPrefix within the 64 characters of a name key.
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,58}$`)
+
+// CheckName checks that name can name a group.
+func CheckName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("%w %q: use up to 58 letters, digits, - and _", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// Group is the document describing a group.
+type Group struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Admin   string `json:"admin"`
+	// Members are the peer IDs of the members, the admin included.
+	Members []string `json:"members"`
+	// Generations are the group keys, oldest first. The last is the one
+	// files are encrypted with.
+	Generations []Generation `json:"generations"`
+	// Signature is the admin's, by its node key, over the rest.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// Generation is a group key.
+type Generation struct {
+	KeyID   string    `json:"key_id"`
+	Created time.Time `json:"created"`
+	// Keys are the key sealed to each member, by peer ID.
+	Keys map[string]SealedKey `json:"keys"`
+}
+
+// SealedKey is a group key sealed to a member's node key.
+type SealedKey struct {
+	Ephemeral []byte `json:"ephemeral"`
+	Key       []byte `json:"key"`
+}
+
+// New creates the group name administered by admin, the node key, with
+// the given members besides the admin.
+func New(name string, admin crypto.PrivKey, members []peer.ID) (*Group, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	self, err := peer.IDFromPrivateKey(admin)
+	if err != nil {
+		return nil, err
+	}
+	g := &Group{Version: Version, Name: name, Admin: self.String(), Members: []string{self.String()}}
+	for _, m := range members {
+		if !slices.Contains(g.Members, m.String()) {
+			g.Members = append(g.Members, m.String())
+		}
+	}
+	if err := g.rotate(); err != nil {
+		return nil, err
+	}
+	if err := g.sign(admin); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Parse decodes a group document and checks its admin signed it.
+func Parse(data []byte) (*Group, error) {
+	var g Group
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("group: %w", err)
+	}
+	if g.Version != Version {
+		return nil, fmt.Errorf("group: unsupported version %d", g.Version)
+	}
+	if err := CheckName(g.Name); err != nil {
+		return nil, err
+	}
+	if len(g.Generations) == 0 {
+		return nil, fmt.Errorf("group %s: no keys", g.Name)
+	}
+	if err := g.verify(); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Marshal encodes the document.
+func (g *Group) Marshal() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+// Current returns the ID of the key files are encrypted with.
+func (g *Group) Current() string {
+	return g.Generations[len(g.Generations)-1].KeyID
+}
+
+// Keys opens the group keys sealed to priv, a member's node key, by key
+// ID.
+func (g *Group) Keys(priv crypto.PrivKey) (map[string]*crypt.MasterKey, error) {
+	self, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*crypt.MasterKey)
+	for _, gen := range g.Generations {
+		sealed, ok := gen.Keys[self.String()]
+		if !ok {
+			continue
+		}
+		secret, err := share.OpenKey(priv, sealed.Ephemeral, sealed.Key, g.aad(gen.KeyID, self))
+		if err != nil {
+			return nil, fmt.Errorf("group %s key %s: %w", g.Name, gen.KeyID, err)
+		}
+		key, err := crypt.DecodeMasterKey(secret)
+		clear(secret)
+		if err != nil || key.ID() != gen.KeyID {
+			return nil, fmt.Errorf("group %s key %s: %w", g.Name, gen.KeyID, crypt.ErrDecrypt)
+		}
+		keys[gen.KeyID] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("group %s: %w", g.Name, ErrNotMember)
+	}
+	return keys, nil
+}
+
+// Add makes the peers members, sealing every key of the group to them.
+// admin is the admin's node key.
+func (g *Group) Add(admin crypto.PrivKey, members ...peer.ID) error {
+	keys, err := g.adminKeys(admin)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if slices.Contains(g.Members, m.String()) {
+			continue
+		}
+		g.Members = append(g.Members, m.String())
+		for i := range g.Generations {
+			gen := &g.Generations[i]
+			if err := g.seal(gen, keys[gen.KeyID], m); err != nil {
+				return err
+			}
+		}
+	}
+	return g.sign(admin)
+}
+
+// Remove drops the peers from the group and the keys sealed to them, and
+// moves the group on to a new key the remaining members get. Files
+// encrypted from then on are out of the reach of those removed.
+func (g *Group) Remove(admin crypto.PrivKey, members ...peer.ID) error {
+	if _, err := g.adminKeys(admin); err != nil {
+		return err
+	}
+	removed := false
+	for _, m := range members {
+		if m.String() == g.Admin {
+			return errors.New("group: the admin can't be removed")
+		}
+		i := slices.Index(g.Members, m.String())
+		if i < 0 {
+			continue
+		}
+		g.Members = slices.Delete(g.Members, i, i+1)
+		for _, gen := range g.Generations {
+			delete(gen.Keys, m.String())
+		}
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	if err := g.rotate(); err != nil {
+		return err
+	}
+	return g.sign(admin)
+}
+
+// adminKeys checks that admin is the admin's key and opens the keys.
+func (g *Group) adminKeys(admin crypto.PrivKey) (map[string]*crypt.MasterKey, error) {
+	self, err := peer.IDFromPrivateKey(admin)
+	if err != nil {
+		return nil, err
+	}
+	if self.String() != g.Admin {
+		return nil, fmt.Errorf("group %s: %w, %s", g.Name, ErrNotAdmin, g.Admin)
+	}
+	return g.Keys(admin)
+}
+
+// signed returns what the signature covers.
+func (g *Group) signed() ([]byte, error) {
+	unsigned := *g
+	unsigned.Signature = nil
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(signDomain), data...), nil
+}
+
+func (g *Group) sign(admin crypto.PrivKey) error {
+	data, err := g.signed()
+	if err != nil {
+		return err
+	}
+	g.Signature, err = admin.Sign(data)
+	return err
+}
+
+// verify checks the signature against the key of the admin's peer ID.
+func (g *Group) verify() error {
+	id, err := peer.Decode(g.Admin)
+	if err != nil {
+		return fmt.Errorf("group %s: admin: %w", g.Name, err)
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("group %s: admin %s: %w", g.Name, id, err)
+	}
+	data, err := g.signed()
+	if err != nil {
+		return err
+	}
+	if ok, err := pub.Verify(data, g.Signature); err != nil || !ok {
+		return fmt.Errorf("group %s: %w", g.Name, ErrBadSignature)
+	}
+	return nil
+}
+
+// rotate adds a new key, sealed to every member.
+func (g *Group) rotate() error {
+	key, err := crypt.GenerateMasterKey()
+	if err != nil {
+		return err
+	}
+	gen := Generation{KeyID: key.ID(), Created: time.Now().UTC(), Keys: make(map[string]SealedKey)}
+	for _, m := range g.Members {
+		id, err := peer.Decode(m)
+		if err != nil {
+			return fmt.Errorf("group %s: member %s: %w", g.Name, m, err)
+		}
+		if err := g.seal(&gen, key, id); err != nil {
+			return err
+		}
+	}
+	g.Generations = append(g.Generations, gen)
+	return nil
+}
+
+func (g *Group) seal(gen *Generation, key *crypt.MasterKey, to peer.ID) error {
+	ephemeral, sealed, err := share.SealKey(to, key.Encode(), g.aad(gen.KeyID, to))
+	if err != nil {
+		return fmt.Errorf("group %s: member %s: %w", g.Name, to, err)
+	}
+	gen.Keys[to.String()] = SealedKey{Ephemeral: ephemeral, Key: sealed}
+	return nil
+}
+
+// aad binds a sealed key to its group, key ID and member.
+func (g *Group) aad(keyID string, member peer.ID) []byte {
+	return []byte(domain + g.Name + "/" + keyID + "/" + member.String())
+}
@@ -0,0 +1,239 @@
+package group
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func nodeKey(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, id
+}
+
+// roundTrip encodes and decodes g, as members get it.
+func roundTrip(t *testing.T, g *Group) *Group {
+	t.Helper()
+	data, err := g.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestMembers(t *testing.T) {
+	admin, _ := nodeKey(t)
+	alice, aliceID := nodeKey(t)
+	bob, _ := nodeKey(t)
+
+	g, err := New("team", admin, []peer.ID{aliceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g = roundTrip(t, g)
+	if len(g.Members) != 2 {
+		t.Fatalf("members = %v, want the admin and alice", g.Members)
+	}
+
+	adminKeys, err := g.Keys(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKeys, err := g.Keys(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := g.Current()
+	if adminKeys[current] == nil || aliceKeys[current] == nil {
+		t.Fatalf("current key %s missing", current)
+	}
+
+	// A file key wrapped by one member unwraps for the other
+	fileKey, err := crypt.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := adminKeys[current].Wrap(fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aliceKeys[current].Unwrap(current, wrapped); err != nil {
+		t.Fatalf("alice unwrap: %v", err)
+	}
+
+	if _, err := g.Keys(bob); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("bob's keys: err = %v, want ErrNotMember", err)
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	admin, _ := nodeKey(t)
+	alice, aliceID := nodeKey(t)
+	bob, bobID := nodeKey(t)
+
+	g, err := New("team", admin, []peer.ID{aliceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := g.Current()
+
+	if err := g.Remove(admin, aliceID); err != nil {
+		t.Fatal(err)
+	}
+	if g.Current() == first || len(g.Generations) != 2 {
+		t.Fatalf("removing a member didn't move on to a new key")
+	}
+	if _, err := g.Keys(alice); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("removed member's keys: err = %v, want ErrNotMember", err)
+	}
+
+	// A member added later reads every key, the old ones included
+	if err := g.Add(admin, bobID); err != nil {
+		t.Fatal(err)
+	}
+	g = roundTrip(t, g)
+	keys, err := g.Keys(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys[first] == nil || keys[g.Current()] == nil {
+		t.Fatalf("new member got keys %v, want %s and %s", keys, first, g.Current())
+	}
+
+	// Removing someone who isn't a member changes nothing
+	if err := g.Remove(admin, aliceID); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Generations) != 2 {
+		t.Fatalf("generations = %d, want 2", len(g.Generations))
+	}
+}
+
+func TestOnlyAdmin(t *testing.T) {
+	admin, adminID := nodeKey(t)
+	alice, aliceID := nodeKey(t)
+	_, bobID := nodeKey(t)
+
+	g, err := New("team", admin, []peer.ID{aliceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Add(alice, bobID); !errors.Is(err, ErrNotAdmin) {
+		t.Fatalf("member adding: err = %v, want ErrNotAdmin", err)
+	}
+	if err := g.Remove(alice, adminID); !errors.Is(err, ErrNotAdmin) {
+		t.Fatalf("member removing: err = %v, want ErrNotAdmin", err)
+	}
+	if err := g.Remove(admin, adminID); err == nil {
+		t.Fatal("admin removed itself")
+	}
+}
+
+func TestSealedToMember(t *testing.T) {
+	admin, _ := nodeKey(t)
+	alice, aliceID := nodeKey(t)
+	_, bobID := nodeKey(t)
+
+	g, err := New("team", admin, []peer.ID{aliceID, bobID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Alice's key copied over to bob's isn't bob's to open
+	gen := g.Generations[0]
+	gen.Keys[bobID.String()], gen.Keys[aliceID.String()] = gen.Keys[aliceID.String()], gen.Keys[bobID.String()]
+	if _, err := g.Keys(alice); err == nil {
+		t.Fatal("opened a key sealed to another member")
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	for _, name := range []string{"", "a/b", "with space", string(make([]byte, 59))} {
+		if err := CheckName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("CheckName(%q) = %v, want ErrInvalidName", name, err)
+		}
+	}
+	if err := CheckName("team-1_a"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	admin, _ := nodeKey(t)
+	other, _ := nodeKey(t)
+	g, err := New("team", admin, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "groups.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(Entry{Group: g, Name: "k51name", CID: "bafy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := s.Get("team")
+	if !ok || e.Name != "k51name" || e.Updated.IsZero() {
+		t.Fatalf("reopened store: %+v, %v", e, ok)
+	}
+	if _, ok := s.ByKey(g.Current()); !ok {
+		t.Fatal("group not found by its key")
+	}
+	if _, ok := s.ByKey("nope"); ok {
+		t.Fatal("found a group by a key it doesn't have")
+	}
+
+	// Another admin's group of the same name doesn't replace it
+	g2, err := New("team", other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(Entry{Group: g2}); err == nil {
+		t.Fatal("another admin's group replaced the one kept")
+	}
+	if len(s.List()) != 1 {
+		t.Fatalf("list = %d groups, want 1", len(s.List()))
+	}
+}
+
+func TestSignature(t *testing.T) {
+	admin, _ := nodeKey(t)
+	_, aliceID := nodeKey(t)
+	g, err := New("team", admin, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, g)
+
+	// A member slipped in by anyone but the admin is refused
+	g.Members = append(g.Members, aliceID.String())
+	data, err := g.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(data); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Parse(tampered) = %v, want ErrBadSignature", err)
+	}
+}
@@ -0,0 +1,132 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+)
+
+// Entry is a group a node created or joined.
+type Entry struct {
+	Group *Group `json:"group"`
+	// Name is the name the document is published under, and CID the
+	// version of it last seen.
+	Name    string    `json:"name"`
+	CID     string    `json:"cid"`
+	Updated time.Time `json:"updated"`
+}
+
+// Store keeps the groups of a node, by group name, in a JSON file like
+// the pin set. The group keys in them stay sealed to the node key.
+type Store struct {
+	path string
+
+	mu     sync.Mutex
+	groups map[string]Entry
+}
+
+// OpenStore loads the groups at path. A missing file is an empty store;
+// an empty path keeps them in memory only.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, groups: make(map[string]Entry)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Entry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, e := range list {
+		if e.Group == nil {
+			return nil, fmt.Errorf("group store %s: entry without a group", path)
+		}
+		s.groups[e.Group.Name] = e
+	}
+	return s, nil
+}
+
+// Put keeps e, replacing the earlier version of its group. A group of the
+// same name but another admin is refused.
+func (s *Store) Put(e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.groups[e.Group.Name]; ok && old.Group.Admin != e.Group.Admin {
+		return fmt.Errorf("group %s: already have a group of that name, from %s", e.Group.Name, old.Group.Admin)
+	}
+	e.Updated = time.Now().UTC()
+	s.groups[e.Group.Name] = e
+	return s.save()
+}
+
+// Get returns the group called name.
+func (s *Store) Get(name string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.groups[name]
+	return e, ok
+}
+
+// List returns the groups, by name.
+func (s *Store) List() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+// ByKey returns the group holding the key with the given ID.
+func (s *Store) ByKey(keyID string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.groups {
+		for _, gen := range e.Group.Generations {
+			if gen.KeyID == keyID {
+				return e, true
+			}
+		}
+	}
+	return Entry{}, false
+}
+
+func (s *Store) sorted() []Entry {
+	list := make([]Entry, 0, len(s.groups))
+	for _, e := range s.groups {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Group.Name < list[j].Group.Name })
+	return list
+}
+
+// save writes the store atomically. Callers hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(s.path, data, 0600)
+}
@@ -0,0 +1,346 @@
+// Package health keeps rolling failure rates of the daemon's operations,
+// per subsystem, and judges them against error budgets.
+//
+// Each subsystem has an objective, the share of its operations expected to
+// succeed; the rest is its error budget. The burn rate is how fast failures
+// spend that budget: at 1 they spend exactly all of it over a window. A
+// subsystem is yellow while either the short or the long window burns
+// faster than that, and red once the long window's budget is spent and the
+// short window is still burning.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Subsystems tracked.
+const (
+	DHT   = "dht"
+	Dial  = "dial"
+	Fetch = "fetch"
+)
+
+// Status of a subsystem, or of the node as the worst of them.
+const (
+	Green  = "green"
+	Yellow = "yellow"
+	Red    = "red"
+)
+
+const (
+	ShortWindow = 5 * time.Minute
+	LongWindow  = time.Hour
+
+	// Failures are counted in buckets of bucketWidth, covering LongWindow.
+	bucketWidth = 10 * time.Second
+	buckets     = int(LongWindow / bucketWidth)
+
+	// minOps is the number of operations a window needs before its
+	// failure rate is judged, so a handful of failures on an idle node
+	// don't turn it red.
+	minOps = 10
+)
+
+// DefaultObjectives are used for the subsystems Objectives leaves out.
+// Dials fail routinely on a peer-to-peer network, as peers go away and
+// announce stale addresses, so their objective is the loosest.
+var DefaultObjectives = map[string]float64{
+	DHT:   0.95,
+	Dial:  0.9,
+	Fetch: 0.99,
+}
+
+// subsystems lists the subsystems in the order they are reported.
+var subsystems = []string{DHT, Dial, Fetch}
+
+type TrackerOpts struct {
+	// Objectives are the shares of operations expected to succeed, by
+	// subsystem. Zero uses the subsystem's default.
+	Objectives map[string]float64
+	Logger     *zap.Logger
+}
+
+// Tracker records the outcome of operations. A nil Tracker records
+// nothing.
+type Tracker struct {
+	mu     sync.Mutex
+	series map[string]*series
+	// status is the last status reported for each subsystem, to log
+	// changes.
+	status map[string]string
+
+	TrackerOpts
+}
+
+type bucket struct {
+	// slot is the bucketWidth-long period since the epoch this bucket
+	// counts.
+	slot     int64
+	ops      int64
+	failures int64
+}
+
+type series struct {
+	buckets [buckets]bucket
+}
+
+func NewTracker(opts TrackerOpts) *Tracker {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	objectives := make(map[string]float64, len(DefaultObjectives))
+	for sub, def := range DefaultObjectives {
+		objectives[sub] = def
+		if o := opts.Objectives[sub]; o > 0 {
+			objectives[sub] = o
+		}
+	}
+	opts.Objectives = objectives
+
+	t := &Tracker{
+		series:      make(map[string]*series),
+		status:      make(map[string]string),
+		TrackerOpts: opts,
+	}
+	for _, sub := range subsystems {
+		t.series[sub] = &series{}
+		t.status[sub] = Green
+	}
+	return t
+}
+
+// Start judges the subsystems every bucketWidth until ctx ends, so status
+// changes are logged as they happen.
+func (t *Tracker) Start(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(bucketWidth)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.Health()
+			}
+		}
+	}()
+}
+
+// Record counts an operation of subsystem that ended with err. Operations
+// cancelled by the caller, such as a fetch that another peer won, are
+// neither successes nor failures and are left out.
+func (t *Tracker) Record(ctx context.Context, subsystem string, err error) {
+	if t == nil || errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.Operations.WithLabelValues(subsystem, result).Inc()
+
+	slot := time.Now().UnixNano() / int64(bucketWidth)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.series[subsystem]
+	if s == nil {
+		return
+	}
+	b := &s.buckets[slot%int64(buckets)]
+	if b.slot != slot {
+		*b = bucket{slot: slot}
+	}
+	b.ops++
+	if err != nil {
+		b.failures++
+	}
+}
+
+// Window is a subsystem's operations over a window.
+type Window struct {
+	Duration time.Duration
+	Ops      int64
+	Failures int64
+}
+
+// ErrorRatio is the share of operations that failed.
+func (w Window) ErrorRatio() float64 {
+	if w.Ops == 0 {
+		return 0
+	}
+	return float64(w.Failures) / float64(w.Ops)
+}
+
+// Subsystem is the health of one subsystem.
+type Subsystem struct {
+	Name      string
+	Status    string
+	Objective float64
+	Short     Window
+	Long      Window
+}
+
+// Budget is the share of operations allowed to fail.
+func (s Subsystem) Budget() float64 {
+	return 1 - s.Objective
+}
+
+// BurnRate is how fast failures over w spend the error budget, 1 being
+// exactly as fast as the budget allows. Windows with too few operations
+// to judge burn at 0.
+func (s Subsystem) BurnRate(w Window) float64 {
+	if w.Ops < minOps || s.Budget() <= 0 {
+		return 0
+	}
+	return w.ErrorRatio() / s.Budget()
+}
+
+// BudgetLeft is the share of the long window's error budget not spent
+// yet, from 1 down to 0.
+func (s Subsystem) BudgetLeft() float64 {
+	return max(0, 1-s.BurnRate(s.Long))
+}
+
+func (s Subsystem) status() string {
+	short, long := s.BurnRate(s.Short), s.BurnRate(s.Long)
+	switch {
+	case long >= 1 && short >= 1:
+		return Red
+	case long >= 1 || short >= 1:
+		return Yellow
+	default:
+		return Green
+	}
+}
+
+// Health is the health of every subsystem.
+type Health struct {
+	Status     string
+	Subsystems []Subsystem
+}
+
+// Health judges every subsystem, logging those whose status changed.
+func (t *Tracker) Health() Health {
+	if t == nil {
+		return Health{Status: Green}
+	}
+
+	slot := time.Now().UnixNano() / int64(bucketWidth)
+	shortSlots := int64(ShortWindow / bucketWidth)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := Health{Status: Green}
+	for _, name := range subsystems {
+		sub := Subsystem{
+			Name:      name,
+			Objective: t.Objectives[name],
+			Short:     Window{Duration: ShortWindow},
+			Long:      Window{Duration: LongWindow},
+		}
+		for _, b := range t.series[name].buckets {
+			age := slot - b.slot
+			if age < 0 || age >= int64(buckets) {
+				continue
+			}
+			sub.Long.Ops += b.ops
+			sub.Long.Failures += b.failures
+			if age < shortSlots {
+				sub.Short.Ops += b.ops
+				sub.Short.Failures += b.failures
+			}
+		}
+		sub.Status = sub.status()
+		t.logChange(sub)
+
+		h.Subsystems = append(h.Subsystems, sub)
+		if rank(sub.Status) > rank(h.Status) {
+			h.Status = sub.Status
+		}
+	}
+	return h
+}
+
+// logChange logs when a subsystem's status differs from the last one
+// reported. Callers must hold t.mu.
+func (t *Tracker) logChange(sub Subsystem) {
+	prev := t.status[sub.Name]
+	if prev == sub.Status {
+		return
+	}
+	t.status[sub.Name] = sub.Status
+
+	fields := []zap.Field{
+		zap.String("subsystem", sub.Name),
+		zap.String("status", sub.Status),
+		zap.Float64("error_ratio", sub.Short.ErrorRatio()),
+		zap.Float64("objective", sub.Objective),
+	}
+	if rank(sub.Status) > rank(prev) {
+		t.Logger.Warn("Subsystem failing more than its error budget allows", fields...)
+	} else {
+		t.Logger.Info("Subsystem failure rate recovering", fields...)
+	}
+}
+
+func rank(status string) int {
+	switch status {
+	case Red:
+		return 2
+	case Yellow:
+		return 1
+	default:
+		return 0
+	}
+}
+
+var (
+	errorRatioDesc = prometheus.NewDesc("dfs_health_error_ratio",
+		"Share of operations that failed over the window, by subsystem.", []string{"subsystem", "window"}, nil)
+	burnRateDesc = prometheus.NewDesc("dfs_health_burn_rate",
+		"How fast failures spend the error budget over the window, 1 spending exactly all of it.", []string{"subsystem", "window"}, nil)
+	budgetLeftDesc = prometheus.NewDesc("dfs_health_budget_left_ratio",
+		"Share of the hour's error budget not spent yet, by subsystem.", []string{"subsystem"}, nil)
+	statusDesc = prometheus.NewDesc("dfs_health_status",
+		"Health of the subsystem: 0 green, 1 yellow, 2 red.", []string{"subsystem"}, nil)
+)
+
+// Collector exports the health of every subsystem as Prometheus metrics.
+func (t *Tracker) Collector() prometheus.Collector {
+	return collector{t}
+}
+
+type collector struct {
+	t *Tracker
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- errorRatioDesc
+	ch <- burnRateDesc
+	ch <- budgetLeftDesc
+	ch <- statusDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	for _, sub := range c.t.Health().Subsystems {
+		for _, w := range []struct {
+			name   string
+			window Window
+		}{{"5m", sub.Short}, {"1h", sub.Long}} {
+			ch <- prometheus.MustNewConstMetric(errorRatioDesc, prometheus.GaugeValue, w.window.ErrorRatio(), sub.Name, w.name)
+			ch <- prometheus.MustNewConstMetric(burnRateDesc, prometheus.GaugeValue, sub.BurnRate(w.window), sub.Name, w.name)
+		}
+		ch <- prometheus.MustNewConstMetric(budgetLeftDesc, prometheus.GaugeValue, sub.BudgetLeft(), sub.Name)
+		ch <- prometheus.MustNewConstMetric(statusDesc, prometheus.GaugeValue, float64(rank(sub.Status)), sub.Name)
+	}
+}
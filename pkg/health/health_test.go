@@ -0,0 +1,122 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var errFailed = errors.New("failed")
+
+// A subsystem turns yellow when one window burns its budget and red when
+// both do; windows with too few operations aren't judged.
+func TestSubsystemStatus(t *testing.T) {
+	win := func(ops, failures int64) Window { return Window{Ops: ops, Failures: failures} }
+	tests := []struct {
+		name        string
+		short, long Window
+		want        string
+	}{
+		{"idle", win(0, 0), win(0, 0), Green},
+		{"too few to judge", win(5, 5), win(5, 5), Green},
+		{"within budget", win(100, 1), win(1000, 10), Green},
+		{"short burst", win(20, 10), win(1000, 10), Yellow},
+		{"long burn", win(100, 0), win(1000, 200), Yellow},
+		{"burning", win(100, 50), win(1000, 200), Red},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := Subsystem{Objective: 0.99, Short: tt.short, Long: tt.long}
+			if got := sub.status(); got != tt.want {
+				t.Errorf("status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Failures recorded against a subsystem turn it and the node red and log
+// the change once; cancelled operations aren't counted.
+func TestTracker(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	tr := NewTracker(TrackerOpts{Logger: zap.New(core)})
+	ctx := context.Background()
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	for i := 0; i < 20; i++ {
+		tr.Record(canceled, Fetch, errFailed)
+		tr.Record(ctx, Dial, nil)
+	}
+	if h := tr.Health(); h.Status != Green {
+		t.Fatalf("status with cancelled failures = %s, want %s", h.Status, Green)
+	}
+
+	for i := 0; i < 20; i++ {
+		tr.Record(ctx, Fetch, errFailed)
+	}
+	h := tr.Health()
+	if h.Status != Red {
+		t.Errorf("node status = %s, want %s", h.Status, Red)
+	}
+	for _, sub := range h.Subsystems {
+		want := Green
+		if sub.Name == Fetch {
+			want = Red
+		}
+		if sub.Status != want {
+			t.Errorf("%s status = %s, want %s", sub.Name, sub.Status, want)
+		}
+		if sub.Name == Fetch && (sub.Short.Failures != 20 || sub.BudgetLeft() != 0) {
+			t.Errorf("fetch = %+v, want 20 failures and no budget left", sub)
+		}
+	}
+
+	tr.Health()
+	if n := logs.FilterMessage("Subsystem failing more than its error budget allows").Len(); n != 1 {
+		t.Errorf("logged the change %d times, want 1", n)
+	}
+}
+
+// Objectives left out of the options fall back to the defaults.
+func TestObjectives(t *testing.T) {
+	tr := NewTracker(TrackerOpts{Objectives: map[string]float64{Dial: 0.5}})
+	if got := tr.Objectives[Dial]; got != 0.5 {
+		t.Errorf("dial objective = %v, want 0.5", got)
+	}
+	if got := tr.Objectives[Fetch]; got != DefaultObjectives[Fetch] {
+		t.Errorf("fetch objective = %v, want %v", got, DefaultObjectives[Fetch])
+	}
+}
+
+// A nil Tracker records nothing and reports green.
+func TestNilTracker(t *testing.T) {
+	var tr *Tracker
+	tr.Record(context.Background(), Fetch, errFailed)
+	tr.Start(context.Background())
+	if h := tr.Health(); h.Status != Green {
+		t.Errorf("status = %s, want %s", h.Status, Green)
+	}
+}
+
+// Buckets older than the long window are dropped from both windows.
+func TestWindowsAge(t *testing.T) {
+	tr := NewTracker(TrackerOpts{})
+	slot := time.Now().UnixNano() / int64(bucketWidth)
+	s := tr.series[Fetch]
+	s.buckets[0] = bucket{slot: slot - int64(buckets), ops: 50, failures: 50}
+	s.buckets[1] = bucket{slot: slot - int64(ShortWindow/bucketWidth), ops: 10, failures: 1}
+
+	for _, sub := range tr.Health().Subsystems {
+		if sub.Name != Fetch {
+			continue
+		}
+		if sub.Short.Ops != 0 || sub.Long.Ops != 10 || sub.Long.Failures != 1 {
+			t.Errorf("windows = %+v, %+v, want only the recent bucket in the long window", sub.Short, sub.Long)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Package importer keeps what "dfs import" needs to load a directory on
+// another machine without copying it locally first: the commands run
+// there, the tree the imported files go in, and a journal so an
+// interrupted import picks up where it stopped.
+//
+// An import lists the remote files, then streams the ones not imported
+// yet through tar. Every so often the files imported so far are made into
+// a directory that is pinned in place of the last one, so what an import
+// holds survives a restart of the daemon and a collection; the journal
+// records that directory and the files in it.
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Remote is a directory on another machine, [user@]host:path, with an
+// IPv6 address in brackets: [user@][fe80::1]:path.
+type Remote struct {
+	// Host is the user@host or host ssh connects to, an IPv6 address
+	// without brackets.
+	Host string
+	// Path is the directory, relative to the user's home directory when
+	// it isn't absolute.
+	Path string
+}
+
+// ParseRemote parses [user@]host:path. An empty path is the user's home
+// directory.
+func ParseRemote(s string) (Remote, error) {
+	bad := func(why string) (Remote, error) {
+		return Remote{}, fmt.Errorf("remote %q: %s", s, why)
+	}
+
+	user, rest := "", s
+	if i := strings.LastIndex(s, "@"); i >= 0 && !strings.Contains(s[:i], ":") {
+		user, rest = s[:i+1], s[i+1:]
+	}
+	var host, dir string
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]")
+		if end < 0 || !strings.HasPrefix(rest[end+1:], ":") {
+			return bad("want [user@][address]:path")
+		}
+		host, dir = rest[1:end], rest[end+2:]
+		if net.ParseIP(host) == nil || !strings.Contains(host, ":") {
+			return bad(host + " is not an IPv6 address")
+		}
+	} else {
+		var ok bool
+		if host, dir, ok = strings.Cut(rest, ":"); !ok {
+			return bad("want [user@]host:path")
+		}
+		if strings.HasPrefix(dir, ":") {
+			return bad("write an IPv6 address in brackets, [user@][address]:path")
+		}
+	}
+	if host == "" || strings.HasPrefix(user+host, "-") {
+		return bad("want [user@]host:path")
+	}
+	if dir == "" {
+		dir = "."
+	}
+	return Remote{Host: user + host, Path: dir}, nil
+}
+
+// String writes r as ParseRemote reads it.
+func (r Remote) String() string {
+	user, host := "", r.Host
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		user, host = host[:i+1], host[i+1:]
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return user + host + ":" + r.Path
+}
+
+// ListCommand is the shell command printing the regular files below the
+// directory, one "size mtime ./path" line each. GNU and busybox stat
+// take -c and BSD stat -f, which to GNU stat asks for file system status
+// instead, so the flavour is probed once up front rather than falling
+// back file by file.
+func (r Remote) ListCommand() string {
+	return "cd -- " + Quote(r.Path) + " && if stat -c %s . >/dev/null 2>&1; " +
+		"then find . -type f -exec stat -c '%s %Y %n' {} +; " +
+		"else find . -type f -exec stat -f '%z %m %N' {} +; fi"
+}
+
+// TarCommand is the shell command writing a tar stream of the files
+// named on its standard input, one ./path a line.
+func (r Remote) TarCommand() string {
+	return "cd -- " + Quote(r.Path) + " && tar -cf - -T -"
+}
+
+// Quote quotes s for a POSIX shell.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// File is a regular file below the remote directory.
+type File struct {
+	// Path is slash-separated, relative to the directory.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// ModTime is in seconds since the Unix epoch.
+	ModTime int64 `json:"mtime"`
+}
+
+// ParseListing parses the output of ListCommand, sorted by path.
+func ParseListing(r io.Reader) ([]File, error) {
+	var files []File
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		size, rest, _ := strings.Cut(line, " ")
+		mtime, name, _ := strings.Cut(rest, " ")
+		f := File{Path: Clean(name)}
+		var err error
+		if f.Size, err = strconv.ParseInt(size, 10, 64); err != nil || f.Size < 0 {
+			return nil, fmt.Errorf("listing line %d: bad size in %q", n, line)
+		}
+		if f.ModTime, err = strconv.ParseInt(mtime, 10, 64); err != nil {
+			return nil, fmt.Errorf("listing line %d: bad modification time in %q", n, line)
+		}
+		if f.Path == "" {
+			return nil, fmt.Errorf("listing line %d: bad path in %q", n, line)
+		}
+		files = append(files, f)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// Clean returns the slash-separated path of a name in a listing or a tar
+// stream relative to the directory, or "" if it isn't below it.
+func Clean(name string) string {
+	p := path.Clean("/" + name)[1:]
+	if p == "" || strings.HasPrefix(name, "/") || strings.Contains("/"+name+"/", "/../") {
+		return ""
+	}
+	return p
+}
+
+// Entry is an entry of a directory made from the tree.
+type Entry struct {
+	Name string
+	CID  string
+	Dir  bool
+}
+
+// Tree is the directory tree of the files imported so far. It remembers
+// the hashes of the directories made from it, so only those that changed
+// since are made again.
+type Tree struct {
+	root *dirNode
+}
+
+type dirNode struct {
+	dirs  map[string]*dirNode
+	files map[string]string
+	// cid is the hash the directory was last made with, empty when it
+	// changed since.
+	cid string
+}
+
+func newDirNode() *dirNode {
+	return &dirNode{dirs: make(map[string]*dirNode), files: make(map[string]string)}
+}
+
+// NewTree returns an empty tree.
+func NewTree() *Tree {
+	return &Tree{root: newDirNode()}
+}
+
+// Set puts the file with hash cid at the slash-separated path rel,
+// replacing a file or directory there and files in the way.
+func (t *Tree) Set(rel, cid string) {
+	parts := strings.Split(rel, "/")
+	d := t.root
+	for _, name := range parts[:len(parts)-1] {
+		d.cid = ""
+		next, ok := d.dirs[name]
+		if !ok {
+			delete(d.files, name)
+			next = newDirNode()
+			d.dirs[name] = next
+		}
+		d = next
+	}
+	name := parts[len(parts)-1]
+	d.cid = ""
+	delete(d.dirs, name)
+	d.files[name] = cid
+}
+
+// Remove removes the file at rel and the directories it leaves empty.
+func (t *Tree) Remove(rel string) {
+	t.remove(t.root, strings.Split(rel, "/"))
+}
+
+func (t *Tree) remove(d *dirNode, parts []string) bool {
+	name := parts[0]
+	if len(parts) == 1 {
+		if _, ok := d.files[name]; !ok {
+			return false
+		}
+		delete(d.files, name)
+		d.cid = ""
+		return true
+	}
+	sub, ok := d.dirs[name]
+	if !ok || !t.remove(sub, parts[1:]) {
+		return false
+	}
+	if len(sub.dirs) == 0 && len(sub.files) == 0 {
+		delete(d.dirs, name)
+	}
+	d.cid = ""
+	return true
+}
+
+// Build makes the directories that changed since the last build, bottom
+// up, with mkdir, which is given the slash-separated path of each ("" for
+// the top one) and its entries by name. It returns the hash of the top
+// directory.
+func (t *Tree) Build(mkdir func(rel string, entries []Entry) (string, error)) (string, error) {
+	return t.build(t.root, "", mkdir)
+}
+
+func (t *Tree) build(d *dirNode, rel string, mkdir func(string, []Entry) (string, error)) (string, error) {
+	if d.cid != "" {
+		return d.cid, nil
+	}
+	entries := make([]Entry, 0, len(d.dirs)+len(d.files))
+	for name, sub := range d.dirs {
+		c, err := t.build(sub, path.Join(rel, name), mkdir)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, Entry{Name: name, CID: c, Dir: true})
+	}
+	for name, c := range d.files {
+		entries = append(entries, Entry{Name: name, CID: c})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	c, err := mkdir(rel, entries)
+	if err != nil {
+		return "", err
+	}
+	d.cid = c
+	return c, nil
+}
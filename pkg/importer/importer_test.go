@@ -0,0 +1,176 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRemote(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Remote
+	}{
+		{"backup@nas:/volume1/photos", Remote{Host: "backup@nas", Path: "/volume1/photos"}},
+		{"nas:share", Remote{Host: "nas", Path: "share"}},
+		{"nas:", Remote{Host: "nas", Path: "."}},
+		{"nas:/a:b", Remote{Host: "nas", Path: "/a:b"}},
+		{"[::1]:/path", Remote{Host: "::1", Path: "/path"}},
+		{"user@[fe80::1]:/p", Remote{Host: "user@fe80::1", Path: "/p"}},
+		{"user@[2001:db8::2]:", Remote{Host: "user@2001:db8::2", Path: "."}},
+		{"us@er@nas:/p", Remote{Host: "us@er@nas", Path: "/p"}},
+	} {
+		got, err := ParseRemote(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseRemote(%s) = %+v, %v, want %+v", tc.in, got, err, tc.want)
+			continue
+		}
+		if got.String() != tc.in && !strings.HasSuffix(tc.in, ":") {
+			t.Errorf("ParseRemote(%s).String() = %s", tc.in, got)
+		}
+	}
+	for _, in := range []string{
+		"nas",
+		":/x",
+		"-oProxyCommand=x:/y",
+		"user@fe80::1:/p",
+		"::1:/p",
+		"[::1]/p",
+		"[::1",
+		"[nas]:/p",
+		"[]:/p",
+	} {
+		if _, err := ParseRemote(in); err == nil {
+			t.Errorf("ParseRemote(%s) succeeded", in)
+		}
+	}
+}
+
+// The listing runs as the remote shell would run it, with the stat found
+// here.
+func TestListCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub dir/it's.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("12345"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := exec.Command("sh", "-c", Remote{Host: "nas", Path: dir}.ListCommand()).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := ParseListing(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Path != "a.txt" || files[1].Path != "sub dir/it's.txt" || files[1].Size != 5 || files[1].ModTime == 0 {
+		t.Errorf("listed %+v", files)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"plain", "it's", "$(rm -rf /)", `a"b\c`} {
+		out, err := exec.Command("sh", "-c", "printf %s "+Quote(s)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != s {
+			t.Errorf("sh got %q for %q", out, s)
+		}
+	}
+}
+
+func TestParseListing(t *testing.T) {
+	listing := "12 1700000000 ./b/c.txt\n0 1700000001 ./a b.txt\n"
+	files, err := ParseListing(strings.NewReader(listing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []File{
+		{Path: "a b.txt", Size: 0, ModTime: 1700000001},
+		{Path: "b/c.txt", Size: 12, ModTime: 1700000000},
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("ParseListing = %v, want %v", files, want)
+	}
+
+	for _, bad := range []string{"x 1 ./a\n", "1 x ./a\n", "1 1 ../a\n", "1 1 /etc/passwd\n"} {
+		if _, err := ParseListing(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseListing(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestTree(t *testing.T) {
+	tree := NewTree()
+	made := make(map[string]int)
+	mkdir := func(rel string, entries []Entry) (string, error) {
+		made[rel]++
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name+"="+e.CID)
+		}
+		return "(" + strings.Join(names, ",") + ")", nil
+	}
+
+	tree.Set("a/x", "1")
+	tree.Set("a/y", "2")
+	tree.Set("b/z", "3")
+	root, err := tree.Build(mkdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != "(a=(x=1,y=2),b=(z=3))" {
+		t.Errorf("root = %s", root)
+	}
+
+	tree.Set("b/z", "4")
+	if root, _ = tree.Build(mkdir); root != "(a=(x=1,y=2),b=(z=4))" {
+		t.Errorf("root after change = %s", root)
+	}
+	if made["a"] != 1 || made["b"] != 2 {
+		t.Errorf("directories made %v, want a once and b twice", made)
+	}
+
+	tree.Remove("b/z")
+	tree.Set("a", "5")
+	if root, _ = tree.Build(mkdir); root != "(a=5)" {
+		t.Errorf("root after removal = %s", root)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "imports.json")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := Job{
+		Source: "nas:/photos",
+		Root:   "bafyroot",
+		Files:  map[string]ImportedFile{"a.jpg": {Size: 3, ModTime: 7, CID: "bafya"}},
+	}
+	if err := j.Put(job); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := reopened.Get("nas:/photos")
+	if !ok {
+		t.Fatal("job lost on reopening")
+	}
+	f := got.Files["a.jpg"]
+	if got.Root != "bafyroot" || !f.Current(File{Path: "a.jpg", Size: 3, ModTime: 7}) || f.Current(File{Size: 3, ModTime: 8}) {
+		t.Errorf("reopened job = %+v", got)
+	}
+}
@@ -0,0 +1,125 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+)
+
+// Job is the state of the import of a remote directory.
+type Job struct {
+	// Source is the remote directory, as Remote.String.
+	Source string `json:"source"`
+	// Root is the pinned directory holding Files, empty until the first
+	// checkpoint.
+	Root string `json:"root,omitempty"`
+	// Files are the files imported, by path.
+	Files map[string]ImportedFile `json:"files,omitempty"`
+	// Done reports whether the last run imported every file it listed.
+	Done    bool      `json:"done,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// ImportedFile is a file imported and the version of it that was.
+type ImportedFile struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	CID     string `json:"cid"`
+}
+
+// Current reports whether f is the version of the file imported.
+func (f ImportedFile) Current(r File) bool {
+	return f.Size == r.Size && f.ModTime == r.ModTime
+}
+
+// Journal keeps the jobs of a node in a JSON file like the sync pairs.
+type Journal struct {
+	path string
+
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// Open loads the jobs at path. A missing file is an empty journal; an
+// empty path keeps them in memory only.
+func Open(path string) (*Journal, error) {
+	j := &Journal{path: path, jobs: make(map[string]Job)}
+	if path == "" {
+		return j, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Job
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, job := range list {
+		j.jobs[job.Source] = job
+	}
+	return j, nil
+}
+
+// Get returns the job importing source.
+func (j *Journal) Get(source string) (Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[source]
+	return job, ok
+}
+
+// Put keeps job, replacing the one of the same source.
+func (j *Journal) Put(job Job) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	prev, had := j.jobs[job.Source]
+	j.jobs[job.Source] = job
+	if err := j.save(); err != nil {
+		if had {
+			j.jobs[job.Source] = prev
+		} else {
+			delete(j.jobs, job.Source)
+		}
+		return err
+	}
+	return nil
+}
+
+func (j *Journal) sorted() []Job {
+	list := make([]Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Source < list[b].Source })
+	return list
+}
+
+// save writes the journal atomically. Callers hold j.mu.
+func (j *Journal) save() error {
+	if j.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(j.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(j.path, data, 0600)
+}
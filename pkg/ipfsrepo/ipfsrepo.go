@@ -0,0 +1,223 @@
+// Package ipfsrepo reads a Kubo (go-ipfs) repository in place, for "dfs
+// import ipfs": its blocks, its pins and the root of its MFS, the files
+// "ipfs files" keeps. Only the default layout is read, a flatfs
+// blockstore next to a leveldb datastore, and only while the IPFS daemon
+// is stopped, as it keeps the datastore locked.
+package ipfsrepo
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/polydawn/refmt/cbor"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Datastore keys Kubo keeps its pins and MFS root under.
+const (
+	pinPrefix    = "/pins/pin/"
+	filesRootKey = "/local/filesroot"
+)
+
+// Pin modes as Kubo records them.
+const (
+	modeRecursive = 0
+	modeDirect    = 1
+)
+
+// ErrNotFound is returned for a block the repo doesn't hold.
+var ErrNotFound = errors.New("ipfsrepo: block not in the repo")
+
+// Repo is an opened Kubo repository.
+type Repo struct {
+	blocks string
+	shard  func(key string) string
+	db     *leveldb.DB
+}
+
+// Open opens the repo at path read-only.
+func Open(path string) (*Repo, error) {
+	var spec struct {
+		Type   string `json:"type"`
+		Mounts []struct {
+			Mountpoint string `json:"mountpoint"`
+			Path       string `json:"path"`
+			Type       string `json:"type"`
+		} `json:"mounts"`
+	}
+	data, err := os.ReadFile(filepath.Join(path, "datastore_spec"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s is not an IPFS repo: no datastore_spec", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("%s: datastore_spec: %w", path, err)
+	}
+
+	var blocks, datastore string
+	for _, m := range spec.Mounts {
+		switch {
+		case m.Mountpoint == "/blocks" && m.Type == "flatfs":
+			blocks = filepath.Join(path, m.Path)
+		case m.Mountpoint == "/" && m.Type == "levelds":
+			datastore = filepath.Join(path, m.Path)
+		}
+	}
+	if spec.Type != "mount" || blocks == "" || datastore == "" {
+		return nil, fmt.Errorf("%s: only repos with a flatfs blockstore and a leveldb datastore can be read, not %s", path, data)
+	}
+
+	sharding, err := os.ReadFile(filepath.Join(blocks, "SHARDING"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	shard, err := parseShardFunc(strings.TrimSpace(string(sharding)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	db, err := leveldb.OpenFile(datastore, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w (stop the IPFS daemon first)", datastore, err)
+	}
+	return &Repo{blocks: blocks, shard: shard, db: db}, nil
+}
+
+// parseShardFunc parses the flatfs sharding function of a SHARDING file,
+// /repo/flatfs/shard/v1/<name>/<length>, which names the directory a
+// block's file is in after its key.
+func parseShardFunc(s string) (func(string) string, error) {
+	rest, ok := strings.CutPrefix(s, "/repo/flatfs/shard/v1/")
+	name, param, ok2 := strings.Cut(rest, "/")
+	n, err := strconv.Atoi(param)
+	if !ok || !ok2 || err != nil || n <= 0 {
+		return nil, fmt.Errorf("unknown flatfs sharding %q", s)
+	}
+	switch name {
+	case "prefix":
+		return func(key string) string { return (key + strings.Repeat("_", n))[:n] }, nil
+	case "suffix":
+		return func(key string) string {
+			padded := strings.Repeat("_", n) + key
+			return padded[len(padded)-n:]
+		}, nil
+	case "next-to-last":
+		return func(key string) string {
+			padded := strings.Repeat("_", n+1) + key
+			offset := len(padded) - n - 1
+			return padded[offset : offset+n]
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown flatfs sharding %q", s)
+}
+
+func (r *Repo) Close() error {
+	return r.db.Close()
+}
+
+// Block returns the data of the block c, checked against its hash.
+func (r *Repo) Block(c cid.Cid) ([]byte, error) {
+	// Blocks are keyed by multihash alone, whatever the CID version
+	key := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(c.Hash())
+	data, err := os.ReadFile(filepath.Join(r.blocks, r.shard(key), key+".data"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c, err)
+	}
+	if !bytes.Equal(sum.Hash(), c.Hash()) {
+		return nil, fmt.Errorf("ipfsrepo: block %s is corrupt", c)
+	}
+	return data, nil
+}
+
+// Pin is a pin of the repo.
+type Pin struct {
+	CID cid.Cid
+	// Recursive pins hold everything below CID, direct ones only the
+	// block itself.
+	Recursive bool
+	Name      string
+}
+
+// Pins lists the recursive and direct pins.
+func (r *Repo) Pins() ([]Pin, error) {
+	it := r.db.NewIterator(util.BytesPrefix([]byte(pinPrefix)), nil)
+	defer it.Release()
+
+	var pins []Pin
+	for it.Next() {
+		p, err := decodePin(it.Value())
+		if err != nil {
+			return nil, fmt.Errorf("pin %s: %w", strings.TrimPrefix(string(it.Key()), pinPrefix), err)
+		}
+		pins = append(pins, p)
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+// decodePin decodes a pin as Kubo's pinner stores it, a CBOR map of the
+// binary CID, the mode and an optional name.
+func decodePin(data []byte) (Pin, error) {
+	var m map[string]interface{}
+	if err := cbor.Unmarshal(cbor.DecodeOptions{}, data, &m); err != nil {
+		return Pin{}, err
+	}
+	raw, _ := m["cid"].([]byte)
+	c, err := cid.Cast(raw)
+	if err != nil {
+		return Pin{}, fmt.Errorf("bad CID: %w", err)
+	}
+	var mode int64 = -1
+	switch v := m["mode"].(type) {
+	case int:
+		mode = int64(v)
+	case int64:
+		mode = v
+	case uint64:
+		mode = int64(v)
+	}
+	if mode != modeRecursive && mode != modeDirect {
+		return Pin{}, fmt.Errorf("unknown pin mode %v", m["mode"])
+	}
+	name, _ := m["name"].(string)
+	return Pin{CID: c, Recursive: mode == modeRecursive, Name: name}, nil
+}
+
+// FilesRoot returns the root directory of the MFS, or cid.Undef if the
+// repo never had one.
+func (r *Repo) FilesRoot() (cid.Cid, error) {
+	data, err := r.db.Get([]byte(filesRootKey), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return cid.Undef, nil
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+	c, err := cid.Cast(data)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("MFS root: %w", err)
+	}
+	return c, nil
+}
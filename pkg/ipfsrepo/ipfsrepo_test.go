@@ -0,0 +1,252 @@
+package ipfsrepo
+
+import (
+	"encoding/base32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	pb "github.com/ipfs/boxo/ipld/merkledag/pb"
+	unixfspb "github.com/ipfs/boxo/ipld/unixfs/pb"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/polydawn/refmt/cbor"
+	"github.com/syndtr/goleveldb/leveldb"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeRepo writes a repo laid out as Kubo's default one.
+type fakeRepo struct {
+	t    *testing.T
+	path string
+	db   *leveldb.DB
+}
+
+func newFakeRepo(t *testing.T) *fakeRepo {
+	t.Helper()
+	path := t.TempDir()
+	spec := `{"mounts":[{"mountpoint":"/blocks","path":"blocks","shardFunc":"/repo/flatfs/shard/v1/next-to-last/2","type":"flatfs"},` +
+		`{"mountpoint":"/","path":"datastore","type":"levelds"}],"type":"mount"}`
+	if err := os.WriteFile(filepath.Join(path, "datastore_spec"), []byte(spec), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(path, "blocks"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "blocks", "SHARDING"), []byte("/repo/flatfs/shard/v1/next-to-last/2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := leveldb.OpenFile(filepath.Join(path, "datastore"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeRepo{t: t, path: path, db: db}
+}
+
+// close leaves the datastore for Open, as a stopped daemon does.
+func (f *fakeRepo) close() {
+	if err := f.db.Close(); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fakeRepo) put(codec uint64, data []byte) cid.Cid {
+	f.t.Helper()
+	mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	c := cid.NewCidV1(codec, mh)
+	if codec == cid.DagProtobuf {
+		c = cid.NewCidV0(mh)
+	}
+	key := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(mh)
+	dir := filepath.Join(f.path, "blocks", key[len(key)-3:len(key)-1])
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, key+".data"), data, 0600); err != nil {
+		f.t.Fatal(err)
+	}
+	return c
+}
+
+func (f *fakeRepo) node(data *unixfspb.Data, links ...*pb.PBLink) cid.Cid {
+	f.t.Helper()
+	d, err := proto.Marshal(data)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	block, err := proto.Marshal(&pb.PBNode{Data: d, Links: links})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return f.put(cid.DagProtobuf, block)
+}
+
+func link(name string, c cid.Cid) *pb.PBLink {
+	return &pb.PBLink{Name: proto.String(name), Hash: c.Bytes()}
+}
+
+func (f *fakeRepo) pin(id string, c cid.Cid, mode int, name string) {
+	f.t.Helper()
+	m := map[string]interface{}{"cid": c.Bytes(), "mode": mode}
+	if name != "" {
+		m["name"] = name
+	}
+	data, err := cbor.Marshal(m)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.db.Put([]byte(pinPrefix+id), data, nil); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, r *Repo, c cid.Cid) string {
+	t.Helper()
+	n, err := r.Stat(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := r.Reader(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRepo(t *testing.T) {
+	f := newFakeRepo(t)
+	small := f.put(cid.Raw, []byte("small file"))
+	chunked := f.node(
+		&unixfspb.Data{Type: unixfspb.Data_File.Enum(), Data: []byte("head "), Filesize: proto.Uint64(19)},
+		link("", f.put(cid.Raw, []byte("first "))),
+		link("", f.node(&unixfspb.Data{Type: unixfspb.Data_File.Enum(), Data: []byte("second")})),
+	)
+	symlink := f.node(&unixfspb.Data{Type: unixfspb.Data_Symlink.Enum(), Data: []byte("small.txt")})
+	dir := f.node(&unixfspb.Data{Type: unixfspb.Data_Directory.Enum()},
+		link("chunked.txt", chunked),
+		link("link", symlink),
+		link("small.txt", small),
+	)
+	fanout := &unixfspb.Data{Type: unixfspb.Data_HAMTShard.Enum(), Fanout: proto.Uint64(256), HashType: proto.Uint64(0x22)}
+	shard := f.node(fanout,
+		link("0Aa.txt", small),
+		link("F1", f.node(fanout, link("00b.txt", chunked), link("FFsub", dir))),
+	)
+	f.pin("one", dir, modeRecursive, "docs")
+	f.pin("two", small, modeDirect, "")
+	f.pin("three", shard, modeRecursive, "")
+	if err := f.db.Put([]byte(filesRootKey), dir.Bytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+	f.close()
+
+	r, err := Open(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	pins, err := r.Pins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pin{{CID: dir, Recursive: true, Name: "docs"}, {CID: shard, Recursive: true}, {CID: small}}
+	if !slices.Equal(pins, want) {
+		t.Errorf("pins = %v, want %v", pins, want)
+	}
+	if root, err := r.FilesRoot(); err != nil || root != dir {
+		t.Errorf("FilesRoot = %s, %v, want %s", root, err, dir)
+	}
+
+	if got := readAll(t, r, chunked); got != "head first second" {
+		t.Errorf("chunked file = %q", got)
+	}
+	if n, _ := r.Stat(chunked); n.Size != 19 {
+		t.Errorf("chunked file size = %d", n.Size)
+	}
+	if got := readAll(t, r, small); got != "small file" {
+		t.Errorf("raw file = %q", got)
+	}
+	if n, err := r.Stat(symlink); err != nil || n.Kind != Symlink || n.Target() != "small.txt" {
+		t.Errorf("symlink = %+v, %v", n, err)
+	}
+
+	for _, tc := range []struct {
+		dir  cid.Cid
+		want string
+	}{
+		{dir, "chunked.txt link small.txt"},
+		{shard, "a.txt b.txt sub"},
+	} {
+		n, err := r.Stat(tc.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := r.Entries(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		if got := strings.Join(names, " "); got != tc.want {
+			t.Errorf("entries of %s = %s, want %s", tc.dir, got, tc.want)
+		}
+	}
+}
+
+func TestBlockChecksHash(t *testing.T) {
+	f := newFakeRepo(t)
+	c := f.put(cid.Raw, []byte("original"))
+	f.close()
+	key := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(c.Hash())
+	if err := os.WriteFile(filepath.Join(f.path, "blocks", key[len(key)-3:len(key)-1], key+".data"), []byte("tampered"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Block(c); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("Block of a tampered file = %v", err)
+	}
+	missing := cid.NewCidV1(cid.Raw, c.Hash()[:len(c.Hash())-1])
+	if _, err := r.Stat(missing); err == nil {
+		t.Error("Stat of a missing block succeeded")
+	}
+}
+
+func TestShardFunc(t *testing.T) {
+	for _, tc := range []struct {
+		spec, key, want string
+	}{
+		{"/repo/flatfs/shard/v1/next-to-last/2", "CIQABCDE", "CD"},
+		{"/repo/flatfs/shard/v1/next-to-last/2", "A", "__"},
+		{"/repo/flatfs/shard/v1/prefix/3", "CIQABC", "CIQ"},
+		{"/repo/flatfs/shard/v1/suffix/2", "CIQABC", "BC"},
+	} {
+		shard, err := parseShardFunc(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := shard(tc.key); got != tc.want {
+			t.Errorf("%s(%s) = %s, want %s", tc.spec, tc.key, got, tc.want)
+		}
+	}
+	if _, err := parseShardFunc("/repo/flatfs/shard/v2/prefix/2"); err == nil {
+		t.Error("unknown sharding accepted")
+	}
+}
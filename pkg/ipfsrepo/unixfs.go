@@ -0,0 +1,196 @@
+package ipfsrepo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	pb "github.com/ipfs/boxo/ipld/merkledag/pb"
+	unixfspb "github.com/ipfs/boxo/ipld/unixfs/pb"
+	"github.com/ipfs/go-cid"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kind is what a UnixFS node is.
+type Kind int
+
+const (
+	File Kind = iota
+	Directory
+	Symlink
+)
+
+// ErrUnsupported is returned for nodes that are neither UnixFS files,
+// directories nor symlinks, such as DAGs of other codecs.
+var ErrUnsupported = errors.New("ipfsrepo: not a UnixFS file or directory")
+
+// Node is a UnixFS node.
+type Node struct {
+	CID  cid.Cid
+	Kind Kind
+	// Size is the size of a file.
+	Size uint64
+
+	links []*pb.PBLink
+	data  *unixfspb.Data
+	// raw is the data of a raw block, a file of its own.
+	raw []byte
+}
+
+// Stat reads the node c.
+func (r *Repo) Stat(c cid.Cid) (*Node, error) {
+	block, err := r.Block(c)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Type() {
+	case cid.Raw:
+		return &Node{CID: c, Kind: File, Size: uint64(len(block)), raw: block}, nil
+	case cid.DagProtobuf:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, c)
+	}
+
+	var pn pb.PBNode
+	if err := proto.Unmarshal(block, &pn); err != nil {
+		return nil, fmt.Errorf("%s: %w", c, err)
+	}
+	var data unixfspb.Data
+	if err := proto.Unmarshal(pn.GetData(), &data); err != nil {
+		return nil, fmt.Errorf("%s: %w", c, err)
+	}
+	n := &Node{CID: c, links: pn.GetLinks(), data: &data}
+	switch data.GetType() {
+	case unixfspb.Data_File, unixfspb.Data_Raw:
+		n.Kind = File
+		n.Size = data.GetFilesize()
+		if n.Size == 0 {
+			n.Size = uint64(len(data.GetData()))
+		}
+	case unixfspb.Data_Directory, unixfspb.Data_HAMTShard:
+		n.Kind = Directory
+	case unixfspb.Data_Symlink:
+		n.Kind = Symlink
+	default:
+		return nil, fmt.Errorf("%w: %s is of type %s", ErrUnsupported, c, data.GetType())
+	}
+	return n, nil
+}
+
+// Target returns where a symlink points.
+func (n *Node) Target() string {
+	return string(n.data.GetData())
+}
+
+// Entry is an entry of a directory.
+type Entry struct {
+	Name string
+	CID  cid.Cid
+}
+
+// Entries lists the directory n, the shards of a sharded one merged.
+func (r *Repo) Entries(n *Node) ([]Entry, error) {
+	if n.Kind != Directory {
+		return nil, fmt.Errorf("ipfsrepo: %s is not a directory", n.CID)
+	}
+	var entries []Entry
+	if err := r.entries(n, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (r *Repo) entries(n *Node, entries *[]Entry) error {
+	// Links of a shard are named by a bucket of as many hex digits as the
+	// fanout takes, followed by the entry's name; one named by the bucket
+	// alone is a shard below.
+	width := 0
+	if n.data.GetType() == unixfspb.Data_HAMTShard {
+		width = len(fmt.Sprintf("%X", n.data.GetFanout()-1))
+	}
+	for _, l := range n.links {
+		c, err := cid.Cast(l.GetHash())
+		if err != nil {
+			return fmt.Errorf("%s: bad link: %w", n.CID, err)
+		}
+		name := l.GetName()
+		if width == 0 {
+			*entries = append(*entries, Entry{Name: name, CID: c})
+			continue
+		}
+		if len(name) < width {
+			return fmt.Errorf("%s: bad shard link %q", n.CID, name)
+		}
+		if len(name) > width {
+			*entries = append(*entries, Entry{Name: name[width:], CID: c})
+			continue
+		}
+		sub, err := r.Stat(c)
+		if err != nil {
+			return err
+		}
+		if sub.data == nil || sub.data.GetType() != unixfspb.Data_HAMTShard {
+			return fmt.Errorf("%s: shard link %q is not a shard", n.CID, name)
+		}
+		if err := r.entries(sub, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader returns the content of the file n. Its blocks are read as the
+// content is.
+func (r *Repo) Reader(n *Node) (io.Reader, error) {
+	if n.Kind != File {
+		return nil, fmt.Errorf("ipfsrepo: %s is not a file", n.CID)
+	}
+	return &fileReader{repo: r, next: n}, nil
+}
+
+// fileReader reads a file depth first, each node's own data before that
+// of its links.
+type fileReader struct {
+	repo *Repo
+	// next is the node to read next, before those pending.
+	next    *Node
+	pending []cid.Cid
+	buf     []byte
+}
+
+func (f *fileReader) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		n := f.next
+		f.next = nil
+		if n == nil {
+			if len(f.pending) == 0 {
+				return 0, io.EOF
+			}
+			c := f.pending[len(f.pending)-1]
+			f.pending = f.pending[:len(f.pending)-1]
+			var err error
+			if n, err = f.repo.Stat(c); err != nil {
+				return 0, err
+			}
+			if n.Kind != File {
+				return 0, fmt.Errorf("ipfsrepo: file links to %s, not a file", c)
+			}
+		}
+		if n.data == nil {
+			f.buf = n.raw
+			continue
+		}
+		f.buf = n.data.GetData()
+		// Pushed last to first, so they are popped in order
+		for i := len(n.links) - 1; i >= 0; i-- {
+			c, err := cid.Cast(n.links[i].GetHash())
+			if err != nil {
+				return 0, fmt.Errorf("%s: bad link: %w", n.CID, err)
+			}
+			f.pending = append(f.pending, c)
+		}
+	}
+	k := copy(p, f.buf)
+	f.buf = f.buf[k:]
+	return k, nil
+}
@@ -0,0 +1,93 @@
+package keystore
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// backupVersion is the version of the format Backup writes.
+const backupVersion = 1
+
+type backup struct {
+	Version int         `json:"version"`
+	Keys    []backupKey `json:"keys"`
+}
+
+type backupKey struct {
+	Key
+	Secret []byte `json:"secret"`
+}
+
+// Backup returns the keys of the given kinds, every kind when none are
+// given, retired ones included, with their secrets in the clear, for
+// Restore. The caller protects it, for example by splitting it into
+// shares.
+func (ks *Keystore) Backup(kinds ...Kind) ([]byte, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	b := backup{Version: backupVersion}
+	defer func() {
+		for _, k := range b.Keys {
+			clear(k.Secret)
+		}
+	}()
+	for _, e := range ks.file.Keys {
+		if len(kinds) > 0 && !slices.Contains(kinds, e.Kind) {
+			continue
+		}
+		secret, err := ks.open(aad(e.Key), e.Sealed)
+		if err != nil {
+			return nil, fmt.Errorf("keystore: %s key %s: %w", e.Kind, e.Name, err)
+		}
+		b.Keys = append(b.Keys, backupKey{Key: e.Key, Secret: secret})
+	}
+	return json.Marshal(b)
+}
+
+// Restore adds the keys of a backup made by Backup that the keystore
+// doesn't hold. A key in use in the backup is put in use here, retiring
+// the key of its kind and name in use, if any. It returns the keys added.
+func (ks *Keystore) Restore(data []byte) ([]Key, error) {
+	var b backup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("keystore: backup: %w", err)
+	}
+	defer func() {
+		for _, k := range b.Keys {
+			clear(k.Secret)
+		}
+	}()
+	if b.Version != backupVersion {
+		return nil, fmt.Errorf("keystore: backup version %d, want %d", b.Version, backupVersion)
+	}
+
+	var added []Key
+	err := ks.update(func(f *file) error {
+		added = nil
+		now := time.Now().UTC()
+		for _, k := range b.Keys {
+			if _, err := ParseKind(string(k.Kind)); err != nil {
+				return fmt.Errorf("keystore: backup: %w", err)
+			}
+			if slices.ContainsFunc(f.Keys, func(e entry) bool { return e.Kind == k.Kind && e.ID == k.ID }) {
+				continue
+			}
+			if k.Retired.IsZero() {
+				for i, e := range f.Keys {
+					if e.Kind == k.Kind && e.Name == k.Name && e.Retired.IsZero() {
+						f.Keys[i].Retired = now
+					}
+				}
+			}
+			if err := ks.add(f, k.Key, k.Secret); err != nil {
+				return err
+			}
+			added = append(added, k.Key)
+		}
+		return nil
+	})
+	return added, err
+}
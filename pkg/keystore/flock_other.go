@@ -0,0 +1,9 @@
+//go:build !unix
+
+package keystore
+
+// Without flock changes aren't serialized; changing one keystore from two
+// processes at once is then up to the user to avoid.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
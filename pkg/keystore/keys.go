@@ -0,0 +1,200 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Kinds of keys. Secrets are kept in the form of the key files a node
+// without a keystore reads: libp2p's protobuf encoding for identity and
+// name keys, hex for master and file keys.
+const (
+	// KindIdentity is the node key, which its peer ID derives from.
+	KindIdentity Kind = "identity"
+	// KindMaster keys wrap the keys of encrypted files.
+	KindMaster Kind = "master"
+	// KindName keys sign the records of the names published under them.
+	KindName Kind = "name"
+	// KindFile keys encrypt one file each, named by its hash.
+	KindFile Kind = "file"
+)
+
+var kinds = []Kind{KindIdentity, KindMaster, KindName, KindFile}
+
+// Names of the keys a node has one of.
+const (
+	IdentityName = "self"
+	MasterName   = "default"
+)
+
+// ParseKind checks that s names a kind of key.
+func ParseKind(s string) (Kind, error) {
+	for _, k := range kinds {
+		if string(k) == s {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key kind %q: use identity, master, name or file", s)
+}
+
+// Generate creates a new key of the given kind and name. File keys are
+// only ever created along with the file they encrypt, see PutFileKey.
+func (ks *Keystore) Generate(kind Kind, name string) (Key, error) {
+	k, secret, err := generate(kind, name)
+	if err != nil {
+		return Key{}, err
+	}
+	return k, ks.Put(k, secret)
+}
+
+// Rotate replaces the key of the given kind and name with a new one and
+// returns both. The old key is kept, retired: a master key to unwrap the
+// keys of the files added before, an identity or name key to show what
+// it signed. The new key takes effect when the daemon restarts.
+func (ks *Keystore) Rotate(kind Kind, name string) (old, key Key, err error) {
+	key, secret, err := generate(kind, name)
+	if err != nil {
+		return Key{}, Key{}, err
+	}
+	if _, _, err := ks.Get(kind, name); err != nil {
+		return Key{}, Key{}, err
+	}
+	old, err = ks.Replace(key, secret)
+	return old, key, err
+}
+
+func generate(kind Kind, name string) (Key, []byte, error) {
+	switch kind {
+	case KindIdentity, KindName:
+		priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+		if err != nil {
+			return Key{}, nil, err
+		}
+		return privKey(kind, name, priv)
+	case KindMaster:
+		m, err := crypt.GenerateMasterKey()
+		if err != nil {
+			return Key{}, nil, err
+		}
+		return Key{Kind: kind, Name: name, ID: m.ID()}, m.Encode(), nil
+	case KindFile:
+		return Key{}, nil, errors.New("keystore: file keys are made with the file they encrypt")
+	default:
+		return Key{}, nil, fmt.Errorf("keystore: unknown key kind %q", kind)
+	}
+}
+
+func privKey(kind Kind, name string, priv crypto.PrivKey) (Key, []byte, error) {
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return Key{}, nil, err
+	}
+	data, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return Key{}, nil, err
+	}
+	return Key{Kind: kind, Name: name, ID: id.String()}, data, nil
+}
+
+// Identity returns the node key.
+func (ks *Keystore) Identity() (crypto.PrivKey, error) {
+	_, data, err := ks.Get(KindIdentity, IdentityName)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.UnmarshalPrivateKey(data)
+}
+
+// PutIdentity stores priv as the node key.
+func (ks *Keystore) PutIdentity(priv crypto.PrivKey) error {
+	k, data, err := privKey(KindIdentity, IdentityName, priv)
+	if err != nil {
+		return err
+	}
+	return ks.Put(k, data)
+}
+
+// MasterKey returns the master key new files are encrypted with.
+func (ks *Keystore) MasterKey() (*crypt.MasterKey, error) {
+	_, data, err := ks.Get(KindMaster, MasterName)
+	if err != nil {
+		return nil, err
+	}
+	return crypt.DecodeMasterKey(data)
+}
+
+// RetiredMasterKeys returns the master keys Rotate replaced, which still
+// unwrap the keys of the files added while they were in use.
+func (ks *Keystore) RetiredMasterKeys() ([]*crypt.MasterKey, error) {
+	var keys []*crypt.MasterKey
+	for _, k := range ks.List() {
+		if k.Kind != KindMaster || k.Retired.IsZero() {
+			continue
+		}
+		_, data, err := ks.GetID(KindMaster, k.ID)
+		if err != nil {
+			return nil, err
+		}
+		m, err := crypt.DecodeMasterKey(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, m)
+	}
+	return keys, nil
+}
+
+// PutMasterKey stores m as the master key.
+func (ks *Keystore) PutMasterKey(m *crypt.MasterKey) error {
+	return ks.Put(Key{Kind: KindMaster, Name: MasterName, ID: m.ID()}, m.Encode())
+}
+
+// NameKey returns the key the name called name is published under,
+// generating it on first use.
+func (ks *Keystore) NameKey(name string) (crypto.PrivKey, error) {
+	_, data, err := ks.Get(KindName, name)
+	if errors.Is(err, ErrNotFound) {
+		_, err = ks.Generate(KindName, name)
+		// Another process may have generated it meanwhile
+		if err == nil || errors.Is(err, ErrExists) {
+			_, data, err = ks.Get(KindName, name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return crypto.UnmarshalPrivateKey(data)
+}
+
+// PutNameKey stores priv as the key of the name called name.
+func (ks *Keystore) PutNameKey(name string, priv crypto.PrivKey) error {
+	k, data, err := privKey(KindName, name, priv)
+	if err != nil {
+		return err
+	}
+	return ks.Put(k, data)
+}
+
+// FileKey returns the key the file c is encrypted with.
+func (ks *Keystore) FileKey(c cid.Cid) (*crypt.FileKey, error) {
+	_, data, err := ks.Get(KindFile, c.String())
+	if err != nil {
+		return nil, err
+	}
+	return crypt.DecodeFileKey(data)
+}
+
+// PutFileKey stores the key the file c is encrypted with. Storing a key
+// that is already there is a no-op.
+func (ks *Keystore) PutFileKey(c cid.Cid, key *crypt.FileKey) error {
+	err := ks.Put(Key{Kind: KindFile, Name: c.String(), ID: c.String()}, key.Encode())
+	if errors.Is(err, ErrExists) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,433 @@
+// Package keystore keeps a node's secret keys in one file, encrypted with
+// a key derived from a passphrase: the node identity, the master keys
+// that wrap the keys of encrypted files, the keys names are published
+// under, and the keys of individual files.
+//
+// Every key is sealed on its own, so one is read without decrypting the
+// rest, and the file is locked while it is changed, so the daemon and the
+// CLI can both add keys to it. Keys replaced by Rotate are kept,
+// marked retired, so what they encrypted stays readable.
+package keystore
+
+import (
+	"cmp"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Version is the version of the keystore file format.
+const Version = 1
+
+// scrypt parameters for new keystores. Deriving the key takes about a
+// tenth of a second, paid once when the keystore is opened.
+const (
+	kdfName = "scrypt"
+	kdfN    = 1 << 15
+	kdfR    = 8
+	kdfP    = 1
+	saltLen = 16
+)
+
+// Bounds on the scrypt parameters a keystore file may ask for, checked
+// before deriving anything: a tampered file could otherwise make opening
+// it take gigabytes of memory or hours. Memory is 128*N*r bytes.
+const (
+	maxKDFN      = 1 << 20
+	maxKDFR      = 16
+	maxKDFP      = 16
+	maxKDFMemory = 1 << 30
+)
+
+// checkValue is sealed into every keystore to tell a wrong passphrase
+// from a damaged key.
+const checkValue = "dfs keystore"
+
+var (
+	// ErrNotFound is returned for a key the keystore doesn't hold.
+	ErrNotFound = errors.New("keystore: no such key")
+	// ErrExists is returned when creating a keystore, or a key, that
+	// already exists.
+	ErrExists = errors.New("keystore: already exists")
+	// ErrPassphrase is returned when opening a keystore with the wrong
+	// passphrase.
+	ErrPassphrase = errors.New("keystore: wrong passphrase")
+)
+
+// Kind is what a key is used for.
+type Kind string
+
+// Key describes a key without revealing it.
+type Key struct {
+	Kind Kind   `json:"kind"`
+	Name string `json:"name"`
+	// ID identifies the key: the peer ID of an identity or name key, the
+	// ID of a master key, the hash of the file a file key encrypts.
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	// Retired is when Rotate replaced the key, zero for keys in use.
+	Retired time.Time `json:"retired,omitzero"`
+}
+
+// entry is a key as stored, its secret sealed with the passphrase.
+type entry struct {
+	Key
+	Sealed []byte `json:"sealed"`
+}
+
+type kdfParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// file is the keystore as stored on disk.
+type file struct {
+	Version int       `json:"version"`
+	KDF     kdfParams `json:"kdf"`
+	Check   []byte    `json:"check"`
+	Keys    []entry   `json:"keys"`
+}
+
+// Keystore is an open keystore file.
+type Keystore struct {
+	path string
+	// key is derived from the passphrase, aead seals with it.
+	key  []byte
+	aead cipher.AEAD
+
+	mu   sync.Mutex
+	file file
+}
+
+// Create creates a keystore at path protected by passphrase. It fails
+// with ErrExists when there is one already.
+func Create(path string, passphrase []byte) (*Keystore, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("keystore: empty passphrase")
+	}
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	params := kdfParams{Name: kdfName, Salt: salt, N: kdfN, R: kdfR, P: kdfP}
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &Keystore{
+		path: path,
+		key:  key,
+		aead: aead,
+		file: file{Version: Version, KDF: params, Keys: []entry{}},
+	}
+	if ks.file.Check, err = ks.seal("check", []byte(checkValue)); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	unlock, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrExists, path)
+	}
+	if err := ks.save(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Open opens the keystore at path. A missing file is reported with an
+// error wrapping fs.ErrNotExist.
+func Open(path string, passphrase []byte) (*Keystore, error) {
+	f, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, f.KDF)
+	if err != nil {
+		return nil, fmt.Errorf("keystore %s: %w", path, err)
+	}
+	return open(path, f, key)
+}
+
+// OpenUnlocked opens the keystore at path with the key UnlockKey returned
+// for it, skipping the passphrase. It fails with ErrPassphrase once the
+// keystore was recreated.
+func OpenUnlocked(path string, key []byte) (*Keystore, error) {
+	f, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return open(path, f, key)
+}
+
+func open(path string, f *file, key []byte) (*Keystore, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("keystore %s: %w", path, err)
+	}
+	ks := &Keystore{path: path, key: slices.Clone(key), aead: aead, file: *f}
+	check, err := ks.open("check", f.Check)
+	if err != nil || string(check) != checkValue {
+		return nil, ErrPassphrase
+	}
+	return ks, nil
+}
+
+// UnlockKey returns the key derived from the passphrase, which opens the
+// keystore without it, see OpenUnlocked. It is as secret as every key in
+// the keystore.
+func (ks *Keystore) UnlockKey() []byte {
+	return slices.Clone(ks.key)
+}
+
+// Exists reports whether there is a keystore at path.
+func Exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Path is the file the keystore is kept in.
+func (ks *Keystore) Path() string {
+	return ks.path
+}
+
+// List returns the keys held, by kind and name, the key in use before
+// those it replaced.
+func (ks *Keystore) List() []Key {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	keys := make([]Key, len(ks.file.Keys))
+	for i, e := range ks.file.Keys {
+		keys[i] = e.Key
+	}
+	slices.SortStableFunc(keys, func(a, b Key) int {
+		if c := cmp.Or(
+			cmp.Compare(kindOrder(a.Kind), kindOrder(b.Kind)),
+			cmp.Compare(a.Kind, b.Kind),
+			cmp.Compare(a.Name, b.Name),
+		); c != 0 {
+			return c
+		}
+		// The key in use, then the most recently retired
+		switch {
+		case a.Retired.IsZero() && !b.Retired.IsZero():
+			return -1
+		case !a.Retired.IsZero() && b.Retired.IsZero():
+			return 1
+		}
+		return b.Retired.Compare(a.Retired)
+	})
+	return keys
+}
+
+// Get returns the key of the given kind and name in use, and its secret.
+func (ks *Keystore) Get(kind Kind, name string) (Key, []byte, error) {
+	return ks.find(func(k Key) bool {
+		return k.Kind == kind && k.Name == name && k.Retired.IsZero()
+	})
+}
+
+// GetID returns the key of the given kind with the given ID, retired or
+// not, and its secret.
+func (ks *Keystore) GetID(kind Kind, id string) (Key, []byte, error) {
+	return ks.find(func(k Key) bool {
+		return k.Kind == kind && k.ID == id
+	})
+}
+
+func (ks *Keystore) find(match func(Key) bool) (Key, []byte, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for _, e := range ks.file.Keys {
+		if !match(e.Key) {
+			continue
+		}
+		secret, err := ks.open(aad(e.Key), e.Sealed)
+		if err != nil {
+			return Key{}, nil, fmt.Errorf("keystore: %s key %s: %w", e.Kind, e.Name, err)
+		}
+		return e.Key, secret, nil
+	}
+	return Key{}, nil, ErrNotFound
+}
+
+// Put adds a key with the given secret. It fails with ErrExists when a
+// key of that kind and name is in use.
+func (ks *Keystore) Put(k Key, secret []byte) error {
+	return ks.update(func(f *file) error {
+		if slices.ContainsFunc(f.Keys, func(e entry) bool {
+			return e.Kind == k.Kind && e.Name == k.Name && e.Retired.IsZero()
+		}) {
+			return fmt.Errorf("%w: %s key %s", ErrExists, k.Kind, k.Name)
+		}
+		return ks.add(f, k, secret)
+	})
+}
+
+// Replace retires the key of k's kind and name in use, if any, and adds
+// k in its place. It returns the retired key.
+func (ks *Keystore) Replace(k Key, secret []byte) (Key, error) {
+	var old Key
+	err := ks.update(func(f *file) error {
+		now := time.Now().UTC()
+		for i, e := range f.Keys {
+			if e.Kind == k.Kind && e.Name == k.Name && e.Retired.IsZero() {
+				f.Keys[i].Retired = now
+				old = f.Keys[i].Key
+			}
+		}
+		return ks.add(f, k, secret)
+	})
+	return old, err
+}
+
+func (ks *Keystore) add(f *file, k Key, secret []byte) error {
+	if k.Created.IsZero() {
+		k.Created = time.Now().UTC()
+	}
+	sealed, err := ks.seal(aad(k), secret)
+	if err != nil {
+		return err
+	}
+	f.Keys = append(f.Keys, entry{Key: k, Sealed: sealed})
+	return nil
+}
+
+// update applies change to the keystore as it is on disk, which another
+// process may have added keys to, and saves the result.
+func (ks *Keystore) update(change func(*file) error) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	unlock, err := lockFile(ks.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := readFile(ks.path)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(f.KDF.Salt, ks.file.KDF.Salt) {
+		return fmt.Errorf("keystore %s was replaced since it was opened", ks.path)
+	}
+	if err := change(f); err != nil {
+		return err
+	}
+	ks.file = *f
+	return ks.save()
+}
+
+// save writes the keystore atomically. Callers hold the file lock.
+func (ks *Keystore) save() error {
+	data, err := json.MarshalIndent(ks.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(ks.path, data, 0600)
+}
+
+func (ks *Keystore) seal(aad string, secret []byte) ([]byte, error) {
+	nonce := make([]byte, ks.aead.NonceSize(), ks.aead.NonceSize()+len(secret)+ks.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return ks.aead.Seal(nonce, nonce, secret, []byte(aad)), nil
+}
+
+func (ks *Keystore) open(aad string, sealed []byte) ([]byte, error) {
+	n := ks.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("sealed key too short")
+	}
+	return ks.aead.Open(nil, sealed[:n], sealed[n:], []byte(aad))
+}
+
+func readFile(path string) (*file, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("keystore %s: %w", path, err)
+		}
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("keystore %s: %w", path, err)
+	}
+	if f.Version != Version {
+		return nil, fmt.Errorf("keystore %s: unsupported version %d", path, f.Version)
+	}
+	if err := f.KDF.check(); err != nil {
+		return nil, fmt.Errorf("keystore %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// check bounds the parameters, see maxKDFN.
+func (p kdfParams) check() error {
+	switch {
+	case p.Name != kdfName:
+		return fmt.Errorf("unsupported key derivation %q", p.Name)
+	case p.N < 2 || p.N > maxKDFN || p.N&(p.N-1) != 0:
+		return fmt.Errorf("scrypt N %d: want a power of two up to %d", p.N, maxKDFN)
+	case p.R < 1 || p.R > maxKDFR:
+		return fmt.Errorf("scrypt r %d: want 1 to %d", p.R, maxKDFR)
+	case p.P < 1 || p.P > maxKDFP:
+		return fmt.Errorf("scrypt p %d: want 1 to %d", p.P, maxKDFP)
+	case 128*int64(p.N)*int64(p.R) > maxKDFMemory:
+		return fmt.Errorf("scrypt N %d and r %d need more than %d MiB", p.N, p.R, maxKDFMemory>>20)
+	case len(p.Salt) < saltLen:
+		return fmt.Errorf("scrypt salt of %d bytes, want %d", len(p.Salt), saltLen)
+	}
+	return nil
+}
+
+func deriveKey(passphrase []byte, p kdfParams) ([]byte, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return scrypt.Key(passphrase, p.Salt, p.N, p.R, p.P, chacha20poly1305.KeySize)
+}
+
+// aad binds a sealed secret to the key it belongs to, so secrets can't be
+// swapped between entries.
+func aad(k Key) string {
+	return string(k.Kind) + "/" + k.ID
+}
+
+func kindOrder(k Kind) int {
+	if i := slices.Index(kinds, k); i >= 0 {
+		return i
+	}
+	return len(kinds)
+}
@@ -0,0 +1,145 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenUnlocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	ks, err := Create(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ks.Generate(KindMaster, MasterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlocked, err := OpenUnlocked(path, ks.UnlockKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := unlocked.Get(KindMaster, MasterName); err != nil || got.ID != k.ID {
+		t.Errorf("Get = %v, %v, want %s", got.ID, err, k.ID)
+	}
+
+	// A key of another keystore, or of one recreated in its place, fails
+	other, err := Create(filepath.Join(t.TempDir(), "keystore.json"), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenUnlocked(path, other.UnlockKey()); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("OpenUnlocked with another keystore's key = %v, want %v", err, ErrPassphrase)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	ks, err := Create(filepath.Join(t.TempDir(), "keystore.json"), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := ks.Generate(KindIdentity, IdentityName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Generate(KindMaster, MasterName); err != nil {
+		t.Fatal(err)
+	}
+	retired, master, err := ks.Rotate(KindMaster, MasterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ks.Backup(KindIdentity, KindMaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new machine, which made a node key of its own
+	other, err := Create(filepath.Join(t.TempDir(), "keystore.json"), []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := other.Generate(KindIdentity, IdentityName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := other.Restore(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 3 {
+		t.Errorf("Restore added %d keys, want 3", len(added))
+	}
+
+	if got, _, err := other.Get(KindIdentity, IdentityName); err != nil || got.ID != id.ID {
+		t.Errorf("identity in use = %v, %v, want %s", got.ID, err, id.ID)
+	}
+	if got, _, err := other.GetID(KindIdentity, fresh.ID); err != nil || got.Retired.IsZero() {
+		t.Errorf("replaced identity = %+v, %v, want it retired", got, err)
+	}
+	if got, _, err := other.Get(KindMaster, MasterName); err != nil || got.ID != master.ID {
+		t.Errorf("master key in use = %v, %v, want %s", got.ID, err, master.ID)
+	}
+	if got, _, err := other.GetID(KindMaster, retired.ID); err != nil || got.Retired.IsZero() {
+		t.Errorf("retired master key = %+v, %v, want it kept retired", got, err)
+	}
+
+	if added, err := other.Restore(data); err != nil || len(added) != 0 {
+		t.Errorf("Restore again = %d keys, %v, want none", len(added), err)
+	}
+}
+
+// A tampered file can't make opening it derive a key with costly
+// parameters: they are refused before the passphrase is tried.
+func TestKDFBounds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	if _, err := Create(path, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(*kdfParams)
+	}{
+		{"huge N", func(p *kdfParams) { p.N = 1 << 30 }},
+		{"N not a power of two", func(p *kdfParams) { p.N = 1<<15 + 1 }},
+		{"N 1", func(p *kdfParams) { p.N = 1 }},
+		{"huge r", func(p *kdfParams) { p.R = 1 << 20 }},
+		{"r 0", func(p *kdfParams) { p.R = 0 }},
+		{"huge p", func(p *kdfParams) { p.P = 1 << 20 }},
+		{"N and r over the memory cap", func(p *kdfParams) { p.N, p.R = 1<<20, 16 }},
+		{"short salt", func(p *kdfParams) { p.Salt = p.Salt[:4] }},
+	} {
+		tampered := f
+		tampered.KDF.Salt = bytes.Clone(f.KDF.Salt)
+		tc.change(&tampered.KDF)
+		data, err := json.Marshal(tampered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if _, err := Open(path, []byte("passphrase")); err == nil {
+			t.Errorf("%s: opened", tc.name)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: refusing took %v", tc.name, d)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// PassphraseEnv is the environment variable the passphrase can be given
+// in, for scripts and daemons started without a terminal.
+const PassphraseEnv = "DFS_PASSPHRASE"
+
+// ErrNoPassphrase is returned when a passphrase is needed but none is
+// configured and there is no terminal to ask on.
+var ErrNoPassphrase = errors.New("keystore: no passphrase; set " + PassphraseEnv + " or keystore.passphrase_file")
+
+// Passphrase returns the passphrase in PassphraseEnv, else the first line
+// of file when it is set, else asks for it on the terminal.
+func Passphrase(file string) ([]byte, error) {
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	if file != "" {
+		return ReadPassphraseFile(file)
+	}
+	return Prompt("Keystore passphrase: ")
+}
+
+// NewPassphrase is Passphrase for a keystore being created: on the
+// terminal it is asked twice, to catch typos.
+func NewPassphrase(file string) ([]byte, error) {
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	if file != "" {
+		return ReadPassphraseFile(file)
+	}
+	p, err := Prompt("New keystore passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	again, err := Prompt("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(p, again) {
+		return nil, errors.New("passphrases don't match")
+	}
+	return p, nil
+}
+
+// ReadPassphraseFile returns the first line of the file at path.
+func ReadPassphraseFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read passphrase: %w", err)
+	}
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
+
+// Prompt reads a passphrase from the terminal without echoing it, after
+// showing msg.
+func Prompt(msg string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, ErrNoPassphrase
+	}
+	fmt.Fprint(os.Stderr, msg)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return p, nil
+}
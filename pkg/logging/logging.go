@@ -16,19 +16,46 @@ const (
 	environment = "development"
 )
 
-func New() (*zap.Logger, error) {
-	return newZapLogger()
+type Option func(*options)
+
+type options struct {
+	level  string
+	redact []Category
+}
+
+// WithLevel overrides the default level of the environment.
+func WithLevel(level string) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// WithRedaction redacts the values of fields in the given categories. The
+// categories listed in DFS_LOG_REDACT are always added.
+func WithRedaction(cats ...Category) Option {
+	return func(o *options) {
+		o.redact = append(o.redact, cats...)
+	}
 }
 
-func MustNew() *zap.Logger {
-	logger, err := newZapLogger()
+func New(opts ...Option) (*zap.Logger, error) {
+	return newZapLogger(opts...)
+}
+
+func MustNew(opts ...Option) *zap.Logger {
+	logger, err := newZapLogger(opts...)
 	if err != nil {
 		panic(err)
 	}
 	return logger
 }
 
-func newZapLogger() (*zap.Logger, error) {
+func newZapLogger(opts ...Option) (*zap.Logger, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	var (
 		logCfg  zap.Config
 		baseDir = "/var/log/dfs"
@@ -44,8 +71,8 @@ func newZapLogger() (*zap.Logger, error) {
 			baseDir = path.Join(cwd, "logs")
 		}
 
-		logCfg.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
 		logCfg = zap.NewDevelopmentConfig()
+		logCfg.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
 	case "production", "prod":
 		// Production logger with structured logging
 		if homeDir, err := os.UserHomeDir(); err == nil {
@@ -56,6 +83,14 @@ func newZapLogger() (*zap.Logger, error) {
 		return nil, fmt.Errorf("unknown environment: %s", environment)
 	}
 
+	if o.level != "" {
+		level, err := zap.ParseAtomicLevel(o.level)
+		if err != nil {
+			return nil, err
+		}
+		logCfg.Level = level
+	}
+
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		return nil, err
 	}
@@ -68,5 +103,26 @@ func newZapLogger() (*zap.Logger, error) {
 		logFilePath,
 	}
 
-	return logCfg.Build()
+	logger, err := logCfg.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return withRedaction(logger, o)
+}
+
+func withRedaction(logger *zap.Logger, o options) (*zap.Logger, error) {
+	envCats, err := redactionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRedactor(append(o.redact, envCats...))
+	if err != nil || r == nil {
+		return logger, err
+	}
+
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &redactCore{Core: core, r: r}
+	})), nil
 }
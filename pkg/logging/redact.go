@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Category groups log fields that can be redacted together.
+type Category string
+
+const (
+	RedactPeers Category = "peers"
+	RedactAddrs Category = "addrs"
+	RedactFiles Category = "files"
+	RedactCIDs  Category = "cids"
+)
+
+// RedactEnvVar lists categories to redact, e.g. "peers,addrs" or "all".
+const RedactEnvVar = "DFS_LOG_REDACT"
+
+var allCategories = []Category{RedactPeers, RedactAddrs, RedactFiles, RedactCIDs}
+
+// fieldCategories maps the field keys used across the code base to the
+// category they belong to. Keys not listed here, such as operation or
+// correlation ids, are never redacted.
+var fieldCategories = map[string]Category{
+	"peer":      RedactPeers,
+	"PeerID":    RedactPeers,
+	"from":      RedactPeers,
+	"to":        RedactPeers,
+	"admin":     RedactPeers,
+	"addr":      RedactAddrs,
+	"Addresses": RedactAddrs,
+	"remote":    RedactAddrs,
+	"onion":     RedactAddrs,
+	"api":       RedactAddrs,
+	"path":      RedactFiles,
+	"file":      RedactFiles,
+	"name":      RedactFiles,
+	"group":     RedactFiles,
+	"cid":       RedactCIDs,
+	"hash":      RedactCIDs,
+	"root":      RedactCIDs,
+	"base":      RedactCIDs,
+}
+
+func ParseCategories(spec string) ([]Category, error) {
+	var cats []Category
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		switch part {
+		case "":
+		case "all":
+			return allCategories, nil
+		case string(RedactPeers), string(RedactAddrs), string(RedactFiles), string(RedactCIDs):
+			cats = append(cats, Category(part))
+		default:
+			return nil, fmt.Errorf("unknown redaction category: %s", part)
+		}
+	}
+	return cats, nil
+}
+
+// redactor replaces sensitive values with a keyed hash. The key is random per
+// process, so the same value still correlates across log lines without being
+// recoverable from the logs.
+type redactor struct {
+	key  []byte
+	cats map[Category]bool
+}
+
+func newRedactor(cats []Category) (*redactor, error) {
+	if len(cats) == 0 {
+		return nil, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	r := &redactor{key: key, cats: make(map[Category]bool)}
+	for _, c := range cats {
+		r.cats[c] = true
+	}
+	return r, nil
+}
+
+func (r *redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		if !r.sensitive(f) {
+			continue
+		}
+		if out == nil {
+			out = make([]zapcore.Field, len(fields))
+			copy(out, fields)
+		}
+		out[i] = zap.String(f.Key, r.token(f))
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+func (r *redactor) sensitive(f zapcore.Field) bool {
+	if !r.cats[fieldCategories[f.Key]] {
+		return false
+	}
+
+	switch f.Type {
+	case zapcore.StringType, zapcore.StringerType, zapcore.ArrayMarshalerType,
+		zapcore.ByteStringType, zapcore.BinaryType, zapcore.ReflectType:
+		return true
+	}
+	return false
+}
+
+func (r *redactor) token(f zapcore.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+
+	mac := hmac.New(sha256.New, r.key)
+	fmt.Fprint(mac, enc.Fields[f.Key])
+	return "redacted:" + hex.EncodeToString(mac.Sum(nil)[:4])
+}
+
+type redactCore struct {
+	zapcore.Core
+	r *redactor
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.r.fields(fields)), r: c.r}
+}
+
+func (c *redactCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(entry, c.r.fields(fields))
+}
+
+func redactionFromEnv() ([]Category, error) {
+	spec := os.Getenv(RedactEnvVar)
+	if spec == "" {
+		return nil, nil
+	}
+	return ParseCategories(spec)
+}
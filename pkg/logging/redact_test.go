@@ -0,0 +1,205 @@
+package logging
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// unredactedKeys are the field keys logged across the code base whose
+// values say nothing about who or what a node stores: counts, durations
+// and states. A key must be here or in fieldCategories.
+var unredactedKeys = []string{
+	"after", "blocks", "bytes", "capacity", "chunks", "copies", "corrupt",
+	"dedup_chunks", "drain_timeout", "duration", "encrypted", "entries",
+	"error_ratio", "factor", "failed", "groups", "interval", "item", "key", "keys",
+	"lifetime", "limit", "members", "missing", "next", "objective", "old_pid", "peers", "pid",
+	"pins", "protocol", "reachability", "read_only", "recent", "removed",
+	"removed_bytes", "resource", "reused_chunks", "seq", "shared", "size",
+	"spec", "status", "strikes", "subsystem", "timeout", "topic", "ttl",
+	"value", "want_zones", "window", "zones",
+}
+
+// observe returns a logger redacting cats and the entries it wrote.
+func observe(t *testing.T, cats ...Category) (*zap.Logger, *observer.ObservedLogs) {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger, err := withRedaction(zap.New(core), options{redact: cats})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger, logs
+}
+
+func TestParseCategories(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want []Category
+	}{
+		{"", nil},
+		{"peers", []Category{RedactPeers}},
+		{" Peers , addrs,", []Category{RedactPeers, RedactAddrs}},
+		{"files,all", allCategories},
+	} {
+		got, err := ParseCategories(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	if _, err := ParseCategories("peers,secrets"); err == nil {
+		t.Error("unknown category accepted")
+	}
+}
+
+// TestRedactCategories logs every key of the table with only its own
+// category redacted: its value is replaced, the others are kept.
+func TestRedactCategories(t *testing.T) {
+	for _, cat := range allCategories {
+		t.Run(string(cat), func(t *testing.T) {
+			t.Setenv(RedactEnvVar, "")
+			logger, logs := observe(t, cat)
+
+			var fields []zap.Field
+			for key := range fieldCategories {
+				fields = append(fields, zap.String(key, "secret-"+key))
+			}
+			fields = append(fields, zap.Int("blocks", 3))
+			logger.Info("entry", fields...)
+			logger.With(fields...).Info("with")
+
+			for _, entry := range logs.All() {
+				got := entry.ContextMap()
+				if got["blocks"] != int64(3) {
+					t.Errorf("%s: blocks = %v, want it kept", entry.Message, got["blocks"])
+				}
+				for key, c := range fieldCategories {
+					value := got[key].(string)
+					switch {
+					case c == cat && !strings.HasPrefix(value, "redacted:"):
+						t.Errorf("%s: %s = %q, want it redacted", entry.Message, key, value)
+					case c == cat && strings.Contains(value, "secret"):
+						t.Errorf("%s: %s token %q leaks the value", entry.Message, key, value)
+					case c != cat && value != "secret-"+key:
+						t.Errorf("%s: %s = %q, want it kept", entry.Message, key, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestRedactCorrelates checks the same value gets the same token, so log
+// lines about one peer can still be followed, and different values don't.
+func TestRedactCorrelates(t *testing.T) {
+	t.Setenv(RedactEnvVar, "")
+	logger, logs := observe(t, RedactPeers)
+	logger.Info("a", zap.String("peer", "12D3KooWA"))
+	logger.Info("b", zap.String("from", "12D3KooWA"))
+	logger.Info("c", zap.String("peer", "12D3KooWB"))
+
+	entries := logs.All()
+	a, b, c := entries[0].ContextMap()["peer"], entries[1].ContextMap()["from"], entries[2].ContextMap()["peer"]
+	if a != b {
+		t.Errorf("one peer logged as %v and %v", a, b)
+	}
+	if a == c {
+		t.Errorf("two peers both logged as %v", a)
+	}
+}
+
+func TestRedactFromEnv(t *testing.T) {
+	t.Setenv(RedactEnvVar, "cids")
+	logger, logs := observe(t)
+	logger.Info("entry", zap.String("cid", "bafy"), zap.String("peer", "12D3KooWA"))
+
+	got := logs.All()[0].ContextMap()
+	if got["cid"] == "bafy" {
+		t.Error("cid kept with DFS_LOG_REDACT=cids")
+	}
+	if got["peer"] != "12D3KooWA" {
+		t.Errorf("peer = %v, want it kept", got["peer"])
+	}
+
+	t.Setenv(RedactEnvVar, "everything")
+	if _, err := withRedaction(zap.NewNop(), options{}); err == nil {
+		t.Error("unknown category in the environment accepted")
+	}
+}
+
+// TestFieldKeysClassified scans the code base for zap fields: each key has
+// to be in fieldCategories or unredactedKeys, so a new key is redacted or
+// deliberately left alone rather than slipping through.
+func TestFieldKeysClassified(t *testing.T) {
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	seen := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "zap" {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			key, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return true
+			}
+
+			seen++
+			if _, ok := fieldCategories[key]; !ok && !slices.Contains(unredactedKeys, key) {
+				t.Errorf("%s: field %q is neither redacted nor listed as safe", fset.Position(lit.Pos()), key)
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen == 0 {
+		t.Fatal("no zap fields found")
+	}
+}
@@ -0,0 +1,256 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DirectoryCodec is the multicodec of encoded directories, next to Codec in
+// the private use range.
+const DirectoryCodec uint64 = 0x300002
+
+// Directories are encoded like manifests, with entries sorted by name:
+//
+//	1: version (varint)
+//	2: entries (repeated message: 1 name, 2 cid, 3 size)
+const (
+	fieldDirVersion = 1
+	fieldDirEntries = 2
+)
+
+var (
+	ErrNotDirectory = errors.New("manifest: not a directory")
+	ErrNoEntry      = errors.New("manifest: no such entry")
+)
+
+// Directory lists named files and subdirectories. Like a manifest, its
+// encoding only depends on the entries, so the same tree always has the
+// same CID.
+type Directory struct {
+	Version int
+	Entries []Entry
+}
+
+// Entry is a file (a manifest CID) or a subdirectory (a directory CID).
+type Entry struct {
+	Name string
+	CID  cid.Cid
+	// Size is the size of the file, or of everything under the directory.
+	Size int64
+}
+
+func (e Entry) IsDir() bool {
+	return e.CID.Type() == DirectoryCodec
+}
+
+// NewDirectory builds a directory of entries, which must have valid,
+// distinct names.
+func NewDirectory(entries []Entry) (*Directory, error) {
+	d := &Directory{Version: Version, Entries: append([]Entry(nil), entries...)}
+	sort.Slice(d.Entries, func(i, j int) bool { return d.Entries[i].Name < d.Entries[j].Name })
+
+	for i, e := range d.Entries {
+		if err := ValidName(e.Name); err != nil {
+			return nil, err
+		}
+		if i > 0 && d.Entries[i-1].Name == e.Name {
+			return nil, fmt.Errorf("manifest: duplicate entry %q", e.Name)
+		}
+		if t := e.CID.Type(); t != Codec && t != DirectoryCodec {
+			return nil, fmt.Errorf("manifest: entry %q: %s is not a file or directory", e.Name, e.CID)
+		}
+	}
+	return d, nil
+}
+
+// ValidName reports whether name can name a directory entry.
+func ValidName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return fmt.Errorf("manifest: invalid entry name %q", name)
+	}
+	return nil
+}
+
+// Size is the total size of the files under the directory.
+func (d *Directory) Size() int64 {
+	var size int64
+	for _, e := range d.Entries {
+		size += e.Size
+	}
+	return size
+}
+
+// Lookup returns the entry called name.
+func (d *Directory) Lookup(name string) (Entry, bool) {
+	i := sort.Search(len(d.Entries), func(i int) bool { return d.Entries[i].Name >= name })
+	if i < len(d.Entries) && d.Entries[i].Name == name {
+		return d.Entries[i], true
+	}
+	return Entry{}, false
+}
+
+func (d *Directory) Encode() ([]byte, error) {
+	if d.Version != Version {
+		return nil, fmt.Errorf("manifest: cannot encode directory version %d", d.Version)
+	}
+
+	var b []byte
+	b = appendVarint(b, fieldDirVersion, uint64(d.Version))
+	for _, e := range d.Entries {
+		var entry []byte
+		entry = appendString(entry, 1, e.Name)
+		entry = appendBytes(entry, 2, e.CID.Bytes())
+		entry = appendVarint(entry, 3, uint64(e.Size))
+		b = protowire.AppendTag(b, fieldDirEntries, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b, nil
+}
+
+// DecodeDirectory reads an encoded directory.
+func DecodeDirectory(data []byte) (*Directory, error) {
+	d := &Directory{}
+
+	err := walkFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case fieldDirVersion:
+			d.Version = int(v)
+		case fieldDirEntries:
+			var e Entry
+			err := walkFields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					e.Name = string(b)
+				case 2:
+					c, err := cid.Cast(b)
+					if err != nil {
+						return err
+					}
+					e.CID = c
+				case 3:
+					e.Size = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			d.Entries = append(d.Entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Version != Version {
+		return nil, fmt.Errorf("manifest: unsupported directory version %d", d.Version)
+	}
+	// Entries are looked up by binary search and joined into paths, so a
+	// directory not written by NewDirectory is refused.
+	for i, e := range d.Entries {
+		if ValidName(e.Name) != nil || (i > 0 && d.Entries[i-1].Name >= e.Name) {
+			return nil, fmt.Errorf("%w: bad directory entries", ErrMalformed)
+		}
+	}
+	return d, nil
+}
+
+// Block encodes the directory and returns it as a block addressed by its
+// CID.
+func (d *Directory) Block() (storage.Block, error) {
+	data, err := d.Encode()
+	if err != nil {
+		return storage.Block{}, err
+	}
+
+	c, err := storage.Sum(DirectoryCodec, data)
+	if err != nil {
+		return storage.Block{}, err
+	}
+	return storage.Block{CID: c, Data: data}, nil
+}
+
+// PutDirectory stores the directory and returns its CID.
+func PutDirectory(ctx context.Context, store chunking.BlockPutter, d *Directory) (cid.Cid, error) {
+	b, err := d.Block()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := store.Put(ctx, b.CID, b.Data); err != nil {
+		return cid.Undef, err
+	}
+	return b.CID, nil
+}
+
+// LoadDirectory fetches and decodes the directory stored under c.
+func LoadDirectory(ctx context.Context, store chunking.BlockGetter, c cid.Cid) (*Directory, error) {
+	if c.Type() != DirectoryCodec {
+		return nil, fmt.Errorf("%w: %s", ErrNotDirectory, c)
+	}
+
+	data, err := store.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Verify(c, data); err != nil {
+		return nil, err
+	}
+	return DecodeDirectory(data)
+}
+
+// Links returns the blocks that the file or directory stored under c
+// refers to directly: the chunks of a file, the entries of a directory.
+func Links(ctx context.Context, store chunking.BlockGetter, c cid.Cid) ([]cid.Cid, error) {
+	if c.Type() != Codec && c.Type() != DirectoryCodec {
+		return nil, nil
+	}
+	data, err := store.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Verify(c, data); err != nil {
+		return nil, err
+	}
+	return DecodeLinks(c, data)
+}
+
+// DecodeLinks is Links for the block c holding data. It is the LinkFunc
+// the block store counts references with, see LinkCodecs.
+func DecodeLinks(c cid.Cid, data []byte) ([]cid.Cid, error) {
+	switch c.Type() {
+	case Codec:
+		m, err := Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		links := make([]cid.Cid, len(m.Chunks))
+		for i, ref := range m.Chunks {
+			links[i] = ref.CID
+		}
+		return links, nil
+	case DirectoryCodec:
+		d, err := DecodeDirectory(data)
+		if err != nil {
+			return nil, err
+		}
+		links := make([]cid.Cid, len(d.Entries))
+		for i, e := range d.Entries {
+			links[i] = e.CID
+		}
+		return links, nil
+	default:
+		return nil, nil
+	}
+}
+
+// LinkCodecs are the codecs of blocks that refer to other blocks.
+var LinkCodecs = []uint64{Codec, DirectoryCodec}
@@ -0,0 +1,247 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/ipfs/go-cid"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Manifests are encoded as protobuf wire format written by hand, always with
+// fields in ascending order and no unknown fields, which keeps the encoding
+// deterministic. Encryption is only written when set, so files added
+// without it keep the CIDs they had before it existed. How chunks are
+// compressed is up to each node's store and never written: the same file
+// has the same CID however it is kept. Params field 5 and chunk field 3
+// held it in manifests written before, and are skipped when decoding.
+// Params field 6, the alignment, is only written when set, like
+// encryption.
+// When an encrypted file has an envelope, the name, size and chunk sizes
+// are only written inside it and encode as empty and zero.
+//
+//	1: version (varint)
+//	2: name (bytes)
+//	3: size (varint)
+//	4: params (message: 1 strategy, 2 size, 3 min_size, 4 max_size, 6 align)
+//	5: chunks (repeated message: 1 cid, 2 size)
+//	6: root (bytes)
+//	7: encryption (message: 1 cipher, 2 key_id, 3 wrapped_key, 4 envelope)
+const (
+	fieldVersion = 1
+	fieldName    = 2
+	fieldSize    = 3
+	fieldParams  = 4
+	fieldChunks  = 5
+	fieldRoot    = 6
+	fieldEncrypt = 7
+)
+
+var ErrMalformed = errors.New("manifest: malformed encoding")
+
+// decoders holds a decoder for every encoding version ever written, so
+// manifests created by older releases stay readable.
+var decoders = map[uint64]func([]byte) (*Manifest, error){
+	1: decodeV1,
+}
+
+func (m *Manifest) Encode() ([]byte, error) {
+	if m.Version != Version {
+		return nil, fmt.Errorf("manifest: cannot encode version %d", m.Version)
+	}
+
+	// Sealed away in the envelope, an opened manifest encodes as it was
+	// stored
+	name, size, sealed := m.Name, m.Size, m.Encryption != nil && m.Encryption.Envelope != nil
+	if sealed {
+		name, size = "", 0
+	}
+
+	var b []byte
+	b = appendVarint(b, fieldVersion, uint64(m.Version))
+	b = appendString(b, fieldName, name)
+	b = appendVarint(b, fieldSize, uint64(size))
+
+	var params []byte
+	params = appendString(params, 1, m.Params.Strategy)
+	params = appendVarint(params, 2, uint64(m.Params.Size))
+	params = appendVarint(params, 3, uint64(m.Params.MinSize))
+	params = appendVarint(params, 4, uint64(m.Params.MaxSize))
+	if m.Params.Align != 0 {
+		params = appendVarint(params, 6, uint64(m.Params.Align))
+	}
+	b = protowire.AppendTag(b, fieldParams, protowire.BytesType)
+	b = protowire.AppendBytes(b, params)
+
+	for _, c := range m.Chunks {
+		size := c.Size
+		if sealed {
+			size = 0
+		}
+		var chunk []byte
+		chunk = appendBytes(chunk, 1, c.CID.Bytes())
+		chunk = appendVarint(chunk, 2, uint64(size))
+		b = protowire.AppendTag(b, fieldChunks, protowire.BytesType)
+		b = protowire.AppendBytes(b, chunk)
+	}
+
+	b = appendBytes(b, fieldRoot, m.Root)
+
+	if e := m.Encryption; e != nil {
+		var enc []byte
+		enc = appendString(enc, 1, e.Cipher)
+		enc = appendString(enc, 2, e.KeyID)
+		enc = appendBytes(enc, 3, e.WrappedKey)
+		if e.Envelope != nil {
+			enc = appendBytes(enc, 4, e.Envelope)
+		}
+		b = protowire.AppendTag(b, fieldEncrypt, protowire.BytesType)
+		b = protowire.AppendBytes(b, enc)
+	}
+	return b, nil
+}
+
+// Decode reads any known manifest version.
+func Decode(data []byte) (*Manifest, error) {
+	num, typ, n := protowire.ConsumeTag(data)
+	if n < 0 || num != fieldVersion || typ != protowire.VarintType {
+		return nil, fmt.Errorf("%w: missing version", ErrMalformed)
+	}
+	version, m := protowire.ConsumeVarint(data[n:])
+	if m < 0 {
+		return nil, fmt.Errorf("%w: bad version", ErrMalformed)
+	}
+
+	decode, ok := decoders[version]
+	if !ok {
+		return nil, fmt.Errorf("manifest: unsupported version %d", version)
+	}
+	return decode(data)
+}
+
+func decodeV1(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+
+	err := walkFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case fieldVersion:
+			m.Version = int(v)
+		case fieldName:
+			m.Name = string(b)
+		case fieldSize:
+			m.Size = int64(v)
+		case fieldParams:
+			return walkFields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					m.Params.Strategy = string(b)
+				case 2:
+					m.Params.Size = int(v)
+				case 3:
+					m.Params.MinSize = int(v)
+				case 4:
+					m.Params.MaxSize = int(v)
+				case 6:
+					m.Params.Align = int(v)
+				}
+				return nil
+			})
+		case fieldChunks:
+			var ref ChunkRef
+			err := walkFields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					c, err := cid.Cast(b)
+					if err != nil {
+						return err
+					}
+					ref.CID = c
+				case 2:
+					ref.Size = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			m.Chunks = append(m.Chunks, ref)
+		case fieldRoot:
+			m.Root = append([]byte(nil), b...)
+		case fieldEncrypt:
+			e := &Encryption{}
+			m.Encryption = e
+			return walkFields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					e.Cipher = string(b)
+				case 2:
+					e.KeyID = string(b)
+				case 3:
+					e.WrappedKey = append([]byte(nil), b...)
+				case 4:
+					e.Envelope = append([]byte(nil), b...)
+					m.sealed = true
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Params.Strategy == "" {
+		m.Params.Strategy = chunking.StrategyFixed
+	}
+	return m, nil
+}
+
+// walkFields calls fn for every varint or length delimited field in data.
+func walkFields(data []byte, fn func(num protowire.Number, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return ErrMalformed
+		}
+		data = data[n:]
+
+		var (
+			v uint64
+			b []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return ErrMalformed
+		}
+		data = data[n:]
+
+		if err := fn(num, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
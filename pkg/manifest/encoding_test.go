@@ -0,0 +1,234 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/ipfs/go-cid"
+)
+
+func mustCID(t *testing.T, s string) cid.Cid {
+	t.Helper()
+	c, err := cid.Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// goldenManifest is the manifest of the file chunking's TestGoldenFixed
+// splits.
+func goldenManifest(t *testing.T) *Manifest {
+	t.Helper()
+	m, err := New("golden.bin", &chunking.ChunkList{
+		Params: chunking.Params{Strategy: chunking.StrategyFixed, Size: 4096},
+		Size:   10000,
+		Chunks: []chunking.Chunk{
+			{CID: mustCID(t, "bafkreicxgsmmdlnvlw7jbdgvi23vdtcoyh2zjfwunxzmalwkziu62z7gf4"), Size: 4096},
+			{CID: mustCID(t, "bafkreiezamr7joshv5r4frrisbxovjjyhzs3fysalt5ncx2altqv5elvku"), Offset: 4096, Size: 4096},
+			{CID: mustCID(t, "bafkreibcefjsvena4li37iusq5lc3pzefddpclewqxbdws6neny6cdm2ia"), Offset: 8192, Size: 1808},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// The encoding of a manifest is its file's address. An encoding that
+// changes, even just reordering fields, changes the CID of every file.
+func TestGoldenManifest(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Manifest)
+		cid    string
+	}{
+		{"plain", func(*Manifest) {}, "bagaybqabciqgo6xpbwi6oerglk3y3xrdhtmybre7l2jfmoz33ckgyp3i6c7bjmy"},
+		{"encrypted", func(m *Manifest) {
+			m.Encryption = &Encryption{Cipher: "xchacha20-poly1305", KeyID: "golden", WrappedKey: []byte("wrapped key")}
+		}, "bagaybqabciqjc7quotwsghb5lvhsdjdxhibi7u3mkwxtlndvyfwd2v4cwzvi5sq"},
+		{"aligned", func(m *Manifest) {
+			m.Params = chunking.Params{Strategy: chunking.StrategyFastCDC, Size: 4096, MinSize: 1024, MaxSize: 16384, Align: 512}
+		}, "bagaybqabciqfr64bilinzw6pxbois5bc2bbobee6kcwezxmddvhirr6q736ffyy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := goldenManifest(t)
+			tt.change(m)
+			b, err := m.Block()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.CID.String(); got != tt.cid {
+				t.Errorf("CID = %s, want %s", got, tt.cid)
+			}
+
+			decoded, err := Decode(b.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, m) {
+				t.Errorf("decoded %v, want %v", decoded, m)
+			}
+		})
+	}
+}
+
+// How chunks are compressed is up to each store, so it must not change
+// the address of a file.
+func TestCompressionLeftOut(t *testing.T) {
+	m, err := New("golden.bin", &chunking.ChunkList{
+		Params: chunking.Params{Strategy: chunking.StrategyFixed, Size: 4096, Compression: "zstd"},
+		Size:   10000,
+		Chunks: goldenManifest(t).ChunkList(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.CID.String(), "bagaybqabciqgo6xpbwi6oerglk3y3xrdhtmybre7l2jfmoz33ckgyp3i6c7bjmy"; got != want {
+		t.Errorf("CID = %s, want %s", got, want)
+	}
+}
+
+// Manifests written when compression was still recorded keep decoding.
+func TestDecodeRecordedCompression(t *testing.T) {
+	data, err := hex.DecodeString("0801120a676f6c64656e2e62696e18904e22140a056669786564108020180020002a047a7374642a290a240155122057" +
+		"3498c1adb55dbe908cd546b751cc4ec1f59496d46df2c02ecaca29ed67e62f1080202a290a2401551220990323f4ba47" +
+		"af63c2c628906eeaa5383e65b2e2405cfad15f405ce15e9175551080202a2f0a24015512202221532a91a0e2d1bfa292" +
+		"87562dbf2428c6f12c9685c23b4bcd2371e10d9a4010900e1a047a7374643222122066ef0736ad0b9238f9e2bd8e2f6f" +
+		"05a2722876e0b113f1419d228c9c8b8f97c0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := goldenManifest(t); !reflect.DeepEqual(m, want) {
+		t.Errorf("decoded %v, want %v", m, want)
+	}
+}
+
+// A sealed manifest keeps the name and sizes out of its encoding, and
+// opened with the file key it is the manifest that was sealed, with the
+// same CID.
+func TestSealedManifest(t *testing.T) {
+	key, err := crypt.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := goldenManifest(t)
+	m.Encryption = &Encryption{Cipher: crypt.Cipher, KeyID: "key", WrappedKey: []byte("wrapped")}
+	if err := m.Seal(key); err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b.Data, []byte("golden.bin")) {
+		t.Error("sealed manifest holds the name")
+	}
+
+	decoded, err := Decode(b.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Sealed() || decoded.Name != "" || decoded.Size != 0 {
+		t.Fatalf("decoded %v, want it sealed", decoded)
+	}
+	for _, ref := range decoded.Chunks {
+		if ref.Size != 0 {
+			t.Errorf("sealed chunk %s has size %d", ref.CID, ref.Size)
+		}
+	}
+	if err := decoded.Verify(); err != nil {
+		t.Errorf("Verify of a sealed manifest: %v", err)
+	}
+	for _, c := range decoded.ChunkList() {
+		if c.Size != 4096 {
+			t.Errorf("sealed chunk list gives %d bytes, want the chunk size", c.Size)
+		}
+	}
+
+	other, err := crypt.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Open(other); !errors.Is(err, crypt.ErrDecrypt) {
+		t.Errorf("Open with another key: %v, want ErrDecrypt", err)
+	}
+	if err := decoded.Open(key); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Sealed() || !reflect.DeepEqual(decoded.Chunks, m.Chunks) || decoded.Name != m.Name || decoded.Size != m.Size {
+		t.Errorf("opened %v, want %v", decoded, m)
+	}
+	reencoded, err := decoded.Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reencoded.CID.Equals(b.CID) {
+		t.Errorf("opened manifest hashes to %s, want %s", reencoded.CID, b.CID)
+	}
+
+	tampered := decoded.Encryption.Envelope
+	tampered[len(tampered)-1] ^= 1
+	sealed, err := Decode(b.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed.Encryption.Envelope = tampered
+	if err := sealed.Open(key); !errors.Is(err, crypt.ErrDecrypt) {
+		t.Errorf("Open of a tampered envelope: %v, want ErrDecrypt", err)
+	}
+}
+
+func TestGoldenManifestEncoding(t *testing.T) {
+	data, err := goldenManifest(t).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "0801120a676f6c64656e2e62696e18904e220e0a056669786564108020180020002a290a2401551220573498c1adb55dbe908cd546b751cc4ec1f59496d46df2c02ecaca29ed67e62f1080202a290a2401551220990323f4ba47af63c2c628906eeaa5383e65b2e2405cfad15f405ce15e9175551080202a290a24015512202221532a91a0e2d1bfa29287562dbf2428c6f12c9685c23b4bcd2371e10d9a4010900e3222122066ef0736ad0b9238f9e2bd8e2f6f05a2722876e0b113f1419d228c9c8b8f97c0"
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("encoding = %s\nwant       %s", got, want)
+	}
+}
+
+func TestGoldenDirectory(t *testing.T) {
+	file, err := goldenManifest(t).Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := NewDirectory([]Entry{{Name: "golden.bin", CID: file.CID, Size: 10000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subBlock, err := sub.Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDirectory([]Entry{
+		{Name: "sub", CID: subBlock.CID, Size: 10000},
+		{Name: "a.bin", CID: file.CID, Size: 10000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.CID.String(), "bagbibqabciqfruufrj3ky5xwz54k7eujjrhckyselqwc2yutrxde4rfypwgxybq"; got != want {
+		t.Errorf("CID = %s, want %s", got, want)
+	}
+}
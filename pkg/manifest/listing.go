@@ -0,0 +1,131 @@
+package manifest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ListingVersion is the listing format written by this release.
+const ListingVersion = 1
+
+// listingDomain prefixes signed listings so the signature can't be passed
+// off as one over something else.
+const listingDomain = "dfs-listing:"
+
+var ErrBadSignature = errors.New("manifest: listing signature does not verify")
+
+// Listing is a machine-readable account of everything under a root: every
+// file with its size and hashes. A node signs the listings it exports so
+// recipients can audit what a dataset contains, and who vouched for it,
+// before fetching it.
+type Listing struct {
+	Version int          `json:"version"`
+	Root    string       `json:"root"`
+	Files   []ListedFile `json:"files"`
+	// Signer is the peer ID whose key made Signature.
+	Signer    string `json:"signer,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+type ListedFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// CID is the content address of the file, Root the hex encoded Merkle
+	// root over its chunks.
+	CID    string        `json:"cid"`
+	Root   string        `json:"merkle_root"`
+	Chunks []ListedChunk `json:"chunks"`
+}
+
+type ListedChunk struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	CID    string `json:"cid"`
+}
+
+// NewListing lists the file described by m, stored under root.
+func NewListing(root cid.Cid, m *Manifest) *Listing {
+	file := ListedFile{
+		Path:   m.Name,
+		Size:   m.Size,
+		CID:    root.String(),
+		Root:   hex.EncodeToString(m.Root),
+		Chunks: make([]ListedChunk, len(m.Chunks)),
+	}
+	for i, c := range m.ChunkList() {
+		file.Chunks[i] = ListedChunk{Offset: c.Offset, Size: c.Size, CID: c.CID.String()}
+	}
+
+	return &Listing{
+		Version: ListingVersion,
+		Root:    root.String(),
+		Files:   []ListedFile{file},
+	}
+}
+
+// Sign signs the listing with priv, replacing any earlier signature.
+func (l *Listing) Sign(priv crypto.PrivKey) error {
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	l.Signer = id.String()
+
+	payload, err := l.payload()
+	if err != nil {
+		return err
+	}
+	l.Signature, err = priv.Sign(payload)
+	return err
+}
+
+// Verify checks the signature and returns the signer.
+func (l *Listing) Verify() (peer.ID, error) {
+	if l.Signer == "" || len(l.Signature) == 0 {
+		return "", errors.New("manifest: listing is not signed")
+	}
+	if l.Version != ListingVersion {
+		return "", fmt.Errorf("manifest: unsupported listing version %d", l.Version)
+	}
+
+	id, err := peer.Decode(l.Signer)
+	if err != nil {
+		return "", fmt.Errorf("manifest: listing signer: %w", err)
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return "", fmt.Errorf("manifest: listing signer: %w", err)
+	}
+
+	payload, err := l.payload()
+	if err != nil {
+		return "", err
+	}
+	ok, err := pub.Verify(payload, l.Signature)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrBadSignature
+	}
+	return id, nil
+}
+
+// payload is what gets signed: the listing, signer included, without the
+// signature.
+func (l *Listing) payload() ([]byte, error) {
+	unsigned := *l
+	unsigned.Signature = nil
+
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(listingDomain), data...), nil
+}
@@ -0,0 +1,271 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codec is the multicodec of encoded manifests, taken from the private use
+// range. Together with the version field inside the encoding it tells
+// readers how to decode a manifest.
+const Codec uint64 = 0x300001
+
+// Version is the encoding version written by this release.
+const Version = 1
+
+// Manifest describes a stored file. Its encoding contains nothing that
+// depends on the platform or on time, so the same bytes chunked with the
+// same params always produce the same CID.
+type Manifest struct {
+	Version int
+	Name    string
+	Size    int64
+	Params  chunking.Params
+	Chunks  []ChunkRef
+	// Root is the Merkle root (a sha2-256 multihash) over the chunk CIDs.
+	Root []byte
+	// Encryption is set when the chunks are sealed with a file key.
+	Encryption *Encryption
+
+	// sealed is set while Name, Size and the chunk sizes are still locked
+	// in the envelope, see Open.
+	sealed bool
+}
+
+// Encryption describes how the chunks of an encrypted file are sealed, see
+// package crypt. Chunk sizes in the manifest are those of the plaintext.
+type Encryption struct {
+	Cipher string
+	// KeyID identifies the master key that wrapped the file key.
+	KeyID      string
+	WrappedKey []byte
+	// Envelope holds the name and sizes of the file sealed with the file
+	// key, see Seal. Manifests encrypted before it existed have none and
+	// keep those in clear.
+	Envelope []byte
+}
+
+type ChunkRef struct {
+	CID  cid.Cid
+	Size int64
+}
+
+// New builds the manifest of a file that was split into list.
+func New(name string, list *chunking.ChunkList) (*Manifest, error) {
+	m := &Manifest{
+		Version: Version,
+		Name:    name,
+		Size:    list.Size,
+		Params:  list.Params,
+		Chunks:  make([]ChunkRef, len(list.Chunks)),
+	}
+	// Compression is how this node keeps the chunks, not part of the file.
+	m.Params.Compression = ""
+	for i, c := range list.Chunks {
+		m.Chunks[i] = ChunkRef{CID: c.CID, Size: c.Size}
+	}
+
+	root, err := MerkleRoot(m.Chunks)
+	if err != nil {
+		return nil, err
+	}
+	m.Root = root
+	return m, nil
+}
+
+// ChunkList returns the chunks with their offsets, ready for reassembly.
+// While m is sealed every chunk is given the largest size its params
+// allow, which is enough to fetch the blocks but not to reassemble them.
+func (m *Manifest) ChunkList() []chunking.Chunk {
+	chunks := make([]chunking.Chunk, len(m.Chunks))
+
+	var offset int64
+	for i, c := range m.Chunks {
+		size := c.Size
+		if m.sealed {
+			size = int64(m.Params.MaxChunk())
+		}
+		chunks[i] = chunking.Chunk{CID: c.CID, Offset: offset, Size: size}
+		offset += size
+	}
+	return chunks
+}
+
+// Seal locks the name and sizes of an encrypted file in its envelope,
+// sealed with s, the file key. They are left out of the encoding, so
+// only nodes holding the key learn what the file is called and how big
+// it is. Sealing is deterministic, so the CID is too.
+func (m *Manifest) Seal(s chunking.Sealer) error {
+	if m.Encryption == nil {
+		return errors.New("manifest: sealing a file that isn't encrypted")
+	}
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	b = appendVarint(b, 2, uint64(m.Size))
+	for _, c := range m.Chunks {
+		b = appendVarint(b, 3, uint64(c.Size))
+	}
+	m.Encryption.Envelope = s.Seal(b)
+	m.sealed = false
+	return nil
+}
+
+// Sealed reports whether the name and sizes of m are still locked in its
+// envelope: Name is empty and Size and the chunk sizes are zero until
+// Open.
+func (m *Manifest) Sealed() bool {
+	return m.sealed
+}
+
+// Open restores the name and sizes sealed in the envelope with s, the
+// file key.
+func (m *Manifest) Open(s chunking.Sealer) error {
+	if !m.sealed {
+		return nil
+	}
+	data, err := s.Open(m.Encryption.Envelope)
+	if err != nil {
+		return fmt.Errorf("manifest: open envelope: %w", err)
+	}
+
+	var (
+		name  string
+		size  int64
+		sizes []int64
+	)
+	err = walkFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			name = string(b)
+		case 2:
+			size = int64(v)
+		case 3:
+			sizes = append(sizes, int64(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(sizes) != len(m.Chunks) {
+		return fmt.Errorf("%w: envelope has %d chunk sizes for %d chunks", ErrMalformed, len(sizes), len(m.Chunks))
+	}
+
+	m.Name, m.Size = name, size
+	for i := range m.Chunks {
+		m.Chunks[i].Size = sizes[i]
+	}
+	m.sealed = false
+	return m.Verify()
+}
+
+// Block encodes the manifest and returns it as a block addressed by its CID,
+// which is the content address of the file.
+func (m *Manifest) Block() (storage.Block, error) {
+	data, err := m.Encode()
+	if err != nil {
+		return storage.Block{}, err
+	}
+
+	c, err := storage.Sum(Codec, data)
+	if err != nil {
+		return storage.Block{}, err
+	}
+	return storage.Block{CID: c, Data: data}, nil
+}
+
+// Put stores the manifest and returns its CID.
+func Put(ctx context.Context, store chunking.BlockPutter, m *Manifest) (cid.Cid, error) {
+	b, err := m.Block()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := store.Put(ctx, b.CID, b.Data); err != nil {
+		return cid.Undef, err
+	}
+	return b.CID, nil
+}
+
+// Hash returns the CID the file read from r gets when added under name
+// with params, without storing anything. Comparing it with a stored CID
+// tells whether a file changed.
+func Hash(ctx context.Context, r io.Reader, name string, params chunking.Params) (cid.Cid, error) {
+	list, err := chunking.Split(ctx, r, params, discard{})
+	if err != nil {
+		return cid.Undef, err
+	}
+	m, err := New(name, list)
+	if err != nil {
+		return cid.Undef, err
+	}
+	b, err := m.Block()
+	if err != nil {
+		return cid.Undef, err
+	}
+	return b.CID, nil
+}
+
+// discard is a BlockPutter that keeps nothing.
+type discard struct{}
+
+func (discard) Put(context.Context, cid.Cid, []byte) error { return nil }
+
+// Load fetches and decodes the manifest stored under c.
+func Load(ctx context.Context, store chunking.BlockGetter, c cid.Cid) (*Manifest, error) {
+	if c.Type() != Codec {
+		return nil, fmt.Errorf("manifest: %s is not a manifest", c)
+	}
+
+	data, err := store.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Verify(c, data); err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Verify checks that the chunk list adds up to the recorded size and root.
+func (m *Manifest) Verify() error {
+	var size int64
+	for _, c := range m.Chunks {
+		size += c.Size
+	}
+	if size != m.Size {
+		return fmt.Errorf("manifest: chunks add up to %d bytes, expected %d", size, m.Size)
+	}
+
+	root, err := MerkleRoot(m.Chunks)
+	if err != nil {
+		return err
+	}
+	if string(root) != string(m.Root) {
+		return fmt.Errorf("manifest: merkle root mismatch")
+	}
+	return nil
+}
+
+func rootString(root []byte) string {
+	h, err := mh.Cast(root)
+	if err != nil {
+		return fmt.Sprintf("%x", root)
+	}
+	return h.B58String()
+}
+
+func (m *Manifest) String() string {
+	s := fmt.Sprintf("%s (%d bytes, %d chunks, root %s)", m.Name, m.Size, len(m.Chunks), rootString(m.Root))
+	if m.Encryption != nil {
+		s += ", encrypted"
+	}
+	return s
+}
@@ -0,0 +1,50 @@
+package manifest
+
+import (
+	"crypto/sha256"
+
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Domain separation prefixes keep leaf hashes from being confused with
+// inner node hashes.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// MerkleRoot computes a binary Merkle tree over the chunk CIDs and returns
+// the root as a sha2-256 multihash. An odd node at the end of a level is
+// promoted unchanged.
+func MerkleRoot(chunks []ChunkRef) ([]byte, error) {
+	if len(chunks) == 0 {
+		sum := sha256.Sum256([]byte{leafPrefix})
+		return mh.Encode(sum[:], mh.SHA2_256)
+	}
+
+	level := make([][]byte, len(chunks))
+	for i, c := range chunks {
+		h := sha256.New()
+		h.Write([]byte{leafPrefix})
+		h.Write(c.CID.Bytes())
+		level[i] = h.Sum(nil)
+	}
+
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte{nodePrefix})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+
+	return mh.Encode(level[0], mh.SHA2_256)
+}
@@ -0,0 +1,51 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/ipfs/go-cid"
+)
+
+// ParsePath splits a path such as /<root>/sub/dir/file.txt, with or without
+// the leading slash, into the root CID and the names below it.
+func ParsePath(p string) (cid.Cid, []string, error) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+
+	root, err := cid.Decode(parts[0])
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("invalid root %q: %w", parts[0], err)
+	}
+
+	var names []string
+	for _, name := range parts[1:] {
+		if name == "" {
+			continue
+		}
+		if err := ValidName(name); err != nil {
+			return cid.Undef, nil, err
+		}
+		names = append(names, name)
+	}
+	return root, names, nil
+}
+
+// Resolve follows names from the directory root and returns the CID they
+// lead to.
+func Resolve(ctx context.Context, store chunking.BlockGetter, root cid.Cid, names []string) (cid.Cid, error) {
+	c := root
+	for i, name := range names {
+		d, err := LoadDirectory(ctx, store, c)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("%s: %w", strings.Join(names[:i], "/"), err)
+		}
+		e, ok := d.Lookup(name)
+		if !ok {
+			return cid.Undef, fmt.Errorf("%s: %w", strings.Join(names[:i+1], "/"), ErrNoEntry)
+		}
+		c = e.CID
+	}
+	return c, nil
+}
@@ -0,0 +1,223 @@
+// Package metrics holds the node's Prometheus metrics. Everything is
+// registered in Registry, which the daemon serves on metrics.addr.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "dfs"
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	// ChunkSize is observed once per chunk written by an add.
+	ChunkSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "chunker",
+		Name:      "chunk_size_bytes",
+		Help:      "Size of the chunks files are split into.",
+		// 4 KiB to 8 MiB
+		Buckets: prometheus.ExponentialBuckets(4<<10, 2, 12),
+	}, []string{"strategy"})
+
+	// DedupRatio is observed once per add with the fraction of the file's
+	// bytes that were already stored.
+	DedupRatio = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "add",
+		Name:      "dedup_ratio",
+		Help:      "Fraction of each added file's bytes that were already stored.",
+		Buckets:   []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+	})
+
+	AddedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "add",
+		Name:      "bytes_total",
+		Help:      "Bytes of file data added.",
+	})
+
+	DedupBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "add",
+		Name:      "dedup_bytes_total",
+		Help:      "Bytes of added file data that were already stored.",
+	})
+
+	// CompressionRatio is observed once per block stored compressed with
+	// its compressed size as a fraction of the original.
+	CompressionRatio = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "compression_ratio",
+		Help:      "Compressed size of stored blocks as a fraction of their size.",
+		Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+	})
+
+	CompressionSavedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "compression_saved_bytes_total",
+		Help:      "Bytes compression saved on blocks written.",
+	})
+
+	// SharedWants counts fetches that waited for a block already wanted
+	// from the same peer instead of asking again.
+	SharedWants = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "exchange",
+		Name:      "shared_wants_total",
+		Help:      "Block wants joined to one already outstanding.",
+	})
+
+	DuplicateBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "exchange",
+		Name:      "duplicate_blocks_total",
+		Help:      "Blocks received after they arrived from another peer or stopped being wanted.",
+	})
+
+	// ThrottledSeconds is time the block exchange spent waiting for the
+	// bandwidth limits, by direction.
+	ThrottledSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "exchange",
+		Name:      "throttled_seconds_total",
+		Help:      "Time spent waiting for the bandwidth limits, by direction.",
+	}, []string{"direction"})
+
+	// StreamsShed counts inbound streams reset because every worker for
+	// the protocol was busy and its queue full.
+	StreamsShed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "streams",
+		Name:      "shed_total",
+		Help:      "Inbound streams reset because the protocol's handlers were saturated.",
+	}, []string{"protocol"})
+
+	// StreamsActive is the number of inbound streams being handled.
+	StreamsActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "streams",
+		Name:      "active",
+		Help:      "Inbound streams being handled, by protocol.",
+	}, []string{"protocol"})
+
+	// StreamsQueued is the number of inbound streams waiting for a worker.
+	StreamsQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "streams",
+		Name:      "queued",
+		Help:      "Inbound streams waiting for a handler, by protocol.",
+	}, []string{"protocol"})
+
+	// PressureCapacity is the share of its usual concurrency the node
+	// allows itself under resource pressure.
+	PressureCapacity = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pressure",
+		Name:      "capacity_ratio",
+		Help:      "Share of the usual concurrency in use, 1 when not throttled.",
+	})
+
+	// PressureUsage is each watched resource's use as a share of its limit.
+	PressureUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pressure",
+		Name:      "usage_ratio",
+		Help:      "Use of each watched resource as a share of its limit.",
+	}, []string{"resource"})
+
+	// Operations counts the operations the health tracker judges, by
+	// subsystem and result.
+	Operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "health",
+		Name:      "operations_total",
+		Help:      "Operations counted towards error budgets, by subsystem and result.",
+	}, []string{"subsystem", "result"})
+
+	// GCRuns counts garbage collections by result, "ok" or "error".
+	// Dry runs aren't counted in any of the gc metrics.
+	GCRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gc",
+		Name:      "runs_total",
+		Help:      "Garbage collections by result.",
+	}, []string{"result"})
+
+	GCDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "gc",
+		Name:      "duration_seconds",
+		Help:      "Time taken by garbage collections.",
+		// 10ms to about 20 minutes
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	})
+
+	GCScannedBlocks = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "gc",
+		Name:      "scanned_blocks",
+		Help:      "Blocks in the store during the last garbage collection.",
+	})
+
+	GCRemovedBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gc",
+		Name:      "removed_blocks_total",
+		Help:      "Blocks removed by garbage collection.",
+	})
+
+	GCRemovedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gc",
+		Name:      "removed_bytes_total",
+		Help:      "Bytes reclaimed by garbage collection.",
+	})
+
+	GCLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "gc",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time the last successful garbage collection finished.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ChunkSize,
+		DedupRatio,
+		AddedBytes,
+		DedupBytes,
+		CompressionRatio,
+		CompressionSavedBytes,
+		SharedWants,
+		DuplicateBlocks,
+		ThrottledSeconds,
+		StreamsShed,
+		StreamsActive,
+		StreamsQueued,
+		PressureCapacity,
+		PressureUsage,
+		Operations,
+		GCRuns,
+		GCDuration,
+		GCScannedBlocks,
+		GCRemovedBlocks,
+		GCRemovedBytes,
+		GCLastSuccess,
+	)
+}
+
+// Handler serves Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Everything registered gathers cleanly under the dfs namespace, beside
+// the Go and process collectors.
+func TestRegistry(t *testing.T) {
+	ChunkSize.WithLabelValues("test").Observe(8 << 10)
+	Operations.WithLabelValues("test", "ok").Inc()
+
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, f := range families {
+		name := f.GetName()
+		seen[name] = true
+		if !strings.HasPrefix(name, namespace+"_") && !strings.HasPrefix(name, "go_") && !strings.HasPrefix(name, "process_") {
+			t.Errorf("metric %s outside the %s namespace", name, namespace)
+		}
+	}
+	for _, name := range []string{"dfs_chunker_chunk_size_bytes", "dfs_health_operations_total"} {
+		if !seen[name] {
+			t.Errorf("%s not gathered", name)
+		}
+	}
+}
+
+// Handler serves the registry in the text format.
+func TestHandler(t *testing.T) {
+	AddedBytes.Add(1)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != 200 {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if !strings.Contains(string(body), "dfs_add_bytes_total ") {
+		t.Errorf("dfs_add_bytes_total missing from:\n%s", body)
+	}
+}
@@ -0,0 +1,115 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+)
+
+// Bandwidth caps the block exchange, in bytes per second. Zero leaves a
+// direction unlimited.
+type Bandwidth struct {
+	Upload   int64
+	Download int64
+	// PeerUpload and PeerDownload cap the traffic with any one peer.
+	PeerUpload   int64
+	PeerDownload int64
+}
+
+// shaper holds blocks back to keep to the Bandwidth limits. Limits are
+// token buckets holding a second's worth of traffic, so short bursts go
+// through at full speed.
+type shaper struct {
+	limits   Bandwidth
+	upload   *rate.Limiter
+	download *rate.Limiter
+
+	mu    sync.Mutex
+	peers map[peer.ID]*peerShaper
+}
+
+type peerShaper struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+func newShaper(limits Bandwidth) *shaper {
+	return &shaper{
+		limits:   limits,
+		upload:   newLimiter(limits.Upload),
+		download: newLimiter(limits.Download),
+		peers:    make(map[peer.ID]*peerShaper),
+	}
+}
+
+// sent waits until n more bytes may be sent to p.
+func (s *shaper) sent(ctx context.Context, p peer.ID, n int) error {
+	return throttle(ctx, "upload", n, s.peer(p).upload, s.upload)
+}
+
+// received waits until n more bytes may be taken from p. Not reading
+// meanwhile makes the peer slow down.
+func (s *shaper) received(ctx context.Context, p peer.ID, n int) error {
+	return throttle(ctx, "download", n, s.peer(p).download, s.download)
+}
+
+func (s *shaper) peer(p peer.ID) *peerShaper {
+	if s.limits.PeerUpload <= 0 && s.limits.PeerDownload <= 0 {
+		return &peerShaper{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps := s.peers[p]
+	if ps == nil {
+		ps = &peerShaper{
+			upload:   newLimiter(s.limits.PeerUpload),
+			download: newLimiter(s.limits.PeerDownload),
+		}
+		s.peers[p] = ps
+	}
+	return ps
+}
+
+// forget drops the limits kept for p once it disconnects.
+func (s *shaper) forget(p peer.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.peers, p)
+}
+
+func newLimiter(bps int64) *rate.Limiter {
+	if bps <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bps), int(bps))
+}
+
+// throttle waits on every limiter in turn for n bytes, in pieces no larger
+// than their bursts. Nil limiters don't limit.
+func throttle(ctx context.Context, direction string, n int, limiters ...*rate.Limiter) error {
+	start := time.Now()
+	defer func() {
+		if waited := time.Since(start); waited > time.Millisecond {
+			metrics.ThrottledSeconds.WithLabelValues(direction).Add(waited.Seconds())
+		}
+	}()
+
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		for left := n; left > 0; {
+			k := min(left, l.Burst())
+			if err := l.WaitN(ctx, k); err != nil {
+				return err
+			}
+			left -= k
+		}
+	}
+	return nil
+}
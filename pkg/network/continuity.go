@@ -0,0 +1,434 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const continuityDomain = "dfs-continuity/1\n"
+
+// successionInterval is how often a node repeats the continuity records
+// leading to its identity.
+const successionInterval = 10 * time.Minute
+
+var (
+	// ErrBadContinuity is returned for a continuity record whose
+	// signatures don't check out.
+	ErrBadContinuity = errors.New("network: invalid continuity record")
+	// ErrConflictingSuccession is returned for a record naming another
+	// successor for a peer whose successor is known already.
+	ErrConflictingSuccession = errors.New("network: peer already has a successor")
+)
+
+// Continuity records that a node rotated its key: the old key signs the
+// peer ID of the new one, and the new key signs the same statement back,
+// so nobody can claim to succeed a peer without holding both keys. Peers
+// that learn of a record carry over what they granted the old peer ID,
+// such as allow rules, replicas kept for it and its name.
+type Continuity struct {
+	Old     string    `json:"old"`
+	New     string    `json:"new"`
+	Rotated time.Time `json:"rotated"`
+	// OldKey and NewKey are the public keys of Old and New, which only
+	// some peer IDs embed.
+	OldKey []byte `json:"old_key"`
+	NewKey []byte `json:"new_key"`
+	// OldSignature and NewSignature sign Old, New and Rotated with the
+	// old and the new key.
+	OldSignature []byte `json:"old_signature"`
+	NewSignature []byte `json:"new_signature"`
+	// Recorded is when this node first kept the record. It isn't signed
+	// or announced; a revocation of Old undoes records kept after it.
+	Recorded time.Time `json:"recorded,omitzero"`
+}
+
+// NewContinuity signs over from the old node key to the new one.
+func NewContinuity(oldKey, newKey crypto.PrivKey, rotated time.Time) (*Continuity, error) {
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		return nil, err
+	}
+	newID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+	if oldID == newID {
+		return nil, fmt.Errorf("%w: old and new key are the same", ErrBadContinuity)
+	}
+
+	c := &Continuity{Old: oldID.String(), New: newID.String(), Rotated: rotated.UTC().Truncate(time.Second)}
+	if c.OldKey, err = crypto.MarshalPublicKey(oldKey.GetPublic()); err != nil {
+		return nil, err
+	}
+	if c.NewKey, err = crypto.MarshalPublicKey(newKey.GetPublic()); err != nil {
+		return nil, err
+	}
+	if c.OldSignature, err = oldKey.Sign(c.payload()); err != nil {
+		return nil, err
+	}
+	if c.NewSignature, err = newKey.Sign(c.payload()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Verify checks both signatures and returns the peer IDs the record links.
+func (c *Continuity) Verify() (from, to peer.ID, err error) {
+	if from, err = peer.Decode(c.Old); err != nil {
+		return "", "", fmt.Errorf("%w: old peer ID: %v", ErrBadContinuity, err)
+	}
+	if to, err = peer.Decode(c.New); err != nil {
+		return "", "", fmt.Errorf("%w: new peer ID: %v", ErrBadContinuity, err)
+	}
+	if from == to {
+		return "", "", fmt.Errorf("%w: a peer can't succeed itself", ErrBadContinuity)
+	}
+	for _, signed := range []struct {
+		id  peer.ID
+		key []byte
+		sig []byte
+	}{{from, c.OldKey, c.OldSignature}, {to, c.NewKey, c.NewSignature}} {
+		pub, err := crypto.UnmarshalPublicKey(signed.key)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: key of %s: %v", ErrBadContinuity, signed.id, err)
+		}
+		if !signed.id.MatchesPublicKey(pub) {
+			return "", "", fmt.Errorf("%w: key doesn't match %s", ErrBadContinuity, signed.id)
+		}
+		if ok, err := pub.Verify(c.payload(), signed.sig); err != nil || !ok {
+			return "", "", fmt.Errorf("%w: bad signature by %s", ErrBadContinuity, signed.id)
+		}
+	}
+	return from, to, nil
+}
+
+func (c *Continuity) payload() []byte {
+	return fmt.Appendf(nil, "%s%s\n%s\n%s", continuityDomain, c.Old, c.New, c.Rotated.UTC().Format(time.RFC3339))
+}
+
+// Successions keeps the verified continuity records a node knows of, its
+// own and those of other peers, in a JSON file like the pin set.
+type Successions struct {
+	path string
+
+	mu   sync.Mutex
+	next map[peer.ID]Continuity
+}
+
+// OpenSuccessions loads the records at path. A missing file is an empty
+// set; an empty path keeps them in memory only.
+func OpenSuccessions(path string) (*Successions, error) {
+	s := &Successions{path: path, next: make(map[peer.ID]Continuity)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add verifies c and keeps it, reporting whether it was new. A record for
+// a peer that has another successor already is refused with
+// ErrConflictingSuccession: the first one seen stands.
+func (s *Successions) Add(c Continuity) (bool, error) {
+	from, to, err := c.Verify()
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another process, like dfs identity rotate, may have added records
+	if err := s.load(); err != nil {
+		return false, err
+	}
+	if prev, ok := s.next[from]; ok {
+		if prev.New == c.New {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %s is succeeded by %s", ErrConflictingSuccession, from, prev.New)
+	}
+	if s.successor(to) == from {
+		return false, fmt.Errorf("%w: %s would succeed itself", ErrBadContinuity, from)
+	}
+
+	c.Recorded = time.Now().UTC()
+	s.next[from] = c
+	if err := s.save(); err != nil {
+		delete(s.next, from)
+		return false, err
+	}
+	return true, nil
+}
+
+// Drop forgets the record naming the successor of old, returning it.
+func (s *Successions) Drop(old peer.ID) (Continuity, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return Continuity{}, false, err
+	}
+	c, ok := s.next[old]
+	if !ok {
+		return Continuity{}, false, nil
+	}
+	delete(s.next, old)
+	if err := s.save(); err != nil {
+		s.next[old] = c
+		return Continuity{}, false, err
+	}
+	return c, true, nil
+}
+
+// Get returns the record naming the successor of old.
+func (s *Successions) Get(old peer.ID) (Continuity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.next[old]
+	return c, ok
+}
+
+// Successor returns the latest peer ID id was rotated to, id itself when
+// it never was.
+func (s *Successions) Successor(id peer.ID) peer.ID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.successor(id)
+}
+
+func (s *Successions) successor(id peer.ID) peer.ID {
+	// Add refuses cycles, but a file edited by hand may have one
+	for range len(s.next) {
+		c, ok := s.next[id]
+		if !ok {
+			break
+		}
+		id, _ = peer.Decode(c.New)
+	}
+	return id
+}
+
+// Predecessors returns the peer IDs that were rotated to id, latest first.
+func (s *Successions) Predecessors(id peer.ID) []peer.ID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := make(map[peer.ID]peer.ID, len(s.next))
+	for old, c := range s.next {
+		next, _ := peer.Decode(c.New)
+		prev[next] = old
+	}
+	var ids []peer.ID
+	for range len(prev) {
+		old, ok := prev[id]
+		if !ok {
+			break
+		}
+		ids = append(ids, old)
+		id = old
+	}
+	return ids
+}
+
+// PublicKey returns the key of a peer that rotated its identity.
+func (s *Successions) PublicKey(id peer.ID) (crypto.PubKey, error) {
+	s.mu.Lock()
+	c, ok := s.next[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("network: %s never rotated its identity", id)
+	}
+	// Checked against id when added
+	return crypto.UnmarshalPublicKey(c.OldKey)
+}
+
+// List returns the records, oldest rotation first.
+func (s *Successions) List() []Continuity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+func (s *Successions) sorted() []Continuity {
+	list := make([]Continuity, 0, len(s.next))
+	for _, c := range s.next {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Rotated.Equal(list[j].Rotated) {
+			return list[i].Rotated.Before(list[j].Rotated)
+		}
+		return list[i].Old < list[j].Old
+	})
+	return list
+}
+
+// load merges the records in the file into s, skipping any that don't
+// verify. Callers hold s.mu or own s.
+func (s *Successions) load() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []Continuity
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	for _, c := range list {
+		from, _, err := c.Verify()
+		if err != nil {
+			continue
+		}
+		if _, ok := s.next[from]; !ok {
+			s.next[from] = c
+		}
+	}
+	return nil
+}
+
+// save writes the records atomically. Callers hold s.mu.
+func (s *Successions) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(s.path, data, 0600)
+}
+
+// OnSuccession registers fn to be called with every succession the node
+// knows of, oldest first, and then with each one it learns of later.
+func (n *P2PNetworking) OnSuccession(fn func(old, to peer.ID)) {
+	n.successionMu.Lock()
+	defer n.successionMu.Unlock()
+
+	for _, c := range n.Successions.List() {
+		// Verified when added
+		old, _ := peer.Decode(c.Old)
+		to, _ := peer.Decode(c.New)
+		fn(old, to)
+	}
+	n.onSuccession = append(n.onSuccession, fn)
+}
+
+// OnSuccessionUndone registers fn to be called when a succession the
+// node had applied is undone, because the old key was revoked before the
+// node learned of it.
+func (n *P2PNetworking) OnSuccessionUndone(fn func(old, to peer.ID)) {
+	n.successionMu.Lock()
+	defer n.successionMu.Unlock()
+
+	n.onSuccessionUndone = append(n.onSuccessionUndone, fn)
+}
+
+// followSuccessions applies the continuity records peers announce and
+// repeats the node's own, every successionInterval.
+func (n *P2PNetworking) followSuccessions() error {
+	n.OnSuccession(n.Gater.Succeed)
+	n.OnSuccessionUndone(n.Gater.Unsucceed)
+
+	err := IdentityRotatedTopic.Subscribe(n.ctx, n.bus, func(_ peer.ID, c Continuity) {
+		// Anyone may relay a record, the signatures are what counts
+		n.learnSuccession(c)
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(successionInterval)
+		defer ticker.Stop()
+		for {
+			n.announceSuccessions()
+			select {
+			case <-n.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// learnSuccession keeps and applies a continuity record a peer announced.
+// A revoked key hands over to nothing new, whenever the record says it
+// rotated: the signer picks that time, so a record backdated with a
+// stolen key would otherwise pass.
+func (n *P2PNetworking) learnSuccession(c Continuity) {
+	old, to, err := c.Verify()
+	if err != nil {
+		n.logger.Debug("Ignoring continuity record", zap.String("peer", c.Old), zap.Error(err))
+		return
+	}
+
+	// Held over the revocation check to the callbacks, so a revocation
+	// learned meanwhile undoes the succession after it is applied
+	n.successionMu.Lock()
+	defer n.successionMu.Unlock()
+
+	if n.Revocations.Revoked(old) {
+		n.logger.Debug("Ignoring continuity record of revoked key", zap.String("peer", c.Old))
+		return
+	}
+	added, err := n.Successions.Add(c)
+	if err != nil {
+		n.logger.Debug("Ignoring continuity record", zap.String("peer", c.Old), zap.Error(err))
+		return
+	}
+	if !added {
+		return
+	}
+	n.logger.Info("Peer rotated its identity", zap.String("from", c.Old), zap.String("to", c.New))
+
+	for _, fn := range n.onSuccession {
+		fn(old, to)
+	}
+
+	// A denied peer doesn't get back in by rotating
+	if !n.Gater.Allows(to, nil) && n.host != nil {
+		n.host.Network().ClosePeer(to)
+	}
+}
+
+// announceSuccessions publishes the records leading to this node's
+// identity.
+func (n *P2PNetworking) announceSuccessions() {
+	self := n.host.ID()
+	for _, c := range n.Successions.List() {
+		old, _ := peer.Decode(c.Old)
+		if n.Successions.Successor(old) != self {
+			continue
+		}
+		c.Recorded = time.Time{}
+		if err := IdentityRotatedTopic.Publish(n.ctx, n.bus, c); err != nil && n.ctx.Err() == nil {
+			n.logger.Debug("Failed to announce continuity record", zap.Error(err))
+		}
+	}
+}
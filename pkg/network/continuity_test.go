@@ -0,0 +1,274 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+func nodeKey(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, id
+}
+
+func mustContinuity(t *testing.T, old, to crypto.PrivKey) Continuity {
+	t.Helper()
+	c, err := NewContinuity(old, to, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *c
+}
+
+func TestContinuityVerify(t *testing.T) {
+	oldKey, oldID := nodeKey(t)
+	newKey, newID := nodeKey(t)
+	otherKey, otherID := nodeKey(t)
+
+	c := mustContinuity(t, oldKey, newKey)
+	from, to, err := c.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != oldID || to != newID {
+		t.Errorf("Verify = %s, %s, want %s, %s", from, to, oldID, newID)
+	}
+
+	// Pointing the old key's record at another peer
+	forged := c
+	forged.New = otherID.String()
+	if _, _, err := forged.Verify(); !errors.Is(err, ErrBadContinuity) {
+		t.Errorf("Verify of a redirected record = %v, want %v", err, ErrBadContinuity)
+	}
+	// Claiming a peer's succession without its key
+	forged = mustContinuity(t, otherKey, newKey)
+	forged.Old = oldID.String()
+	if _, _, err := forged.Verify(); !errors.Is(err, ErrBadContinuity) {
+		t.Errorf("Verify without the old key = %v, want %v", err, ErrBadContinuity)
+	}
+	// The new key must countersign
+	forged = c
+	forged.NewSignature = c.OldSignature
+	if _, _, err := forged.Verify(); !errors.Is(err, ErrBadContinuity) {
+		t.Errorf("Verify without the new key = %v, want %v", err, ErrBadContinuity)
+	}
+}
+
+func TestSuccessionsChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "successions.json")
+	s, err := OpenSuccessions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, aID := nodeKey(t)
+	b, bID := nodeKey(t)
+	c, cID := nodeKey(t)
+	d, _ := nodeKey(t)
+
+	for _, rec := range []Continuity{mustContinuity(t, a, b), mustContinuity(t, b, c)} {
+		if added, err := s.Add(rec); err != nil || !added {
+			t.Fatalf("Add = %v, %v", added, err)
+		}
+	}
+	if added, err := s.Add(mustContinuity(t, a, b)); err != nil || added {
+		t.Errorf("Add of a known record = %v, %v, want false, nil", added, err)
+	}
+	if _, err := s.Add(mustContinuity(t, a, d)); !errors.Is(err, ErrConflictingSuccession) {
+		t.Errorf("Add of a second successor = %v, want %v", err, ErrConflictingSuccession)
+	}
+	if _, err := s.Add(mustContinuity(t, c, a)); !errors.Is(err, ErrBadContinuity) {
+		t.Errorf("Add of a cycle = %v, want %v", err, ErrBadContinuity)
+	}
+
+	reopened, err := OpenSuccessions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Successions{s, reopened} {
+		if got := s.Successor(aID); got != cID {
+			t.Errorf("Successor(a) = %s, want %s", got, cID)
+		}
+		if got := s.Successor(cID); got != cID {
+			t.Errorf("Successor(c) = %s, want itself", got)
+		}
+		if got, want := s.Predecessors(cID), []peer.ID{bID, aID}; !slices.Equal(got, want) {
+			t.Errorf("Predecessors(c) = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestGaterSucceed(t *testing.T) {
+	_, allowed := nodeKey(t)
+	_, denied := nodeKey(t)
+	_, allowedNext := nodeKey(t)
+	_, deniedNext := nodeKey(t)
+	g, err := NewGater([]string{allowed.String(), denied.String()}, []string{denied.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Allows(allowedNext, nil) {
+		t.Fatal("peer allowed before succeeding an allowed one")
+	}
+
+	g.Succeed(allowed, allowedNext)
+	g.Succeed(denied, deniedNext)
+	if !g.Allows(allowedNext, nil) {
+		t.Error("successor of an allowed peer not allowed")
+	}
+	if g.Allows(deniedNext, nil) {
+		t.Error("successor of a denied peer allowed")
+	}
+}
+
+// TestNameCarriedOver rotates the node key under a published name: the
+// node's new name points where the old one did, and the old name resolves
+// through it.
+func TestNameCarriedOver(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, oldID := nodeKey(t)
+	newKey, newID := nodeKey(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hash, err := multihash.Sum([]byte("published"), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := cid.NewCidV1(cid.Raw, hash)
+	before := NewNameSystem(NameSystemOpts{Self: oldKey, Dir: dir, Logger: zap.NewNop()})
+	if _, err := before.Publish(ctx, SelfKey, target); err != nil {
+		t.Fatal(err)
+	}
+
+	successions, err := OpenSuccessions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without the record the old name's record is no use to the new key
+	after := NewNameSystem(NameSystemOpts{Self: newKey, Dir: dir, Successions: successions, Logger: zap.NewNop()})
+	if err := after.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := after.Resolve(ctx, ipns.NameFromPeer(newID).String()); !errors.Is(err, ErrNameNotFound) {
+		t.Fatalf("Resolve of the new name before the rotation is known = %v, want %v", err, ErrNameNotFound)
+	}
+
+	if _, err := successions.Add(mustContinuity(t, oldKey, newKey)); err != nil {
+		t.Fatal(err)
+	}
+	after = NewNameSystem(NameSystemOpts{Self: newKey, Dir: dir, Successions: successions, Logger: zap.NewNop()})
+	if err := after.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []peer.ID{newID, oldID} {
+		got, err := after.Resolve(ctx, ipns.NameFromPeer(id).String())
+		if err != nil {
+			t.Fatalf("Resolve(%s): %v", id, err)
+		}
+		if !got.Equals(target) {
+			t.Errorf("Resolve(%s) = %s, want %s", id, got, target)
+		}
+	}
+}
+
+// TestSuccessionAnnounced rotates a node's key and checks that a peer
+// learns of it from the event bus and denies the successor of a peer it
+// denied.
+func TestSuccessionAnnounced(t *testing.T) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	oldKey, oldID := nodeKey(t)
+	nets := make([]*P2PNetworking, 2)
+	gaters := make([]*Gater, 2)
+	for i := range nets {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gaters[i], err = NewGater(nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		successions, err := OpenSuccessions("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// Node 0 is what became of the old peer
+			rec := mustContinuity(t, oldKey, h.Peerstore().PrivKey(h.ID()))
+			if _, err := successions.Add(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nets[i] = NewP2PNetworking(P2PNetworkingOpts{
+			CustomHost:  h,
+			Gater:       gaters[i],
+			Successions: successions,
+			Logger:      zap.NewNop(),
+		})
+		if err := nets[i].Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nets[i].Close() })
+	}
+	if err := gaters[1].Deny(oldID.String()); err != nil {
+		t.Fatal(err)
+	}
+	learned := make(chan peer.ID, 1)
+	nets[1].OnSuccession(func(old, to peer.ID) {
+		if old == oldID {
+			learned <- to
+		}
+	})
+
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Announce until the bus has formed its mesh
+	successor := nets[0].Host().ID()
+	deadline := time.After(10 * time.Second)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		nets[0].announceSuccessions()
+		select {
+		case to := <-learned:
+			if to != successor {
+				t.Fatalf("peer learned %s succeeds %s, want %s", to, oldID, successor)
+			}
+			if nets[1].Successions.Successor(oldID) != successor {
+				t.Error("peer didn't keep the record")
+			}
+			if gaters[1].Allows(successor, nil) {
+				t.Error("successor of a denied peer allowed")
+			}
+			return
+		case <-deadline:
+			t.Fatal("peer never learned of the rotation")
+		case <-ticker.C:
+		}
+	}
+}
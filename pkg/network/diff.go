@@ -0,0 +1,243 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/zap"
+)
+
+// DiffProtocol sends the blocks of a file or directory tree that the
+// requester doesn't have, like rsync: a peer holding an older version of
+// a file lists the chunks it has and gets only the rest of the new one in
+// a single exchange, instead of wanting every block of it.
+//
+// A request is the length prefixed root CID, then the uvarint number of
+// CIDs the requester has, each length prefixed. For every block of the
+// tree the requester lacks, root first, the response is diffBlock and the
+// length prefixed CID and block, and then diffDone. A responder without
+// the whole tree answers diffFailed and a length prefixed message instead.
+const DiffProtocol protocol.ID = "/dfs/diff/1.0.0"
+
+const (
+	diffBlock  byte = 0
+	diffDone   byte = 1
+	diffFailed byte = 2
+
+	// maxDiffHave bounds the blocks a requester may say it has, enough
+	// for a version of a file of a few hundred thousand chunks.
+	maxDiffHave = 1 << 18
+
+	diffTimeout = 30 * time.Minute
+)
+
+// ErrDiffUnavailable is returned when the peer doesn't have the tree a
+// difference was asked for, or doesn't serve DiffProtocol.
+var ErrDiffUnavailable = errors.New("network: difference not available")
+
+// DAGLister lists the blocks of the file or directory tree rooted at c,
+// root first, from the local store only.
+type DAGLister func(ctx context.Context, c cid.Cid) ([]cid.Cid, error)
+
+// DiffStats counts what a difference transferred.
+type DiffStats struct {
+	Blocks int
+	Bytes  int64
+}
+
+// HandleDiff serves DiffProtocol, listing trees with list.
+func (n *P2PNetworking) HandleDiff(list DAGLister) {
+	n.setPooledHandler(DiffProtocol, func(raw network.Stream) {
+		s := n.Faults.WrapStream(raw)
+		defer s.Close()
+		s.SetDeadline(time.Now().Add(diffTimeout))
+
+		root, have, err := readDiffRequest(bufio.NewReader(s))
+		if err != nil {
+			s.Reset()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(n.ctx, diffTimeout)
+		defer cancel()
+
+		w := bufio.NewWriter(s)
+		if err := n.sendDiff(ctx, s, w, list, root, have); err != nil {
+			n.logger.Debug("Failed to send difference",
+				zap.String("cid", root.String()),
+				zap.String("peer", s.Conn().RemotePeer().String()),
+				zap.Error(err),
+			)
+			s.Reset()
+			return
+		}
+		w.Flush()
+	})
+}
+
+func readDiffRequest(r *bufio.Reader) (cid.Cid, map[cid.Cid]bool, error) {
+	root, err := readCID(r)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	have, err := readHave(r)
+	return root, have, err
+}
+
+// readHave reads the blocks a requester has, see writeHave.
+func readHave(r *bufio.Reader) (map[cid.Cid]bool, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > maxDiffHave {
+		return nil, fmt.Errorf("network: peer has more than %d blocks", maxDiffHave)
+	}
+	have := make(map[cid.Cid]bool, count)
+	for range count {
+		c, err := readCID(r)
+		if err != nil {
+			return nil, err
+		}
+		have[c] = true
+	}
+	return have, nil
+}
+
+// sendDiff writes the blocks of root that aren't in have.
+func (n *P2PNetworking) sendDiff(ctx context.Context, s network.Stream, w *bufio.Writer, list DAGLister, root cid.Cid, have map[cid.Cid]bool) error {
+	blocks, err := list(ctx, root)
+	if err == nil && n.Blocks == nil {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		w.WriteByte(diffFailed)
+		writePrefixed(w, []byte(err.Error()))
+		return w.Flush()
+	}
+
+	for _, c := range blocks {
+		if have[c] {
+			continue
+		}
+		have[c] = true
+
+		data, err := n.Blocks.Get(ctx, c)
+		if err != nil {
+			return fmt.Errorf("block %s: %w", c, err)
+		}
+		if err := n.shaper.sent(ctx, s.Conn().RemotePeer(), len(data)); err != nil {
+			return err
+		}
+		w.WriteByte(diffBlock)
+		writePrefixed(w, c.Bytes())
+		writePrefixed(w, data)
+	}
+	return w.WriteByte(diffDone)
+}
+
+// FetchDiff asks p for the blocks of the tree rooted at c that aren't in
+// have, and passes each to put once verified. A peer that doesn't have
+// the tree fails it with ErrDiffUnavailable.
+func (n *P2PNetworking) FetchDiff(ctx context.Context, p peer.ID, c cid.Cid, have []cid.Cid, put func(ctx context.Context, c cid.Cid, data []byte) error) (DiffStats, error) {
+	return n.fetchBlocks(ctx, p, DiffProtocol, put, func(w *bufio.Writer) {
+		writePrefixed(w, c.Bytes())
+		writeHave(w, have)
+	})
+}
+
+// writeHave writes the blocks a requester has, as many of them as a
+// responder accepts.
+func writeHave(w *bufio.Writer, have []cid.Cid) {
+	if len(have) > maxDiffHave {
+		have = have[:maxDiffHave]
+	}
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(have)))])
+	for _, h := range have {
+		writePrefixed(w, h.Bytes())
+	}
+}
+
+// fetchBlocks sends the request request writes over a stream of proto to
+// p, and passes every block of the response to put once verified, as
+// DiffProtocol answers.
+func (n *P2PNetworking) fetchBlocks(ctx context.Context, p peer.ID, proto protocol.ID, put func(ctx context.Context, c cid.Cid, data []byte) error, request func(w *bufio.Writer)) (DiffStats, error) {
+	var stats DiffStats
+	raw, err := n.host.NewStream(ctx, p, proto)
+	if err != nil {
+		return stats, fmt.Errorf("%w: %v", ErrDiffUnavailable, err)
+	}
+	s := n.Faults.WrapStream(raw)
+	defer s.Close()
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	} else {
+		s.SetDeadline(time.Now().Add(diffTimeout))
+	}
+
+	w := bufio.NewWriter(s)
+	request(w)
+	if err := w.Flush(); err != nil {
+		s.Reset()
+		return stats, err
+	}
+	s.CloseWrite()
+
+	r := bufio.NewReader(s)
+	for {
+		status, err := r.ReadByte()
+		if err != nil {
+			s.Reset()
+			return stats, err
+		}
+		switch status {
+		case diffDone:
+			return stats, nil
+		case diffFailed:
+			msg, _ := readPrefixed(r, 4<<10)
+			return stats, fmt.Errorf("%w: peer %s: %s", ErrDiffUnavailable, p, msg)
+		case diffBlock:
+		default:
+			s.Reset()
+			return stats, fmt.Errorf("network: unexpected difference status %d", status)
+		}
+
+		b, err := readCID(r)
+		if err != nil {
+			s.Reset()
+			return stats, err
+		}
+		data, err := readPrefixed(r, storage.MaxBlockSize)
+		if err != nil {
+			s.Reset()
+			return stats, err
+		}
+		if err := n.shaper.received(ctx, p, len(data)); err != nil {
+			s.Reset()
+			return stats, err
+		}
+		if err := storage.Verify(b, data); err != nil {
+			s.Reset()
+			return stats, fmt.Errorf("block %s from %s: %w", b, p, err)
+		}
+		if err := put(ctx, b, data); err != nil {
+			s.Reset()
+			return stats, err
+		}
+		stats.Blocks++
+		stats.Bytes += int64(len(data))
+	}
+}
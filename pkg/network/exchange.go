@@ -0,0 +1,161 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/zap"
+)
+
+// BlockProtocol serves single blocks by CID. A request is the CID prefixed
+// with its length as a uvarint; the response is a status byte followed by
+// the length prefixed block. It is still served for nodes that predate
+// WantProtocol, and used to fetch from them.
+const BlockProtocol protocol.ID = "/dfs/block/1.0.0"
+
+const (
+	blockOK       byte = 0
+	blockNotFound byte = 1
+
+	blockStreamTimeout = time.Minute
+)
+
+var ErrBlockNotFound = errors.New("network: peer does not have the block")
+
+// BlockSource is the local store blocks are served and announced from.
+type BlockSource interface {
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+	List(ctx context.Context, fn func(cid.Cid) error) error
+}
+
+func (n *P2PNetworking) handleBlockStream(raw network.Stream) {
+	s := n.Faults.WrapStream(raw)
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(blockStreamTimeout))
+
+	c, err := readCID(bufio.NewReader(s))
+	if err != nil {
+		s.Reset()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, blockStreamTimeout)
+	defer cancel()
+
+	data, err := n.Blocks.Get(ctx, c)
+	if err == nil && n.Faults.DropBlock() {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			n.logger.Warn("Failed to serve block", zap.String("cid", c.String()), zap.Error(err))
+		}
+		s.Write([]byte{blockNotFound})
+		return
+	}
+
+	if err := n.shaper.sent(ctx, s.Conn().RemotePeer(), len(data)); err != nil {
+		s.Reset()
+		return
+	}
+
+	buf := make([]byte, 1+binary.MaxVarintLen64, 1+binary.MaxVarintLen64+len(data))
+	buf[0] = blockOK
+	buf = buf[:1+binary.PutUvarint(buf[1:], uint64(len(data)))]
+	if _, err := s.Write(append(buf, data...)); err != nil {
+		s.Reset()
+	}
+}
+
+// FetchBlock asks p for the block c and verifies what it sends back.
+func (n *P2PNetworking) FetchBlock(ctx context.Context, p peer.AddrInfo, c cid.Cid) ([]byte, error) {
+	if err := n.dial(ctx, p); err != nil {
+		return nil, err
+	}
+
+	raw, err := n.host.NewStream(ctx, p.ID, BlockProtocol)
+	if err != nil {
+		return nil, err
+	}
+	s := n.Faults.WrapStream(raw)
+	defer s.Close()
+	// Deadlines only cover timeouts; a cancelled fetch must stop too.
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	} else {
+		s.SetDeadline(time.Now().Add(blockStreamTimeout))
+	}
+
+	key := c.Bytes()
+	req := binary.AppendUvarint(nil, uint64(len(key)))
+	if _, err := s.Write(append(req, key...)); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	s.CloseWrite()
+
+	r := bufio.NewReader(s)
+	status, err := r.ReadByte()
+	if err != nil {
+		s.Reset()
+		return nil, err
+	}
+	if status == blockNotFound {
+		return nil, ErrBlockNotFound
+	}
+	if status != blockOK {
+		s.Reset()
+		return nil, fmt.Errorf("network: unexpected block status %d", status)
+	}
+
+	data, err := readPrefixed(r, storage.MaxBlockSize)
+	if err != nil {
+		s.Reset()
+		return nil, err
+	}
+	if err := n.shaper.received(ctx, p.ID, len(data)); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	if err := storage.Verify(c, data); err != nil {
+		return nil, fmt.Errorf("block %s from %s: %w", c, p.ID, err)
+	}
+	return data, nil
+}
+
+func readCID(r *bufio.Reader) (cid.Cid, error) {
+	key, err := readPrefixed(r, 256)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.Cast(key)
+}
+
+func readPrefixed(r *bufio.Reader, limit uint64) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > limit {
+		return nil, fmt.Errorf("network: message of %d bytes exceeds limit of %d", size, limit)
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,95 @@
+//go:build faults
+
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/faults"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"go.uber.org/zap"
+)
+
+// startFaultyPair runs a node serving block with server's faults and a
+// node asking for it with client's.
+func startFaultyPair(t *testing.T, block storage.Block, server, client faults.Config) (serving peer.AddrInfo, asking *P2PNetworking) {
+	t.Helper()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	blocks := storage.NewMemBlockstore()
+	if err := blocks.Put(context.Background(), block.CID, block.Data); err != nil {
+		t.Fatal(err)
+	}
+	nets := make([]*P2PNetworking, 2)
+	for i, cfg := range []faults.Config{server, client} {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets[i] = NewP2PNetworking(P2PNetworkingOpts{
+			Blocks:     blocks,
+			CustomHost: h,
+			Faults:     faults.New(cfg),
+			Logger:     zap.NewNop(),
+		})
+		if err := nets[i].Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nets[i].Close() })
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	h := nets[0].Host()
+	return peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}, nets[1]
+}
+
+// A server dropping every block answers as if it had none, on both the
+// want-list and the single block protocol.
+func TestFaultsDropBlock(t *testing.T) {
+	block, err := storage.NewRawBlock([]byte("dropped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := startFaultyPair(t, block, faults.Config{DropBlockRate: 1}, faults.Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, _, err := client.WantBlock(ctx, server, block.CID); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("WantBlock = %v, want ErrBlockNotFound", err)
+	}
+	if _, err := client.FetchBlock(ctx, server, block.CID); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("FetchBlock = %v, want ErrBlockNotFound", err)
+	}
+}
+
+// Corrupted or killed transfers fail instead of handing out bad data.
+func TestFaultsCorruptKill(t *testing.T) {
+	block, err := storage.NewRawBlock(make([]byte, 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, cfg := range map[string]faults.Config{
+		"corrupt": {CorruptRate: 1, Seed: 1},
+		"kill":    {KillRate: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, client := startFaultyPair(t, block, faults.Config{}, cfg)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if data, err := client.FetchBlock(ctx, server, block.CID); err == nil {
+				t.Errorf("FetchBlock returned %d bytes, want an error", len(data))
+			}
+			if data, _, err := client.WantBlock(ctx, server, block.CID); err == nil {
+				t.Errorf("WantBlock returned %d bytes, want an error", len(data))
+			}
+		})
+	}
+}
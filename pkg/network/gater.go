@@ -0,0 +1,252 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var ErrNotBlocked = errors.New("network: no such deny rule")
+
+// Gater decides which peers the node talks to. Rules are peer IDs or IP
+// addresses and CIDR ranges. A connection matching a deny rule is refused.
+// When there are allow rules, a connection must also match one of them.
+//
+// Connections are checked as early as possible: dials and accepted
+// connections by address, then again by peer once it is authenticated.
+type Gater struct {
+	mu         sync.RWMutex
+	allowPeers map[peer.ID]bool
+	denyPeers  map[peer.ID]bool
+	allowNets  []*net.IPNet
+	denyNets   []*net.IPNet
+	// revoked peers are refused whatever the rules, see Revocation.
+	revoked map[peer.ID]bool
+	// inherited maps peers allowed by Succeed to the peer they succeeded.
+	inherited map[peer.ID]peer.ID
+}
+
+// NewGater parses allow and deny rules.
+func NewGater(allow, deny []string) (*Gater, error) {
+	g := &Gater{
+		allowPeers: make(map[peer.ID]bool),
+		denyPeers:  make(map[peer.ID]bool),
+		revoked:    make(map[peer.ID]bool),
+		inherited:  make(map[peer.ID]peer.ID),
+	}
+	for _, rule := range allow {
+		id, ipnet, err := ParseGateRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if ipnet != nil {
+			g.allowNets = append(g.allowNets, ipnet)
+		} else {
+			g.allowPeers[id] = true
+		}
+	}
+	for _, rule := range deny {
+		if err := g.Deny(rule); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// ParseGateRule parses a peer ID, IP address or CIDR range. Exactly one of
+// the results is set when err is nil; an address is returned as a single
+// host range.
+func ParseGateRule(rule string) (peer.ID, *net.IPNet, error) {
+	if _, ipnet, err := net.ParseCIDR(rule); err == nil {
+		return "", ipnet, nil
+	}
+	if ip := net.ParseIP(rule); ip != nil {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return "", &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	id, err := peer.Decode(rule)
+	if err != nil {
+		return "", nil, fmt.Errorf("%q is not a peer ID, IP address or CIDR range", rule)
+	}
+	return id, nil, nil
+}
+
+// Deny adds a deny rule.
+func (g *Gater) Deny(rule string) error {
+	id, ipnet, err := ParseGateRule(rule)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ipnet != nil {
+		if !slices.ContainsFunc(g.denyNets, func(n *net.IPNet) bool { return n.String() == ipnet.String() }) {
+			g.denyNets = append(g.denyNets, ipnet)
+		}
+	} else {
+		g.denyPeers[id] = true
+	}
+	return nil
+}
+
+// Undeny removes a deny rule, returning ErrNotBlocked if there is none.
+func (g *Gater) Undeny(rule string) error {
+	id, ipnet, err := ParseGateRule(rule)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ipnet != nil {
+		i := slices.IndexFunc(g.denyNets, func(n *net.IPNet) bool { return n.String() == ipnet.String() })
+		if i < 0 {
+			return ErrNotBlocked
+		}
+		g.denyNets = slices.Delete(g.denyNets, i, i+1)
+		return nil
+	}
+	if !g.denyPeers[id] {
+		return ErrNotBlocked
+	}
+	delete(g.denyPeers, id)
+	return nil
+}
+
+// Revoke refuses id for good: unlike a deny rule it can't be removed, and
+// allow rules naming id no longer let it in.
+func (g *Gater) Revoke(id peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.revoked[id] = true
+}
+
+// Succeed gives to, the peer ID old was rotated to, the rules naming
+// old: an allowed peer stays allowed and a denied one stays denied.
+func (g *Gater) Succeed(old, to peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.allowPeers[old] && !g.allowPeers[to] {
+		g.allowPeers[to] = true
+		g.inherited[to] = old
+	}
+	if g.denyPeers[old] {
+		g.denyPeers[to] = true
+	}
+}
+
+// Unsucceed takes back the allow rule Succeed gave to, and those its own
+// successors inherited from it. Deny rules stay.
+func (g *Gater) Unsucceed(old, to peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.inherited[to] != old {
+		return
+	}
+	undo := []peer.ID{to}
+	for len(undo) > 0 {
+		id := undo[0]
+		undo = undo[1:]
+		delete(g.allowPeers, id)
+		delete(g.inherited, id)
+		for next, from := range g.inherited {
+			if from == id {
+				undo = append(undo, next)
+			}
+		}
+	}
+}
+
+// Rules returns the current allow and deny rules.
+func (g *Gater) Rules() (allow, deny []string) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for id := range g.allowPeers {
+		allow = append(allow, id.String())
+	}
+	for _, n := range g.allowNets {
+		allow = append(allow, n.String())
+	}
+	for id := range g.denyPeers {
+		deny = append(deny, id.String())
+	}
+	for _, n := range g.denyNets {
+		deny = append(deny, n.String())
+	}
+	slices.Sort(allow)
+	slices.Sort(deny)
+	return allow, deny
+}
+
+// Allows reports whether a connection to id at addr is allowed. Either may
+// be unknown (empty or nil); an unknown part only fails a rule once it is
+// known.
+func (g *Gater) Allows(id peer.ID, addr multiaddr.Multiaddr) bool {
+	var ip net.IP
+	if addr != nil {
+		ip, _ = manet.ToIP(addr)
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if id != "" && (g.denyPeers[id] || g.revoked[id]) {
+		return false
+	}
+	if ip != nil && containsIP(g.denyNets, ip) {
+		return false
+	}
+
+	if len(g.allowPeers) == 0 && len(g.allowNets) == 0 {
+		return true
+	}
+	if id != "" && g.allowPeers[id] {
+		return true
+	}
+	if ip != nil && containsIP(g.allowNets, ip) {
+		return true
+	}
+	return (id == "" && len(g.allowPeers) > 0) || (ip == nil && len(g.allowNets) > 0)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	return slices.ContainsFunc(nets, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+func (g *Gater) InterceptPeerDial(p peer.ID) bool {
+	return g.Allows(p, nil)
+}
+
+func (g *Gater) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	return g.Allows(p, addr)
+}
+
+func (g *Gater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return g.Allows("", addrs.RemoteMultiaddr())
+}
+
+func (g *Gater) InterceptSecured(_ network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	return g.Allows(p, addrs.RemoteMultiaddr())
+}
+
+func (g *Gater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,96 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DefaultIdentityPath is where the node key is kept unless configured
+// otherwise.
+func DefaultIdentityPath() string {
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(homeDir, ".local", "share", "dfs", "identity.key")
+	}
+	return "identity.key"
+}
+
+// LoadOrCreateIdentity reads the node key at path, generating and storing a
+// new Ed25519 key on first run.
+func LoadOrCreateIdentity(path string) (crypto.PrivKey, error) {
+	priv, err := LoadIdentity(path)
+	if err == nil {
+		return priv, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	priv, _, err = crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeIdentity(path, priv); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+func LoadIdentity(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity key %s: %w", path, err)
+	}
+	return priv, nil
+}
+
+// RotateIdentity replaces the key at path with a freshly generated one. The
+// previous key is kept next to it with a timestamp suffix and its path is
+// returned, empty if there was no previous key.
+func RotateIdentity(path string) (crypto.PrivKey, string, error) {
+	var backup string
+	if _, err := os.Stat(path); err == nil {
+		backup = fmt.Sprintf("%s.%d.old", path, time.Now().Unix())
+		if err := os.Rename(path, backup); err != nil {
+			return nil, "", err
+		}
+	}
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		return nil, backup, err
+	}
+	if err := writeIdentity(path, priv); err != nil {
+		return nil, backup, err
+	}
+	return priv, backup, nil
+}
+
+func IdentityPeerID(priv crypto.PrivKey) (peer.ID, error) {
+	return peer.IDFromPrivateKey(priv)
+}
+
+func writeIdentity(path string, priv crypto.PrivKey) error {
+	data, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return fsutil.AtomicWrite(path, data, 0600)
+}
@@ -0,0 +1,246 @@
+package network
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultInventoryInterval is how often a node advertises its block
+	// inventory when nothing changed meanwhile.
+	DefaultInventoryInterval = 5 * time.Minute
+
+	// inventoryDelay gathers the blocks added in a burst, like the chunks
+	// of a file, into one advertisement.
+	inventoryDelay = 30 * time.Second
+	// inventoryLifetime is how long an advertisement is relied on without
+	// being repeated.
+	inventoryLifetime = 3 * DefaultInventoryInterval
+	// inventoryRebuild is how often the filter is built anew when no block
+	// was provided meanwhile, to drop blocks that were removed.
+	inventoryRebuild = time.Hour
+
+	// inventoryBitsPerBlock gives filters a false positive rate of about
+	// one percent with inventoryHashes hash functions.
+	inventoryBitsPerBlock = 10
+	inventoryHashes       = 7
+	minInventoryBytes     = 128
+	// maxInventoryBytes keeps an advertisement well below the pubsub
+	// message limit. Larger stores get more false positives.
+	maxInventoryBytes  = 512 << 10
+	maxInventoryHashes = 16
+)
+
+// Bloom is a Bloom filter of CIDs. Has never misses a CID that was added,
+// but may report one that wasn't.
+type Bloom struct {
+	bits   []byte
+	hashes int
+}
+
+// NewBloom returns a filter sized for blocks CIDs.
+func NewBloom(blocks int) *Bloom {
+	size := min(max(blocks*inventoryBitsPerBlock/8, minInventoryBytes), maxInventoryBytes)
+	// Fewer hashes suit a filter holding more than it was sized for
+	hashes := int(math.Round(float64(size*8) / float64(max(blocks, 1)) * math.Ln2))
+	return &Bloom{bits: make([]byte, size), hashes: min(max(hashes, 1), inventoryHashes)}
+}
+
+// Add adds c to the filter.
+func (b *Bloom) Add(c cid.Cid) {
+	for bit := range b.positions(c) {
+		b.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Has reports whether c may have been added.
+func (b *Bloom) Has(c cid.Cid) bool {
+	for bit := range b.positions(c) {
+		if b.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// positions yields the bits c sets, derived from two halves of a hash of
+// c (double hashing).
+func (b *Bloom) positions(c cid.Cid) func(func(uint64) bool) {
+	sum := sha256.Sum256(c.Bytes())
+	h1 := binary.LittleEndian.Uint64(sum[:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:16]) | 1
+	m := uint64(len(b.bits)) * 8
+	return func(yield func(uint64) bool) {
+		for i := range uint64(b.hashes) {
+			if !yield((h1 + i*h2) % m) {
+				return
+			}
+		}
+	}
+}
+
+// Inventory advertises the blocks a node stores, in a Bloom filter, on
+// InventoryTopic. Peers ask nodes whose filter has a block for it before
+// looking it up in the DHT, and don't ask those whose filter rules it out.
+type Inventory struct {
+	Blocks int    `json:"blocks"`
+	Hashes int    `json:"hashes"`
+	Filter []byte `json:"filter"`
+}
+
+// Bloom returns the filter the inventory carries.
+func (inv Inventory) Bloom() (*Bloom, error) {
+	if len(inv.Filter) == 0 || len(inv.Filter) > maxInventoryBytes {
+		return nil, fmt.Errorf("network: inventory filter of %d bytes", len(inv.Filter))
+	}
+	if inv.Hashes < 1 || inv.Hashes > maxInventoryHashes {
+		return nil, fmt.Errorf("network: inventory filter with %d hashes", inv.Hashes)
+	}
+	return &Bloom{bits: inv.Filter, hashes: inv.Hashes}, nil
+}
+
+// inventory is the latest advertisement of a peer.
+type inventory struct {
+	filter   *Bloom
+	received time.Time
+}
+
+// followInventories keeps the inventories peers advertise and, with
+// Blocks, advertises this node's every InventoryInterval and shortly after
+// blocks are provided.
+func (n *P2PNetworking) followInventories() error {
+	n.OnDisconnect(func(id peer.ID) {
+		n.inventoryMu.Lock()
+		delete(n.inventories, id)
+		n.inventoryMu.Unlock()
+	})
+
+	err := InventoryTopic.Subscribe(n.ctx, n.bus, func(from peer.ID, inv Inventory) {
+		filter, err := inv.Bloom()
+		if err != nil {
+			n.logger.Debug("Ignoring inventory", zap.String("peer", from.String()), zap.Error(err))
+			return
+		}
+		n.inventoryMu.Lock()
+		n.inventories[from] = inventory{filter: filter, received: time.Now()}
+		n.inventoryMu.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+
+	if n.Blocks != nil && n.InventoryInterval >= 0 {
+		go n.advertiseInventory()
+	}
+	return nil
+}
+
+func (n *P2PNetworking) advertiseInventory() {
+	// Peers forget advertisements that aren't repeated in time
+	interval := n.InventoryInterval
+	if interval == 0 || interval > DefaultInventoryInterval {
+		interval = DefaultInventoryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		inv   Inventory
+		built time.Time
+		stale = true
+	)
+	for {
+		if stale || time.Since(built) >= inventoryRebuild {
+			var err error
+			if inv, err = n.buildInventory(n.ctx); err != nil {
+				if n.ctx.Err() == nil {
+					n.logger.Warn("Failed to list blocks to advertise", zap.Error(err))
+				}
+			} else {
+				built, stale = time.Now(), false
+			}
+		}
+		if !built.IsZero() {
+			if err := InventoryTopic.Publish(n.ctx, n.bus, inv); err != nil && n.ctx.Err() == nil {
+				n.logger.Debug("Failed to advertise inventory", zap.Error(err))
+			}
+		}
+
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.inventoryWake:
+			stale = true
+			select {
+			case <-n.ctx.Done():
+				return
+			case <-time.After(min(inventoryDelay, interval)):
+			}
+		}
+	}
+}
+
+// buildInventory puts every stored block in a filter.
+func (n *P2PNetworking) buildInventory(ctx context.Context) (Inventory, error) {
+	var blocks []cid.Cid
+	err := n.Blocks.List(ctx, func(c cid.Cid) error {
+		blocks = append(blocks, c)
+		return nil
+	})
+	if err != nil {
+		return Inventory{}, err
+	}
+
+	filter := NewBloom(len(blocks))
+	for _, c := range blocks {
+		filter.Add(c)
+	}
+	return Inventory{Blocks: len(blocks), Hashes: filter.hashes, Filter: filter.bits}, nil
+}
+
+// inventoryChanged has the inventory advertised again soon.
+func (n *P2PNetworking) inventoryChanged() {
+	select {
+	case n.inventoryWake <- struct{}{}:
+	default:
+	}
+}
+
+// Advertised returns the connected peers whose inventory has c. Each may
+// still lack it, as filters have false positives and advertisements lag.
+func (n *P2PNetworking) Advertised(c cid.Cid) []peer.AddrInfo {
+	var found []peer.AddrInfo
+	for _, pi := range n.Peers() {
+		if filter := n.inventoryOf(pi.ID); filter != nil && filter.Has(c) {
+			found = append(found, pi)
+		}
+	}
+	return found
+}
+
+// Lacks reports whether p's inventory rules out c. Without a recent
+// inventory from p it doesn't.
+func (n *P2PNetworking) Lacks(p peer.ID, c cid.Cid) bool {
+	filter := n.inventoryOf(p)
+	return filter != nil && !filter.Has(c)
+}
+
+func (n *P2PNetworking) inventoryOf(p peer.ID) *Bloom {
+	n.inventoryMu.RLock()
+	defer n.inventoryMu.RUnlock()
+
+	inv, ok := n.inventories[p]
+	if !ok || time.Since(inv.received) > inventoryLifetime {
+		return nil
+	}
+	return inv.filter
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func testCID(t *testing.T, i int) cid.Cid {
+	t.Helper()
+	h, err := multihash.Sum(fmt.Appendf(nil, "block %d", i), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func TestBloom(t *testing.T) {
+	for _, blocks := range []int{1, 1000, 100000} {
+		b := NewBloom(blocks)
+		for i := range blocks {
+			b.Add(testCID(t, i))
+		}
+		for i := range blocks {
+			if !b.Has(testCID(t, i)) {
+				t.Fatalf("%d blocks: filter misses block %d", blocks, i)
+			}
+		}
+
+		// Carried over the wire and read back
+		inv, err := Inventory{Blocks: blocks, Hashes: b.hashes, Filter: b.bits}.Bloom()
+		if err != nil {
+			t.Fatal(err)
+		}
+		const probes = 10000
+		positives := 0
+		for i := range probes {
+			if inv.Has(testCID(t, blocks+i)) {
+				positives++
+			}
+		}
+		if rate := float64(positives) / probes; rate > 0.02 {
+			t.Errorf("%d blocks: false positive rate %.3f", blocks, rate)
+		}
+	}
+}
+
+func TestBloomCapped(t *testing.T) {
+	b := NewBloom(10 * maxInventoryBytes)
+	if len(b.bits) != maxInventoryBytes {
+		t.Fatalf("filter of %d bytes, want the cap of %d", len(b.bits), maxInventoryBytes)
+	}
+	if b.hashes < 1 || b.hashes >= inventoryHashes {
+		t.Errorf("overfull filter uses %d hashes", b.hashes)
+	}
+}
+
+func TestInventoryRejected(t *testing.T) {
+	for _, inv := range []Inventory{
+		{Hashes: 7},
+		{Hashes: 7, Filter: make([]byte, maxInventoryBytes+1)},
+		{Hashes: 0, Filter: make([]byte, 128)},
+		{Hashes: maxInventoryHashes + 1, Filter: make([]byte, 128)},
+	} {
+		if _, err := inv.Bloom(); err == nil {
+			t.Errorf("inventory with %d hashes and %d bytes accepted", inv.Hashes, len(inv.Filter))
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"go.uber.org/zap"
+)
+
+const (
+	// SelfKey names the node's own key, which needs no key file.
+	SelfKey = "self"
+
+	// DefaultNameLifetime is how long a published record stays valid.
+	DefaultNameLifetime = ipns.DefaultRecordLifetime
+	// DefaultNameRepublishInterval re-signs and re-announces records well
+	// within their lifetime, and within the 36 hours DHT nodes keep them.
+	DefaultNameRepublishInterval = 4 * time.Hour
+
+	nameRecordTTL         = ipns.DefaultRecordTTL
+	initialRepublishDelay = time.Minute
+	namePublishTimeout    = time.Minute
+	nameRecordExt         = ".record"
+	nameKeyExt            = ".key"
+)
+
+var (
+	// ErrNameNotFound is returned when no valid record exists for a name.
+	ErrNameNotFound = errors.New("network: name not found")
+	// ErrInvalidName is returned for strings that aren't a name.
+	ErrInvalidName = errors.New("network: invalid name")
+	// ErrInvalidKeyName is returned for key names that can't be a file name.
+	ErrInvalidKeyName = errors.New("network: invalid key name")
+	// ErrNameNotAnnounced is returned by Publish when the record was kept
+	// but couldn't be stored in the DHT. Republishing retries it.
+	ErrNameNotAnnounced = errors.New("network: name not announced")
+)
+
+var keyNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// NameSystem publishes mutable names that point to content. A name is
+// derived from a key: the node's own, or one of any number of named keys
+// the node generates on first use. Each publication is a signed IPNS
+// record holding the target hash and a sequence number, stored in the DHT
+// under the name, so anyone can resolve it and nobody but the key holder
+// can change it.
+//
+// The node's latest records are kept on disk and republished
+// periodically, since the DHT forgets records and records expire. Without
+// a DHT, names still publish and resolve on this node only.
+type NameSystem struct {
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	records map[string]*nameRecord
+
+	NameSystemOpts
+}
+
+// NameKeys supplies the keys names are published under, generating them
+// on first use. A keystore.Keystore is one.
+type NameKeys interface {
+	NameKey(name string) (crypto.PrivKey, error)
+}
+
+type NameSystemOpts struct {
+	// Router stores and finds records. Optional.
+	Router routing.ValueStore
+	// Self signs records published under SelfKey.
+	Self crypto.PrivKey
+	// Dir holds named keys and the latest record of every key. Without it
+	// records are kept in memory and only SelfKey can publish.
+	Dir string
+	// Keys supplies named keys in place of the key files in Dir. Optional.
+	Keys NameKeys
+	// Lifetime is how long records stay valid.
+	Lifetime time.Duration
+	// RepublishInterval is how often records are re-signed and announced.
+	// It is kept below Lifetime.
+	RepublishInterval time.Duration
+	// Successions makes the names of rotated identities resolve through
+	// their successors, and carries the node's own name over from its
+	// previous keys. Optional.
+	Successions *Successions
+	// Revocations stops the names of revoked keys resolving, except
+	// through their successors. Optional.
+	Revocations *Revocations
+	Logger      *zap.Logger
+}
+
+// nameRecord is the latest record published under a key.
+type nameRecord struct {
+	key    crypto.PrivKey
+	name   ipns.Name
+	record *ipns.Record
+	seq    uint64
+	value  path.Path
+}
+
+func NewNameSystem(opts NameSystemOpts) *NameSystem {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = DefaultNameLifetime
+	}
+	if opts.RepublishInterval <= 0 {
+		opts.RepublishInterval = DefaultNameRepublishInterval
+	}
+	if opts.RepublishInterval >= opts.Lifetime {
+		opts.Logger.Warn("Name republish interval exceeds record lifetime, names would lapse",
+			zap.Duration("interval", opts.RepublishInterval),
+			zap.Duration("lifetime", opts.Lifetime),
+		)
+	}
+
+	return &NameSystem{
+		logger:         opts.Logger,
+		records:        make(map[string]*nameRecord),
+		NameSystemOpts: opts,
+	}
+}
+
+// Start loads the records published before a restart and republishes
+// them until ctx is done.
+func (ns *NameSystem) Start(ctx context.Context) error {
+	if err := ns.load(); err != nil {
+		return err
+	}
+	go ns.run(ctx)
+	return nil
+}
+
+// Publish points the name of key at c, creating the key when it is a new
+// named key, and returns the name. The record is kept and republished
+// even when announcing it in the DHT fails, which is reported with
+// ErrNameNotAnnounced.
+func (ns *NameSystem) Publish(ctx context.Context, key string, c cid.Cid) (ipns.Name, error) {
+	if key == "" {
+		key = SelfKey
+	}
+	sk, err := ns.key(key)
+	if err != nil {
+		return ipns.Name{}, err
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return ipns.Name{}, err
+	}
+
+	r := &nameRecord{key: sk, name: ipns.NameFromPeer(id), value: path.FromCid(c)}
+	ns.mu.Lock()
+	if prev := ns.records[key]; prev != nil {
+		r.seq = prev.seq + 1
+	}
+	err = ns.sign(key, r)
+	if err == nil {
+		ns.records[key] = r
+	}
+	ns.mu.Unlock()
+	if err != nil {
+		return ipns.Name{}, err
+	}
+
+	ns.logger.Info("Published name",
+		zap.String("name", r.name.String()),
+		zap.String("cid", c.String()),
+		zap.Uint64("seq", r.seq),
+	)
+	return r.name, ns.put(ctx, r)
+}
+
+// Resolve returns the hash the name currently points to. The node's own
+// names are answered from the latest record, others are looked up in the
+// DHT. The name of a node that rotated its identity is answered by its
+// successor's name, or by its own when the successor's isn't found.
+func (ns *NameSystem) Resolve(ctx context.Context, s string) (cid.Cid, error) {
+	name, err := ipns.NameFromString(s)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w %q: %v", ErrInvalidName, s, err)
+	}
+
+	if ns.Successions != nil {
+		if next := ns.Successions.Successor(name.Peer()); next != name.Peer() {
+			c, err := ns.resolve(ctx, ipns.NameFromPeer(next))
+			if err == nil {
+				return c, nil
+			}
+			ns.logger.Debug("Successor's name not resolved", zap.String("peer", next.String()), zap.Error(err))
+		}
+	}
+	if ns.Revocations.Revoked(name.Peer()) {
+		return cid.Undef, fmt.Errorf("%w: %s: %w", ErrNameNotFound, name, ErrRevoked)
+	}
+	return ns.resolve(ctx, name)
+}
+
+func (ns *NameSystem) resolve(ctx context.Context, name ipns.Name) (cid.Cid, error) {
+	ns.mu.Lock()
+	var value path.Path
+	for _, r := range ns.records {
+		if r.name.Equal(name) {
+			value = r.value
+			break
+		}
+	}
+	ns.mu.Unlock()
+
+	if value == nil {
+		if ns.Router == nil {
+			return cid.Undef, fmt.Errorf("%w: %s is not published by this node and the DHT is off", ErrNameNotFound, name)
+		}
+		data, err := ns.Router.GetValue(ctx, string(name.RoutingKey()))
+		if errors.Is(err, routing.ErrNotFound) {
+			return cid.Undef, fmt.Errorf("%w: %s", ErrNameNotFound, name)
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+		rec, err := ipns.UnmarshalRecord(data)
+		if err != nil {
+			return cid.Undef, err
+		}
+		// Checks the signature and that the record hasn't expired
+		if err := ipns.ValidateWithName(rec, name); err != nil {
+			return cid.Undef, fmt.Errorf("%w: %s: %v", ErrNameNotFound, name, err)
+		}
+		if value, err = rec.Value(); err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	// Names published elsewhere may point at other names or paths
+	immutable, err := path.NewImmutablePath(value)
+	if err != nil || len(immutable.Segments()) > 2 {
+		return cid.Undef, fmt.Errorf("name %s points to %s, not a hash", name, value)
+	}
+	return immutable.RootCid(), nil
+}
+
+// Name returns the name of key without publishing anything.
+func (ns *NameSystem) Name(key string) (ipns.Name, error) {
+	sk, err := ns.key(key)
+	if err != nil {
+		return ipns.Name{}, err
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return ipns.Name{}, err
+	}
+	return ipns.NameFromPeer(id), nil
+}
+
+// key returns the private key called name, creating named keys on first
+// use.
+func (ns *NameSystem) key(name string) (crypto.PrivKey, error) {
+	if name == "" || name == SelfKey {
+		if ns.Self == nil {
+			return nil, errors.New("network: node key is not available")
+		}
+		return ns.Self, nil
+	}
+	if !keyNameRe.MatchString(name) {
+		return nil, fmt.Errorf("%w %q: use up to 64 letters, digits, '-' and '_'", ErrInvalidKeyName, name)
+	}
+	if ns.Keys != nil {
+		return ns.Keys.NameKey(name)
+	}
+	if ns.Dir == "" {
+		return nil, fmt.Errorf("network: no key directory for named key %q", name)
+	}
+	return LoadOrCreateIdentity(filepath.Join(ns.Dir, name+nameKeyExt))
+}
+
+// sign replaces r.record with a fresh record for r.value and r.seq, and
+// stores it. Callers hold ns.mu.
+func (ns *NameSystem) sign(key string, r *nameRecord) error {
+	eol := time.Now().Add(ns.Lifetime)
+	rec, err := ipns.NewRecord(r.key, r.value, r.seq, eol, nameRecordTTL)
+	if err != nil {
+		return err
+	}
+	r.record = rec
+
+	if ns.Dir == "" {
+		return nil
+	}
+	data, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ns.Dir, 0700); err != nil {
+		return err
+	}
+	recordPath := filepath.Join(ns.Dir, key+nameRecordExt)
+	return fsutil.AtomicWrite(recordPath, data, 0600)
+}
+
+// put announces r in the DHT.
+func (ns *NameSystem) put(ctx context.Context, r *nameRecord) error {
+	if ns.Router == nil {
+		return nil
+	}
+	data, err := ipns.MarshalRecord(r.record)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, namePublishTimeout)
+	defer cancel()
+	if err := ns.Router.PutValue(ctx, string(r.name.RoutingKey()), data); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrNameNotAnnounced, r.name, err)
+	}
+	return nil
+}
+
+// load reads the records stored in Dir.
+func (ns *NameSystem) load() error {
+	if ns.Dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(ns.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	for _, e := range entries {
+		key, ok := strings.CutSuffix(e.Name(), nameRecordExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		r, err := ns.loadRecord(key)
+		if err != nil {
+			// One bad record shouldn't take down the other names
+			ns.logger.Warn("Skipping name record", zap.String("path", e.Name()), zap.Error(err))
+			continue
+		}
+		ns.records[key] = r
+	}
+	return nil
+}
+
+// carryOver checks the self record rec against the node's previous keys.
+// Signed by one, it is what the name pointed to before the rotation.
+func (ns *NameSystem) carryOver(rec *ipns.Record) bool {
+	if ns.Successions == nil || ns.Self == nil {
+		return false
+	}
+	self, err := peer.IDFromPrivateKey(ns.Self)
+	if err != nil {
+		return false
+	}
+	for _, id := range ns.Successions.Predecessors(self) {
+		pub, err := ns.Successions.PublicKey(id)
+		if err != nil {
+			continue
+		}
+		if err := ipns.Validate(rec, pub); err == nil || errors.Is(err, ipns.ErrExpiredRecord) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ns *NameSystem) loadRecord(key string) (*nameRecord, error) {
+	sk, err := ns.key(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(ns.Dir, key+nameRecordExt))
+	if err != nil {
+		return nil, err
+	}
+	rec, err := ipns.UnmarshalRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	// An expired record still tells the sequence and value to republish
+	carried := false
+	if err := ipns.Validate(rec, sk.GetPublic()); err != nil && !errors.Is(err, ipns.ErrExpiredRecord) {
+		if carried = key == SelfKey && ns.carryOver(rec); !carried {
+			return nil, err
+		}
+	}
+
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := rec.Sequence()
+	if err != nil {
+		return nil, err
+	}
+	value, err := rec.Value()
+	if err != nil {
+		return nil, err
+	}
+	r := &nameRecord{key: sk, name: ipns.NameFromPeer(id), record: rec, seq: seq, value: value}
+	if carried {
+		// Signed again with the new key, under the new name
+		if err := ns.sign(key, r); err != nil {
+			return nil, err
+		}
+		ns.logger.Info("Carried the node's name over from its previous identity",
+			zap.String("name", r.name.String()),
+			zap.String("path", value.String()),
+		)
+	}
+	return r, nil
+}
+
+func (ns *NameSystem) run(ctx context.Context) {
+	// Give the DHT time to find peers before the first round
+	timer := time.NewTimer(initialRepublishDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		ns.republish(ctx)
+		timer.Reset(ns.RepublishInterval)
+	}
+}
+
+// republish re-signs every record with a new expiry and announces it.
+func (ns *NameSystem) republish(ctx context.Context) {
+	ns.mu.Lock()
+	var due []*nameRecord
+	for key, r := range ns.records {
+		next := *r
+		next.seq++
+		if err := ns.sign(key, &next); err != nil {
+			ns.logger.Warn("Failed to renew name record", zap.String("name", r.name.String()), zap.Error(err))
+			continue
+		}
+		ns.records[key] = &next
+		due = append(due, &next)
+	}
+	ns.mu.Unlock()
+
+	for _, r := range due {
+		if err := ns.put(ctx, r); err != nil {
+			ns.logger.Warn("Failed to republish name", zap.String("name", r.name.String()), zap.Error(err))
+			continue
+		}
+		ns.logger.Debug("Republished name", zap.String("name", r.name.String()), zap.Uint64("seq", r.seq))
+	}
+}
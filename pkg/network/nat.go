@@ -0,0 +1,168 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.uber.org/zap"
+)
+
+// NATStatus describes how the node is reachable from outside its network.
+type NATStatus struct {
+	// PortMap is set when the node asks the gateway for port mappings
+	// over UPnP or NAT-PMP, Gateway once a gateway answered.
+	PortMap  bool
+	Gateway  bool
+	Mappings []PortMapping
+	// Static are the addresses announced for a mapping set up by hand.
+	Static []multiaddr.Multiaddr
+
+	// Reachability is what peers report about dialing the node back.
+	Reachability network.Reachability
+	// Reachable, Unreachable and Unknown sort the node's public addresses
+	// by whether peers managed to dial them. Only public addresses are
+	// tested, and testing takes a few minutes after start.
+	Reachable   []multiaddr.Multiaddr
+	Unreachable []multiaddr.Multiaddr
+	Unknown     []multiaddr.Multiaddr
+}
+
+// PortMapping is a port the gateway forwards to a listen address.
+type PortMapping struct {
+	Internal multiaddr.Multiaddr
+	// External is nil while the gateway hasn't granted the mapping.
+	External multiaddr.Multiaddr
+}
+
+// natState is what the node learns about its reachability from events.
+type natState struct {
+	mu           sync.Mutex
+	reachability network.Reachability
+	reachable    []multiaddr.Multiaddr
+	unreachable  []multiaddr.Multiaddr
+	unknown      []multiaddr.Multiaddr
+}
+
+// ParseExternalAddr parses a static port mapping, an "ip:port" address.
+func ParseExternalAddr(s string) (netip.AddrPort, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%q is not an IP address", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return netip.AddrPort{}, fmt.Errorf("invalid port %q", port)
+	}
+	return netip.AddrPortFrom(ip, uint16(p)), nil
+}
+
+// staticAddrs returns the addresses to announce for ExternalAddr: TCP,
+// and QUIC when the node listens for it.
+func (n *P2PNetworking) staticAddrs() ([]multiaddr.Multiaddr, error) {
+	if n.ExternalAddr == "" {
+		return nil, nil
+	}
+	ap, err := ParseExternalAddr(n.ExternalAddr)
+	if err != nil {
+		return nil, fmt.Errorf("external address %q: %w", n.ExternalAddr, err)
+	}
+
+	tcp, err := manet.FromNetAddr(net.TCPAddrFromAddrPort(ap))
+	if err != nil {
+		return nil, err
+	}
+	addrs := []multiaddr.Multiaddr{tcp}
+	if n.QUIC && n.SwarmKeyPath == "" {
+		udp, err := manet.FromNetAddr(net.UDPAddrFromAddrPort(ap))
+		if err != nil {
+			return nil, err
+		}
+		quic, err := multiaddr.NewMultiaddr("/quic-v1")
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, udp.Encapsulate(quic))
+	}
+	return addrs, nil
+}
+
+// newNATManager is handed to libp2p, keeping the manager for NATStatus.
+func (n *P2PNetworking) newNATManager(net network.Network) bhost.NATManager {
+	n.natmgr = bhost.NewNATManager(net)
+	return n.natmgr
+}
+
+// watchReachability keeps n.nat up to date until the node closes.
+func (n *P2PNetworking) watchReachability() error {
+	sub, err := n.host.EventBus().Subscribe([]any{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtHostReachableAddrsChanged),
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-n.ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				n.nat.mu.Lock()
+				switch e := e.(type) {
+				case event.EvtLocalReachabilityChanged:
+					n.nat.reachability = e.Reachability
+					n.logger.Info("Reachability changed", zap.String("reachability", e.Reachability.String()))
+				case event.EvtHostReachableAddrsChanged:
+					n.nat.reachable = e.Reachable
+					n.nat.unreachable = e.Unreachable
+					n.nat.unknown = e.Unknown
+				}
+				n.nat.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+// NATStatus reports port mappings and what peers found about dialing the
+// node.
+func (n *P2PNetworking) NATStatus() NATStatus {
+	st := NATStatus{PortMap: n.natmgr != nil}
+	if n.natmgr != nil {
+		st.Gateway = n.natmgr.HasDiscoveredNAT()
+		for _, addr := range n.host.Network().ListenAddresses() {
+			// Relayed addresses have no port to map
+			if !manet.IsThinWaist(addr) {
+				continue
+			}
+			st.Mappings = append(st.Mappings, PortMapping{Internal: addr, External: n.natmgr.GetMapping(addr)})
+		}
+	}
+	// Checked when the host was built
+	st.Static, _ = n.staticAddrs()
+
+	n.nat.mu.Lock()
+	defer n.nat.mu.Unlock()
+	st.Reachability = n.nat.reachability
+	st.Reachable = n.nat.reachable
+	st.Unreachable = n.nat.unreachable
+	st.Unknown = n.nat.unknown
+	return st
+}
@@ -2,31 +2,80 @@ package network
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/faults"
+	"github.com/Noah-Wilderom/dfs/pkg/health"
+	"github.com/Noah-Wilderom/dfs/pkg/pressure"
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
+	lpmetrics "github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/routing"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 	"github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap"
 )
 
-type P2PNetworking struct {
-	host   host.Host
-	dht    *dht.IpfsDHT
-	logger *zap.Logger
+const (
+	// bootstrapTimeout bounds connecting to each bootstrap peer.
+	bootstrapTimeout = 30 * time.Second
+
+	// DefaultConnLow and DefaultConnHigh are the connection manager's
+	// watermarks: above high, connections are trimmed back to low.
+	DefaultConnLow  = 100
+	DefaultConnHigh = 400
+)
+
+// errNoProviders counts a DHT lookup that found nobody as failed.
+var errNoProviders = errors.New("network: no providers found")
 
-	peersMu sync.RWMutex
-	peers   map[peer.ID]peer.AddrInfo
+type P2PNetworking struct {
+	// ctx lives from Start until Close and bounds the background work:
+	// the DHT, announcements, the event bus and serving peers.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	host    host.Host
+	dht     *dht.IpfsDHT
+	routing *ContentRouting
+	names   *NameSystem
+	bus     *EventBus
+	natmgr  bhost.NATManager
+	nat     natState
+	onion   *onionService
+	wants   *wantManager
+	shaper  *shaper
+	traffic *lpmetrics.BandwidthCounter
+	logger  *zap.Logger
+
+	peersMu      sync.RWMutex
+	peers        map[peer.ID]peer.AddrInfo
+	onDisconnect []func(peer.ID)
+
+	successionMu sync.Mutex
+	onSuccession []func(old, to peer.ID)
+	// onSuccessionUndone is called when a revocation undoes a succession.
+	onSuccessionUndone []func(old, to peer.ID)
+
+	inventoryMu   sync.RWMutex
+	inventories   map[peer.ID]inventory
+	inventoryWake chan struct{}
 
 	P2PNetworkingOpts
 }
@@ -34,77 +83,486 @@ type P2PNetworking struct {
 type P2PNetworkingOpts struct {
 	Port           int
 	EnableDHT      bool
+	DHTMode        dht.ModeOpt
 	BootstrapPeers []string
 	Logger         *zap.Logger
+
+	// IdentityPath is the file holding the node key. It is created on first
+	// start. Defaults to DefaultIdentityPath().
+	IdentityPath string
+	// Identity is the node key, taken from a keystore. When set,
+	// IdentityPath is left alone.
+	Identity crypto.PrivKey
+	// EphemeralIdentity runs the node with a throwaway key and leaves
+	// IdentityPath untouched.
+	EphemeralIdentity bool
+
+	// Events records peer activity for offline replay. Optional.
+	Events *eventlog.Recorder
+
+	// Blocks is served to peers over WantProtocol and BlockProtocol and
+	// announced in the DHT. Without it the node serves nothing.
+	Blocks BlockSource
+	// ReprovideInterval overrides DefaultReprovideInterval.
+	ReprovideInterval time.Duration
+	// InventoryInterval is how often Blocks is advertised to the cluster,
+	// at most DefaultInventoryInterval, the default. Negative stops the
+	// node advertising it.
+	InventoryInterval time.Duration
+	// NamesDir keeps named keys and published name records. Without it
+	// names are published with the node key only and forgotten on exit.
+	NamesDir string
+	// NameKeys supplies named keys instead of NamesDir. Optional.
+	NameKeys NameKeys
+	// NameLifetime and NameRepublishInterval override DefaultNameLifetime
+	// and DefaultNameRepublishInterval.
+	NameLifetime          time.Duration
+	NameRepublishInterval time.Duration
+	// Faults injects failures into block transfers. Optional.
+	Faults *faults.Injector
+
+	// AutoRelay reserves slots on circuit relays when the node finds it
+	// isn't publicly reachable, and advertises the relayed addresses.
+	// Relays are taken from StaticRelays, or else from connected peers
+	// that offer relaying.
+	AutoRelay    bool
+	StaticRelays []string
+	// HolePunching upgrades relayed connections to direct ones (DCUtR).
+	HolePunching bool
+	// RelayServer offers circuit relaying to other peers. Only enable it on
+	// publicly reachable nodes; it assumes the node is one.
+	RelayServer bool
+
+	// DisablePortMap stops asking the gateway for port mappings over UPnP
+	// or NAT-PMP.
+	DisablePortMap bool
+	// ExternalAddr is a mapping set up by hand on the gateway, an
+	// "ip:port" address forwarded to Port. It is announced as is, and
+	// replaces asking the gateway for mappings.
+	ExternalAddr string
+
+	// Proxy is a SOCKS5 proxy, as "host:port", that every outgoing
+	// connection goes through, such as Tor's. The node then speaks TCP
+	// only and listens on loopback only, and leaves out port mapping,
+	// AutoNAT, relays and hole punching, which would reveal its address.
+	// See ProxyLimitations.
+	Proxy string
+	// OnionControl is Tor's control port. With Proxy set, the node asks Tor
+	// for an onion service forwarding to Port and announces only that.
+	// OnionControlPassword is needed unless Tor uses cookie or no
+	// authentication.
+	OnionControl         string
+	OnionControlPassword string
+	// OnionKeyPath keeps the onion service key so the address survives
+	// restarts. Without it, every start gets a new onion address.
+	OnionKeyPath string
+
+	// SwarmKeyPath, when set, makes the node part of a private network: it
+	// only talks to peers holding the same pre-shared key.
+	SwarmKeyPath string
+
+	// DisableIPv4 and DisableIPv6 stop listening on that address family.
+	// By default the node listens on both.
+	DisableIPv4 bool
+	DisableIPv6 bool
+	// QUIC listens for QUIC connections on Port too. Outgoing QUIC works
+	// regardless. Ignored on a private network.
+	QUIC bool
+	// Browser listens for WebRTC-direct on Port, and for WebTransport too
+	// when QUIC is on, so browsers can connect to the node as peers. The
+	// addresses carry certificate hashes browsers check the node against.
+	// Ignored on a private network.
+	Browser bool
+
+	// ConnLow and ConnHigh override DefaultConnLow and DefaultConnHigh.
+	ConnLow  int
+	ConnHigh int
+
+	// Bandwidth caps the blocks sent to and fetched from peers.
+	Bandwidth Bandwidth
+	// StreamPools override DefaultStreamPools for some protocols.
+	StreamPools map[protocol.ID]StreamPool
+	// Pressure scales the stream pools down when resources run low.
+	// Optional.
+	Pressure *pressure.Monitor
+	// Health counts dial, DHT lookup and block fetch failures. Optional.
+	Health *health.Tracker
+
+	// Gater keeps peers out by peer ID or address. Defaults to one without
+	// rules, which Block can add to at runtime.
+	Gater *Gater
+	// Successions keeps the continuity records of rotated identities,
+	// this node's and its peers'. Defaults to keeping them in memory.
+	Successions *Successions
+
+	// Revocations keeps the revoked keys this node knows of. Defaults to
+	// keeping them in memory.
+	Revocations *Revocations
+
+	// CustomHost, when set, is used instead of building a libp2p host. The
+	// simulation harness uses this to run nodes on an in-memory network.
+	CustomHost host.Host
 }
 
 func NewP2PNetworking(opts P2PNetworkingOpts) *P2PNetworking {
 	if opts.Port == 0 {
 		opts.Port = 9000
 	}
+	if opts.IdentityPath == "" {
+		opts.IdentityPath = DefaultIdentityPath()
+	}
+	if opts.ConnLow == 0 {
+		opts.ConnLow = DefaultConnLow
+	}
+	if opts.ConnHigh == 0 {
+		opts.ConnHigh = DefaultConnHigh
+	}
+	if opts.Gater == nil {
+		opts.Gater, _ = NewGater(nil, nil)
+	}
+	if opts.Successions == nil {
+		opts.Successions, _ = OpenSuccessions("")
+	}
+	if opts.Revocations == nil {
+		opts.Revocations, _ = OpenRevocations("")
+	}
 
 	return &P2PNetworking{
 		logger:            opts.Logger,
+		shaper:            newShaper(opts.Bandwidth),
+		traffic:           lpmetrics.NewBandwidthCounter(),
 		peers:             make(map[peer.ID]peer.AddrInfo),
+		inventories:       make(map[peer.ID]inventory),
+		inventoryWake:     make(chan struct{}, 1),
 		P2PNetworkingOpts: opts,
 	}
 }
 
+// Start brings the node online. ctx only bounds starting up, including
+// connecting to bootstrap peers; everything started keeps running until
+// Close.
 func (n *P2PNetworking) Start(ctx context.Context) error {
-	// Generate identity
-	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	n.ctx, n.cancel = context.WithCancel(context.WithoutCancel(ctx))
+
+	// A failed start leaves nothing running, least of all the listeners a
+	// retry in the same process would find taken
+	ok := false
+	defer func() {
+		if !ok {
+			n.stop(n.CustomHost == nil)
+			n.host, n.dht, n.onion = nil, nil, nil
+		}
+	}()
+
+	h := n.CustomHost
+	if h == nil {
+		var err error
+		if h, err = n.newHost(); err != nil {
+			return err
+		}
+	} else if n.EnableDHT {
+		d, err := dht.New(n.ctx, h, dht.Mode(n.DHTMode))
+		if err != nil {
+			return err
+		}
+		n.dht = d
+	}
+	n.host = h
+
+	// Setup notifications
+	h.Network().Notify(&networkNotifiee{net: n, logger: n.logger})
+	if err := n.watchReachability(); err != nil {
+		return err
+	}
+
+	n.wants = newWantManager(n)
+	n.OnDisconnect(n.shaper.forget)
+	go n.trimTraffic()
+	if n.Blocks != nil {
+		n.setPooledHandler(BlockProtocol, n.handleBlockStream)
+		n.setPooledHandler(WantProtocol, n.handleWantStream)
+	}
+
+	bus, err := NewEventBus(n.ctx, h, n.logger)
 	if err != nil {
 		return err
 	}
+	n.bus = bus
+	bus.revoked = n.Revocations.Revoked
+	if err := n.followRevocations(); err != nil {
+		return err
+	}
+	if err := n.followSuccessions(); err != nil {
+		return err
+	}
+	if err := n.followInventories(); err != nil {
+		return err
+	}
+
+	// Announce stored content once there is a DHT to announce it in
+	if n.dht != nil {
+		n.routing = NewContentRouting(ContentRoutingOpts{
+			Router:            n.dht,
+			Blocks:            n.Blocks,
+			Self:              h.ID(),
+			ReprovideInterval: n.ReprovideInterval,
+			Logger:            n.logger,
+		})
+		n.routing.Start(n.ctx)
+	}
+
+	nameOpts := NameSystemOpts{
+		Self:              h.Peerstore().PrivKey(h.ID()),
+		Dir:               n.NamesDir,
+		Keys:              n.NameKeys,
+		Lifetime:          n.NameLifetime,
+		RepublishInterval: n.NameRepublishInterval,
+		Successions:       n.Successions,
+		Revocations:       n.Revocations,
+		Logger:            n.logger,
+	}
+	if n.dht != nil {
+		nameOpts.Router = n.dht
+	}
+	n.names = NewNameSystem(nameOpts)
+	if err := n.names.Start(n.ctx); err != nil {
+		return fmt.Errorf("names: %w", err)
+	}
+
+	if n.onion != nil {
+		go n.watchOnion()
+	}
+
+	n.logger.Info("P2P Node Ready",
+		zap.String("PeerID", h.ID().String()),
+		zap.Strings("Addresses", formatAddrs(h.Addrs())),
+	)
+
+	// Bootstrap DHT if enabled
+	if n.EnableDHT && len(n.BootstrapPeers) > 0 {
+		n.bootstrapDHT(ctx, n.BootstrapPeers)
+	}
+
+	ok = true
+	return nil
+}
+
+// listenAddrs returns the addresses to listen on: TCP, and QUIC and the
+// browser transports when enabled, on the same port for every enabled address family. libp2p
+// announces the wildcards as the addresses of the interfaces behind them,
+// leaving out link-local IPv6 ones.
+func (n *P2PNetworking) listenAddrs() ([]multiaddr.Multiaddr, error) {
+	// Behind a proxy, only Tor connects to the node directly
+	if n.Proxy != "" {
+		addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", n.Port))
+		if err != nil {
+			return nil, err
+		}
+		return []multiaddr.Multiaddr{addr}, nil
+	}
+
+	var hosts []string
+	if !n.DisableIPv4 {
+		hosts = append(hosts, "/ip4/0.0.0.0")
+	}
+	if !n.DisableIPv6 {
+		hosts = append(hosts, "/ip6/::")
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("network: IPv4 and IPv6 are both disabled")
+	}
+
+	formats := []string{"%s/tcp/%d"}
+	// A private network only speaks TCP
+	if n.QUIC && n.SwarmKeyPath == "" {
+		formats = append(formats, "%s/udp/%d/quic-v1")
+	}
+	if n.Browser && n.SwarmKeyPath == "" {
+		formats = append(formats, "%s/udp/%d/webrtc-direct")
+		// WebTransport runs over QUIC
+		if n.QUIC {
+			formats = append(formats, "%s/udp/%d/quic-v1/webtransport")
+		}
+	}
+
+	var addrs []multiaddr.Multiaddr
+	for _, h := range hosts {
+		for _, f := range formats {
+			addr, err := multiaddr.NewMultiaddr(fmt.Sprintf(f, h, n.Port))
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+func (n *P2PNetworking) newHost() (h host.Host, err error) {
+	// The onion service started on the way is stopped with a failed host
+	defer func() {
+		if err != nil && n.onion != nil {
+			n.onion.Close()
+			n.onion = nil
+		}
+	}()
+
+	// Load identity, generated on first run
+	var priv crypto.PrivKey
+	switch {
+	case n.EphemeralIdentity:
+		priv, _, err = crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	case n.Identity != nil:
+		priv = n.Identity
+	default:
+		priv, err = LoadOrCreateIdentity(n.IdentityPath)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Connection manager
-	connManager, err := connmgr.NewConnManager(100, 400, connmgr.WithGracePeriod(time.Minute))
+	connManager, err := connmgr.NewConnManager(n.ConnLow, n.ConnHigh, connmgr.WithGracePeriod(time.Minute))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	listenAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", n.Port))
+	listenAddrs, err := n.listenAddrs()
+	if err != nil {
+		return nil, err
+	}
 
 	// Build options
 	libp2pOpts := []libp2p.Option{
 		libp2p.Identity(priv),
-		libp2p.ListenAddrs(listenAddr),
+		libp2p.ListenAddrs(listenAddrs...),
 		libp2p.Security(libp2ptls.ID, libp2ptls.New),
 		libp2p.Security(noise.ID, noise.New),
-		libp2p.DefaultTransports,
 		libp2p.ConnectionManager(connManager),
-		libp2p.NATPortMap(),
+		libp2p.ConnectionGater(n.Gater),
+		libp2p.BandwidthReporter(n.traffic),
+	}
+	if n.Proxy == "" {
+		// Lets peers tell which of the node's addresses they can dial
+		libp2pOpts = append(libp2pOpts, libp2p.EnableAutoNATv2())
+	}
+
+	// Port mapping, asked from the gateway or set up by hand
+	static, err := n.staticAddrs()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case n.Proxy != "":
+		// Announce the onion address alone, or nothing without one
+		var onion []multiaddr.Multiaddr
+		if n.OnionControl != "" {
+			n.onion, err = startOnion(n.OnionControl, n.OnionControlPassword, n.OnionKeyPath, n.Port)
+			if err != nil {
+				return nil, err
+			}
+			onion = append(onion, n.onion.addr)
+		}
+		libp2pOpts = append(libp2pOpts, libp2p.AddrsFactory(func([]multiaddr.Multiaddr) []multiaddr.Multiaddr {
+			return onion
+		}))
+	case len(static) > 0:
+		libp2pOpts = append(libp2pOpts, libp2p.AddrsFactory(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+			return append(addrs, static...)
+		}))
+	case !n.DisablePortMap:
+		libp2pOpts = append(libp2pOpts, libp2p.NATManager(n.newNATManager))
+	}
+
+	// Private network
+	if n.SwarmKeyPath != "" {
+		psk, err := LoadSwarmKey(n.SwarmKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPrivateBootstrap(n.BootstrapPeers); err != nil {
+			return nil, err
+		}
+		libp2pOpts = append(libp2pOpts, libp2p.PrivateNetwork(psk))
+	}
+	switch {
+	case n.Proxy != "":
+		libp2pOpts = append(libp2pOpts, libp2p.Transport(n.newSOCKSTransport))
+	case n.SwarmKeyPath != "":
+		// QUIC and the browser transports can't be protected by a
+		// pre-shared key, so a private node speaks TCP only.
+		libp2pOpts = append(libp2pOpts, libp2p.Transport(tcp.NewTCPTransport))
+	default:
+		libp2pOpts = append(libp2pOpts, libp2p.DefaultTransports)
+	}
+
+	// NAT traversal, which a node behind a proxy does without
+	if n.AutoRelay && n.Proxy == "" {
+		relays, err := parseAddrInfos(n.StaticRelays)
+		if err != nil {
+			return nil, fmt.Errorf("static relays: %w", err)
+		}
+		if len(relays) > 0 {
+			libp2pOpts = append(libp2pOpts, libp2p.EnableAutoRelayWithStaticRelays(relays))
+		} else {
+			libp2pOpts = append(libp2pOpts, libp2p.EnableAutoRelayWithPeerSource(n.relayCandidates))
+		}
+	}
+	if n.HolePunching && n.Proxy == "" {
+		libp2pOpts = append(libp2pOpts, libp2p.EnableHolePunching())
+	}
+	if n.RelayServer && n.Proxy == "" {
+		// The relay service only runs once the node believes it's public,
+		// and it also helps others find out whether they are.
+		libp2pOpts = append(libp2pOpts,
+			libp2p.EnableRelayService(),
+			libp2p.ForceReachabilityPublic(),
+			libp2p.EnableNATService(),
+		)
 	}
 
 	// Add DHT if enabled
 	if n.EnableDHT {
 		libp2pOpts = append(libp2pOpts, libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			n.dht, err = dht.New(ctx, h)
-			return n.dht, err
+			d, err := dht.New(n.ctx, h, dht.Mode(n.DHTMode))
+			if err != nil {
+				return nil, err
+			}
+			n.dht = d
+			return d, nil
 		}))
 	}
 
 	// Create host
-	h, err := libp2p.New(libp2pOpts...)
-	if err != nil {
-		return err
-	}
-	n.host = h
-
-	// Setup notifications
-	h.Network().Notify(&networkNotifiee{net: n, logger: n.logger})
+	return libp2p.New(libp2pOpts...)
+}
 
-	n.logger.Info("P2P Node Ready",
-		zap.String("PeerID", h.ID().String()),
-		zap.Strings("Addresses", formatAddrs(h.Addrs())),
-	)
+// relayCandidates offers connected peers to AutoRelay, which keeps those
+// that support relaying.
+func (n *P2PNetworking) relayCandidates(ctx context.Context, num int) <-chan peer.AddrInfo {
+	peers := n.Peers()
+	if len(peers) > num {
+		peers = peers[:num]
+	}
 
-	// Bootstrap DHT if enabled
-	if n.EnableDHT && len(n.BootstrapPeers) > 0 {
-		n.bootstrapDHT(ctx, n.BootstrapPeers)
+	ch := make(chan peer.AddrInfo, len(peers))
+	for _, pi := range peers {
+		ch <- pi
 	}
+	close(ch)
+	return ch
+}
 
-	return nil
+func parseAddrInfos(addrs []string) ([]peer.AddrInfo, error) {
+	infos := make([]peer.AddrInfo, 0, len(addrs))
+	for _, s := range addrs {
+		pi, err := peer.AddrInfoFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", s, err)
+		}
+		infos = append(infos, *pi)
+	}
+	return infos, nil
 }
 
 func (n *P2PNetworking) bootstrapDHT(ctx context.Context, bootstrapPeers []string) {
@@ -116,16 +574,25 @@ func (n *P2PNetworking) bootstrapDHT(ctx context.Context, bootstrapPeers []strin
 	n.logger.Info("Bootstrapping DHT...", zap.Int("peers", len(bootstrapPeers)))
 
 	for _, peerStr := range bootstrapPeers {
-		addr, _ := multiaddr.NewMultiaddr(peerStr)
-		pi, _ := peer.AddrInfoFromP2pAddr(addr)
+		pi, err := peer.AddrInfoFromString(peerStr)
+		if err != nil {
+			n.logger.Warn("Invalid bootstrap peer", zap.String("addr", peerStr), zap.Error(err))
+			continue
+		}
 
-		if err := n.host.Connect(ctx, *pi); err == nil {
+		connectCtx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+		err = n.dial(connectCtx, *pi)
+		cancel()
+		if err == nil {
 			n.logger.Info("Connected to bootstrap peer", zap.String("peer", pi.ID.String()))
 		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
 
 	if n.dht != nil {
-		n.dht.Bootstrap(ctx)
+		n.dht.Bootstrap(n.ctx)
 	}
 }
 
@@ -133,11 +600,143 @@ func (n *P2PNetworking) Host() host.Host {
 	return n.host
 }
 
+// Routing returns the DHT content routing, nil when the DHT is off.
+func (n *P2PNetworking) Routing() *ContentRouting {
+	return n.routing
+}
+
+// Names returns the name system.
+func (n *P2PNetworking) Names() *NameSystem {
+	return n.names
+}
+
+// Bus returns the cluster event bus.
+func (n *P2PNetworking) Bus() *EventBus {
+	return n.bus
+}
+
+// Provide announces that this node stores cids. It does nothing without a
+// DHT.
+func (n *P2PNetworking) Provide(cids ...cid.Cid) {
+	if n.routing != nil {
+		n.routing.Provide(cids...)
+	}
+	n.inventoryChanged()
+}
+
+// FindProviders returns peers that may have c. Without a DHT every
+// connected peer is a candidate.
+func (n *P2PNetworking) FindProviders(ctx context.Context, c cid.Cid) ([]peer.AddrInfo, error) {
+	if n.routing == nil {
+		return n.Peers(), nil
+	}
+
+	providers, err := n.routing.FindProviders(ctx, c)
+	lookupErr := err
+	if err == nil && len(providers) == 0 {
+		lookupErr = errNoProviders
+	}
+	n.Health.Record(ctx, health.DHT, lookupErr)
+	return providers, err
+}
+
+// Ping measures the round trip time to p.
+func (n *P2PNetworking) Ping(ctx context.Context, p peer.ID) (time.Duration, error) {
+	res := <-ping.Ping(ctx, n.host, p)
+	return res.RTT, res.Error
+}
+
+// OnDisconnect registers fn to be called when the last connection to a
+// peer closes. fn runs on its own goroutine.
+func (n *P2PNetworking) OnDisconnect(fn func(peer.ID)) {
+	n.peersMu.Lock()
+	n.onDisconnect = append(n.onDisconnect, fn)
+	n.peersMu.Unlock()
+}
+
+// Peers returns the currently connected peers.
+func (n *P2PNetworking) Peers() []peer.AddrInfo {
+	n.peersMu.RLock()
+	defer n.peersMu.RUnlock()
+
+	peers := make([]peer.AddrInfo, 0, len(n.peers))
+	for _, pi := range n.peers {
+		peers = append(peers, pi)
+	}
+	return peers
+}
+
+// Connect dials the peer at addr, which must include its /p2p/ component.
+func (n *P2PNetworking) Connect(ctx context.Context, addr string) (peer.ID, error) {
+	ma, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return "", err
+	}
+	pi, err := peer.AddrInfoFromP2pAddr(ma)
+	if err != nil {
+		return "", err
+	}
+
+	if err := n.dial(ctx, *pi); err != nil {
+		return "", err
+	}
+	return pi.ID, nil
+}
+
+// dial connects to p unless it is connected already, counting how the
+// dial went. Dials refused because p failed recently aren't counted again.
+func (n *P2PNetworking) dial(ctx context.Context, p peer.AddrInfo) error {
+	if n.host.Network().Connectedness(p.ID) == network.Connected {
+		return nil
+	}
+	err := n.host.Connect(ctx, p)
+	if !errors.Is(err, swarm.ErrDialBackoff) {
+		n.Health.Record(ctx, health.Dial, err)
+	}
+	return err
+}
+
+// Block denies a peer ID, IP address or CIDR range and closes the
+// connections it matches.
+func (n *P2PNetworking) Block(rule string) error {
+	if err := n.Gater.Deny(rule); err != nil {
+		return err
+	}
+	if n.host == nil {
+		return nil
+	}
+
+	for _, conn := range n.host.Network().Conns() {
+		if !n.Gater.Allows(conn.RemotePeer(), conn.RemoteMultiaddr()) {
+			n.logger.Info("Closing connection to blocked peer", zap.String("peer", conn.RemotePeer().String()))
+			conn.Close()
+		}
+	}
+	return nil
+}
+
+// Unblock removes a deny rule added by Block or configured at start.
+func (n *P2PNetworking) Unblock(rule string) error {
+	return n.Gater.Undeny(rule)
+}
+
 func (n *P2PNetworking) Close() error {
+	return n.stop(true)
+}
+
+// stop ends what Start started, closing the host too when closeHost is
+// set. A failed Start keeps a CustomHost open for its owner.
+func (n *P2PNetworking) stop(closeHost bool) error {
+	if n.cancel != nil {
+		n.cancel()
+	}
 	if n.dht != nil {
 		n.dht.Close()
 	}
-	if n.host != nil {
+	if n.onion != nil {
+		n.onion.Close()
+	}
+	if n.host != nil && closeHost {
 		return n.host.Close()
 	}
 	return nil
@@ -155,15 +754,37 @@ func (nn *networkNotifiee) Connected(net network.Network, conn network.Conn) {
 	nn.net.peers[peerID] = peer.AddrInfo{ID: peerID, Addrs: []multiaddr.Multiaddr{conn.RemoteMultiaddr()}}
 	nn.net.peersMu.Unlock()
 
+	nn.net.Events.Record(eventlog.Event{
+		Type: eventlog.PeerConnected,
+		Peer: peerID.String(),
+		Fields: map[string]string{
+			"addr":      conn.RemoteMultiaddr().String(),
+			"direction": conn.Stat().Direction.String(),
+		},
+	})
+
 	nn.logger.Info("Peer connected", zap.String("peer", peerID.String()))
 }
 
 func (nn *networkNotifiee) Disconnected(net network.Network, conn network.Conn) {
 	peerID := conn.RemotePeer()
+	lost := net.Connectedness(peerID) != network.Connected
+
 	nn.net.peersMu.Lock()
-	delete(nn.net.peers, peerID)
+	if lost {
+		delete(nn.net.peers, peerID)
+	}
+	callbacks := nn.net.onDisconnect
 	nn.net.peersMu.Unlock()
 
+	if lost {
+		for _, fn := range callbacks {
+			go fn(peerID)
+		}
+	}
+
+	nn.net.Events.Record(eventlog.Event{Type: eventlog.PeerDisconnected, Peer: peerID.String()})
+
 	nn.logger.Info("Peer disconnected", zap.String("peer", peerID.String()))
 }
 
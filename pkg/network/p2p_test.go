@@ -0,0 +1,236 @@
+package network
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// noBlocks is a block source with nothing in it, enough to start the
+// reprovider and the block handlers.
+type noBlocks struct{}
+
+func (noBlocks) Get(context.Context, cid.Cid) ([]byte, error)    { return nil, context.Canceled }
+func (noBlocks) List(context.Context, func(cid.Cid) error) error { return nil }
+
+// backgroundFrames mark the goroutines a node runs until it is closed:
+// the DHT, pubsub and this package's loops.
+var backgroundFrames = []string{
+	"github.com/libp2p/go-libp2p-kad-dht",
+	"github.com/libp2p/go-libp2p-pubsub",
+	"github.com/Noah-Wilderom/dfs/pkg/network.",
+}
+
+// background returns the stacks of the goroutines running node work,
+// leaving out the test's own.
+func background() []string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	var stacks []string
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, "testing.tRunner") || strings.Contains(g, "pkg/network.background") {
+			continue
+		}
+		for _, frame := range backgroundFrames {
+			if strings.Contains(g, frame) {
+				stacks = append(stacks, g)
+				break
+			}
+		}
+	}
+	return stacks
+}
+
+// waitBackground fails t unless the node goroutines are gone in time.
+func waitBackground(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left := background()
+		if len(left) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines outlived Close:\n\n%s", len(left), strings.Join(left, "\n\n"))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func startNode(t *testing.T, ctx context.Context) *P2PNetworking {
+	t.Helper()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewP2PNetworking(P2PNetworkingOpts{
+		EnableDHT:         true,
+		DHTMode:           dht.ModeServer,
+		Blocks:            noBlocks{},
+		ReprovideInterval: time.Hour,
+		CustomHost:        h,
+		Logger:            zap.NewNop(),
+	})
+	if err := n.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCloseStopsBackgroundWork(t *testing.T) {
+	waitBackground(t)
+
+	n := startNode(t, context.Background())
+	// A subscription without a deadline of its own ends with the bus
+	if err := NodeStatusTopic.Subscribe(context.Background(), n.Bus(), func(peer.ID, NodeStatus) {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(background()) == 0 {
+		t.Fatal("no background goroutines while the node runs")
+	}
+
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n.ctx.Err() == nil {
+		t.Error("node context not cancelled by Close")
+	}
+	if n.dht.Context().Err() == nil {
+		t.Error("DHT context not cancelled by Close")
+	}
+	waitBackground(t)
+}
+
+func TestStartContextOnlyBoundsStartup(t *testing.T) {
+	waitBackground(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := startNode(t, ctx)
+	cancel()
+
+	// Background work keeps running until Close, not until Start's ctx ends
+	time.Sleep(100 * time.Millisecond)
+	if n.ctx.Err() != nil {
+		t.Fatal("node context ended with Start's")
+	}
+	if n.dht.Context().Err() != nil {
+		t.Fatal("DHT context ended with Start's")
+	}
+	if len(background()) == 0 {
+		t.Fatal("background goroutines stopped with Start's context")
+	}
+
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitBackground(t)
+}
+
+// A start failing after the host is made closes it, so a retry can listen
+// on the same port.
+func TestFailedStartFreesPort(t *testing.T) {
+	port := freePort(t)
+	notDir := filepath.Join(t.TempDir(), "names")
+	if err := os.WriteFile(notDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts := P2PNetworkingOpts{
+		Port:              port,
+		DisableIPv6:       true,
+		DisablePortMap:    true,
+		EphemeralIdentity: true,
+		NamesDir:          notDir,
+		Logger:            zap.NewNop(),
+	}
+	failed := NewP2PNetworking(opts)
+	if err := failed.Start(context.Background()); err == nil {
+		failed.Close()
+		t.Fatal("Start succeeded with a file for the names directory")
+	}
+	if failed.Host() != nil {
+		t.Error("failed Start kept the host")
+	}
+
+	opts.NamesDir = t.TempDir()
+	n := NewP2PNetworking(opts)
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatalf("retry on port %d: %v", port, err)
+	}
+	n.Close()
+}
+
+// freePort returns a port that is free for UDP and, most likely, TCP.
+func freePort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// TestQUICHandshake connects two nodes over QUIC only. The quic-go
+// release libp2p v0.44 pulled in panicked on every incoming handshake
+// when built with Go 1.27.
+func TestQUICHandshake(t *testing.T) {
+	nodes := make([]*P2PNetworking, 2)
+	for i := range nodes {
+		nodes[i] = NewP2PNetworking(P2PNetworkingOpts{
+			Port:              freePort(t),
+			QUIC:              true,
+			DisableIPv6:       true,
+			DisablePortMap:    true,
+			EphemeralIdentity: true,
+			Logger:            zap.NewNop(),
+		})
+		if err := nodes[i].Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nodes[i].Close() })
+	}
+
+	target := peer.AddrInfo{ID: nodes[1].Host().ID()}
+	for _, addr := range nodes[1].Host().Addrs() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_QUIC_V1); err == nil {
+			target.Addrs = append(target.Addrs, addr)
+		}
+	}
+	if len(target.Addrs) == 0 {
+		t.Fatalf("no QUIC address among %v", nodes[1].Host().Addrs())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := nodes[0].Host().Connect(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range nodes[0].Host().Network().ConnsToPeer(target.ID) {
+		if _, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_QUIC_V1); err != nil {
+			t.Errorf("connected over %s, want QUIC", conn.RemoteMultiaddr())
+		}
+	}
+}
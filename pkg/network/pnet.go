@@ -0,0 +1,80 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// ErrPublicBootstrap is returned when a private network is configured with
+// public bootstrap peers, which can never be reached with a swarm key.
+var ErrPublicBootstrap = errors.New("network: public bootstrap peers can't be used in a private network")
+
+// LoadSwarmKey reads a pre-shared key in the format used by IPFS private
+// networks ("/key/swarm/psk/1.0.0/").
+func LoadSwarmKey(path string) (pnet.PSK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid swarm key %s: %w", path, err)
+	}
+	return psk, nil
+}
+
+// GenerateSwarmKey writes a new random swarm key to path. It refuses to
+// replace an existing key, which would cut the node off from its network.
+func GenerateSwarmKey(path string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "/key/swarm/psk/1.0.0/\n/base16/\n%s\n", hex.EncodeToString(key)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// checkPrivateBootstrap fails if any of addrs is a public IPFS bootstrap
+// peer.
+func checkPrivateBootstrap(addrs []string) error {
+	public := make(map[peer.ID]bool)
+	for _, a := range dht.DefaultBootstrapPeers {
+		if pi, err := peer.AddrInfoFromP2pAddr(a); err == nil {
+			public[pi.ID] = true
+		}
+	}
+
+	for _, s := range addrs {
+		pi, err := peer.AddrInfoFromString(s)
+		if err != nil {
+			continue
+		}
+		if public[pi.ID] {
+			return fmt.Errorf("%w: %s", ErrPublicBootstrap, s)
+		}
+	}
+	return nil
+}
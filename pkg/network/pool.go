@@ -0,0 +1,97 @@
+package network
+
+import (
+	"github.com/Noah-Wilderom/dfs/pkg/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/zap"
+)
+
+// StreamPool bounds how many inbound streams of a protocol are handled:
+// Workers at once, with up to Queue more waiting. Streams beyond that are
+// reset straight away, so a burst of requests can't pile up goroutines.
+// Zero fields use the protocol's default.
+type StreamPool struct {
+	Workers int
+	Queue   int
+}
+
+// DefaultStreamPools are the pools used for protocols StreamPools leaves
+// out. A want stream holds its worker as long as the peer keeps fetching,
+// and until it has been idle for wantIdleTimeout, so want workers bound
+// the peers served at once. Replication requests, pushes, differences
+// and selections run the longest and are the fewest.
+var DefaultStreamPools = map[protocol.ID]StreamPool{
+	BlockProtocol:     {Workers: 32, Queue: 256},
+	WantProtocol:      {Workers: 64, Queue: 64},
+	ReplicateProtocol: {Workers: 4, Queue: 16},
+	DiffProtocol:      {Workers: 4, Queue: 16},
+	SelectProtocol:    {Workers: 4, Queue: 16},
+	PushProtocol:      {Workers: 4, Queue: 16},
+}
+
+// streamPool returns the pool for proto, filling in defaults.
+func (n *P2PNetworking) streamPool(proto protocol.ID) StreamPool {
+	pool, def := n.StreamPools[proto], DefaultStreamPools[proto]
+	if pool.Workers <= 0 {
+		pool.Workers = def.Workers
+	}
+	if pool.Queue <= 0 {
+		pool.Queue = def.Queue
+	}
+	return pool
+}
+
+// setPooledHandler serves proto with h, run by the workers of the
+// protocol's pool until Close. Fewer of them run while Pressure throttles.
+func (n *P2PNetworking) setPooledHandler(proto protocol.ID, h network.StreamHandler) {
+	pool := n.streamPool(proto)
+	active := metrics.StreamsActive.WithLabelValues(string(proto))
+	queued := metrics.StreamsQueued.WithLabelValues(string(proto))
+	shed := metrics.StreamsShed.WithLabelValues(string(proto))
+
+	queue := make(chan network.Stream, pool.Queue)
+	for i := range pool.Workers {
+		go func() {
+			for {
+				// Under resource pressure only some workers take streams
+				for {
+					changed := n.Pressure.Changed()
+					if i < n.Pressure.Limit(pool.Workers) {
+						break
+					}
+					select {
+					case <-n.ctx.Done():
+						return
+					case <-changed:
+					}
+				}
+
+				select {
+				case <-n.ctx.Done():
+					return
+				case s := <-queue:
+					queued.Dec()
+					active.Inc()
+					h(s)
+					active.Dec()
+				}
+			}
+		}()
+	}
+
+	n.host.SetStreamHandler(proto, func(s network.Stream) {
+		queued.Inc()
+		select {
+		case queue <- s:
+		default:
+			queued.Dec()
+			shed.Inc()
+			n.logger.Debug("Handlers busy, resetting stream",
+				zap.String("protocol", string(proto)),
+				zap.String("peer", s.Conn().RemotePeer().String()),
+			)
+			s.Reset()
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcpreuse"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/net/proxy"
+)
+
+// socksTransport dials TCP and onion addresses through a SOCKS5 proxy.
+// Host names are handed to the proxy unresolved, so the node doesn't look
+// them up itself. Listening is plain TCP, meant for loopback only.
+type socksTransport struct {
+	dialer   proxy.ContextDialer
+	upgrader transport.Upgrader
+	rcmgr    network.ResourceManager
+	// tcp accepts the connections Tor forwards from the onion service.
+	tcp *tcp.TcpTransport
+}
+
+var (
+	_ transport.Transport    = (*socksTransport)(nil)
+	_ transport.SkipResolver = (*socksTransport)(nil)
+)
+
+// newSOCKSTransport is handed to libp2p, which fills in the arguments.
+func (n *P2PNetworking) newSOCKSTransport(u transport.Upgrader, rcmgr network.ResourceManager, sharedTCP *tcpreuse.ConnMgr) (*socksTransport, error) {
+	d, err := proxy.SOCKS5("tcp", n.Proxy, nil, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	t, err := tcp.NewTCPTransport(u, rcmgr, sharedTCP)
+	if err != nil {
+		return nil, err
+	}
+	return &socksTransport{dialer: d.(proxy.ContextDialer), upgrader: u, rcmgr: rcmgr, tcp: t}, nil
+}
+
+// proxyTarget returns the "host:port" to ask the proxy for: an onion
+// address, or an IP address or host name with a TCP port.
+func proxyTarget(addr multiaddr.Multiaddr) (string, bool) {
+	if len(addr) == 1 && addr[0].Code() == multiaddr.P_ONION3 {
+		// The value is "<service id>:<port>"
+		id, port, ok := strings.Cut(addr[0].Value(), ":")
+		return net.JoinHostPort(id+".onion", port), ok
+	}
+	if len(addr) != 2 || addr[1].Code() != multiaddr.P_TCP {
+		return "", false
+	}
+	switch addr[0].Code() {
+	case multiaddr.P_IP4, multiaddr.P_IP6, multiaddr.P_DNS, multiaddr.P_DNS4, multiaddr.P_DNS6:
+		return net.JoinHostPort(addr[0].Value(), addr[1].Value()), true
+	}
+	return "", false
+}
+
+func (t *socksTransport) CanDial(addr multiaddr.Multiaddr) bool {
+	_, ok := proxyTarget(addr)
+	return ok
+}
+
+// SkipResolve keeps the swarm from resolving host names locally.
+func (t *socksTransport) SkipResolve(context.Context, multiaddr.Multiaddr) bool {
+	return true
+}
+
+func (t *socksTransport) Dial(ctx context.Context, raddr multiaddr.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	target, ok := proxyTarget(raddr)
+	if !ok {
+		return nil, fmt.Errorf("can't dial %s through a proxy", raddr)
+	}
+
+	scope, err := t.rcmgr.OpenConnection(network.DirOutbound, true, raddr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := t.dial(ctx, target, raddr, p, scope)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *socksTransport) dial(ctx context.Context, target string, raddr multiaddr.Multiaddr, p peer.ID, scope network.ConnManagementScope) (transport.CapableConn, error) {
+	if err := scope.SetPeer(p); err != nil {
+		return nil, err
+	}
+	nc, err := t.dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, err
+	}
+	laddr, err := manet.FromNetAddr(nc.LocalAddr())
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	// Report the peer's address rather than the proxy's
+	c := &proxiedConn{Conn: nc, laddr: laddr, raddr: raddr}
+	return t.upgrader.Upgrade(ctx, t, c, network.DirOutbound, p, scope)
+}
+
+func (t *socksTransport) Listen(laddr multiaddr.Multiaddr) (transport.Listener, error) {
+	return t.tcp.Listen(laddr)
+}
+
+func (t *socksTransport) Protocols() []int {
+	return []int{multiaddr.P_TCP, multiaddr.P_ONION3}
+}
+
+func (t *socksTransport) Proxy() bool {
+	return false
+}
+
+func (t *socksTransport) String() string {
+	return "SOCKS5"
+}
+
+// proxiedConn is a connection made through the proxy.
+type proxiedConn struct {
+	net.Conn
+	laddr, raddr multiaddr.Multiaddr
+}
+
+var _ manet.Conn = (*proxiedConn)(nil)
+
+func (c *proxiedConn) LocalMultiaddr() multiaddr.Multiaddr  { return c.laddr }
+func (c *proxiedConn) RemoteMultiaddr() multiaddr.Multiaddr { return c.raddr }
+
+// ProxyLimitations lists what a node behind Proxy gives up, or nothing
+// without a proxy.
+func (n *P2PNetworking) ProxyLimitations() []string {
+	if n.Proxy == "" {
+		return nil
+	}
+	l := []string{
+		"only TCP goes through the proxy: QUIC and the browser transports are off",
+		"port mapping, AutoNAT, relays and hole punching are off, as they reveal the node's address",
+		"/dnsaddr bootstrap addresses are looked up with the local resolver, not through the proxy",
+		"peers still see which hashes this peer ID stores and asks for",
+	}
+	if n.onion == nil {
+		l = append(l, "no onion service: peers can't connect to the node, it only dials out")
+	} else {
+		l = append(l, "only peers dialing through Tor themselves can reach the onion address")
+	}
+	return l
+}
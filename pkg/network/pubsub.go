@@ -0,0 +1,187 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// Cluster event topics. Messages are JSON and signed by their publisher,
+// so the sender passed to subscribers is authenticated even when the
+// message was relayed by other peers.
+var (
+	// ContentAddedTopic announces that a peer added or pinned a file and
+	// now holds a full copy.
+	ContentAddedTopic = Topic[ContentAdded]{Name: "/dfs/events/content-added/1.0.0"}
+	// PinRequestTopic asks peers that accept replicas to keep a copy of a
+	// file. Those that do announce it on ContentAddedTopic.
+	PinRequestTopic = Topic[PinRequest]{Name: "/dfs/events/pin-request/1.0.0"}
+	// NodeStatusTopic carries periodic node status.
+	NodeStatusTopic = Topic[NodeStatus]{Name: "/dfs/events/node-status/1.0.0"}
+	// IdentityRotatedTopic carries the continuity records of nodes that
+	// rotated their key. Nodes repeat their own periodically, for peers
+	// that were offline at the rotation.
+	IdentityRotatedTopic = Topic[Continuity]{Name: "/dfs/events/identity-rotated/1.0.0"}
+	// InventoryTopic carries Bloom filters of the blocks nodes store.
+	InventoryTopic = Topic[Inventory]{Name: "/dfs/events/inventory/1.0.0"}
+	// KeyRevokedTopic carries the revocations of node keys, repeated
+	// periodically like continuity records. A revoked key announces its
+	// own revocation, so this topic takes messages from revoked keys.
+	KeyRevokedTopic = Topic[Revocation]{Name: "/dfs/events/key-revoked/1.0.0", FromRevoked: true}
+)
+
+type ContentAdded struct {
+	CID  string `json:"cid"`
+	Name string `json:"name,omitempty"`
+	Size int64  `json:"size"`
+}
+
+type PinRequest struct {
+	CID string `json:"cid"`
+}
+
+type NodeStatus struct {
+	Blocks int64 `json:"blocks"`
+	Bytes  int64 `json:"bytes"`
+	Pins   int   `json:"pins"`
+	Peers  int   `json:"peers"`
+	// AcceptsReplicas is set when the node keeps copies for others.
+	AcceptsReplicas bool `json:"accepts_replicas"`
+	// Zone is the zone the node is in, for replicas to be spread across
+	// zones. Empty when not configured.
+	Zone string `json:"zone,omitempty"`
+}
+
+// EventBus publishes and delivers cluster events over GossipSub.
+type EventBus struct {
+	// ctx ends when the bus stops, and with it every subscription.
+	ctx    context.Context
+	ps     *pubsub.PubSub
+	self   peer.ID
+	logger *zap.Logger
+	// revoked tells the publishers whose messages are dropped. Optional.
+	revoked func(peer.ID) bool
+
+	mu     sync.Mutex
+	topics map[string]*pubsub.Topic
+}
+
+func NewEventBus(ctx context.Context, h host.Host, logger *zap.Logger) (*EventBus, error) {
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventBus{
+		ctx:    ctx,
+		ps:     ps,
+		self:   h.ID(),
+		logger: logger,
+		topics: make(map[string]*pubsub.Topic),
+	}, nil
+}
+
+// join returns the joined topic name, registering validate for it on
+// first use. Messages published by revoked keys are dropped unless
+// fromRevoked is set.
+func (b *EventBus) join(name string, fromRevoked bool, validate func([]byte) error) (*pubsub.Topic, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if t, ok := b.topics[name]; ok {
+		return t, nil
+	}
+
+	// Malformed messages are dropped before they are delivered or
+	// forwarded to other peers.
+	err := b.ps.RegisterTopicValidator(name, func(_ context.Context, _ peer.ID, msg *pubsub.Message) bool {
+		if !fromRevoked && b.revoked != nil && b.revoked(msg.GetFrom()) {
+			return false
+		}
+		return validate(msg.Data) == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	t, err := b.ps.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	b.topics[name] = t
+	return t, nil
+}
+
+// Topic is a cluster event topic carrying messages of type T.
+type Topic[T any] struct {
+	Name string
+	// FromRevoked delivers messages published by revoked keys.
+	FromRevoked bool
+}
+
+func (t Topic[T]) join(b *EventBus) (*pubsub.Topic, error) {
+	if b == nil {
+		return nil, errors.New("network: event bus is not running")
+	}
+	return b.join(t.Name, t.FromRevoked, func(data []byte) error {
+		return json.Unmarshal(data, new(T))
+	})
+}
+
+// Publish sends msg to every peer subscribed to the topic.
+func (t Topic[T]) Publish(ctx context.Context, b *EventBus, msg T) error {
+	topic, err := t.join(b)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return topic.Publish(ctx, data)
+}
+
+// Subscribe calls fn with every message other peers publish on the topic
+// until ctx is done or the bus stops. fn runs on one goroutine per
+// subscription.
+func (t Topic[T]) Subscribe(ctx context.Context, b *EventBus, fn func(from peer.ID, msg T)) error {
+	topic, err := t.join(b)
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(b.ctx, cancel)
+	go func() {
+		defer cancel()
+		defer stop()
+		defer sub.Cancel()
+		for {
+			raw, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			from := raw.GetFrom()
+			if from == b.self {
+				continue
+			}
+
+			var msg T
+			if err := json.Unmarshal(raw.Data, &msg); err != nil {
+				b.logger.Debug("Dropping malformed event", zap.String("topic", t.Name), zap.Error(err))
+				continue
+			}
+			fn(from, msg)
+		}
+	}()
+	return nil
+}
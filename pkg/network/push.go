@@ -0,0 +1,126 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	msmux "github.com/multiformats/go-multistream"
+	"go.uber.org/zap"
+)
+
+// PushProtocol offers a peer a file or directory tree, for it to take
+// without anyone looking it up: "send this to my other machine now". A
+// request is the length prefixed root CID; the peer fetches the tree from
+// the requester, if its policy accepts it, before answering a status
+// byte followed by a length prefixed message.
+const PushProtocol protocol.ID = "/dfs/push/1.0.0"
+
+const (
+	pushTaken   byte = 0
+	pushFailed  byte = 1
+	pushRefused byte = 2
+
+	pushTimeout = 30 * time.Minute
+)
+
+// ErrPushRefused is wrapped by handler errors that decline a push on
+// purpose rather than failing it.
+var ErrPushRefused = errors.New("network: push refused")
+
+// PushHandler takes the file or directory tree rooted at c that from
+// pushed, fetching it from from.
+type PushHandler func(ctx context.Context, from peer.ID, c cid.Cid) error
+
+// HandlePush accepts pushes from peers. Without a handler peers can't
+// push to this node.
+func (n *P2PNetworking) HandlePush(h PushHandler) {
+	n.setPooledHandler(PushProtocol, func(s network.Stream) {
+		defer s.Close()
+		s.SetDeadline(time.Now().Add(pushTimeout))
+
+		c, err := readCID(bufio.NewReader(s))
+		if err != nil {
+			s.Reset()
+			return
+		}
+
+		from := s.Conn().RemotePeer()
+		ctx, cancel := context.WithTimeout(n.ctx, pushTimeout)
+		defer cancel()
+
+		status, msg := pushTaken, ""
+		if err := h(ctx, from, c); err != nil {
+			log := n.logger.Warn
+			status = pushFailed
+			if errors.Is(err, ErrPushRefused) {
+				log, status = n.logger.Debug, pushRefused
+			}
+			log("Failed to take push",
+				zap.String("cid", c.String()),
+				zap.String("peer", from.String()),
+				zap.Error(err),
+			)
+			msg = err.Error()
+		}
+
+		res := binary.AppendUvarint([]byte{status}, uint64(len(msg)))
+		s.Write(append(res, msg...))
+	})
+}
+
+// Push offers p the file or directory tree rooted at c and waits until p
+// has fetched it from this node, or declined it. Peers that don't accept
+// pushes don't speak the protocol, which declines it too.
+func (n *P2PNetworking) Push(ctx context.Context, p peer.ID, c cid.Cid) error {
+	s, err := n.host.NewStream(ctx, p, PushProtocol)
+	if errors.Is(err, msmux.ErrNotSupported[protocol.ID]{}) {
+		return &refusedError{msg: fmt.Sprintf("peer %s doesn't accept pushes", p), err: ErrPushRefused}
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	} else {
+		s.SetDeadline(time.Now().Add(pushTimeout))
+	}
+
+	key := c.Bytes()
+	if _, err := s.Write(append(binary.AppendUvarint(nil, uint64(len(key))), key...)); err != nil {
+		s.Reset()
+		return err
+	}
+	s.CloseWrite()
+
+	r := bufio.NewReader(s)
+	status, err := r.ReadByte()
+	if err != nil {
+		s.Reset()
+		return err
+	}
+	msg, err := readPrefixed(r, 4<<10)
+	if err != nil {
+		s.Reset()
+		return err
+	}
+	switch status {
+	case pushTaken:
+		return nil
+	case pushRefused:
+		return &refusedError{msg: fmt.Sprintf("peer %s: %s", p, msg), err: ErrPushRefused}
+	default:
+		return fmt.Errorf("peer %s: %s", p, msg)
+	}
+}
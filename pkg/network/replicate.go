@@ -0,0 +1,160 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/zap"
+)
+
+// ReplicateProtocol asks a peer to keep a copy of a file. A request is the
+// length prefixed root CID, optionally followed by the length prefixed
+// root of an earlier version the copy replaces; the response is a status
+// byte followed by a length prefixed error message. The peer fetches the
+// file over WantProtocol, or what changed over DiffProtocol, before
+// answering.
+const ReplicateProtocol protocol.ID = "/dfs/replicate/1.0.0"
+
+const (
+	replicaStored byte = 0
+	replicaFailed byte = 1
+	// replicaRefused tells the requester the peer declined on purpose, so
+	// asking again soon is pointless.
+	replicaRefused byte = 2
+
+	replicateTimeout = 30 * time.Minute
+)
+
+// ErrReplicaRefused is wrapped by handler errors that decline a request on
+// purpose rather than failing it.
+var ErrReplicaRefused = errors.New("network: replica refused")
+
+// ReplicaHandler keeps a copy of the file rooted at c on behalf of from.
+// base is the earlier version c replaces, cid.Undef for a new copy.
+type ReplicaHandler func(ctx context.Context, from peer.ID, c, base cid.Cid) error
+
+// HandleReplicas accepts replication requests from peers. Without a
+// handler they are refused.
+func (n *P2PNetworking) HandleReplicas(h ReplicaHandler) {
+	n.setPooledHandler(ReplicateProtocol, func(s network.Stream) {
+		defer s.Close()
+		s.SetDeadline(time.Now().Add(replicateTimeout))
+
+		r := bufio.NewReader(s)
+		c, err := readCID(r)
+		if err != nil {
+			s.Reset()
+			return
+		}
+		base, err := readCID(r)
+		if errors.Is(err, io.EOF) {
+			base = cid.Undef
+		} else if err != nil {
+			s.Reset()
+			return
+		}
+
+		from := s.Conn().RemotePeer()
+		ctx, cancel := context.WithTimeout(n.ctx, replicateTimeout)
+		defer cancel()
+
+		status, msg := replicaStored, ""
+		if err := h(ctx, from, c, base); err != nil {
+			log := n.logger.Warn
+			status = replicaFailed
+			if errors.Is(err, ErrReplicaRefused) {
+				log, status = n.logger.Debug, replicaRefused
+			}
+			log("Failed to store replica",
+				zap.String("cid", c.String()),
+				zap.String("peer", from.String()),
+				zap.Error(err),
+			)
+			msg = err.Error()
+		}
+
+		res := binary.AppendUvarint([]byte{status}, uint64(len(msg)))
+		s.Write(append(res, msg...))
+	})
+}
+
+// RequestReplica asks p to keep a copy of the file rooted at c and waits
+// until it has one.
+func (n *P2PNetworking) RequestReplica(ctx context.Context, p peer.ID, c cid.Cid) error {
+	return n.requestReplica(ctx, p, c, cid.Undef)
+}
+
+// RequestUpdate asks p to keep c in place of its copy of base, an earlier
+// version, and waits until it has. p fetches only what c doesn't share
+// with base.
+func (n *P2PNetworking) RequestUpdate(ctx context.Context, p peer.ID, c, base cid.Cid) error {
+	return n.requestReplica(ctx, p, c, base)
+}
+
+func (n *P2PNetworking) requestReplica(ctx context.Context, p peer.ID, c, base cid.Cid) error {
+	// Peers that don't accept replicas don't speak the protocol, so
+	// opening the stream fails.
+	s, err := n.host.NewStream(ctx, p, ReplicateProtocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	} else {
+		s.SetDeadline(time.Now().Add(replicateTimeout))
+	}
+
+	key := c.Bytes()
+	req := append(binary.AppendUvarint(nil, uint64(len(key))), key...)
+	if base.Defined() {
+		key := base.Bytes()
+		req = append(binary.AppendUvarint(req, uint64(len(key))), key...)
+	}
+	if _, err := s.Write(req); err != nil {
+		s.Reset()
+		return err
+	}
+	s.CloseWrite()
+
+	r := bufio.NewReader(s)
+	status, err := r.ReadByte()
+	if err != nil {
+		s.Reset()
+		return err
+	}
+	msg, err := readPrefixed(r, 4<<10)
+	if err != nil {
+		s.Reset()
+		return err
+	}
+	switch status {
+	case replicaStored:
+		return nil
+	case replicaRefused:
+		return &refusedError{msg: fmt.Sprintf("peer %s: %s", p, msg), err: ErrReplicaRefused}
+	default:
+		return fmt.Errorf("peer %s: %s", p, msg)
+	}
+}
+
+// refusedError carries a peer's refusal, matching err: ErrReplicaRefused
+// or ErrPushRefused.
+type refusedError struct {
+	msg string
+	err error
+}
+
+func (e *refusedError) Error() string { return e.msg }
+
+func (e *refusedError) Unwrap() error { return e.err }
@@ -0,0 +1,360 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const revocationDomain = "dfs-revocation/1\n"
+
+var (
+	// ErrBadRevocation is returned for a revocation whose signature
+	// doesn't check out.
+	ErrBadRevocation = errors.New("network: invalid revocation")
+	// ErrRevoked is returned for anything signed by a revoked key.
+	ErrRevoked = errors.New("network: key revoked")
+)
+
+// Revocation retires a key for good: the key signs its own revocation, so
+// only its holder can make one, and can make it ahead of time to keep
+// offline for when the key is lost. Peers that learn of a revocation stop
+// talking to the peer and stop honouring what its key signs: allow rules,
+// replica requests and cluster events, shares and names. An identity
+// rotation it signed is only honoured by a node that kept it before both
+// the revocation's time and learning of the revocation.
+type Revocation struct {
+	Peer    string    `json:"peer"`
+	Revoked time.Time `json:"revoked"`
+	Reason  string    `json:"reason,omitempty"`
+	// Key is the public key of Peer, which only some peer IDs embed.
+	Key       []byte `json:"key"`
+	Signature []byte `json:"signature"`
+	// Received is when this node first learned the key was revoked. It
+	// isn't signed or announced.
+	Received time.Time `json:"received,omitzero"`
+}
+
+// NewRevocation signs the revocation of key as of revoked.
+func NewRevocation(key crypto.PrivKey, revoked time.Time, reason string) (*Revocation, error) {
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Revocation{Peer: id.String(), Revoked: revoked.UTC().Truncate(time.Second), Reason: reason}
+	if r.Key, err = crypto.MarshalPublicKey(key.GetPublic()); err != nil {
+		return nil, err
+	}
+	if r.Signature, err = key.Sign(r.payload()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Verify checks the signature and returns the revoked peer ID.
+func (r *Revocation) Verify() (peer.ID, error) {
+	id, err := peer.Decode(r.Peer)
+	if err != nil {
+		return "", fmt.Errorf("%w: peer ID: %v", ErrBadRevocation, err)
+	}
+	pub, err := crypto.UnmarshalPublicKey(r.Key)
+	if err != nil {
+		return "", fmt.Errorf("%w: key of %s: %v", ErrBadRevocation, id, err)
+	}
+	if !id.MatchesPublicKey(pub) {
+		return "", fmt.Errorf("%w: key doesn't match %s", ErrBadRevocation, id)
+	}
+	if ok, err := pub.Verify(r.payload(), r.Signature); err != nil || !ok {
+		return "", fmt.Errorf("%w: bad signature by %s", ErrBadRevocation, id)
+	}
+	return id, nil
+}
+
+// honours reports whether c, a rotation away from the revoked key, still
+// holds: it does when this node kept it before the key was revoked and
+// before it learned so.
+func (r *Revocation) honours(c Continuity) bool {
+	cutoff := r.Revoked
+	if !r.Received.IsZero() && r.Received.Before(cutoff) {
+		cutoff = r.Received
+	}
+	return c.Recorded.Before(cutoff)
+}
+
+func (r *Revocation) payload() []byte {
+	return fmt.Appendf(nil, "%s%s\n%s\n%s", revocationDomain, r.Peer, r.Revoked.UTC().Format(time.RFC3339), r.Reason)
+}
+
+// Revocations keeps the verified revocations a node knows of in a JSON
+// file, like Successions.
+type Revocations struct {
+	path string
+
+	mu      sync.Mutex
+	revoked map[peer.ID]Revocation
+}
+
+// OpenRevocations loads the revocations at path. A missing file is an
+// empty set; an empty path keeps them in memory only.
+func OpenRevocations(path string) (*Revocations, error) {
+	s := &Revocations{path: path, revoked: make(map[peer.ID]Revocation)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add verifies r and keeps it, reporting whether it was new. Of two
+// revocations of a key the earlier one stands.
+func (s *Revocations) Add(r Revocation) (bool, error) {
+	id, err := r.Verify()
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another process, like dfs identity revoke, may have added some
+	if err := s.load(); err != nil {
+		return false, err
+	}
+	if prev, ok := s.revoked[id]; ok && !r.Revoked.Before(prev.Revoked) {
+		return false, nil
+	}
+
+	prev, had := s.revoked[id]
+	r.Received = time.Now().UTC()
+	if had {
+		r.Received = prev.Received
+	}
+	s.revoked[id] = r
+	if err := s.save(); err != nil {
+		if had {
+			s.revoked[id] = prev
+		} else {
+			delete(s.revoked, id)
+		}
+		return false, err
+	}
+	return !had, nil
+}
+
+// Revoked reports whether the key of id is revoked. It is safe on a nil
+// set, which revokes nothing.
+func (s *Revocations) Revoked(id peer.ID) bool {
+	_, ok := s.RevokedAt(id)
+	return ok
+}
+
+// RevokedAt returns when the key of id was revoked.
+func (s *Revocations) RevokedAt(id peer.ID) (time.Time, bool) {
+	r, ok := s.Get(id)
+	return r.Revoked, ok
+}
+
+// Get returns the revocation of the key of id.
+func (s *Revocations) Get(id peer.ID) (Revocation, bool) {
+	if s == nil {
+		return Revocation{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.revoked[id]
+	return r, ok
+}
+
+// List returns the revocations, oldest first.
+func (s *Revocations) List() []Revocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+func (s *Revocations) sorted() []Revocation {
+	list := make([]Revocation, 0, len(s.revoked))
+	for _, r := range s.revoked {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Revoked.Equal(list[j].Revoked) {
+			return list[i].Revoked.Before(list[j].Revoked)
+		}
+		return list[i].Peer < list[j].Peer
+	})
+	return list
+}
+
+// load merges the revocations in the file into s, skipping any that don't
+// verify. Callers hold s.mu or own s.
+func (s *Revocations) load() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []Revocation
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	for _, r := range list {
+		id, err := r.Verify()
+		if err != nil {
+			continue
+		}
+		if prev, ok := s.revoked[id]; !ok || r.Revoked.Before(prev.Revoked) {
+			if ok {
+				r.Received = prev.Received
+			}
+			s.revoked[id] = r
+		}
+	}
+	return nil
+}
+
+// save writes the revocations atomically. Callers hold s.mu.
+func (s *Revocations) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(s.path, data, 0600)
+}
+
+// Revoke keeps r, cuts the revoked peer off and announces r to the
+// cluster. It reports whether r was new.
+func (n *P2PNetworking) Revoke(r Revocation) (bool, error) {
+	added, err := n.Revocations.Add(r)
+	if err != nil || !added {
+		return added, err
+	}
+	// Verified by Add
+	id, _ := r.Verify()
+	n.revoked(id, r)
+
+	if n.bus != nil {
+		r.Received = time.Time{}
+		if err := KeyRevokedTopic.Publish(n.ctx, n.bus, r); err != nil {
+			n.logger.Warn("Failed to announce revocation", zap.String("peer", r.Peer), zap.Error(err))
+		}
+	}
+	return true, nil
+}
+
+// revoked stops honouring the key of id.
+func (n *P2PNetworking) revoked(id peer.ID, r Revocation) {
+	n.Gater.Revoke(id)
+	n.undoSuccession(id)
+	if n.host != nil {
+		n.host.Network().ClosePeer(id)
+	}
+	n.logger.Warn("Peer key revoked", zap.String("peer", id.String()))
+	n.Events.Record(eventlog.Event{
+		Type:   eventlog.KeyRevoked,
+		Peer:   id.String(),
+		Fields: map[string]string{"revoked": r.Revoked.Format(time.RFC3339), "reason": r.Reason},
+	})
+}
+
+// undoSuccession forgets the rotation away from the revoked key of id
+// unless the revocation honours it, and undoes what the node carried
+// over to the successor.
+func (n *P2PNetworking) undoSuccession(id peer.ID) {
+	n.successionMu.Lock()
+	defer n.successionMu.Unlock()
+
+	r, ok := n.Revocations.Get(id)
+	if !ok {
+		return
+	}
+	c, ok := n.Successions.Get(id)
+	if !ok || r.honours(c) {
+		return
+	}
+	if _, _, err := n.Successions.Drop(id); err != nil {
+		n.logger.Warn("Failed to forget succession of revoked key", zap.String("peer", id.String()), zap.Error(err))
+		return
+	}
+	// Verified when added
+	_, to, _ := c.Verify()
+	n.logger.Warn("Succession of revoked key undone", zap.String("from", c.Old), zap.String("to", c.New))
+	for _, fn := range n.onSuccessionUndone {
+		fn(id, to)
+	}
+	if !n.Gater.Allows(to, nil) && n.host != nil {
+		n.host.Network().ClosePeer(to)
+	}
+}
+
+// followRevocations applies the revocations already known and those
+// peers announce, and repeats them all every successionInterval for
+// peers that were offline when they were made.
+func (n *P2PNetworking) followRevocations() error {
+	for _, r := range n.Revocations.List() {
+		// Before followSuccessions, so nothing was carried over yet
+		id, _ := r.Verify()
+		n.Gater.Revoke(id)
+		n.undoSuccession(id)
+	}
+
+	err := KeyRevokedTopic.Subscribe(n.ctx, n.bus, func(_ peer.ID, r Revocation) {
+		// Anyone may relay a revocation, the signature is what counts
+		added, err := n.Revocations.Add(r)
+		if err != nil {
+			n.logger.Debug("Ignoring revocation", zap.String("peer", r.Peer), zap.Error(err))
+			return
+		}
+		if added {
+			id, _ := r.Verify()
+			n.revoked(id, r)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(successionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, r := range n.Revocations.List() {
+				r.Received = time.Time{}
+				if err := KeyRevokedTopic.Publish(n.ctx, n.bus, r); err != nil && n.ctx.Err() == nil {
+					n.logger.Debug("Failed to announce revocation", zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
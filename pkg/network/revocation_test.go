@@ -0,0 +1,252 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"go.uber.org/zap"
+)
+
+func mustRevocation(t *testing.T, key crypto.PrivKey, revoked time.Time) Revocation {
+	t.Helper()
+	r, err := NewRevocation(key, revoked, "lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *r
+}
+
+func TestRevocationVerify(t *testing.T) {
+	key, id := nodeKey(t)
+	otherKey, _ := nodeKey(t)
+
+	r := mustRevocation(t, key, time.Now())
+	got, err := r.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("Verify = %s, want %s", got, id)
+	}
+
+	// Backdating a revocation
+	forged := r
+	forged.Revoked = r.Revoked.Add(-time.Hour)
+	if _, err := forged.Verify(); !errors.Is(err, ErrBadRevocation) {
+		t.Errorf("Verify of a backdated revocation = %v, want %v", err, ErrBadRevocation)
+	}
+	// Revoking a peer without its key
+	forged = mustRevocation(t, otherKey, time.Now())
+	forged.Peer = id.String()
+	if _, err := forged.Verify(); !errors.Is(err, ErrBadRevocation) {
+		t.Errorf("Verify without the key = %v, want %v", err, ErrBadRevocation)
+	}
+}
+
+func TestRevocationsKeepEarliest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	s, err := OpenRevocations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, id := nodeKey(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	if added, err := s.Add(mustRevocation(t, key, now)); err != nil || !added {
+		t.Fatalf("Add = %v, %v, want true, nil", added, err)
+	}
+	if added, err := s.Add(mustRevocation(t, key, now.Add(time.Hour))); err != nil || added {
+		t.Fatalf("Add of a later revocation = %v, %v, want false, nil", added, err)
+	}
+	if _, err := s.Add(mustRevocation(t, key, now.Add(-time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenRevocations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at, ok := reopened.RevokedAt(id)
+	if !ok || !at.Equal(now.Add(-time.Hour)) {
+		t.Errorf("RevokedAt after reopening = %v, %v, want %v, true", at, ok, now.Add(-time.Hour))
+	}
+	if n := len(reopened.List()); n != 1 {
+		t.Errorf("List has %d revocations, want 1", n)
+	}
+
+	var none *Revocations
+	if none.Revoked(id) {
+		t.Error("nil set revokes")
+	}
+}
+
+func TestGaterRevoke(t *testing.T) {
+	_, id := nodeKey(t)
+	g, err := NewGater([]string{id.String()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.Revoke(id)
+	if g.Allows(id, nil) {
+		t.Error("revoked peer allowed by an allow rule")
+	}
+	if err := g.Deny(id.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Undeny(id.String()); err != nil {
+		t.Fatal(err)
+	}
+	if g.Allows(id, nil) {
+		t.Error("revoked peer allowed once unblocked")
+	}
+}
+
+func TestRevocationAnnounced(t *testing.T) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	nets := make([]*P2PNetworking, 2)
+	for i := range nets {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		gater, err := NewGater(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets[i] = NewP2PNetworking(P2PNetworkingOpts{
+			CustomHost: h,
+			Gater:      gater,
+			Logger:     zap.NewNop(),
+		})
+		if err := nets[i].Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nets[i].Close() })
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	key, id := nodeKey(t)
+	r := mustRevocation(t, key, time.Now())
+	if added, err := nets[0].Revoke(r); err != nil || !added {
+		t.Fatalf("Revoke = %v, %v, want true, nil", added, err)
+	}
+	if nets[0].Gater.Allows(id, nil) {
+		t.Error("revoked peer allowed by the revoking node")
+	}
+
+	// Announce until the bus has formed its mesh
+	deadline := time.After(10 * time.Second)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for !nets[1].Revocations.Revoked(id) {
+		if err := KeyRevokedTopic.Publish(context.Background(), nets[0].bus, r); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-deadline:
+			t.Fatal("peer never learned of the revocation")
+		case <-ticker.C:
+		}
+	}
+	if nets[1].Gater.Allows(id, nil) {
+		t.Error("revoked peer allowed by the peer that learned of it")
+	}
+}
+
+// revocationNet is a node, not started, whose gater allows allowed and
+// follows successions as a started one does.
+func revocationNet(t *testing.T, allowed peer.ID) *P2PNetworking {
+	t.Helper()
+	gater, err := NewGater([]string{allowed.String()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewP2PNetworking(P2PNetworkingOpts{Gater: gater, Logger: zap.NewNop()})
+	n.OnSuccession(n.Gater.Succeed)
+	n.OnSuccessionUndone(n.Gater.Unsucceed)
+	return n
+}
+
+// TestBackdatedSuccessionAfterRevocation has a thief rotate a stolen key,
+// dating the rotation before the revocation the node already knows of.
+func TestBackdatedSuccessionAfterRevocation(t *testing.T) {
+	key, id := nodeKey(t)
+	thiefKey, thief := nodeKey(t)
+	n := revocationNet(t, id)
+
+	if _, err := n.Revoke(mustRevocation(t, key, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewContinuity(key, thiefKey, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.learnSuccession(*c)
+
+	if next := n.Successions.Successor(id); next != id {
+		t.Errorf("revoked key succeeded by %s", next)
+	}
+	if n.Gater.Allows(thief, nil) {
+		t.Error("successor of a revoked key allowed")
+	}
+}
+
+// TestSuccessionBeforeRevocation learns of a rotation and then of the
+// revocation of the old key: it stands when the node kept it before the
+// key was revoked, and is undone when the key was revoked before.
+func TestSuccessionBeforeRevocation(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		revoked time.Duration
+		stands  bool
+	}{
+		{"rotated before", time.Hour, true},
+		{"rotated after", -time.Hour, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			key, id := nodeKey(t)
+			nextKey, next := nodeKey(t)
+			n := revocationNet(t, id)
+			var undone []peer.ID
+			n.OnSuccessionUndone(func(old, to peer.ID) {
+				undone = append(undone, old, to)
+			})
+
+			n.learnSuccession(mustContinuity(t, key, nextKey))
+			if !n.Gater.Allows(next, nil) {
+				t.Fatal("successor of an allowed peer not allowed")
+			}
+			if _, err := n.Revoke(mustRevocation(t, key, time.Now().Add(tc.revoked))); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := n.Successions.Successor(id) == next; got != tc.stands {
+				t.Errorf("succession kept = %v, want %v", got, tc.stands)
+			}
+			if got := n.Gater.Allows(next, nil); got != tc.stands {
+				t.Errorf("successor allowed = %v, want %v", got, tc.stands)
+			}
+			if !tc.stands && !slices.Equal(undone, []peer.ID{id, next}) {
+				t.Errorf("undone successions = %v, want %s to %s", undone, id, next)
+			}
+			if n.Gater.Allows(id, nil) {
+				t.Error("revoked peer allowed")
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultProviderTTL is how long DHT nodes keep a provider record
+	// before dropping it.
+	DefaultProviderTTL = 48 * time.Hour
+	// DefaultReprovideInterval refreshes records well before they expire.
+	DefaultReprovideInterval = 12 * time.Hour
+
+	provideTimeout        = time.Minute
+	providerCacheTTL      = 10 * time.Minute
+	initialReprovideDelay = time.Minute
+	maxProviders          = 20
+)
+
+// ContentRouting announces the blocks this node stores and finds the peers
+// that store blocks it wants.
+//
+// Announcements go through a queue so that adding a file never waits on the
+// DHT. A periodic sweep re-announces every stored block whose record is
+// due, which also covers blocks whose first announcement failed.
+type ContentRouting struct {
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	pending  map[cid.Cid]struct{}
+	provided map[cid.Cid]time.Time
+	wake     chan struct{}
+
+	cacheMu sync.Mutex
+	cache   map[cid.Cid]providerEntry
+
+	ContentRoutingOpts
+}
+
+type ContentRoutingOpts struct {
+	Router routing.ContentRouting
+	// Blocks lists what to re-announce. Without it only explicitly
+	// provided blocks are announced, once.
+	Blocks BlockSource
+	// Self is left out of provider lookups.
+	Self peer.ID
+	// ReprovideInterval is how often each record is refreshed. It is kept
+	// below ProviderTTL.
+	ReprovideInterval time.Duration
+	ProviderTTL       time.Duration
+	Logger            *zap.Logger
+}
+
+type providerEntry struct {
+	providers []peer.AddrInfo
+	expires   time.Time
+}
+
+func NewContentRouting(opts ContentRoutingOpts) *ContentRouting {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.ProviderTTL <= 0 {
+		opts.ProviderTTL = DefaultProviderTTL
+	}
+	if opts.ReprovideInterval <= 0 {
+		opts.ReprovideInterval = DefaultReprovideInterval
+	}
+	if opts.ReprovideInterval >= opts.ProviderTTL {
+		opts.Logger.Warn("Reprovide interval exceeds provider TTL, records would lapse",
+			zap.Duration("interval", opts.ReprovideInterval),
+			zap.Duration("ttl", opts.ProviderTTL),
+		)
+		opts.ReprovideInterval = opts.ProviderTTL / 2
+	}
+
+	return &ContentRouting{
+		logger:             opts.Logger,
+		pending:            make(map[cid.Cid]struct{}),
+		provided:           make(map[cid.Cid]time.Time),
+		wake:               make(chan struct{}, 1),
+		cache:              make(map[cid.Cid]providerEntry),
+		ContentRoutingOpts: opts,
+	}
+}
+
+// Start runs the announce queue and the reprovide sweep until ctx is done.
+func (r *ContentRouting) Start(ctx context.Context) {
+	go r.provideLoop(ctx)
+	if r.Blocks != nil {
+		go r.reprovideLoop(ctx)
+	}
+}
+
+// Provide queues cids to be announced.
+func (r *ContentRouting) Provide(cids ...cid.Cid) {
+	if len(cids) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	for _, c := range cids {
+		r.pending[c] = struct{}{}
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// LastProvided returns when c was last announced successfully. Records
+// older than the provider TTL have expired and are not reported.
+func (r *ContentRouting) LastProvided(c cid.Cid) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.provided[c]
+	if !ok || time.Since(t) >= r.ProviderTTL {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// FindProviders looks up peers that announced c. Results are cached
+// briefly since a file's chunks are usually fetched from the same peers.
+func (r *ContentRouting) FindProviders(ctx context.Context, c cid.Cid) ([]peer.AddrInfo, error) {
+	r.cacheMu.Lock()
+	entry, ok := r.cache[c]
+	r.cacheMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.providers, nil
+	}
+
+	var providers []peer.AddrInfo
+	for pi := range r.Router.FindProvidersAsync(ctx, c, maxProviders) {
+		if pi.ID == r.Self {
+			continue
+		}
+		providers = append(providers, pi)
+	}
+	if err := ctx.Err(); err != nil && len(providers) == 0 {
+		return nil, err
+	}
+
+	if len(providers) > 0 {
+		r.cacheMu.Lock()
+		r.cache[c] = providerEntry{providers: providers, expires: time.Now().Add(providerCacheTTL)}
+		r.cacheMu.Unlock()
+	}
+	return providers, nil
+}
+
+func (r *ContentRouting) provideLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		}
+
+		r.mu.Lock()
+		batch := make([]cid.Cid, 0, len(r.pending))
+		for c := range r.pending {
+			batch = append(batch, c)
+		}
+		clear(r.pending)
+		r.mu.Unlock()
+
+		failed := 0
+		for _, c := range batch {
+			if err := r.provide(ctx, c); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				failed++
+				r.logger.Debug("Provide failed", zap.String("cid", c.String()), zap.Error(err))
+			}
+		}
+
+		// Failed blocks are picked up again by the next sweep rather than
+		// retried in a tight loop while the DHT has no peers.
+		if failed > 0 {
+			r.logger.Warn("Some blocks could not be announced",
+				zap.Int("blocks", len(batch)),
+				zap.Int("failed", failed),
+			)
+		}
+	}
+}
+
+func (r *ContentRouting) provide(ctx context.Context, c cid.Cid) error {
+	ctx, cancel := context.WithTimeout(ctx, provideTimeout)
+	defer cancel()
+
+	if err := r.Router.Provide(ctx, c, true); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.provided[c] = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *ContentRouting) reprovideLoop(ctx context.Context) {
+	timer := time.NewTimer(initialReprovideDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if err := r.sweep(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Warn("Reprovide sweep failed", zap.Error(err))
+		}
+		timer.Reset(r.ReprovideInterval / 4)
+	}
+}
+
+// sweep queues every stored block whose record is due and forgets records
+// and cached lookups that are no longer relevant.
+func (r *ContentRouting) sweep(ctx context.Context) error {
+	var (
+		due  []cid.Cid
+		seen = make(map[cid.Cid]struct{})
+		now  = time.Now()
+	)
+
+	r.mu.Lock()
+	last := make(map[cid.Cid]time.Time, len(r.provided))
+	for c, t := range r.provided {
+		last[c] = t
+	}
+	r.mu.Unlock()
+
+	err := r.Blocks.List(ctx, func(c cid.Cid) error {
+		seen[c] = struct{}{}
+		if t, ok := last[c]; !ok || now.Sub(t) >= r.ReprovideInterval {
+			due = append(due, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	for c := range r.provided {
+		if _, ok := seen[c]; !ok {
+			delete(r.provided, c)
+		}
+	}
+	r.mu.Unlock()
+
+	r.cacheMu.Lock()
+	for c, entry := range r.cache {
+		if now.After(entry.expires) {
+			delete(r.cache, c)
+		}
+	}
+	r.cacheMu.Unlock()
+
+	if len(due) > 0 {
+		r.logger.Info("Reproviding blocks", zap.Int("blocks", len(due)))
+		r.Provide(due...)
+	}
+	return nil
+}
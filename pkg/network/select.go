@@ -0,0 +1,115 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/zap"
+)
+
+// SelectProtocol sends everything under a path in a directory tree, such
+// as /photos/2024 under a backup's root, in a single exchange: the
+// responder walks the path and streams the blocks it passes through and
+// the whole tree it ends at, instead of the requester wanting them one
+// directory level at a time.
+//
+// A request is the length prefixed root CID, then the uvarint number of
+// names on the path, each length prefixed, and then the blocks the
+// requester has as in DiffProtocol. The response is that of DiffProtocol,
+// root first.
+const SelectProtocol protocol.ID = "/dfs/select/1.0.0"
+
+const (
+	// maxSelectNames bounds how deep a path may go.
+	maxSelectNames = 256
+	maxSelectName  = 4 << 10
+)
+
+// Selector lists the blocks of the directories from root along the path
+// names, root first, and then those of the tree the path ends at, from
+// the local store only.
+type Selector func(ctx context.Context, root cid.Cid, names []string) ([]cid.Cid, error)
+
+// HandleSelect serves SelectProtocol, walking paths with sel.
+func (n *P2PNetworking) HandleSelect(sel Selector) {
+	n.setPooledHandler(SelectProtocol, func(raw network.Stream) {
+		s := n.Faults.WrapStream(raw)
+		defer s.Close()
+		s.SetDeadline(time.Now().Add(diffTimeout))
+
+		root, names, have, err := readSelectRequest(bufio.NewReader(s))
+		if err != nil {
+			s.Reset()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(n.ctx, diffTimeout)
+		defer cancel()
+
+		list := func(ctx context.Context, root cid.Cid) ([]cid.Cid, error) {
+			return sel(ctx, root, names)
+		}
+		w := bufio.NewWriter(s)
+		if err := n.sendDiff(ctx, s, w, list, root, have); err != nil {
+			n.logger.Debug("Failed to send selection",
+				zap.String("cid", root.String()),
+				zap.Strings("path", names),
+				zap.String("peer", s.Conn().RemotePeer().String()),
+				zap.Error(err),
+			)
+			s.Reset()
+			return
+		}
+		w.Flush()
+	})
+}
+
+func readSelectRequest(r *bufio.Reader) (cid.Cid, []string, map[cid.Cid]bool, error) {
+	root, err := readCID(r)
+	if err != nil {
+		return cid.Undef, nil, nil, err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return cid.Undef, nil, nil, err
+	}
+	if count > maxSelectNames {
+		return cid.Undef, nil, nil, fmt.Errorf("network: path deeper than %d", maxSelectNames)
+	}
+	names := make([]string, 0, count)
+	for range count {
+		name, err := readPrefixed(r, maxSelectName)
+		if err != nil {
+			return cid.Undef, nil, nil, err
+		}
+		names = append(names, string(name))
+	}
+	have, err := readHave(r)
+	return root, names, have, err
+}
+
+// FetchSelect asks p for the blocks from root along the path names and
+// of the tree it ends at, less those in have, and passes each to put once
+// verified. A peer that doesn't have all of them fails it with
+// ErrDiffUnavailable.
+func (n *P2PNetworking) FetchSelect(ctx context.Context, p peer.ID, root cid.Cid, names []string, have []cid.Cid, put func(ctx context.Context, c cid.Cid, data []byte) error) (DiffStats, error) {
+	if len(names) > maxSelectNames {
+		return DiffStats{}, fmt.Errorf("network: path deeper than %d", maxSelectNames)
+	}
+	return n.fetchBlocks(ctx, p, SelectProtocol, put, func(w *bufio.Writer) {
+		writePrefixed(w, root.Bytes())
+		var buf [binary.MaxVarintLen64]byte
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(names)))])
+		for _, name := range names {
+			writePrefixed(w, []byte(name))
+		}
+		writeHave(w, have)
+	})
+}
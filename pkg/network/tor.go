@@ -0,0 +1,181 @@
+package network
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// torControlTimeout bounds setting up the onion service.
+const torControlTimeout = 30 * time.Second
+
+// onionService is an onion service Tor runs for the node, forwarding to
+// its loopback listener. It lasts as long as the control connection.
+type onionService struct {
+	conn *textproto.Conn
+	addr multiaddr.Multiaddr
+}
+
+// startOnion asks Tor, over its control port, to forward an onion service
+// on port to 127.0.0.1:port. The service key is kept in keyPath so the
+// address stays the same across restarts; without one, every start gets
+// a new address.
+func startOnion(control, password, keyPath string, port int) (*onionService, error) {
+	nc, err := net.DialTimeout("tcp", control, torControlTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("tor control: %w", err)
+	}
+	nc.SetDeadline(time.Now().Add(torControlTimeout))
+	c := textproto.NewConn(nc)
+
+	svc, err := addOnion(c, password, keyPath, port)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("tor control: %w", err)
+	}
+	nc.SetDeadline(time.Time{})
+	return svc, nil
+}
+
+func addOnion(c *textproto.Conn, password, keyPath string, port int) (*onionService, error) {
+	if err := torAuthenticate(c, password); err != nil {
+		return nil, err
+	}
+
+	keySpec := "NEW:ED25519-V3"
+	if keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		switch {
+		case err == nil:
+			keySpec = strings.TrimSpace(string(data))
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	msg, err := torCmd(c, "ADD_ONION %s Port=%d,127.0.0.1:%d", keySpec, port, port)
+	if err != nil {
+		return nil, err
+	}
+	var id, key string
+	for _, line := range strings.Split(msg, "\n") {
+		if v, ok := strings.CutPrefix(line, "ServiceID="); ok {
+			id = v
+		}
+		if v, ok := strings.CutPrefix(line, "PrivateKey="); ok {
+			key = v
+		}
+	}
+	if id == "" {
+		return nil, fmt.Errorf("ADD_ONION returned no service ID")
+	}
+	// Tor only returns the key when it made a new one
+	if key != "" && keyPath != "" {
+		if err := writeOnionKey(keyPath, key); err != nil {
+			return nil, err
+		}
+	}
+
+	addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/onion3/%s:%d", id, port))
+	if err != nil {
+		return nil, err
+	}
+	return &onionService{conn: c, addr: addr}, nil
+}
+
+// torAuthenticate logs in with the password when given, and otherwise with
+// the cookie file or no credentials, as Tor offers.
+func torAuthenticate(c *textproto.Conn, password string) error {
+	if password != "" {
+		_, err := torCmd(c, "AUTHENTICATE %s", strconv.Quote(password))
+		return err
+	}
+
+	msg, err := torCmd(c, "PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+	var methods, cookieFile string
+	for _, line := range strings.Split(msg, "\n") {
+		rest, ok := strings.CutPrefix(line, "AUTH ")
+		if !ok {
+			continue
+		}
+		methods, rest, _ = strings.Cut(strings.TrimPrefix(rest, "METHODS="), " ")
+		if v, ok := strings.CutPrefix(rest, "COOKIEFILE="); ok {
+			if cookieFile, err = strconv.Unquote(v); err != nil {
+				return fmt.Errorf("bad cookie file %s", v)
+			}
+		}
+	}
+
+	for _, m := range strings.Split(methods, ",") {
+		switch m {
+		case "NULL":
+			_, err := torCmd(c, "AUTHENTICATE")
+			return err
+		case "COOKIE":
+			cookie, err := os.ReadFile(cookieFile)
+			if err != nil {
+				return err
+			}
+			_, err = torCmd(c, "AUTHENTICATE %s", hex.EncodeToString(cookie))
+			return err
+		}
+	}
+	return fmt.Errorf("no supported authentication method in %q; set a control password", methods)
+}
+
+// torCmd sends a command and returns the reply lines of a 250 reply.
+func torCmd(c *textproto.Conn, format string, args ...any) (string, error) {
+	if err := c.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	_, msg, err := c.ReadResponse(250)
+	return msg, err
+}
+
+func writeOnionKey(path, key string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(path, []byte(key+"\n"), 0600)
+}
+
+// wait returns once Tor closes the control connection, which takes the
+// onion service down with it.
+func (s *onionService) wait() error {
+	for {
+		if _, err := s.conn.ReadLine(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *onionService) Close() error {
+	return s.conn.Close()
+}
+
+// watchOnion warns when Tor drops the onion service before the node
+// closes. Tor doesn't restore it; the daemon has to be restarted.
+func (n *P2PNetworking) watchOnion() {
+	err := n.onion.wait()
+	if n.ctx.Err() == nil {
+		n.logger.Warn("Lost the Tor control connection, the onion service is down until restart",
+			zap.String("onion", n.onion.addr.String()),
+			zap.Error(err),
+		)
+	}
+}
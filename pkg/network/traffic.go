@@ -0,0 +1,120 @@
+package network
+
+import (
+	"time"
+
+	lpmetrics "github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Peers idle for trafficIdle are dropped from the per-peer counts every
+// trafficTrimInterval, so they don't grow with every peer ever seen.
+const (
+	trafficIdle         = time.Hour
+	trafficTrimInterval = 10 * time.Minute
+)
+
+// TrafficStats is the data exchanged, in bytes, and the current rates, in
+// bytes per second.
+type TrafficStats struct {
+	TotalIn  int64
+	TotalOut int64
+	RateIn   float64
+	RateOut  float64
+}
+
+// Traffic is everything exchanged with peers since the node started, in
+// total and broken down by peer and protocol. Traffic outside streams,
+// such as connection handshakes, only counts towards the total.
+type Traffic struct {
+	Total     TrafficStats
+	Peers     map[peer.ID]TrafficStats
+	Protocols map[protocol.ID]TrafficStats
+}
+
+// Traffic reports the data exchanged with peers. The counts are updated
+// every second, so they lag slightly behind.
+func (n *P2PNetworking) Traffic() Traffic {
+	t := Traffic{
+		Total:     trafficStats(n.traffic.GetBandwidthTotals()),
+		Peers:     make(map[peer.ID]TrafficStats),
+		Protocols: make(map[protocol.ID]TrafficStats),
+	}
+	for p, s := range n.traffic.GetBandwidthByPeer() {
+		t.Peers[p] = trafficStats(s)
+	}
+	for proto, s := range n.traffic.GetBandwidthByProtocol() {
+		t.Protocols[proto] = trafficStats(s)
+	}
+	return t
+}
+
+// trimTraffic forgets peers that have been idle for a while.
+func (n *P2PNetworking) trimTraffic() {
+	ticker := time.NewTicker(trafficTrimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			n.traffic.TrimIdle(time.Now().Add(-trafficIdle))
+		}
+	}
+}
+
+func trafficStats(s lpmetrics.Stats) TrafficStats {
+	return TrafficStats{TotalIn: s.TotalIn, TotalOut: s.TotalOut, RateIn: s.RateIn, RateOut: s.RateOut}
+}
+
+var (
+	protocolBytesDesc = prometheus.NewDesc("dfs_network_protocol_bytes_total",
+		"Bytes exchanged with peers, by protocol and direction.", []string{"protocol", "direction"}, nil)
+	protocolRateDesc = prometheus.NewDesc("dfs_network_protocol_rate_bytes",
+		"Current rate of exchange with peers in bytes per second, by protocol and direction.", []string{"protocol", "direction"}, nil)
+	peerBytesDesc = prometheus.NewDesc("dfs_network_peer_bytes_total",
+		"Bytes exchanged with recently active peers, by peer and direction.", []string{"peer", "direction"}, nil)
+	peerRateDesc = prometheus.NewDesc("dfs_network_peer_rate_bytes",
+		"Current rate of exchange with recently active peers in bytes per second, by peer and direction.", []string{"peer", "direction"}, nil)
+	totalBytesDesc = prometheus.NewDesc("dfs_network_bytes_total",
+		"Bytes exchanged with peers, by direction.", []string{"direction"}, nil)
+)
+
+// TrafficCollector exports Traffic as Prometheus metrics.
+func (n *P2PNetworking) TrafficCollector() prometheus.Collector {
+	return trafficCollector{n}
+}
+
+type trafficCollector struct {
+	n *P2PNetworking
+}
+
+func (c trafficCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- protocolBytesDesc
+	ch <- protocolRateDesc
+	ch <- peerBytesDesc
+	ch <- peerRateDesc
+	ch <- totalBytesDesc
+}
+
+func (c trafficCollector) Collect(ch chan<- prometheus.Metric) {
+	t := c.n.Traffic()
+	collect := func(bytes, rate *prometheus.Desc, s TrafficStats, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(bytes, prometheus.CounterValue, float64(s.TotalIn), append(labels, "in")...)
+		ch <- prometheus.MustNewConstMetric(bytes, prometheus.CounterValue, float64(s.TotalOut), append(labels, "out")...)
+		if rate != nil {
+			ch <- prometheus.MustNewConstMetric(rate, prometheus.GaugeValue, s.RateIn, append(labels, "in")...)
+			ch <- prometheus.MustNewConstMetric(rate, prometheus.GaugeValue, s.RateOut, append(labels, "out")...)
+		}
+	}
+
+	collect(totalBytesDesc, nil, t.Total)
+	for proto, s := range t.Protocols {
+		collect(protocolBytesDesc, protocolRateDesc, s, string(proto))
+	}
+	for p, s := range t.Peers {
+		collect(peerBytesDesc, peerRateDesc, s, p.String())
+	}
+}
@@ -0,0 +1,629 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/health"
+	"github.com/Noah-Wilderom/dfs/pkg/metrics"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	msmux "github.com/multiformats/go-multistream"
+	"go.uber.org/zap"
+)
+
+// WantProtocol exchanges blocks over one stream per peer, kept open while
+// blocks are wanted from it. The requester writes entries, each a kind
+// byte followed by a length prefixed CID, that want a block or cancel an
+// earlier want. The responder answers every want that wasn't cancelled in
+// time, in the order they came in, with a status byte, the length prefixed
+// CID and, when it has the block, the length prefixed block.
+const WantProtocol protocol.ID = "/dfs/want/1.0.0"
+
+const (
+	entryWant   byte = 0
+	entryCancel byte = 1
+
+	// maxWantlist bounds the wants a peer may have outstanding with us.
+	maxWantlist = 1024
+	// wantIdleTimeout closes a stream nothing was wanted on for that long.
+	// The responder waits longer before giving up on a quiet requester.
+	wantIdleTimeout  = 30 * time.Second
+	wantReadDeadline = 2 * wantIdleTimeout
+)
+
+// errNoWantProtocol is returned for peers that only serve BlockProtocol.
+var errNoWantProtocol = errors.New("network: peer doesn't speak the want protocol")
+
+// wantlist is what a peer wants from us over one stream.
+type wantlist struct {
+	mu     sync.Mutex
+	queue  []cid.Cid
+	wanted map[cid.Cid]bool
+	closed bool
+	wake   chan struct{}
+}
+
+func (n *P2PNetworking) handleWantStream(raw network.Stream) {
+	s := n.Faults.WrapStream(raw)
+	defer s.Close()
+
+	wl := &wantlist{wanted: make(map[cid.Cid]bool), wake: make(chan struct{}, 1)}
+	go func() {
+		if err := wl.read(s); err != nil {
+			s.Reset()
+		}
+	}()
+
+	w := bufio.NewWriter(s)
+	for {
+		c, ok := wl.next(n.ctx)
+		if !ok {
+			return
+		}
+		if err := n.answerWant(s, w, c); err != nil {
+			s.Reset()
+			return
+		}
+	}
+}
+
+// read adds and removes wants until the requester stops writing. Either
+// way the wants already queued are still answered.
+func (wl *wantlist) read(s network.Stream) error {
+	defer wl.close()
+
+	r := bufio.NewReader(s)
+	for {
+		s.SetReadDeadline(time.Now().Add(wantReadDeadline))
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil
+		}
+		c, err := readCID(r)
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case entryWant:
+			if err := wl.add(c); err != nil {
+				return err
+			}
+		case entryCancel:
+			wl.cancel(c)
+		default:
+			return fmt.Errorf("network: unknown want entry %d", kind)
+		}
+	}
+}
+
+func (wl *wantlist) add(c cid.Cid) error {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	if wl.wanted[c] {
+		return nil
+	}
+	if len(wl.queue) >= maxWantlist {
+		// Drop the cancelled wants still queued before giving up
+		queue := wl.queue[:0]
+		for _, q := range wl.queue {
+			if wl.wanted[q] {
+				queue = append(queue, q)
+			}
+		}
+		wl.queue = queue
+		if len(wl.queue) >= maxWantlist {
+			return fmt.Errorf("network: peer wants more than %d blocks", maxWantlist)
+		}
+	}
+	wl.wanted[c] = true
+	wl.queue = append(wl.queue, c)
+	wl.notify()
+	return nil
+}
+
+func (wl *wantlist) cancel(c cid.Cid) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	delete(wl.wanted, c)
+}
+
+func (wl *wantlist) close() {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	wl.closed = true
+	wl.notify()
+}
+
+// notify wakes next. Callers hold wl.mu.
+func (wl *wantlist) notify() {
+	select {
+	case wl.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest want still standing, waiting for one if there
+// is none. It returns false once the requester is done and every want is
+// answered.
+func (wl *wantlist) next(ctx context.Context) (cid.Cid, bool) {
+	for {
+		wl.mu.Lock()
+		for len(wl.queue) > 0 {
+			c := wl.queue[0]
+			wl.queue = wl.queue[1:]
+			if wl.wanted[c] {
+				delete(wl.wanted, c)
+				wl.mu.Unlock()
+				return c, true
+			}
+		}
+		closed := wl.closed
+		wl.mu.Unlock()
+		if closed {
+			return cid.Undef, false
+		}
+
+		select {
+		case <-ctx.Done():
+			return cid.Undef, false
+		case <-wl.wake:
+		}
+	}
+}
+
+// answerWant sends the block c, or tells the requester we don't have it.
+func (n *P2PNetworking) answerWant(s network.Stream, w *bufio.Writer, c cid.Cid) error {
+	ctx, cancel := context.WithTimeout(n.ctx, blockStreamTimeout)
+	data, err := n.Blocks.Get(ctx, c)
+	cancel()
+	if err == nil && n.Faults.DropBlock() {
+		err = storage.ErrNotFound
+	}
+	status := blockOK
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			n.logger.Warn("Failed to serve block", zap.String("cid", c.String()), zap.Error(err))
+		}
+		status, data = blockNotFound, nil
+	}
+	n.Events.Record(eventlog.Event{
+		Type:   eventlog.WantServed,
+		Peer:   s.Conn().RemotePeer().String(),
+		Fields: map[string]string{"cid": c.String(), "found": fmt.Sprint(status == blockOK)},
+	})
+	if err := n.shaper.sent(n.ctx, s.Conn().RemotePeer(), len(data)); err != nil {
+		return err
+	}
+
+	s.SetWriteDeadline(time.Now().Add(blockStreamTimeout))
+	w.WriteByte(status)
+	writePrefixed(w, c.Bytes())
+	if status == blockOK {
+		writePrefixed(w, data)
+	}
+	return w.Flush()
+}
+
+func writePrefixed(w *bufio.Writer, data []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(data)))])
+	w.Write(data)
+}
+
+// wantManager keeps the blocks this node wants and the streams it wants
+// them over. A block is wanted once per peer however many fetches wait for
+// it, and the first copy to arrive from any peer answers all of them.
+type wantManager struct {
+	n *P2PNetworking
+
+	mu      sync.Mutex
+	blocks  map[cid.Cid]*wantedBlock
+	streams map[peer.ID]*wantStream
+	// legacy peers only serve BlockProtocol.
+	legacy map[peer.ID]bool
+}
+
+// wantedBlock is a block one or more fetches wait for.
+type wantedBlock struct {
+	// done is closed once the block arrived, from from.
+	done  chan struct{}
+	data  []byte
+	from  peer.ID
+	peers map[peer.ID]*peerWant
+}
+
+// peerWant is a block wanted from one peer.
+type peerWant struct {
+	waiters int
+	// done is closed with err set when the peer can't deliver.
+	done chan struct{}
+	err  error
+	// stream the want went out on, once sent.
+	stream *wantStream
+	// ctx ends when nobody waits for the block from this peer anymore.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// wantStream is the stream wants go out on to one peer.
+type wantStream struct {
+	peer peer.ID
+	s    network.Stream
+	wmu  sync.Mutex
+
+	// Guarded by the manager's mu: the wants sent and not yet answered,
+	// and whether the stream broke.
+	pending int
+	dead    bool
+	idle    *time.Timer
+}
+
+func newWantManager(n *P2PNetworking) *wantManager {
+	return &wantManager{
+		n:       n,
+		blocks:  make(map[cid.Cid]*wantedBlock),
+		streams: make(map[peer.ID]*wantStream),
+		legacy:  make(map[peer.ID]bool),
+	}
+}
+
+// WantBlock asks p for the block c and returns it along with the peer
+// that sent it. While c is wanted from p, asking again waits for the same
+// answer instead of sending another request, and once c arrives from any
+// peer everyone waiting for it gets it. Peers that only serve
+// BlockProtocol are asked with FetchBlock.
+func (n *P2PNetworking) WantBlock(ctx context.Context, p peer.AddrInfo, c cid.Cid) (data []byte, from peer.ID, err error) {
+	defer func() {
+		// A peer that lacks the block still answered
+		if errors.Is(err, ErrBlockNotFound) {
+			n.Health.Record(ctx, health.Fetch, nil)
+		} else {
+			n.Health.Record(ctx, health.Fetch, err)
+		}
+	}()
+
+	m := n.wants
+
+	m.mu.Lock()
+	b := m.blocks[c]
+	if b == nil {
+		b = &wantedBlock{done: make(chan struct{}), peers: make(map[peer.ID]*peerWant)}
+		m.blocks[c] = b
+	}
+	pw := b.peers[p.ID]
+	first := pw == nil
+	if first {
+		pw = &peerWant{done: make(chan struct{})}
+		pw.ctx, pw.cancel = context.WithCancel(n.ctx)
+		b.peers[p.ID] = pw
+	} else {
+		metrics.SharedWants.Inc()
+	}
+	pw.waiters++
+	m.mu.Unlock()
+
+	if first {
+		n.Events.Record(eventlog.Event{Type: eventlog.WantSent, Peer: p.ID.String(), Fields: map[string]string{"cid": c.String()}})
+		go m.ask(p, c, pw)
+	}
+
+	select {
+	case <-b.done:
+		return b.data, b.from, nil
+	case <-pw.done:
+		select {
+		case <-b.done:
+			return b.data, b.from, nil
+		default:
+		}
+		return nil, "", pw.err
+	case <-ctx.Done():
+		m.release(c, p.ID, b, pw)
+		return nil, "", ctx.Err()
+	}
+}
+
+// ask sends the want for c to p.
+func (m *wantManager) ask(p peer.AddrInfo, c cid.Cid, pw *peerWant) {
+	// A stream may close for idling just as it is picked up; one other
+	// try gets a new one.
+	for try := 0; ; try++ {
+		ws, err := m.stream(pw.ctx, p)
+		if errors.Is(err, errNoWantProtocol) {
+			data, err := m.n.FetchBlock(pw.ctx, p, c)
+			if err != nil {
+				m.fail(c, p.ID, nil, err)
+				return
+			}
+			m.deliver(c, p.ID, data)
+			return
+		}
+		if err != nil {
+			m.fail(c, p.ID, nil, err)
+			return
+		}
+
+		m.mu.Lock()
+		switch {
+		case pw.ctx.Err() != nil:
+			// Nobody waits anymore
+			m.mu.Unlock()
+			return
+		case ws.dead:
+			m.mu.Unlock()
+			if try == 0 {
+				continue
+			}
+			m.fail(c, p.ID, nil, errors.New("network: want stream closed"))
+			return
+		}
+		pw.stream = ws
+		ws.pending++
+		ws.idle.Stop()
+		m.mu.Unlock()
+
+		if err := ws.send(entryWant, c); err != nil {
+			ws.s.Reset()
+			m.fail(c, p.ID, ws, err)
+		}
+		return
+	}
+}
+
+// stream returns the want stream to p, opening one if needed.
+func (m *wantManager) stream(ctx context.Context, p peer.AddrInfo) (*wantStream, error) {
+	m.mu.Lock()
+	ws, legacy := m.streams[p.ID], m.legacy[p.ID]
+	m.mu.Unlock()
+	switch {
+	case legacy:
+		return nil, errNoWantProtocol
+	case ws != nil:
+		return ws, nil
+	}
+
+	if err := m.n.dial(ctx, p); err != nil {
+		return nil, err
+	}
+	raw, err := m.n.host.NewStream(ctx, p.ID, WantProtocol)
+	if errors.Is(err, msmux.ErrNotSupported[protocol.ID]{}) {
+		m.mu.Lock()
+		m.legacy[p.ID] = true
+		m.mu.Unlock()
+		return nil, errNoWantProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ws = &wantStream{peer: p.ID, s: m.n.Faults.WrapStream(raw)}
+	m.mu.Lock()
+	if other := m.streams[p.ID]; other != nil {
+		// Lost a race with another want for this peer
+		m.mu.Unlock()
+		raw.Reset()
+		return other, nil
+	}
+	m.streams[p.ID] = ws
+	ws.idle = time.AfterFunc(wantIdleTimeout, func() { m.closeIdle(ws) })
+	m.mu.Unlock()
+
+	go m.read(ws)
+	return ws, nil
+}
+
+// read takes the answers to the wants sent over ws until it closes.
+func (m *wantManager) read(ws *wantStream) {
+	r := bufio.NewReader(ws.s)
+	for {
+		status, err := r.ReadByte()
+		if err != nil {
+			m.closeStream(ws, fmt.Errorf("network: want stream closed: %w", err))
+			return
+		}
+		c, err := readCID(r)
+		if err != nil {
+			ws.s.Reset()
+			m.closeStream(ws, err)
+			return
+		}
+
+		switch status {
+		case blockOK:
+			data, err := readPrefixed(r, storage.MaxBlockSize)
+			if err != nil {
+				ws.s.Reset()
+				m.closeStream(ws, err)
+				return
+			}
+			if err := m.n.shaper.received(m.n.ctx, ws.peer, len(data)); err != nil {
+				ws.s.Reset()
+				m.closeStream(ws, err)
+				return
+			}
+			if err := storage.Verify(c, data); err != nil {
+				m.fail(c, ws.peer, ws, fmt.Errorf("block %s from %s: %w", c, ws.peer, err))
+				continue
+			}
+			m.deliver(c, ws.peer, data)
+		case blockNotFound:
+			m.fail(c, ws.peer, ws, ErrBlockNotFound)
+		default:
+			ws.s.Reset()
+			m.closeStream(ws, fmt.Errorf("network: unexpected block status %d", status))
+			return
+		}
+	}
+}
+
+// deliver hands the block c, sent by from, to everyone waiting for it and
+// cancels the wants for it still out at other peers.
+func (m *wantManager) deliver(c cid.Cid, from peer.ID, data []byte) {
+	m.mu.Lock()
+	b := m.blocks[c]
+	if b == nil {
+		m.mu.Unlock()
+		// Already arrived from another peer, or nobody waits anymore
+		metrics.DuplicateBlocks.Inc()
+		return
+	}
+	delete(m.blocks, c)
+	b.data, b.from = data, from
+
+	var cancels []*wantStream
+	for id, pw := range b.peers {
+		if ws := pw.stream; ws != nil && id != from {
+			cancels = append(cancels, ws)
+		}
+		m.unpend(pw)
+		pw.cancel()
+		if id == from {
+			m.recordDone(c, id, eventlog.WantReceived)
+		} else {
+			m.recordDone(c, id, eventlog.WantCancelled)
+		}
+	}
+	close(b.done)
+	m.mu.Unlock()
+
+	for _, ws := range cancels {
+		ws.send(entryCancel, c)
+	}
+}
+
+// fail tells the fetches waiting for c from p that it can't deliver.
+// With ws set, only a want sent over ws is failed.
+func (m *wantManager) fail(c cid.Cid, p peer.ID, ws *wantStream, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.blocks[c]
+	if b == nil {
+		return
+	}
+	pw := b.peers[p]
+	if pw == nil || (ws != nil && pw.stream != ws) {
+		return
+	}
+	m.drop(c, p, b, pw)
+	if errors.Is(err, ErrBlockNotFound) {
+		m.recordDone(c, p, eventlog.WantNotFound)
+	} else {
+		m.recordDone(c, p, eventlog.WantFailed)
+	}
+	pw.err = err
+	close(pw.done)
+}
+
+// release gives up waiting for c from p, cancelling the want once nobody
+// waits for it anymore.
+func (m *wantManager) release(c cid.Cid, p peer.ID, b *wantedBlock, pw *peerWant) {
+	m.mu.Lock()
+	pw.waiters--
+	if pw.waiters > 0 || b.peers[p] != pw {
+		m.mu.Unlock()
+		return
+	}
+	ws := pw.stream
+	m.drop(c, p, b, pw)
+	m.recordDone(c, p, eventlog.WantCancelled)
+	m.mu.Unlock()
+
+	if ws != nil {
+		ws.send(entryCancel, c)
+	}
+}
+
+// drop forgets the want for c from p. Callers hold m.mu.
+func (m *wantManager) drop(c cid.Cid, p peer.ID, b *wantedBlock, pw *peerWant) {
+	delete(b.peers, p)
+	if len(b.peers) == 0 && m.blocks[c] == b {
+		delete(m.blocks, c)
+	}
+	m.unpend(pw)
+	pw.cancel()
+}
+
+// unpend counts the want as answered on its stream, which is closed after
+// idling with nothing pending. Callers hold m.mu.
+func (m *wantManager) unpend(pw *peerWant) {
+	ws := pw.stream
+	if ws == nil {
+		return
+	}
+	pw.stream = nil
+	ws.pending--
+	if ws.pending == 0 && !ws.dead {
+		ws.idle.Reset(wantIdleTimeout)
+	}
+}
+
+// closeIdle ends ws when nothing was wanted over it for a while. The peer
+// finishes its answers and closes its side, which ends read.
+func (m *wantManager) closeIdle(ws *wantStream) {
+	m.mu.Lock()
+	if ws.pending > 0 || m.streams[ws.peer] != ws {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.streams, ws.peer)
+	ws.dead = true
+	m.mu.Unlock()
+	ws.s.CloseWrite()
+}
+
+// closeStream fails every want still pending on ws.
+func (m *wantManager) closeStream(ws *wantStream, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ws.dead = true
+	ws.idle.Stop()
+	if m.streams[ws.peer] == ws {
+		delete(m.streams, ws.peer)
+	}
+	for c, b := range m.blocks {
+		pw := b.peers[ws.peer]
+		if pw == nil || pw.stream != ws {
+			continue
+		}
+		m.drop(c, ws.peer, b, pw)
+		m.recordDone(c, ws.peer, eventlog.WantFailed)
+		pw.err = err
+		close(pw.done)
+	}
+	ws.s.Close()
+}
+
+// recordDone records how the want for c from p ended.
+func (m *wantManager) recordDone(c cid.Cid, p peer.ID, result string) {
+	m.n.Events.Record(eventlog.Event{
+		Type:   eventlog.WantDone,
+		Peer:   p.String(),
+		Fields: map[string]string{"cid": c.String(), "result": result},
+	})
+}
+
+// send writes a want or cancel entry.
+func (ws *wantStream) send(kind byte, c cid.Cid) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	key := c.Bytes()
+	entry := binary.AppendUvarint([]byte{kind}, uint64(len(key)))
+	ws.s.SetWriteDeadline(time.Now().Add(blockStreamTimeout))
+	_, err := ws.s.Write(append(entry, key...))
+	return err
+}
@@ -0,0 +1,89 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"go.uber.org/zap"
+)
+
+// The wants a node sends and serves are recorded, and replaying the
+// recording accounts for every one of them.
+func TestWantEventsReplay(t *testing.T) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	blocks := storage.NewMemBlockstore()
+	held, err := storage.NewRawBlock([]byte("held"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := storage.NewRawBlock([]byte("missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blocks.Put(context.Background(), held.CID, held.Data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only node 0 holds the block
+	sources := []BlockSource{blocks, noBlocks{}}
+	logs := make([]*bytes.Buffer, 2)
+	nets := make([]*P2PNetworking, 2)
+	for i := range nets {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs[i] = &bytes.Buffer{}
+		nets[i] = NewP2PNetworking(P2PNetworkingOpts{
+			Blocks:     sources[i],
+			CustomHost: h,
+			Events:     eventlog.NewRecorder(logs[i]),
+			Logger:     zap.NewNop(),
+		})
+		if err := nets[i].Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nets[i].Close() })
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := peer.AddrInfo{ID: nets[0].Host().ID(), Addrs: nets[0].Host().Addrs()}
+	if _, _, err := nets[1].WantBlock(ctx, server, held.CID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := nets[1].WantBlock(ctx, server, missing.CID); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("WantBlock of a missing block = %v, want ErrBlockNotFound", err)
+	}
+
+	client := eventlog.NewWantTable()
+	if err := eventlog.Replay(logs[1], client); err != nil {
+		t.Fatal(err)
+	}
+	if client.PendingCount() != 0 || client.Results[eventlog.WantReceived] != 1 || client.Results[eventlog.WantNotFound] != 1 {
+		t.Errorf("client replayed %d pending, results %v", client.PendingCount(), client.Results)
+	}
+	if len(client.Anomalies) != 0 {
+		t.Errorf("anomalies: %v", client.Anomalies)
+	}
+
+	served := eventlog.NewWantTable()
+	if err := eventlog.Replay(logs[0], served); err != nil {
+		t.Fatal(err)
+	}
+	if served.Served != 1 || served.Missing != 1 {
+		t.Errorf("server replayed %d served, %d missing, want 1 and 1", served.Served, served.Missing)
+	}
+}
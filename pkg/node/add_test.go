@@ -0,0 +1,114 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+)
+
+// openNode runs a node without networking on a fresh store.
+func openNode(t *testing.T, params chunking.Params) (*Node, *storage.FSBlockstore) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	pins, err := pin.Open(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewNode(NodeOpts{Store: store, Pins: pins, Chunking: params}), store
+}
+
+// A file has the same address however the node adding it keeps its
+// chunks.
+func TestAddCompressionKeepsCID(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("compressible text "), 1000)
+
+	plain, _ := openNode(t, chunking.Params{Strategy: chunking.StrategyFixed, Size: 4096})
+	compressed, store := openNode(t, chunking.Params{Strategy: chunking.StrategyFixed, Size: 4096, Compression: storage.CompressionZstd})
+
+	want, err := plain.Add(ctx, bytes.NewReader(data), AddOptions{Name: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := compressed.Add(ctx, bytes.NewReader(data), AddOptions{Name: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CID != want.CID {
+		t.Errorf("CID = %s, want %s", got.CID, want.CID)
+	}
+	if !bytes.Equal(got.Manifest.Root, want.Manifest.Root) {
+		t.Errorf("root = %x, want %x", got.Manifest.Root, want.Manifest.Root)
+	}
+
+	codec, err := store.Compression(ctx, got.Manifest.Chunks[0].CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codec != storage.CompressionZstd {
+		t.Errorf("chunk kept with %q, want %q", codec, storage.CompressionZstd)
+	}
+}
+
+// Adding a file again over its base takes the unchanged chunks over, and
+// ends up with the CID a fresh add gives.
+func TestAddBase(t *testing.T) {
+	ctx := context.Background()
+	params := chunking.Params{Strategy: chunking.StrategyFastCDC, Size: 4096, Align: 512}
+	n, _ := openNode(t, params)
+	index, err := OpenChunkIndex(filepath.Join(t.TempDir(), "chunk-index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.ChunkIndex = index
+
+	data := make([]byte, 64<<10)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	copy(data[1000:], "some text to vary the pages")
+	base, err := n.Add(ctx, bytes.NewReader(data), AddOptions{Name: "db.sqlite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite a page in place and append one
+	changed := append(bytes.Clone(data), bytes.Repeat([]byte{9}, 512)...)
+	copy(changed[20*512:], bytes.Repeat([]byte{1}, 512))
+	res, err := n.Add(ctx, bytes.NewReader(changed), AddOptions{Name: "db.sqlite", Base: base.CID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.ReusedChunks == 0 || res.Stats.ReusedChunks > len(res.Manifest.Chunks)-2 {
+		t.Errorf("reused %d of %d chunks, want all but those around the changes", res.Stats.ReusedChunks, len(res.Manifest.Chunks))
+	}
+
+	fresh, _ := openNode(t, params)
+	want, err := fresh.Add(ctx, bytes.NewReader(changed), AddOptions{Name: "db.sqlite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CID != want.CID {
+		t.Errorf("CID over the base = %s, fresh add = %s", res.CID, want.CID)
+	}
+
+	// Without the index the file is added whole
+	n.ChunkIndex = nil
+	res, err = n.Add(ctx, bytes.NewReader(changed), AddOptions{Name: "db.sqlite", Base: base.CID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.ReusedChunks != 0 || res.CID != want.CID {
+		t.Errorf("without an index: reused %d chunks, CID %s", res.Stats.ReusedChunks, res.CID)
+	}
+}
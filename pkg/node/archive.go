@@ -0,0 +1,183 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/coldstore"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrArchived is returned when reading or pinning again a pin whose
+	// blocks are in the cold store.
+	ErrArchived = errors.New("node: pin is archived, recall it first")
+	// ErrNotArchived is returned when recalling a pin that isn't archived.
+	ErrNotArchived = errors.New("node: pin is not archived")
+)
+
+// TierReport describes a pin moved between the hot and the cold store.
+type TierReport struct {
+	// Blocks and Bytes count the blocks copied, Present those the
+	// destination had already.
+	Blocks  int
+	Bytes   int64
+	Present int
+}
+
+// ArchivePin copies every block of the pin c to the cold store and marks
+// it archived. Its blocks then no longer keep it in the hot store: a
+// garbage collection evicts those no other pin holds, and the node stops
+// serving them. The pin must be stored in full.
+func (n *Node) ArchivePin(ctx context.Context, c cid.Cid) (*TierReport, error) {
+	p, err := n.tierPin(c)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Archived.IsZero() {
+		return &TierReport{}, nil
+	}
+
+	blocks, err := dagOf(ctx, n.store, c)
+	if err != nil {
+		return nil, fmt.Errorf("%w; pin it again to fetch what is missing", err)
+	}
+	report, err := copyBlocks(ctx, blocks, n.store, n.ColdStore)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.Pins.SetArchived(c, time.Now()); err != nil {
+		return nil, err
+	}
+	n.logger.Info("Archived",
+		zap.String("cid", c.String()),
+		zap.Int("blocks", report.Blocks),
+		zap.Int64("bytes", report.Bytes),
+	)
+	return report, nil
+}
+
+// RecallPin copies the blocks of the archived pin c the hot store lacks
+// back from the cold store, and marks it as stored again. The cold store
+// keeps its copy, so archiving the pin again copies nothing.
+func (n *Node) RecallPin(ctx context.Context, c cid.Cid) (*TierReport, error) {
+	p, err := n.tierPin(c)
+	if err != nil {
+		return nil, err
+	}
+	if p.Archived.IsZero() {
+		return nil, fmt.Errorf("%s: %w", c, ErrNotArchived)
+	}
+	// Recalled blocks aren't pinned until the recall is done
+	defer n.Pins.Pinning()()
+
+	blocks, err := dagOf(ctx, n.ColdStore, c)
+	if err != nil {
+		return nil, fmt.Errorf("cold store: %w", err)
+	}
+	report, err := copyBlocks(ctx, blocks, n.ColdStore, n.store)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.Pins.SetArchived(c, time.Time{}); err != nil {
+		return nil, err
+	}
+	if n.network != nil {
+		n.network.Provide(blocks...)
+	}
+	n.logger.Info("Recalled",
+		zap.String("cid", c.String()),
+		zap.Int("blocks", report.Blocks),
+		zap.Int64("bytes", report.Bytes),
+	)
+	return report, nil
+}
+
+// tierPin returns the pin of c, to move between the stores.
+func (n *Node) tierPin(c cid.Cid) (pin.Pin, error) {
+	if n.ColdStore == nil {
+		return pin.Pin{}, coldstore.ErrNotConfigured
+	}
+	if n.Pins == nil {
+		return pin.Pin{}, fmt.Errorf("node has no pin set")
+	}
+	p, ok := n.Pins.Get(c)
+	if !ok {
+		return pin.Pin{}, fmt.Errorf("%s: %w", c, pin.ErrNotPinned)
+	}
+	if p.KeptFor != "" {
+		return pin.Pin{}, fmt.Errorf("%s is a replica kept for %s, not a pin of this node's", c, p.KeptFor)
+	}
+	return p, nil
+}
+
+// checkArchived refuses to read or pin c while it is archived.
+func (n *Node) checkArchived(c cid.Cid) error {
+	if n.Pins == nil {
+		return nil
+	}
+	if p, ok := n.Pins.Get(c); ok && !p.Archived.IsZero() {
+		return fmt.Errorf("%s: %w", c, ErrArchived)
+	}
+	return nil
+}
+
+// tierStore is what copyBlocks needs of either store.
+type tierStore interface {
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+	Put(ctx context.Context, c cid.Cid, data []byte) error
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	Size(ctx context.Context, c cid.Cid) (int64, error)
+}
+
+// copyBlocks copies the blocks to lacks from from, recording them on the
+// operation of ctx as missing, then as fetched once copied.
+func copyBlocks(ctx context.Context, blocks []cid.Cid, from, to tierStore) (*TierReport, error) {
+	report := &TierReport{}
+	var (
+		missing []cid.Cid
+		size    int64
+	)
+	for _, b := range blocks {
+		has, err := to.Has(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			report.Present++
+			continue
+		}
+		n, err := from.Size(ctx, b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b, err)
+		}
+		missing = append(missing, b)
+		size += n
+	}
+
+	op := ops.FromContext(ctx)
+	op.AddMissing(len(missing), size)
+	for _, b := range missing {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := from.Get(ctx, b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b, err)
+		}
+		if err := to.Put(ctx, b, data); err != nil {
+			return nil, fmt.Errorf("%s: %w", b, err)
+		}
+		report.Blocks++
+		report.Bytes += int64(len(data))
+		op.AddFetched(len(data))
+	}
+	return report, nil
+}
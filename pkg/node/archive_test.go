@@ -0,0 +1,91 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/coldstore"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+)
+
+// An archived pin can't be read until it is recalled, which brings back
+// the blocks evicted meanwhile.
+func TestArchiveRecall(t *testing.T) {
+	ctx := context.Background()
+	n, store := openNode(t, chunking.Params{Strategy: chunking.StrategyFixed, Size: 1024})
+	data := make([]byte, 4*1024)
+	for i := range data {
+		data[i] = byte(i / 1024)
+	}
+	res, err := n.Add(ctx, bytes.NewReader(data), AddOptions{Name: "a.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := n.ArchivePin(ctx, res.CID); !errors.Is(err, coldstore.ErrNotConfigured) {
+		t.Fatalf("archiving without a cold store: %v", err)
+	}
+	cold, err := storage.Open(filepath.Join(t.TempDir(), "cold"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cold.Close()
+	n.ColdStore = cold
+
+	if _, err := n.RecallPin(ctx, res.CID); !errors.Is(err, ErrNotArchived) {
+		t.Fatalf("recalling a pin not archived: %v", err)
+	}
+	report, err := n.ArchivePin(ctx, res.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blocks != 5 || report.Bytes < int64(len(data)) {
+		t.Errorf("archived %+v, want 5 blocks of at least %d bytes", report, len(data))
+	}
+	if p, _ := n.Pins.Get(res.CID); p.Archived.IsZero() {
+		t.Error("pin not marked archived")
+	}
+	if _, err := n.Stat(ctx, res.CID); !errors.Is(err, ErrArchived) {
+		t.Errorf("stat of an archived pin: %v", err)
+	}
+	if _, err := n.Get(ctx, res.CID, new(bytes.Buffer)); !errors.Is(err, ErrArchived) {
+		t.Errorf("reading an archived pin: %v", err)
+	}
+	if err := n.Pin(ctx, res.CID, PinOptions{}); !errors.Is(err, ErrArchived) {
+		t.Errorf("pinning an archived pin again: %v", err)
+	}
+
+	// What a collection evicts
+	for _, c := range res.Manifest.Chunks[1:] {
+		if err := store.Delete(ctx, c.CID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err = n.RecallPin(ctx, res.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blocks != 3 || report.Present != 2 {
+		t.Errorf("recalled %+v, want 3 blocks copied and 2 present", report)
+	}
+	var out bytes.Buffer
+	if _, err := n.Get(ctx, res.CID, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("recalled file differs")
+	}
+
+	// The cold store kept its copy
+	report, err = n.ArchivePin(ctx, res.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blocks != 0 || report.Present != 5 {
+		t.Errorf("archived again %+v, want 5 blocks present", report)
+	}
+}
@@ -0,0 +1,128 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/ipfs/go-cid"
+)
+
+// maxIndexedFiles bounds the files a ChunkIndex keeps the checksums of;
+// the ones indexed longest ago go first.
+const maxIndexedFiles = 256
+
+// ChunkIndex keeps the checksums of the chunks of files added with
+// aligned chunking, by file CID, so adding a later version of a file only
+// hashes the chunks that changed (see AddOptions.Base). It is a cache: a
+// file it doesn't hold is added whole.
+type ChunkIndex struct {
+	// dir holds a file per indexed file, named by its CID, with the
+	// checksums as big endian uint64s.
+	dir string
+
+	mu  sync.Mutex
+	mem map[cid.Cid][]uint64
+}
+
+// OpenChunkIndex opens the index in dir, creating it. An empty dir keeps
+// the index in memory only.
+func OpenChunkIndex(dir string) (*ChunkIndex, error) {
+	x := &ChunkIndex{dir: dir}
+	if dir == "" {
+		x.mem = make(map[cid.Cid][]uint64)
+		return x, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// Get returns the checksums of the chunks of c.
+func (x *ChunkIndex) Get(c cid.Cid) ([]uint64, bool) {
+	if x == nil {
+		return nil, false
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.dir == "" {
+		sums, ok := x.mem[c]
+		return sums, ok
+	}
+	data, err := os.ReadFile(filepath.Join(x.dir, c.String()))
+	if err != nil || len(data)%8 != 0 {
+		return nil, false
+	}
+	sums := make([]uint64, len(data)/8)
+	for i := range sums {
+		sums[i] = binary.BigEndian.Uint64(data[i*8:])
+	}
+	return sums, true
+}
+
+// Put records the checksums of the chunks of c, dropping the files
+// indexed longest ago beyond maxIndexedFiles.
+func (x *ChunkIndex) Put(c cid.Cid, sums []uint64) error {
+	if x == nil {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.dir == "" {
+		x.mem[c] = sums
+		if len(x.mem) > maxIndexedFiles {
+			for k := range x.mem {
+				if k != c {
+					delete(x.mem, k)
+					break
+				}
+			}
+		}
+		return nil
+	}
+
+	data := make([]byte, 0, len(sums)*8)
+	for _, sum := range sums {
+		data = binary.BigEndian.AppendUint64(data, sum)
+	}
+	if err := fsutil.AtomicWrite(filepath.Join(x.dir, c.String()), data, 0o600); err != nil {
+		return err
+	}
+	return x.prune()
+}
+
+// prune removes the files indexed longest ago beyond maxIndexedFiles.
+// Callers hold mu.
+func (x *ChunkIndex) prune() error {
+	entries, err := os.ReadDir(x.dir)
+	if err != nil || len(entries) <= maxIndexedFiles {
+		return err
+	}
+	type indexed struct {
+		name string
+		info fs.FileInfo
+	}
+	var files []indexed
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, indexed{e.Name(), info})
+	}
+	slices.SortFunc(files, func(a, b indexed) int { return a.info.ModTime().Compare(b.info.ModTime()) })
+	for _, f := range files[:max(len(files)-maxIndexedFiles, 0)] {
+		if err := os.Remove(filepath.Join(x.dir, f.name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
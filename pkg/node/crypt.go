@@ -0,0 +1,103 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrNoMasterKey is returned when encrypting or decrypting a file on a node
+// without a master key.
+var ErrNoMasterKey = errors.New("node: no master key")
+
+// FileKeys keeps the keys of encrypted files by file hash. A
+// keystore.Keystore is one.
+type FileKeys interface {
+	FileKey(c cid.Cid) (*crypt.FileKey, error)
+	PutFileKey(c cid.Cid, key *crypt.FileKey) error
+}
+
+// newFileKey generates the key an encrypted file is sealed with, and the
+// manifest field recording it wrapped by master.
+func newFileKey(master *crypt.MasterKey) (*crypt.FileKey, *manifest.Encryption, error) {
+	if master == nil {
+		return nil, nil, ErrNoMasterKey
+	}
+	key, err := crypt.NewFileKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := master.Wrap(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, &manifest.Encryption{Cipher: crypt.Cipher, KeyID: master.ID(), WrappedKey: wrapped}, nil
+}
+
+// sealer returns what opens the chunks of m, the manifest of c, nil when
+// m isn't encrypted.
+func (n *Node) sealer(c cid.Cid, m *manifest.Manifest) (chunking.Sealer, error) {
+	if m.Encryption == nil {
+		return nil, nil
+	}
+	key, err := n.fileKey(c, m)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// fileKey returns the key the encrypted file c, described by m, is sealed
+// with. A key kept in FileKeys is used as is; otherwise the master key
+// that wrapped it, in use or retired, unwraps it, or failing that a
+// capability another node shared the file with or the key of a group
+// this node is a member of.
+func (n *Node) fileKey(c cid.Cid, m *manifest.Manifest) (*crypt.FileKey, error) {
+	e := m.Encryption
+	// A sealed manifest has no name until it is opened with the key
+	name := m.Name
+	if name == "" {
+		name = c.String()
+	}
+	if e.Cipher != crypt.Cipher {
+		return nil, fmt.Errorf("%s: unsupported cipher %q", name, e.Cipher)
+	}
+	if n.FileKeys != nil {
+		if key, err := n.FileKeys.FileKey(c); err == nil {
+			return key, nil
+		}
+	}
+
+	master := n.MasterKey
+	for _, retired := range n.RetiredMasterKeys {
+		if retired.ID() == e.KeyID {
+			master = retired
+		}
+	}
+	if master == nil || master.ID() != e.KeyID {
+		if key, ok, err := n.sharedKey(c); ok {
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			return key, nil
+		}
+		if key, ok, err := n.groupFileKey(e); ok {
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			return key, nil
+		}
+	}
+	if master == nil {
+		return nil, fmt.Errorf("%s is encrypted: %w", name, ErrNoMasterKey)
+	}
+	key, err := master.Unwrap(e.KeyID, e.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,175 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+)
+
+func mustMasterKey(t *testing.T) *crypt.MasterKey {
+	t.Helper()
+	m, err := crypt.GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// An encrypted file keeps its content, name and sizes from whoever holds
+// its blocks without the key, and reads back whole with it.
+func TestEncryptedAdd(t *testing.T) {
+	ctx := context.Background()
+	data := []byte(strings.Repeat("secret content ", 1000))
+	n, store := openNode(t, chunking.Params{Strategy: chunking.StrategyFixed, Size: 4096})
+	n.MasterKey = mustMasterKey(t)
+
+	res, err := n.Add(ctx, bytes.NewReader(data), AddOptions{Name: "secret.txt", Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := store.Get(ctx, res.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret.txt")) {
+		t.Error("stored manifest holds the name")
+	}
+	stored, err := manifest.Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Size != 0 || stored.Chunks[0].Size != 0 {
+		t.Errorf("stored manifest gives size %d, first chunk %d", stored.Size, stored.Chunks[0].Size)
+	}
+	for _, ref := range stored.Chunks {
+		block, err := store.Get(ctx, ref.CID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(block, []byte("secret content")) {
+			t.Fatalf("chunk %s holds the plaintext", ref.CID)
+		}
+	}
+
+	m, err := n.Stat(ctx, res.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "secret.txt" || m.Size != int64(len(data)) {
+		t.Errorf("Stat = %s, %d bytes, want secret.txt, %d bytes", m.Name, m.Size, len(data))
+	}
+	var out bytes.Buffer
+	if _, err := n.Get(ctx, res.CID, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("Get returned different content")
+	}
+}
+
+// Nodes without the master key hold the blocks but can't read the file,
+// nor learn its name.
+func TestEncryptedWrongKey(t *testing.T) {
+	ctx := context.Background()
+	n, store := openNode(t, chunking.Params{Strategy: chunking.StrategyFixed, Size: 4096})
+	n.MasterKey = mustMasterKey(t)
+	res, err := n.Add(ctx, strings.NewReader("secret content"), AddOptions{Name: "secret.txt", Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  *crypt.MasterKey
+		want error
+	}{
+		{"no key", nil, ErrNoMasterKey},
+		{"other key", mustMasterKey(t), crypt.ErrWrongKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := NewNode(NodeOpts{Store: store, MasterKey: tt.key})
+			m, err := other.Stat(ctx, res.CID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !m.Sealed() || m.Name != "" || m.Size != 0 {
+				t.Errorf("Stat = %q, %d bytes, want it sealed", m.Name, m.Size)
+			}
+			if _, err := other.Get(ctx, res.CID, io.Discard); !errors.Is(err, tt.want) {
+				t.Errorf("Get: %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// A manifest whose envelope was altered is refused rather than read.
+func TestEncryptedTampered(t *testing.T) {
+	ctx := context.Background()
+	n, store := openNode(t, chunking.Params{Strategy: chunking.StrategyFixed, Size: 4096})
+	n.MasterKey = mustMasterKey(t)
+	res, err := n.Add(ctx, strings.NewReader("secret content"), AddOptions{Name: "secret.txt", Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := *res.Manifest
+	e := *m.Encryption
+	e.Envelope = bytes.Clone(e.Envelope)
+	e.Envelope[len(e.Envelope)-1] ^= 1
+	m.Encryption = &e
+	c, err := manifest.Put(ctx, store, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.Stat(ctx, c); !errors.Is(err, crypt.ErrDecrypt) {
+		t.Errorf("Stat of a tampered manifest: %v, want ErrDecrypt", err)
+	}
+}
+
+// The file key is kept wrapped: the manifest never holds it in clear, and
+// what the master key unwraps opens the file.
+func TestEncryptedKeyWrapped(t *testing.T) {
+	ctx := context.Background()
+	n, store := openNode(t, chunking.Params{Strategy: chunking.StrategyFixed, Size: 4096})
+	n.MasterKey = mustMasterKey(t)
+	res, err := n.Add(ctx, strings.NewReader("secret content"), AddOptions{Name: "secret.txt", Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := n.fileKey(res.CID, res.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, err := hex.DecodeString(strings.TrimSpace(string(key.Encode())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := store.Get(ctx, res.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, rawKey) || bytes.Contains(raw, key.Encode()[:64]) {
+		t.Error("stored manifest holds the file key")
+	}
+	if bytes.Contains(res.Manifest.Encryption.WrappedKey, rawKey) {
+		t.Error("wrapped key holds the file key")
+	}
+
+	unwrapped, err := n.MasterKey.Unwrap(res.Manifest.Encryption.KeyID, res.Manifest.Encryption.WrappedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped.Encode(), key.Encode()) {
+		t.Error("unwrapped key differs from the file key")
+	}
+}
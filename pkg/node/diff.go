@@ -0,0 +1,117 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// DAG lists the blocks of the file or directory tree rooted at c, root
+// first, from the local store. It is what peers are sent the difference
+// of a new version from, see network.DiffProtocol.
+func (n *Node) DAG(ctx context.Context, c cid.Cid) ([]cid.Cid, error) {
+	return dagOf(ctx, n.store, c)
+}
+
+// dagOf is DAG from store.
+func dagOf(ctx context.Context, store chunking.BlockGetter, c cid.Cid) ([]cid.Cid, error) {
+	blocks := []cid.Cid{c}
+	seen := map[cid.Cid]bool{c: true}
+	for i := 0; i < len(blocks); i++ {
+		links, err := manifest.Links(ctx, store, blocks[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", blocks[i], err)
+		}
+		for _, l := range links {
+			if !seen[l] {
+				seen[l] = true
+				blocks = append(blocks, l)
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// UpdatePin moves the pin of base to c, a later version of the same file
+// or directory tree, keeping the pin's settings. Blocks c shares with
+// base aren't fetched again. When from is set, it is sent the blocks of
+// base this node has and sends back the rest of c in one exchange;
+// whatever that leaves missing is fetched block by block.
+func (n *Node) UpdatePin(ctx context.Context, base, c cid.Cid, from peer.ID) error {
+	if n.Pins == nil {
+		return fmt.Errorf("node has no pin set")
+	}
+	if !n.Pins.Has(base) {
+		return pin.ErrNotPinned
+	}
+	defer n.Pins.Pinning()()
+
+	f := n.newFetcher()
+	f.touch = true
+	if from != "" && n.network != nil {
+		n.fetchDiff(ctx, from, c, base)
+		f.hints = []peer.AddrInfo{{ID: from}}
+	}
+
+	var (
+		name string
+		size int64
+	)
+	err := n.download(ctx, f, c, func(ctx context.Context) (err error) {
+		name, size, err = n.fetchAll(ctx, f, c)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := n.Pins.Update(base, c); err != nil {
+		return err
+	}
+
+	if n.network != nil {
+		n.announce(ctx, c, name, size)
+	}
+	n.logger.Info("Updated pin", zap.String("cid", c.String()), zap.String("base", base.String()))
+	return nil
+}
+
+// fetchDiff stores the blocks of c that from has and base doesn't share.
+// Failing is left to fetching block by block.
+func (n *Node) fetchDiff(ctx context.Context, from peer.ID, c, base cid.Cid) {
+	blocks, err := n.DAG(ctx, base)
+	if err != nil {
+		n.logger.Debug("Not fetching a difference, base incomplete", zap.String("cid", base.String()), zap.Error(err))
+		return
+	}
+	have := blocks[:0]
+	for _, b := range blocks {
+		if ok, err := n.store.Has(ctx, b); err == nil && ok {
+			have = append(have, b)
+		}
+	}
+
+	stats, err := n.network.FetchDiff(ctx, from, c, have, n.store.Put)
+	if err != nil {
+		log := n.logger.Warn
+		if errors.Is(err, ctx.Err()) || errors.Is(err, network.ErrDiffUnavailable) {
+			log = n.logger.Debug
+		}
+		log("Failed to fetch difference", zap.String("cid", c.String()), zap.String("peer", from.String()), zap.Error(err))
+		return
+	}
+	n.logger.Debug("Fetched difference",
+		zap.String("cid", c.String()),
+		zap.String("peer", from.String()),
+		zap.Int("blocks", stats.Blocks),
+		zap.Int64("bytes", stats.Bytes),
+		zap.Int("shared", len(have)),
+	)
+}
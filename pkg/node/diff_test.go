@@ -0,0 +1,179 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// countingSource counts the blocks served to peers.
+type countingSource struct {
+	*storage.FSBlockstore
+	served atomic.Int64
+}
+
+func (s *countingSource) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	s.served.Add(1)
+	return s.FSBlockstore.Get(ctx, c)
+}
+
+// startNodes runs nodes connected over an in-memory network, each serving
+// differences and selections from its own store. opts is the networking every node gets.
+func startNodes(t *testing.T, count int, opts network.P2PNetworkingOpts) ([]*Node, []*countingSource) {
+	t.Helper()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	nodes := make([]*Node, count)
+	sources := make([]*countingSource, count)
+	for i := range nodes {
+		dir := t.TempDir()
+		store, err := storage.Open(filepath.Join(dir, "blocks"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		pins, err := pin.Open(filepath.Join(dir, "pins.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Node keys are Ed25519, which sharing relies on
+		priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := mn.AddPeer(priv, ma.StringCast(fmt.Sprintf("/ip4/100.64.0.%d/tcp/4242", i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sources[i] = &countingSource{FSBlockstore: store}
+		opts.Blocks = sources[i]
+		opts.CustomHost = h
+		opts.Logger = zap.NewNop()
+		net := network.NewP2PNetworking(opts)
+		if err := net.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { net.Close() })
+
+		nodes[i] = NewNode(NodeOpts{
+			Store:    store,
+			Pins:     pins,
+			Network:  net,
+			Chunking: chunking.Params{Strategy: chunking.StrategyFixed, Size: 1024},
+		})
+		net.HandleDiff(nodes[i].DAG)
+		net.HandleSelect(nodes[i].Select)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connections are noted asynchronously; until then nodes have no one
+	// to fetch from
+	deadline := time.Now().Add(5 * time.Second)
+	for _, n := range nodes {
+		for len(n.network.Peers()) < count-1 {
+			if time.Now().After(deadline) {
+				t.Fatal("nodes not connected")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return nodes, sources
+}
+
+// TestUpdatePinFetchesDifference updates a replica to a version of the
+// file with a chunk changed and one appended: only those and the new
+// manifest cross the network.
+func TestUpdatePinFetchesDifference(t *testing.T) {
+	nodes, sources := startNodes(t, 2, network.P2PNetworkingOpts{})
+	owner, holder := nodes[0], nodes[1]
+	ctx := context.Background()
+
+	v1 := make([]byte, 20*1024)
+	for i := range v1 {
+		v1[i] = byte(i / 1024)
+	}
+	old, err := owner.Add(ctx, bytes.NewReader(v1), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := holder.Pin(ctx, old.CID, PinOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := bytes.Clone(v1)
+	copy(v2[5*1024:], "changed")
+	v2 = append(v2, bytes.Repeat([]byte("more"), 256)...)
+	updated, err := owner.Add(ctx, bytes.NewReader(v2), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The exchange itself: the holder lists what it has of the old version
+	have, err := holder.DAG(ctx, old.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := owner.DAG(ctx, updated.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []cid.Cid
+	stats, err := holder.network.FetchDiff(ctx, owner.network.Host().ID(), updated.CID, have, func(_ context.Context, c cid.Cid, _ []byte) error {
+		sent = append(sent, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []cid.Cid
+	for _, c := range links {
+		if !slices.Contains(have, c) {
+			want = append(want, c)
+		}
+	}
+	if !slices.Equal(sent, want) || stats.Blocks != len(want) {
+		t.Fatalf("difference sent %d blocks %v, want %v", stats.Blocks, sent, want)
+	}
+
+	sources[0].served.Store(0)
+	if err := holder.UpdatePin(ctx, old.CID, updated.CID, owner.network.Host().ID()); err != nil {
+		t.Fatal(err)
+	}
+	// The manifest, the changed chunk and the appended one
+	if served := sources[0].served.Load(); served != 3 {
+		t.Errorf("owner served %d blocks for the update, want 3", served)
+	}
+
+	if holder.Pins.Has(old.CID) || !holder.Pins.Has(updated.CID) {
+		t.Error("pin not moved to the new version")
+	}
+	var got bytes.Buffer
+	if _, err := holder.Get(ctx, updated.CID, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), v2) {
+		t.Error("updated file reads back wrong")
+	}
+}
@@ -0,0 +1,163 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+// DirectoryEntry names a file or directory to put in a new directory.
+type DirectoryEntry struct {
+	Name string
+	CID  cid.Cid
+}
+
+type DirectoryOptions struct {
+	// NoPin leaves the directory unpinned, for one that is about to become
+	// part of a larger tree. A session pin keeps it until then.
+	NoPin bool
+}
+
+// MakeDirectory stores a directory of already added files and directories
+// and returns its CID. Entry sizes are read from the entries themselves.
+func (n *Node) MakeDirectory(ctx context.Context, entries []DirectoryEntry, opts DirectoryOptions) (cid.Cid, *manifest.Directory, error) {
+	if n.Pins != nil {
+		defer n.Pins.Pinning()()
+	}
+	f := n.newFetcher()
+	f.touch = true
+
+	list := make([]manifest.Entry, len(entries))
+	for i, e := range entries {
+		_, size, err := n.describe(ctx, f, e.CID)
+		if err != nil {
+			return cid.Undef, nil, fmt.Errorf("entry %q: %w", e.Name, err)
+		}
+		list[i] = manifest.Entry{Name: e.Name, CID: e.CID, Size: size}
+	}
+
+	d, err := manifest.NewDirectory(list)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	c, err := manifest.PutDirectory(ctx, n.store, d)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+
+	if n.Pins != nil && !opts.NoPin {
+		if err := n.Pins.Add(c); err != nil {
+			return cid.Undef, nil, err
+		}
+		n.release(ctx, d)
+	} else if n.Pins != nil {
+		n.Pins.Hold(c, SessionPinTimeout)
+	}
+	if n.network != nil {
+		n.network.Provide(c)
+		if !opts.NoPin {
+			n.announce(ctx, c, "", d.Size())
+		}
+	}
+
+	n.logger.Info("Directory added",
+		zap.String("cid", c.String()),
+		zap.Int("entries", len(d.Entries)),
+		zap.Int64("size", d.Size()),
+	)
+	return c, d, nil
+}
+
+// release drops the session pins of the tree under d, now that a pin
+// keeps it.
+func (n *Node) release(ctx context.Context, d *manifest.Directory) {
+	for _, e := range d.Entries {
+		n.Pins.Release(e.CID)
+		if e.CID.Type() != manifest.DirectoryCodec {
+			continue
+		}
+		if sub, err := manifest.LoadDirectory(ctx, n.store, e.CID); err == nil {
+			n.release(ctx, sub)
+		}
+	}
+}
+
+// List loads the directory stored under c, fetching it from peers if it
+// isn't stored locally, unless the directory is an archived pin.
+func (n *Node) List(ctx context.Context, c cid.Cid) (*manifest.Directory, error) {
+	if err := n.checkArchived(c); err != nil {
+		return nil, err
+	}
+	return manifest.LoadDirectory(ctx, n.newFetcher(), c)
+}
+
+// Resolve follows names from the directory root, as split from a path by
+// manifest.ParsePath, fetching directories from peers as needed.
+func (n *Node) Resolve(ctx context.Context, root cid.Cid, names []string) (cid.Cid, error) {
+	return manifest.Resolve(ctx, n.newFetcher(), root, names)
+}
+
+// Describe returns the name and size of the file or directory stored
+// under c. Directories have no name of their own, and neither has an
+// encrypted file this node can't open; its size is then the most its
+// chunks can add up to.
+func (n *Node) Describe(ctx context.Context, c cid.Cid) (string, int64, error) {
+	return n.describe(ctx, n.newFetcher(), c)
+}
+
+func (n *Node) describe(ctx context.Context, f *fetcher, c cid.Cid) (string, int64, error) {
+	if c.Type() == manifest.DirectoryCodec {
+		d, err := manifest.LoadDirectory(ctx, f, c)
+		if err != nil {
+			return "", 0, err
+		}
+		return "", d.Size(), nil
+	}
+
+	m, err := n.stat(ctx, f, c)
+	if err != nil {
+		return "", 0, err
+	}
+	if m.Sealed() {
+		var bound int64
+		for _, chunk := range m.ChunkList() {
+			bound += chunk.Size + crypt.Overhead
+		}
+		return "", bound, nil
+	}
+	return m.Name, m.Size, nil
+}
+
+// fetchAll makes sure every block of the file or directory tree under c
+// is stored locally and returns the name and size to announce it with,
+// which for an encrypted file are left empty.
+func (n *Node) fetchAll(ctx context.Context, f *fetcher, c cid.Cid) (string, int64, error) {
+	if c.Type() != manifest.DirectoryCodec {
+		m, err := n.stat(ctx, f, c)
+		if err != nil {
+			return "", 0, err
+		}
+		if err := f.fetchChunks(ctx, m.ChunkList()); err != nil {
+			return "", 0, err
+		}
+		if m.Encryption != nil {
+			return "", 0, nil
+		}
+		return m.Name, m.Size, nil
+	}
+
+	d, err := manifest.LoadDirectory(ctx, f, c)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, e := range d.Entries {
+		if _, _, err := n.fetchAll(ctx, f, e.CID); err != nil {
+			return "", 0, fmt.Errorf("%s: %w", e.Name, err)
+		}
+	}
+	return "", d.Size(), nil
+}
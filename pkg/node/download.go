@@ -0,0 +1,319 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+const (
+	// An interrupted download is tried again after downloadRetryMin,
+	// doubling up to downloadRetryMax while it keeps failing.
+	downloadRetryMin = 30 * time.Second
+	downloadRetryMax = 30 * time.Minute
+	// maxDownloadFailures failed attempts in a row give a download up,
+	// about a day after it started failing.
+	maxDownloadFailures = 60
+)
+
+// ErrNoDownload is returned when cancelling a download that isn't pending.
+var ErrNoDownload = errors.New("node: no such download")
+
+// Download is a file or directory tree being fetched.
+type Download struct {
+	CID     cid.Cid   `json:"cid"`
+	Started time.Time `json:"started"`
+	// Failures counts the attempts in a row that ended in an error, Error
+	// is the last one and RetryAt when the next attempt starts.
+	Failures int       `json:"failures,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	RetryAt  time.Time `json:"retry_at,omitzero"`
+	// Running is set while a request or the node itself fetches it.
+	Running bool `json:"-"`
+
+	running int
+}
+
+// Downloads records the downloads that haven't completed yet. Blocks
+// fetched so far stay stored, so a download interrupted by the network or
+// a restart continues where it stopped instead of starting over.
+type Downloads struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[cid.Cid]*Download
+	// wake tells the resume loop the list changed.
+	wake chan struct{}
+}
+
+// OpenDownloads loads the download list at path, a JSON file like the pin
+// set. A missing file is an empty list; an empty path keeps the list in
+// memory only.
+func OpenDownloads(path string) (*Downloads, error) {
+	d := &Downloads{
+		path:    path,
+		entries: make(map[cid.Cid]*Download),
+		wake:    make(chan struct{}, 1),
+	}
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Download
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, dl := range list {
+		d.entries[dl.CID] = dl
+	}
+	return d, nil
+}
+
+// List returns the unfinished downloads, oldest first.
+func (d *Downloads) List() []Download {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	list := make([]Download, 0, len(d.entries))
+	for _, dl := range d.entries {
+		e := *dl
+		e.Running = dl.running > 0
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+// Roots returns the roots of the unfinished downloads, for garbage
+// collection to keep what they fetched so far.
+func (d *Downloads) Roots() []cid.Cid {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	roots := make([]cid.Cid, 0, len(d.entries))
+	for c := range d.entries {
+		roots = append(roots, c)
+	}
+	return roots
+}
+
+// Remove gives up a download waiting to be resumed. One running is
+// cancelled through its operation instead.
+func (d *Downloads) Remove(c cid.Cid) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dl, ok := d.entries[c]
+	if !ok || dl.running > 0 {
+		return ErrNoDownload
+	}
+	delete(d.entries, c)
+	d.notify()
+	return d.save()
+}
+
+// notify wakes the resume loop. Callers hold d.mu.
+func (d *Downloads) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Downloads) has(c cid.Cid) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.entries[c]
+	return ok
+}
+
+// waiting reports whether c waits to be resumed.
+func (d *Downloads) waiting(c cid.Cid) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl, ok := d.entries[c]
+	return ok && dl.running == 0
+}
+
+// begin records that c is being fetched.
+func (d *Downloads) begin(c cid.Cid) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dl, ok := d.entries[c]
+	if !ok {
+		dl = &Download{CID: c, Started: time.Now()}
+		d.entries[c] = dl
+	}
+	dl.running++
+	if ok {
+		return nil
+	}
+	return d.save()
+}
+
+// end records how fetching c went: a download is forgotten once it
+// completes, is cancelled or has failed too often, and otherwise tried
+// again later.
+func (d *Downloads) end(c cid.Cid, err error, cancelled bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dl, ok := d.entries[c]
+	if !ok {
+		return nil
+	}
+	dl.running--
+	switch {
+	case err == nil || cancelled:
+		if dl.running > 0 {
+			// Another request is still fetching it
+			return nil
+		}
+		delete(d.entries, c)
+	case errors.Is(err, context.Canceled):
+		// The client or the daemon went away, not the network
+		dl.RetryAt = time.Now()
+	default:
+		dl.Failures++
+		dl.Error = err.Error()
+		dl.RetryAt = time.Now().Add(min(downloadRetryMin<<min(dl.Failures-1, 10), downloadRetryMax))
+		if dl.Failures >= maxDownloadFailures && dl.running == 0 {
+			delete(d.entries, c)
+		}
+	}
+	d.notify()
+	return d.save()
+}
+
+// next returns the download to resume first, and when it is due.
+func (d *Downloads) next() (cid.Cid, time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var (
+		first cid.Cid
+		due   time.Time
+	)
+	for c, dl := range d.entries {
+		if dl.running > 0 {
+			continue
+		}
+		if !first.Defined() || dl.RetryAt.Before(due) {
+			first, due = c, dl.RetryAt
+		}
+	}
+	return first, due, first.Defined()
+}
+
+// save writes the list atomically. Callers hold d.mu.
+func (d *Downloads) save() error {
+	if d.path == "" {
+		return nil
+	}
+
+	list := make([]*Download, 0, len(d.entries))
+	for _, dl := range d.entries {
+		list = append(list, dl)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CID.KeyString() < list[j].CID.KeyString() })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(d.path, data, 0644)
+}
+
+// download runs fetch for the file or tree under c, recording it so it is
+// resumed if it doesn't complete. Only cancelling the operation through
+// the ops registry gives the download up; a client going away leaves it
+// to finish in the background.
+func (n *Node) download(ctx context.Context, f *fetcher, c cid.Cid, fetch func(context.Context) error) error {
+	if n.network == nil {
+		return fetch(ctx)
+	}
+	// Only record what exists, which fails fast on unknown hashes
+	if !n.Downloads.has(c) {
+		if _, err := f.Get(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	if err := n.Downloads.begin(c); err != nil {
+		n.logger.Warn("Failed to record download", zap.String("cid", c.String()), zap.Error(err))
+	}
+	err := fetch(ctx)
+	cancelled := errors.Is(context.Cause(ctx), ops.ErrCanceled)
+	if err := n.Downloads.end(c, err, cancelled); err != nil {
+		n.logger.Warn("Failed to record download", zap.String("cid", c.String()), zap.Error(err))
+	}
+	return err
+}
+
+// ResumeDownloads finishes interrupted downloads in the background until
+// ctx is done: those left over from before a restart, those that failed
+// and those whose client went away. Failed ones are retried with growing
+// delays.
+func (n *Node) ResumeDownloads(ctx context.Context) {
+	if n.network == nil {
+		return
+	}
+
+	go func() {
+		for {
+			c, due, ok := n.Downloads.next()
+			var timer <-chan time.Time
+			if ok {
+				timer = time.After(time.Until(due))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-n.Downloads.wake:
+				continue
+			case <-timer:
+			}
+			// Given up, or taken up by a request meanwhile
+			if !n.Downloads.waiting(c) {
+				continue
+			}
+
+			n.logger.Info("Resuming download", zap.String("cid", c.String()))
+			runCtx, _, done := n.Ops.Start(ctx, ops.KindGet, c.String())
+			f := n.newFetcher()
+			err := n.download(runCtx, f, c, func(ctx context.Context) error {
+				_, _, err := n.fetchAll(ctx, f, c)
+				return err
+			})
+			done()
+			switch {
+			case err == nil:
+				n.logger.Info("Download finished", zap.String("cid", c.String()))
+			case ctx.Err() == nil && !errors.Is(context.Cause(runCtx), ops.ErrCanceled):
+				n.logger.Warn("Download failed", zap.String("cid", c.String()), zap.Error(err))
+			}
+		}
+	}()
+}
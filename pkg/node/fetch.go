@@ -0,0 +1,111 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// fetcher reads blocks from the local store and fetches missing ones from
+// peers, keeping what it fetched. One fetcher is used per file so the peers
+// that had the root are asked for its chunks before doing a new lookup.
+type fetcher struct {
+	n     *Node
+	hints []peer.AddrInfo
+	trace *routeTrace
+	// touch refreshes the blocks found stored, for a file about to be
+	// pinned: a collection running before the pin is recorded keeps
+	// them, see gc.
+	touch bool
+}
+
+func (n *Node) newFetcher() *fetcher {
+	return &fetcher{n: n}
+}
+
+// toucher is a store that refreshes the write time of a block, as
+// storage.FSBlockstore does.
+type toucher interface {
+	Touch(ctx context.Context, c cid.Cid) error
+}
+
+// has reports whether c is stored, touching it when f.touch is set.
+func (f *fetcher) has(ctx context.Context, c cid.Cid) (bool, error) {
+	if t, ok := f.n.store.(toucher); ok && f.touch {
+		err := t.Touch(ctx, c)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, storage.ErrNotFound):
+			return false, nil
+		case !errors.Is(err, storage.ErrReadOnly):
+			return false, err
+		}
+	}
+	return f.n.store.Has(ctx, c)
+}
+
+func (f *fetcher) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	data, err := f.n.store.Get(ctx, c)
+	if err == nil && f.touch {
+		// Put touches the block, or stores it again if a collection
+		// removed it since it was read
+		if err := f.n.store.Put(ctx, c, data); err != nil && !errors.Is(err, storage.ErrReadOnly) {
+			return nil, err
+		}
+		return data, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) || f.n.network == nil {
+		return data, err
+	}
+
+	var hints []peer.AddrInfo
+	for _, pi := range f.hints {
+		if !f.n.network.Lacks(pi.ID, c) {
+			hints = append(hints, pi)
+		}
+	}
+	if data, ok := f.tryPeers(ctx, c, hints); ok {
+		return data, nil
+	}
+	// Peers that advertised the block spare a DHT lookup
+	if advertised := f.n.network.Advertised(c); len(advertised) > 0 {
+		if data, ok := f.tryPeers(ctx, c, advertised); ok {
+			if len(f.hints) == 0 {
+				f.hints = advertised
+			}
+			return data, nil
+		}
+	}
+
+	start := time.Now()
+	providers, err := f.n.network.FindProviders(ctx, c)
+	f.trace.lookup(c, providers, time.Since(start), err)
+	if err != nil {
+		return nil, fmt.Errorf("find providers for %s: %w", c, err)
+	}
+	if data, ok := f.tryPeers(ctx, c, providers); ok {
+		if len(f.hints) == 0 {
+			f.hints = providers
+		}
+		return data, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fetcher) tryPeers(ctx context.Context, c cid.Cid, peers []peer.AddrInfo) ([]byte, bool) {
+	if len(peers) == 0 {
+		return nil, false
+	}
+	if err := f.fetch(ctx, []chunking.Chunk{{CID: c}}, peers, nil); err != nil {
+		return nil, false
+	}
+	data, err := f.n.store.Get(ctx, c)
+	return data, err == nil
+}
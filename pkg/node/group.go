@@ -0,0 +1,281 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/group"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// groupRefreshInterval is how long a member uses the group document it
+// has before looking up a newer one to add files with.
+const groupRefreshInterval = time.Minute
+
+// maxGroupDocSize bounds the group documents read.
+const maxGroupDocSize = 4 << 20
+
+// ErrNoGroup is returned for a group the node neither created nor joined.
+var ErrNoGroup = errors.New("node: no such group")
+
+// CreateGroup creates the group name with this node as its admin and the
+// given members, and publishes its document. Like network.Publish it
+// returns network.ErrNameNotAnnounced, along with the group, when the
+// document was published but not announced.
+func (n *Node) CreateGroup(ctx context.Context, name string, members []peer.ID) (*group.Entry, error) {
+	if n.Groups == nil {
+		return nil, errors.New("node has no group store")
+	}
+	if _, ok := n.Groups.Get(name); ok {
+		return nil, fmt.Errorf("group %s already exists", name)
+	}
+	priv, err := n.identity()
+	if err != nil {
+		return nil, err
+	}
+	g, err := group.New(name, priv, members)
+	if err != nil {
+		return nil, err
+	}
+	return n.publishGroup(ctx, g, cid.Undef)
+}
+
+// UpdateGroup adds and removes members of a group this node is the admin
+// of, and publishes the new document. Removing a member moves the group
+// on to a new key; files already added stay encrypted with the keys they
+// were added with. Errors are as for CreateGroup.
+func (n *Node) UpdateGroup(ctx context.Context, name string, add, remove []peer.ID) (*group.Entry, error) {
+	e, err := n.group(name)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := n.identity()
+	if err != nil {
+		return nil, err
+	}
+	// Changed on a copy, for the stored one to stay as it was on failure
+	data, err := e.Group.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	g, err := group.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.Add(priv, add...); err != nil {
+		return nil, err
+	}
+	if err := g.Remove(priv, remove...); err != nil {
+		return nil, err
+	}
+	prev, _ := cid.Decode(e.CID)
+	return n.publishGroup(ctx, g, prev)
+}
+
+// JoinGroup looks up the group document published under name and keeps
+// it, once it checked the admin signed it and this node is a member. Joining a group again
+// brings it up to date.
+func (n *Node) JoinGroup(ctx context.Context, name string) (*group.Entry, error) {
+	if n.Groups == nil {
+		return nil, errors.New("node has no group store")
+	}
+	priv, err := n.identity()
+	if err != nil {
+		return nil, err
+	}
+	if n.network == nil || n.network.Names() == nil {
+		return nil, errors.New("node: groups are looked up with names, which need networking")
+	}
+	c, err := n.network.Names().Resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	g, err := n.loadGroup(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := g.Keys(priv); err != nil {
+		return nil, err
+	}
+
+	var prev cid.Cid
+	if old, ok := n.Groups.Get(g.Name); ok {
+		// Keys are only ever added, so an older document has fewer
+		if old.Group.Admin == g.Admin && len(g.Generations) < len(old.Group.Generations) {
+			return nil, fmt.Errorf("group %s: %s holds an older version of the group", g.Name, name)
+		}
+		prev, _ = cid.Decode(old.CID)
+	}
+	e := group.Entry{Group: g, Name: name, CID: c.String()}
+	if err := n.Groups.Put(e); err != nil {
+		return nil, err
+	}
+	n.keepGroupDoc(ctx, c, prev)
+
+	n.logger.Info("Joined group",
+		zap.String("group", g.Name),
+		zap.String("admin", g.Admin),
+		zap.Int("keys", len(g.Generations)),
+	)
+	e, _ = n.Groups.Get(g.Name)
+	return &e, nil
+}
+
+// publishGroup stores and pins the document of g, publishes it under the
+// group's name and keeps it, unpinning the version before it, prev.
+func (n *Node) publishGroup(ctx context.Context, g *group.Group, prev cid.Cid) (*group.Entry, error) {
+	if n.network == nil || n.network.Names() == nil {
+		return nil, errors.New("node: group documents are published under names, which need networking")
+	}
+	data, err := g.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Add(ctx, bytes.NewReader(data), AddOptions{Name: group.KeyPrefix + g.Name + ".json", NoPin: true})
+	if err != nil {
+		return nil, err
+	}
+	n.keepGroupDoc(ctx, res.CID, prev)
+
+	name, pubErr := n.network.Names().Publish(ctx, group.KeyPrefix+g.Name, res.CID)
+	if pubErr != nil && !errors.Is(pubErr, network.ErrNameNotAnnounced) {
+		return nil, pubErr
+	}
+	e := group.Entry{Group: g, Name: name.String(), CID: res.CID.String()}
+	if err := n.Groups.Put(e); err != nil {
+		return nil, err
+	}
+
+	n.logger.Info("Published group",
+		zap.String("group", g.Name),
+		zap.String("name", e.Name),
+		zap.Int("members", len(g.Members)),
+		zap.String("key", g.Current()),
+	)
+	e, _ = n.Groups.Get(g.Name)
+	return &e, pubErr
+}
+
+// keepGroupDoc pins the group document c in place of prev.
+func (n *Node) keepGroupDoc(ctx context.Context, c, prev cid.Cid) {
+	if n.Pins == nil || c == prev {
+		return
+	}
+	if err := n.Pin(ctx, c, PinOptions{}); err != nil {
+		n.logger.Warn("Failed to pin group document", zap.String("cid", c.String()), zap.Error(err))
+		return
+	}
+	n.Pins.Release(c)
+	if prev.Defined() {
+		n.Pins.Remove(prev)
+	}
+}
+
+// loadGroup reads the group document c.
+func (n *Node) loadGroup(ctx context.Context, c cid.Cid) (*group.Group, error) {
+	if c.Type() == manifest.DirectoryCodec {
+		return nil, fmt.Errorf("%s is a directory, not a group document", c)
+	}
+	var buf bytes.Buffer
+	m, err := n.Get(ctx, c, &limitWriter{w: &buf, n: maxGroupDocSize})
+	if err != nil {
+		return nil, err
+	}
+	if m.Encryption != nil {
+		return nil, fmt.Errorf("%s is encrypted, not a group document", c)
+	}
+	return group.Parse(buf.Bytes())
+}
+
+// group returns the group called name.
+func (n *Node) group(name string) (group.Entry, error) {
+	if n.Groups == nil {
+		return group.Entry{}, errors.New("node has no group store")
+	}
+	e, ok := n.Groups.Get(name)
+	if !ok {
+		return group.Entry{}, fmt.Errorf("%w %q", ErrNoGroup, name)
+	}
+	return e, nil
+}
+
+// groupMasterKey returns the current key of the group called name, to
+// encrypt a file with. A member not the admin first looks up a newer
+// document, for a key moved on from not to be used.
+func (n *Node) groupMasterKey(ctx context.Context, name string) (*crypt.MasterKey, error) {
+	e, err := n.group(name)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := n.identity()
+	if err != nil {
+		return nil, err
+	}
+	self, _ := peer.IDFromPrivateKey(priv)
+	if e.Group.Admin != self.String() && time.Since(e.Updated) > groupRefreshInterval {
+		if refreshed, err := n.JoinGroup(ctx, e.Name); err == nil {
+			e = *refreshed
+		} else {
+			n.logger.Warn("Failed to refresh group, using the document at hand",
+				zap.String("group", name), zap.Error(err))
+		}
+	}
+	keys, err := e.Group.Keys(priv)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[e.Group.Current()]
+	if !ok {
+		return nil, fmt.Errorf("group %s: %w", name, group.ErrNotMember)
+	}
+	return key, nil
+}
+
+// groupFileKey unwraps the key of a file encrypted for a group with the
+// group key e.KeyID, if this node has it.
+func (n *Node) groupFileKey(e *manifest.Encryption) (*crypt.FileKey, bool, error) {
+	if n.Groups == nil {
+		return nil, false, nil
+	}
+	entry, ok := n.Groups.ByKey(e.KeyID)
+	if !ok {
+		return nil, false, nil
+	}
+	priv, err := n.identity()
+	if err != nil {
+		return nil, true, err
+	}
+	keys, err := entry.Group.Keys(priv)
+	if err != nil {
+		return nil, true, err
+	}
+	master, ok := keys[e.KeyID]
+	if !ok {
+		return nil, true, fmt.Errorf("group %s: %w for key %s", entry.Group.Name, group.ErrNotMember, e.KeyID)
+	}
+	key, err := master.Unwrap(e.KeyID, e.WrappedKey)
+	return key, true, err
+}
+
+// limitWriter fails writes past n bytes.
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errors.New("group document too large")
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
@@ -0,0 +1,94 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/group"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// memNameKeys makes named keys on demand.
+type memNameKeys map[string]crypto.PrivKey
+
+func (k memNameKeys) NameKey(name string) (crypto.PrivKey, error) {
+	if k[name] == nil {
+		priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+		if err != nil {
+			return nil, err
+		}
+		k[name] = priv
+	}
+	return k[name], nil
+}
+
+func TestGroupFiles(t *testing.T) {
+	nodes, _ := startNodes(t, 2, network.P2PNetworkingOpts{NameKeys: memNameKeys{}})
+	admin, member := nodes[0], nodes[1]
+	for _, n := range nodes {
+		n.MasterKey = mustMasterKey(t)
+		n.Groups, _ = group.OpenStore("")
+	}
+	ctx := context.Background()
+	memberID := member.network.Host().ID()
+
+	e, err := admin.CreateGroup(ctx, "team", []peer.ID{memberID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The admin's own name resolves without a DHT
+	if _, err := admin.JoinGroup(ctx, e.Name); err != nil {
+		t.Fatalf("join own group: %v", err)
+	}
+	// Members look the document up by name, which takes a DHT here
+	if err := member.Groups.Put(*e); err != nil {
+		t.Fatal(err)
+	}
+
+	before := []byte("added while a member")
+	old, err := admin.Add(ctx, bytes.NewReader(before), AddOptions{Name: "old.txt", Group: "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Manifest.Encryption == nil || old.Manifest.Encryption.KeyID != e.Group.Current() {
+		t.Fatalf("file not encrypted with the group key: %+v", old.Manifest.Encryption)
+	}
+	var buf bytes.Buffer
+	if _, err := member.Get(ctx, old.CID, &buf); err != nil {
+		t.Fatalf("member get: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), before) {
+		t.Fatalf("member read %q", buf.Bytes())
+	}
+
+	e, err = admin.UpdateGroup(ctx, "team", nil, []peer.ID{memberID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := member.Groups.Put(*e); err != nil {
+		t.Fatal(err)
+	}
+	added, err := admin.Add(ctx, bytes.NewReader([]byte("added after")), AddOptions{Name: "new.txt", Group: "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.Manifest.Encryption.KeyID == old.Manifest.Encryption.KeyID {
+		t.Fatal("file added after a removal uses the key from before")
+	}
+	if _, err := member.Get(ctx, added.CID, &bytes.Buffer{}); !errors.Is(err, group.ErrNotMember) {
+		t.Fatalf("removed member reading a new file: err = %v, want ErrNotMember", err)
+	}
+
+	// The admin reads both with the group keys, and only members change it
+	buf.Reset()
+	if _, err := admin.Get(ctx, old.CID, &buf); err != nil || !bytes.Equal(buf.Bytes(), before) {
+		t.Fatalf("admin get: %q, %v", buf.Bytes(), err)
+	}
+	if _, err := member.UpdateGroup(ctx, "team", []peer.ID{memberID}, nil); !errors.Is(err, group.ErrNotAdmin) {
+		t.Fatalf("member update: err = %v, want ErrNotAdmin", err)
+	}
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+)
+
+// TestFetchFollowsInventories fetches a file in a cluster where one peer
+// has it and another has nothing: the inventories they advertise send
+// every request to the first, without asking the second about any block.
+func TestFetchFollowsInventories(t *testing.T) {
+	nodes, sources := startNodes(t, 3, network.P2PNetworkingOpts{InventoryInterval: 50 * time.Millisecond})
+	owner, empty, fetcher := nodes[0], nodes[1], nodes[2]
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("inventory "), 2048)
+	res, err := owner.Add(ctx, bytes.NewReader(data), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ownerID, emptyID := owner.network.Host().ID(), empty.network.Host().ID()
+	deadline := time.Now().Add(10 * time.Second)
+	for len(fetcher.network.Advertised(res.CID)) == 0 || !fetcher.network.Lacks(emptyID, res.CID) {
+		if time.Now().After(deadline) {
+			t.Fatal("inventories not received")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if advertised := fetcher.network.Advertised(res.CID); len(advertised) != 1 || advertised[0].ID != ownerID {
+		t.Fatalf("file advertised by %v, want only the owner", advertised)
+	}
+
+	var got bytes.Buffer
+	if _, err := fetcher.Get(ctx, res.CID, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Fatal("file reads back wrong")
+	}
+	if asked := sources[1].served.Load(); asked != 0 {
+		t.Errorf("peer without the file was asked for %d blocks", asked)
+	}
+	if served := sources[0].served.Load(); served == 0 {
+		t.Error("owner served nothing")
+	}
+}
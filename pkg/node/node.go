@@ -0,0 +1,440 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/coldstore"
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/group"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/Noah-Wilderom/dfs/pkg/pressure"
+	"github.com/Noah-Wilderom/dfs/pkg/share"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+// Node ties local storage and networking together and implements the
+// operations exposed to users.
+type Node struct {
+	store   storage.Blockstore
+	network *network.P2PNetworking
+	logger  *zap.Logger
+
+	NodeOpts
+}
+
+type NodeOpts struct {
+	Store storage.Blockstore
+	Pins  *pin.Set
+	// Network is optional; without it the node only works on local data.
+	Network  *network.P2PNetworking
+	Chunking chunking.Params
+	// Downloads records unfinished downloads so they can be resumed.
+	// Defaults to a list kept in memory.
+	Downloads *Downloads
+	// Ops tracks resumed downloads so they can be cancelled. Optional.
+	Ops *ops.Registry
+	// Pressure scales down how many blocks are fetched at once when
+	// resources run low. Optional.
+	Pressure *pressure.Monitor
+	// MasterKey wraps the keys of encrypted files. Without it the node can
+	// neither add nor read them.
+	MasterKey *crypt.MasterKey
+	// RetiredMasterKeys unwrap the keys of files added before the master
+	// key was rotated.
+	RetiredMasterKeys []*crypt.MasterKey
+	// FileKeys keeps the key of every file encrypted here. Keys found in
+	// it need no master key to be used. Optional.
+	FileKeys FileKeys
+	// Shares keeps the capabilities other nodes granted this one to read
+	// their encrypted files. Optional.
+	Shares *share.Store
+	// Groups keeps the groups this node created or joined, whose keys
+	// encrypt the files added for them. Optional.
+	Groups *group.Store
+	// ColdStore is the cold tier pins are archived to. Optional.
+	ColdStore coldstore.Store
+	// ChunkIndex keeps the chunk checksums of files added with aligned
+	// chunking, for AddOptions.Base. Optional.
+	ChunkIndex *ChunkIndex
+	Logger     *zap.Logger
+}
+
+func NewNode(opts NodeOpts) *Node {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.Chunking.Strategy == "" {
+		opts.Chunking = chunking.DefaultParams()
+	}
+	if opts.Downloads == nil {
+		opts.Downloads, _ = OpenDownloads("")
+	}
+
+	return &Node{
+		store:    opts.Store,
+		network:  opts.Network,
+		logger:   opts.Logger,
+		NodeOpts: opts,
+	}
+}
+
+func (n *Node) Store() storage.Blockstore {
+	return n.store
+}
+
+// Network returns the node's networking, nil when running offline.
+func (n *Node) Network() *network.P2PNetworking {
+	return n.network
+}
+
+type AddOptions struct {
+	Name string
+	// Chunking overrides the node default when Strategy is set.
+	Chunking chunking.Params
+	// NoPin leaves the file unpinned, for one that is about to be put in a
+	// directory. Until then a session pin protects it, for at most
+	// SessionPinTimeout.
+	NoPin bool
+	// Encrypt seals the chunks with a new file key before they are stored
+	// or sent anywhere.
+	Encrypt bool
+	// Group encrypts the file with the current key of the group so
+	// called rather than the master key, for its members to read it.
+	// Implies Encrypt.
+	Group string
+	// Base is an earlier version of the file. When it was added here with
+	// the same aligned chunking, its chunks whose content didn't change
+	// are taken over without being hashed again. Optional.
+	Base cid.Cid
+}
+
+// SessionPinTimeout is how long a file or directory added without a pin
+// is kept for a directory to be made of it.
+const SessionPinTimeout = 24 * time.Hour
+
+type AddResult struct {
+	CID      cid.Cid
+	Manifest *manifest.Manifest
+	Stats    AddStats
+}
+
+// Add chunks r into the block store and stores its manifest. The manifest
+// CID is the content address of the file.
+func (n *Node) Add(ctx context.Context, r io.Reader, opts AddOptions) (*AddResult, error) {
+	// Blocks stored again refresh their write time; a collection keeps
+	// those written since the add started until its file is pinned
+	if n.Pins != nil {
+		defer n.Pins.Pinning()()
+	}
+	params := n.Chunking
+	if opts.Chunking.Strategy != "" {
+		params = opts.Chunking
+	}
+
+	var (
+		sealer     chunking.Sealer
+		fileKey    *crypt.FileKey
+		encryption *manifest.Encryption
+	)
+	if opts.Group != "" {
+		opts.Encrypt = true
+	}
+	if opts.Encrypt {
+		master := n.MasterKey
+		if opts.Group != "" {
+			var err error
+			if master, err = n.groupMasterKey(ctx, opts.Group); err != nil {
+				return nil, err
+			}
+		}
+		key, e, err := newFileKey(master)
+		if err != nil {
+			return nil, err
+		}
+		sealer, fileKey, encryption = key, key, e
+	}
+
+	counter := &dedupCounter{
+		store:   n.store,
+		stats:   &AddStats{Strategy: params.Strategy},
+		classes: make(map[int64]int),
+	}
+	// Only files chunked at page boundaries keep the offsets of their
+	// chunks as they change, which taking chunks over relies on
+	split := chunking.SplitOptions{
+		Sealer: sealer,
+		Sums:   params.Align > 0 && sealer == nil && n.ChunkIndex != nil,
+	}
+	if split.Sums && opts.Base.Defined() {
+		split.Earlier = n.earlier(ctx, opts.Base)
+	}
+	list, err := chunking.SplitWith(ctx, r, params, split, counter)
+	if err != nil {
+		return nil, err
+	}
+	counter.finish(list)
+
+	m, err := manifest.New(opts.Name, list)
+	if err != nil {
+		return nil, err
+	}
+	m.Encryption = encryption
+	if fileKey != nil {
+		if err := m.Seal(fileKey); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := manifest.Put(ctx, n.store, m)
+	if err != nil {
+		return nil, err
+	}
+	if split.Sums {
+		if err := n.ChunkIndex.Put(c, list.Sums); err != nil {
+			n.logger.Warn("Failed to index chunks", zap.String("cid", c.String()), zap.Error(err))
+		}
+	}
+	if fileKey != nil && n.FileKeys != nil {
+		// The master key still unwraps it, so the file stays readable
+		if err := n.FileKeys.PutFileKey(c, fileKey); err != nil {
+			n.logger.Warn("Failed to keep file key", zap.String("cid", c.String()), zap.Error(err))
+		}
+	}
+
+	if n.Pins != nil && !opts.NoPin {
+		if err := n.Pins.Add(c); err != nil {
+			return nil, err
+		}
+	} else if n.Pins != nil {
+		// Kept until the directory it is put in is pinned
+		n.Pins.Hold(c, SessionPinTimeout)
+	}
+
+	if n.network != nil {
+		cids := make([]cid.Cid, 0, len(m.Chunks)+1)
+		cids = append(cids, c)
+		for _, ref := range m.Chunks {
+			cids = append(cids, ref.CID)
+		}
+		n.network.Provide(cids...)
+		if !opts.NoPin {
+			name, size := m.Name, m.Size
+			if opts.Encrypt {
+				// What an encrypted file is called and how big it is
+				// stays with those holding its key
+				name, size = "", 0
+			}
+			n.announce(ctx, c, name, size)
+		}
+	}
+
+	n.logger.Info("File added",
+		zap.String("cid", c.String()),
+		zap.String("name", opts.Name),
+		zap.Int64("size", m.Size),
+		zap.Int("chunks", len(m.Chunks)),
+		zap.Int("dedup_chunks", counter.stats.DedupChunks),
+		zap.Int("reused_chunks", counter.stats.ReusedChunks),
+		zap.Bool("encrypted", opts.Encrypt),
+		zap.String("group", opts.Group),
+	)
+
+	return &AddResult{CID: c, Manifest: m, Stats: *counter.stats}, nil
+}
+
+// earlier returns the chunk list of base with the checksums of its
+// chunks, for a later version of it to take over the unchanged ones. It
+// returns nil when base isn't stored and indexed here.
+func (n *Node) earlier(ctx context.Context, base cid.Cid) *chunking.ChunkList {
+	sums, ok := n.ChunkIndex.Get(base)
+	if !ok {
+		return nil
+	}
+	m, err := manifest.Load(ctx, n.store, base)
+	if err != nil || m.Verify() != nil || m.Encryption != nil || len(sums) != len(m.Chunks) {
+		n.logger.Debug("Adding the file whole, its base can't be used", zap.String("base", base.String()), zap.Error(err))
+		return nil
+	}
+	return &chunking.ChunkList{Params: m.Params, Size: m.Size, Chunks: m.ChunkList(), Sums: sums}
+}
+
+// Stat loads the manifest of a file, fetching it from peers if it isn't
+// stored locally, unless the file is an archived pin.
+func (n *Node) Stat(ctx context.Context, c cid.Cid) (*manifest.Manifest, error) {
+	if err := n.checkArchived(c); err != nil {
+		return nil, err
+	}
+	return n.stat(ctx, n.newFetcher(), c)
+}
+
+func (n *Node) stat(ctx context.Context, f *fetcher, c cid.Cid) (*manifest.Manifest, error) {
+	m, err := manifest.Load(ctx, f, c)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest %s: %w", c, err)
+	}
+	if err := m.Verify(); err != nil {
+		return nil, err
+	}
+	// Nodes without the key hold an encrypted file without learning its
+	// name or size
+	if m.Sealed() {
+		if key, err := n.fileKey(c, m); err == nil {
+			if err := m.Open(key); err != nil {
+				return nil, fmt.Errorf("manifest %s: %w", c, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Get reassembles the file addressed by c into w. Blocks missing locally
+// are fetched from peers and kept, before any of the file is written; see
+// ResumeDownloads for what happens when fetching is interrupted. An
+// archived pin can't be read until it is recalled.
+func (n *Node) Get(ctx context.Context, c cid.Cid, w io.Writer) (*manifest.Manifest, error) {
+	if err := n.checkArchived(c); err != nil {
+		return nil, err
+	}
+	return n.get(ctx, n.newFetcher(), c, w)
+}
+
+func (n *Node) get(ctx context.Context, f *fetcher, c cid.Cid, w io.Writer) (*manifest.Manifest, error) {
+	m, err := n.stat(ctx, f, c)
+	if err != nil {
+		return nil, err
+	}
+	// Fail before fetching anything when the file can't be decrypted
+	sealer, err := n.sealer(c, m)
+	if err != nil {
+		return nil, err
+	}
+
+	err = n.download(ctx, f, c, func(ctx context.Context) error {
+		return f.fetchChunks(ctx, m.ChunkList())
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := chunking.ReassembleSealed(ctx, w, m.ChunkList(), sealer, f); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type PinOptions struct {
+	// Replicas sets the replication factor of the pin when positive,
+	// Class its replication class when not empty. Either replaces the
+	// other.
+	Replicas int
+	Class    string
+	// NoFetch records the pin without fetching anything. What isn't
+	// stored is fetched when the file is read or pinned again.
+	NoFetch bool
+	// Name and Labels are set on the pin, see pin.Set.Label.
+	Name   string
+	Labels map[string]string
+}
+
+// Pin protects a file or directory tree from removal, first fetching
+// whatever part of it isn't stored locally. An archived pin is recalled
+// rather than pinned again.
+func (n *Node) Pin(ctx context.Context, c cid.Cid, opts PinOptions) error {
+	if n.Pins == nil {
+		return fmt.Errorf("node has no pin set")
+	}
+	if err := n.checkArchived(c); err != nil {
+		return err
+	}
+	// Blocks found stored aren't fetched again, so they are touched for a
+	// collection not to sweep them before the pin is recorded
+	defer n.Pins.Pinning()()
+
+	var (
+		name string
+		size int64
+		f    = n.newFetcher()
+	)
+	f.touch = true
+	if !opts.NoFetch {
+		err := n.download(ctx, f, c, func(ctx context.Context) (err error) {
+			if c.Type() == manifest.DirectoryCodec {
+				// A whole tree comes in one exchange from a peer that has it
+				n.fetchSelected(ctx, f, c, nil)
+			}
+			name, size, err = n.fetchAll(ctx, f, c)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := n.Pins.AddWith(c, pin.AddOptions{
+		Replicas: opts.Replicas,
+		Class:    opts.Class,
+		Name:     opts.Name,
+		Labels:   opts.Labels,
+	})
+	if err != nil {
+		return err
+	}
+
+	if n.network != nil && !opts.NoFetch {
+		n.announce(ctx, c, name, size)
+	}
+
+	n.logger.Info("Pinned", zap.String("cid", c.String()))
+	return nil
+}
+
+// announce tells the cluster that this node holds the file or directory
+// rooted at c.
+func (n *Node) announce(ctx context.Context, c cid.Cid, name string, size int64) {
+	msg := network.ContentAdded{CID: c.String(), Name: name, Size: size}
+	if err := network.ContentAddedTopic.Publish(ctx, n.network.Bus(), msg); err != nil {
+		n.logger.Debug("Failed to announce content", zap.String("cid", c.String()), zap.Error(err))
+	}
+}
+
+// Unpin removes the pin of a file. Its blocks stay until garbage
+// collection removes them.
+func (n *Node) Unpin(c cid.Cid) error {
+	if n.Pins == nil {
+		return fmt.Errorf("node has no pin set")
+	}
+	removed, err := n.Pins.Remove(c)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return pin.ErrNotPinned
+	}
+
+	n.logger.Info("Unpinned", zap.String("cid", c.String()))
+	return nil
+}
+
+type Stats struct {
+	Storage storage.Stats
+	Pins    int
+	Peers   int
+}
+
+func (n *Node) Stats() Stats {
+	stats := Stats{Storage: n.store.Stats()}
+	if n.Pins != nil {
+		stats.Pins = n.Pins.Len()
+	}
+	if n.network != nil {
+		stats.Peers = len(n.network.Peers())
+	}
+	return stats
+}
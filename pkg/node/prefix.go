@@ -0,0 +1,101 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+// ShortLen is the shortest abbreviation Abbreviate returns. Hashes of
+// files, and of directories, share their first dozen characters, which
+// encode the codec and hash function, so this leaves six of the digest.
+const ShortLen = 18
+
+// ErrAmbiguous is returned for a prefix shared by several stored files or
+// directories.
+var ErrAmbiguous = errors.New("node: ambiguous hash prefix")
+
+// Expand returns the stored file or directory whose hash starts with
+// prefix. It reads the names of all stored blocks, so it is meant for
+// hashes typed by hand.
+func (n *Node) Expand(ctx context.Context, prefix string) (cid.Cid, error) {
+	roots, err := n.roots(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	i, _ := slices.BinarySearch(roots, prefix)
+	var matches []string
+	for ; i < len(roots) && strings.HasPrefix(roots[i], prefix); i++ {
+		matches = append(matches, roots[i])
+	}
+
+	switch len(matches) {
+	case 0:
+		return cid.Undef, fmt.Errorf("no stored file or directory starts with %s: %w", prefix, storage.ErrNotFound)
+	case 1:
+		return cid.Decode(matches[0])
+	default:
+		return cid.Undef, fmt.Errorf("%w %s: %d files and directories start with it", ErrAmbiguous, prefix, len(matches))
+	}
+}
+
+// Abbreviate shortens every hash in cids to the shortest prefix, of at
+// least ShortLen characters, that no other stored file or directory, nor
+// another of cids, starts with. Expand turns it back into the hash as
+// long as nothing is added that shares it.
+func (n *Node) Abbreviate(ctx context.Context, cids []cid.Cid) ([]string, error) {
+	roots, err := n.roots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cids {
+		roots = append(roots, c.String())
+	}
+	slices.Sort(roots)
+	roots = slices.Compact(roots)
+
+	short := make([]string, len(cids))
+	for i, c := range cids {
+		s := c.String()
+		j, _ := slices.BinarySearch(roots, s)
+		length := ShortLen
+		if j > 0 {
+			length = max(length, commonPrefix(s, roots[j-1])+1)
+		}
+		if j+1 < len(roots) {
+			length = max(length, commonPrefix(s, roots[j+1])+1)
+		}
+		short[i] = s[:min(length, len(s))]
+	}
+	return short, nil
+}
+
+// roots lists the hashes of the stored files and directories, sorted.
+func (n *Node) roots(ctx context.Context) ([]string, error) {
+	var roots []string
+	err := n.store.List(ctx, func(c cid.Cid) error {
+		if slices.Contains(manifest.LinkCodecs, c.Type()) {
+			roots = append(roots, c.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(roots)
+	return roots, nil
+}
+
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
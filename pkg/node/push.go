@@ -0,0 +1,59 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// ErrIncomplete is returned when pushing a file or directory tree this
+// node doesn't store all of.
+var ErrIncomplete = errors.New("node: not all of it is stored")
+
+// Push offers to, a peer accepting pushes, the file or directory tree
+// under c, and waits until to has fetched it from this node or declined.
+// Nothing is looked up, so to must be connected or its addresses known;
+// c must be stored here whole, see Pin.
+func (n *Node) Push(ctx context.Context, c cid.Cid, to peer.ID) error {
+	if n.network == nil {
+		return errors.New("node: pushing needs networking")
+	}
+	if err := n.checkArchived(c); err != nil {
+		return err
+	}
+	if _, complete := n.present(ctx, c, nil); !complete {
+		return fmt.Errorf("%s: %w", c, ErrIncomplete)
+	}
+	if err := n.network.Push(ctx, to, c); err != nil {
+		return err
+	}
+	n.logger.Info("Pushed", zap.String("cid", c.String()), zap.String("peer", to.String()))
+	return nil
+}
+
+// Fetch stores every block of the file or directory tree under c without
+// pinning it, fetching from from before looking anyone up, for a file
+// from pushed. Keeping it from being collected is up to the caller, such
+// as the push inbox.
+func (n *Node) Fetch(ctx context.Context, c cid.Cid, from peer.ID) error {
+	f := n.newFetcher()
+	f.hints = []peer.AddrInfo{{ID: from}}
+	if c.Type() == manifest.DirectoryCodec {
+		n.fetchSelected(ctx, f, c, nil)
+	}
+	_, _, err := n.fetchAll(ctx, f, c)
+	return err
+}
+
+// DescribeFrom is Describe fetching from from before looking anyone up,
+// for a file from is about to push.
+func (n *Node) DescribeFrom(ctx context.Context, c cid.Cid, from peer.ID) (string, int64, error) {
+	f := n.newFetcher()
+	f.hints = []peer.AddrInfo{{ID: from}}
+	return n.describe(ctx, f, c)
+}
@@ -0,0 +1,134 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/push"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TestPush sends a file to a peer that takes it into its inbox within its
+// policy, and files it refuses: over its size cap without fetching, and
+// past the inbox capacity.
+func TestPush(t *testing.T) {
+	nodes, sources := startNodes(t, 3, network.P2PNetworkingOpts{})
+	owner, receiver, stranger := nodes[0], nodes[1], nodes[2]
+	ctx := context.Background()
+
+	inbox, err := push.OpenInbox("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := push.NewReceiver(push.ReceiverOpts{
+		Network: receiver.network,
+		Inbox:   inbox,
+		Stat:    receiver.DescribeFrom,
+		Fetch:   receiver.Fetch,
+		Keep: func(ctx context.Context, c cid.Cid, name string) error {
+			return receiver.Pin(ctx, c, PinOptions{Name: name})
+		},
+		Policy: push.Policy{MaxSize: 10 << 10, Capacity: 10 << 10, Peers: []peer.ID{owner.network.Host().ID()}},
+	})
+	r.Start()
+	to := receiver.network.Host().ID()
+
+	small, err := owner.Add(ctx, bytes.NewReader(bytes.Repeat([]byte("small"), 1000)), AddOptions{Name: "small.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.Push(ctx, small.CID, to); err != nil {
+		t.Fatal(err)
+	}
+	items := inbox.List()
+	if len(items) != 1 || items[0].CID != small.CID || items[0].Name != "small.txt" || items[0].Received.IsZero() {
+		t.Fatalf("inbox after push: %+v", items)
+	}
+	if _, complete := receiver.present(ctx, small.CID, nil); !complete {
+		t.Error("pushed file not stored whole")
+	}
+	if receiver.Pins.Has(small.CID) {
+		t.Error("pushed file pinned before it was accepted")
+	}
+
+	if _, err := r.Accept(ctx, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := receiver.Pins.Get(small.CID)
+	if !ok || p.Name != "small.txt" {
+		t.Fatalf("accepted file pinned as %+v, %v", p, ok)
+	}
+	if len(inbox.List()) != 0 {
+		t.Error("accepted file left in the inbox")
+	}
+	if _, err := r.Accept(ctx, items[0].ID); !errors.Is(err, push.ErrNoItem) {
+		t.Errorf("accepting twice: %v", err)
+	}
+
+	// The inbox holds at most 10 KiB waiting
+	first, err := owner.Add(ctx, bytes.NewReader(bytes.Repeat([]byte("first"), 1200)), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := owner.Add(ctx, bytes.NewReader(bytes.Repeat([]byte("other"), 1200)), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.Push(ctx, first.CID, to); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.Push(ctx, second.CID, to); !errors.Is(err, network.ErrPushRefused) {
+		t.Fatalf("push past the inbox capacity: %v", err)
+	}
+	items = inbox.List()
+	if len(items) != 1 || items[0].CID != first.CID {
+		t.Fatalf("inbox after refused push: %+v", items)
+	}
+	if _, err := r.Reject(items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox.Roots()) != 0 || receiver.Pins.Has(first.CID) {
+		t.Error("rejected file kept")
+	}
+	if err := owner.Push(ctx, second.CID, to); err != nil {
+		t.Errorf("push after rejecting made room: %v", err)
+	}
+
+	large, err := owner.Add(ctx, bytes.NewReader(bytes.Repeat([]byte("large"), 4000)), AddOptions{Name: "large.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources[0].served.Store(0)
+	if err := owner.Push(ctx, large.CID, to); !errors.Is(err, network.ErrPushRefused) {
+		t.Fatalf("push over the size cap: %v", err)
+	}
+	// Only the root was fetched, to learn the size
+	if served := sources[0].served.Load(); served != 1 {
+		t.Errorf("refused push fetched %d blocks", served)
+	}
+
+	other, err := stranger.Add(ctx, bytes.NewReader([]byte("unasked")), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources[2].served.Store(0)
+	if err := stranger.Push(ctx, other.CID, to); !errors.Is(err, network.ErrPushRefused) {
+		t.Fatalf("push from a peer not allowed: %v", err)
+	}
+	if served := sources[2].served.Load(); served != 0 {
+		t.Errorf("push from a peer not allowed fetched %d blocks", served)
+	}
+
+	if err := receiver.Push(ctx, small.CID, owner.network.Host().ID()); !errors.Is(err, network.ErrPushRefused) {
+		t.Errorf("push to a peer not taking pushes: %v", err)
+	}
+
+	// Nothing is pushed that isn't stored whole
+	if err := receiver.Push(ctx, other.CID, owner.network.Host().ID()); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("push of a file not stored: %v", err)
+	}
+}
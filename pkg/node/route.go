@@ -0,0 +1,244 @@
+package node
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const pingTimeout = 5 * time.Second
+
+// RouteReport describes how a file was actually retrieved: which providers
+// were found, which of them supplied which byte ranges and where the time
+// went.
+type RouteReport struct {
+	CID  cid.Cid
+	Size int64
+
+	Total time.Duration
+	// Lookup is the time spent finding providers, Fetch the time spent
+	// transferring blocks from peers, including failed attempts.
+	Lookup time.Duration
+	Fetch  time.Duration
+
+	Lookups []RouteLookup
+	Peers   []RoutePeer
+	Chunks  []RouteChunk
+}
+
+type RouteLookup struct {
+	CID       cid.Cid
+	Providers []peer.ID
+	Duration  time.Duration
+	Err       error
+}
+
+// RoutePeer is a provider that was found or supplied blocks.
+type RoutePeer struct {
+	ID peer.ID
+	// RTT is measured with a ping after the retrieval. Zero with RTTErr
+	// set if the peer did not answer.
+	RTT    time.Duration
+	RTTErr error
+
+	Blocks   int
+	Bytes    int64
+	Duration time.Duration
+	Failures int
+	// Ranges are the file byte ranges the peer supplied, merged where
+	// adjacent.
+	Ranges []ByteRange
+}
+
+type ByteRange struct {
+	Offset int64
+	Size   int64
+}
+
+// RouteChunk is where one chunk came from. Source is empty for chunks that
+// were already stored locally.
+type RouteChunk struct {
+	CID      cid.Cid
+	Offset   int64
+	Size     int64
+	Source   peer.ID
+	Duration time.Duration
+	// Failed lists the peers that were asked for the chunk without success.
+	Failed []peer.ID
+}
+
+// Routes retrieves the file addressed by c and reports how it was done.
+// Blocks fetched along the way are kept, so a second run reports them as
+// local.
+func (n *Node) Routes(ctx context.Context, c cid.Cid) (*RouteReport, error) {
+	f := n.newFetcher()
+	f.trace = newRouteTrace()
+
+	start := time.Now()
+	m, err := n.get(ctx, f, c, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RouteReport{CID: c, Size: m.Size, Total: time.Since(start)}
+	t := f.trace
+
+	report.Lookups = t.lookups
+	for _, l := range t.lookups {
+		report.Lookup += l.Duration
+	}
+
+	peers := make(map[peer.ID]*RoutePeer)
+	peerFor := func(id peer.ID) *RoutePeer {
+		p, ok := peers[id]
+		if !ok {
+			p = &RoutePeer{ID: id}
+			peers[id] = p
+		}
+		return p
+	}
+	for _, l := range t.lookups {
+		for _, id := range l.Providers {
+			peerFor(id)
+		}
+	}
+
+	for _, chunk := range m.ChunkList() {
+		rc := RouteChunk{CID: chunk.CID, Offset: chunk.Offset, Size: chunk.Size}
+		if b, ok := t.blocks[chunk.CID]; ok {
+			rc.Source, rc.Duration, rc.Failed = b.source, b.duration, b.failed
+		}
+		report.Chunks = append(report.Chunks, rc)
+
+		if rc.Source != "" {
+			p := peerFor(rc.Source)
+			p.Blocks++
+			p.Bytes += rc.Size
+			p.Ranges = appendRange(p.Ranges, ByteRange{Offset: rc.Offset, Size: rc.Size})
+		}
+	}
+	for _, b := range t.blocks {
+		report.Fetch += b.fetchTime
+		if b.source != "" {
+			peerFor(b.source).Duration += b.duration
+		}
+		for _, id := range b.failed {
+			peerFor(id).Failures++
+		}
+	}
+
+	n.measureRTTs(ctx, peers)
+
+	for _, p := range peers {
+		report.Peers = append(report.Peers, *p)
+	}
+	sort.Slice(report.Peers, func(i, j int) bool {
+		if report.Peers[i].Bytes != report.Peers[j].Bytes {
+			return report.Peers[i].Bytes > report.Peers[j].Bytes
+		}
+		return report.Peers[i].ID < report.Peers[j].ID
+	})
+	return report, nil
+}
+
+func (n *Node) measureRTTs(ctx context.Context, peers map[peer.ID]*RoutePeer) {
+	if n.network == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p *RoutePeer) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+			defer cancel()
+			p.RTT, p.RTTErr = n.network.Ping(ctx, p.ID)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func appendRange(ranges []ByteRange, r ByteRange) []ByteRange {
+	if len(ranges) > 0 {
+		last := &ranges[len(ranges)-1]
+		if last.Offset+last.Size == r.Offset {
+			last.Size += r.Size
+			return ranges
+		}
+	}
+	return append(ranges, r)
+}
+
+// routeTrace collects what a fetcher did. A nil trace records nothing.
+type routeTrace struct {
+	mu      sync.Mutex
+	lookups []RouteLookup
+	blocks  map[cid.Cid]*blockTrace
+}
+
+type blockTrace struct {
+	source   peer.ID
+	duration time.Duration
+	// fetchTime includes failed attempts.
+	fetchTime time.Duration
+	failed    []peer.ID
+}
+
+func newRouteTrace() *routeTrace {
+	return &routeTrace{blocks: make(map[cid.Cid]*blockTrace)}
+}
+
+func (t *routeTrace) block(c cid.Cid) *blockTrace {
+	b, ok := t.blocks[c]
+	if !ok {
+		b = &blockTrace{}
+		t.blocks[c] = b
+	}
+	return b
+}
+
+func (t *routeTrace) lookup(c cid.Cid, providers []peer.AddrInfo, d time.Duration, err error) {
+	if t == nil {
+		return
+	}
+
+	l := RouteLookup{CID: c, Duration: d, Err: err}
+	for _, p := range providers {
+		l.Providers = append(l.Providers, p.ID)
+	}
+
+	t.mu.Lock()
+	t.lookups = append(t.lookups, l)
+	t.mu.Unlock()
+}
+
+func (t *routeTrace) fetched(c cid.Cid, p peer.ID, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	b := t.block(c)
+	b.source = p
+	b.duration = d
+	b.fetchTime += d
+	t.mu.Unlock()
+}
+
+func (t *routeTrace) failed(c cid.Cid, p peer.ID, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	b := t.block(c)
+	b.failed = append(b.failed, p)
+	b.fetchTime += d
+	t.mu.Unlock()
+}
@@ -0,0 +1,164 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// maxSelectPeers bounds the peers asked for a selection before leaving
+// the rest to fetching block by block.
+const maxSelectPeers = 3
+
+// Select lists the blocks of the directories from root along names, root
+// first, and then those of the tree the path ends at, from the local
+// store. It is what peers are sent when they ask for everything under a
+// path, see network.SelectProtocol.
+func (n *Node) Select(ctx context.Context, root cid.Cid, names []string) ([]cid.Cid, error) {
+	var path []cid.Cid
+	c := root
+	for i, name := range names {
+		d, err := manifest.LoadDirectory(ctx, n.store, c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(names[:i], "/"), err)
+		}
+		e, ok := d.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", strings.Join(names[:i+1], "/"), manifest.ErrNoEntry)
+		}
+		path = append(path, c)
+		c = e.CID
+	}
+	blocks, err := dagOf(ctx, n.store, c)
+	if err != nil {
+		return nil, err
+	}
+	return append(path, blocks...), nil
+}
+
+// FetchPath fetches everything under the path names below the directory
+// root and returns the CID the path leads to. A peer serving
+// network.SelectProtocol sends it all in one exchange; whatever that
+// leaves missing is fetched when the path is resolved and read.
+func (n *Node) FetchPath(ctx context.Context, root cid.Cid, names []string) (cid.Cid, error) {
+	if err := n.checkArchived(root); err != nil {
+		return cid.Undef, err
+	}
+	f := n.newFetcher()
+	n.fetchSelected(ctx, f, root, names)
+	return manifest.Resolve(ctx, f, root, names)
+}
+
+// fetchSelected stores the blocks under the path names below root that
+// aren't stored yet, asking the peers f knows of, or else those that
+// have root, for all of them at once. The peer that sent them becomes
+// f's hint. Failing is left to fetching block by block.
+func (n *Node) fetchSelected(ctx context.Context, f *fetcher, root cid.Cid, names []string) {
+	if n.network == nil {
+		return
+	}
+	have, complete := n.present(ctx, root, names)
+	if complete {
+		return
+	}
+
+	peers := f.hints
+	if len(peers) == 0 {
+		peers = n.network.Advertised(root)
+	}
+	if len(peers) == 0 {
+		found, err := n.network.FindProviders(ctx, root)
+		if err != nil {
+			n.logger.Debug("Not fetching a selection, no providers", zap.String("cid", root.String()), zap.Error(err))
+			return
+		}
+		peers = found
+	}
+
+	for i, pi := range peers {
+		if i == maxSelectPeers || ctx.Err() != nil {
+			return
+		}
+		if pi.ID == n.network.Host().ID() {
+			continue
+		}
+		stats, err := n.network.FetchSelect(ctx, pi.ID, root, names, have, n.store.Put)
+		if err != nil {
+			log := n.logger.Warn
+			if errors.Is(err, ctx.Err()) || errors.Is(err, network.ErrDiffUnavailable) {
+				log = n.logger.Debug
+			}
+			log("Failed to fetch selection",
+				zap.String("cid", root.String()),
+				zap.Strings("path", names),
+				zap.String("peer", pi.ID.String()),
+				zap.Error(err),
+			)
+			continue
+		}
+		if len(f.hints) == 0 {
+			f.hints = []peer.AddrInfo{pi}
+		}
+		n.logger.Debug("Fetched selection",
+			zap.String("cid", root.String()),
+			zap.Strings("path", names),
+			zap.String("peer", pi.ID.String()),
+			zap.Int("blocks", stats.Blocks),
+			zap.Int64("bytes", stats.Bytes),
+			zap.Int("shared", len(have)),
+		)
+		return
+	}
+}
+
+// present lists the blocks under the path names below root that are
+// stored, and reports whether that is all of them.
+func (n *Node) present(ctx context.Context, root cid.Cid, names []string) ([]cid.Cid, bool) {
+	var have []cid.Cid
+	c := root
+	for _, name := range names {
+		d, err := manifest.LoadDirectory(ctx, n.store, c)
+		if err != nil {
+			return have, false
+		}
+		e, ok := d.Lookup(name)
+		if !ok {
+			// Nothing more to fetch, resolving the path fails on it
+			return have, true
+		}
+		have = append(have, c)
+		c = e.CID
+	}
+
+	complete := true
+	queue := []cid.Cid{c}
+	seen := map[cid.Cid]bool{c: true}
+	for len(queue) > 0 {
+		b := queue[0]
+		queue = queue[1:]
+		if ok, err := n.store.Has(ctx, b); err != nil || !ok {
+			complete = false
+			continue
+		}
+		have = append(have, b)
+		links, err := manifest.Links(ctx, n.store, b)
+		if err != nil {
+			complete = false
+			continue
+		}
+		for _, l := range links {
+			if !seen[l] {
+				seen[l] = true
+				queue = append(queue, l)
+			}
+		}
+	}
+	return have, complete
+}
@@ -0,0 +1,83 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/ipfs/go-cid"
+)
+
+// TestFetchPathSelects fetches /photos/2024 out of a larger tree: the
+// directories on the way and the tree under it come in one exchange, and
+// nothing else of the tree does.
+func TestFetchPathSelects(t *testing.T) {
+	nodes, sources := startNodes(t, 2, network.P2PNetworkingOpts{})
+	owner, holder := nodes[0], nodes[1]
+	ctx := context.Background()
+
+	file := func(name string, size int) DirectoryEntry {
+		t.Helper()
+		res, err := owner.Add(ctx, bytes.NewReader(bytes.Repeat([]byte(name), size/len(name))), AddOptions{Name: name, NoPin: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return DirectoryEntry{Name: name, CID: res.CID}
+	}
+	dir := func(entries ...DirectoryEntry) cid.Cid {
+		t.Helper()
+		c, _, err := owner.MakeDirectory(ctx, entries, DirectoryOptions{NoPin: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	year := dir(file("a.jpg", 5000), DirectoryEntry{Name: "trip", CID: dir(file("b.jpg", 3000))})
+	old := dir(file("c.jpg", 4000))
+	photos := dir(DirectoryEntry{Name: "2024", CID: year}, DirectoryEntry{Name: "2023", CID: old})
+	root := dir(DirectoryEntry{Name: "photos", CID: photos}, file("notes.txt", 2000))
+
+	want, err := owner.Select(ctx, root, []string{"photos", "2024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	under, err := owner.DAG(ctx, year)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(want, append([]cid.Cid{root, photos}, under...)) {
+		t.Fatalf("selection %v", want)
+	}
+
+	sources[0].served.Store(0)
+	got, err := holder.FetchPath(ctx, root, []string{"photos", "2024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != year {
+		t.Fatalf("path led to %s, want %s", got, year)
+	}
+	if served := sources[0].served.Load(); served != int64(len(want)) {
+		t.Errorf("owner served %d blocks, want the %d selected", served, len(want))
+	}
+	if _, complete := holder.present(ctx, root, []string{"photos", "2024"}); !complete {
+		t.Error("selection not stored")
+	}
+	if ok, _ := holder.store.Has(ctx, old); ok {
+		t.Error("fetched a directory outside the selection")
+	}
+
+	// Pinning the path fetches nothing more
+	if err := holder.Pin(ctx, got, PinOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if served := sources[0].served.Load(); served != int64(len(want)) {
+		t.Errorf("owner served %d blocks, want no more than the %d selected", served, len(want))
+	}
+
+	if _, err := holder.network.FetchSelect(ctx, owner.network.Host().ID(), root, []string{"photos", "1999"}, nil, holder.store.Put); err == nil {
+		t.Error("selected a missing path")
+	}
+}
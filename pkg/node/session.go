@@ -0,0 +1,456 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	// requestsPerPeer keeps a few requests in flight per peer so transfers
+	// don't wait on round trips.
+	requestsPerPeer = 4
+
+	// A request stalls when it takes stallFactor times longer than the
+	// peer's observed rate predicts, bounded by min and max. Until a peer
+	// delivered a block its requests get initialStallTimeout.
+	stallFactor         = 4
+	minStallTimeout     = 2 * time.Second
+	maxStallTimeout     = 30 * time.Second
+	initialStallTimeout = 10 * time.Second
+	// initialHedgeDelay is how long a peer without a known rate gets
+	// before another peer is asked as well.
+	initialHedgeDelay = time.Second
+
+	// maxPeerStrikes consecutive stalls or failures drop a peer from the
+	// session.
+	maxPeerStrikes = 3
+	// maxAttempts bounds how many peers are asked for one chunk at once.
+	maxAttempts = 2
+
+	rateSmoothing = 0.3
+	idleWait      = 50 * time.Millisecond
+)
+
+// session fetches the missing chunks of a file from several peers at once.
+// It tracks how fast each peer delivers and moves chunks away from peers
+// that stall: a stalled request is cancelled and requeued, and once the
+// queue runs dry idle peers that are faster also request the chunks still
+// outstanding at slower peers, so a dying peer can't hold up the tail of a
+// download.
+type session struct {
+	f *fetcher
+
+	mu        sync.Mutex
+	queue     []chunking.Chunk
+	sizes     map[cid.Cid]int64
+	attempts  map[cid.Cid][]*attempt
+	done      map[cid.Cid]bool
+	remaining int
+	peers     []*peerState
+}
+
+// peerState is what a session learned about one peer.
+type peerState struct {
+	info    peer.AddrInfo
+	rate    float64 // bytes per second, zero until a block arrived
+	strikes int
+	dropped bool
+	lacks   map[cid.Cid]bool
+}
+
+type attempt struct {
+	peer    *peerState
+	started time.Time
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func (p *peerState) stallTimeout(size int64) time.Duration {
+	if p.rate == 0 {
+		return initialStallTimeout
+	}
+	d := time.Duration(float64(size) / p.rate * stallFactor * float64(time.Second))
+	return min(max(d, minStallTimeout), maxStallTimeout)
+}
+
+// hedgeAfter is how long p may take for size bytes before a faster peer
+// is asked as well.
+func (p *peerState) hedgeAfter(size int64) time.Duration {
+	if p.rate == 0 {
+		return initialHedgeDelay
+	}
+	return 2 * time.Duration(float64(size)/p.rate*float64(time.Second))
+}
+
+// fetchChunks makes sure every chunk is stored locally: prefetch gets what
+// it can from several peers at once, and the rest is looked up one by one.
+func (f *fetcher) fetchChunks(ctx context.Context, chunks []chunking.Chunk) error {
+	if f.n.network == nil {
+		if !f.touch {
+			return nil
+		}
+		// Nothing can be fetched, but what is stored is touched all the
+		// same
+		for _, chunk := range chunks {
+			has, err := f.has(ctx, chunk.CID)
+			if err != nil {
+				return err
+			}
+			if !has {
+				return fmt.Errorf("fetch %s: %w", chunk.CID, storage.ErrNotFound)
+			}
+		}
+		return nil
+	}
+	if err := f.prefetch(ctx, chunks); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		has, err := f.has(ctx, chunk.CID)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := f.Get(ctx, chunk.CID); err != nil {
+			return fmt.Errorf("fetch %s: %w", chunk.CID, err)
+		}
+	}
+	return nil
+}
+
+// prefetch fetches every chunk that isn't stored locally. Whatever it
+// could not get is left for the sequential path, which looks up providers
+// per block.
+func (f *fetcher) prefetch(ctx context.Context, chunks []chunking.Chunk) error {
+	var (
+		missing []chunking.Chunk
+		size    int64
+	)
+	seen := make(map[cid.Cid]bool)
+	for _, chunk := range chunks {
+		if seen[chunk.CID] {
+			continue
+		}
+		seen[chunk.CID] = true
+
+		has, err := f.has(ctx, chunk.CID)
+		if err != nil {
+			return err
+		}
+		if !has {
+			missing = append(missing, chunk)
+			size += chunk.Size
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	ops.FromContext(ctx).AddMissing(len(missing), size)
+
+	providers := f.hints
+	if len(providers) == 0 {
+		providers = f.n.network.Advertised(missing[0].CID)
+	}
+	if len(providers) == 0 {
+		start := time.Now()
+		found, err := f.n.network.FindProviders(ctx, missing[0].CID)
+		f.trace.lookup(missing[0].CID, found, time.Since(start), err)
+		if err != nil {
+			return nil
+		}
+		providers = found
+	}
+	// Skipping what inventories rule out saves a round trip per block. An
+	// inventory that lags is made up for by the lookups after.
+	return f.fetch(ctx, missing, providers, f.n.network.Lacks)
+}
+
+// fetch runs a session getting chunks from providers and keeps what
+// arrives in the local store. Chunks lacks reports for a provider aren't
+// asked of it.
+func (f *fetcher) fetch(ctx context.Context, chunks []chunking.Chunk, providers []peer.AddrInfo, lacks func(peer.ID, cid.Cid) bool) error {
+	s := &session{
+		f:         f,
+		queue:     chunks,
+		sizes:     make(map[cid.Cid]int64),
+		attempts:  make(map[cid.Cid][]*attempt),
+		done:      make(map[cid.Cid]bool),
+		remaining: len(chunks),
+	}
+	for _, chunk := range chunks {
+		s.sizes[chunk.CID] = chunk.Size
+	}
+	for _, pi := range providers {
+		p := &peerState{info: pi, lacks: make(map[cid.Cid]bool)}
+		for _, chunk := range chunks {
+			if lacks != nil && lacks(pi.ID, chunk.CID) {
+				p.lacks[chunk.CID] = true
+			}
+		}
+		s.peers = append(s.peers, p)
+	}
+	ops.FromContext(ctx).SetPeers(len(s.peers))
+
+	var wg sync.WaitGroup
+	for _, p := range s.peers {
+		for slot := range min(requestsPerPeer, len(chunks)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.work(ctx, p, slot)
+			}()
+		}
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// work requests chunks from p, one at a time. slot numbers the worker
+// among p's; under resource pressure the higher ones pause.
+func (s *session) work(ctx context.Context, p *peerState, slot int) {
+	for ctx.Err() == nil {
+		if slot >= s.f.n.Pressure.Limit(requestsPerPeer) {
+			if s.over(p) {
+				return
+			}
+			time.Sleep(idleWait)
+			continue
+		}
+		chunk, a, ok, wait := s.next(ctx, p)
+		if !ok {
+			return
+		}
+		if wait {
+			time.Sleep(idleWait)
+			continue
+		}
+
+		data, from, err := s.f.n.network.WantBlock(a.ctx, p.info, chunk.CID)
+		stalled := errors.Is(a.ctx.Err(), context.DeadlineExceeded)
+		a.cancel()
+		s.finish(ctx, chunk, a, data, from, err, stalled)
+	}
+}
+
+// over reports whether p has nothing left to do.
+func (s *session) over(p *peerState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remaining == 0 || p.dropped
+}
+
+// next picks the chunk p should request: the next queued one, or one that
+// is taking too long at a slower peer. wait is set when there is nothing
+// to do yet but other chunks are still outstanding.
+func (s *session) next(ctx context.Context, p *peerState) (chunk chunking.Chunk, a *attempt, ok, wait bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.remaining == 0 || p.dropped {
+		return chunk, nil, false, false
+	}
+
+	for i := 0; i < len(s.queue); {
+		chunk = s.queue[i]
+		switch {
+		case s.done[chunk.CID]:
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		case p.lacks[chunk.CID]:
+			i++
+		default:
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return chunk, s.start(ctx, chunk, p), true, false
+		}
+	}
+
+	// Hedge: take over a chunk a slower peer is stalling on.
+	now := time.Now()
+	for c, attempts := range s.attempts {
+		if s.done[c] || len(attempts) >= maxAttempts {
+			continue
+		}
+
+		owner := attempts[0]
+		if owner.peer == p || p.lacks[c] || (p.rate > 0 && owner.peer.rate >= p.rate) {
+			continue
+		}
+		size := s.sizes[c]
+		if now.Sub(owner.started) < owner.peer.hedgeAfter(size) {
+			continue
+		}
+
+		s.f.n.logger.Debug("Reassigning chunk from slow peer",
+			zap.String("cid", c.String()),
+			zap.String("from", owner.peer.info.ID.String()),
+			zap.String("to", p.info.ID.String()),
+		)
+		chunk = chunking.Chunk{CID: c, Size: size}
+		return chunk, s.start(ctx, chunk, p), true, false
+	}
+
+	if len(s.attempts) == 0 && s.stuck() {
+		// Every chunk left is missing from every peer still around.
+		s.remaining = 0
+		return chunk, nil, false, false
+	}
+	return chunk, nil, true, true
+}
+
+// stuck reports whether no live peer can serve any queued chunk. Callers
+// hold s.mu.
+func (s *session) stuck() bool {
+	for _, chunk := range s.queue {
+		if s.done[chunk.CID] {
+			continue
+		}
+		for _, p := range s.peers {
+			if !p.dropped && !p.lacks[chunk.CID] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// start records a request for chunk at p. Callers hold s.mu.
+func (s *session) start(ctx context.Context, chunk chunking.Chunk, p *peerState) *attempt {
+	a := &attempt{peer: p, started: time.Now()}
+	a.ctx, a.cancel = context.WithTimeout(ctx, p.stallTimeout(chunk.Size))
+	s.attempts[chunk.CID] = append(s.attempts[chunk.CID], a)
+	return a
+}
+
+// finish records how a request went. The block may have come from another
+// peer than the one asked, when someone else wanted it too.
+func (s *session) finish(ctx context.Context, chunk chunking.Chunk, a *attempt, data []byte, from peer.ID, err error, stalled bool) {
+	p := a.peer
+	elapsed := time.Since(a.started)
+
+	s.mu.Lock()
+	s.removeAttempt(chunk.CID, a)
+
+	if err == nil {
+		if from == p.info.ID {
+			sample := float64(len(data)) / max(elapsed.Seconds(), 1e-6)
+			if p.rate == 0 {
+				p.rate = sample
+			} else {
+				p.rate = rateSmoothing*sample + (1-rateSmoothing)*p.rate
+			}
+			p.strikes = 0
+		}
+
+		if s.done[chunk.CID] {
+			s.mu.Unlock()
+			return
+		}
+		s.done[chunk.CID] = true
+		s.remaining--
+		for _, other := range s.attempts[chunk.CID] {
+			other.cancel()
+		}
+		delete(s.attempts, chunk.CID)
+		s.mu.Unlock()
+
+		ops.FromContext(ctx).AddFetched(len(data))
+		s.f.trace.fetched(chunk.CID, from, elapsed)
+		s.f.n.logger.Debug("Fetched block",
+			zap.String("cid", chunk.CID.String()),
+			zap.String("peer", from.String()),
+			zap.Int("size", len(data)),
+		)
+		// Kept the way this node keeps what it adds.
+		if err := s.f.n.store.Put(storage.WithCompression(ctx, s.f.n.Chunking.Compression), chunk.CID, data); err != nil {
+			s.f.n.logger.Warn("Failed to keep fetched block", zap.String("cid", chunk.CID.String()), zap.Error(err))
+			return
+		}
+		s.f.n.network.Provide(chunk.CID)
+		return
+	}
+
+	// Lost the race to another peer, or the whole fetch was cancelled.
+	if s.done[chunk.CID] || ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+
+	if errors.Is(err, network.ErrBlockNotFound) {
+		p.lacks[chunk.CID] = true
+	} else {
+		p.strikes++
+	}
+	if stalled {
+		s.f.n.logger.Info("Peer stalled, reassigning chunk",
+			zap.String("peer", p.info.ID.String()),
+			zap.String("cid", chunk.CID.String()),
+			zap.Duration("after", elapsed),
+		)
+	}
+	if p.strikes >= maxPeerStrikes && !p.dropped {
+		p.dropped = true
+		ops.FromContext(ctx).SetPeers(s.livePeers())
+		s.f.n.logger.Warn("Dropping peer from fetch",
+			zap.String("peer", p.info.ID.String()),
+			zap.Int("strikes", p.strikes),
+		)
+	}
+	if len(s.attempts[chunk.CID]) == 0 {
+		delete(s.attempts, chunk.CID)
+		s.queue = append([]chunking.Chunk{chunk}, s.queue...)
+	}
+	if s.allDropped() {
+		// Nobody left to ask; the sequential path takes over.
+		s.remaining = 0
+	}
+	s.mu.Unlock()
+
+	s.f.trace.failed(chunk.CID, p.info.ID, elapsed)
+	s.f.n.logger.Debug("Fetch failed",
+		zap.String("cid", chunk.CID.String()),
+		zap.String("peer", p.info.ID.String()),
+		zap.Error(err),
+	)
+}
+
+func (s *session) removeAttempt(c cid.Cid, a *attempt) {
+	attempts := s.attempts[c]
+	for i, other := range attempts {
+		if other == a {
+			s.attempts[c] = append(attempts[:i], attempts[i+1:]...)
+			return
+		}
+	}
+}
+
+// livePeers counts the peers not dropped. Callers hold s.mu.
+func (s *session) livePeers() int {
+	n := 0
+	for _, p := range s.peers {
+		if !p.dropped {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *session) allDropped() bool {
+	for _, p := range s.peers {
+		if !p.dropped {
+			return false
+		}
+	}
+	return true
+}
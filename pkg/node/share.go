@@ -0,0 +1,128 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/share"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// ErrNotEncrypted is returned when sharing a file that isn't encrypted,
+// which anyone with its hash can read.
+var ErrNotEncrypted = errors.New("node: file is not encrypted")
+
+// Share issues a capability granting to access to the encrypted file c,
+// until expires unless that is zero. It is signed with the node key.
+func (n *Node) Share(ctx context.Context, c cid.Cid, to peer.ID, expires time.Time) (*share.Capability, error) {
+	if c.Type() == manifest.DirectoryCodec {
+		return nil, fmt.Errorf("%s is a directory: share the files in it", c)
+	}
+	m, err := n.Stat(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if m.Encryption == nil {
+		return nil, fmt.Errorf("%s: %w, its hash is all it takes to read it", m.Name, ErrNotEncrypted)
+	}
+	key, err := n.fileKey(c, m)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := n.identity()
+	if err != nil {
+		return nil, err
+	}
+	return share.Issue(priv, to, c, key, expires)
+}
+
+// Redeem checks that capability grants this node access to a file and
+// keeps it, to read the file with for as long as it is valid.
+func (n *Node) Redeem(capability *share.Capability) (cid.Cid, error) {
+	if n.Shares == nil {
+		return cid.Undef, errors.New("node has no share store")
+	}
+	priv, err := n.identity()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if _, err := capability.Open(priv, time.Now()); err != nil {
+		return cid.Undef, err
+	}
+	if err := n.checkIssuer(capability.Issuer); err != nil {
+		return cid.Undef, err
+	}
+	// Checked by Open
+	c, _ := cid.Decode(capability.CID)
+	if err := n.Shares.Put(capability); err != nil {
+		return cid.Undef, err
+	}
+
+	n.logger.Info("Redeemed share",
+		zap.String("cid", c.String()),
+		zap.String("peer", capability.Issuer),
+	)
+	return c, nil
+}
+
+// sharedKey unwraps the key of the file c from the capability redeemed
+// for it, if any.
+func (n *Node) sharedKey(c cid.Cid) (*crypt.FileKey, bool, error) {
+	if n.Shares == nil {
+		return nil, false, nil
+	}
+	capability, ok := n.Shares.Get(c.String())
+	if !ok {
+		return nil, false, nil
+	}
+	priv, err := n.identity()
+	if err != nil {
+		return nil, true, err
+	}
+	if err := n.checkIssuer(capability.Issuer); err != nil {
+		return nil, true, err
+	}
+	key, err := capability.Open(priv, time.Now())
+	if err != nil {
+		return nil, true, fmt.Errorf("shared by %s: %w", capability.Issuer, err)
+	}
+	return key, true, nil
+}
+
+// checkIssuer refuses capabilities signed by a revoked key, even those
+// redeemed before the revocation.
+func (n *Node) checkIssuer(s string) error {
+	if n.network == nil {
+		return nil
+	}
+	issuer, err := peer.Decode(s)
+	if err != nil {
+		return err
+	}
+	if n.network.Revocations.Revoked(issuer) {
+		return fmt.Errorf("shared by %s: %w", issuer, network.ErrRevoked)
+	}
+	return nil
+}
+
+// identity is the node key, which signs the capabilities the node issues
+// and unwraps those it redeems.
+func (n *Node) identity() (crypto.PrivKey, error) {
+	if n.network == nil || n.network.Host() == nil {
+		return nil, errors.New("node: sharing needs the node key, which is loaded with networking")
+	}
+	h := n.network.Host()
+	priv := h.Peerstore().PrivKey(h.ID())
+	if priv == nil {
+		return nil, errors.New("node: no node key")
+	}
+	return priv, nil
+}
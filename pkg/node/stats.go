@@ -0,0 +1,132 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/Noah-Wilderom/dfs/pkg/metrics"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+// AddStats describes how a file was chunked and how much of it was
+// already stored, to help tune the chunker.
+type AddStats struct {
+	Strategy string
+	Chunks   int
+	MinChunk int64
+	MaxChunk int64
+	// Sizes counts chunks per power of two size class.
+	Sizes []SizeClass
+
+	// DedupChunks and DedupBytes count chunks that were already stored,
+	// including repeats within the file itself.
+	DedupChunks int
+	DedupBytes  int64
+	// ReusedChunks and ReusedBytes count the chunks of those taken over
+	// from the file's base without being hashed, see AddOptions.Base.
+	ReusedChunks int
+	ReusedBytes  int64
+}
+
+// SizeClass counts the chunks with a size in (UpTo/2, UpTo].
+type SizeClass struct {
+	UpTo  int64
+	Count int
+}
+
+// DedupRatio is the fraction of the file's bytes that were already stored.
+func (s AddStats) DedupRatio(size int64) float64 {
+	if size == 0 {
+		return 0
+	}
+	return float64(s.DedupBytes) / float64(size)
+}
+
+// dedupCounter counts the blocks put through it that the store already has.
+type dedupCounter struct {
+	store   storage.Blockstore
+	stats   *AddStats
+	classes map[int64]int
+}
+
+func (d *dedupCounter) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	has, err := d.store.Has(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	d.count(ctx, int64(len(data)), has)
+	if has {
+		return nil
+	}
+	return d.store.Put(ctx, c, data)
+}
+
+// Reuse takes a chunk of the file's base over, touching it so that a
+// collection keeps it until the file is pinned.
+func (d *dedupCounter) Reuse(ctx context.Context, chunk chunking.Chunk) (bool, error) {
+	t, ok := d.store.(toucher)
+	if !ok {
+		return false, nil
+	}
+	err := t.Touch(ctx, chunk.CID)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrReadOnly) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	d.count(ctx, chunk.Size, true)
+	d.stats.ReusedChunks++
+	d.stats.ReusedBytes += chunk.Size
+	return true, nil
+}
+
+// count records a chunk of the file, stored already or not.
+func (d *dedupCounter) count(ctx context.Context, size int64, has bool) {
+	if d.stats.Chunks == 0 || size < d.stats.MinChunk {
+		d.stats.MinChunk = size
+	}
+	if size > d.stats.MaxChunk {
+		d.stats.MaxChunk = size
+	}
+	d.stats.Chunks++
+	d.classes[sizeClass(size)]++
+	metrics.ChunkSize.WithLabelValues(d.stats.Strategy).Observe(float64(size))
+
+	ops.FromContext(ctx).AddChunk()
+	if has {
+		d.stats.DedupChunks++
+		d.stats.DedupBytes += size
+	}
+}
+
+// finish fills in the size classes and records the add in the metrics.
+func (d *dedupCounter) finish(list *chunking.ChunkList) {
+	for upTo, n := range d.classes {
+		d.stats.Sizes = append(d.stats.Sizes, SizeClass{UpTo: upTo, Count: n})
+	}
+	sort.Slice(d.stats.Sizes, func(i, j int) bool {
+		return d.stats.Sizes[i].UpTo < d.stats.Sizes[j].UpTo
+	})
+
+	metrics.AddedBytes.Add(float64(list.Size))
+	metrics.DedupBytes.Add(float64(d.stats.DedupBytes))
+	if list.Size > 0 {
+		metrics.DedupRatio.Observe(d.stats.DedupRatio(list.Size))
+	}
+}
+
+// sizeClass rounds size up to a power of two.
+func sizeClass(size int64) int64 {
+	if size <= 1 {
+		return 1
+	}
+	return 1 << bits.Len64(uint64(size-1))
+}
@@ -0,0 +1,64 @@
+package node
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Noah-Wilderom/dfs/pkg/manifest"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+// Damage is what Verify found wrong with the local copy of a file or
+// directory tree.
+type Damage struct {
+	// Blocks is the number of blocks checked, DAG the blocks of the tree
+	// as far as it could be walked, root first.
+	Blocks  int
+	DAG     []cid.Cid
+	Missing []cid.Cid
+	Corrupt []cid.Cid
+}
+
+// Intact reports whether every block was present and valid.
+func (d *Damage) Intact() bool {
+	return len(d.Missing) == 0 && len(d.Corrupt) == 0
+}
+
+// Verify reads every block of the file or directory tree rooted at c from
+// the local store and checks it against its CID. Unlike DAG it carries on
+// past missing and corrupt blocks, though what they link to can't be
+// checked. Nothing is fetched.
+func (n *Node) Verify(ctx context.Context, c cid.Cid) (*Damage, error) {
+	d := &Damage{DAG: []cid.Cid{c}}
+	seen := map[cid.Cid]bool{c: true}
+	for i := 0; i < len(d.DAG); i++ {
+		b := d.DAG[i]
+		data, err := n.store.Get(ctx, b)
+		if errors.Is(err, storage.ErrNotFound) {
+			d.Missing = append(d.Missing, b)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.Blocks++
+		if storage.Verify(b, data) != nil {
+			d.Corrupt = append(d.Corrupt, b)
+			continue
+		}
+
+		links, err := manifest.DecodeLinks(b, data)
+		if err != nil {
+			d.Corrupt = append(d.Corrupt, b)
+			continue
+		}
+		for _, l := range links {
+			if !seen[l] {
+				seen[l] = true
+				d.DAG = append(d.DAG, l)
+			}
+		}
+	}
+	return d, nil
+}
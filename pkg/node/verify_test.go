@@ -0,0 +1,78 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/chunking"
+	"github.com/ipfs/go-cid"
+)
+
+// Verify finds the chunks that went missing or bad on disk and checks
+// the rest.
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	n, store := openNode(t, chunking.Params{Strategy: chunking.StrategyFixed, Size: 1024})
+	data := make([]byte, 4*1024)
+	for i := range data {
+		data[i] = byte(i / 1024)
+	}
+	res, err := n.Add(ctx, bytes.NewReader(data), AddOptions{Name: "a.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := n.Verify(ctx, res.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Intact() || d.Blocks != 5 || len(d.DAG) != 5 {
+		t.Fatalf("Verify = %+v, want 5 intact blocks", d)
+	}
+
+	chunks := res.Manifest.Chunks
+	if err := store.Delete(ctx, chunks[1].CID); err != nil {
+		t.Fatal(err)
+	}
+	corrupt(t, store.Root(), chunks[2].CID)
+
+	d, err = n.Verify(ctx, res.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Missing) != 1 || !d.Missing[0].Equals(chunks[1].CID) {
+		t.Errorf("missing = %v, want %s", d.Missing, chunks[1].CID)
+	}
+	if len(d.Corrupt) != 1 || !d.Corrupt[0].Equals(chunks[2].CID) {
+		t.Errorf("corrupt = %v, want %s", d.Corrupt, chunks[2].CID)
+	}
+	if d.Blocks != 4 {
+		t.Errorf("blocks read = %d, want 4", d.Blocks)
+	}
+}
+
+// corrupt flips a bit of the block c in the store at root.
+func corrupt(t *testing.T, root string, c cid.Cid) {
+	t.Helper()
+	found := false
+	err := filepath.WalkDir(root, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() || !strings.HasPrefix(e.Name(), c.String()+".") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data[0] ^= 1
+		found = true
+		return os.WriteFile(path, data, 0644)
+	})
+	if err != nil || !found {
+		t.Fatalf("corrupting %s: %v, found %v", c, err, found)
+	}
+}
@@ -0,0 +1,200 @@
+// Package ops tracks the daemon's long-running operations so they can be
+// listed and cancelled from outside the request that started them.
+package ops
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Operation kinds.
+const (
+	KindAdd       = "add"
+	KindGet       = "get"
+	KindPin       = "pin"
+	KindStat      = "stat"
+	KindReplicate = "replicate"
+	KindGC        = "gc"
+	KindVerify    = "verify"
+	KindArchive   = "archive"
+	KindRecall    = "recall"
+	KindPush      = "push"
+	KindReceive   = "receive"
+)
+
+var (
+	ErrNotFound = errors.New("ops: no such operation")
+	// ErrCanceled is the cause of the context of a cancelled operation.
+	ErrCanceled = errors.New("ops: operation cancelled")
+)
+
+// Op is a running operation.
+type Op struct {
+	ID   uint64
+	Kind string
+	// Target is what the operation works on, usually a CID or file name.
+	Target  string
+	Started time.Time
+
+	bytes  atomic.Int64
+	cancel context.CancelCauseFunc
+
+	// Chunks stored, for operations that add.
+	chunks atomic.Int64
+
+	// Blocks fetched from peers, for operations that download.
+	missing       atomic.Int64
+	fetched       atomic.Int64
+	missingBlocks atomic.Int64
+	fetchedBlocks atomic.Int64
+	peers         atomic.Int32
+}
+
+type opKey struct{}
+
+// FromContext returns the operation a context returned by Start belongs
+// to, or nil.
+func FromContext(ctx context.Context) *Op {
+	op, _ := ctx.Value(opKey{}).(*Op)
+	return op
+}
+
+// AddBytes records progress. It is safe on a nil Op.
+func (o *Op) AddBytes(n int) {
+	if o != nil {
+		o.bytes.Add(int64(n))
+	}
+}
+
+// Bytes is the amount of data the operation has moved so far.
+func (o *Op) Bytes() int64 {
+	return o.bytes.Load()
+}
+
+// AddChunk records a chunk stored. It is safe on a nil Op, like the other
+// progress methods.
+func (o *Op) AddChunk() {
+	if o != nil {
+		o.chunks.Add(1)
+	}
+}
+
+// Chunks is the number of chunks the operation has stored so far.
+func (o *Op) Chunks() int64 {
+	return o.chunks.Load()
+}
+
+// AddMissing records blocks totalling n bytes found missing locally, to be
+// fetched from peers.
+func (o *Op) AddMissing(blocks int, n int64) {
+	if o != nil {
+		o.missingBlocks.Add(int64(blocks))
+		o.missing.Add(n)
+	}
+}
+
+// AddFetched records a block of n bytes fetched from peers.
+func (o *Op) AddFetched(n int) {
+	if o != nil {
+		o.fetchedBlocks.Add(1)
+		o.fetched.Add(int64(n))
+	}
+}
+
+// SetPeers records how many peers the operation is fetching from.
+func (o *Op) SetPeers(n int) {
+	if o != nil {
+		o.peers.Store(int32(n))
+	}
+}
+
+// Fetched returns the bytes fetched from peers so far and the bytes
+// found missing, which fetched may slightly exceed: metadata blocks are
+// fetched before anything is known to be missing.
+func (o *Op) Fetched() (fetched, missing int64) {
+	return o.fetched.Load(), o.missing.Load()
+}
+
+// FetchedBlocks is Fetched counted in blocks.
+func (o *Op) FetchedBlocks() (fetched, missing int64) {
+	return o.fetchedBlocks.Load(), o.missingBlocks.Load()
+}
+
+// Peers is the number of peers the operation last fetched from.
+func (o *Op) Peers() int {
+	return int(o.peers.Load())
+}
+
+// Registry holds the running operations. A nil Registry tracks nothing.
+type Registry struct {
+	mu   sync.Mutex
+	next uint64
+	ops  map[uint64]*Op
+}
+
+func NewRegistry() *Registry {
+	return &Registry{ops: make(map[uint64]*Op)}
+}
+
+// Start registers an operation running under ctx. The returned context is
+// cancelled when the operation is, and carries the operation for
+// FromContext; done must be called when it ends.
+func (r *Registry) Start(ctx context.Context, kind, target string) (context.Context, *Op, func()) {
+	if r == nil {
+		return ctx, nil, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	r.mu.Lock()
+	r.next++
+	op := &Op{ID: r.next, Kind: kind, Target: target, Started: time.Now(), cancel: cancel}
+	r.ops[op.ID] = op
+	r.mu.Unlock()
+
+	ctx = context.WithValue(ctx, opKey{}, op)
+	return ctx, op, func() {
+		r.mu.Lock()
+		delete(r.ops, op.ID)
+		r.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// List returns the running operations, oldest first.
+func (r *Registry) List() []*Op {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	ops := make([]*Op, 0, len(r.ops))
+	for _, op := range r.ops {
+		ops = append(ops, op)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i].ID < ops[j].ID })
+	return ops
+}
+
+// Cancel aborts the operation with the given ID.
+func (r *Registry) Cancel(id uint64) error {
+	if r == nil {
+		return ErrNotFound
+	}
+
+	r.mu.Lock()
+	op, ok := r.ops[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+	op.cancel(ErrCanceled)
+	return nil
+}
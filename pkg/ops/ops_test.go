@@ -0,0 +1,93 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// Operations are listed oldest first until done, and cancelling one ends
+// its context with ErrCanceled.
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	ctx := context.Background()
+
+	ctxA, a, doneA := r.Start(ctx, KindAdd, "a.txt")
+	_, b, doneB := r.Start(ctx, KindGet, "b")
+	if FromContext(ctxA) != a {
+		t.Error("FromContext doesn't return the operation")
+	}
+
+	list := r.List()
+	if len(list) != 2 || list[0] != a || list[1] != b {
+		t.Fatalf("List = %v, want [a b]", list)
+	}
+
+	if err := r.Cancel(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(context.Cause(ctxA), ErrCanceled) {
+		t.Errorf("cause = %v, want %v", context.Cause(ctxA), ErrCanceled)
+	}
+
+	doneA()
+	doneB()
+	if list := r.List(); len(list) != 0 {
+		t.Errorf("List after done = %v, want none", list)
+	}
+	if err := r.Cancel(a.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel of a finished operation = %v, want %v", err, ErrNotFound)
+	}
+}
+
+// A nil Registry and a nil Op accept every call and track nothing.
+func TestNil(t *testing.T) {
+	var r *Registry
+	ctx, op, done := r.Start(context.Background(), KindGC, "")
+	defer done()
+	if op != nil || FromContext(ctx) != nil {
+		t.Error("nil Registry started an operation")
+	}
+	op.AddBytes(1)
+	op.AddChunk()
+	op.AddMissing(1, 1)
+	op.AddFetched(1)
+	op.SetPeers(1)
+
+	if r.List() != nil {
+		t.Error("nil Registry lists operations")
+	}
+	if err := r.Cancel(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel = %v, want %v", err, ErrNotFound)
+	}
+}
+
+// Progress accumulates across calls.
+func TestProgress(t *testing.T) {
+	_, op, done := NewRegistry().Start(context.Background(), KindPin, "c")
+	defer done()
+
+	op.AddBytes(10)
+	op.AddBytes(5)
+	op.AddChunk()
+	op.AddMissing(3, 300)
+	op.AddFetched(100)
+	op.AddFetched(120)
+	op.SetPeers(2)
+
+	if got := op.Bytes(); got != 15 {
+		t.Errorf("Bytes = %d, want 15", got)
+	}
+	if got := op.Chunks(); got != 1 {
+		t.Errorf("Chunks = %d, want 1", got)
+	}
+	if fetched, missing := op.Fetched(); fetched != 220 || missing != 300 {
+		t.Errorf("Fetched = %d, %d, want 220, 300", fetched, missing)
+	}
+	if fetched, missing := op.FetchedBlocks(); fetched != 2 || missing != 3 {
+		t.Errorf("FetchedBlocks = %d, %d, want 2, 3", fetched, missing)
+	}
+	if got := op.Peers(); got != 2 {
+		t.Errorf("Peers = %d, want 2", got)
+	}
+}
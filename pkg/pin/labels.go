@@ -0,0 +1,103 @@
+package pin
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Limits on names and labels, which are kept in the pin set and shown by
+// pin ls.
+const (
+	MaxNameLen       = 256
+	MaxLabelValueLen = 128
+)
+
+// labelKey is what a label key may look like, e.g. "tier" or "team/owner".
+var labelKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+
+// ParseLabel parses a label given as key=value, or as a bare key for a
+// tag, which is a label with an empty value.
+func ParseLabel(s string) (key, value string, err error) {
+	key, value, _ = strings.Cut(s, "=")
+	if err := CheckLabel(key, value); err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// CheckLabel checks that a pin can be labelled key=value.
+func CheckLabel(key, value string) error {
+	if !labelKey.MatchString(key) {
+		return fmt.Errorf("invalid label key %q: use up to 63 letters, digits and . _ / -, starting with a letter or digit", key)
+	}
+	if len(value) > MaxLabelValueLen {
+		return fmt.Errorf("label %s: value longer than %d bytes", key, MaxLabelValueLen)
+	}
+	if strings.ContainsFunc(value, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+		return fmt.Errorf("label %s: value must not contain commas or control characters", key)
+	}
+	return nil
+}
+
+// CheckName checks that name can be given to a pin.
+func CheckName(name string) error {
+	if len(name) > MaxNameLen {
+		return fmt.Errorf("pin name longer than %d bytes", MaxNameLen)
+	}
+	if strings.ContainsFunc(name, unicode.IsControl) {
+		return fmt.Errorf("pin name must not contain control characters")
+	}
+	return nil
+}
+
+// FormatLabels writes labels as pin ls shows them, sorted by key:
+// "tier=gold,archive".
+func FormatLabels(labels map[string]string) string {
+	var b strings.Builder
+	for i, k := range slices.Sorted(maps.Keys(labels)) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		if v := labels[k]; v != "" {
+			b.WriteByte('=')
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
+
+// Selector picks pins by label: "tier=gold" those with that label,
+// "archive" those with an archive label of any value.
+type Selector struct {
+	Key   string
+	Value string
+	// Any matches every value of Key.
+	Any bool
+}
+
+// ParseSelector parses a selector written as key=value or key.
+func ParseSelector(s string) (Selector, error) {
+	key, value, hasValue := strings.Cut(s, "=")
+	if err := CheckLabel(key, value); err != nil {
+		return Selector{}, err
+	}
+	return Selector{Key: key, Value: value, Any: !hasValue}, nil
+}
+
+// Matches reports whether p has the selected label.
+func (s Selector) Matches(p Pin) bool {
+	v, ok := p.Labels[s.Key]
+	return ok && (s.Any || v == s.Value)
+}
+
+func (s Selector) String() string {
+	if s.Any {
+		return s.Key
+	}
+	return s.Key + "=" + s.Value
+}
@@ -0,0 +1,433 @@
+package pin
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var ErrNotPinned = errors.New("pin: not pinned")
+
+// Pin keeps a file root, and everything it references, from being removed.
+type Pin struct {
+	CID     cid.Cid   `json:"cid"`
+	Created time.Time `json:"created"`
+	// Replicas is the number of copies, this node's included, to keep in
+	// the cluster. Zero uses the configured default.
+	Replicas int `json:"replicas,omitempty"`
+	// Class names the configured replication class the pin is kept by
+	// when Replicas isn't set.
+	Class string `json:"class,omitempty"`
+	// KeptFor is the peer that asked this node to keep a replica, empty
+	// for local pins. Size is the file size, recorded for replicas.
+	KeptFor peer.ID `json:"kept_for,omitempty"`
+	Size    int64   `json:"size,omitempty"`
+	// Name is a free form name to tell the pin by, Labels arbitrary
+	// key/value pairs replication policies and pin ls select pins by. A
+	// label with an empty value is a tag.
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Archived is when the pin's blocks were moved to the cold store, zero
+	// for a pin in the hot store. An archived pin keeps nothing in the hot
+	// store, and isn't served or replicated until it is recalled.
+	Archived time.Time `json:"archived,omitzero"`
+}
+
+// Set is a persistent set of pins backed by a JSON file.
+type Set struct {
+	path     string
+	readOnly bool
+
+	mu   sync.RWMutex
+	pins map[cid.Cid]Pin
+
+	// pinning holds when each file still being stored or fetched to be
+	// pinned started, see Pinning. held are the session pins and when
+	// they expire, see Hold. Both are guarded by mu.
+	pinning     map[uint64]time.Time
+	nextPinning uint64
+	held        map[cid.Cid]time.Time
+}
+
+// Open loads the pin set at path. A missing file is an empty set.
+func Open(path string) (*Set, error) {
+	s := &Set{path: path, pins: make(map[cid.Cid]Pin)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pins []Pin
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, err
+	}
+	for _, p := range pins {
+		s.pins[p.CID] = p
+	}
+	return s, nil
+}
+
+// OpenReadOnly loads the pin set at path but never writes it back. Pins
+// added or removed afterwards only last for the life of the process.
+func OpenReadOnly(path string) (*Set, error) {
+	s, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s.readOnly = true
+	return s, nil
+}
+
+// Pinning is called when a file starts being stored or fetched to be
+// pinned, and returns the function to call once it is pinned or given
+// up. Nothing waits on it: a garbage collection keeps every block
+// written since the oldest file still being pinned started, see
+// PinningSince, and the root itself is recorded under the set's lock.
+func (s *Set) Pinning() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pinning == nil {
+		s.pinning = make(map[uint64]time.Time)
+	}
+	id := s.nextPinning
+	s.nextPinning++
+	s.pinning[id] = time.Now()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.pinning, id)
+	}
+}
+
+// PinningSince returns when the oldest file still being pinned started,
+// the zero time when none is.
+func (s *Set) PinningSince() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var since time.Time
+	for _, started := range s.pinning {
+		if since.IsZero() || started.Before(since) {
+			since = started
+		}
+	}
+	return since
+}
+
+// Hold keeps c, and everything it references, from being collected for
+// d without pinning it. Session pins live in memory only; they protect
+// a file added to be put in a directory until the directory is pinned,
+// see Release.
+func (s *Set) Hold(c cid.Cid, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held == nil {
+		s.held = make(map[cid.Cid]time.Time)
+	}
+	s.held[c] = time.Now().Add(d)
+}
+
+// Release drops the session pins of cids.
+func (s *Set) Release(cids ...cid.Cid) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range cids {
+		delete(s.held, c)
+	}
+}
+
+// Held returns the session pins that haven't expired, dropping the rest.
+func (s *Set) Held() []cid.Cid {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	held := make([]cid.Cid, 0, len(s.held))
+	for c, until := range s.held {
+		if now.After(until) {
+			delete(s.held, c)
+			continue
+		}
+		held = append(held, c)
+	}
+	return held
+}
+
+// AddOptions are applied to a pin as it is added, see AddWith.
+type AddOptions struct {
+	// Replicas and Class set the pin's replication as SetReplication
+	// does, when either is set.
+	Replicas int
+	Class    string
+	// Name and Labels label the pin as Label does, when either is set.
+	Name   string
+	Labels map[string]string
+}
+
+// Add pins c. Pinning an already pinned CID is a no-op.
+func (s *Set) Add(c cid.Cid) error {
+	return s.AddWith(c, AddOptions{})
+}
+
+// AddWith pins c, unless it is pinned already, and applies opts to the
+// pin, in a single write.
+func (s *Set) AddWith(c cid.Cid, opts AddOptions) error {
+	if err := checkLabels(opts.Name, opts.Labels); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, pinned := s.pins[c]
+	changed := !pinned
+	if !pinned {
+		p = Pin{CID: c, Created: time.Now().UTC()}
+	}
+	if opts.Replicas > 0 || opts.Class != "" {
+		changed = p.setReplication(opts.Replicas, opts.Class) || changed
+	}
+	if opts.Name != "" || len(opts.Labels) > 0 {
+		p.label(opts.Name, opts.Labels, nil)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return s.put(p)
+}
+
+// SetReplication changes the replication factor and class of a pinned
+// CID. A pin has at most one of them; the factor wins when both are set.
+func (s *Set) SetReplication(c cid.Cid, replicas int, class string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pins[c]
+	if !ok {
+		return ErrNotPinned
+	}
+	if !p.setReplication(replicas, class) {
+		return nil
+	}
+	return s.put(p)
+}
+
+func (p *Pin) setReplication(replicas int, class string) bool {
+	if replicas > 0 {
+		class = ""
+	}
+	if p.Replicas == replicas && p.Class == class {
+		return false
+	}
+	p.Replicas, p.Class = replicas, class
+	return true
+}
+
+// SetKeptFor records that c is kept as a replica for from.
+func (s *Set) SetKeptFor(c cid.Cid, from peer.ID, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pins[c]
+	if !ok {
+		return ErrNotPinned
+	}
+	p.KeptFor, p.Size = from, size
+	return s.put(p)
+}
+
+// SetArchived records when c was archived, or with the zero time that it
+// was recalled.
+func (s *Set) SetArchived(c cid.Cid, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pins[c]
+	if !ok {
+		return ErrNotPinned
+	}
+	if p.Archived.Equal(t) {
+		return nil
+	}
+	p.Archived = t.UTC()
+	return s.put(p)
+}
+
+// Label names a pinned CID when name isn't empty, sets the labels in set
+// and removes those keyed in remove.
+func (s *Set) Label(c cid.Cid, name string, set map[string]string, remove []string) error {
+	if err := checkLabels(name, set); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pins[c]
+	if !ok {
+		return ErrNotPinned
+	}
+	p.label(name, set, remove)
+	return s.put(p)
+}
+
+func checkLabels(name string, labels map[string]string) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	for k, v := range labels {
+		if err := CheckLabel(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// label changes p's name and labels on a copy of its labels, which the
+// set's pins share.
+func (p *Pin) label(name string, set map[string]string, remove []string) {
+	if name != "" {
+		p.Name = name
+	}
+	labels := maps.Clone(p.Labels)
+	if labels == nil {
+		labels = make(map[string]string, len(set))
+	}
+	maps.Copy(labels, set)
+	for _, k := range remove {
+		delete(labels, k)
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+	p.Labels = labels
+}
+
+// Update moves the pin of base to c, a later version of the same file or
+// tree, in a single write. The pin keeps its replication, labels and the
+// peer it is kept for, but not being archived, as c is fetched to the hot
+// store; a pin c already had is kept as it is.
+func (s *Set) Update(base, c cid.Cid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pins[base]
+	if !ok {
+		return ErrNotPinned
+	}
+	pins := maps.Clone(s.pins)
+	delete(pins, base)
+	if _, pinned := pins[c]; !pinned {
+		p.CID, p.Created, p.Archived = c, time.Now().UTC(), time.Time{}
+		pins[c] = p
+	}
+	if err := s.save(pins); err != nil {
+		return err
+	}
+	s.pins = pins
+	return nil
+}
+
+// Remove unpins c and reports whether it was pinned.
+func (s *Set) Remove(c cid.Cid) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pins[c]; !ok {
+		return false, nil
+	}
+	pins := maps.Clone(s.pins)
+	delete(pins, c)
+	if err := s.save(pins); err != nil {
+		return false, err
+	}
+	s.pins = pins
+	return true, nil
+}
+
+// put stores p in place of the pin of its CID, if any. The set only
+// changes once the change is saved. Callers must hold the write lock.
+func (s *Set) put(p Pin) error {
+	pins := maps.Clone(s.pins)
+	pins[p.CID] = p
+	if err := s.save(pins); err != nil {
+		return err
+	}
+	s.pins = pins
+	return nil
+}
+
+func (s *Set) Has(c cid.Cid) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.pins[c]
+	return ok
+}
+
+func (s *Set) Get(c cid.Cid) (Pin, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.pins[c]
+	return p, ok
+}
+
+// List returns all pins ordered by CID.
+func (s *Set) List() []Pin {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return sorted(s.pins)
+}
+
+func (s *Set) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.pins)
+}
+
+func sorted(set map[cid.Cid]Pin) []Pin {
+	pins := make([]Pin, 0, len(set))
+	for _, p := range set {
+		pins = append(pins, p)
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		return pins[i].CID.KeyString() < pins[j].CID.KeyString()
+	})
+	return pins
+}
+
+// save writes pins as the set atomically. Callers must hold the write
+// lock.
+func (s *Set) save(pins map[cid.Cid]Pin) error {
+	if s.readOnly {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(sorted(pins), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	return fsutil.AtomicWrite(s.path, data, 0644)
+}
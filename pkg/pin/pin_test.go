@@ -0,0 +1,189 @@
+package pin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func testCID(t *testing.T, s string) cid.Cid {
+	t.Helper()
+	h, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+// breakSaves makes every later save of the set at dir/pins/pins.json fail
+// by putting a file where its directory was.
+func breakSaves(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.RemoveAll(filepath.Join(dir, "pins")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pins"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailedSaveChangesNothing(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(filepath.Join(dir, "pins", "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, other := testCID(t, "kept"), testCID(t, "other")
+	if err := s.AddWith(kept, AddOptions{Replicas: 2, Name: "kept", Labels: map[string]string{"env": "prod"}}); err != nil {
+		t.Fatal(err)
+	}
+	before := s.List()
+
+	breakSaves(t, dir)
+	if err := s.Add(other); err == nil {
+		t.Error("Add saved")
+	}
+	if err := s.AddWith(other, AddOptions{Replicas: 3}); err == nil {
+		t.Error("AddWith saved")
+	}
+	if err := s.SetReplication(kept, 5, ""); err == nil {
+		t.Error("SetReplication saved")
+	}
+	if err := s.SetKeptFor(kept, "peer", 10); err == nil {
+		t.Error("SetKeptFor saved")
+	}
+	if err := s.Label(kept, "renamed", map[string]string{"env": "dev"}, nil); err == nil {
+		t.Error("Label saved")
+	}
+	if _, err := s.Remove(kept); err == nil {
+		t.Error("Remove saved")
+	}
+
+	if after := s.List(); !reflect.DeepEqual(after, before) {
+		t.Errorf("pins after failed saves = %+v, want %+v", after, before)
+	}
+}
+
+func TestAddWith(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pins.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testCID(t, "file")
+
+	if err := s.AddWith(c, AddOptions{Labels: map[string]string{"bad key": ""}}); err == nil {
+		t.Fatal("AddWith accepted an invalid label")
+	}
+	if s.Has(c) {
+		t.Fatal("pinned despite an invalid label")
+	}
+
+	opts := AddOptions{Class: "gold", Name: "report", Labels: map[string]string{"team": "a"}}
+	if err := s.AddWith(c, opts); err != nil {
+		t.Fatal(err)
+	}
+	// Pinning again with options changes the pin, keeping the rest
+	if err := s.AddWith(c, AddOptions{Replicas: 3, Labels: map[string]string{"tag": ""}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := reopened.Get(c)
+	if !ok {
+		t.Fatal("pin not saved")
+	}
+	want := map[string]string{"team": "a", "tag": ""}
+	if p.Replicas != 3 || p.Class != "" || p.Name != "report" || !reflect.DeepEqual(p.Labels, want) {
+		t.Errorf("saved pin = %+v", p)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, c := testCID(t, "v1"), testCID(t, "v2")
+	if err := s.Update(base, c); err != ErrNotPinned {
+		t.Fatalf("Update of an unpinned CID = %v, want %v", err, ErrNotPinned)
+	}
+
+	opts := AddOptions{Replicas: 3, Name: "report", Labels: map[string]string{"team": "ops"}}
+	if err := s.AddWith(base, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKeptFor(base, "peer", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(base, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Has(base) {
+		t.Error("old version still pinned")
+	}
+	got, ok := s.Get(c)
+	if !ok {
+		t.Fatal("new version not pinned")
+	}
+	if got.Replicas != 3 || got.Name != "report" || !reflect.DeepEqual(got.Labels, opts.Labels) || got.KeptFor != "peer" {
+		t.Errorf("updated pin = %+v, want the old one's settings", got)
+	}
+}
+
+// Session pins last until released or expired, and never reach the file.
+func TestHold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pins.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, expired := testCID(t, "held"), testCID(t, "expired")
+	s.Hold(held, time.Hour)
+	s.Hold(expired, -time.Second)
+
+	if got := s.Held(); !reflect.DeepEqual(got, []cid.Cid{held}) {
+		t.Errorf("Held = %v, want %v", got, []cid.Cid{held})
+	}
+	if s.Has(held) || s.Len() != 0 {
+		t.Error("a session pin counts as a pin")
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("session pins were saved")
+	}
+	s.Release(held)
+	if got := s.Held(); len(got) != 0 {
+		t.Errorf("Held = %v after Release", got)
+	}
+}
+
+func TestPinningSince(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since := s.PinningSince(); !since.IsZero() {
+		t.Errorf("PinningSince = %v with nothing being pinned", since)
+	}
+
+	before := time.Now()
+	first := s.Pinning()
+	second := s.Pinning()
+	if since := s.PinningSince(); since.Before(before) || since.After(time.Now()) {
+		t.Errorf("PinningSince = %v, want the start of the first", since)
+	}
+	first()
+	second()
+	if since := s.PinningSince(); !since.IsZero() {
+		t.Errorf("PinningSince = %v after both finished", since)
+	}
+}
@@ -0,0 +1,64 @@
+package pressure
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+)
+
+// Disk wraps a block store to count the operations in flight, the depth
+// of the node's disk queue.
+type Disk struct {
+	storage.Blockstore
+	inFlight atomic.Int64
+}
+
+func NewDisk(bs storage.Blockstore) *Disk {
+	return &Disk{Blockstore: bs}
+}
+
+// InFlight is the number of operations running.
+func (d *Disk) InFlight() int64 {
+	return d.inFlight.Load()
+}
+
+func (d *Disk) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	return d.Blockstore.Put(ctx, c, data)
+}
+
+func (d *Disk) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	return d.Blockstore.Get(ctx, c)
+}
+
+func (d *Disk) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	return d.Blockstore.Has(ctx, c)
+}
+
+// Touch refreshes the write time of a stored block, see
+// storage.FSBlockstore.Touch. A store that can't returns
+// storage.ErrReadOnly.
+func (d *Disk) Touch(ctx context.Context, c cid.Cid) error {
+	t, ok := d.Blockstore.(interface {
+		Touch(ctx context.Context, c cid.Cid) error
+	})
+	if !ok {
+		return storage.ErrReadOnly
+	}
+	d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	return t.Touch(ctx, c)
+}
+
+func (d *Disk) Delete(ctx context.Context, c cid.Cid) error {
+	d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	return d.Blockstore.Delete(ctx, c)
+}
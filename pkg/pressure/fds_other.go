@@ -0,0 +1,15 @@
+//go:build !unix
+
+package pressure
+
+func fdLimit() int {
+	return 0
+}
+
+func openFDs() (int, bool) {
+	return 0, false
+}
+
+func cgroupMemoryLimit() int64 {
+	return 0
+}
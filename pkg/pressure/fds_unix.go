@@ -0,0 +1,44 @@
+//go:build unix
+
+package pressure
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// fdLimit is the soft limit on open files, or zero if unknown.
+func fdLimit() int {
+	var rl unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rl); err != nil || rl.Cur == unix.RLIM_INFINITY {
+		return 0
+	}
+	return int(min(rl.Cur, 1<<31-1))
+}
+
+// openFDs counts the open files, where /proc or /dev/fd lists them.
+func openFDs() (int, bool) {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		if entries, err := os.ReadDir(dir); err == nil {
+			return len(entries), true
+		}
+	}
+	return 0, false
+}
+
+// cgroupMemoryLimit is the cgroup v2 memory limit, or zero without one.
+func cgroupMemoryLimit() int64 {
+	data, err := os.ReadFile("/sys/fs/cgroup/memory.max")
+	if err != nil {
+		return 0
+	}
+	limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		// "max" when there is no limit
+		return 0
+	}
+	return limit
+}
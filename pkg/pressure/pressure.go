@@ -0,0 +1,246 @@
+// Package pressure watches the daemon's memory, open files and disk queue,
+// and scales down how much work it takes on as they near their limits, so
+// a busy node slows down instead of running out.
+package pressure
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	dfsmetrics "github.com/Noah-Wilderom/dfs/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultInterval is how often resources are sampled.
+	DefaultInterval = 2 * time.Second
+	// DefaultDiskQueue is the number of block store operations in flight
+	// treated as the disk's limit.
+	DefaultDiskQueue = 64
+
+	// Throttling starts once a resource is at highWater of its limit, and
+	// deepens until the node runs at minCapacity at the limit.
+	highWater   = 0.75
+	minCapacity = 0.125
+)
+
+// Resources watched.
+const (
+	Heap      = "heap"
+	FDs       = "fds"
+	DiskQueue = "disk_queue"
+)
+
+type MonitorOpts struct {
+	// HeapLimit is the memory held by the Go runtime, heap included, in
+	// bytes, that the node must stay under. Zero uses the Go memory limit
+	// (GOMEMLIMIT) or else the cgroup's, and doesn't watch memory without
+	// either.
+	HeapLimit int64
+	// FDLimit is the number of open files allowed. Zero uses the process
+	// limit.
+	FDLimit int
+	// DiskQueueLimit is the number of block store operations in flight
+	// allowed. Zero uses DefaultDiskQueue.
+	DiskQueueLimit int
+	// Disk counts the block store operations in flight. Optional.
+	Disk *Disk
+	// Interval between samples. Zero uses DefaultInterval.
+	Interval time.Duration
+	Events   *eventlog.Recorder
+	Logger   *zap.Logger
+}
+
+// Monitor samples resource use and turns it into a capacity: the share of
+// its usual concurrency the node should use. A nil Monitor always allows
+// full capacity.
+type Monitor struct {
+	mu       sync.Mutex
+	capacity float64
+	// changed is closed and replaced whenever the capacity changes.
+	changed chan struct{}
+
+	MonitorOpts
+}
+
+// Usage is one resource's use against its limit.
+type Usage struct {
+	Resource string
+	Value    int64
+	Limit    int64
+}
+
+func (u Usage) ratio() float64 {
+	return float64(u.Value) / float64(u.Limit)
+}
+
+func NewMonitor(opts MonitorOpts) *Monitor {
+	if opts.HeapLimit == 0 {
+		opts.HeapLimit = memoryLimit()
+	}
+	if opts.FDLimit == 0 {
+		opts.FDLimit = fdLimit()
+	}
+	if opts.DiskQueueLimit == 0 {
+		opts.DiskQueueLimit = DefaultDiskQueue
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultInterval
+	}
+	return &Monitor{capacity: 1, changed: make(chan struct{}), MonitorOpts: opts}
+}
+
+// Start samples every Interval until ctx ends.
+func (m *Monitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+		for {
+			m.update(m.sample())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Capacity is the share of its usual concurrency the node should use,
+// from 1 when resources are plentiful down to 1/8 at their limits.
+func (m *Monitor) Capacity() float64 {
+	if m == nil {
+		return 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.capacity
+}
+
+// Limit scales the concurrency n by the capacity, keeping at least 1.
+func (m *Monitor) Limit(n int) int {
+	return max(1, int(math.Ceil(float64(n)*m.Capacity())))
+}
+
+// Changed is closed once the capacity changes. It is nil for a nil
+// Monitor.
+func (m *Monitor) Changed() <-chan struct{} {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.changed
+}
+
+// sample measures the resources that have a limit.
+func (m *Monitor) sample() []Usage {
+	var usage []Usage
+	if m.HeapLimit > 0 {
+		usage = append(usage, Usage{Heap, heapInUse(), m.HeapLimit})
+	}
+	if m.FDLimit > 0 {
+		if n, ok := openFDs(); ok {
+			usage = append(usage, Usage{FDs, int64(n), int64(m.FDLimit)})
+		}
+	}
+	if m.Disk != nil {
+		usage = append(usage, Usage{DiskQueue, m.Disk.InFlight(), int64(m.DiskQueueLimit)})
+	}
+	return usage
+}
+
+// update sets the capacity from the resource under the most pressure,
+// reporting when throttling engages and lets up.
+func (m *Monitor) update(usage []Usage) {
+	capacity, worst := 1.0, Usage{}
+	for _, u := range usage {
+		dfsmetrics.PressureUsage.WithLabelValues(u.Resource).Set(u.ratio())
+		if c := capacityAt(u.ratio()); c < capacity {
+			capacity, worst = c, u
+		}
+	}
+	// Coarse steps keep small fluctuations from resizing everything
+	capacity = math.Ceil(capacity*8) / 8
+	dfsmetrics.PressureCapacity.Set(capacity)
+
+	m.mu.Lock()
+	prev := m.capacity
+	if capacity == prev {
+		m.mu.Unlock()
+		return
+	}
+	m.capacity = capacity
+	close(m.changed)
+	m.changed = make(chan struct{})
+	m.mu.Unlock()
+
+	switch {
+	case prev == 1:
+		m.Logger.Warn("Resources under pressure, throttling",
+			zap.String("resource", worst.Resource),
+			zap.Int64("value", worst.Value),
+			zap.Int64("limit", worst.Limit),
+			zap.Float64("capacity", capacity),
+		)
+		m.record(eventlog.PressureThrottled, worst, capacity)
+	case capacity == 1:
+		m.Logger.Info("Resource pressure eased, no longer throttling")
+		m.record(eventlog.PressureRelieved, worst, capacity)
+	default:
+		m.Logger.Debug("Throttling adjusted",
+			zap.String("resource", worst.Resource),
+			zap.Float64("capacity", capacity),
+		)
+	}
+}
+
+func (m *Monitor) record(typ string, u Usage, capacity float64) {
+	fields := map[string]string{"capacity": fmt.Sprint(capacity)}
+	if u.Resource != "" {
+		fields["resource"] = u.Resource
+		fields["value"] = fmt.Sprint(u.Value)
+		fields["limit"] = fmt.Sprint(u.Limit)
+	}
+	m.Events.Record(eventlog.Event{Type: typ, Fields: fields})
+}
+
+// capacityAt is the capacity for a resource at ratio of its limit.
+func capacityAt(ratio float64) float64 {
+	if ratio <= highWater {
+		return 1
+	}
+	if ratio >= 1 {
+		return minCapacity
+	}
+	return 1 - (ratio-highWater)/(1-highWater)*(1-minCapacity)
+}
+
+var memorySamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+// heapInUse is the memory held by the Go runtime, as counted against the
+// Go memory limit.
+func heapInUse() int64 {
+	samples := make([]metrics.Sample, len(memorySamples))
+	copy(samples, memorySamples)
+	metrics.Read(samples)
+	return int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+// memoryLimit is GOMEMLIMIT, or else the cgroup's memory limit, or zero
+// without either.
+func memoryLimit() int64 {
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		return limit
+	}
+	return cgroupMemoryLimit()
+}
@@ -0,0 +1,117 @@
+package pressure
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+// Capacity stays full up to the high water mark and falls linearly to
+// its minimum at the limit.
+func TestCapacityAt(t *testing.T) {
+	tests := []struct {
+		ratio, want float64
+	}{
+		{0, 1},
+		{highWater, 1},
+		{(highWater + 1) / 2, (1 + minCapacity) / 2},
+		{1, minCapacity},
+		{2, minCapacity},
+	}
+	for _, tt := range tests {
+		if got := capacityAt(tt.ratio); got != tt.want {
+			t.Errorf("capacityAt(%v) = %v, want %v", tt.ratio, got, tt.want)
+		}
+	}
+}
+
+// The most pressed resource sets the capacity in steps of an eighth;
+// changes wake Changed and are recorded when throttling starts and ends.
+func TestUpdate(t *testing.T) {
+	var events bytes.Buffer
+	m := NewMonitor(MonitorOpts{Events: eventlog.NewRecorder(&events), Logger: zap.NewNop()})
+
+	changed := m.Changed()
+	m.update([]Usage{{Heap, 10, 100}, {FDs, 90, 100}})
+	select {
+	case <-changed:
+	default:
+		t.Error("Changed not closed when throttling")
+	}
+	// 0.9 of the limit leaves 0.475, rounded up to 0.5.
+	if got := m.Capacity(); got != 0.5 {
+		t.Errorf("Capacity = %v, want 0.5", got)
+	}
+	if got := m.Limit(16); got != 8 {
+		t.Errorf("Limit(16) = %d, want 8", got)
+	}
+	if got := m.Limit(1); got != 1 {
+		t.Errorf("Limit(1) = %d, want 1", got)
+	}
+
+	changed = m.Changed()
+	m.update([]Usage{{FDs, 91, 100}})
+	select {
+	case <-changed:
+		t.Error("Changed closed without a change of capacity")
+	default:
+	}
+
+	m.update([]Usage{{FDs, 10, 100}})
+	if got := m.Capacity(); got != 1 {
+		t.Errorf("Capacity after pressure eased = %v, want 1", got)
+	}
+
+	var types []string
+	if err := eventlog.Read(&events, func(e eventlog.Event) error {
+		types = append(types, e.Type)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(types, ","), eventlog.PressureThrottled+","+eventlog.PressureRelieved; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+// A nil Monitor never throttles.
+func TestNilMonitor(t *testing.T) {
+	var m *Monitor
+	if m.Capacity() != 1 || m.Limit(4) != 4 || m.Changed() != nil {
+		t.Error("nil Monitor throttles")
+	}
+}
+
+type watchedStore struct {
+	storage.Blockstore
+	disk     *Disk
+	inFlight int64
+}
+
+func (s *watchedStore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	s.inFlight = s.disk.InFlight()
+	return nil, nil
+}
+
+// Disk counts an operation as in flight only while it runs.
+func TestDisk(t *testing.T) {
+	store := &watchedStore{}
+	d := NewDisk(store)
+	store.disk = d
+
+	if _, err := d.Get(context.Background(), cid.Cid{}); err != nil {
+		t.Fatal(err)
+	}
+	if store.inFlight != 1 {
+		t.Errorf("in flight during Get = %d, want 1", store.inFlight)
+	}
+	if got := d.InFlight(); got != 0 {
+		t.Errorf("in flight after Get = %d, want 0", got)
+	}
+}
@@ -0,0 +1,195 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	// ErrNoItem is returned for an inbox item that doesn't exist.
+	ErrNoItem = errors.New("push: no such inbox item")
+	// ErrReceiving is returned when accepting an item still being fetched.
+	ErrReceiving = errors.New("push: still being received")
+)
+
+// Item is a push waiting in the inbox to be accepted or rejected.
+type Item struct {
+	ID   uint64  `json:"id"`
+	CID  cid.Cid `json:"cid"`
+	Name string  `json:"name,omitempty"`
+	Size int64   `json:"size"`
+	From peer.ID `json:"from"`
+	// Offered is when the push came in, Received when all of it was
+	// stored. Until then it is being fetched.
+	Offered  time.Time `json:"offered"`
+	Received time.Time `json:"received,omitzero"`
+}
+
+// Inbox holds what peers pushed until the user accepts it, which pins
+// it, or rejects it, which leaves it to garbage collection. Its roots are
+// kept from collection meanwhile without being pins, so nothing pushed
+// counts as content this node keeps, serves as pinned or replicates.
+type Inbox struct {
+	path string
+
+	mu    sync.Mutex
+	items map[uint64]*Item
+	next  uint64
+}
+
+type inboxFile struct {
+	Next  uint64  `json:"next"`
+	Items []*Item `json:"items"`
+}
+
+// OpenInbox loads the inbox at path, a JSON file like the pin set. A
+// missing file is an empty inbox; an empty path keeps it in memory only.
+// Items still being received when it was last saved were cut off, and are
+// dropped.
+func OpenInbox(path string) (*Inbox, error) {
+	b := &Inbox{path: path, items: make(map[uint64]*Item), next: 1}
+	if path == "" {
+		return b, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file inboxFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("inbox %s: %w", path, err)
+	}
+	b.next = max(file.Next, 1)
+	for _, item := range file.Items {
+		if !item.Received.IsZero() {
+			b.items[item.ID] = item
+		}
+	}
+	return b, nil
+}
+
+// List returns the items, oldest first.
+func (b *Inbox) List() []Item {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := make([]Item, 0, len(b.items))
+	for _, item := range b.items {
+		list = append(list, *item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Get returns the item id.
+func (b *Inbox) Get(id uint64) (Item, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	item, ok := b.items[id]
+	if !ok {
+		return Item{}, false
+	}
+	return *item, true
+}
+
+// Roots returns the roots of the items, for garbage collection to keep
+// them.
+func (b *Inbox) Roots() []cid.Cid {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	roots := make([]cid.Cid, 0, len(b.items))
+	for _, item := range b.items {
+		roots = append(roots, item.CID)
+	}
+	return roots
+}
+
+// add records item as being received and returns its ID. A positive
+// capacity refuses it if the items would add up to more bytes.
+func (b *Inbox) add(item Item, capacity int64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if capacity > 0 {
+		var held int64
+		for _, i := range b.items {
+			held += i.Size
+		}
+		if held+item.Size > capacity {
+			return 0, &RefusedError{
+				Rule:   "capacity",
+				Reason: fmt.Sprintf("%d bytes waiting, %d more exceeds %d", held, item.Size, capacity),
+			}
+		}
+	}
+
+	item.ID = b.next
+	b.next++
+	b.items[item.ID] = &item
+	return item.ID, b.save()
+}
+
+// received marks the item id as all stored.
+func (b *Inbox) received(id uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	item, ok := b.items[id]
+	if !ok {
+		return ErrNoItem
+	}
+	item.Received = time.Now()
+	return b.save()
+}
+
+// remove takes the item id out of the inbox.
+func (b *Inbox) remove(id uint64) (Item, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	item, ok := b.items[id]
+	if !ok {
+		return Item{}, ErrNoItem
+	}
+	delete(b.items, id)
+	return *item, b.save()
+}
+
+// save writes the inbox to disk. Callers hold b.mu.
+func (b *Inbox) save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	file := inboxFile{Next: b.next, Items: make([]*Item, 0, len(b.items))}
+	for _, item := range b.items {
+		file.Items = append(file.Items, item)
+	}
+	sort.Slice(file.Items, func(i, j int) bool { return file.Items[i].ID < file.Items[j].ID })
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(b.path, data, 0644)
+}
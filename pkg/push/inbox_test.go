@@ -0,0 +1,69 @@
+package push
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// What was received is kept across opening the inbox again; what was cut
+// off while being received is dropped, and IDs aren't reused.
+func TestInboxReopen(t *testing.T) {
+	from, err := peer.Decode("12D3KooWAyiojnNTYXKDWqHRKTZ9HuQcvyiuYd7NS11d3P6enVnm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "inbox.json")
+	b, err := OpenInbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := b.add(Item{CID: testCID(t, "done"), Name: "done.txt", Size: 10, From: from, Offered: time.Now()}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.received(done); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.add(Item{CID: testCID(t, "cut off"), Size: 10, From: from, Offered: time.Now()}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = OpenInbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := b.List()
+	if len(items) != 1 || items[0].ID != done || items[0].Name != "done.txt" {
+		t.Fatalf("reopened inbox holds %+v", items)
+	}
+	id, err := b.add(Item{CID: testCID(t, "next"), Size: 10, From: from, Offered: time.Now()}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= done+1 {
+		t.Errorf("new item got ID %d, reusing one", id)
+	}
+
+	if _, err := b.remove(done); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.remove(done); !errors.Is(err, ErrNoItem) {
+		t.Errorf("removing twice: %v", err)
+	}
+}
+
+func testCID(t *testing.T, data string) cid.Cid {
+	t.Helper()
+	h, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
@@ -0,0 +1,233 @@
+// Package push takes the files and directory trees peers push to this
+// node over network.PushProtocol, as "dfs push" sends them.
+//
+// A push names only the root. The receiver applies its policy to the name
+// and size the root holds, fetched from the pusher, and then fetches the
+// whole tree from the pusher before anyone else, so nothing is looked up
+// in the DHT when the pusher has it all. What it fetched waits in the
+// inbox, kept but not pinned, until the user accepts or rejects it.
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// Policy holds the rules a push must pass to be taken. Rules left unset
+// don't apply.
+type Policy struct {
+	// MaxSize refuses files and trees larger than it, in bytes.
+	MaxSize int64
+	// Peers only takes pushes from these peers.
+	Peers []peer.ID
+	// Capacity caps the total size of what waits in the inbox, in bytes.
+	Capacity int64
+}
+
+// RefusedError names the policy rule a push failed.
+type RefusedError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *RefusedError) Error() string {
+	return fmt.Sprintf("push refused by %s rule: %s", e.Rule, e.Reason)
+}
+
+func (e *RefusedError) Unwrap() error {
+	return network.ErrPushRefused
+}
+
+// Admit returns a *RefusedError when the policy doesn't allow from to
+// push size bytes.
+func (p Policy) Admit(from peer.ID, size int64) error {
+	if len(p.Peers) > 0 && !slices.Contains(p.Peers, from) {
+		return &RefusedError{Rule: "peers", Reason: "peer is not allowed"}
+	}
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return &RefusedError{Rule: "max_size", Reason: fmt.Sprintf("%d bytes exceeds %d", size, p.MaxSize)}
+	}
+	return nil
+}
+
+// errRejected tells the pusher the user rejected its push before it was
+// all received.
+var errRejected = fmt.Errorf("%w: rejected", network.ErrPushRefused)
+
+type ReceiverOpts struct {
+	Network *network.P2PNetworking
+	// Inbox holds what was pushed until it is accepted or rejected.
+	Inbox *Inbox
+	// Stat returns the name and size of the pushed file or directory,
+	// fetching its root from from, so Policy is applied before anything
+	// else is fetched.
+	Stat func(ctx context.Context, c cid.Cid, from peer.ID) (name string, size int64, err error)
+	// Fetch stores what from pushed without pinning it, fetching it from
+	// from first.
+	Fetch func(ctx context.Context, c cid.Cid, from peer.ID) error
+	// Keep pins an accepted item.
+	Keep func(ctx context.Context, c cid.Cid, name string) error
+	// Policy limits which pushes are taken.
+	Policy Policy
+	// Ops tracks pushes being fetched so they can be cancelled. Optional.
+	Ops *ops.Registry
+	// Events records pushes coming in and what became of them, for the
+	// user to hear of them. Optional.
+	Events *eventlog.Recorder
+	Logger *zap.Logger
+}
+
+// Receiver takes pushes into the inbox, and accepts or rejects them.
+type Receiver struct {
+	logger *zap.Logger
+
+	mu sync.Mutex
+	// receiving cancels the fetches of items being received.
+	receiving map[uint64]context.CancelFunc
+
+	ReceiverOpts
+}
+
+func NewReceiver(opts ReceiverOpts) *Receiver {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	return &Receiver{
+		logger:       opts.Logger,
+		receiving:    make(map[uint64]context.CancelFunc),
+		ReceiverOpts: opts,
+	}
+}
+
+// Start has peers push to this node. Without it the inbox only holds
+// what was pushed before.
+func (r *Receiver) Start() {
+	r.Network.HandlePush(r.handle)
+}
+
+func (r *Receiver) handle(ctx context.Context, from peer.ID, c cid.Cid) error {
+	// Only the peers allowed get anything fetched from them
+	if err := r.Policy.Admit(from, 0); err != nil {
+		r.refused(from, c, err)
+		return err
+	}
+
+	ctx, _, done := r.Ops.Start(ctx, ops.KindReceive, c.String())
+	defer done()
+
+	name, size, err := r.Stat(ctx, c, from)
+	if err != nil {
+		return err
+	}
+	if err := r.Policy.Admit(from, size); err != nil {
+		r.refused(from, c, err)
+		return err
+	}
+	id, err := r.Inbox.add(Item{CID: c, Name: name, Size: size, From: from, Offered: time.Now()}, r.Policy.Capacity)
+	if err != nil {
+		if errors.Is(err, network.ErrPushRefused) {
+			r.refused(from, c, err)
+		}
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	r.receiving[id] = cancel
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.receiving, id)
+		r.mu.Unlock()
+		cancel()
+	}()
+
+	if err := r.Fetch(ctx, c, from); err != nil {
+		if _, ok := r.Inbox.Get(id); !ok {
+			// Rejecting it cancelled the fetch
+			return errRejected
+		}
+		if _, err := r.Inbox.remove(id); err != nil && !errors.Is(err, ErrNoItem) {
+			r.logger.Warn("Failed to remove push from inbox", zap.Uint64("item", id), zap.Error(err))
+		}
+		return err
+	}
+	if err := r.Inbox.received(id); errors.Is(err, ErrNoItem) {
+		return errRejected
+	} else if err != nil {
+		return err
+	}
+
+	r.record(eventlog.PushReceived, from, map[string]string{
+		"id":   strconv.FormatUint(id, 10),
+		"cid":  c.String(),
+		"name": name,
+		"size": strconv.FormatInt(size, 10),
+	})
+	r.logger.Info("Push waiting in inbox",
+		zap.Uint64("item", id),
+		zap.String("cid", c.String()),
+		zap.String("peer", from.String()),
+		zap.Int64("size", size),
+	)
+	return nil
+}
+
+// Accept pins the item id and takes it out of the inbox.
+func (r *Receiver) Accept(ctx context.Context, id uint64) (Item, error) {
+	item, ok := r.Inbox.Get(id)
+	if !ok {
+		return Item{}, ErrNoItem
+	}
+	if item.Received.IsZero() {
+		return Item{}, fmt.Errorf("item %d: %w", id, ErrReceiving)
+	}
+	if err := r.Keep(ctx, item.CID, item.Name); err != nil {
+		return Item{}, err
+	}
+	if _, err := r.Inbox.remove(id); err != nil && !errors.Is(err, ErrNoItem) {
+		return Item{}, err
+	}
+
+	r.record(eventlog.PushAccepted, item.From, map[string]string{"id": strconv.FormatUint(id, 10), "cid": item.CID.String()})
+	r.logger.Info("Accepted push", zap.Uint64("item", id), zap.String("cid", item.CID.String()))
+	return item, nil
+}
+
+// Reject takes the item id out of the inbox, cancelling its fetch if it
+// is still being received. Its blocks are left to garbage collection.
+func (r *Receiver) Reject(id uint64) (Item, error) {
+	item, err := r.Inbox.remove(id)
+	if err != nil {
+		return Item{}, err
+	}
+	r.mu.Lock()
+	if cancel, ok := r.receiving[id]; ok {
+		cancel()
+	}
+	r.mu.Unlock()
+
+	r.record(eventlog.PushRejected, item.From, map[string]string{"id": strconv.FormatUint(id, 10), "cid": item.CID.String()})
+	r.logger.Info("Rejected push", zap.Uint64("item", id), zap.String("cid", item.CID.String()))
+	return item, nil
+}
+
+func (r *Receiver) refused(from peer.ID, c cid.Cid, err error) {
+	r.record(eventlog.PushRefused, from, map[string]string{"cid": c.String(), "reason": err.Error()})
+}
+
+func (r *Receiver) record(typ string, p peer.ID, fields map[string]string) {
+	r.Events.Record(eventlog.Event{Type: typ, Peer: p.String(), Fields: fields})
+}
@@ -0,0 +1,47 @@
+package push
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Each rule that is set refuses pushes outside it, naming itself.
+func TestPolicyAdmit(t *testing.T) {
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+	policy := Policy{MaxSize: 100, Peers: []peer.ID{alice}}
+	tests := []struct {
+		name string
+		from peer.ID
+		size int64
+		rule string
+	}{
+		{"accepted", alice, 100, ""},
+		{"other peer", bob, 1, "peers"},
+		{"too large", alice, 101, "max_size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Admit(tt.from, tt.size)
+			if tt.rule == "" {
+				if err != nil {
+					t.Errorf("Admit = %v, want accepted", err)
+				}
+				return
+			}
+			var refused *RefusedError
+			if !errors.As(err, &refused) || refused.Rule != tt.rule {
+				t.Fatalf("Admit = %v, want refused by %s", err, tt.rule)
+			}
+			if !errors.Is(err, network.ErrPushRefused) {
+				t.Errorf("Admit = %v, want it to wrap %v", err, network.ErrPushRefused)
+			}
+		})
+	}
+
+	if err := (Policy{}).Admit(bob, 1<<40); err != nil {
+		t.Errorf("zero Policy refused: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package replication
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Class is a named replication policy, such as gold for three copies in
+// two zones, pins are assigned directly or through rules on their labels.
+type Class struct {
+	// Replicas is the number of copies, this node's included. Zero asks
+	// no peer for a copy and never counts the pin as under-replicated,
+	// for content merely cached here.
+	Replicas int
+	// Zones is the number of zones the copies must span, this node's
+	// included. Peers announce their zone; those that announce none are
+	// in none. When there are copies enough but in too few zones, one more
+	// is made in another zone.
+	Zones int
+}
+
+// Rule assigns the pins Selector picks a class, or when Class is empty a
+// replication factor.
+type Rule struct {
+	Selector pin.Selector
+	Class    string
+	Replicas int
+}
+
+// Requirement is what replication keeps of a pin.
+type Requirement struct {
+	// Class is the class the requirement comes from, empty for a factor
+	// set on the pin, by a rule or by default.
+	Class    string
+	Replicas int
+	Zones    int
+}
+
+// met reports whether copies spanning zones satisfy r.
+func (r Requirement) met(copies, zones int) bool {
+	return copies >= r.Replicas && zones >= r.Zones
+}
+
+// Status is how well a pin's copies meet its requirement.
+type Status struct {
+	Requirement
+	// Copies is the number of copies known to be available, Spanned the
+	// number of zones they are in.
+	Copies  int
+	Spanned int
+}
+
+// Compliant reports whether the pin has the copies and zones it needs.
+func (s Status) Compliant() bool {
+	return s.met(s.Copies, s.Spanned)
+}
+
+// Compliance counts the pins of one class, or of none, that meet their
+// requirement.
+type Compliance struct {
+	Class string
+	Pins  int
+	// Compliant pins have all they need; UnderReplicated ones lack
+	// copies, and ZonesShort ones have copies enough in too few zones.
+	Compliant       int
+	UnderReplicated int
+	ZonesShort      int
+}
+
+// Requirement resolves what applies to p: the factor set on the pin, else
+// its class, else the most demanding rule matching its labels, else the
+// default factor.
+func (m *Manager) Requirement(p pin.Pin) Requirement {
+	if p.Replicas > 0 {
+		return Requirement{Replicas: p.Replicas}
+	}
+	if c, ok := m.Classes[p.Class]; ok {
+		return Requirement{Class: p.Class, Replicas: c.Replicas, Zones: c.Zones}
+	}
+
+	var (
+		best  Requirement
+		found bool
+	)
+	for _, r := range m.Rules {
+		if !r.Selector.Matches(p) {
+			continue
+		}
+		req := Requirement{Replicas: r.Replicas}
+		if c, ok := m.Classes[r.Class]; ok {
+			req = Requirement{Class: r.Class, Replicas: c.Replicas, Zones: c.Zones}
+		}
+		if !found || cmp.Or(cmp.Compare(req.Replicas, best.Replicas), cmp.Compare(req.Zones, best.Zones)) > 0 {
+			best, found = req, true
+		}
+	}
+	if found {
+		return best
+	}
+	return Requirement{Replicas: m.ManagerOpts.Factor}
+}
+
+// HasClass reports whether a class is configured by that name.
+func (m *Manager) HasClass(name string) bool {
+	_, ok := m.Classes[name]
+	return ok
+}
+
+// Factor is the replication factor that applies to p.
+func (m *Manager) Factor(p pin.Pin) int {
+	return m.Requirement(p).Replicas
+}
+
+// Status reports how well the copies of p meet its requirement.
+func (m *Manager) Status(p pin.Pin) Status {
+	return m.status(p, m.connected())
+}
+
+func (m *Manager) status(p pin.Pin, connected map[peer.ID]bool) Status {
+	return Status{
+		Requirement: m.Requirement(p),
+		Copies:      m.copies(p.CID, connected),
+		Spanned:     len(m.spanned(p.CID, connected)),
+	}
+}
+
+// Compliance counts the pins meeting their requirement, by class, in the
+// order of the class names, pins of no class first.
+func (m *Manager) Compliance() []Compliance {
+	connected := m.connected()
+	byClass := make(map[string]*Compliance)
+	for _, p := range m.Pins.List() {
+		s := m.status(p, connected)
+		c, ok := byClass[s.Class]
+		if !ok {
+			c = &Compliance{Class: s.Class}
+			byClass[s.Class] = c
+		}
+		c.Pins++
+		switch {
+		case s.Copies < s.Replicas:
+			c.UnderReplicated++
+		case s.Spanned < s.Zones:
+			c.ZonesShort++
+		default:
+			c.Compliant++
+		}
+	}
+
+	list := make([]Compliance, 0, len(byClass))
+	for _, c := range byClass {
+		list = append(list, *c)
+	}
+	slices.SortFunc(list, func(a, b Compliance) int { return cmp.Compare(a.Class, b.Class) })
+	return list
+}
+
+// spanned returns the zones the copies of c are in: this node's and
+// those of connected holders.
+func (m *Manager) spanned(c cid.Cid, connected map[peer.ID]bool) map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	zones := make(map[string]bool)
+	if m.Zone != "" {
+		zones[m.Zone] = true
+	}
+	for id := range m.holders[c] {
+		if z := m.zones[id]; connected[id] && z != "" {
+			zones[z] = true
+		}
+	}
+	return zones
+}
+
+func (m *Manager) zone(id peer.ID) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.zones[id]
+}
+
+// placement orders peers to ask for copies of c: one in each zone not
+// yet spanned first, then the rest. Either way peers whose inventory has
+// c come before others, as they likely hold most of it already.
+func (m *Manager) placement(c cid.Cid, peers []peer.AddrInfo, spanned map[string]bool) []peer.AddrInfo {
+	advertised := make(map[peer.ID]bool)
+	for _, pi := range m.Network.Advertised(c) {
+		advertised[pi.ID] = true
+	}
+	peers = slices.Clone(peers)
+	slices.SortStableFunc(peers, func(a, b peer.AddrInfo) int {
+		switch {
+		case advertised[a.ID] == advertised[b.ID]:
+			return 0
+		case advertised[a.ID]:
+			return -1
+		default:
+			return 1
+		}
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	first := make([]peer.AddrInfo, 0, len(peers))
+	var rest []peer.AddrInfo
+	seen := make(map[string]bool)
+	for _, pi := range peers {
+		z := m.zones[pi.ID]
+		if z != "" && !spanned[z] && !seen[z] {
+			seen[z] = true
+			first = append(first, pi)
+		} else {
+			rest = append(rest, pi)
+		}
+	}
+	return append(first, rest...)
+}
@@ -0,0 +1,73 @@
+package replication
+
+import (
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+)
+
+// A pin's own factor beats its class, its class beats rules, and the most
+// demanding matching rule beats the default.
+func TestRequirement(t *testing.T) {
+	m := NewManager(ManagerOpts{
+		Factor: 2,
+		Classes: map[string]Class{
+			"gold":   {Replicas: 3, Zones: 2},
+			"silver": {Replicas: 3},
+			"cache":  {},
+		},
+		Rules: []Rule{
+			{Selector: pin.Selector{Key: "tier", Value: "hot"}, Class: "silver"},
+			{Selector: pin.Selector{Key: "tier", Any: true}, Replicas: 4},
+			{Selector: pin.Selector{Key: "env", Value: "prod"}, Replicas: 3},
+			{Selector: pin.Selector{Key: "team", Value: "ops"}, Class: "gold"},
+		},
+	})
+	labels := func(kv ...string) map[string]string {
+		l := make(map[string]string)
+		for i := 0; i < len(kv); i += 2 {
+			l[kv[i]] = kv[i+1]
+		}
+		return l
+	}
+	tests := []struct {
+		name string
+		p    pin.Pin
+		want Requirement
+	}{
+		{"default", pin.Pin{}, Requirement{Replicas: 2}},
+		{"own factor", pin.Pin{Replicas: 5, Class: "gold"}, Requirement{Replicas: 5}},
+		{"class", pin.Pin{Class: "gold", Labels: labels("tier", "hot")}, Requirement{Class: "gold", Replicas: 3, Zones: 2}},
+		{"cache class", pin.Pin{Class: "cache"}, Requirement{Class: "cache"}},
+		{"unknown class", pin.Pin{Class: "bronze"}, Requirement{Replicas: 2}},
+		{"most replicas", pin.Pin{Labels: labels("tier", "hot")}, Requirement{Replicas: 4}},
+		{"replicas before zones", pin.Pin{Labels: labels("tier", "cold", "team", "ops")}, Requirement{Replicas: 4}},
+		{"zones break a tie", pin.Pin{Labels: labels("env", "prod", "team", "ops")}, Requirement{Class: "gold", Replicas: 3, Zones: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Requirement(tt.p); got != tt.want {
+				t.Errorf("Requirement = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusCompliant(t *testing.T) {
+	req := Requirement{Replicas: 3, Zones: 2}
+	tests := []struct {
+		copies, spanned int
+		want            bool
+	}{
+		{3, 2, true},
+		{4, 3, true},
+		{2, 2, false},
+		{3, 1, false},
+	}
+	for _, tt := range tests {
+		s := Status{Requirement: req, Copies: tt.copies, Spanned: tt.spanned}
+		if got := s.Compliant(); got != tt.want {
+			t.Errorf("%d copies in %d zones compliant = %v, want %v", tt.copies, tt.spanned, got, tt.want)
+		}
+	}
+}
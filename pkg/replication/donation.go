@@ -0,0 +1,112 @@
+package replication
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Donation is what a node offers to the cluster: how much space replicas
+// kept for other peers may take, and when replication may run. The zero
+// Donation is unlimited.
+type Donation struct {
+	// Capacity caps the total size of replicas kept for others, in bytes.
+	Capacity int64
+	// Window restricts accepting and pushing replicas to part of the day.
+	Window Window
+}
+
+// Window is a daily time range in local time. It may wrap past midnight,
+// e.g. 22:00-04:00. The zero Window is open all day.
+type Window struct {
+	// Start and End are offsets from midnight.
+	Start, End time.Duration
+}
+
+// ParseWindow parses a range like "01:00-06:00". An empty string is the
+// zero Window.
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return Window{}, nil
+	}
+
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q: want HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return Window{}, fmt.Errorf("window %q: %w", s, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return Window{}, fmt.Errorf("window %q: %w", s, err)
+	}
+	if start == end {
+		return Window{}, fmt.Errorf("window %q: start and end are equal", s)
+	}
+	return Window{Start: start, End: end}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls inside the window.
+func (w Window) Contains(t time.Time) bool {
+	if w.Start == w.End {
+		return true
+	}
+	offset := t.Sub(midnight(t))
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Next returns the first time at or after t that falls inside the window.
+func (w Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	start := midnight(t).Add(w.Start)
+	if start.Before(t) {
+		start = midnight(start.AddDate(0, 0, 1)).Add(w.Start)
+	}
+	return start
+}
+
+func (w Window) String() string {
+	if w.Start == w.End {
+		return "always"
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
+
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// admit returns a *RefusedError when keeping size more bytes at t, with
+// donated bytes already kept for others, exceeds the donation.
+func (d Donation) admit(t time.Time, donated, size int64) error {
+	if !d.Window.Contains(t) {
+		return &RefusedError{Rule: "window", Reason: "replicating only " + d.Window.String()}
+	}
+	if d.Capacity > 0 && donated+size > d.Capacity {
+		return &RefusedError{
+			Rule:   "capacity",
+			Reason: fmt.Sprintf("%d bytes donated, %d more exceeds %d", donated, size, d.Capacity),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package replication
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Window
+		wantErr bool
+	}{
+		{"", Window{}, false},
+		{"01:00-06:30", Window{Start: time.Hour, End: 6*time.Hour + 30*time.Minute}, false},
+		{"22:00 - 04:00", Window{Start: 22 * time.Hour, End: 4 * time.Hour}, false},
+		{"01:00", Window{}, true},
+		{"01:00-25:00", Window{}, true},
+		{"03:00-03:00", Window{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWindow(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseWindow(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// Windows may wrap past midnight, and Next finds the next opening.
+func TestWindow(t *testing.T) {
+	day := Window{Start: 9 * time.Hour, End: 17 * time.Hour}
+	night := Window{Start: 22 * time.Hour, End: 4 * time.Hour}
+	tests := []struct {
+		w    Window
+		t    time.Time
+		in   bool
+		next time.Time
+	}{
+		{Window{}, at(3, 0), true, at(3, 0)},
+		{day, at(9, 0), true, at(9, 0)},
+		{day, at(17, 0), false, at(9, 0).AddDate(0, 0, 1)},
+		{day, at(8, 0), false, at(9, 0)},
+		{night, at(23, 0), true, at(23, 0)},
+		{night, at(3, 59), true, at(3, 59)},
+		{night, at(12, 0), false, at(22, 0)},
+	}
+	for _, tt := range tests {
+		if got := tt.w.Contains(tt.t); got != tt.in {
+			t.Errorf("%v.Contains(%s) = %v, want %v", tt.w, tt.t.Format("15:04"), got, tt.in)
+		}
+		if got := tt.w.Next(tt.t); !got.Equal(tt.next) {
+			t.Errorf("%v.Next(%s) = %s, want %s", tt.w, tt.t.Format("15:04"), got, tt.next)
+		}
+	}
+}
+
+// Replicas are refused outside the window and past the capacity.
+func TestDonationAdmit(t *testing.T) {
+	d := Donation{Capacity: 100, Window: Window{Start: 22 * time.Hour, End: 4 * time.Hour}}
+	tests := []struct {
+		t             time.Time
+		donated, size int64
+		rule          string
+	}{
+		{at(23, 0), 50, 50, ""},
+		{at(12, 0), 0, 1, "window"},
+		{at(1, 0), 50, 51, "capacity"},
+	}
+	for _, tt := range tests {
+		err := d.admit(tt.t, tt.donated, tt.size)
+		var refused *RefusedError
+		switch {
+		case tt.rule == "" && err != nil:
+			t.Errorf("admit(%s, %d, %d) = %v, want accepted", tt.t.Format("15:04"), tt.donated, tt.size, err)
+		case tt.rule != "" && (!errors.As(err, &refused) || refused.Rule != tt.rule):
+			t.Errorf("admit(%s, %d, %d) = %v, want refused by %s", tt.t.Format("15:04"), tt.donated, tt.size, err, tt.rule)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package replication
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Policy decides which replica requests a node accepts. Every rule that is
+// set must pass; the zero Policy accepts everything.
+type Policy struct {
+	// MaxSize refuses files larger than it, in bytes.
+	MaxSize int64
+	// Peers only accepts requests from these peers.
+	Peers []peer.ID
+	// ContentTypes only accepts files whose media type, guessed from the
+	// file name, matches one of these. "image/*" matches every image type.
+	ContentTypes []string
+	// MaxBytesPerPeer caps the total size of the replicas kept for any one
+	// peer.
+	MaxBytesPerPeer int64
+}
+
+// Candidate is a replica request being considered.
+type Candidate struct {
+	From peer.ID
+	Name string
+	Size int64
+	// Held is the total size of the replicas already kept for From.
+	Held int64
+}
+
+// RefusedError names the policy rule a request failed.
+type RefusedError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *RefusedError) Error() string {
+	return fmt.Sprintf("replica refused by %s rule: %s", e.Rule, e.Reason)
+}
+
+func (e *RefusedError) Unwrap() error {
+	return network.ErrReplicaRefused
+}
+
+// Admit returns a *RefusedError when the policy doesn't allow c.
+func (p Policy) Admit(c Candidate) error {
+	if len(p.Peers) > 0 && !slices.Contains(p.Peers, c.From) {
+		return &RefusedError{Rule: "peers", Reason: "peer is not allowed"}
+	}
+	if p.MaxSize > 0 && c.Size > p.MaxSize {
+		return &RefusedError{Rule: "max_size", Reason: fmt.Sprintf("%d bytes exceeds %d", c.Size, p.MaxSize)}
+	}
+	if len(p.ContentTypes) > 0 {
+		typ := ContentType(c.Name)
+		if !slices.ContainsFunc(p.ContentTypes, func(pattern string) bool { return matchType(pattern, typ) }) {
+			return &RefusedError{Rule: "content_types", Reason: typ + " is not allowed"}
+		}
+	}
+	if p.MaxBytesPerPeer > 0 && c.Held+c.Size > p.MaxBytesPerPeer {
+		return &RefusedError{
+			Rule:   "max_bytes_per_peer",
+			Reason: fmt.Sprintf("%d bytes already kept, %d more exceeds %d", c.Held, c.Size, p.MaxBytesPerPeer),
+		}
+	}
+	return nil
+}
+
+// ContentType guesses the media type of a file from its name.
+func ContentType(name string) string {
+	typ := mime.TypeByExtension(path.Ext(name))
+	if typ == "" {
+		return "application/octet-stream"
+	}
+	typ, _, _ = strings.Cut(typ, ";")
+	return strings.TrimSpace(typ)
+}
+
+func matchType(pattern, typ string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(typ, prefix+"/")
+	}
+	return pattern == typ
+}
@@ -0,0 +1,68 @@
+package replication
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Each rule that is set refuses requests outside it, naming itself.
+func TestPolicyAdmit(t *testing.T) {
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+	policy := Policy{
+		MaxSize:         100,
+		Peers:           []peer.ID{alice},
+		ContentTypes:    []string{"image/*", "text/plain"},
+		MaxBytesPerPeer: 150,
+	}
+	tests := []struct {
+		name string
+		c    Candidate
+		rule string
+	}{
+		{"accepted image", Candidate{From: alice, Name: "a.png", Size: 100}, ""},
+		{"accepted text", Candidate{From: alice, Name: "a.txt", Size: 10, Held: 140}, ""},
+		{"other peer", Candidate{From: bob, Name: "a.png", Size: 1}, "peers"},
+		{"too large", Candidate{From: alice, Name: "a.png", Size: 101}, "max_size"},
+		{"wrong type", Candidate{From: alice, Name: "a.zip", Size: 1}, "content_types"},
+		{"unknown type", Candidate{From: alice, Name: "a", Size: 1}, "content_types"},
+		{"peer over its share", Candidate{From: alice, Name: "a.png", Size: 20, Held: 140}, "max_bytes_per_peer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Admit(tt.c)
+			if tt.rule == "" {
+				if err != nil {
+					t.Errorf("Admit = %v, want accepted", err)
+				}
+				return
+			}
+			var refused *RefusedError
+			if !errors.As(err, &refused) || refused.Rule != tt.rule {
+				t.Fatalf("Admit = %v, want refused by %s", err, tt.rule)
+			}
+			if !errors.Is(err, network.ErrReplicaRefused) {
+				t.Errorf("Admit = %v, want it to wrap %v", err, network.ErrReplicaRefused)
+			}
+		})
+	}
+
+	if err := (Policy{}).Admit(Candidate{From: bob, Name: "a", Size: 1 << 40}); err != nil {
+		t.Errorf("zero Policy refused: %v", err)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.txt":  "text/plain",
+		"a.PNG":  "image/png",
+		"a":      "application/octet-stream",
+		"a.nope": "application/octet-stream",
+	} {
+		if got := ContentType(name); got != want {
+			t.Errorf("ContentType(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,671 @@
+// Package replication keeps a configured number of copies of pinned files
+// on other peers.
+//
+// The manager remembers which peers hold a copy of each pin. Only holders
+// that are currently connected count towards the replication factor, so
+// when a peer disconnects the pins it held become under-replicated and the
+// manager asks other connected peers to store copies until the factor is
+// restored. Peers agree to store a copy over network.ReplicateProtocol and
+// fetch it like any other file.
+//
+// The manager also follows cluster events: peers announcing content this
+// node has pinned become holders, peers that start accepting replicas
+// trigger a check, and pins no connected peer would take are offered to
+// the whole cluster with a pin request.
+package replication
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/ops"
+	"github.com/Noah-Wilderom/dfs/pkg/pin"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const DefaultInterval = 5 * time.Minute
+
+// refusalBackoff is how long a peer that refused a pin isn't asked for it
+// again, so its copies are planned elsewhere.
+const refusalBackoff = 30 * time.Minute
+
+// maxGossipStores limits pin requests from the event bus handled at once;
+// more are dropped.
+const (
+	maxGossipStores    = 2
+	gossipStoreTimeout = 30 * time.Minute
+)
+
+type Manager struct {
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	holders map[cid.Cid]map[peer.ID]struct{}
+	// pending is the size of replicas being fetched per requesting peer.
+	pending map[peer.ID]int64
+	// refused records when peers declined to keep a pin.
+	refused map[cid.Cid]map[peer.ID]time.Time
+	// accepting holds peers that announced they accept replicas since
+	// they connected.
+	accepting map[peer.ID]bool
+	// zones holds the zones peers announced.
+	zones  map[peer.ID]string
+	gossip chan struct{}
+	wake   chan struct{}
+
+	ManagerOpts
+}
+
+type ManagerOpts struct {
+	Network *network.P2PNetworking
+	Pins    *pin.Set
+	// Factor is the number of copies, this node's included, kept of pins
+	// that don't set their own nor match a rule. 1 disables replication by
+	// default.
+	Factor int
+	// Classes are the replication classes pins can be assigned, by name,
+	// and Rules assign them, or a factor, to pins by label.
+	Classes map[string]Class
+	Rules   []Rule
+	// Zone is the zone this node is in, see Class.Zones.
+	Zone string
+	// Store keeps a copy requested by another peer. Nil refuses requests.
+	Store func(ctx context.Context, c cid.Cid) error
+	// Update keeps c in place of base, an earlier version of it kept for
+	// from, fetching only what c doesn't share with base. Without it an
+	// update is stored as a new copy.
+	Update func(ctx context.Context, from peer.ID, c, base cid.Cid) error
+	// Stat returns the name and size of a requested file or directory so
+	// Policy can be applied before anything else is fetched. Without it
+	// Policy is not enforced.
+	Stat func(ctx context.Context, c cid.Cid) (name string, size int64, err error)
+	// Policy limits which requests are accepted.
+	Policy Policy
+	// Donation caps the space given to other peers' replicas and the hours
+	// replication runs in, both ways.
+	Donation Donation
+	// Ops tracks replica transfers so they can be cancelled. Optional.
+	Ops *ops.Registry
+	// Events records what was decided on replicas. Optional.
+	Events *eventlog.Recorder
+	// Interval between checks. Pins and disconnects trigger a check too.
+	Interval time.Duration
+	Logger   *zap.Logger
+}
+
+func NewManager(opts ManagerOpts) *Manager {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.Factor < 1 {
+		opts.Factor = 1
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+
+	return &Manager{
+		logger:      opts.Logger,
+		holders:     make(map[cid.Cid]map[peer.ID]struct{}),
+		pending:     make(map[peer.ID]int64),
+		refused:     make(map[cid.Cid]map[peer.ID]time.Time),
+		accepting:   make(map[peer.ID]bool),
+		zones:       make(map[peer.ID]string),
+		gossip:      make(chan struct{}, maxGossipStores),
+		wake:        make(chan struct{}, 1),
+		ManagerOpts: opts,
+	}
+}
+
+// Start serves replication requests and runs the checks until ctx is done.
+func (m *Manager) Start(ctx context.Context) {
+	if m.Store != nil {
+		m.Network.HandleReplicas(m.handle)
+	}
+	m.Network.OnDisconnect(m.peerLost)
+	m.Network.OnSuccession(m.succeeded)
+	m.Network.OnSuccessionUndone(m.unsucceeded)
+	m.subscribe(ctx)
+	go m.loop(ctx)
+}
+
+func (m *Manager) subscribe(ctx context.Context) {
+	bus := m.Network.Bus()
+	err := network.ContentAddedTopic.Subscribe(ctx, bus, func(from peer.ID, msg network.ContentAdded) {
+		m.contentAdded(ctx, from, msg)
+	})
+	if err == nil {
+		err = network.NodeStatusTopic.Subscribe(ctx, bus, m.nodeStatus)
+	}
+	if err == nil && m.Store != nil {
+		err = network.PinRequestTopic.Subscribe(ctx, bus, func(from peer.ID, req network.PinRequest) {
+			m.pinRequested(ctx, from, req)
+		})
+	}
+	if err != nil {
+		m.logger.Warn("Not following cluster events", zap.Error(err))
+	}
+}
+
+// contentAdded records a peer that announced a full copy of a pin. Only
+// connected holders count, so the node connects to it if need be.
+func (m *Manager) contentAdded(ctx context.Context, from peer.ID, msg network.ContentAdded) {
+	c, err := cid.Decode(msg.CID)
+	if err != nil || !m.Pins.Has(c) || m.isHolder(c, from) {
+		return
+	}
+	m.addHolder(c, from)
+	m.logger.Debug("Peer announced a copy", zap.String("cid", c.String()), zap.String("peer", from.String()))
+
+	if !m.connected()[from] {
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			if err := m.Network.Host().Connect(ctx, peer.AddrInfo{ID: from}); err != nil {
+				m.logger.Debug("Failed to connect to holder", zap.String("peer", from.String()), zap.Error(err))
+			}
+		}()
+	}
+}
+
+// nodeStatus records the zone of a peer and checks replication when it
+// starts accepting replicas or moves to another zone.
+func (m *Manager) nodeStatus(from peer.ID, msg network.NodeStatus) {
+	m.mu.Lock()
+	known := m.accepting[from]
+	if msg.AcceptsReplicas {
+		m.accepting[from] = true
+	} else {
+		delete(m.accepting, from)
+	}
+	moved := m.zones[from] != msg.Zone
+	if msg.Zone != "" {
+		m.zones[from] = msg.Zone
+	} else {
+		delete(m.zones, from)
+	}
+	m.mu.Unlock()
+
+	if msg.AcceptsReplicas && !known || moved {
+		m.Trigger()
+	}
+}
+
+// pinRequested keeps a copy for a peer that asked the cluster for one. It
+// is announced on the event bus once stored, which tells the requester.
+func (m *Manager) pinRequested(ctx context.Context, from peer.ID, req network.PinRequest) {
+	c, err := cid.Decode(req.CID)
+	if err != nil || m.Pins.Has(c) {
+		return
+	}
+
+	select {
+	case m.gossip <- struct{}{}:
+	default:
+		m.logger.Debug("Dropping pin request, busy", zap.String("cid", c.String()), zap.String("peer", from.String()))
+		return
+	}
+
+	go func() {
+		defer func() { <-m.gossip }()
+
+		ctx, cancel := context.WithTimeout(ctx, gossipStoreTimeout)
+		defer cancel()
+		if err := m.handle(ctx, from, c, cid.Undef); err != nil {
+			m.logger.Debug("Pin request not taken",
+				zap.String("cid", c.String()),
+				zap.String("peer", from.String()),
+				zap.Error(err),
+			)
+		}
+	}()
+}
+
+// Trigger schedules a check soon, e.g. after a pin changed.
+func (m *Manager) Trigger() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Copies counts the copies of c known to be available: this node's and
+// those of connected holders.
+func (m *Manager) Copies(c cid.Cid) int {
+	return m.copies(c, m.connected())
+}
+
+func (m *Manager) copies(c cid.Cid, connected map[peer.ID]bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 1
+	for id := range m.holders[c] {
+		if connected[id] {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *Manager) handle(ctx context.Context, from peer.ID, c, base cid.Cid) error {
+	ctx, _, done := m.Ops.Start(ctx, ops.KindReplicate, c.String())
+	defer done()
+
+	// An update of a copy kept for the requester replaces that copy
+	kept, updating := m.Pins.Get(base)
+	updating = updating && kept.KeptFor == from && m.Update != nil && !m.Pins.Has(c)
+	store := m.Store
+	if updating {
+		store = func(ctx context.Context, c cid.Cid) error { return m.Update(ctx, from, c, base) }
+	} else {
+		kept = pin.Pin{}
+	}
+
+	if m.Pins.Has(c) || m.Stat == nil {
+		// Already kept, or no way to check: store without admission.
+		if err := store(ctx, c); err != nil {
+			return err
+		}
+	} else if err := m.admitAndStore(ctx, from, c, kept.Size, store); err != nil {
+		if errors.Is(err, network.ErrReplicaRefused) {
+			m.record(eventlog.ReplicaRefused, from, map[string]string{"cid": c.String(), "reason": err.Error()})
+		}
+		return err
+	}
+
+	// The requester has a copy too, so it counts towards our factor.
+	m.addHolder(c, from)
+	m.record(eventlog.ReplicaStored, from, map[string]string{"cid": c.String()})
+	if updating {
+		m.logger.Info("Updated replica", zap.String("cid", c.String()), zap.String("base", base.String()), zap.String("peer", from.String()))
+	} else {
+		m.logger.Info("Stored replica", zap.String("cid", c.String()), zap.String("peer", from.String()))
+	}
+	return nil
+}
+
+// admitAndStore applies Policy to a new replica and keeps it with store
+// if allowed, recording whom it is kept for. replaces is the size of the
+// copy it takes the place of, if any.
+func (m *Manager) admitAndStore(ctx context.Context, from peer.ID, c cid.Cid, replaces int64, store func(context.Context, cid.Cid) error) error {
+	name, size, err := m.Stat(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	// Count replicas still being fetched so concurrent requests can't
+	// overrun the per-peer cap together.
+	m.mu.Lock()
+	candidate := Candidate{From: from, Name: name, Size: size, Held: m.keptFor(from) + m.pending[from] - replaces}
+	if err := m.Policy.Admit(candidate); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if err := m.Donation.admit(time.Now(), m.donated()-replaces, size); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	m.pending[from] += size
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.pending[from] -= size
+		if m.pending[from] == 0 {
+			delete(m.pending, from)
+		}
+		m.mu.Unlock()
+	}()
+
+	if err := store(ctx, c); err != nil {
+		return err
+	}
+	return m.Pins.SetKeptFor(c, from, size)
+}
+
+// Updated asks the connected peers holding copies of base to keep c in
+// their place, c being a later version whose pin replaced base's. They
+// fetch only what c doesn't share with base. It returns how many did.
+func (m *Manager) Updated(ctx context.Context, base, c cid.Cid) int {
+	connected := m.connected()
+	m.mu.Lock()
+	var holders []peer.ID
+	for id := range m.holders[base] {
+		if connected[id] {
+			holders = append(holders, id)
+		}
+	}
+	m.mu.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		updated atomic.Int32
+	)
+	for _, id := range holders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, _, done := m.Ops.Start(ctx, ops.KindReplicate, c.String())
+			defer done()
+
+			if err := m.Network.RequestUpdate(ctx, id, c, base); err != nil {
+				m.logger.Debug("Replica update failed",
+					zap.String("cid", c.String()),
+					zap.String("peer", id.String()),
+					zap.Error(err),
+				)
+				return
+			}
+			m.addHolder(c, id)
+			updated.Add(1)
+			m.logger.Info("Updated replica", zap.String("cid", c.String()), zap.String("base", base.String()), zap.String("peer", id.String()))
+		}()
+	}
+	wg.Wait()
+
+	// Peers that didn't take the update are replaced as usual
+	m.Trigger()
+	return int(updated.Load())
+}
+
+// keptFor is the total size of the replicas kept for id.
+func (m *Manager) keptFor(id peer.ID) int64 {
+	var total int64
+	for _, p := range m.Pins.List() {
+		if p.KeptFor == id {
+			total += p.Size
+		}
+	}
+	return total
+}
+
+// donated is the total size of the replicas kept or being fetched for
+// other peers. Callers hold mu.
+func (m *Manager) donated() int64 {
+	var total int64
+	for _, p := range m.Pins.List() {
+		if p.KeptFor != "" {
+			total += p.Size
+		}
+	}
+	for _, size := range m.pending {
+		total += size
+	}
+	return total
+}
+
+func (m *Manager) peerLost(id peer.ID) {
+	m.mu.Lock()
+	held := 0
+	for _, holders := range m.holders {
+		if _, ok := holders[id]; ok {
+			held++
+		}
+	}
+	delete(m.accepting, id)
+	m.mu.Unlock()
+
+	if held > 0 {
+		m.logger.Info("Lost peer holding replicas", zap.String("peer", id.String()), zap.Int("pins", held))
+		m.Trigger()
+	}
+}
+
+// succeeded moves what is known of old to to, the peer ID it rotated to:
+// the copies it holds, the replicas kept for it and its place in Policy.
+func (m *Manager) succeeded(old, to peer.ID) {
+	m.mu.Lock()
+	for _, holders := range m.holders {
+		if _, ok := holders[old]; ok {
+			delete(holders, old)
+			holders[to] = struct{}{}
+		}
+	}
+	if size, ok := m.pending[old]; ok {
+		delete(m.pending, old)
+		m.pending[to] += size
+	}
+	if slices.Contains(m.Policy.Peers, old) && !slices.Contains(m.Policy.Peers, to) {
+		m.Policy.Peers = append(slices.Clone(m.Policy.Peers), to)
+	}
+	m.mu.Unlock()
+
+	for _, p := range m.Pins.List() {
+		if p.KeptFor != old {
+			continue
+		}
+		if err := m.Pins.SetKeptFor(p.CID, to, p.Size); err != nil {
+			m.logger.Warn("Failed to move replica to successor", zap.String("cid", p.CID.String()), zap.Error(err))
+		}
+	}
+}
+
+// unsucceeded moves back what succeeded gave to, once the key of old is
+// revoked and the succession undone. old is refused by then, so its
+// replicas are made elsewhere.
+func (m *Manager) unsucceeded(old, to peer.ID) {
+	m.mu.Lock()
+	for _, holders := range m.holders {
+		if _, ok := holders[to]; ok {
+			delete(holders, to)
+			holders[old] = struct{}{}
+		}
+	}
+	if size, ok := m.pending[to]; ok {
+		delete(m.pending, to)
+		m.pending[old] += size
+	}
+	if slices.Contains(m.Policy.Peers, old) {
+		m.Policy.Peers = slices.DeleteFunc(slices.Clone(m.Policy.Peers), func(id peer.ID) bool { return id == to })
+	}
+	m.mu.Unlock()
+
+	for _, p := range m.Pins.List() {
+		if p.KeptFor != to {
+			continue
+		}
+		if err := m.Pins.SetKeptFor(p.CID, old, p.Size); err != nil {
+			m.logger.Warn("Failed to move replica back from successor", zap.String("cid", p.CID.String()), zap.Error(err))
+		}
+	}
+}
+
+func (m *Manager) addHolder(c cid.Cid, id peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	holders, ok := m.holders[c]
+	if !ok {
+		holders = make(map[peer.ID]struct{})
+		m.holders[c] = holders
+	}
+	holders[id] = struct{}{}
+}
+
+func (m *Manager) isHolder(c cid.Cid, id peer.ID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.holders[c][id]
+	return ok
+}
+
+// requestReplica asks id to keep a copy of c.
+func (m *Manager) requestReplica(ctx context.Context, id peer.ID, c cid.Cid) error {
+	ctx, _, done := m.Ops.Start(ctx, ops.KindReplicate, c.String())
+	defer done()
+	return m.Network.RequestReplica(ctx, id, c)
+}
+
+func (m *Manager) setRefused(c cid.Cid, id peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refused, ok := m.refused[c]
+	if !ok {
+		refused = make(map[peer.ID]time.Time)
+		m.refused[c] = refused
+	}
+	refused[id] = time.Now()
+}
+
+// recentlyRefused reports whether id declined c within refusalBackoff.
+func (m *Manager) recentlyRefused(c cid.Cid, id peer.ID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	at, ok := m.refused[c][id]
+	return ok && time.Since(at) < refusalBackoff
+}
+
+func (m *Manager) connected() map[peer.ID]bool {
+	connected := make(map[peer.ID]bool)
+	for _, pi := range m.Network.Peers() {
+		connected[pi.ID] = true
+	}
+	return connected
+}
+
+func (m *Manager) loop(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+		m.check(ctx)
+	}
+}
+
+// check pushes copies of under-replicated pins to connected peers.
+func (m *Manager) check(ctx context.Context) {
+	if now := time.Now(); !m.Donation.Window.Contains(now) {
+		m.logger.Debug("Outside the replication window",
+			zap.Stringer("window", m.Donation.Window),
+			zap.Time("next", m.Donation.Window.Next(now)),
+		)
+		return
+	}
+
+	pins := m.Pins.List()
+
+	m.mu.Lock()
+	pinned := make(map[cid.Cid]bool, len(pins))
+	for _, p := range pins {
+		pinned[p.CID] = true
+	}
+	for c := range m.holders {
+		if !pinned[c] {
+			delete(m.holders, c)
+		}
+	}
+	for c := range m.refused {
+		if !pinned[c] {
+			delete(m.refused, c)
+		}
+	}
+	m.mu.Unlock()
+
+	peers := m.Network.Peers()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	connected := make(map[peer.ID]bool, len(peers))
+	for _, pi := range peers {
+		connected[pi.ID] = true
+	}
+
+	for _, p := range pins {
+		// Archived pins keep no blocks to copy from
+		if !p.Archived.IsZero() {
+			continue
+		}
+		req := m.Requirement(p)
+		have := m.copies(p.CID, connected)
+		zones := m.spanned(p.CID, connected)
+		if req.met(have, len(zones)) {
+			continue
+		}
+
+		for _, pi := range m.placement(p.CID, peers, zones) {
+			if req.met(have, len(zones)) || ctx.Err() != nil {
+				break
+			}
+			zone := m.zone(pi.ID)
+			// With copies enough, only one in another zone helps
+			if have >= req.Replicas && (zone == "" || zones[zone]) {
+				continue
+			}
+			if m.isHolder(p.CID, pi.ID) || m.recentlyRefused(p.CID, pi.ID) {
+				continue
+			}
+
+			if err := m.requestReplica(ctx, pi.ID, p.CID); err != nil {
+				if errors.Is(err, network.ErrReplicaRefused) {
+					m.setRefused(p.CID, pi.ID)
+					m.record(eventlog.ReplicaDeclined, pi.ID, map[string]string{"cid": p.CID.String()})
+				}
+				m.logger.Debug("Replica request failed",
+					zap.String("cid", p.CID.String()),
+					zap.String("peer", pi.ID.String()),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			m.addHolder(p.CID, pi.ID)
+			m.record(eventlog.ReplicaPlaced, pi.ID, map[string]string{"cid": p.CID.String()})
+			have++
+			if zone != "" {
+				zones[zone] = true
+			}
+			m.logger.Info("Replicated pin",
+				zap.String("cid", p.CID.String()),
+				zap.String("peer", pi.ID.String()),
+				zap.Int("copies", have),
+				zap.Int("factor", req.Replicas),
+			)
+		}
+
+		if !req.met(have, len(zones)) {
+			// Peers beyond those connected may take it.
+			if err := network.PinRequestTopic.Publish(ctx, m.Network.Bus(), network.PinRequest{CID: p.CID.String()}); err != nil {
+				m.logger.Debug("Failed to request pins", zap.String("cid", p.CID.String()), zap.Error(err))
+			}
+			m.record(eventlog.PinUnderReplicated, "", map[string]string{
+				"cid":    p.CID.String(),
+				"copies": strconv.Itoa(have),
+				"factor": strconv.Itoa(req.Replicas),
+			})
+			m.logger.Warn("Pin is under-replicated",
+				zap.String("cid", p.CID.String()),
+				zap.Int("copies", have),
+				zap.Int("factor", req.Replicas),
+				zap.Int("zones", len(zones)),
+				zap.Int("want_zones", req.Zones),
+			)
+		}
+	}
+}
+
+// record records a replication decision involving p, if any.
+func (m *Manager) record(typ string, p peer.ID, fields map[string]string) {
+	e := eventlog.Event{Type: typ, Fields: fields}
+	if p != "" {
+		e.Peer = p.String()
+	}
+	m.Events.Record(e)
+}
@@ -0,0 +1,121 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+
+	"github.com/Noah-Wilderom/dfs/pkg/eventlog"
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// DefaultSamples is the number of blocks Verify asks each holder for.
+const DefaultSamples = 8
+
+// Integrity is what sampling a pin's blocks from one holder found.
+type Integrity struct {
+	Peer    peer.ID
+	Sampled int
+	Valid   int
+	// Missing blocks the holder said it doesn't have, Corrupt ones it
+	// sent data for that doesn't match their CID.
+	Missing int
+	Corrupt int
+	// Err is why sampling stopped early, when the holder couldn't be
+	// reached or stopped answering.
+	Err error
+}
+
+// OK reports whether every block sampled was valid.
+func (i Integrity) OK() bool {
+	return i.Err == nil && i.Valid == i.Sampled
+}
+
+// Holders returns the peers recorded as holding a copy of c, connected or
+// not.
+func (m *Manager) Holders(c cid.Cid) []peer.ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	holders := make([]peer.ID, 0, len(m.holders[c]))
+	for id := range m.holders[c] {
+		holders = append(holders, id)
+	}
+	slices.Sort(holders)
+	return holders
+}
+
+// Verify asks every holder of c for samples of blocks, c's DAG, picked at
+// random, and checks what each sends back against the CIDs. The blocks
+// aren't stored. The root is always sampled, as a holder without it has
+// nothing usable.
+func (m *Manager) Verify(ctx context.Context, c cid.Cid, blocks []cid.Cid, samples int) []Integrity {
+	if samples <= 0 {
+		samples = DefaultSamples
+	}
+
+	var results []Integrity
+	for _, id := range m.Holders(c) {
+		res := Integrity{Peer: id}
+		for _, b := range sample(c, blocks, samples) {
+			_, err := m.Network.FetchBlock(ctx, peer.AddrInfo{ID: id}, b)
+			switch {
+			case err == nil:
+				res.Valid++
+			case errors.Is(err, network.ErrBlockNotFound):
+				res.Missing++
+			case errors.Is(err, storage.ErrHashMismatch):
+				res.Corrupt++
+			default:
+				res.Err = err
+			}
+			if res.Err != nil {
+				break
+			}
+			res.Sampled++
+		}
+
+		if !res.OK() {
+			m.logger.Warn("Replica failed verification",
+				zap.String("cid", c.String()),
+				zap.String("peer", id.String()),
+				zap.Int("missing", res.Missing),
+				zap.Int("corrupt", res.Corrupt),
+				zap.Error(res.Err),
+			)
+		}
+		fields := map[string]string{
+			"cid":     c.String(),
+			"sampled": strconv.Itoa(res.Sampled),
+			"valid":   strconv.Itoa(res.Valid),
+			"missing": strconv.Itoa(res.Missing),
+			"corrupt": strconv.Itoa(res.Corrupt),
+		}
+		if res.Err != nil {
+			fields["error"] = res.Err.Error()
+		}
+		m.record(eventlog.ReplicaVerified, id, fields)
+		results = append(results, res)
+	}
+	return results
+}
+
+// sample picks up to n of blocks at random, root first.
+func sample(root cid.Cid, blocks []cid.Cid, n int) []cid.Cid {
+	picked := []cid.Cid{root}
+	for _, i := range rand.Perm(len(blocks)) {
+		if len(picked) == n {
+			break
+		}
+		if !blocks[i].Equals(root) {
+			picked = append(picked, blocks[i])
+		}
+	}
+	return picked
+}
@@ -0,0 +1,127 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"go.uber.org/zap"
+)
+
+// rotten serves every block it has with a bit flipped.
+type rotten struct {
+	*storage.MemBlockstore
+}
+
+func (r rotten) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	data, err := r.MemBlockstore.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	data = append([]byte(nil), data...)
+	data[0] ^= 1
+	return data, nil
+}
+
+// A holder with every block checks out, one missing a block or sending a
+// corrupt one is reported as such, and one that is gone is unreachable.
+func TestVerify(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var blocks []cid.Cid
+	full, partial := storage.NewMemBlockstore(), storage.NewMemBlockstore()
+	for i := range 4 {
+		b, err := storage.NewRawBlock([]byte(fmt.Sprint("block ", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b.CID)
+		full.Put(ctx, b.CID, b.Data)
+		if i != 3 {
+			partial.Put(ctx, b.CID, b.Data)
+		}
+	}
+	root := blocks[0]
+
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	sources := []network.BlockSource{nil, full, partial, rotten{full}}
+	nets := make([]*network.P2PNetworking, len(sources))
+	for i, src := range sources {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets[i] = network.NewP2PNetworking(network.P2PNetworkingOpts{Blocks: src, CustomHost: h, Logger: zap.NewNop()})
+		if err := nets[i].Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nets[i].Close() })
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(ManagerOpts{Network: nets[0]})
+	good, short, bad := nets[1].Host().ID(), nets[2].Host().ID(), nets[3].Host().ID()
+	gone := peer.ID("gone")
+	for _, id := range []peer.ID{good, short, bad, gone} {
+		m.addHolder(root, id)
+	}
+
+	results := make(map[peer.ID]Integrity)
+	for _, r := range m.Verify(ctx, root, blocks, len(blocks)) {
+		results[r.Peer] = r
+	}
+	if len(results) != 4 {
+		t.Fatalf("verified %d holders, want 4", len(results))
+	}
+	if r := results[good]; !r.OK() || r.Sampled != 4 {
+		t.Errorf("full holder = %+v, want 4 valid", r)
+	}
+	if r := results[short]; r.OK() || r.Missing != 1 || r.Valid != 3 {
+		t.Errorf("partial holder = %+v, want 1 missing", r)
+	}
+	if r := results[bad]; r.OK() || r.Corrupt != 4 {
+		t.Errorf("corrupt holder = %+v, want 4 corrupt", r)
+	}
+	if r := results[gone]; r.OK() || r.Err == nil || r.Sampled != 0 {
+		t.Errorf("gone holder = %+v, want unreachable", r)
+	}
+}
+
+// Samples are distinct, start at the root and stop at n.
+func TestSample(t *testing.T) {
+	var blocks []cid.Cid
+	for i := range 10 {
+		b, err := storage.NewRawBlock([]byte(fmt.Sprint(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b.CID)
+	}
+	got := sample(blocks[3], blocks, 4)
+	if len(got) != 4 || !got[0].Equals(blocks[3]) {
+		t.Fatalf("sample = %v, want 4 starting at the root", got)
+	}
+	seen := make(map[cid.Cid]bool)
+	for _, c := range got {
+		if seen[c] {
+			t.Errorf("%s sampled twice", c)
+		}
+		seen[c] = true
+	}
+	if got := sample(blocks[0], blocks, 20); len(got) != 10 {
+		t.Errorf("sampling more than there are = %d blocks, want 10", len(got))
+	}
+}
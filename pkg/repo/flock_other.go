@@ -0,0 +1,15 @@
+//go:build !unix
+
+package repo
+
+import "os"
+
+// Without flock every lock succeeds; running two writers against one repo
+// is then up to the user to avoid.
+func tryLock(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
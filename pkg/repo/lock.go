@@ -0,0 +1,217 @@
+// Package repo coordinates processes that share a data dir.
+//
+// One process at a time may write the repo: it holds an exclusive lock on
+// repo.lock, whose contents name the holder. Any number of read-only
+// processes (inspection tools, a read-only daemon) can run next to it. They
+// never touch repo.lock; instead each holds a shared lock on readers.lock.
+// Blocks are written with an atomic link, so readers never see partial
+// data, and a writer that is about to delete blocks out from under them can
+// first check for readers with (*Lock).TryExclusive.
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	writeLockFile   = "repo.lock"
+	readersLockFile = "readers.lock"
+)
+
+var (
+	ErrLocked       = errors.New("repo: locked by another process")
+	ErrReadersAlive = errors.New("repo: in use by read-only processes")
+)
+
+// LockedError reports who holds the write lock.
+type LockedError struct {
+	Path string
+	// PID of the holder, 0 if unknown.
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("repo %s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("repo %s is locked by process %d", e.Path, e.PID)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Lock is a held write lock or read lease. Release it with Close.
+type Lock struct {
+	dir     string
+	write   bool
+	file    *os.File
+	readers *os.File
+}
+
+// AcquireWrite takes the write lock on the repo at dir, creating dir if
+// needed. It fails with a *LockedError if another process holds it.
+func AcquireWrite(dir string) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, writeLockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := tryLock(f, true)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !ok {
+		f.Close()
+		return nil, &LockedError{Path: dir, PID: readHolder(path)}
+	}
+
+	// Readers open readers.lock read-only, so make sure it exists for them.
+	readers, err := os.OpenFile(filepath.Join(dir, readersLockFile), os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		unlock(f)
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%d\n%s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+	}
+	return &Lock{dir: dir, write: true, file: f, readers: readers}, nil
+}
+
+// WaitWrite takes the write lock on the repo at dir like AcquireWrite, but
+// if another process holds it, waits for that process to release it or
+// die, trying again every interval until ctx is done.
+func WaitWrite(ctx context.Context, dir string, interval time.Duration) (*Lock, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		l, err := AcquireWrite(dir)
+		if !errors.Is(err, ErrLocked) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// AcquireRead takes a read lease on the repo at dir. It doesn't conflict
+// with the write lock, only with a writer inside TryExclusive. On read-only media, where the lease file can't be
+// created, no lease is taken; nothing can delete blocks there anyway.
+func AcquireRead(dir string) (*Lock, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, readersLockFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = os.OpenFile(filepath.Join(dir, readersLockFile), os.O_RDONLY|os.O_CREATE, 0644)
+	}
+	if err != nil {
+		return &Lock{dir: dir}, nil
+	}
+
+	ok, err := tryLock(f, false)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !ok {
+		f.Close()
+		return nil, &LockedError{Path: dir, PID: readHolder(filepath.Join(dir, writeLockFile))}
+	}
+	return &Lock{dir: dir, readers: f}, nil
+}
+
+// Holder returns the PID of the process holding the write lock on dir, or
+// 0 if it isn't locked.
+func Holder(dir string) int {
+	path := filepath.Join(dir, writeLockFile)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	ok, err := tryLock(f, false)
+	if err != nil || ok {
+		if ok {
+			unlock(f)
+		}
+		return 0
+	}
+	return readHolder(path)
+}
+
+// TryExclusive reports whether no read-only process currently holds a
+// lease. While it returns nil the writer holds readers.lock exclusively, so
+// new readers fail with a *LockedError until Done is called.
+func (l *Lock) TryExclusive() error {
+	if !l.write {
+		return errors.New("repo: exclusive access needs the write lock")
+	}
+
+	ok, err := tryLock(l.readers, true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrReadersAlive
+	}
+	return nil
+}
+
+// Done ends exclusive access taken with TryExclusive.
+func (l *Lock) Done() {
+	if l.write {
+		unlock(l.readers)
+	}
+}
+
+func (l *Lock) Dir() string {
+	return l.dir
+}
+
+// Close releases the lock.
+func (l *Lock) Close() error {
+	var errs []error
+	if l.file != nil {
+		// Clear the holder so a stale PID is never reported.
+		l.file.Truncate(0)
+		errs = append(errs, unlock(l.file), l.file.Close())
+	}
+	if l.readers != nil {
+		errs = append(errs, unlock(l.readers), l.readers.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func readHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	pid, _ := strconv.Atoi(strings.TrimSpace(line))
+	return pid
+}
@@ -0,0 +1,117 @@
+//go:build unix
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func mustWrite(t *testing.T, dir string) *Lock {
+	t.Helper()
+	l, err := AcquireWrite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// A second writer is turned away with the PID of the first, and the lock
+// is free again once the first closes.
+func TestAcquireWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, err := AcquireWrite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := Holder(dir); got != os.Getpid() {
+		t.Errorf("Holder = %d, want %d", got, os.Getpid())
+	}
+
+	_, err = AcquireWrite(dir)
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("second AcquireWrite = %v, want a *LockedError", err)
+	}
+	if locked.PID != os.Getpid() {
+		t.Errorf("LockedError.PID = %d, want %d", locked.PID, os.Getpid())
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := Holder(dir); got != 0 {
+		t.Errorf("Holder after Close = %d, want 0", got)
+	}
+	mustWrite(t, dir)
+}
+
+// WaitWrite gets the lock once the holder lets go, and gives up when its
+// context ends first.
+func TestWaitWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, err := AcquireWrite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := WaitWrite(ctx, dir, time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitWrite while held = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	time.AfterFunc(10*time.Millisecond, func() { l.Close() })
+	got, err := WaitWrite(context.Background(), dir, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Close()
+}
+
+// Readers sit beside the writer, and exclusive access waits for them to
+// leave and keeps new ones out until Done.
+func TestTryExclusive(t *testing.T) {
+	dir := t.TempDir()
+	w := mustWrite(t, dir)
+
+	r, err := AcquireRead(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.TryExclusive(); err == nil {
+		t.Error("TryExclusive on a read lease succeeded")
+	}
+	if err := w.TryExclusive(); !errors.Is(err, ErrReadersAlive) {
+		t.Fatalf("TryExclusive with a reader = %v, want %v", err, ErrReadersAlive)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.TryExclusive(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcquireRead(dir); !errors.Is(err, ErrLocked) {
+		t.Errorf("AcquireRead during exclusive access = %v, want %v", err, ErrLocked)
+	}
+	w.Done()
+
+	r, err = AcquireRead(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+}
+
+// A read lease on a repo that doesn't exist fails rather than creating it.
+func TestAcquireReadMissing(t *testing.T) {
+	if _, err := AcquireRead(t.TempDir() + "/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("AcquireRead = %v, want %v", err, os.ErrNotExist)
+	}
+}
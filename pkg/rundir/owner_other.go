@@ -0,0 +1,10 @@
+//go:build !unix
+
+package rundir
+
+import "io/fs"
+
+// Without Unix owners the directory's mode is all there is to check.
+func ownedBySelf(fi fs.FileInfo) bool {
+	return true
+}
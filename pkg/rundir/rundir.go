@@ -0,0 +1,61 @@
+// Package rundir locates the per-user directory holding the agent, handoff
+// and read-only API sockets, and makes sure nobody else can reach into it.
+//
+// The directory is dfs under $XDG_RUNTIME_DIR, or dfs-<uid> under the temp
+// dir when that isn't set. The latter is a predictable name in a directory
+// everyone can write to, so another user may create it first: Ensure
+// refuses a directory that isn't the current user's alone rather than put
+// sockets in it.
+package rundir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrUnsafe is returned for a socket directory others can enter or
+// replace.
+var ErrUnsafe = errors.New("rundir: directory not private")
+
+// Dir is the current user's socket directory.
+func Dir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "dfs")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("dfs-%d", os.Getuid()))
+}
+
+// Ensure creates dir, only accessible to the current user, or checks that
+// an existing one is: a directory, not a symlink, owned by the current
+// user and with mode 0700.
+func Ensure(dir string) error {
+	err := os.Mkdir(dir, 0700)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+			return err
+		}
+		err = os.Mkdir(dir, 0700)
+	}
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Mode()&fs.ModeSymlink != 0:
+		return fmt.Errorf("%w: %s is a symlink", ErrUnsafe, dir)
+	case !fi.IsDir():
+		return fmt.Errorf("%w: %s is not a directory", ErrUnsafe, dir)
+	case !ownedBySelf(fi):
+		return fmt.Errorf("%w: %s is owned by another user", ErrUnsafe, dir)
+	case fi.Mode().Perm() != 0700:
+		return fmt.Errorf("%w: %s has mode %#o, want 0700", ErrUnsafe, dir, fi.Mode().Perm())
+	}
+	return nil
+}
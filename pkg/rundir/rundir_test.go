@@ -0,0 +1,61 @@
+package rundir
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsure(t *testing.T) {
+	base := t.TempDir()
+
+	dir := filepath.Join(base, "new", "dfs")
+	if err := Ensure(dir); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Fatalf("created directory: %v, %v", fi, err)
+	}
+	if err := Ensure(dir); err != nil {
+		t.Errorf("Ensure of a private directory = %v", err)
+	}
+
+	open := filepath.Join(base, "open")
+	if err := os.Mkdir(open, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(open, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Ensure(open); !errors.Is(err, ErrUnsafe) {
+		t.Errorf("Ensure of a 0755 directory = %v, want %v", err, ErrUnsafe)
+	}
+
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := Ensure(link); !errors.Is(err, ErrUnsafe) {
+		t.Errorf("Ensure of a symlink = %v, want %v", err, ErrUnsafe)
+	}
+
+	file := filepath.Join(base, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Ensure(file); !errors.Is(err, ErrUnsafe) {
+		t.Errorf("Ensure of a file = %v, want %v", err, ErrUnsafe)
+	}
+}
+
+func TestDir(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if got := Dir(); got != "/run/user/1000/dfs" {
+		t.Errorf("Dir = %s, want /run/user/1000/dfs", got)
+	}
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if got := filepath.Dir(Dir()); got != os.TempDir() {
+		t.Errorf("Dir without XDG_RUNTIME_DIR is in %s, want %s", got, os.TempDir())
+	}
+}
@@ -0,0 +1,246 @@
+// Package shamir splits a secret into shares with Shamir's secret sharing
+// over GF(256): any threshold of the shares rebuild the secret, and fewer
+// reveal nothing about it.
+//
+// Every byte of the secret is the constant term of its own random
+// polynomial of degree threshold-1. A share holds the value of all those
+// polynomials at its x coordinate, so it is as long as the secret.
+package shamir
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+var (
+	// ErrTooFewShares is returned when combining fewer shares than their
+	// threshold.
+	ErrTooFewShares = errors.New("shamir: too few shares")
+	// ErrBadShare is returned for a share that doesn't parse, or whose
+	// checksum doesn't match.
+	ErrBadShare = errors.New("shamir: bad share")
+	// ErrMixedShares is returned when combining shares of different
+	// splits, or the same share twice.
+	ErrMixedShares = errors.New("shamir: shares don't belong together")
+)
+
+// Share is one share of a split secret.
+type Share struct {
+	// Set is random for every split, to tell its shares from another's.
+	Set       uint32
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+// Split splits secret into n shares, any threshold of which rebuild it.
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	switch {
+	case len(secret) == 0:
+		return nil, errors.New("shamir: empty secret")
+	case threshold < 2:
+		return nil, errors.New("shamir: the threshold must be at least 2")
+	case n < threshold:
+		return nil, errors.New("shamir: fewer shares than the threshold")
+	case n > 255:
+		return nil, errors.New("shamir: at most 255 shares")
+	}
+
+	var set [4]byte
+	if _, err := rand.Read(set[:]); err != nil {
+		return nil, err
+	}
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{
+			Set:       binary.BigEndian.Uint32(set[:]),
+			Threshold: threshold,
+			X:         byte(i + 1),
+			Y:         make([]byte, len(secret)),
+		}
+	}
+
+	coeffs := make([]byte, threshold)
+	defer clear(coeffs)
+	for j, b := range secret {
+		coeffs[0] = b
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i].Y[j] = eval(coeffs, shares[i].X)
+		}
+	}
+	return shares, nil
+}
+
+// Combine rebuilds the secret from at least the threshold of its shares.
+// The shares must all be of one split: the same set, threshold and
+// length, with distinct, non-zero x coordinates.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrTooFewShares
+	}
+	first := shares[0]
+	if err := first.check(); err != nil {
+		return nil, err
+	}
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.Set != first.Set || s.Threshold != first.Threshold || len(s.Y) != len(first.Y) || seen[s.X] || s.X == 0 {
+			return nil, ErrMixedShares
+		}
+		seen[s.X] = true
+	}
+	if len(shares) < first.Threshold {
+		return nil, ErrTooFewShares
+	}
+	// More than the threshold adds nothing
+	shares = shares[:first.Threshold]
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, len(first.Y))
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = mul(basis, div(sj.X, sj.X^si.X))
+			}
+		}
+		for k, y := range si.Y {
+			secret[k] ^= mul(y, basis)
+		}
+	}
+	return secret, nil
+}
+
+// sharePrefix starts a share written out by String.
+const sharePrefix = "dfs-share-"
+
+// shareVersion is the version of the encoding String writes.
+const shareVersion = 1
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// String writes the share out as text to print or copy by hand: base32
+// in dash-separated groups, with a checksum that catches typos.
+func (s Share) String() string {
+	b := make([]byte, 0, 7+len(s.Y)+4)
+	b = append(b, shareVersion)
+	b = binary.BigEndian.AppendUint32(b, s.Set)
+	b = append(b, byte(s.Threshold), s.X)
+	b = append(b, s.Y...)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+
+	text := shareEncoding.EncodeToString(b)
+	var sb strings.Builder
+	sb.WriteString(sharePrefix)
+	for i := 0; i < len(text); i += 5 {
+		if i > 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteString(text[i:min(i+5, len(text))])
+	}
+	return sb.String()
+}
+
+// ParseShare reads a share written by String. Case, spaces, line breaks
+// and dashes between the groups don't matter.
+func ParseShare(text string) (Share, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if !strings.HasPrefix(text, sharePrefix) {
+		return Share{}, fmt.Errorf("%w: doesn't start with %s", ErrBadShare, sharePrefix)
+	}
+	text = strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(text[len(sharePrefix):]))
+
+	b, err := shareEncoding.DecodeString(text)
+	if err != nil {
+		return Share{}, fmt.Errorf("%w: %v", ErrBadShare, err)
+	}
+	if len(b) < 12 {
+		return Share{}, fmt.Errorf("%w: too short", ErrBadShare)
+	}
+	body, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return Share{}, fmt.Errorf("%w: checksum mismatch, check for typos", ErrBadShare)
+	}
+	if body[0] != shareVersion {
+		return Share{}, fmt.Errorf("%w: unknown version %d", ErrBadShare, body[0])
+	}
+	share := Share{
+		Set:       binary.BigEndian.Uint32(body[1:5]),
+		Threshold: int(body[5]),
+		X:         body[6],
+		Y:         body[7:],
+	}
+	if err := share.check(); err != nil {
+		return Share{}, err
+	}
+	return share, nil
+}
+
+// check rejects a share no split makes: a threshold below 2, which would
+// hand out the secret itself, the x coordinate 0, where the secret is, or
+// no data.
+func (s Share) check() error {
+	switch {
+	case s.Threshold < 2:
+		return fmt.Errorf("%w: threshold %d", ErrBadShare, s.Threshold)
+	case s.X == 0:
+		return fmt.Errorf("%w: x coordinate 0", ErrBadShare)
+	case len(s.Y) == 0:
+		return fmt.Errorf("%w: empty", ErrBadShare)
+	}
+	return nil
+}
+
+// eval evaluates the polynomial with the given coefficients, lowest
+// first, at x.
+func eval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// Arithmetic in GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1,
+// through logarithms to the generator 3.
+var logTable, expTable = func() (log [256]byte, exp [510]byte) {
+	x := byte(1)
+	for i := range 255 {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// x *= 3
+		x ^= x<<1 ^ byte(int8(x)>>7)&0x1b
+	}
+	return log, exp
+}()
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func div(a, b byte) byte {
+	if b == 0 {
+		panic("shamir: division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
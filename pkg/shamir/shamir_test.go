@@ -0,0 +1,126 @@
+package shamir
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("the node key and the master key")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pick := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var some []Share
+		for _, i := range pick {
+			some = append(some, shares[i])
+		}
+		got, err := Combine(some)
+		if err != nil {
+			t.Fatalf("Combine %v: %v", pick, err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Errorf("Combine %v = %q", pick, got)
+		}
+	}
+
+	if _, err := Combine(shares[:2]); !errors.Is(err, ErrTooFewShares) {
+		t.Errorf("Combine of 2 of 3 = %v, want %v", err, ErrTooFewShares)
+	}
+	if _, err := Combine([]Share{shares[0], shares[0], shares[1]}); !errors.Is(err, ErrMixedShares) {
+		t.Errorf("Combine with a share twice = %v, want %v", err, ErrMixedShares)
+	}
+	other, err := Split(secret, 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Combine([]Share{shares[0], shares[1], other[2]}); !errors.Is(err, ErrMixedShares) {
+		t.Errorf("Combine across splits = %v, want %v", err, ErrMixedShares)
+	}
+}
+
+func TestShareText(t *testing.T) {
+	shares, err := Split([]byte{0, 1, 2, 255}, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := shares[1].String()
+
+	// Copied by hand: other case, broken over lines
+	copied := strings.ToUpper(text[:20]) + "\n  " + text[20:]
+	got, err := ParseShare(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Set != shares[1].Set || got.Threshold != 2 || got.X != 2 || !bytes.Equal(got.Y, shares[1].Y) {
+		t.Errorf("ParseShare = %+v, want %+v", got, shares[1])
+	}
+
+	// A typo
+	i := strings.LastIndexByte(text, '-') + 1
+	typo := []byte(text)
+	if typo[i] == 'A' {
+		typo[i] = 'B'
+	} else {
+		typo[i] = 'A'
+	}
+	if _, err := ParseShare(string(typo)); !errors.Is(err, ErrBadShare) {
+		t.Errorf("ParseShare with a typo = %v, want %v", err, ErrBadShare)
+	}
+}
+
+// Shares no split makes are refused rather than combined into a wrong
+// secret.
+func TestCombineRejects(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(i int, change func(*Share)) []Share {
+		some := []Share{shares[0], shares[1], shares[2]}
+		for j := range some {
+			some[j].Y = bytes.Clone(some[j].Y)
+		}
+		change(&some[i])
+		return some
+	}
+	for _, tc := range []struct {
+		name   string
+		shares []Share
+		want   error
+	}{
+		{"none", nil, ErrTooFewShares},
+		{"threshold 0", with(0, func(s *Share) { s.Threshold = 0 }), ErrBadShare},
+		{"threshold 1", with(0, func(s *Share) { s.Threshold = 1 }), ErrBadShare},
+		{"other threshold", with(1, func(s *Share) { s.Threshold = 3 }), ErrMixedShares},
+		{"other length", with(2, func(s *Share) { s.Y = s.Y[:3] }), ErrMixedShares},
+		{"same x", with(1, func(s *Share) { s.X = shares[0].X }), ErrMixedShares},
+		{"x 0", with(0, func(s *Share) { s.X = 0 }), ErrBadShare},
+		{"x 0 later", with(2, func(s *Share) { s.X = 0 }), ErrMixedShares},
+		{"empty", with(0, func(s *Share) { s.Y = nil }), ErrBadShare},
+	} {
+		if _, err := Combine(tc.shares); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Combine = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestParseShareRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		share Share
+	}{
+		{"threshold 0", Share{Set: 1, Threshold: 0, X: 1, Y: []byte{1}}},
+		{"threshold 1", Share{Set: 1, Threshold: 1, X: 1, Y: []byte{1}}},
+		{"x 0", Share{Set: 1, Threshold: 2, X: 0, Y: []byte{1}}},
+	} {
+		// String writes whatever it is given, with a valid checksum
+		if _, err := ParseShare(tc.share.String()); !errors.Is(err, ErrBadShare) {
+			t.Errorf("%s: ParseShare = %v, want %v", tc.name, err, ErrBadShare)
+		}
+	}
+}
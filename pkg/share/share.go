@@ -0,0 +1,192 @@
+// Package share gives one peer access to an encrypted file without handing
+// over a master key.
+//
+// A capability names the file, carries the file's key wrapped for the
+// recipient's node key and may expire. The node that issues it signs it
+// with its own node key, so the recipient can check who shared the file.
+// Only the recipient can unwrap the key: its peer ID is an Ed25519 public
+// key, which the file key is sealed to through an ephemeral X25519
+// exchange. The capability travels as a text token, safe to pass on
+// through any channel.
+package share
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Version is the capability format written by this release.
+const Version = 1
+
+// TokenPrefix starts every token, to tell it from other strings.
+const TokenPrefix = "dfs-share-"
+
+// domain prefixes signed capabilities so the signature can't be passed
+// off as one over something else.
+const domain = "dfs-share:"
+
+var (
+	ErrBadSignature = errors.New("share: capability signature does not verify")
+	// ErrExpired is returned for a capability past its expiry.
+	ErrExpired = errors.New("share: capability expired")
+	// ErrNotRecipient is returned when opening a capability issued to
+	// another peer.
+	ErrNotRecipient = errors.New("share: capability is for another peer")
+)
+
+// Capability grants Recipient access to the encrypted file CID.
+type Capability struct {
+	Version   int    `json:"version"`
+	CID       string `json:"cid"`
+	Issuer    string `json:"issuer"`
+	Recipient string `json:"recipient"`
+	// Expires is when the capability stops opening, zero for never.
+	Expires time.Time `json:"expires,omitzero"`
+	// Ephemeral is the X25519 public key the file key was wrapped with,
+	// Key the wrapped file key.
+	Ephemeral []byte `json:"ephemeral"`
+	Key       []byte `json:"key"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// Issue grants to access to the file c, encrypted with key, until expires
+// or for good when expires is zero. The capability is signed with priv,
+// the issuing node's key.
+func Issue(priv crypto.PrivKey, to peer.ID, c cid.Cid, key *crypt.FileKey, expires time.Time) (*Capability, error) {
+	issuer, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	capability := &Capability{
+		Version:   Version,
+		CID:       c.String(),
+		Issuer:    issuer.String(),
+		Recipient: to.String(),
+	}
+	if !expires.IsZero() {
+		capability.Expires = expires.UTC().Truncate(time.Second)
+	}
+	if capability.Ephemeral, capability.Key, err = wrap(to, key, capability.aad()); err != nil {
+		return nil, err
+	}
+
+	payload, err := capability.payload()
+	if err != nil {
+		return nil, err
+	}
+	capability.Signature, err = priv.Sign(payload)
+	return capability, err
+}
+
+// Parse decodes a token written by Token. It doesn't check the signature,
+// see Verify.
+func Parse(token string) (*Capability, error) {
+	data, ok := strings.CutPrefix(strings.TrimSpace(token), TokenPrefix)
+	if !ok {
+		return nil, errors.New("share: not a share token")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("share: malformed token: %w", err)
+	}
+	var capability Capability
+	if err := json.Unmarshal(raw, &capability); err != nil {
+		return nil, fmt.Errorf("share: malformed token: %w", err)
+	}
+	if capability.Version != Version {
+		return nil, fmt.Errorf("share: unsupported capability version %d", capability.Version)
+	}
+	return &capability, nil
+}
+
+// Token encodes the capability as text.
+func (c *Capability) Token() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Verify checks the signature and that the capability hasn't expired by
+// now, and returns the file it grants access to.
+func (c *Capability) Verify(now time.Time) (cid.Cid, error) {
+	root, err := cid.Decode(c.CID)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("share: capability file: %w", err)
+	}
+	if _, err := peer.Decode(c.Recipient); err != nil {
+		return cid.Undef, fmt.Errorf("share: capability recipient: %w", err)
+	}
+	issuer, err := peer.Decode(c.Issuer)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("share: capability issuer: %w", err)
+	}
+	pub, err := issuer.ExtractPublicKey()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("share: capability issuer: %w", err)
+	}
+
+	payload, err := c.payload()
+	if err != nil {
+		return cid.Undef, err
+	}
+	ok, err := pub.Verify(payload, c.Signature)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !ok {
+		return cid.Undef, ErrBadSignature
+	}
+	if c.Expired(now) {
+		return cid.Undef, fmt.Errorf("%w at %s", ErrExpired, c.Expires.Local().Format(time.DateTime))
+	}
+	return root, nil
+}
+
+// Expired reports whether the capability has expired by now.
+func (c *Capability) Expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !now.Before(c.Expires)
+}
+
+// Open verifies the capability and unwraps the file key with priv, the
+// recipient's node key.
+func (c *Capability) Open(priv crypto.PrivKey, now time.Time) (*crypt.FileKey, error) {
+	if _, err := c.Verify(now); err != nil {
+		return nil, err
+	}
+	self, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if self.String() != c.Recipient {
+		return nil, fmt.Errorf("%w: %s", ErrNotRecipient, c.Recipient)
+	}
+	return unwrap(priv, c.Ephemeral, c.Key, c.aad())
+}
+
+// payload is what gets signed: the capability, without the signature.
+func (c *Capability) payload() ([]byte, error) {
+	unsigned := *c
+	unsigned.Signature = nil
+
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(domain), data...), nil
+}
+
+// aad binds the wrapped key to the file and recipient.
+func (c *Capability) aad() []byte {
+	return []byte(domain + c.CID + "/" + c.Recipient)
+}
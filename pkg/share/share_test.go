@@ -0,0 +1,188 @@
+package share
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func nodeKey(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, id
+}
+
+func fileCID(t *testing.T) cid.Cid {
+	t.Helper()
+	h, err := multihash.Sum([]byte("shared file"), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+// TestConvertKnownAnswer checks the Ed25519 to X25519 conversion against
+// libsodium's crypto_sign_ed25519_{pk,sk}_to_curve25519 test vector.
+func TestConvertKnownAnswer(t *testing.T) {
+	seed := mustHex(t, "421151a459faeade3d247115f94aedae42318124095afabe4d1451a559faedee")
+	wantPub := mustHex(t, "f1814f0e8ff1043d8a44d25babff3cedcae6c22c3edaa48f857ae70de2baae50")
+	wantPriv := mustHex(t, "8052030376d47112be7f73ed7a019293dd12ad910b654455798b4667d73de166")
+
+	priv, err := crypto.UnmarshalEd25519PrivateKey(ed25519.NewKeyFromSeed(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := x25519Public(priv.GetPublic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pub.Bytes(), wantPub) {
+		t.Errorf("public key = %x, want %x", pub.Bytes(), wantPub)
+	}
+
+	xpriv, err := x25519Private(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// libsodium returns the scalar clamped, X25519 clamps it when used
+	scalar := xpriv.Bytes()
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+	if !bytes.Equal(scalar, wantPriv) {
+		t.Errorf("private key = %x, want %x", scalar, wantPriv)
+	}
+	if !xpriv.PublicKey().Equal(pub) {
+		t.Error("converted private key doesn't match the converted public key")
+	}
+}
+
+func TestConvertRejectsOtherKeys(t *testing.T) {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Secp256k1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x25519Public(priv.GetPublic()); !errors.Is(err, errKeyType) {
+		t.Errorf("x25519Public(secp256k1) = %v, want %v", err, errKeyType)
+	}
+	if _, err := x25519Private(priv); !errors.Is(err, errKeyType) {
+		t.Errorf("x25519Private(secp256k1) = %v, want %v", err, errKeyType)
+	}
+}
+
+func TestIssueOpen(t *testing.T) {
+	issuer, _ := nodeKey(t)
+	recipient, to := nodeKey(t)
+	c := fileCID(t)
+	key, err := crypt.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capability, err := Issue(issuer, to, c, key, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := capability.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parsed.Open(recipient, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Encode(), key.Encode()) {
+		t.Error("opened key differs from the shared one")
+	}
+	if verified, err := parsed.Verify(time.Now()); err != nil || !verified.Equals(c) {
+		t.Errorf("Verify = %s, %v, want %s", verified, err, c)
+	}
+}
+
+func TestOpenWrongRecipient(t *testing.T) {
+	issuer, _ := nodeKey(t)
+	_, to := nodeKey(t)
+	other, otherID := nodeKey(t)
+	key, err := crypt.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	capability, err := Issue(issuer, to, fileCID(t), key, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := capability.Open(other, time.Now()); !errors.Is(err, ErrNotRecipient) {
+		t.Errorf("Open by another peer = %v, want %v", err, ErrNotRecipient)
+	}
+
+	// Naming itself the recipient breaks the signature
+	forged := *capability
+	forged.Recipient = otherID.String()
+	if _, err := forged.Open(other, time.Now()); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Open of a forged capability = %v, want %v", err, ErrBadSignature)
+	}
+
+	// And the wrapped key only opens for the recipient's key anyway
+	if _, err := unwrap(other, capability.Ephemeral, capability.Key, capability.aad()); !errors.Is(err, crypt.ErrDecrypt) {
+		t.Errorf("unwrap with another key = %v, want %v", err, crypt.ErrDecrypt)
+	}
+}
+
+func TestOpenExpired(t *testing.T) {
+	issuer, _ := nodeKey(t)
+	recipient, to := nodeKey(t)
+	key, err := crypt.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	capability, err := Issue(issuer, to, fileCID(t), key, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := capability.Open(recipient, expires.Add(-time.Minute)); err != nil {
+		t.Errorf("Open before expiry: %v", err)
+	}
+	if _, err := capability.Open(recipient, expires); !errors.Is(err, ErrExpired) {
+		t.Errorf("Open at expiry = %v, want %v", err, ErrExpired)
+	}
+
+	// Pushing the expiry back breaks the signature
+	capability.Expires = expires.Add(time.Hour)
+	if _, err := capability.Open(recipient, expires); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Open with a changed expiry = %v, want %v", err, ErrBadSignature)
+	}
+}
@@ -0,0 +1,106 @@
+package share
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+)
+
+// Redeemed is a capability a node accepted, and when.
+type Redeemed struct {
+	Capability
+	Redeemed time.Time `json:"redeemed"`
+}
+
+// Store keeps the capabilities a node redeemed, one per file, in a JSON
+// file like the pin set. The keys in them stay wrapped for the node key,
+// and are unwrapped whenever a file is read, so a capability stops
+// working once it expires.
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	caps map[string]Redeemed
+}
+
+// OpenStore loads the capabilities at path. A missing file is an empty
+// store; an empty path keeps them in memory only.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, caps: make(map[string]Redeemed)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Redeemed
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, r := range list {
+		s.caps[r.CID] = r
+	}
+	return s, nil
+}
+
+// Put keeps c, replacing any capability redeemed earlier for its file.
+func (s *Store) Put(c *Capability) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.caps[c.CID] = Redeemed{Capability: *c, Redeemed: time.Now().UTC()}
+	return s.save()
+}
+
+// Get returns the capability redeemed for the file with the given hash.
+func (s *Store) Get(cid string) (Redeemed, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.caps[cid]
+	return r, ok
+}
+
+// List returns the redeemed capabilities, oldest first.
+func (s *Store) List() []Redeemed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+func (s *Store) sorted() []Redeemed {
+	list := make([]Redeemed, 0, len(s.caps))
+	for _, r := range s.caps {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Redeemed.Before(list[j].Redeemed) })
+	return list
+}
+
+// save writes the store atomically. Callers hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(s.path, data, 0600)
+}
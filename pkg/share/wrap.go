@@ -0,0 +1,148 @@
+package share
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"slices"
+
+	"filippo.io/edwards25519"
+	"github.com/Noah-Wilderom/dfs/pkg/crypt"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// wrapInfo labels the key derived from the exchange.
+const wrapInfo = "dfs share key"
+
+// errKeyType is returned for a node key other than Ed25519, the kind
+// nodes generate, which has no X25519 counterpart.
+var errKeyType = errors.New("share: only Ed25519 node keys can receive shares")
+
+// wrap seals key to the node key of to and returns the ephemeral public
+// key the recipient needs to unwrap it.
+func wrap(to peer.ID, key *crypt.FileKey, aad []byte) (ephemeral, wrapped []byte, err error) {
+	return SealKey(to, key.Encode(), aad)
+}
+
+// unwrap opens a key wrap sealed to priv.
+func unwrap(priv crypto.PrivKey, ephemeral, wrapped, aad []byte) (*crypt.FileKey, error) {
+	secret, err := OpenKey(priv, ephemeral, wrapped, aad)
+	if err != nil {
+		return nil, err
+	}
+	return crypt.DecodeFileKey(secret)
+}
+
+// SealKey seals secret, a key, to the node key of to, bound to aad, and
+// returns the ephemeral public key the recipient needs to open it with
+// OpenKey.
+func SealKey(to peer.ID, secret, aad []byte) (ephemeral, sealed []byte, err error) {
+	pub, err := to.ExtractPublicKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("share: recipient %s: %w", to, err)
+	}
+	recipient, err := x25519Public(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := eph.ECDH(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := wrapKey(shared, eph.PublicKey(), recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(secret)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return eph.PublicKey().Bytes(), aead.Seal(nonce, nonce, secret, aad), nil
+}
+
+// OpenKey opens a key sealed to priv by SealKey.
+func OpenKey(priv crypto.PrivKey, ephemeral, sealed, aad []byte) ([]byte, error) {
+	self, err := x25519Private(priv)
+	if err != nil {
+		return nil, err
+	}
+	eph, err := ecdh.X25519().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("share: ephemeral key: %w", err)
+	}
+	shared, err := self.ECDH(eph)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := wrapKey(shared, eph, self.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, crypt.ErrDecrypt
+	}
+	secret, err := aead.Open(nil, sealed[:n], sealed[n:], aad)
+	if err != nil {
+		return nil, crypt.ErrDecrypt
+	}
+	return secret, nil
+}
+
+// wrapKey derives the key wrapping a file key from the secret shared by
+// the ephemeral key and the recipient's, bound to both public keys.
+func wrapKey(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	info := slices.Concat([]byte(wrapInfo), ephemeral.Bytes(), recipient.Bytes())
+	key, err := hkdf.Key(sha256.New, shared, nil, string(info), chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// x25519Public converts an Ed25519 public key to the X25519 key of the
+// same secret, the Montgomery form of the point.
+func x25519Public(pub crypto.PubKey) (*ecdh.PublicKey, error) {
+	if pub.Type() != pb.KeyType_Ed25519 {
+		return nil, errKeyType
+	}
+	raw, err := pub.Raw()
+	if err != nil {
+		return nil, err
+	}
+	point, err := new(edwards25519.Point).SetBytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("share: invalid Ed25519 public key: %w", err)
+	}
+	return ecdh.X25519().NewPublicKey(point.BytesMontgomery())
+}
+
+// x25519Private converts an Ed25519 private key to the X25519 key of the
+// same secret: the scalar Ed25519 derives from the seed, which X25519
+// clamps the same way.
+func x25519Private(priv crypto.PrivKey) (*ecdh.PrivateKey, error) {
+	if priv.Type() != pb.KeyType_Ed25519 {
+		return nil, errKeyType
+	}
+	raw, err := priv.Raw()
+	if err != nil {
+		return nil, err
+	}
+	// The seed, followed by the public key
+	h := sha512.Sum512(raw[:ed25519.SeedSize])
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
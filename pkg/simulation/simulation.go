@@ -0,0 +1,280 @@
+package simulation
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"go.uber.org/zap"
+)
+
+// Profile describes the shape of a virtual link.
+//
+// The in-memory transport is reliable, so Loss is modelled the way a
+// reliable transport experiences it: a lost write shows up as a
+// retransmission delay on top of the link latency.
+type Profile struct {
+	Latency   time.Duration
+	Jitter    time.Duration
+	Loss      float64
+	Bandwidth float64 // bytes per second, 0 is unlimited
+}
+
+var Profiles = map[string]Profile{
+	"lan":       {Latency: time.Millisecond, Jitter: 200 * time.Microsecond},
+	"wan":       {Latency: 40 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.005, Bandwidth: 12_500_000},
+	"broadband": {Latency: 25 * time.Millisecond, Jitter: 5 * time.Millisecond, Loss: 0.001, Bandwidth: 2_500_000},
+	"mobile":    {Latency: 120 * time.Millisecond, Jitter: 60 * time.Millisecond, Loss: 0.02, Bandwidth: 500_000},
+}
+
+type Options struct {
+	Nodes     int
+	Profile   Profile
+	EnableDHT bool
+	// Blocks returns the block source node i serves blocks from, nil for
+	// none. Optional.
+	Blocks func(i int) network.BlockSource
+	// Seed makes jitter and loss reproducible. Zero uses 1.
+	Seed int64
+	// Tick is how often link latencies are resampled. Defaults to 50ms.
+	Tick   time.Duration
+	Logger *zap.Logger
+}
+
+// Simulation runs many nodes in-process, connected through shaped links of
+// an in-memory libp2p network.
+type Simulation struct {
+	Nodes []*network.P2PNetworking
+
+	mn     mocknet.Mocknet
+	logger *zap.Logger
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	profile  Profile
+	profiles map[[2]peer.ID]Profile
+}
+
+func New(ctx context.Context, opts Options) (*Simulation, error) {
+	if opts.Nodes <= 0 {
+		return nil, fmt.Errorf("simulation: need at least one node")
+	}
+	if opts.Seed == 0 {
+		opts.Seed = 1
+	}
+	if opts.Tick == 0 {
+		opts.Tick = 50 * time.Millisecond
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Simulation{
+		mn:       mocknet.New(),
+		logger:   opts.Logger,
+		cancel:   cancel,
+		rng:      rand.New(rand.NewSource(opts.Seed)),
+		profile:  opts.Profile,
+		profiles: make(map[[2]peer.ID]Profile),
+	}
+
+	for i := 0; i < opts.Nodes; i++ {
+		h, err := s.mn.GenPeer()
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		var blocks network.BlockSource
+		if opts.Blocks != nil {
+			blocks = opts.Blocks(i)
+		}
+		node := network.NewP2PNetworking(network.P2PNetworkingOpts{
+			EnableDHT:  opts.EnableDHT,
+			Blocks:     blocks,
+			Logger:     opts.Logger.Named(fmt.Sprintf("node-%d", i)),
+			CustomHost: h,
+		})
+		if err := node.Start(ctx); err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.Nodes = append(s.Nodes, node)
+	}
+
+	if err := s.mn.LinkAll(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.reshape()
+
+	s.wg.Add(1)
+	go s.shape(ctx, opts.Tick)
+
+	return s, nil
+}
+
+// ConnectAll dials every node from every other node.
+func (s *Simulation) ConnectAll() error {
+	return s.mn.ConnectAllButSelf()
+}
+
+func (s *Simulation) Connect(a, b int) error {
+	_, err := s.mn.ConnectPeers(s.id(a), s.id(b))
+	return err
+}
+
+// SetLinkProfile overrides the profile of the link between nodes a and b.
+func (s *Simulation) SetLinkProfile(a, b int, p Profile) {
+	s.mu.Lock()
+	s.profiles[linkKey(s.id(a), s.id(b))] = p
+	s.mu.Unlock()
+	s.reshape()
+}
+
+// Partition splits the nodes into the given groups of node indexes. Links
+// between groups are removed and open connections across them are closed.
+// Nodes not listed in any group form a group of their own.
+func (s *Simulation) Partition(groups ...[]int) error {
+	group := make(map[peer.ID]int)
+	for g, members := range groups {
+		for _, i := range members {
+			group[s.id(i)] = g + 1
+		}
+	}
+
+	for i := range s.Nodes {
+		for j := i + 1; j < len(s.Nodes); j++ {
+			a, b := s.id(i), s.id(j)
+			if group[a] == group[b] {
+				continue
+			}
+			if len(s.mn.LinksBetweenPeers(a, b)) == 0 {
+				continue
+			}
+			if err := s.mn.UnlinkPeers(a, b); err != nil {
+				return err
+			}
+			// Closing a connection reaches the other end asynchronously,
+			// so close it from both ends before returning
+			if err := s.mn.DisconnectPeers(a, b); err != nil {
+				return err
+			}
+			if err := s.mn.DisconnectPeers(b, a); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.logger.Info("Network partitioned", zap.Int("groups", len(groups)))
+	return nil
+}
+
+// Heal restores links between all nodes. Connections are not re-dialed.
+func (s *Simulation) Heal() error {
+	for i := range s.Nodes {
+		for j := i + 1; j < len(s.Nodes); j++ {
+			a, b := s.id(i), s.id(j)
+			if len(s.mn.LinksBetweenPeers(a, b)) > 0 {
+				continue
+			}
+			if _, err := s.mn.LinkPeers(a, b); err != nil {
+				return err
+			}
+		}
+	}
+	s.reshape()
+
+	s.logger.Info("Network healed")
+	return nil
+}
+
+func (s *Simulation) Close() error {
+	s.cancel()
+	s.wg.Wait()
+
+	for _, node := range s.Nodes {
+		node.Close()
+	}
+	return s.mn.Close()
+}
+
+func (s *Simulation) id(i int) peer.ID {
+	return s.Nodes[i].Host().ID()
+}
+
+func (s *Simulation) shape(ctx context.Context, tick time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reshape()
+		}
+	}
+}
+
+// reshape resamples the latency of every link from its profile. Links are
+// drawn for in the order of their nodes, not that of the link map or of
+// the peer IDs, which are random, so a seed gives every run the same
+// shapes.
+func (s *Simulation) reshape() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Nodes {
+		for j := i + 1; j < len(s.Nodes); j++ {
+			a, b := s.id(i), s.id(j)
+			links := s.mn.LinksBetweenPeers(a, b)
+			if len(links) == 0 {
+				continue
+			}
+			p, ok := s.profiles[linkKey(a, b)]
+			if !ok {
+				p = s.profile
+			}
+			opts := mocknet.LinkOptions{
+				Latency:   s.sample(p),
+				Bandwidth: p.Bandwidth,
+			}
+			for _, link := range links {
+				link.SetOptions(opts)
+			}
+		}
+	}
+}
+
+func (s *Simulation) sample(p Profile) time.Duration {
+	latency := p.Latency
+	if p.Jitter > 0 {
+		latency += time.Duration((s.rng.Float64()*2 - 1) * float64(p.Jitter))
+	}
+	if p.Loss > 0 && s.rng.Float64() < p.Loss {
+		// A lost segment costs roughly one retransmission timeout.
+		latency += 3*p.Latency + 200*time.Millisecond
+	}
+	if latency < 0 {
+		latency = 0
+	}
+	return latency
+}
+
+func linkKey(a, b peer.ID) [2]peer.ID {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]peer.ID{a, b}
+}
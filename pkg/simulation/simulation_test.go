@@ -0,0 +1,167 @@
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/network"
+	"github.com/Noah-Wilderom/dfs/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// seeded starts a simulation whose first node holds one block of size
+// bytes.
+func seeded(t *testing.T, nodes, size int, opts Options) (*Simulation, cid.Cid, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	block, err := storage.NewRawBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewMemBlockstore()
+	if err := store.Put(context.Background(), block.CID, block.Data); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Nodes = nodes
+	opts.Blocks = func(i int) network.BlockSource {
+		if i == 0 {
+			return store
+		}
+		return nil
+	}
+	s, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, block.CID, data
+}
+
+func fetch(s *Simulation, from, to int, c cid.Cid) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	data, err := s.Nodes[from].FetchBlock(ctx, peer.AddrInfo{ID: s.id(to)}, c)
+	return data, time.Since(start), err
+}
+
+func TestPartitionHeal(t *testing.T) {
+	s, c, data := seeded(t, 3, 1024, Options{Profile: Profiles["lan"]})
+	if err := s.ConnectAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fetch(s, 1, 0, c); err != nil {
+		t.Fatalf("fetch before the partition: %v", err)
+	}
+
+	if err := s.Partition([]int{0}, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fetch(s, 1, 0, c); err == nil {
+		t.Fatal("fetched across the partition")
+	}
+	if err := s.Connect(2, 0); err == nil {
+		t.Fatal("connected across the partition")
+	}
+	// Within a side the network still works
+	if err := s.Connect(1, 2); err != nil {
+		t.Fatalf("connect within a side: %v", err)
+	}
+
+	if err := s.Heal(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Connect(2, 0); err != nil {
+		t.Fatalf("connect after healing: %v", err)
+	}
+	got, _, err := fetch(s, 2, 0, c)
+	if err != nil {
+		t.Fatalf("fetch after healing: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("fetched block differs")
+	}
+}
+
+func TestShapedLinkFetch(t *testing.T) {
+	const size = 256 << 10
+	slow := Profile{Latency: 50 * time.Millisecond, Bandwidth: 1 << 20}
+	s, c, data := seeded(t, 3, size, Options{Profile: Profiles["lan"]})
+	s.SetLinkProfile(0, 1, slow)
+	if err := s.ConnectAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, slowTook, err := fetch(s, 1, 0, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("fetched block differs")
+	}
+	_, fastTook, err := fetch(s, 2, 0, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A request and a response cross the link: two latencies, plus the
+	// block at the link's bandwidth
+	want := 2*slow.Latency + time.Duration(float64(size)/slow.Bandwidth*float64(time.Second))
+	if slowTook < want*3/4 {
+		t.Errorf("fetch over the shaped link took %v, want about %v", slowTook, want)
+	}
+	if fastTook >= slowTook {
+		t.Errorf("fetch over a lan link took %v, no faster than the shaped link's %v", fastTook, slowTook)
+	}
+	t.Logf("shaped link %v, lan link %v", slowTook, fastTook)
+}
+
+// linkLatencies returns the latency of the link between every pair of
+// nodes, by node index.
+func linkLatencies(s *Simulation) map[[2]int]time.Duration {
+	latencies := make(map[[2]int]time.Duration)
+	for i := range s.Nodes {
+		for j := i + 1; j < len(s.Nodes); j++ {
+			for _, link := range s.mn.LinksBetweenPeers(s.id(i), s.id(j)) {
+				latencies[[2]int{i, j}] = link.Options().Latency
+			}
+		}
+	}
+	return latencies
+}
+
+// The same seed shapes every link the same way in every run.
+func TestSeedReproducible(t *testing.T) {
+	run := func() []map[[2]int]time.Duration {
+		s, err := New(context.Background(), Options{Nodes: 5, Profile: Profiles["mobile"], Seed: 7, Tick: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		var rounds []map[[2]int]time.Duration
+		for range 3 {
+			rounds = append(rounds, linkLatencies(s))
+			s.reshape()
+		}
+		return rounds
+	}
+
+	a, b := run(), run()
+	for round := range a {
+		if len(a[round]) != 10 {
+			t.Fatalf("round %d shaped %d links, want 10", round, len(a[round]))
+		}
+		for link, latency := range a[round] {
+			if b[round][link] != latency {
+				t.Errorf("round %d: link %v latency %v, then %v", round, link, latency, b[round][link])
+			}
+		}
+	}
+}
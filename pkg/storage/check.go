@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/ipfs/go-cid"
+)
+
+const corruptExt = ".corrupt"
+
+type CheckReport struct {
+	Blocks  int64
+	Bytes   int64
+	Corrupt []cid.Cid
+}
+
+// Check re-reads every block, verifies it against its CID and rebuilds the
+// size accounting from what it found. With repair set, corrupt blocks are
+// set aside with a .corrupt suffix so they are no longer served.
+func (s *FSBlockstore) Check(ctx context.Context, repair bool) (CheckReport, error) {
+	var report CheckReport
+	if repair && s.readOnly {
+		return report, ErrReadOnly
+	}
+
+	err := s.List(ctx, func(c cid.Cid) error {
+		data, err := s.Get(ctx, c)
+		if err != nil && !errors.Is(err, errDecompress) {
+			return err
+		}
+
+		if err != nil || Verify(c, data) != nil {
+			report.Corrupt = append(report.Corrupt, c)
+			if repair {
+				path, _, err := s.locate(c)
+				if err != nil {
+					return err
+				}
+				return os.Rename(path, path+corruptExt)
+			}
+			return nil
+		}
+
+		size, err := s.Size(ctx, c)
+		if err != nil {
+			return err
+		}
+		report.Blocks++
+		report.Bytes += size
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if repair {
+		s.mu.Lock()
+		s.stats = Stats{Blocks: report.Blocks, Bytes: report.Bytes}
+		links, codecs := s.links, s.linkCodecs
+		s.mu.Unlock()
+
+		// Blocks set aside no longer refer to anything
+		if links != nil && len(report.Corrupt) > 0 {
+			if err := s.CountRefs(ctx, links, slices.Collect(maps.Keys(codecs))...); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
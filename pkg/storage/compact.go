@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type CompactOptions struct {
+	// TempAge is how old a leftover temp file must be before it is removed,
+	// so writes in flight from a running daemon are never touched. Defaults
+	// to one hour.
+	TempAge time.Duration
+	// OpsPerSecond throttles filesystem changes. Zero is unthrottled.
+	OpsPerSecond int
+	// DryRun reports what would change without changing anything.
+	DryRun bool
+}
+
+type CompactReport struct {
+	Before, After      DiskUsage
+	TempFilesRemoved   int
+	TempBytesRemoved   int64
+	EmptyShardsRemoved int
+	BlocksRelocated    int
+}
+
+// DiskUsage is the space taken by the store. Allocated can be larger than
+// Apparent because of filesystem block rounding.
+type DiskUsage struct {
+	Files     int64
+	Dirs      int64
+	Apparent  int64
+	Allocated int64
+}
+
+// Compact reclaims space left behind by crashes and heavy deletion: it
+// removes stale temp files and empty shard directories and moves blocks
+// that ended up in the wrong shard. It is safe to run while the store is in
+// use by another process.
+func (s *FSBlockstore) Compact(ctx context.Context, opts CompactOptions) (CompactReport, error) {
+	if s.readOnly && !opts.DryRun {
+		return CompactReport{}, ErrReadOnly
+	}
+	if opts.TempAge == 0 {
+		opts.TempAge = time.Hour
+	}
+
+	var (
+		report CompactReport
+		err    error
+	)
+
+	if report.Before, err = s.DiskUsage(); err != nil {
+		return report, err
+	}
+
+	throttle := newThrottle(opts.OpsPerSecond)
+	defer throttle.stop()
+
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return report, err
+	}
+
+	cutoff := time.Now().Add(-opts.TempAge)
+	for _, dir := range entries {
+		if !dir.IsDir() {
+			continue
+		}
+		shardPath := filepath.Join(s.root, dir.Name())
+
+		files, err := os.ReadDir(shardPath)
+		if err != nil {
+			return report, err
+		}
+
+		remaining := len(files)
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+
+			info, err := f.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				remaining--
+				continue
+			}
+			if err != nil {
+				return report, err
+			}
+
+			switch {
+			case strings.HasSuffix(f.Name(), tempExt):
+				if info.ModTime().After(cutoff) {
+					continue
+				}
+				if err := throttle.wait(ctx); err != nil {
+					return report, err
+				}
+				if !opts.DryRun {
+					if err := os.Remove(filepath.Join(shardPath, f.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+						return report, err
+					}
+				}
+				report.TempFilesRemoved++
+				report.TempBytesRemoved += info.Size()
+				remaining--
+
+			case strings.HasSuffix(f.Name(), blockExt) || strings.HasSuffix(f.Name(), compressedExt):
+				_, want, ok, err := s.parseBlockName(f.Name())
+				if err != nil || !ok {
+					continue
+				}
+				if filepath.Dir(want) == shardPath {
+					continue
+				}
+				if err := throttle.wait(ctx); err != nil {
+					return report, err
+				}
+				if !opts.DryRun {
+					if err := s.relocate(filepath.Join(shardPath, f.Name()), want); err != nil {
+						return report, err
+					}
+				}
+				report.BlocksRelocated++
+				remaining--
+			}
+		}
+
+		if remaining == 0 {
+			if err := throttle.wait(ctx); err != nil {
+				return report, err
+			}
+			if !opts.DryRun {
+				// Remove fails if a concurrent Put just created a file here,
+				// which is fine: the shard is in use again.
+				if err := os.Remove(shardPath); err != nil {
+					continue
+				}
+			}
+			report.EmptyShardsRemoved++
+		}
+	}
+
+	if opts.DryRun {
+		report.After = report.Before
+		report.After.Files -= int64(report.TempFilesRemoved)
+		report.After.Dirs -= int64(report.EmptyShardsRemoved)
+		report.After.Apparent -= report.TempBytesRemoved
+		report.After.Allocated -= report.TempBytesRemoved
+		return report, nil
+	}
+
+	report.After, err = s.DiskUsage()
+	return report, err
+}
+
+func (s *FSBlockstore) relocate(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(from, to); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// DiskUsage walks the store and sums the space it takes.
+func (s *FSBlockstore) DiskUsage() (DiskUsage, error) {
+	var du DiskUsage
+
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			du.Dirs++
+		} else {
+			du.Files++
+			du.Apparent += info.Size()
+		}
+		du.Allocated += allocated(info)
+		return nil
+	})
+
+	return du, err
+}
+
+type throttle struct {
+	ticker *time.Ticker
+}
+
+func newThrottle(opsPerSecond int) *throttle {
+	if opsPerSecond <= 0 {
+		return &throttle{}
+	}
+	return &throttle{ticker: time.NewTicker(time.Second / time.Duration(opsPerSecond))}
+}
+
+func (t *throttle) wait(ctx context.Context) error {
+	if t.ticker == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.ticker.C:
+		return nil
+	}
+}
+
+func (t *throttle) stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
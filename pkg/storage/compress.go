@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/Noah-Wilderom/dfs/pkg/metrics"
+	"github.com/ipfs/go-cid"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs for stored blocks. Blocks are addressed and served
+// by their uncompressed bytes either way; only how FSBlockstore keeps them
+// on disk differs.
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+)
+
+const (
+	// Blocks smaller than minCompressSize aren't worth the frame overhead.
+	minCompressSize = 512
+	// Compressible samples up to entropySample bytes, in pieces of
+	// entropyPiece spread over the block.
+	entropySample = 64 << 10
+	entropyPiece  = 4 << 10
+	// maxEntropy is the byte entropy, in bits per byte, above which data
+	// is taken to be compressed or encrypted already. Random data is
+	// close to 8.
+	maxEntropy = 7.5
+)
+
+// errDecompress marks a compressed block that doesn't decompress.
+var errDecompress = errors.New("storage: block does not decompress")
+
+// ValidCompression checks that codec is known. Empty is the same as
+// CompressionNone.
+func ValidCompression(codec string) error {
+	switch codec {
+	case "", CompressionNone, CompressionZstd:
+		return nil
+	default:
+		return fmt.Errorf("storage: unknown compression %q", codec)
+	}
+}
+
+type compressionKey struct{}
+
+// WithCompression asks FSBlockstore to compress the blocks put with ctx
+// using codec, when they look compressible and that makes them smaller.
+// Other stores ignore it.
+func WithCompression(ctx context.Context, codec string) context.Context {
+	return context.WithValue(ctx, compressionKey{}, codec)
+}
+
+func compressionFrom(ctx context.Context) string {
+	codec, _ := ctx.Value(compressionKey{}).(string)
+	return codec
+}
+
+// Compressible guesses whether data would compress, from the entropy of a
+// sample of its bytes. It is cheap next to compressing, and deterministic,
+// so the same chunk is always judged the same.
+func Compressible(data []byte) bool {
+	if len(data) < minCompressSize {
+		return false
+	}
+
+	var counts [256]int
+	n := 0
+	step := max(entropyPiece, len(data)/(entropySample/entropyPiece))
+	for off := 0; off < len(data); off += step {
+		for _, b := range data[off:min(off+entropyPiece, len(data))] {
+			counts[b]++
+		}
+		n += min(entropyPiece, len(data)-off)
+	}
+
+	var bits float64
+	for _, k := range counts {
+		if k == 0 {
+			continue
+		}
+		p := float64(k) / float64(n)
+		// The conversion keeps the product from being fused into the
+		// subtraction, which would round differently on some platforms.
+		bits -= float64(p * math.Log2(p))
+	}
+	return bits < maxEntropy
+}
+
+var (
+	encoder = sync.OnceValue(func() *zstd.Encoder {
+		e, _ := zstd.NewWriter(nil)
+		return e
+	})
+	decoder = sync.OnceValue(func() *zstd.Decoder {
+		d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+		return d
+	})
+)
+
+// compress returns data compressed with codec, or nil when data looks
+// incompressible or compressing doesn't make it smaller.
+func compress(codec string, data []byte) []byte {
+	if codec != CompressionZstd || !Compressible(data) {
+		return nil
+	}
+	z := encoder().EncodeAll(data, make([]byte, 0, len(data)))
+	if len(z) >= len(data) {
+		return nil
+	}
+	metrics.CompressionRatio.Observe(float64(len(z)) / float64(len(data)))
+	metrics.CompressionSavedBytes.Add(float64(len(data) - len(z)))
+	return z
+}
+
+// Compression reports the codec block c is kept with on disk, empty when
+// it is kept as it is. Only this store knows: other nodes may keep the
+// same block differently, and manifests don't record it.
+func (s *FSBlockstore) Compression(ctx context.Context, c cid.Cid) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	path, _, err := s.locate(c)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(path, compressedExt) {
+		return CompressionZstd, nil
+	}
+	return "", nil
+}
+
+func decompress(z []byte) ([]byte, error) {
+	data, err := decoder().DecodeAll(z, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errDecompress, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package storage
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func allocated(info fs.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512
+	}
+	return info.Size()
+}
@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	blockExt = ".data"
+	// compressedExt holds blocks compressed with zstd.
+	compressedExt = ".zst"
+	tempExt       = ".tmp"
+)
+
+// FSBlockstore keeps every block in its own file, sharded into directories
+// by the two characters before the last character of the CID. CIDs share
+// their leading characters, so this spreads blocks evenly like flatfs does.
+// Blocks put with WithCompression are kept compressed, and decompressed
+// when read.
+type FSBlockstore struct {
+	root     string
+	readOnly bool
+
+	mu    sync.Mutex
+	stats Stats
+	// refs counts the stored blocks referring to each block, see CountRefs.
+	refs       map[cid.Cid]int
+	links      LinkFunc
+	linkCodecs map[uint64]bool
+}
+
+var _ Blockstore = (*FSBlockstore)(nil)
+
+// Open opens or creates a block store rooted at path. Size accounting is
+// rebuilt by scanning the store.
+func Open(path string) (*FSBlockstore, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &FSBlockstore{root: path}
+	if err := s.scan(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenReadOnly opens an existing block store without ever writing to it.
+// Put and Delete return ErrReadOnly.
+func OpenReadOnly(path string) (*FSBlockstore, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("storage: %s is not a directory", path)
+	}
+
+	s := &FSBlockstore{root: path, readOnly: true}
+	if err := s.scan(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FSBlockstore) Root() string {
+	return s.root
+}
+
+func (s *FSBlockstore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := Verify(c, data); err != nil {
+		return err
+	}
+
+	// Content addressed: an existing block already holds these bytes.
+	if err := s.Touch(ctx, c); err == nil {
+		return nil
+	}
+
+	path, stored := s.path(c), data
+	if z := compress(compressionFrom(ctx), data); z != nil {
+		path, stored = s.compressedPath(c), z
+	}
+
+	tmp, err := createTemp(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(stored); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Link instead of rename so that concurrent writers of the same block
+	// don't both count it.
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil
+		}
+		return err
+	}
+
+	linked := s.linksOf(ctx, c, data)
+	s.mu.Lock()
+	s.stats.Blocks++
+	s.stats.Bytes += int64(len(stored))
+	s.addRefs(linked)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *FSBlockstore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.path(c))
+	if !errors.Is(err, fs.ErrNotExist) {
+		return data, err
+	}
+	z, err := os.ReadFile(s.compressedPath(c))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decompress(z)
+}
+
+func (s *FSBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	_, err := s.Size(ctx, c)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Size is the size of the block on disk, compressed if it is.
+func (s *FSBlockstore) Size(ctx context.Context, c cid.Cid) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	_, info, err := s.locate(c)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// locate finds the file holding c.
+func (s *FSBlockstore) locate(c cid.Cid) (string, fs.FileInfo, error) {
+	for _, path := range []string{s.path(c), s.compressedPath(c)} {
+		info, err := os.Stat(path)
+		if err == nil {
+			return path, info, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", nil, err
+		}
+	}
+	return "", nil, ErrNotFound
+}
+
+// Touch bumps the write time of a stored block, so GC treats it as just
+// written. A collection deleting it at the same time either sees the new
+// time or has removed it already, when Touch returns ErrNotFound.
+func (s *FSBlockstore) Touch(ctx context.Context, c cid.Cid) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, _, err := s.locate(c)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *FSBlockstore) Delete(ctx context.Context, c cid.Cid) error {
+	return s.delete(ctx, c, time.Time{})
+}
+
+// DeleteOlder deletes c unless it was written or touched after cutoff,
+// when it returns ErrRecent. Checking and deleting happen at once, so a
+// block touched meanwhile is kept.
+func (s *FSBlockstore) DeleteOlder(ctx context.Context, c cid.Cid, cutoff time.Time) error {
+	return s.delete(ctx, c, cutoff)
+}
+
+// delete removes c, unless a zero cutoff is given, only when it wasn't
+// written after cutoff.
+func (s *FSBlockstore) delete(ctx context.Context, c cid.Cid, cutoff time.Time) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	if _, err := s.Size(ctx, c); err != nil {
+		return err
+	}
+	linked := s.linksOf(ctx, c, nil)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs[c] > 0 {
+		return ErrReferenced
+	}
+	if !cutoff.IsZero() {
+		if _, info, err := s.locate(c); err == nil && info.ModTime().After(cutoff) {
+			return ErrRecent
+		}
+	}
+	// Writers asking for different compression at once may both have
+	// stored the block
+	removed := false
+	for _, path := range []string{s.path(c), s.compressedPath(c)} {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		s.stats.Blocks--
+		s.stats.Bytes -= info.Size()
+		removed = true
+	}
+	if !removed {
+		return ErrNotFound
+	}
+	s.dropRefs(linked)
+	return nil
+}
+
+func (s *FSBlockstore) List(ctx context.Context, fn func(cid.Cid) error) error {
+	return s.walk(ctx, func(c cid.Cid, _ fs.FileInfo) error {
+		return fn(c)
+	})
+}
+
+// BlockInfo describes a stored block.
+type BlockInfo struct {
+	CID  cid.Cid
+	Size int64
+	// ModTime is when the block was last written.
+	ModTime time.Time
+}
+
+// ListInfo is List with the size and write time of every block.
+func (s *FSBlockstore) ListInfo(ctx context.Context, fn func(BlockInfo) error) error {
+	return s.walk(ctx, func(c cid.Cid, info fs.FileInfo) error {
+		return fn(BlockInfo{CID: c, Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+func (s *FSBlockstore) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *FSBlockstore) ReadOnly() bool {
+	return s.readOnly
+}
+
+func (s *FSBlockstore) Close() error {
+	return nil
+}
+
+func (s *FSBlockstore) path(c cid.Cid) string {
+	key := c.String()
+	return filepath.Join(s.root, shard(key), key+blockExt)
+}
+
+func (s *FSBlockstore) compressedPath(c cid.Cid) string {
+	key := c.String()
+	return filepath.Join(s.root, shard(key), key+compressedExt)
+}
+
+// parseBlockName returns the CID of the block in the file name and the
+// path it belongs at, ok false for files that aren't blocks.
+func (s *FSBlockstore) parseBlockName(name string) (c cid.Cid, want string, ok bool, err error) {
+	key, compressed := strings.CutSuffix(name, compressedExt)
+	if !compressed {
+		if key, ok = strings.CutSuffix(name, blockExt); !ok {
+			return cid.Undef, "", false, nil
+		}
+	}
+	if c, err = cid.Decode(key); err != nil {
+		return cid.Undef, "", false, err
+	}
+	if compressed {
+		return c, s.compressedPath(c), true, nil
+	}
+	return c, s.path(c), true, nil
+}
+
+// createTemp creates a temp file in the shard dir. Compaction may remove an
+// empty shard between MkdirAll and CreateTemp, so that case is retried.
+func createTemp(dir string) (*os.File, error) {
+	for attempt := 0; ; attempt++ {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+
+		f, err := os.CreateTemp(dir, "put-*"+tempExt)
+		if errors.Is(err, fs.ErrNotExist) && attempt < 3 {
+			continue
+		}
+		return f, err
+	}
+}
+
+func shard(key string) string {
+	if len(key) < 3 {
+		return "_"
+	}
+	return key[len(key)-3 : len(key)-1]
+}
+
+func (s *FSBlockstore) scan() error {
+	var stats Stats
+	err := s.walk(context.Background(), func(_ cid.Cid, info fs.FileInfo) error {
+		stats.Blocks++
+		stats.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.stats = stats
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *FSBlockstore) walk(ctx context.Context, fn func(cid.Cid, fs.FileInfo) error) error {
+	return filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		c, _, ok, err := s.parseBlockName(d.Name())
+		if err != nil {
+			return fmt.Errorf("storage: unexpected file %s: %w", path, err)
+		}
+		if !ok {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(c, info)
+	})
+}
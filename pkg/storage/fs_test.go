@@ -0,0 +1,415 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+func openStore(t *testing.T) *FSBlockstore {
+	t.Helper()
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func mustBlock(t *testing.T, data string) Block {
+	t.Helper()
+	b, err := NewRawBlock([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func mustPut(t *testing.T, s Blockstore, b Block) {
+	t.Helper()
+	if err := s.Put(context.Background(), b.CID, b.Data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// files lists the files under the store root with the given extension.
+func files(t *testing.T, s *FSBlockstore, ext string) []string {
+	t.Helper()
+	var found []string
+	err := filepath.WalkDir(s.Root(), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ext) {
+			found = append(found, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestPutGet(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	b := mustBlock(t, "hello")
+	mustPut(t, s, b)
+
+	data, err := s.Get(ctx, b.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, b.Data) {
+		t.Errorf("Get = %q, want %q", data, b.Data)
+	}
+	if has, _ := s.Has(ctx, b.CID); !has {
+		t.Error("Has = false after Put")
+	}
+	if got, want := s.Stats(), (Stats{Blocks: 1, Bytes: 5}); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+
+	if _, err := s.Get(ctx, mustBlock(t, "missing").CID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing block: %v, want ErrNotFound", err)
+	}
+}
+
+// Put checks data against its CID before anything is written.
+func TestPutVerifies(t *testing.T) {
+	s := openStore(t)
+	b := mustBlock(t, "hello")
+	if err := s.Put(context.Background(), b.CID, []byte("tampered")); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("Put of mismatching data: %v, want ErrHashMismatch", err)
+	}
+	if got := s.Stats(); got != (Stats{}) {
+		t.Errorf("Stats = %+v after a rejected Put", got)
+	}
+	if found := files(t, s, ""); len(found) != 0 {
+		t.Errorf("rejected Put left %v", found)
+	}
+}
+
+// Putting a block again only refreshes its write time, so GC treats it as
+// new without it being counted twice.
+func TestPutExistingTouches(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	b := mustBlock(t, "hello")
+	mustPut(t, s, b)
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(s.path(b.CID), old, old); err != nil {
+		t.Fatal(err)
+	}
+	mustPut(t, s, b)
+
+	info, err := os.Stat(s.path(b.CID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().After(old.Add(time.Minute)) {
+		t.Errorf("mtime = %v, want it refreshed", info.ModTime())
+	}
+	if got, want := s.Stats(), (Stats{Blocks: 1, Bytes: 5}); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	data, err := s.Get(ctx, b.CID)
+	if err != nil || !bytes.Equal(data, b.Data) {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+}
+
+// Concurrent writers of the same block link the same file into place, so
+// it is stored and counted once and no temp file is left.
+func TestConcurrentPut(t *testing.T) {
+	s := openStore(t)
+	b := mustBlock(t, strings.Repeat("block ", 1000))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Put(context.Background(), b.CID, b.Data)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := s.Stats(), (Stats{Blocks: 1, Bytes: int64(len(b.Data))}); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	if tmp := files(t, s, tempExt); len(tmp) != 0 {
+		t.Errorf("temp files left: %v", tmp)
+	}
+}
+
+// A file already in place is never replaced, not even by a writer that
+// would keep the block differently.
+func TestPutLinksNotRenames(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	b := mustBlock(t, strings.Repeat("block ", 1000))
+	mustPut(t, s, b)
+
+	before, err := os.Stat(s.path(b.CID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(WithCompression(ctx, CompressionZstd), b.CID, b.Data); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(s.path(b.CID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("block file was replaced")
+	}
+	if z := files(t, s, compressedExt); len(z) != 0 {
+		t.Errorf("stored a second copy: %v", z)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	b := mustBlock(t, "hello")
+	mustPut(t, s, b)
+
+	if err := s.Delete(ctx, b.CID); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.Has(ctx, b.CID); has {
+		t.Error("Has = true after Delete")
+	}
+	if got := s.Stats(); got != (Stats{}) {
+		t.Errorf("Stats = %+v after Delete", got)
+	}
+	if err := s.Delete(ctx, b.CID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete: %v, want ErrNotFound", err)
+	}
+}
+
+// A block touched after the cutoff is kept by DeleteOlder, one left alone
+// since is deleted.
+func TestDeleteOlder(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	b := mustBlock(t, "hello")
+	mustPut(t, s, b)
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(s.path(b.CID), old, old); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now().Add(-time.Minute)
+	if err := s.Touch(ctx, b.CID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteOlder(ctx, b.CID, cutoff); !errors.Is(err, ErrRecent) {
+		t.Fatalf("DeleteOlder of a touched block: %v, want ErrRecent", err)
+	}
+	if has, _ := s.Has(ctx, b.CID); !has {
+		t.Fatal("refused DeleteOlder removed the block")
+	}
+
+	if err := s.DeleteOlder(ctx, b.CID, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Touch(ctx, b.CID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Touch of a deleted block: %v, want ErrNotFound", err)
+	}
+}
+
+// linkCodec marks test blocks that refer to others: their data is the
+// CIDs they link to, one per line.
+const linkCodec = cid.DagCBOR
+
+func mustParent(t *testing.T, children ...Block) Block {
+	t.Helper()
+	var lines []string
+	for _, c := range children {
+		lines = append(lines, c.CID.String())
+	}
+	data := []byte(strings.Join(lines, "\n"))
+	c, err := Sum(linkCodec, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Block{CID: c, Data: data}
+}
+
+func testLinks(_ cid.Cid, data []byte) ([]cid.Cid, error) {
+	var links []cid.Cid
+	for _, line := range strings.Split(string(data), "\n") {
+		c, err := cid.Decode(line)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, c)
+	}
+	return links, nil
+}
+
+// A block something still refers to can't be deleted until that is gone.
+func TestDeleteReferenced(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	if err := s.CountRefs(ctx, testLinks, linkCodec); err != nil {
+		t.Fatal(err)
+	}
+	child := mustBlock(t, "child")
+	parent := mustParent(t, child)
+	mustPut(t, s, child)
+	mustPut(t, s, parent)
+
+	if err := s.Delete(ctx, child.CID); !errors.Is(err, ErrReferenced) {
+		t.Fatalf("Delete of a referenced block: %v, want ErrReferenced", err)
+	}
+	if has, _ := s.Has(ctx, child.CID); !has {
+		t.Fatal("refused Delete removed the block")
+	}
+	if err := s.Delete(ctx, parent.CID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, child.CID); err != nil {
+		t.Errorf("Delete after the parent is gone: %v", err)
+	}
+}
+
+// Compressed blocks are served as they were put.
+func TestCompression(t *testing.T) {
+	ctx := WithCompression(context.Background(), CompressionZstd)
+	s := openStore(t)
+
+	text := mustBlock(t, strings.Repeat("compressible ", 1000))
+	random := make([]byte, 4096)
+	rand.NewChaCha8([32]byte{}).Read(random)
+	noise, err := NewRawBlock(random)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		block Block
+		codec string
+	}{
+		{"compressible", text, CompressionZstd},
+		{"small", mustBlock(t, "tiny"), ""},
+		{"incompressible", noise, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Put(ctx, tt.block.CID, tt.block.Data); err != nil {
+				t.Fatal(err)
+			}
+			codec, err := s.Compression(ctx, tt.block.CID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec != tt.codec {
+				t.Errorf("kept with %q, want %q", codec, tt.codec)
+			}
+			data, err := s.Get(ctx, tt.block.CID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, tt.block.Data) {
+				t.Error("Get returned different bytes")
+			}
+		})
+	}
+}
+
+// Reopening a store rebuilds its size accounting from disk.
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustPut(t, s, mustBlock(t, "one"))
+	mustPut(t, s, mustBlock(t, "three"))
+	want := s.Stats()
+
+	reopened, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	b := mustBlock(t, "four")
+	if err := reopened.Put(context.Background(), b.CID, b.Data); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put on a read-only store: %v, want ErrReadOnly", err)
+	}
+}
+
+// An overlay serves the lower store and keeps writes in the upper one,
+// without ever touching the lower store.
+func TestOverlay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := mustBlock(t, "old")
+	mustPut(t, base, old)
+
+	lower, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper := NewMemBlockstore()
+	o := NewOverlay(lower, upper)
+
+	fresh := mustBlock(t, "new")
+	mustPut(t, o, old)
+	mustPut(t, o, fresh)
+	if got := upper.Stats(); got.Blocks != 1 {
+		t.Errorf("upper holds %d blocks, want only the new one", got.Blocks)
+	}
+	if got := lower.Stats(); got.Blocks != 1 {
+		t.Errorf("lower holds %d blocks, want 1", got.Blocks)
+	}
+
+	for _, b := range []Block{old, fresh} {
+		data, err := o.Get(ctx, b.CID)
+		if err != nil || !bytes.Equal(data, b.Data) {
+			t.Errorf("Get %q = %q, %v", b.Data, data, err)
+		}
+	}
+
+	var listed []cid.Cid
+	o.List(ctx, func(c cid.Cid) error {
+		listed = append(listed, c)
+		return nil
+	})
+	if len(listed) != 2 {
+		t.Errorf("listed %v, want each block once", listed)
+	}
+
+	if err := o.Delete(ctx, old.CID); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Delete of a lower block: %v, want ErrReadOnly", err)
+	}
+	if err := o.Delete(ctx, fresh.CID); err != nil {
+		t.Errorf("Delete of an upper block: %v", err)
+	}
+}
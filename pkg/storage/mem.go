@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+// MemBlockstore keeps blocks in memory. Nothing survives a restart.
+type MemBlockstore struct {
+	mu     sync.RWMutex
+	blocks map[cid.Cid][]byte
+	stats  Stats
+}
+
+var _ Blockstore = (*MemBlockstore)(nil)
+
+func NewMemBlockstore() *MemBlockstore {
+	return &MemBlockstore{blocks: make(map[cid.Cid][]byte)}
+}
+
+func (s *MemBlockstore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := Verify(c, data); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.blocks[c]; ok {
+		return nil
+	}
+	s.blocks[c] = append([]byte(nil), data...)
+	s.stats.Blocks++
+	s.stats.Bytes += int64(len(data))
+	return nil
+}
+
+func (s *MemBlockstore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blocks[c]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *MemBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	_, err := s.Size(ctx, c)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *MemBlockstore) Size(ctx context.Context, c cid.Cid) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blocks[c]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return int64(len(data)), nil
+}
+
+func (s *MemBlockstore) Delete(ctx context.Context, c cid.Cid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.blocks[c]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.blocks, c)
+	s.stats.Blocks--
+	s.stats.Bytes -= int64(len(data))
+	return nil
+}
+
+func (s *MemBlockstore) List(ctx context.Context, fn func(cid.Cid) error) error {
+	s.mu.RLock()
+	keys := make([]cid.Cid, 0, len(s.blocks))
+	for c := range s.blocks {
+		keys = append(keys, c)
+	}
+	s.mu.RUnlock()
+
+	for _, c := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemBlockstore) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stats
+}
+
+func (s *MemBlockstore) Close() error {
+	return nil
+}
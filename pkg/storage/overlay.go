@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Overlay serves blocks from a read-only lower store and keeps everything
+// written to it in an upper store. Paired with a MemBlockstore it lets a
+// node run against a repo it must not modify.
+type Overlay struct {
+	lower Blockstore
+	upper Blockstore
+}
+
+var _ Blockstore = (*Overlay)(nil)
+
+func NewOverlay(lower, upper Blockstore) *Overlay {
+	return &Overlay{lower: lower, upper: upper}
+}
+
+func (o *Overlay) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	has, err := o.lower.Has(ctx, c)
+	if err != nil {
+		return err
+	}
+	if has {
+		return nil
+	}
+	return o.upper.Put(ctx, c, data)
+}
+
+func (o *Overlay) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	data, err := o.upper.Get(ctx, c)
+	if errors.Is(err, ErrNotFound) {
+		return o.lower.Get(ctx, c)
+	}
+	return data, err
+}
+
+func (o *Overlay) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	has, err := o.upper.Has(ctx, c)
+	if err != nil || has {
+		return has, err
+	}
+	return o.lower.Has(ctx, c)
+}
+
+func (o *Overlay) Size(ctx context.Context, c cid.Cid) (int64, error) {
+	size, err := o.upper.Size(ctx, c)
+	if errors.Is(err, ErrNotFound) {
+		return o.lower.Size(ctx, c)
+	}
+	return size, err
+}
+
+// Delete removes c from the upper store. Blocks in the lower store can't be
+// deleted.
+func (o *Overlay) Delete(ctx context.Context, c cid.Cid) error {
+	err := o.upper.Delete(ctx, c)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	has, err := o.lower.Has(ctx, c)
+	if err != nil {
+		return err
+	}
+	if has {
+		return ErrReadOnly
+	}
+	return ErrNotFound
+}
+
+// List lists the lower store, then the upper one. Put never copies a block
+// that the lower store has, so no block is listed twice.
+func (o *Overlay) List(ctx context.Context, fn func(cid.Cid) error) error {
+	if err := o.lower.List(ctx, fn); err != nil {
+		return err
+	}
+	return o.upper.List(ctx, fn)
+}
+
+func (o *Overlay) Stats() Stats {
+	lower, upper := o.lower.Stats(), o.upper.Stats()
+	return Stats{
+		Blocks: lower.Blocks + upper.Blocks,
+		Bytes:  lower.Bytes + upper.Bytes,
+	}
+}
+
+func (o *Overlay) Close() error {
+	return errors.Join(o.upper.Close(), o.lower.Close())
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ErrReferenced is returned when deleting a block that a stored file or
+// directory still refers to.
+var ErrReferenced = errors.New("storage: block is still referenced")
+
+// LinkFunc decodes the blocks that the block c, holding data, refers to.
+// Blocks that refer to nothing return no links.
+type LinkFunc func(c cid.Cid, data []byte) ([]cid.Cid, error)
+
+// RefStats describes how the stored blocks are shared by the files and
+// directories referring to them.
+type RefStats struct {
+	// Referenced counts the stored blocks that something refers to, Shared
+	// the ones referred to more than once.
+	Referenced int64
+	Shared     int64
+	// LinkedBytes is the size of the referenced blocks counted once per
+	// reference, what they would take up without deduplication.
+	// StoredBytes is what they do take up.
+	LinkedBytes int64
+	StoredBytes int64
+}
+
+// SavedBytes is the space deduplication saves.
+func (r RefStats) SavedBytes() int64 {
+	return r.LinkedBytes - r.StoredBytes
+}
+
+// CountRefs counts, for every block, how many stored blocks refer to it.
+// Only blocks of the given codecs refer to others, with links decoding
+// their references. From then on the counts follow every Put and Delete,
+// and Delete refuses blocks that are still referenced, so chunks shared by
+// several files stay until the last of them is deleted. Blocks links can't
+// decode are taken to refer to nothing. Call it before the store is used.
+func (s *FSBlockstore) CountRefs(ctx context.Context, links LinkFunc, codecs ...uint64) error {
+	linkCodecs := make(map[uint64]bool, len(codecs))
+	for _, codec := range codecs {
+		linkCodecs[codec] = true
+	}
+
+	refs := make(map[cid.Cid]int)
+	err := s.walk(ctx, func(c cid.Cid, _ fs.FileInfo) error {
+		if !linkCodecs[c.Type()] {
+			return nil
+		}
+		data, err := s.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		linked, err := links(c, data)
+		if err != nil {
+			return nil
+		}
+		for _, link := range linked {
+			refs[link]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.links = links
+	s.linkCodecs = linkCodecs
+	s.refs = refs
+	s.mu.Unlock()
+	return nil
+}
+
+// Refs returns how many stored blocks refer to c. It is zero until
+// CountRefs is called.
+func (s *FSBlockstore) Refs(c cid.Cid) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refs[c]
+}
+
+// RefStats sums up the reference counts. It is empty until CountRefs is
+// called.
+func (s *FSBlockstore) RefStats(ctx context.Context) (RefStats, error) {
+	s.mu.Lock()
+	refs := make(map[cid.Cid]int, len(s.refs))
+	for c, n := range s.refs {
+		refs[c] = n
+	}
+	s.mu.Unlock()
+
+	var stats RefStats
+	for c, n := range refs {
+		size, err := s.Size(ctx, c)
+		if errors.Is(err, ErrNotFound) {
+			// Referenced but not fetched yet
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Referenced++
+		if n > 1 {
+			stats.Shared++
+		}
+		stats.LinkedBytes += int64(n) * size
+		stats.StoredBytes += size
+	}
+	return stats, nil
+}
+
+// linksOf returns the blocks c refers to, or nothing when references
+// aren't counted or c can't be read or decoded. Nil data is read from the
+// store.
+func (s *FSBlockstore) linksOf(ctx context.Context, c cid.Cid, data []byte) []cid.Cid {
+	s.mu.Lock()
+	links, counted := s.links, s.linkCodecs[c.Type()]
+	s.mu.Unlock()
+	if !counted {
+		return nil
+	}
+	if data == nil {
+		var err error
+		if data, err = s.Get(ctx, c); err != nil {
+			return nil
+		}
+	}
+	linked, err := links(c, data)
+	if err != nil {
+		return nil
+	}
+	return linked
+}
+
+// addRefs counts the references of a block just stored. Callers must
+// hold s.mu.
+func (s *FSBlockstore) addRefs(linked []cid.Cid) {
+	for _, link := range linked {
+		s.refs[link]++
+	}
+}
+
+// dropRefs uncounts the references of a deleted block. Callers must hold
+// s.mu.
+func (s *FSBlockstore) dropRefs(linked []cid.Cid) {
+	for _, link := range linked {
+		if s.refs[link] <= 1 {
+			delete(s.refs, link)
+		} else {
+			s.refs[link]--
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+// Storing and deleting parents moves the counts of their children, and a
+// store opened again counts the same.
+func TestRefs(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CountRefs(ctx, testLinks, linkCodec); err != nil {
+		t.Fatal(err)
+	}
+
+	shared := mustBlock(t, "shared")
+	own := mustBlock(t, "own")
+	a := mustParent(t, shared, own)
+	b := mustParent(t, shared)
+	for _, blk := range []Block{shared, own, a, b} {
+		mustPut(t, s, blk)
+	}
+
+	counts := func(s *FSBlockstore) [2]int {
+		return [2]int{s.Refs(shared.CID), s.Refs(own.CID)}
+	}
+	if got, want := counts(s), [2]int{2, 1}; got != want {
+		t.Errorf("refs = %v, want %v", got, want)
+	}
+	// A parent stored again isn't counted again.
+	mustPut(t, s, b)
+	if got, want := counts(s), [2]int{2, 1}; got != want {
+		t.Errorf("refs after putting a parent again = %v, want %v", got, want)
+	}
+
+	stats, err := s.RefStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(shared.Data))
+	if stats.Referenced != 2 || stats.Shared != 1 || stats.SavedBytes() != size {
+		t.Errorf("RefStats = %+v, want 2 referenced, 1 shared, %d bytes saved", stats, size)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.CountRefs(ctx, testLinks, linkCodec); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := counts(reopened), counts(s); got != want {
+		t.Errorf("refs after reopening = %v, want %v", got, want)
+	}
+
+	if err := s.Delete(ctx, a.CID); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := counts(s), [2]int{1, 0}; got != want {
+		t.Errorf("refs after deleting a parent = %v, want %v", got, want)
+	}
+	if err := s.Delete(ctx, b.CID); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := counts(s), [2]int{0, 0}; got != want {
+		t.Errorf("refs after deleting both parents = %v, want %v", got, want)
+	}
+}
@@ -1 +1,79 @@
 package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var (
+	ErrNotFound     = errors.New("storage: block not found")
+	ErrHashMismatch = errors.New("storage: data does not match cid")
+	ErrReadOnly     = errors.New("storage: store is read-only")
+	// ErrRecent is returned by DeleteOlder for a block written after the
+	// cutoff.
+	ErrRecent = errors.New("storage: block was written recently")
+)
+
+// MaxBlockSize is the largest block peers exchange. Larger blocks can be
+// stored but never fetched.
+const MaxBlockSize = 64 << 20
+
+// Blockstore is a content addressed store of immutable blocks.
+type Blockstore interface {
+	Put(ctx context.Context, c cid.Cid, data []byte) error
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	// Size returns the stored size of a block in bytes.
+	Size(ctx context.Context, c cid.Cid) (int64, error)
+	Delete(ctx context.Context, c cid.Cid) error
+	// List calls fn for every stored block. Returning an error from fn stops
+	// the iteration and is returned from List.
+	List(ctx context.Context, fn func(cid.Cid) error) error
+	Stats() Stats
+	Close() error
+}
+
+type Stats struct {
+	Blocks int64
+	Bytes  int64
+}
+
+// Block is a chunk of data together with its content address.
+type Block struct {
+	CID  cid.Cid
+	Data []byte
+}
+
+// NewRawBlock hashes data as a raw leaf block.
+func NewRawBlock(data []byte) (Block, error) {
+	c, err := Sum(cid.Raw, data)
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{CID: c, Data: data}, nil
+}
+
+// Sum computes the CIDv1 of data with the given codec using sha2-256.
+func Sum(codec uint64, data []byte) (cid.Cid, error) {
+	return cid.Prefix{
+		Version:  1,
+		Codec:    codec,
+		MhType:   mh.SHA2_256,
+		MhLength: -1,
+	}.Sum(data)
+}
+
+// Verify reports whether data hashes to c.
+func Verify(c cid.Cid, data []byte) error {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(c) {
+		return ErrHashMismatch
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package syncpair
+
+// Side is what one of the base snapshot, the local directory and the
+// remote snapshot has under a name.
+type Side struct {
+	Present bool
+	Dir     bool
+	// CID is the hash of the file or directory. It is empty for a local
+	// directory, whose hash isn't known before it is stored, and for a
+	// local file known to differ from the others without hashing it.
+	CID string
+}
+
+// Action is what to do with a name changed on either side.
+type Action int
+
+const (
+	// Keep leaves the name as the base has it, which the local directory
+	// matches.
+	Keep Action = iota
+	// TakeLocal stores what the local directory has, or drops the name
+	// when it is gone locally.
+	TakeLocal
+	// TakeRemote writes what the remote has into the local directory, or
+	// removes it there when it is gone remotely.
+	TakeRemote
+	// Descend merges two directories entry by entry.
+	Descend
+	// DropUnlessChanged removes the local directory, which is gone
+	// remotely, unless it changed locally; then it is kept.
+	DropUnlessChanged
+	// KeepBoth is a conflict: the remote version is kept under the name
+	// and the local one under a conflict name.
+	KeepBoth
+)
+
+func (a Action) String() string {
+	switch a {
+	case Keep:
+		return "keep"
+	case TakeLocal:
+		return "local"
+	case TakeRemote:
+		return "remote"
+	case Descend:
+		return "descend"
+	case DropUnlessChanged:
+		return "drop-unless-changed"
+	case KeepBoth:
+		return "keep-both"
+	}
+	return "unknown"
+}
+
+// same reports whether two sides hold the same thing. Sides without a
+// hash are never the same as anything.
+func same(a, b Side) bool {
+	if !a.Present || !b.Present {
+		return a.Present == b.Present
+	}
+	return a.Dir == b.Dir && a.CID != "" && a.CID == b.CID
+}
+
+// Decide picks what to do with a name from what the last agreed snapshot,
+// the local directory and the remote snapshot have under it. A deletion
+// gives way to a change on the other side, and two different changes
+// conflict.
+func Decide(base, local, remote Side) Action {
+	switch {
+	case local.Dir && remote.Dir:
+		if same(base, remote) {
+			return TakeLocal
+		}
+		return Descend
+	case local.Dir && !remote.Present:
+		if base.Dir {
+			return DropUnlessChanged
+		}
+		return TakeLocal
+	case local.Dir:
+		if same(base, remote) {
+			return TakeLocal
+		}
+		return KeepBoth
+	}
+
+	localChanged := !same(base, local)
+	remoteChanged := !same(base, remote)
+	switch {
+	case !localChanged && !remoteChanged:
+		return Keep
+	case !remoteChanged:
+		return TakeLocal
+	case !localChanged:
+		return TakeRemote
+	case same(local, remote):
+		return TakeLocal
+	case !local.Present:
+		return TakeRemote
+	case !remote.Present:
+		return TakeLocal
+	}
+	return KeepBoth
+}
@@ -0,0 +1,232 @@
+// Package syncpair keeps the state of the directories "dfs sync" keeps in
+// step with a name, and decides how changes on both sides combine.
+//
+// A pair remembers the snapshot the directory and the name last agreed
+// on. When the name has moved on since, because another node synced the
+// same tree and published it, both sides are compared against that
+// snapshot: what changed on one side only is taken from it, and a file
+// changed differently on both is kept twice, the local version under a
+// conflict name, until someone resolves it.
+package syncpair
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Noah-Wilderom/dfs/pkg/fsutil"
+)
+
+// ErrNoConflict is returned when resolving a path without a conflict.
+var ErrNoConflict = errors.New("syncpair: no conflict")
+
+// Pair is a directory kept in step with a name.
+type Pair struct {
+	// Dir is the absolute path of the local directory.
+	Dir string `json:"dir"`
+	// Remote is the name the directory is synced with, empty when it is
+	// only snapshotted.
+	Remote string `json:"remote,omitempty"`
+	// Key is the key the new snapshots are published under, empty when
+	// they aren't.
+	Key string `json:"key,omitempty"`
+	// Base is the snapshot the directory and Remote last agreed on.
+	Base string `json:"base,omitempty"`
+	// Conflicts are the unresolved conflicts, oldest first.
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+
+	// Include limits the sync to these slash-separated paths below Dir and
+	// what is under them, when not empty. Exclude are patterns written
+	// like the lines of a .dfsignore file, left out as well.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Policies are the policies of directories below Dir, by their
+	// slash-separated path. Those below them follow them.
+	Policies map[string]Policy `json:"policies,omitempty"`
+}
+
+// Policy is what a directory's files are kept as on disk.
+type Policy string
+
+const (
+	// Local files are kept on disk and synced both ways. It is the
+	// policy of directories without one.
+	Local Policy = "local"
+	// CloudFirst files are synced both ways while they are on disk, but
+	// those only stored remotely aren't fetched, and one missing on disk
+	// isn't taken as removed.
+	CloudFirst Policy = "cloud-first"
+	// OnlineOnly files are only stored remotely: nothing under the
+	// directory is read or written on disk.
+	OnlineOnly Policy = "online-only"
+)
+
+// ParsePolicy parses the name of a policy.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case Local, CloudFirst, OnlineOnly:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q, want %s, %s or %s", s, Local, CloudFirst, OnlineOnly)
+}
+
+// PolicyOf returns the policy of the slash-separated path rel below Dir,
+// that of the closest directory with one.
+func (p Pair) PolicyOf(rel string) Policy {
+	for rel != "." && rel != "/" && rel != "" {
+		if policy, ok := p.Policies[rel]; ok {
+			return policy
+		}
+		rel = path.Dir(rel)
+	}
+	return Local
+}
+
+// Conflict is a file changed both locally and remotely. The remote version
+// was kept under Path and the local one moved to Copy.
+type Conflict struct {
+	// Path and Copy are slash-separated, relative to the pair's Dir.
+	Path string `json:"path"`
+	Copy string `json:"copy"`
+	// Remote is the hash of the remote version.
+	Remote string    `json:"remote"`
+	Time   time.Time `json:"time"`
+}
+
+// ConflictName is the name the local version of name is kept under when it
+// conflicts with peer's: name.conflict-<peer>-<time>.
+func ConflictName(name, peer string, t time.Time) string {
+	return fmt.Sprintf("%s.conflict-%s-%s", name, peer, t.UTC().Format("20060102T150405Z"))
+}
+
+// IsConflictName reports whether name is one ConflictName made.
+func IsConflictName(name string) bool {
+	return strings.Contains(name, ".conflict-")
+}
+
+// Store keeps the pairs of a node in a JSON file like the pin set.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	pairs map[string]Pair
+}
+
+// Open loads the pairs at path. A missing file is an empty store; an empty
+// path keeps them in memory only.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, pairs: make(map[string]Pair)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Pair
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, p := range list {
+		s.pairs[p.Dir] = p
+	}
+	return s, nil
+}
+
+// Get returns the pair of the directory at dir, an absolute path.
+func (s *Store) Get(dir string) (Pair, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pairs[filepath.Clean(dir)]
+	return p, ok
+}
+
+// Put keeps p, replacing the pair of the same directory.
+func (s *Store) Put(p Pair) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.Dir = filepath.Clean(p.Dir)
+	prev, had := s.pairs[p.Dir]
+	s.pairs[p.Dir] = p
+	if err := s.save(); err != nil {
+		if had {
+			s.pairs[p.Dir] = prev
+		} else {
+			delete(s.pairs, p.Dir)
+		}
+		return err
+	}
+	return nil
+}
+
+// Resolve forgets the conflict at path, relative to dir, and returns it.
+func (s *Store) Resolve(dir, path string) (Conflict, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir = filepath.Clean(dir)
+	p, ok := s.pairs[dir]
+	if !ok {
+		return Conflict{}, fmt.Errorf("%w: %s is not synced", ErrNoConflict, dir)
+	}
+	for i, c := range p.Conflicts {
+		if c.Path != path && c.Copy != path {
+			continue
+		}
+		prev := p
+		p.Conflicts = append(p.Conflicts[:i:i], p.Conflicts[i+1:]...)
+		s.pairs[dir] = p
+		if err := s.save(); err != nil {
+			s.pairs[dir] = prev
+			return Conflict{}, err
+		}
+		return c, nil
+	}
+	return Conflict{}, fmt.Errorf("%w at %s", ErrNoConflict, path)
+}
+
+// List returns the pairs by directory.
+func (s *Store) List() []Pair {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+func (s *Store) sorted() []Pair {
+	list := make([]Pair, 0, len(s.pairs))
+	for _, p := range s.pairs {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Dir < list[j].Dir })
+	return list
+}
+
+// save writes the store atomically. Callers hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return fsutil.AtomicWrite(s.path, data, 0600)
+}
@@ -0,0 +1,123 @@
+package syncpair
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func file(c string) Side { return Side{Present: true, CID: c} }
+func dir(c string) Side  { return Side{Present: true, Dir: true, CID: c} }
+
+var none Side
+
+func TestDecide(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		base, local, remote Side
+		want                Action
+	}{
+		{"unchanged", file("a"), file("a"), file("a"), Keep},
+		{"changed locally", file("a"), file("b"), file("a"), TakeLocal},
+		{"changed remotely", file("a"), file("a"), file("b"), TakeRemote},
+		{"same change", file("a"), file("b"), file("b"), TakeLocal},
+		{"different changes", file("a"), file("b"), file("c"), KeepBoth},
+		{"unhashed local change", file("a"), file(""), file("c"), KeepBoth},
+		{"added on both", none, file("b"), file("c"), KeepBoth},
+		{"added remotely", none, none, file("b"), TakeRemote},
+		{"deleted locally", file("a"), none, file("a"), TakeLocal},
+		{"deleted remotely", file("a"), file("a"), none, TakeRemote},
+		{"deleted locally, changed remotely", file("a"), none, file("b"), TakeRemote},
+		{"changed locally, deleted remotely", file("a"), file("b"), none, TakeLocal},
+		{"deleted on both", file("a"), none, none, TakeLocal},
+
+		{"directory changed remotely", dir("d"), dir(""), dir("e"), Descend},
+		{"directory unchanged remotely", dir("d"), dir(""), dir("d"), TakeLocal},
+		{"directories added on both", none, dir(""), dir("e"), Descend},
+		{"directory deleted remotely", dir("d"), dir(""), none, DropUnlessChanged},
+		{"directory added locally", none, dir(""), none, TakeLocal},
+		{"directory deleted locally", dir("d"), none, dir("d"), TakeLocal},
+		{"directory deleted locally, changed remotely", dir("d"), none, dir("e"), TakeRemote},
+		{"file replaced by a directory remotely", file("a"), file("a"), dir("e"), TakeRemote},
+		{"file changed, replaced by a directory remotely", file("a"), file("b"), dir("e"), KeepBoth},
+		{"file replaced by a directory locally", file("a"), dir(""), file("a"), TakeLocal},
+		{"file replaced by a directory locally, changed remotely", file("a"), dir(""), file("b"), KeepBoth},
+		{"directory replaced by a file on both", dir("d"), file("a"), file("b"), KeepBoth},
+		{"directory replaced by a file remotely, deleted locally", dir("d"), none, file("b"), TakeRemote},
+	} {
+		if got := Decide(tc.base, tc.local, tc.remote); got != tc.want {
+			t.Errorf("%s: Decide = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConflictName(t *testing.T) {
+	at := time.Date(2026, 10, 16, 15, 4, 5, 0, time.FixedZone("CEST", 2*60*60))
+	name := ConflictName("notes.txt", "Xy12ab", at)
+	if name != "notes.txt.conflict-Xy12ab-20261016T130405Z" {
+		t.Errorf("ConflictName = %s", name)
+	}
+	if !IsConflictName(name) || IsConflictName("notes.txt") {
+		t.Error("IsConflictName doesn't tell conflict copies apart")
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Pair{
+		Dir:    "/home/u/notes/",
+		Remote: "k51example",
+		Base:   "bafybase",
+		Conflicts: []Conflict{
+			{Path: "a.txt", Copy: "a.txt.conflict-x-1", Remote: "bafya"},
+			{Path: "sub/b.txt", Copy: "sub/b.txt.conflict-x-1", Remote: "bafyb"},
+		},
+	}
+	if err := s.Put(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Resolve("/home/u/notes", "sub/b.txt.conflict-x-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Resolve("/home/u/notes", "sub/b.txt"); !errors.Is(err, ErrNoConflict) {
+		t.Errorf("resolving twice = %v, want %v", err, ErrNoConflict)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := reopened.Get("/home/u/notes")
+	if !ok {
+		t.Fatal("pair lost on reopening")
+	}
+	if got.Base != "bafybase" || len(got.Conflicts) != 1 || got.Conflicts[0].Path != "a.txt" {
+		t.Errorf("reopened pair = %+v", got)
+	}
+}
+
+func TestPolicyOf(t *testing.T) {
+	p := Pair{Policies: map[string]Policy{
+		"media":            OnlineOnly,
+		"media/albums":     CloudFirst,
+		"media/albums/now": Local,
+	}}
+	for rel, want := range map[string]Policy{
+		"notes.txt":               Local,
+		"media":                   OnlineOnly,
+		"media/a.mp4":             OnlineOnly,
+		"media/albums/2019/x.jpg": CloudFirst,
+		"media/albums/now/y.jpg":  Local,
+		"mediaextra/z":            Local,
+	} {
+		if got := p.PolicyOf(rel); got != want {
+			t.Errorf("PolicyOf(%s) = %s, want %s", rel, got, want)
+		}
+	}
+}